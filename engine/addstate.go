@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the states a task is added in
+const (
+	AddStarted = "started"
+	AddPaused  = "paused"
+)
+
+// the sources of the adds, with their own DefaultAddState
+const (
+	AddSourceWeb   = "web"
+	AddSourceWatch = "watch"
+	AddSourceMail  = "mail"
+)
+
+// AddOptions are how a task is added
+type AddOptions struct {
+	// AddStarted or AddPaused, empty for the DefaultAddState of the Source
+	State  string
+	Source string
+	// scheduled to start at, stopped until then
+	StartAt time.Time
+	// added even if it looks like a duplicate
+	Force bool
+	// the group the task is put in
+	Group string
+	// the infohash of the task to complete before this one is added
+	After string
+	// the user the task belongs to, for the DiskQuotas
+	User string
+	// all the files downloaded, the ExcludeFiles ignored
+	KeepAllFiles bool
+}
+
+// addStates keeps the add states of the tasks queued by MaxConcurrentTask,
+// so they're added as asked when their turn comes
+type addStates struct {
+	sync.Mutex
+	// infohash -> state
+	queued map[string]string
+}
+
+func addOptions(opts []AddOptions) AddOptions {
+	if len(opts) > 0 {
+		return opts[0]
+	}
+	return AddOptions{}
+}
+
+// parseAddStates parses the DefaultAddState, either a state for all the adds,
+// or the states by source, comma separated, eg. "watch=paused, web=started".
+// The state for all the adds is keyed by "".
+func parseAddStates(s string) (map[string]string, error) {
+	states := make(map[string]string)
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		source, state := "", p
+		if kv := strings.SplitN(p, "=", 2); len(kv) == 2 {
+			source, state = strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+			switch source {
+			case AddSourceWeb, AddSourceWatch, AddSourceMail:
+			default:
+				return nil, fmt.Errorf("unknown source %q, expecting %s, %s or %s", source, AddSourceWeb, AddSourceWatch, AddSourceMail)
+			}
+		}
+		switch state {
+		case AddStarted, AddPaused:
+		default:
+			return nil, fmt.Errorf("unknown state %q, expecting %s or %s", state, AddStarted, AddPaused)
+		}
+		states[source] = state
+	}
+	return states, nil
+}
+
+// defaultAddState is the DefaultAddState of the source, empty if the
+// AutoStart decides
+func (c *Config) defaultAddState(source string) string {
+	states, err := parseAddStates(c.DefaultAddState)
+	if err != nil {
+		return ""
+	}
+	if st, ok := states[source]; ok && source != "" {
+		return st
+	}
+	return states[""]
+}
+
+// addState is the state the task ih is added in: the one asked, the one
+// asked when it was queued, or else the default of the source
+func (e *Engine) addState(ih string, opt AddOptions) string {
+	e.adds.Lock()
+	defer e.adds.Unlock()
+	if st, ok := e.adds.queued[ih]; ok {
+		delete(e.adds.queued, ih)
+		if opt.State == "" {
+			return st
+		}
+	}
+	if opt.State != "" || opt.Source == "" {
+		return opt.State
+	}
+	return e.config.defaultAddState(opt.Source)
+}
+
+// queueAddState keeps the add state of the task ih queued
+func (e *Engine) queueAddState(ih string, opt AddOptions) {
+	st := opt.State
+	if st == "" && opt.Source != "" {
+		st = e.config.defaultAddState(opt.Source)
+	}
+	if st == "" {
+		return
+	}
+	e.adds.Lock()
+	defer e.adds.Unlock()
+	if e.adds.queued == nil {
+		e.adds.queued = make(map[string]string)
+	}
+	e.adds.queued[ih] = st
+}
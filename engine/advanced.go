@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+	"github.com/mitchellh/mapstructure"
+)
+
+var ErrUnknownAdvanced = errors.New("Unknown advanced setting")
+
+// advancedKnobs are the torrent client settings accepted in the Advanced
+// config map, the names are case insensitive as viper lowercases them
+var advancedKnobs = map[string]func(tc *torrent.ClientConfig) interface{}{
+	"HandshakesTimeout":          func(tc *torrent.ClientConfig) interface{} { return &tc.HandshakesTimeout },
+	"KeepAliveTimeout":           func(tc *torrent.ClientConfig) interface{} { return &tc.KeepAliveTimeout },
+	"NominalDialTimeout":         func(tc *torrent.ClientConfig) interface{} { return &tc.NominalDialTimeout },
+	"MinDialTimeout":             func(tc *torrent.ClientConfig) interface{} { return &tc.MinDialTimeout },
+	"EstablishedConnsPerTorrent": func(tc *torrent.ClientConfig) interface{} { return &tc.EstablishedConnsPerTorrent },
+	"HalfOpenConnsPerTorrent":    func(tc *torrent.ClientConfig) interface{} { return &tc.HalfOpenConnsPerTorrent },
+	"TotalHalfOpenConns":         func(tc *torrent.ClientConfig) interface{} { return &tc.TotalHalfOpenConns },
+	"TorrentPeersHighWater":      func(tc *torrent.ClientConfig) interface{} { return &tc.TorrentPeersHighWater },
+	"TorrentPeersLowWater":       func(tc *torrent.ClientConfig) interface{} { return &tc.TorrentPeersLowWater },
+	"MaxUnverifiedBytes":         func(tc *torrent.ClientConfig) interface{} { return &tc.MaxUnverifiedBytes },
+	"DisableAcceptRateLimiting":  func(tc *torrent.ClientConfig) interface{} { return &tc.DisableAcceptRateLimiting },
+	"DropDuplicatePeerIds":       func(tc *torrent.ClientConfig) interface{} { return &tc.DropDuplicatePeerIds },
+	"DropMutuallyCompletePeers":  func(tc *torrent.ClientConfig) interface{} { return &tc.DropMutuallyCompletePeers },
+	"AcceptPeerConnections":      func(tc *torrent.ClientConfig) interface{} { return &tc.AcceptPeerConnections },
+	"PeriodicallyAnnounceTorrentsToDht": func(tc *torrent.ClientConfig) interface{} {
+		return &tc.PeriodicallyAnnounceTorrentsToDht
+	},
+	"HTTPUserAgent": func(tc *torrent.ClientConfig) interface{} { return &tc.HTTPUserAgent },
+}
+
+func advancedKnob(name string) (func(tc *torrent.ClientConfig) interface{}, bool) {
+	for k, f := range advancedKnobs {
+		if strings.EqualFold(k, name) {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// applyAdvanced sets the Advanced settings on the client config, the
+// durations are like "30s", and the values are converted like the config
+// file does
+func applyAdvanced(tc *torrent.ClientConfig, adv map[string]interface{}) error {
+	names := make([]string, 0, len(adv))
+	for name := range adv {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field, ok := advancedKnob(name)
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownAdvanced, name)
+		}
+		dec, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+			DecodeHook:       mapstructure.StringToTimeDurationHookFunc(),
+			WeaklyTypedInput: true,
+			Result:           field(tc),
+		})
+		if err != nil {
+			return err
+		}
+		if err := dec.Decode(adv[name]); err != nil {
+			return fmt.Errorf("%w: %s (%v)", ErrInvalidValue, name, adv[name])
+		}
+	}
+	return nil
+}
+
+// checkAdvanced checks the Advanced settings without a client
+func checkAdvanced(adv map[string]interface{}) error {
+	return applyAdvanced(torrent.NewDefaultClientConfig(), adv)
+}
@@ -0,0 +1,40 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+func Test_applyAdvanced(t *testing.T) {
+	tests := []struct {
+		name    string
+		adv     map[string]interface{}
+		check   func(tc *torrent.ClientConfig) bool
+		wantErr error
+	}{
+		{"none", nil, func(tc *torrent.ClientConfig) bool { return tc.EstablishedConnsPerTorrent == 50 }, nil},
+		{"duration", map[string]interface{}{"handshakestimeout": "10s"},
+			func(tc *torrent.ClientConfig) bool { return tc.HandshakesTimeout == 10*time.Second }, nil},
+		{"int as string", map[string]interface{}{"EstablishedConnsPerTorrent": "80"},
+			func(tc *torrent.ClientConfig) bool { return tc.EstablishedConnsPerTorrent == 80 }, nil},
+		{"bool", map[string]interface{}{"dropduplicatepeerids": true},
+			func(tc *torrent.ClientConfig) bool { return tc.DropDuplicatePeerIds }, nil},
+		{"unknown", map[string]interface{}{"ListenPort": 1}, nil, ErrUnknownAdvanced},
+		{"invalid", map[string]interface{}{"KeepAliveTimeout": "soon"}, nil, ErrInvalidValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := torrent.NewDefaultClientConfig()
+			err := applyAdvanced(tc, tt.adv)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("applyAdvanced() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.check != nil && !tt.check(tc) {
+				t.Errorf("applyAdvanced() not applied %v", tt.adv)
+			}
+		})
+	}
+}
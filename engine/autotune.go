@@ -0,0 +1,143 @@
+package engine
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	autoTuneTick = time.Minute
+	// the connections of a seeding task, anacrolix defaults to 50
+	minUploadSlots     = 4
+	maxUploadSlots     = 100
+	defaultUploadSlots = 50
+	// the measured peak decays, the link may have become slower
+	peakDecay = 0.98
+)
+
+// AutoTuneStatus is the upload capacity measured by the auto-tuner, and
+// the connections given to each seeding task
+type AutoTuneStatus struct {
+	Enabled bool
+	// bytes per second
+	UploadRate float64
+	Capacity   float64
+	Slots      int
+}
+
+type autoTuneState struct {
+	sync.Mutex
+	status    AutoTuneStatus
+	lastBytes int64
+	lastAt    time.Time
+}
+
+// AutoTune reports the upload auto-tuner
+func (e *Engine) AutoTune() AutoTuneStatus {
+	e.autoTune.Lock()
+	defer e.autoTune.Unlock()
+	return e.autoTune.status
+}
+
+// tuneSlots moves the connections of the seeding tasks by the use of the
+// upload capacity: a saturated link is shared by fewer peers, which get
+// the pieces faster, while an idle one looks for more leechers
+func tuneSlots(slots int, uploadRate, capacity float64) int {
+	if capacity <= 0 {
+		return slots
+	}
+	switch use := uploadRate / capacity; {
+	case use > 0.9:
+		slots = int(float64(slots) * 0.8)
+	case use < 0.5:
+		slots = int(math.Ceil(float64(slots) * 1.25))
+	}
+	if slots < minUploadSlots {
+		slots = minUploadSlots
+	}
+	if slots > maxUploadSlots {
+		slots = maxUploadSlots
+	}
+	return slots
+}
+
+// autoTuneRoutine measures the upload throughput and tunes the seeding
+// tasks' connections if UploadAutoTune
+func (e *Engine) autoTuneRoutine() {
+	tk := time.NewTicker(autoTuneTick)
+	defer tk.Stop()
+	for now := range tk.C {
+		e.RLock()
+		enabled := e.config.UploadAutoTune && e.client != nil
+		limit := e.uploadLimit()
+		e.RUnlock()
+		if !enabled {
+			e.autoTune.Lock()
+			restore := e.autoTune.status.Enabled
+			e.autoTune.status = AutoTuneStatus{}
+			e.autoTune.lastAt = time.Time{}
+			e.autoTune.Unlock()
+			if restore {
+				e.applySeedingSlots()
+			}
+			continue
+		}
+		e.autoTuneTick(now, limit)
+	}
+}
+
+func (e *Engine) autoTuneTick(now time.Time, limit rate.Limit) {
+	st := e.ConnStat()
+	written := st.BytesWrittenData.Int64()
+
+	e.autoTune.Lock()
+	s := &e.autoTune.status
+	if !s.Enabled {
+		*s = AutoTuneStatus{Enabled: true, Slots: defaultUploadSlots}
+	}
+	first := e.autoTune.lastAt.IsZero() || written < e.autoTune.lastBytes
+	if !first {
+		s.UploadRate = float64(written-e.autoTune.lastBytes) / now.Sub(e.autoTune.lastAt).Seconds()
+		if limit != rate.Inf {
+			s.Capacity = float64(limit)
+		} else {
+			s.Capacity = math.Max(s.Capacity*peakDecay, s.UploadRate)
+		}
+		s.Slots = tuneSlots(s.Slots, s.UploadRate, s.Capacity)
+	}
+	e.autoTune.lastBytes = written
+	e.autoTune.lastAt = now
+	e.autoTune.Unlock()
+	if !first {
+		e.applySeedingSlots()
+	}
+}
+
+// seedingSlots is the connections of a seeding task, tuned by the
+// auto-tuner and capped by the QoS
+func (e *Engine) seedingSlots() int {
+	slots := defaultUploadSlots
+	if at := e.AutoTune(); at.Enabled {
+		slots = at.Slots
+	}
+	if q := e.QoS(); q.Slots > 0 && q.Slots < slots {
+		slots = q.Slots
+	}
+	return slots
+}
+
+func (e *Engine) applySeedingSlots() {
+	slots := e.seedingSlots()
+	e.RLock()
+	defer e.RUnlock()
+	for _, t := range e.ts {
+		t.Lock()
+		if t.t != nil && t.Done && t.Started {
+			t.t.SetMaxEstablishedConns(slots)
+		}
+		t.Unlock()
+	}
+}
@@ -0,0 +1,27 @@
+package engine
+
+import "testing"
+
+func Test_tuneSlots(t *testing.T) {
+	tests := []struct {
+		name     string
+		slots    int
+		rate     float64
+		capacity float64
+		want     int
+	}{
+		{"unmeasured", 50, 0, 0, 50},
+		{"saturated", 50, 95, 100, 40},
+		{"in range", 50, 70, 100, 50},
+		{"idle", 50, 10, 100, 63},
+		{"min", minUploadSlots, 100, 100, minUploadSlots},
+		{"max", 90, 0, 100, maxUploadSlots},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tuneSlots(tt.slots, tt.rate, tt.capacity); got != tt.want {
+				t.Errorf("tuneSlots() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
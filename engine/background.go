@@ -0,0 +1,225 @@
+package engine
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// how often the disabled tasks are checked again, and the most a task
+	// enabled waits for its first run
+	backgroundIdle = time.Minute
+	// the delays are spread by up to this fraction, so the instances sharing
+	// a feed or a tracker list don't hit it at once
+	backgroundJitter = 0.1
+	// the delay doubles after each failure, up to
+	maxBackgroundBackoff = 6 * time.Hour
+)
+
+var ErrUnknownBackgroundTask = errors.New("Unknown background task")
+
+// BackgroundTask is the state of a periodic task of the engine or the
+// server (RSS poll, tracker list refresh, volume check...), reported by
+// GET /api/tasks
+type BackgroundTask struct {
+	Name string
+	// 0 when disabled
+	Interval     time.Duration
+	Running      bool
+	LastRun      time.Time
+	LastDuration time.Duration
+	NextRun      time.Time
+	// not run on schedule till resumed or restarted, the triggers still run
+	Paused bool
+	// the failures in a row, delaying the next run
+	Failures  int
+	LastError string `json:",omitempty"`
+}
+
+type backgroundTask struct {
+	BackgroundTask
+	// read each round, the config may change
+	interval func() time.Duration
+	run      func() error
+	// run as soon as not running, whatever the schedule
+	triggered bool
+}
+
+type backgroundState struct {
+	sync.Mutex
+	tasks []*backgroundTask
+	wake  chan struct{}
+}
+
+// AddBackgroundTask runs the task every interval, the interval 0 disables
+// it. The first run is within the backgroundIdle once enabled.
+func (e *Engine) AddBackgroundTask(name string, interval func() time.Duration, run func() error) {
+	e.background.Lock()
+	defer e.background.Unlock()
+	e.background.tasks = append(e.background.tasks, &backgroundTask{
+		BackgroundTask: BackgroundTask{Name: name},
+		interval:       interval,
+		run:            run,
+	})
+	e.wakeBackground()
+}
+
+// TriggerBackgroundTask runs the task now, or right after the run in
+// progress, even if disabled or paused
+func (e *Engine) TriggerBackgroundTask(name string) error {
+	e.background.Lock()
+	defer e.background.Unlock()
+	t := e.backgroundTask(name)
+	if t == nil {
+		return ErrUnknownBackgroundTask
+	}
+	t.triggered = true
+	e.wakeBackground()
+	return nil
+}
+
+// PauseBackgroundTask stops or resumes the scheduled runs of the task
+func (e *Engine) PauseBackgroundTask(name string, paused bool) error {
+	e.background.Lock()
+	defer e.background.Unlock()
+	t := e.backgroundTask(name)
+	if t == nil {
+		return ErrUnknownBackgroundTask
+	}
+	if t.Paused != paused {
+		t.Paused = paused
+		log.Printf("[Background] %s paused: %v", name, paused)
+	}
+	e.wakeBackground()
+	return nil
+}
+
+func (e *Engine) backgroundTask(name string) *backgroundTask {
+	for _, t := range e.background.tasks {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// BackgroundTasks reports the background tasks by name
+func (e *Engine) BackgroundTasks() []BackgroundTask {
+	e.background.Lock()
+	defer e.background.Unlock()
+	res := make([]BackgroundTask, 0, len(e.background.tasks))
+	for _, t := range e.background.tasks {
+		res = append(res, t.BackgroundTask)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// wakeBackground has the routine look at the tasks again, the lock held
+func (e *Engine) wakeBackground() {
+	if e.background.wake == nil {
+		e.background.wake = make(chan struct{}, 1)
+	}
+	select {
+	case e.background.wake <- struct{}{}:
+	default:
+	}
+}
+
+// backgroundDelay is the delay of the next run after the failures in a row
+func backgroundDelay(interval time.Duration, failures int) time.Duration {
+	d := interval
+	for i := 0; i < failures && d < maxBackgroundBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackgroundBackoff && interval < maxBackgroundBackoff {
+		d = maxBackgroundBackoff
+	}
+	return d
+}
+
+func jitter(d time.Duration) time.Duration {
+	return d + time.Duration((rand.Float64()*2-1)*backgroundJitter*float64(d))
+}
+
+// backgroundRoutine runs the background tasks when due, each in its own
+// goroutine never overlapping itself
+func (e *Engine) backgroundRoutine() {
+	e.background.Lock()
+	e.wakeBackground()
+	wake := e.background.wake
+	e.background.Unlock()
+	for {
+		tm := time.NewTimer(e.runDueBackground(time.Now()))
+		select {
+		case <-tm.C:
+		case <-wake:
+			tm.Stop()
+		}
+	}
+}
+
+// runDueBackground starts the tasks due, returning the wait till the next
+func (e *Engine) runDueBackground(now time.Time) time.Duration {
+	e.background.Lock()
+	tasks := append([]*backgroundTask(nil), e.background.tasks...)
+	e.background.Unlock()
+
+	wait := backgroundIdle
+	for _, t := range tasks {
+		// the intervals read the config, locking the engine
+		itv := t.interval()
+		e.background.Lock()
+		switch {
+		case t.Running:
+			t.Interval = itv
+		case t.triggered:
+			t.Interval, t.triggered, t.Running = itv, false, true
+			go e.runBackground(t)
+		case itv <= 0:
+			t.Interval, t.NextRun = 0, time.Time{}
+		case t.Paused:
+			t.Interval = itv
+		default:
+			if t.Interval <= 0 || t.NextRun.IsZero() {
+				first := itv
+				if first > backgroundIdle {
+					first = backgroundIdle
+				}
+				t.NextRun = now.Add(jitter(first))
+			}
+			t.Interval = itv
+			if !now.Before(t.NextRun) {
+				t.Running = true
+				go e.runBackground(t)
+			} else if d := t.NextRun.Sub(now); d < wait {
+				wait = d
+			}
+		}
+		e.background.Unlock()
+	}
+	return wait
+}
+
+func (e *Engine) runBackground(t *backgroundTask) {
+	start := time.Now()
+	err := t.run()
+	e.background.Lock()
+	defer e.background.Unlock()
+	t.Running = false
+	t.LastRun, t.LastDuration = start, time.Since(start)
+	if err != nil {
+		t.Failures++
+		t.LastError = err.Error()
+		log.Printf("[Background] %s failed %d times: %s", t.Name, t.Failures, err)
+	} else {
+		t.Failures, t.LastError = 0, ""
+	}
+	if t.Interval > 0 {
+		t.NextRun = time.Now().Add(jitter(backgroundDelay(t.Interval, t.Failures)))
+	}
+	e.wakeBackground()
+}
@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_backgroundDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		failures int
+		want     time.Duration
+	}{
+		{"ok", 30 * time.Minute, 0, 30 * time.Minute},
+		{"failed once", 30 * time.Minute, 1, time.Hour},
+		{"failed thrice", 30 * time.Minute, 3, 4 * time.Hour},
+		{"capped", 30 * time.Minute, 10, maxBackgroundBackoff},
+		{"longer than the cap", 24 * time.Hour, 3, 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := backgroundDelay(tt.interval, tt.failures); got != tt.want {
+				t.Errorf("backgroundDelay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEngine_background(t *testing.T) {
+	e := &Engine{}
+	ran := make(chan struct{}, 1)
+	fail := errors.New("feed down")
+	e.AddBackgroundTask("feed", func() time.Duration { return time.Hour }, func() error {
+		ran <- struct{}{}
+		return fail
+	})
+	e.AddBackgroundTask("off", func() time.Duration { return 0 }, func() error {
+		t.Error("disabled task run")
+		return nil
+	})
+	if err := e.PauseBackgroundTask("nope", true); !errors.Is(err, ErrUnknownBackgroundTask) {
+		t.Errorf("PauseBackgroundTask() = %v", err)
+	}
+
+	now := time.Now()
+	if wait := e.runDueBackground(now); wait > backgroundIdle {
+		t.Errorf("runDueBackground() wait %v", wait)
+	}
+	if err := e.TriggerBackgroundTask("feed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.TriggerBackgroundTask("nope"); !errors.Is(err, ErrUnknownBackgroundTask) {
+		t.Errorf("TriggerBackgroundTask() = %v", err)
+	}
+	e.runDueBackground(time.Now())
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("triggered task not run")
+	}
+	// the state is updated after the run returns
+	for i := 0; i < 100 && e.BackgroundTasks()[0].Running; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	tasks := e.BackgroundTasks()
+	if len(tasks) != 2 || tasks[0].Name != "feed" || tasks[1].Name != "off" {
+		t.Fatalf("BackgroundTasks() = %+v", tasks)
+	}
+	feed := tasks[0]
+	if feed.Failures != 1 || feed.LastError != fail.Error() || feed.LastRun.IsZero() {
+		t.Errorf("BackgroundTasks() feed = %+v", feed)
+	}
+	// an hour doubled after the failure, jittered
+	if d := time.Until(feed.NextRun); d < 108*time.Minute || d > 132*time.Minute {
+		t.Errorf("BackgroundTasks() feed next run in %v", d)
+	}
+	if off := tasks[1]; off.Interval != 0 || !off.NextRun.IsZero() {
+		t.Errorf("BackgroundTasks() off = %+v", off)
+	}
+}
+
+func TestEngine_backgroundPaused(t *testing.T) {
+	e := &Engine{}
+	ran := make(chan string, 2)
+	for _, name := range []string{"paused", "manual"} {
+		name := name
+		e.AddBackgroundTask(name, func() time.Duration {
+			if name == "manual" {
+				return 0
+			}
+			return time.Millisecond
+		}, func() error {
+			ran <- name
+			return nil
+		})
+	}
+	if err := e.PauseBackgroundTask("paused", true); err != nil {
+		t.Fatal(err)
+	}
+	// long past the first run of the paused one
+	e.runDueBackground(time.Now().Add(time.Hour))
+	select {
+	case name := <-ran:
+		t.Fatalf("%s run on schedule", name)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	for _, name := range []string{"paused", "manual"} {
+		if err := e.TriggerBackgroundTask(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.runDueBackground(time.Now())
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case name := <-ran:
+			got[name] = true
+		case <-time.After(time.Second):
+			t.Fatalf("triggered tasks run: %v", got)
+		}
+	}
+	if tasks := e.BackgroundTasks(); !tasks[1].Paused || tasks[0].Paused {
+		t.Errorf("BackgroundTasks() = %+v", tasks)
+	}
+}
@@ -0,0 +1,177 @@
+package engine
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	backupConfigName = "config.yaml"
+	backupTasksName  = "tasks.json"
+	backupCacheDir   = "cache/"
+	maxBackupEntry   = 64 << 20
+)
+
+var ErrInvalidBackup = errors.New("Invalid backup file")
+
+// WriteBackup writes a tar.gz of the config file, the cached torrent
+// metainfo/magnets and notes, and the tasks' state (started, labels). The
+// piece completion is not included, restored tasks are rechecked against
+// the data on disk instead.
+func (e *Engine) WriteBackup(w io.Writer, configFile string) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	add := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: time.Now(),
+		}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	if configFile != "" {
+		data, err := ioutil.ReadFile(configFile)
+		if err != nil {
+			return err
+		}
+		if err := add(backupConfigName, data); err != nil {
+			return err
+		}
+	}
+
+	var tasks []resumeTask
+	for _, rt := range e.resumeTasks() {
+		tasks = append(tasks, rt)
+	}
+	data, err := json.MarshalIndent(tasks, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := add(backupTasksName, data); err != nil {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(e.cacheDir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasPrefix(fi.Name(), cacheSavedPrefix) {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(e.cacheDir, fi.Name()))
+		if err != nil {
+			return err
+		}
+		if err := add(backupCacheDir+fi.Name(), data); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// RestoreBackup imports the tasks from a backup written by WriteBackup,
+// existing tasks are skipped. If applyConfig is not nil, it's called with the
+// config in the backup before the tasks are restored.
+func (e *Engine) RestoreBackup(r io.Reader, applyConfig func(config []byte) error) (restored int, err error) {
+	resume, cached, err := e.readBackup(r, applyConfig)
+	if err != nil {
+		return 0, err
+	}
+
+	// the tasks queued by the MaxConcurrentTask keep their state till added
+	e.setResume(resume)
+	defer e.setResume(nil)
+	for _, fn := range cached {
+		if err := e.RestoreTask(fn); err != nil && !errors.Is(err, ErrMaxConnTasks) {
+			log.Println("[RestoreBackup]", fn, err)
+			continue
+		}
+		restored++
+	}
+	log.Println("[RestoreBackup] restored", restored, "tasks")
+	return restored, nil
+}
+
+// readBackup extracts the cached files of the tasks not existing to the
+// cache dir, and reads the tasks' state. The cached torrents and magnets
+// to restore are returned, the sidecars are loaded along with them.
+func (e *Engine) readBackup(r io.Reader, applyConfig func(config []byte) error) (resume map[string]resumeTask, cached []string, err error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, ErrInvalidBackup
+	}
+	tr := tar.NewReader(gr)
+
+	resume = make(map[string]resumeTask)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg || hdr.Size > maxBackupEntry {
+			continue
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch name := path.Clean(hdr.Name); {
+		case name == backupConfigName:
+			if applyConfig != nil {
+				if err := applyConfig(data); err != nil {
+					return nil, nil, err
+				}
+			}
+		case name == backupTasksName:
+			var tasks []resumeTask
+			if err := json.Unmarshal(data, &tasks); err != nil {
+				return nil, nil, ErrInvalidBackup
+			}
+			for _, rt := range tasks {
+				resume[rt.InfoHash] = rt
+			}
+		case strings.HasPrefix(name, backupCacheDir):
+			base := path.Base(name)
+			if !strings.HasPrefix(base, cacheSavedPrefix) {
+				continue
+			}
+			fn := filepath.Join(e.cacheDir, base)
+			if _, err := os.Stat(fn); err == nil {
+				// task exists
+				continue
+			}
+			if err := ioutil.WriteFile(fn, data, 0644); err != nil {
+				return nil, nil, err
+			}
+			if isTaskSidecar(base) {
+				continue
+			}
+			cached = append(cached, fn)
+		}
+	}
+	return resume, cached, nil
+}
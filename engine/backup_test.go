@@ -0,0 +1,154 @@
+package engine
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEngine_backupRoundTrip(t *testing.T) {
+	const (
+		started = "0123456789abcdef0123456789abcdef01234567"
+		queued  = "89abcdef0123456789abcdef0123456789abcdef"
+	)
+	src := &Engine{ts: map[string]*Torrent{
+		started: {InfoHash: started, Started: true, Labels: []string{"tv"}},
+		queued:  {InfoHash: queued, IsQueueing: true},
+	}, cacheDir: t.TempDir()}
+	// restored into the queue stopped by a previous backup
+	src.setResume(map[string]resumeTask{queued: {InfoHash: queued, Labels: []string{"iso"}}})
+	src.queueResume(queued)
+	src.setResume(nil)
+
+	files := map[string]string{
+		cacheSavedPrefix + started + ".torrent":  "torrent",
+		cacheSavedPrefix + started + taskMetaExt: "meta",
+		cacheSavedPrefix + queued + ".info":      "magnet:?xt=urn:btih:" + queued,
+		"not-cached.txt":                         "x",
+	}
+	for fn, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(src.cacheDir, fn), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conf := filepath.Join(t.TempDir(), "cloud-torrent.yaml")
+	if err := ioutil.WriteFile(conf, []byte("AutoStart: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := src.WriteBackup(&buf, conf); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := &Engine{cacheDir: t.TempDir()}
+	var gotConf string
+	resume, cached, err := dst.readBackup(&buf, func(c []byte) error {
+		gotConf = string(c)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotConf != "AutoStart: true\n" {
+		t.Errorf("config = %q", gotConf)
+	}
+	wantResume := map[string]resumeTask{
+		started: {InfoHash: started, Started: true, Labels: []string{"tv"}},
+		queued:  {InfoHash: queued, Labels: []string{"iso"}},
+	}
+	if !reflect.DeepEqual(resume, wantResume) {
+		t.Errorf("resume = %+v, want %+v", resume, wantResume)
+	}
+	if len(cached) != 2 {
+		t.Errorf("cached = %v, want the torrent and the magnet", cached)
+	}
+	for fn, data := range files {
+		got, err := ioutil.ReadFile(filepath.Join(dst.cacheDir, fn))
+		if fn == "not-cached.txt" {
+			if !os.IsNotExist(err) {
+				t.Errorf("%s restored", fn)
+			}
+			continue
+		}
+		if string(got) != data {
+			t.Errorf("%s = %q, want %q", fn, got, data)
+		}
+	}
+}
+
+func TestEngine_readBackup_names(t *testing.T) {
+	const ih = "0123456789abcdef0123456789abcdef01234567"
+	tests := []struct {
+		name   string
+		entry  string
+		exists bool
+		want   string // the file extracted to the cache dir
+		cached bool
+	}{
+		{"torrent", "cache/" + cacheSavedPrefix + ih + ".torrent", false, cacheSavedPrefix + ih + ".torrent", true},
+		{"sidecar", "cache/" + cacheSavedPrefix + ih + taskScheduleExt, false, cacheSavedPrefix + ih + taskScheduleExt, false},
+		{"existing", "cache/" + cacheSavedPrefix + ih + ".torrent", true, "", false},
+		{"not cached", "cache/notes.txt", false, "", false},
+		{"outside", "cache/../../" + cacheSavedPrefix + ih + ".torrent", false, "", false},
+		{"nested", "cache/a/" + cacheSavedPrefix + ih + ".info", false, cacheSavedPrefix + ih + ".info", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Engine{cacheDir: t.TempDir()}
+			if tt.exists {
+				if err := ioutil.WriteFile(filepath.Join(e.cacheDir, filepath.Base(tt.entry)), []byte("old"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var buf bytes.Buffer
+			gw := gzip.NewWriter(&buf)
+			tw := tar.NewWriter(gw)
+			if err := tw.WriteHeader(&tar.Header{Name: tt.entry, Mode: 0644, Size: 3}); err != nil {
+				t.Fatal(err)
+			}
+			tw.Write([]byte("new")) // nolint: errcheck
+			tw.Close()
+			gw.Close()
+
+			_, cached, err := e.readBackup(&buf, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (len(cached) == 1) != tt.cached {
+				t.Errorf("cached = %v, want %v", cached, tt.cached)
+			}
+			fis, _ := ioutil.ReadDir(e.cacheDir)
+			var got []string
+			for _, fi := range fis {
+				if data, _ := ioutil.ReadFile(filepath.Join(e.cacheDir, fi.Name())); string(data) == "new" {
+					got = append(got, fi.Name())
+				}
+			}
+			if tt.want == "" && len(got) != 0 || tt.want != "" && (len(got) != 1 || got[0] != tt.want) {
+				t.Errorf("extracted %v, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEngine_queueResume(t *testing.T) {
+	const ih = "0123456789abcdef0123456789abcdef01234567"
+	e := &Engine{}
+	rt := resumeTask{InfoHash: ih, Started: true}
+	e.setResume(map[string]resumeTask{ih: rt})
+	e.queueResume(ih)
+	e.setResume(nil)
+
+	if got, ok := e.resumeState(ih); !ok || !reflect.DeepEqual(got, rt) {
+		t.Errorf("resumeState() = %+v, %v, want the queued state", got, ok)
+	}
+	if _, ok := e.resumeState(ih); ok {
+		t.Error("resumeState() kept after the task is added")
+	}
+}
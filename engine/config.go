@@ -1,8 +1,10 @@
 package engine
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -12,7 +14,6 @@ import (
 	"github.com/boypt/simple-torrent/common"
 	"github.com/spf13/viper"
 	"golang.org/x/time/rate"
-	"gopkg.in/yaml.v2"
 )
 
 const (
@@ -27,62 +28,205 @@ const (
 const (
 	defaultTrackerListURL = "https://raw.githubusercontent.com/ngosang/trackerslist/master/trackers_best.txt"
 	defaultConfigFile     = "cloud-torrent"
+	configEnvPrefix       = "CLOUD_TORRENT"
 )
 
 type Config struct {
 	AutoStart               bool          `yaml:"AutoStart"`
+	DefaultAddState         string        `yaml:"DefaultAddState"`
 	EngineDebug             bool          `yaml:"EngineDebug"`
+	EnablePprof             bool          `yaml:"EnablePprof"`
 	MuteEngineLog           bool          `yaml:"MuteEngineLog"`
 	ObfsPreferred           bool          `yaml:"ObfsPreferred"`
 	ObfsRequirePreferred    bool          `yaml:"ObfsRequirePreferred"`
 	DisableTrackers         bool          `yaml:"DisableTrackers"`
 	DisableIPv6             bool          `yaml:"DisableIPv6"`
+	PeerIPPreference        string        `yaml:"PeerIPPreference"`
+	SpeedTestMagnet         string        `yaml:"SpeedTestMagnet"`
+	SpeedTestDuration       time.Duration `yaml:"SpeedTestDuration"`
+	DisableWebseeds         bool          `yaml:"DisableWebseeds"`
 	NoDefaultPortForwarding bool          `yaml:"NoDefaultPortForwarding"`
 	DisableUTP              bool          `yaml:"DisableUTP"`
 	DownloadDirectory       string        `yaml:"DownloadDirectory"`
 	WatchDirectory          string        `yaml:"WatchDirectory"`
+	FinishedDirectory       string        `yaml:"FinishedDirectory"`
+	FileUID                 int           `yaml:"FileUID"`
+	FileGID                 int           `yaml:"FileGID"`
+	Umask                   string        `yaml:"Umask"`
 	EnableUpload            bool          `yaml:"EnableUpload"`
 	EnableSeeding           bool          `yaml:"EnableSeeding"`
 	IncomingPort            int           `yaml:"IncomingPort"`
 	DoneCmd                 string        `yaml:"DoneCmd"`
+	DoneCmdRoutes           string        `yaml:"DoneCmdRoutes"`
+	DoneCmdConcurrency      int           `yaml:"DoneCmdConcurrency"`
+	DoneCmdRetry            int           `yaml:"DoneCmdRetry"`
+	VerifyOnComplete        bool          `yaml:"VerifyOnComplete"`
+	ChecksumManifest        bool          `yaml:"ChecksumManifest"`
+	Hooks                   string        `yaml:"Hooks"`
+	Notifications           string        `yaml:"Notifications"`
+	NotifyQuietHours        string        `yaml:"NotifyQuietHours"`
+	NotifyQuietDigest       bool          `yaml:"NotifyQuietDigest"`
+	Plugins                 string        `yaml:"Plugins"`
+	UITheme                 string        `yaml:"UITheme"`
+	MQTTBroker              string        `yaml:"MQTTBroker"`
+	MQTTTopicPrefix         string        `yaml:"MQTTTopicPrefix"`
+	IMAPMailbox             string        `yaml:"IMAPMailbox"`
+	IMAPAllowedSenders      string        `yaml:"IMAPAllowedSenders"`
+	IMAPPollInterval        time.Duration `yaml:"IMAPPollInterval"`
+	RemoteImports           string        `yaml:"RemoteImports"`
+	RemoteImportInterval    time.Duration `yaml:"RemoteImportInterval"`
+	DiskQuotas              string        `yaml:"DiskQuotas"`
+	TransferQuota           string        `yaml:"TransferQuota"`
+	TransferQuotaCount      string        `yaml:"TransferQuotaCount"`
+	TransferQuotaResetDay   int           `yaml:"TransferQuotaResetDay"`
+	TransferQuotaThrottle   string        `yaml:"TransferQuotaThrottle"`
+	PoliciesFile            string        `yaml:"PoliciesFile"`
+	PoliciesInterval        time.Duration `yaml:"PoliciesInterval"`
+	ExcludeFiles            string        `yaml:"ExcludeFiles"`
+	BlockedFileTypes        string        `yaml:"BlockedFileTypes"`
+	BlockedFileAction       string        `yaml:"BlockedFileAction"`
+	QuarantineDirectory     string        `yaml:"QuarantineDirectory"`
+	MetricsPush             string        `yaml:"MetricsPush"`
+	MetricsPrefix           string        `yaml:"MetricsPrefix"`
+	MetricsPushInterval     time.Duration `yaml:"MetricsPushInterval"`
 	SeedRatio               float32       `yaml:"SeedRatio"`
 	SeedTime                time.Duration `yaml:"SeedTime"`
 	UploadRate              string        `yaml:"UploadRate"`
+	UploadAutoTune          bool          `yaml:"UploadAutoTune"`
+	SeedingUploadShare      float32       `yaml:"SeedingUploadShare"`
 	DownloadRate            string        `yaml:"DownloadRate"`
 	TrackerList             string        `yaml:"TrackerList"`
+	TrackerRefreshInterval  time.Duration `yaml:"TrackerRefreshInterval"`
 	AlwaysAddTrackers       bool          `yaml:"AlwaysAddTrackers"`
+	SkipPrivateTorrents     bool          `yaml:"SkipPrivateTorrents"`
 	ProxyURL                string        `yaml:"ProxyURL"`
+	DNSResolver             string        `yaml:"DNSResolver"`
 	RssURL                  string        `yaml:"RssURL"`
 	ScraperURL              string        `yaml:"ScraperURL"`
+	DisableSearch           bool          `yaml:"DisableSearch"`
+	SearchUseProxy          bool          `yaml:"SearchUseProxy"`
+	SearchTimeout           time.Duration `yaml:"SearchTimeout"`
+	SearchAutoDisable       time.Duration `yaml:"SearchAutoDisable"`
+	SearchOverrides         string        `yaml:"SearchOverrides"`
+	FlareSolverrURL         string        `yaml:"FlareSolverrURL"`
+	ClusterNodes            string        `yaml:"ClusterNodes"`
+	BackupInterval          time.Duration `yaml:"BackupInterval"`
+	BackupLocation          string        `yaml:"BackupLocation"`
+	BackupRetention         int           `yaml:"BackupRetention"`
+	TranscodeFFmpeg         string        `yaml:"TranscodeFFmpeg"`
+	TranscodeVideoCodec     string        `yaml:"TranscodeVideoCodec"`
+	TranscodeHWAccel        string        `yaml:"TranscodeHWAccel"`
+	TranscodeMaxJobs        int           `yaml:"TranscodeMaxJobs"`
+	DLNAEnable              bool          `yaml:"DLNAEnable"`
+	DLNAFriendlyName        string        `yaml:"DLNAFriendlyName"`
+	DLNAPort                int           `yaml:"DLNAPort"`
+	SubtitlesAPIKey         string        `yaml:"SubtitlesAPIKey"`
+	SubtitlesLanguages      string        `yaml:"SubtitlesLanguages"`
 	MaxConcurrentTask       int           `yaml:"MaxConcurrentTask"`
+	RetryMaxAttempts        int           `yaml:"RetryMaxAttempts"`
+	RetryBackoff            time.Duration `yaml:"RetryBackoff"`
+	StalledTimeout          time.Duration `yaml:"StalledTimeout"`
+	StalledReannounce       bool          `yaml:"StalledReannounce"`
+	StalledCallCmd          bool          `yaml:"StalledCallCmd"`
+	WatchdogTimeout         time.Duration `yaml:"WatchdogTimeout"`
+	WatchdogRestart         bool          `yaml:"WatchdogRestart"`
+	ScrubInterval           time.Duration `yaml:"ScrubInterval"`
+	ScrubFraction           float32       `yaml:"ScrubFraction"`
+	ScrubRate               string        `yaml:"ScrubRate"`
+	ScrubRepair             bool          `yaml:"ScrubRepair"`
+	ScrapeInterval          time.Duration `yaml:"ScrapeInterval"`
+	MetadataTimeout         time.Duration `yaml:"MetadataTimeout"`
+	MetadataSources         string        `yaml:"MetadataSources"`
+	StreamReadahead         int           `yaml:"StreamReadahead"`
+	StreamPriorityRadius    int           `yaml:"StreamPriorityRadius"`
 	AllowRuntimeConfigure   bool          `yaml:"AllowRuntimeConfigure"`
+	// the low-level torrent client settings, see advancedKnobs
+	Advanced map[string]interface{} `yaml:"Advanced,omitempty"`
 }
 
-func InitConf(specPath *string) (*Config, error) {
+// InitConf loads the config file, a non-empty instance name namespaces the
+// default config file and directories, and picks a free IncomingPort
+func InitConf(specPath *string, instance string) (*Config, error) {
+	confName := namespaced(defaultConfigFile, instance)
 	if *specPath != "" {
 		// user specific config path
 		viper.SetConfigFile(*specPath)
 	} else {
-		viper.SetConfigName(defaultConfigFile)
+		viper.SetConfigName(confName)
 		viper.AddConfigPath("/etc/")
 		viper.AddConfigPath(".")
 	}
 
-	viper.SetDefault("DownloadDirectory", "./downloads")
-	viper.SetDefault("WatchDirectory", "./torrents")
+	viper.SetDefault("DownloadDirectory", namespaced("./downloads", instance))
+	viper.SetDefault("WatchDirectory", namespaced("./torrents", instance))
+	viper.SetDefault("FileUID", -1)
+	viper.SetDefault("FileGID", -1)
 	viper.SetDefault("EnableUpload", true)
 	viper.SetDefault("EnableSeeding", true)
 	viper.SetDefault("NoDefaultPortForwarding", true)
 	viper.SetDefault("DisableUTP", false)
 	viper.SetDefault("AutoStart", true)
 	viper.SetDefault("DoneCmd", "")
+	viper.SetDefault("DoneCmdConcurrency", 2)
+	viper.SetDefault("DoneCmdRetry", 0)
 	viper.SetDefault("SeedRatio", 0)
 	viper.SetDefault("SeedTime", "0")
 	viper.SetDefault("ObfsPreferred", true)
 	viper.SetDefault("ObfsRequirePreferred", false)
 	viper.SetDefault("IncomingPort", 50007)
+	if instance != "" {
+		// avoid collisions between instances
+		viper.SetDefault("IncomingPort", 0)
+	}
 	viper.SetDefault("MaxConcurrentTask", 0)
 	viper.SetDefault("AllowRuntimeConfigure", true)
+	viper.SetDefault("TrackerRefreshInterval", "24h")
+	viper.SetDefault("SkipPrivateTorrents", true)
+	viper.SetDefault("RetryMaxAttempts", 5)
+	viper.SetDefault("RetryBackoff", "1m")
+	viper.SetDefault("StalledTimeout", "30m")
+	viper.SetDefault("StalledReannounce", true)
+	viper.SetDefault("WatchdogTimeout", "0")
+	viper.SetDefault("WatchdogRestart", false)
+	viper.SetDefault("ScrubInterval", "0")
+	viper.SetDefault("ScrubFraction", 0.1)
+	viper.SetDefault("ScrubRate", "10MB")
+	viper.SetDefault("ScrubRepair", true)
+	viper.SetDefault("MQTTTopicPrefix", "simple-torrent")
+	viper.SetDefault("IMAPPollInterval", 5*time.Minute)
+	viper.SetDefault("RemoteImportInterval", 10*time.Minute)
+	viper.SetDefault("MetricsPrefix", "simpletorrent")
+	viper.SetDefault("MetricsPushInterval", time.Minute)
+	viper.SetDefault("ScrapeInterval", "30m")
+	viper.SetDefault("DisableSearch", false)
+	viper.SetDefault("SearchTimeout", "30s")
+	viper.SetDefault("SearchAutoDisable", "0")
+	viper.SetDefault("DNSResolver", "")
+	viper.SetDefault("PeerIPPreference", "auto")
+	viper.SetDefault("SpeedTestMagnet", "")
+	viper.SetDefault("SpeedTestDuration", time.Minute)
+	viper.SetDefault("TransferQuota", "")
+	viper.SetDefault("TransferQuotaCount", "total")
+	viper.SetDefault("TransferQuotaResetDay", 1)
+	viper.SetDefault("TransferQuotaThrottle", "")
+	viper.SetDefault("PoliciesFile", "")
+	viper.SetDefault("PoliciesInterval", 10*time.Minute)
+	viper.SetDefault("MetadataTimeout", "0")
+	viper.SetDefault("MetadataSources", "")
+	viper.SetDefault("NotifyQuietDigest", true)
+	viper.SetDefault("StreamReadahead", 16)
+	viper.SetDefault("StreamPriorityRadius", 2)
+	viper.SetDefault("BackupInterval", "0")
+	viper.SetDefault("BackupRetention", 7)
+	viper.SetDefault("TranscodeVideoCodec", "libx264")
+	viper.SetDefault("TranscodeMaxJobs", 2)
+	viper.SetDefault("DLNAFriendlyName", "SimpleTorrent")
+	viper.SetDefault("DLNAPort", 1338)
+	viper.SetDefault("SubtitlesLanguages", "en")
+	viper.SetDefault("BlockedFileAction", GuardBlock)
+	viper.SetDefault("QuarantineDirectory", "quarantine")
+
+	bindConfigEnv()
 
 	configExists := true
 	if err := viper.ReadInConfig(); err != nil {
@@ -93,7 +237,7 @@ func InitConf(specPath *string) (*Config, error) {
 			// write a default config file if not exists and not provided
 			c := &Config{}
 			common.HandleError(viper.Unmarshal(c))
-			cn := defaultConfigFile + ".yaml"
+			cn := confName + ".yaml"
 			common.HandleError(c.WriteYaml(cn))
 			viper.SetConfigFile(cn)
 			log.Println("saved default config", cn)
@@ -104,7 +248,28 @@ func InitConf(specPath *string) (*Config, error) {
 	log.Println("[config] using config file: ", *specPath)
 
 	c := &Config{}
-	common.HandleError(viper.Unmarshal(c))
+	if err := unmarshalViper(c); err != nil {
+		var cerrs ConfigErrors
+		if !errors.As(err, &cerrs) {
+			return nil, err
+		}
+		// unknown keys are left from old versions or typos, only warn about them
+		var invalid ConfigErrors
+		for _, ce := range cerrs {
+			if errors.Is(ce, ErrUnknownConfigKey) {
+				log.Println("[config] WARNING: ignored", ce)
+				continue
+			}
+			invalid = append(invalid, ce)
+		}
+		if len(invalid) > 0 {
+			return nil, fmt.Errorf("ERROR: config file %s: %w", *specPath, invalid)
+		}
+	}
+	// the encrypted secrets fail at the start rather than when used
+	if err := c.checkSecrets(); err != nil {
+		return nil, fmt.Errorf("ERROR: config file %s: %w", *specPath, err)
+	}
 
 	dirChanged, err := c.NormlizeConfigDir()
 	if err != nil {
@@ -113,18 +278,77 @@ func InitConf(specPath *string) (*Config, error) {
 	if dirChanged {
 		viper.Set("DownloadDirectory", c.DownloadDirectory)
 		viper.Set("WatchDirectory", c.WatchDirectory)
+		viper.Set("FinishedDirectory", c.FinishedDirectory)
 	}
 
-	if !configExists || dirChanged {
+	portPicked := false
+	if c.IncomingPort == 0 {
+		port, err := freePort()
+		if err != nil {
+			return nil, err
+		}
+		c.IncomingPort = port
+		viper.Set("IncomingPort", port)
+		portPicked = true
+		log.Println("[config] picked free IncomingPort", port)
+	}
+
+	if !configExists || dirChanged || portPicked {
 		if err := c.WriteDefault(); err != nil {
 			return nil, err
 		}
-		log.Println("[config] config file updated: ", *specPath, "exists:", configExists, "dirchanged", dirChanged)
+		log.Println("[config] config file updated: ", *specPath, "exists:", configExists, "dirchanged", dirChanged, "portpicked", portPicked)
 	}
 
 	return c, nil
 }
 
+// the defaults of the config and the fields overridden by the env, which
+// are kept out of the config file
+var (
+	configDefaults  Config
+	envConfigFields map[string]bool
+)
+
+// bindConfigEnv makes every Config field overridable by the env,
+// eg. CLOUD_TORRENT_DOWNLOADDIRECTORY=/srv/downloads
+func bindConfigEnv() {
+	configDefaults = Config{}
+	if err := unmarshalViper(&configDefaults); err != nil {
+		log.Println("[config] defaults:", err)
+	}
+	viper.SetEnvPrefix(configEnvPrefix)
+	envConfigFields = make(map[string]bool)
+	for key, name := range configFieldNames() {
+		common.HandleError(viper.BindEnv(name))
+		if _, ok := os.LookupEnv(configEnvPrefix + "_" + strings.ToUpper(key)); ok && viper.IsSet(key) {
+			envConfigFields[name] = true
+		}
+	}
+}
+
+// fileConfig is c as written to the config file of the data: the fields
+// overridden by the env keep the value of the file, or the default, so a
+// one-off env var or a secret in the env never lands in the file
+func (c *Config) fileConfig(data []byte, typ string) *Config {
+	if len(envConfigFields) == 0 {
+		return c
+	}
+	fc := *c
+	v := viper.New()
+	v.SetConfigType(typ)
+	fileRead := len(bytes.TrimSpace(data)) > 0 && v.ReadConfig(bytes.NewReader(data)) == nil
+	dv := reflect.ValueOf(configDefaults)
+	fv := reflect.ValueOf(&fc).Elem()
+	for name := range envConfigFields {
+		if fileRead && v.IsSet(name) && decodeConfigField(&fc, name, v.Get(name)) == nil {
+			continue
+		}
+		fv.FieldByName(name).Set(dv.FieldByName(name))
+	}
+	return &fc
+}
+
 func (c *Config) NormlizeConfigDir() (bool, error) {
 	var changed bool
 	if c.DownloadDirectory != "" {
@@ -149,6 +373,17 @@ func (c *Config) NormlizeConfigDir() (bool, error) {
 		}
 	}
 
+	if c.FinishedDirectory != "" {
+		fdir, err := filepath.Abs(c.FinishedDirectory)
+		if err != nil {
+			return false, fmt.Errorf("ERROR: Invalid path %s, %w", c.FinishedDirectory, err)
+		}
+		if c.FinishedDirectory != fdir {
+			changed = true
+			c.FinishedDirectory = fdir
+		}
+	}
+
 	return changed, nil
 }
 
@@ -176,7 +411,9 @@ func (c *Config) Validate(nc *Config) uint8 {
 
 	var status uint8
 
-	if c.DoneCmd != nc.DoneCmd {
+	if c.DoneCmd != nc.DoneCmd || c.DoneCmdRoutes != nc.DoneCmdRoutes ||
+		c.Plugins != nc.Plugins || c.TranscodeFFmpeg != nc.TranscodeFFmpeg ||
+		c.TranscodeHWAccel != nc.TranscodeHWAccel {
 		status |= ForbidRuntimeChange
 	}
 	if c.WatchDirectory != nc.WatchDirectory {
@@ -198,12 +435,13 @@ func (c *Config) Validate(nc *Config) uint8 {
 	for _, field := range []string{"IncomingPort", "DownloadDirectory",
 		"EngineDebug", "EnableUpload", "EnableSeeding", "UploadRate",
 		"DownloadRate", "ObfsPreferred", "ObfsRequirePreferred",
-		"DisableTrackers", "DisableIPv6", "ProxyURL"} {
+		"DisableTrackers", "DisableIPv6", "PeerIPPreference", "DisableWebseeds", "ProxyURL",
+		"FileUID", "FileGID", "Umask", "Advanced"} {
 
 		cval := reflect.Indirect(rfc).FieldByName(field)
 		ncval := reflect.Indirect(rfnc).FieldByName(field)
 
-		if cval.Interface() != ncval.Interface() {
+		if !reflect.DeepEqual(cval.Interface(), ncval.Interface()) {
 			status |= NeedEngineReConfig
 			break
 		}
@@ -217,37 +455,58 @@ func (c *Config) SyncViper(nc Config) {
 	nv := reflect.ValueOf(nc)
 	typeOfC := cv.Type()
 	for i := 0; i < typeOfC.NumField(); i++ {
-		if cv.Field(i).Interface() != nv.Field(i).Interface() {
+		if !reflect.DeepEqual(cv.Field(i).Interface(), nv.Field(i).Interface()) {
 			name := typeOfC.Field(i).Name
 			oval := cv.Field(i).Interface()
 			val := nv.Field(i).Interface()
 			viper.Set(name, val)
+			if isSecretField(name) {
+				oval, val = secretMask, secretMask
+			}
 			log.Println("config updated ", name, ": ", oval, " -> ", val)
 		}
 	}
 }
 
+// ChangedFields lists the names of the fields differing in nc
+func (c *Config) ChangedFields(nc *Config) []string {
+	cv := reflect.ValueOf(*c)
+	nv := reflect.ValueOf(*nc)
+	var names []string
+	for i := 0; i < cv.NumField(); i++ {
+		if !reflect.DeepEqual(cv.Field(i).Interface(), nv.Field(i).Interface()) {
+			names = append(names, cv.Type().Field(i).Name)
+		}
+	}
+	return names
+}
+
 func (c *Config) WriteDefault() error {
 	cf := viper.ConfigFileUsed()
 	cfext := strings.ToLower(filepath.Ext(cf))
-	if cfext == "yml" || cfext == "yaml" {
-		// keeps keys cases
+	if cfext == ".yml" || cfext == ".yaml" {
+		// keeps keys cases, and the comments of the file
 		return c.WriteYaml(cf)
 	}
 	// viper's write make all keys lowercased
-	return viper.WriteConfig()
-}
-
-func (c *Config) WriteYaml(cf string) error {
-	d, err := yaml.Marshal(c)
-	if err != nil {
+	if len(envConfigFields) == 0 {
+		return viper.WriteConfig()
+	}
+	data, err := ioutil.ReadFile(cf)
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	return os.WriteFile(cf, d, 0666)
+	fc := c.fileConfig(data, strings.TrimPrefix(cfext, "."))
+	v := viper.New()
+	fv := reflect.ValueOf(*fc)
+	for i := 0; i < fv.NumField(); i++ {
+		v.Set(fv.Type().Field(i).Name, fv.Field(i).Interface())
+	}
+	return v.WriteConfigAs(cf)
 }
 
 func (c *Config) GetCmdConfig() (string, []string, error) {
-	if c.DoneCmd == "" {
+	if c.DoneCmd == "" && c.DoneCmdRoutes == "" {
 		return "", nil, fmt.Errorf("unconfigred Donecmd")
 	}
 	env := append(os.Environ(), fmt.Sprintf("CLD_DIR=%s", c.DownloadDirectory))
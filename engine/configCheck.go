@@ -0,0 +1,302 @@
+package engine
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+// FieldError is a problem found on a config field
+type FieldError struct {
+	Field string
+	Error string
+}
+
+// ConfigCheck is the dry-run result of applying a new config
+type ConfigCheck struct {
+	Valid   bool
+	Errors  []FieldError
+	Effects []string
+}
+
+var statusEffects = []struct {
+	flag uint8
+	name string
+}{
+	{ForbidRuntimeChange, "ForbidRuntimeChange"},
+	{NeedEngineReConfig, "NeedEngineReConfig"},
+	{NeedRestartWatch, "NeedRestartWatch"},
+	{NeedUpdateTracker, "NeedUpdateTracker"},
+	{NeedLoadWaitList, "NeedLoadWaitList"},
+	{NeedUpdateRSS, "NeedUpdateRSS"},
+}
+
+// Check validates nc as the replacement of c without applying it, the
+// Effects list what the change requires (see Validate)
+func (c *Config) Check(nc *Config) ConfigCheck {
+	var errs []FieldError
+	add := func(field string, err error) {
+		if err != nil {
+			errs = append(errs, FieldError{field, err.Error()})
+		}
+	}
+
+	add("DownloadDirectory", checkDirWritable(nc.DownloadDirectory))
+	if nc.WatchDirectory != "" {
+		add("WatchDirectory", checkDirWritable(nc.WatchDirectory))
+	}
+	if nc.IncomingPort <= 0 || nc.IncomingPort > 65535 {
+		add("IncomingPort", fmt.Errorf("Invalid incoming port (%d)", nc.IncomingPort))
+	} else if nc.IncomingPort != c.IncomingPort {
+		add("IncomingPort", checkPortFree(nc.IncomingPort))
+	}
+	if nc.ProxyURL != "" {
+		if proxyURL, err := nc.resolvedProxyURL(); err != nil {
+			add("ProxyURL", err)
+		} else {
+			add("ProxyURL", checkProxy(proxyURL))
+		}
+	}
+	if err := checkIPPreference(nc.PeerIPPreference); err != nil {
+		add("PeerIPPreference", err)
+	}
+	if _, err := NewResolver(nc.DNSResolver); err != nil {
+		add("DNSResolver", err)
+	}
+	if _, err := rateLimiter(nc.UploadRate); err != nil {
+		add("UploadRate", err)
+	}
+	if _, err := rateLimiter(nc.DownloadRate); err != nil {
+		add("DownloadRate", err)
+	}
+	if _, err := rateLimiter(nc.ScrubRate); err != nil {
+		add("ScrubRate", err)
+	}
+	if nc.SeedingUploadShare < 0 || nc.SeedingUploadShare > 1 {
+		add("SeedingUploadShare", fmt.Errorf("Invalid value (%g), expecting 0 to 1", nc.SeedingUploadShare))
+	}
+	if nc.ScrubFraction < 0 || nc.ScrubFraction > 1 {
+		add("ScrubFraction", fmt.Errorf("Invalid value (%g), expecting 0 to 1", nc.ScrubFraction))
+	}
+	if ovs, err := ParseFetchOverrides(nc.SearchOverrides); err != nil {
+		add("SearchOverrides", err)
+	} else {
+		for _, o := range ovs {
+			if o.Proxy != "" && o.Proxy != FetchDirect {
+				add("SearchOverrides", checkProxy(o.Proxy))
+			}
+			if o.Cloudflare && nc.FlareSolverrURL == "" {
+				add("SearchOverrides", fmt.Errorf("%s is cloudflare protected, but FlareSolverrURL is not set", o.Target))
+			}
+		}
+	}
+	if _, err := ParseHooks(nc.Hooks); err != nil {
+		add("Hooks", err)
+	}
+	if _, err := ParseDoneCmdRoutes(nc.DoneCmdRoutes); err != nil {
+		add("DoneCmdRoutes", err)
+	}
+	if _, err := ParseNotifications(nc.Notifications); err != nil {
+		add("Notifications", err)
+	}
+	if nc.UITheme != "" && !strings.HasPrefix(nc.UITheme, "http://") && !strings.HasPrefix(nc.UITheme, "https://") {
+		if st, err := os.Stat(nc.UITheme); err != nil || !st.IsDir() {
+			add("UITheme", fmt.Errorf("Invalid theme, expecting a directory or the url of a .zip (%s)", nc.UITheme))
+		}
+	}
+	if _, err := parseMQTTBroker(nc.MQTTBroker); err != nil {
+		add("MQTTBroker", err)
+	}
+	if nc.MQTTBroker != "" && (nc.MQTTTopicPrefix == "" || strings.ContainsAny(nc.MQTTTopicPrefix, "+#")) {
+		add("MQTTTopicPrefix", fmt.Errorf("Invalid topic prefix (%s)", nc.MQTTTopicPrefix))
+	}
+	if _, err := parseIMAPMailbox(nc.IMAPMailbox); err != nil {
+		add("IMAPMailbox", err)
+	}
+	if nc.IMAPMailbox != "" && strings.TrimSpace(nc.IMAPAllowedSenders) == "" {
+		add("IMAPAllowedSenders", fmt.Errorf("Required by the IMAPMailbox"))
+	}
+	if nc.IMAPMailbox != "" && nc.IMAPPollInterval < time.Minute {
+		add("IMAPPollInterval", fmt.Errorf("Invalid poll interval, at least 1m (%s)", nc.IMAPPollInterval))
+	}
+	if _, err := parseRemoteImports(nc.RemoteImports); err != nil {
+		add("RemoteImports", err)
+	}
+	if strings.TrimSpace(nc.RemoteImports) != "" && nc.RemoteImportInterval < time.Minute {
+		add("RemoteImportInterval", fmt.Errorf("Invalid poll interval, at least 1m (%s)", nc.RemoteImportInterval))
+	}
+	for _, f := range secretFields {
+		add(f.name, checkEncryptedSecrets(*f.get(nc)))
+	}
+	if _, err := parseMetricsPush(nc.MetricsPush); err != nil {
+		add("MetricsPush", err)
+	}
+	if nc.MetricsPush != "" {
+		if nc.MetricsPrefix == "" || strings.ContainsAny(nc.MetricsPrefix, " ,=") {
+			add("MetricsPrefix", fmt.Errorf("Invalid metrics prefix (%s)", nc.MetricsPrefix))
+		}
+		if nc.MetricsPushInterval < minMetricsInterval {
+			add("MetricsPushInterval", fmt.Errorf("Invalid push interval, at least %s (%s)", minMetricsInterval, nc.MetricsPushInterval))
+		}
+	}
+	if _, err := parseQuotas(nc.DiskQuotas); err != nil {
+		add("DiskQuotas", err)
+	}
+	if _, err := parseTransferQuota(nc.TransferQuota); err != nil {
+		add("TransferQuota", err)
+	}
+	switch nc.TransferQuotaCount {
+	case "total", "upload", "download":
+	default:
+		add("TransferQuotaCount", fmt.Errorf("Invalid value, expecting total, upload or download (%s)", nc.TransferQuotaCount))
+	}
+	if nc.TransferQuotaResetDay < 1 || nc.TransferQuotaResetDay > 28 {
+		add("TransferQuotaResetDay", fmt.Errorf("Invalid day, 1-28 (%d)", nc.TransferQuotaResetDay))
+	}
+	if nc.TransferQuotaThrottle != "" {
+		if _, err := rateLimiter(nc.TransferQuotaThrottle); err != nil {
+			add("TransferQuotaThrottle", err)
+		}
+	}
+	if nc.PoliciesFile != "" {
+		if _, _, err := loadPolicies(nc.PoliciesFile); err != nil {
+			add("PoliciesFile", err)
+		}
+		if nc.PoliciesInterval < time.Minute {
+			add("PoliciesInterval", fmt.Errorf("Invalid interval, at least 1m (%s)", nc.PoliciesInterval))
+		}
+	}
+	if _, err := parseExcludeFiles(nc.ExcludeFiles); err != nil {
+		add("ExcludeFiles", err)
+	}
+	if _, err := parseFileGuard(nc); err != nil {
+		field := "BlockedFileTypes"
+		if nc.BlockedFileAction == GuardQuarantine {
+			field = "QuarantineDirectory"
+		}
+		add(field, err)
+	}
+	if _, err := nc.quietHours(); err != nil {
+		add("NotifyQuietHours", err)
+	}
+	if _, err := HWAccelArgs(nc.TranscodeHWAccel); err != nil {
+		add("TranscodeHWAccel", err)
+	}
+	if _, err := parseAddStates(nc.DefaultAddState); err != nil {
+		add("DefaultAddState", err)
+	}
+	if _, err := newFileOwner(nc); err != nil {
+		add("Umask", err)
+	}
+	if err := checkAdvanced(nc.Advanced); err != nil {
+		add("Advanced", err)
+	}
+	if nc.SpeedTestMagnet != "" {
+		if _, err := torrent.TorrentSpecFromMagnetUri(nc.SpeedTestMagnet); err != nil {
+			add("SpeedTestMagnet", err)
+		}
+		if nc.SpeedTestDuration < minSpeedTestDuration || nc.SpeedTestDuration > maxSpeedTestDuration {
+			add("SpeedTestDuration", fmt.Errorf("Invalid duration, %s-%s (%s)", minSpeedTestDuration, maxSpeedTestDuration, nc.SpeedTestDuration))
+		}
+	}
+	if nc.SearchAutoDisable < 0 {
+		add("SearchAutoDisable", fmt.Errorf("Invalid value (%s)", nc.SearchAutoDisable))
+	}
+	if nc.MaxConcurrentTask < 0 {
+		add("MaxConcurrentTask", fmt.Errorf("Invalid value (%d)", nc.MaxConcurrentTask))
+	}
+
+	var effects []string
+	status := c.Validate(nc)
+	for _, se := range statusEffects {
+		if status&se.flag > 0 {
+			effects = append(effects, se.name)
+		}
+	}
+	return ConfigCheck{
+		Valid:   len(errs) == 0 && status&ForbidRuntimeChange == 0,
+		Errors:  errs,
+		Effects: effects,
+	}
+}
+
+// checkDirWritable checks the dir, or its nearest existing parent if the
+// dir is not created yet, is writable
+func checkDirWritable(dir string) error {
+	if dir == "" {
+		return fmt.Errorf("Empty path")
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	for {
+		st, err := os.Stat(dir)
+		if err == nil {
+			if !st.IsDir() {
+				return fmt.Errorf("%s is not a directory", dir)
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return err
+		}
+		dir = parent
+	}
+	f, err := os.CreateTemp(dir, ".writetest")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+func checkPortFree(port int) error {
+	addr := fmt.Sprintf(":%d", port)
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	l.Close()
+	u, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return u.Close()
+}
+
+func checkProxy(proxy string) error {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return err
+	}
+	if u.Host == "" {
+		return fmt.Errorf("Invalid proxy url %s", maskURL(proxy))
+	}
+	host := u.Host
+	if u.Port() == "" {
+		switch u.Scheme {
+		case "https":
+			host = net.JoinHostPort(u.Hostname(), "443")
+		case "socks5", "socks5h":
+			host = net.JoinHostPort(u.Hostname(), "1080")
+		default:
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+	conn, err := net.DialTimeout("tcp", host, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
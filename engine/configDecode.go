@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrUnknownConfigKey = errors.New("Unknown config key")
+	ErrInvalidValue     = errors.New("Invalid value")
+)
+
+// ConfigError is a bad key or value in the config
+type ConfigError struct {
+	Field string
+	Value interface{}
+	Err   error
+}
+
+func (e *ConfigError) Error() string {
+	if e.Value == nil {
+		return fmt.Sprintf("%s: %v", e.Field, e.Err)
+	}
+	return fmt.Sprintf("%s: %v (%v)", e.Field, e.Err, e.Value)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigErrors are all the errors found decoding a config
+type ConfigErrors []*ConfigError
+
+func (es ConfigErrors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func configFieldNames() map[string]string {
+	names := make(map[string]string)
+	typ := reflect.TypeOf(Config{})
+	for i := 0; i < typ.NumField(); i++ {
+		names[strings.ToLower(typ.Field(i).Name)] = typ.Field(i).Name
+	}
+	return names
+}
+
+// unmarshalViper decodes the viper settings strictly: unknown keys and
+// values not fitting the field type are reported instead of silently ignored
+func unmarshalViper(c *Config) error {
+	var errs ConfigErrors
+	names := configFieldNames()
+	advanced := false
+	for _, key := range viper.AllKeys() {
+		// viper flattens the maps into "advanced.<name>"
+		if strings.HasPrefix(key, "advanced.") {
+			if !advanced {
+				advanced = true
+				if err := decodeConfigField(c, "Advanced", viper.GetStringMap("advanced")); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			continue
+		}
+		name, ok := names[key]
+		if !ok {
+			errs = append(errs, &ConfigError{Field: key, Err: ErrUnknownConfigKey})
+			continue
+		}
+		val := viper.Get(key)
+		if err := decodeConfigField(c, name, val); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// decodeConfigField sets a field of c by the name, converting the value
+// like the config file does
+func decodeConfigField(c *Config, name string, val interface{}) *ConfigError {
+	dec, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+		),
+		WeaklyTypedInput: true,
+		Result:           c,
+	})
+	if err != nil {
+		return &ConfigError{Field: name, Value: val, Err: err}
+	}
+	if err := dec.Decode(map[string]interface{}{name: val}); err != nil {
+		return &ConfigError{Field: name, Value: val, Err: ErrInvalidValue}
+	}
+	return nil
+}
+
+// DecodeConfigJSON decodes the config posted by the web UI / API, reporting
+// unknown keys and mistyped values as ConfigErrors
+func DecodeConfigJSON(data []byte, c *Config) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(c)
+	if err == nil {
+		return nil
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return ConfigErrors{{Field: typeErr.Field, Value: typeErr.Value, Err: ErrInvalidValue}}
+	}
+	// encoding/json reports: json: unknown field "name"
+	if msg := err.Error(); strings.HasPrefix(msg, "json: unknown field ") {
+		field := strings.Trim(strings.TrimPrefix(msg, "json: unknown field "), `"`)
+		return ConfigErrors{{Field: field, Err: ErrUnknownConfigKey}}
+	}
+	return err
+}
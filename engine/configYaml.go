@@ -0,0 +1,204 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// mergeYaml writes c into the YAML document orig, keeping its comments,
+// blank lines, anchors and the order of the keys: only the lines of the
+// values changed are rewritten, the keys missing are appended unless zero
+func mergeYaml(orig []byte, c *Config) ([]byte, error) {
+	var doc yaml3.Node
+	if err := yaml3.Unmarshal(orig, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind != yaml3.DocumentNode || len(doc.Content) == 0 ||
+		doc.Content[0].Kind != yaml3.MappingNode || doc.Content[0].Style&yaml3.FlowStyle != 0 {
+		return nil, errors.New("the config is not a YAML block mapping")
+	}
+	root := doc.Content[0]
+
+	var fresh yaml3.Node
+	if err := fresh.Encode(c); err != nil {
+		return nil, err
+	}
+	fields := configYamlFields()
+	cv := reflect.ValueOf(c).Elem()
+	lines := strings.SplitAfter(string(orig), "\n")
+	// the rewritten pairs by the index of their first line
+	replaced := make(map[int]yamlSplice)
+	var appended []*yaml3.Node
+	for i := 0; i+1 < len(fresh.Content); i += 2 {
+		key, val := fresh.Content[i], fresh.Content[i+1]
+		fi := fields[strings.ToLower(key.Value)]
+
+		// viper's keys are case insensitive
+		found := false
+		for j := 0; j+1 < len(root.Content); j += 2 {
+			okey, old := root.Content[j], root.Content[j+1]
+			if !strings.EqualFold(okey.Value, key.Value) {
+				continue
+			}
+			found = true
+			if yamlValueEqual(old, c, fi) {
+				continue
+			}
+			next := len(lines)
+			if j+2 < len(root.Content) {
+				next = root.Content[j+2].Line - 1
+			}
+			text, err := encodeYamlPair(okey.Value, keepYamlValue(old, val))
+			if err != nil {
+				return nil, err
+			}
+			replaced[okey.Line-1] = yamlSplice{end: yamlPairEnd(lines, okey.Line-1, next), text: text}
+		}
+		if !found && !cv.Field(fi).IsZero() {
+			appended = append(appended, key, val)
+		}
+	}
+
+	var b bytes.Buffer
+	for i := 0; i < len(lines); i++ {
+		if sp, ok := replaced[i]; ok {
+			b.WriteString(sp.text)
+			i = sp.end - 1
+			continue
+		}
+		b.WriteString(lines[i])
+	}
+	if len(appended) > 0 {
+		if b.Len() > 0 && !bytes.HasSuffix(b.Bytes(), []byte("\n")) {
+			b.WriteByte('\n')
+		}
+		for i := 0; i < len(appended); i += 2 {
+			text, err := encodeYamlPair(appended[i].Value, appended[i+1])
+			if err != nil {
+				return nil, err
+			}
+			b.WriteString(text)
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// yamlSplice is the text replacing the lines of a pair, up to end
+type yamlSplice struct {
+	end  int
+	text string
+}
+
+// yamlPairEnd is the index after the last line of the top level pair
+// starting at the line start, the comments and blank lines before the next
+// key at next are left to it
+func yamlPairEnd(lines []string, start, next int) int {
+	end := next
+	for end > start+1 {
+		l := lines[end-1]
+		if strings.TrimSpace(l) != "" && !strings.HasPrefix(l, "#") {
+			break
+		}
+		end--
+	}
+	return end
+}
+
+// keepYamlValue is val with the comments and the anchor of old, and its
+// style if both are strings
+func keepYamlValue(old, val *yaml3.Node) *yaml3.Node {
+	nv := *val
+	// the foot comments are kept in the lines after the pair
+	nv.HeadComment, nv.LineComment = old.HeadComment, old.LineComment
+	nv.Anchor = old.Anchor
+	if old.Kind == yaml3.ScalarNode && old.ShortTag() == "!!str" &&
+		val.Kind == yaml3.ScalarNode && val.ShortTag() == "!!str" {
+		nv.Style = old.Style
+	}
+	return &nv
+}
+
+func encodeYamlPair(key string, val *yaml3.Node) (string, error) {
+	pair := &yaml3.Node{Kind: yaml3.MappingNode, Content: []*yaml3.Node{
+		{Kind: yaml3.ScalarNode, Value: key}, val,
+	}}
+	var b bytes.Buffer
+	enc := yaml3.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(pair); err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// configYamlFields are the indexes of the Config fields by their yaml
+// keys, lower cased
+func configYamlFields() map[string]int {
+	fields := make(map[string]int)
+	typ := reflect.TypeOf(Config{})
+	for i := 0; i < typ.NumField(); i++ {
+		name := strings.Split(typ.Field(i).Tag.Get("yaml"), ",")[0]
+		if name == "" {
+			name = typ.Field(i).Name
+		}
+		fields[strings.ToLower(name)] = i
+	}
+	return fields
+}
+
+// yamlValueEqual tells if the node, an alias resolved, is the value of the
+// field i of c, converted like the config file is
+func yamlValueEqual(node *yaml3.Node, c *Config, i int) bool {
+	var raw interface{}
+	if err := node.Decode(&raw); err != nil {
+		return false
+	}
+	var dec Config
+	name := reflect.TypeOf(dec).Field(i).Name
+	if err := decodeConfigField(&dec, name, raw); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(reflect.ValueOf(dec).Field(i).Interface(), reflect.ValueOf(*c).Field(i).Interface())
+}
+
+// WriteYaml writes the config to the YAML file cf, merged into the
+// existing one if any
+func (c *Config) WriteYaml(cf string) error {
+	data, err := ioutil.ReadFile(cf)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	c = c.fileConfig(data, "yaml")
+	var out []byte
+	if len(bytes.TrimSpace(data)) > 0 {
+		if out, err = mergeYaml(data, c); err != nil {
+			log.Printf("[config] %s rewritten, its comments not kept: %s", cf, err)
+		}
+	}
+	if out == nil {
+		if out, err = yaml.Marshal(c); err != nil {
+			return err
+		}
+	}
+
+	mode := os.FileMode(0666)
+	if fi, err := os.Stat(cf); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	tmp := filepath.Join(filepath.Dir(cf), "."+filepath.Base(cf)+".tmp")
+	if err := ioutil.WriteFile(tmp, out, mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cf)
+}
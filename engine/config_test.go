@@ -1,8 +1,10 @@
 package engine
 
 import (
+	"errors"
 	"reflect"
 	"testing"
+	"time"
 
 	"golang.org/x/time/rate"
 )
@@ -41,3 +43,169 @@ func Test_rateLimiter(t *testing.T) {
 		})
 	}
 }
+
+func TestDecodeConfigJSON(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		wantField string
+		wantErr   error
+	}{
+		{"ok", `{"SeedRatio": 1.5, "AutoStart": true}`, "", nil},
+		{"type", `{"SeedRatio": "abc"}`, "SeedRatio", ErrInvalidValue},
+		{"unknown", `{"SeedRatoi": 1.5}`, "SeedRatoi", ErrUnknownConfigKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := DecodeConfigJSON([]byte(tt.data), &Config{})
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("DecodeConfigJSON() error = %v", err)
+				}
+				return
+			}
+			cerrs, ok := err.(ConfigErrors)
+			if !ok || len(cerrs) != 1 {
+				t.Fatalf("DecodeConfigJSON() error = %v, want ConfigErrors", err)
+			}
+			if cerrs[0].Field != tt.wantField || !errors.Is(cerrs[0], tt.wantErr) {
+				t.Errorf("DecodeConfigJSON() = %v, want %s %v", cerrs[0], tt.wantField, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDefaultAddState(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    string
+		source  string
+		want    string
+		wantErr bool
+	}{
+		{"empty", "", AddSourceWeb, "", false},
+		{"all", "paused", AddSourceWatch, AddPaused, false},
+		{"source", "watch=paused, web=started", AddSourceWeb, AddStarted, false},
+		{"fallback", "started, watch=paused", AddSourceWeb, AddStarted, false},
+		{"unset", "watch=paused", AddSourceWeb, "", false},
+		{"state", "stopped", AddSourceWeb, "", true},
+		{"sourceerr", "rss=paused", AddSourceWeb, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseAddStates(tt.conf); (err != nil) != tt.wantErr {
+				t.Errorf("parseAddStates() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			c := &Config{DefaultAddState: tt.conf}
+			if got := c.defaultAddState(tt.source); got != tt.want {
+				t.Errorf("defaultAddState() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHWAccelArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []string
+		wantErr bool
+	}{
+		{"empty", "", nil, false},
+		{"cuda", "-hwaccel cuda", []string{"-hwaccel", "cuda"}, false},
+		{"vaapi", "-hwaccel vaapi -hwaccel_device /dev/dri/renderD128 -hwaccel_output_format vaapi",
+			[]string{"-hwaccel", "vaapi", "-hwaccel_device", "/dev/dri/renderD128", "-hwaccel_output_format", "vaapi"}, false},
+		{"unknown accel", "-hwaccel foo", nil, true},
+		{"input", "-hwaccel cuda -i /etc/passwd", nil, true},
+		{"output", "-hwaccel cuda -y /tmp/x.mp4", nil, true},
+		{"device", "-hwaccel vaapi -hwaccel_device ../x", nil, true},
+		{"repeated", "-hwaccel cuda -hwaccel vaapi", nil, true},
+		{"no hwaccel", "-hwaccel_device 0", nil, true},
+		{"odd", "-hwaccel", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := HWAccelArgs(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("HWAccelArgs() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("HWAccelArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_mergeYaml(t *testing.T) {
+	orig := `# the instance
+AutoStart: true # start the adds
+defaults: &rate 1MB
+
+# the speed
+UploadRate: *rate
+downloadrate: low # slow
+TrackerList: |
+  udp://a
+
+#SeedTime stops the tasks
+SeedTime: 24h
+MetadataTimeout: 0
+`
+	c := Config{
+		AutoStart:    true,
+		UploadRate:   "1MB",
+		DownloadRate: "high",
+		TrackerList:  "udp://a\nudp://b\n",
+		SeedTime:     24 * time.Hour,
+		RssURL:       "http://feed",
+	}
+	got, err := mergeYaml([]byte(orig), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# the instance
+AutoStart: true # start the adds
+defaults: &rate 1MB
+
+# the speed
+UploadRate: *rate
+downloadrate: high # slow
+TrackerList: |
+  udp://a
+  udp://b
+
+#SeedTime stops the tasks
+SeedTime: 24h
+MetadataTimeout: 0
+RssURL: http://feed
+`
+	if string(got) != want {
+		t.Errorf("mergeYaml() = %s, want %s", got, want)
+	}
+
+	if _, err := mergeYaml([]byte("- a\n"), &c); err == nil {
+		t.Error("mergeYaml() of a list, want error")
+	}
+}
+
+func TestFileConfig(t *testing.T) {
+	defer func(d Config, f map[string]bool) {
+		configDefaults, envConfigFields = d, f
+	}(configDefaults, envConfigFields)
+	configDefaults = Config{DownloadDirectory: "/downloads", SeedTime: time.Hour}
+	envConfigFields = map[string]bool{"DownloadDirectory": true, "SeedTime": true}
+
+	c := &Config{DownloadDirectory: "/srv/env", SeedTime: 5 * time.Minute, UploadRate: "1MB"}
+	fc := c.fileConfig([]byte("SeedTime: 24h\nUploadRate: low\n"), "yaml")
+	if fc.SeedTime != 24*time.Hour || fc.DownloadDirectory != "/downloads" || fc.UploadRate != "1MB" {
+		t.Errorf("fileConfig() = %+v", fc)
+	}
+	if c.DownloadDirectory != "/srv/env" || c.SeedTime != 5*time.Minute {
+		t.Errorf("fileConfig() changed the config %+v", c)
+	}
+	if fc := c.fileConfig(nil, "yaml"); fc.SeedTime != time.Hour {
+		t.Errorf("fileConfig() without a file SeedTime = %v, want the default", fc.SeedTime)
+	}
+}
@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const taskAfterExt = ".after"
+
+var ErrInvalidDependency = errors.New("Invalid task dependency")
+
+// afterFileName is saved in the cache dir next to the task's torrent file,
+// the infohash of the task it waits for
+func (e *Engine) afterFileName(infohash string) string {
+	return filepath.Join(e.cacheDir, fmt.Sprintf("%s%s%s", cacheSavedPrefix, infohash, taskAfterExt))
+}
+
+// dependency is the task the task waits for to complete, empty for none
+func (e *Engine) dependency(infohash string) string {
+	data, err := ioutil.ReadFile(e.afterFileName(infohash))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// loadDependency restores the dependency of a new task
+func (e *Engine) loadDependency(t *Torrent) {
+	t.After = e.dependency(t.InfoHash)
+}
+
+func (e *Engine) removeDependency(infohash string) {
+	if err := os.Remove(e.afterFileName(infohash)); err != nil && !os.IsNotExist(err) {
+		log.Println("[Depend]", infohash, err)
+	}
+}
+
+// checkDependency tells if the task can wait for after, empty for none
+func (e *Engine) checkDependency(infohash, after string) error {
+	if after == "" {
+		return nil
+	}
+	if after == infohash {
+		return fmt.Errorf("%w: a task can't wait for itself", ErrInvalidDependency)
+	}
+	if !e.isTaskInList(after) {
+		return fmt.Errorf("%w: no task %s", ErrInvalidDependency, after)
+	}
+	return nil
+}
+
+// dependencyAdded saves the dependency asked by the add, before the task is
+// upserted which loads it
+func (e *Engine) dependencyAdded(infohash string, opt AddOptions) {
+	if opt.After == "" {
+		return
+	}
+	if err := ioutil.WriteFile(e.afterFileName(infohash), []byte(opt.After), 0644); err != nil {
+		log.Println("[Depend]", infohash, err)
+	}
+}
+
+// dependencyMet tells if the task after completed, or was removed
+func (e *Engine) dependencyMet(after string) bool {
+	e.RLock()
+	t, err := e.getTorrent(after)
+	e.RUnlock()
+	if err != nil {
+		// removed, unless it's a cached task still to be restored
+		_, terr := os.Stat(e.TorrentCacheFileName(after))
+		_, merr := os.Stat(filepath.Join(e.cacheDir, fmt.Sprintf("%s%s.info", cacheSavedPrefix, after)))
+		return terr != nil && merr != nil
+	}
+	t.Lock()
+	defer t.Unlock()
+	return t.Done
+}
+
+// waitingDependency tells if the task waits for another to complete. The
+// dependency is dropped once met, so the task is added as usual afterwards.
+func (e *Engine) waitingDependency(infohash string) bool {
+	after := e.dependency(infohash)
+	if after == "" {
+		return false
+	}
+	if !e.dependencyMet(after) {
+		return true
+	}
+	e.removeDependency(infohash)
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err == nil {
+		t.Lock()
+		t.After = ""
+		t.Unlock()
+	}
+	log.Printf("[Depend]%s %s completed, released", infohash, after)
+	return false
+}
+
+// popWaitTask pops the first queued task not waiting for another task
+func (e *Engine) popWaitTask() (taskElem, bool) {
+	for _, v := range e.waitList.Values() {
+		te := v.(taskElem)
+		if after := e.dependency(te.ih); after != "" && !e.dependencyMet(after) {
+			continue
+		}
+		if e.waitList.Remove(te.ih) {
+			return te, true
+		}
+	}
+	return taskElem{}, false
+}
+
+// releaseDependents adds the queued tasks waiting for the task, as the
+// MaxConcurrentTask allows, after it completed or was removed
+func (e *Engine) releaseDependents(infohash string) {
+	var n int
+	for _, v := range e.waitList.Values() {
+		if e.dependency(v.(taskElem).ih) == infohash {
+			n++
+		}
+	}
+	for ; n > 0; n-- {
+		if err := e.NextWaitTask(); err != nil {
+			return
+		}
+	}
+}
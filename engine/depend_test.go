@@ -0,0 +1,55 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_popWaitTask(t *testing.T) {
+	const (
+		parent  = "1111111111111111111111111111111111111111"
+		child   = "2222222222222222222222222222222222222222"
+		waiting = "3333333333333333333333333333333333333333"
+	)
+	e := &Engine{ts: make(map[string]*Torrent), cacheDir: t.TempDir(), waitList: NewSyncList()}
+	e.ts[parent] = &Torrent{InfoHash: parent}
+	e.dependencyAdded(child, AddOptions{After: parent})
+	e.pushWaitTask(child, taskTorrent)
+	e.pushWaitTask(waiting, taskMagnet)
+
+	if te, ok := e.popWaitTask(); !ok || te.ih != waiting {
+		t.Fatalf("popWaitTask() = %v, %v, want the task not waiting", te, ok)
+	}
+	if _, ok := e.popWaitTask(); ok {
+		t.Fatal("popWaitTask() popped a task waiting for another")
+	}
+
+	e.ts[parent].Done = true
+	if te, ok := e.popWaitTask(); !ok || te.ih != child {
+		t.Fatalf("popWaitTask() = %v, %v, want the task released", te, ok)
+	}
+	if e.waitingDependency(child) || e.dependency(child) != "" {
+		t.Error("dependency kept after met")
+	}
+}
+
+func TestEngine_checkDependency(t *testing.T) {
+	const ih = "1111111111111111111111111111111111111111"
+	e := &Engine{ts: map[string]*Torrent{ih: {InfoHash: ih}}}
+	tests := []struct {
+		name    string
+		after   string
+		wantErr bool
+	}{
+		{"none", "", false},
+		{"existing", ih, false},
+		{"itself", "2222222222222222222222222222222222222222", true},
+		{"missing", "3333333333333333333333333333333333333333", true},
+	}
+	for _, tt := range tests {
+		err := e.checkDependency("2222222222222222222222222222222222222222", tt.after)
+		if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrInvalidDependency)) {
+			t.Errorf("%s: checkDependency() = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
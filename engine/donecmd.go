@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// DoneCmdRoutes pick the program called on a task finished by the task, one
+// route each line, the first matched is called instead of the DoneCmd:
+//
+//	<condition> [and <condition>...] => <command>
+//
+// with the conditions of the Hooks, eg.
+//
+//	label == tv => /opt/scripts/sonarr-notify.sh
+//	tracker == linuxtracker.org => /opt/scripts/rsync-iso.sh
+//
+// The tasks matching no route call the DoneCmd, if set.
+
+type doneCmdRoute struct {
+	conds []hookCond
+	cmd   string
+}
+
+// doneCmdRouter keeps the routes apart from the engine lock, as they are
+// read by the tasks with their own lock held
+type doneCmdRouter struct {
+	sync.RWMutex
+	routes []*doneCmdRoute
+}
+
+func (r *doneCmdRouter) set(routes []*doneCmdRoute) {
+	r.Lock()
+	defer r.Unlock()
+	r.routes = routes
+}
+
+func (r *doneCmdRouter) route(ht hookTarget) string {
+	r.RLock()
+	defer r.RUnlock()
+	return routeDoneCmd(r.routes, ht)
+}
+
+// ParseDoneCmdRoutes parses the DoneCmd routes, one each line
+func ParseDoneCmdRoutes(conf string) ([]*doneCmdRoute, error) {
+	var routes []*doneCmdRoute
+	for n, l := range strings.Split(conf, "\n") {
+		line := strings.TrimSpace(l)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r, err := parseDoneCmdRoute(line)
+		if err != nil {
+			return nil, fmt.Errorf("donecmd routes line %d: %w", n+1, err)
+		}
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+func parseDoneCmdRoute(line string) (*doneCmdRoute, error) {
+	cc := strings.SplitN(line, "=>", 2)
+	if len(cc) != 2 {
+		return nil, errors.New("missing =>")
+	}
+	r := &doneCmdRoute{cmd: unquote(cc[1])}
+	if r.cmd == "" {
+		return nil, errors.New("missing command")
+	}
+	for _, cs := range strings.Split(cc[0], " and ") {
+		c, err := parseHookCond(strings.TrimSpace(cs))
+		if err != nil {
+			return nil, err
+		}
+		r.conds = append(r.conds, c)
+	}
+	return r, nil
+}
+
+// routeDoneCmd is the command of the first route matching the task, empty
+// if none matched
+func routeDoneCmd(routes []*doneCmdRoute, ht hookTarget) string {
+	for _, r := range routes {
+		matched := true
+		for _, c := range r.conds {
+			if !c.match(&ht) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return r.cmd
+		}
+	}
+	return ""
+}
@@ -0,0 +1,121 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// the smaller files like nfo and txt are too common to tell a duplicate
+const duplicateMinFileSize = 1 << 20
+
+var ErrDuplicate = errors.New("Duplicate task")
+
+// Duplicate tells why a new task looks like one already added
+type Duplicate struct {
+	InfoHash string
+	// the task of the same infohash
+	Existing string `json:",omitempty"`
+	// the tasks having files of the same names and sizes
+	SameFilesAs []string `json:",omitempty"`
+	// the files of the same names and sizes in the DownloadDirectory
+	FilesOnDisk []string `json:",omitempty"`
+}
+
+func (d *Duplicate) Error() string {
+	switch {
+	case d.Existing != "":
+		return fmt.Sprintf("%s: %s already added", ErrDuplicate, d.Existing)
+	case len(d.SameFilesAs) > 0:
+		return fmt.Sprintf("%s: same files as %s", ErrDuplicate, strings.Join(d.SameFilesAs, ", "))
+	}
+	return fmt.Sprintf("%s: %d files already in the download directory", ErrDuplicate, len(d.FilesOnDisk))
+}
+
+func (d *Duplicate) Unwrap() error {
+	return ErrDuplicate
+}
+
+// checkDuplicate tells if the task added looks like a duplicate, by the
+// infohash, or the files of the torrents. Only the adds by the user (from
+// the web, the watcher, the RSS) are checked, the restores of the cached
+// tasks aren't, as well as the adds forced.
+func (e *Engine) checkDuplicate(spec *torrent.TorrentSpec, opt AddOptions) error {
+	if opt.Source == "" || opt.Force {
+		return nil
+	}
+	var info *metainfo.Info
+	if len(spec.InfoBytes) > 0 {
+		info = new(metainfo.Info)
+		if err := bencode.Unmarshal(spec.InfoBytes, info); err != nil {
+			return err
+		}
+	}
+	if d := e.findDuplicate(spec.InfoHash.HexString(), info); d != nil {
+		return d
+	}
+	return nil
+}
+
+// findDuplicate returns nil if no duplicate found
+func (e *Engine) findDuplicate(ih string, info *metainfo.Info) *Duplicate {
+	d := &Duplicate{InfoHash: ih}
+	e.RLock()
+	if _, ok := e.ts[ih]; ok {
+		d.Existing = ih
+	}
+	e.RUnlock()
+	if d.Existing != "" {
+		return d
+	}
+	if info == nil {
+		return nil
+	}
+
+	// the paths relative to the task dir, same as File.Path
+	files := make(map[string]int64)
+	for _, fi := range info.UpvertedFiles() {
+		if fi.Length < duplicateMinFileSize {
+			continue
+		}
+		p := info.Name
+		if len(info.Files) > 0 {
+			p = strings.Join(append([]string{info.Name}, fi.Path...), "/")
+		}
+		files[p] = fi.Length
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	e.RLock()
+	for tih, t := range e.ts {
+		t.Lock()
+		for _, f := range t.Files {
+			if size, ok := files[f.Path]; ok && size == f.Size {
+				d.SameFilesAs = append(d.SameFilesAs, tih)
+				break
+			}
+		}
+		t.Unlock()
+	}
+	e.RUnlock()
+
+	for p, size := range files {
+		st, err := os.Stat(filepath.Join(e.config.DownloadDirectory, filepath.FromSlash(p)))
+		if err == nil && !st.IsDir() && st.Size() == size {
+			d.FilesOnDisk = append(d.FilesOnDisk, p)
+		}
+	}
+
+	if len(d.SameFilesAs) == 0 && len(d.FilesOnDisk) == 0 {
+		return nil
+	}
+	return d
+}
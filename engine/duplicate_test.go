@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestEngine_checkDuplicate(t *testing.T) {
+	infoBytes, err := bencode.Marshal(metainfo.Info{
+		Name:        "big.iso",
+		PieceLength: 1 << 20,
+		Length:      2 << 20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ih := metainfo.HashBytes(infoBytes)
+	other := metainfo.NewHashFromHex("0123456789abcdef0123456789abcdef01234567")
+	web := AddOptions{Source: AddSourceWeb}
+
+	tests := []struct {
+		name string
+		spec *torrent.TorrentSpec
+		opt  AddOptions
+		// the task existing, and its files
+		task  metainfo.Hash
+		files []*File
+		// the file in the download dir
+		onDisk  int64
+		wantDup bool
+	}{
+		{"new", &torrent.TorrentSpec{InfoHash: ih, InfoBytes: infoBytes}, web, other, nil, 0, false},
+		{"same infohash", &torrent.TorrentSpec{InfoHash: ih}, web, ih, nil, 0, true},
+		{"same infohash forced", &torrent.TorrentSpec{InfoHash: ih}, AddOptions{Source: AddSourceWeb, Force: true}, ih, nil, 0, false},
+		{"restored", &torrent.TorrentSpec{InfoHash: ih}, AddOptions{}, ih, nil, 0, false},
+		{"same files", &torrent.TorrentSpec{InfoHash: ih, InfoBytes: infoBytes}, web, other,
+			[]*File{{Path: "big.iso", Size: 2 << 20}}, 0, true},
+		{"other size", &torrent.TorrentSpec{InfoHash: ih, InfoBytes: infoBytes}, web, other,
+			[]*File{{Path: "big.iso", Size: 3 << 20}}, 0, false},
+		{"on disk", &torrent.TorrentSpec{InfoHash: ih, InfoBytes: infoBytes}, AddOptions{Source: AddSourceWatch}, other, nil, 2 << 20, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Engine{ts: map[string]*Torrent{
+				tt.task.HexString(): {InfoHash: tt.task.HexString(), Files: tt.files},
+			}}
+			e.config.DownloadDirectory = t.TempDir()
+			if tt.onDisk > 0 {
+				fn := filepath.Join(e.config.DownloadDirectory, "big.iso")
+				if err := ioutil.WriteFile(fn, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Truncate(fn, tt.onDisk); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := e.checkDuplicate(tt.spec, tt.opt)
+			if errors.Is(err, ErrDuplicate) != tt.wantDup {
+				t.Errorf("checkDuplicate() = %v, want duplicate %v", err, tt.wantDup)
+			}
+		})
+	}
+}
@@ -1,12 +1,16 @@
 package engine
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
+	"path/filepath"
 	"strconv"
 	"sync"
 	"time"
@@ -16,6 +20,7 @@ import (
 	"github.com/anacrolix/torrent/metainfo"
 	"github.com/anacrolix/torrent/storage"
 	"github.com/boypt/simple-torrent/common"
+	"github.com/boypt/simple-torrent/plugin"
 	"github.com/fsnotify/fsnotify"
 )
 
@@ -30,46 +35,151 @@ const (
 )
 
 var (
-	ErrTaskExists    = errors.New("Task already exists")
-	ErrWaitListEmpty = errors.New("Wait list empty")
-	ErrMaxConnTasks  = errors.New("Max conncurrent task reached")
+	ErrTaskExists     = errors.New("Task already exists")
+	ErrWaitListEmpty  = errors.New("Wait list empty")
+	ErrMaxConnTasks   = errors.New("Max conncurrent task reached")
+	ErrMissingTorrent = errors.New("Missing torrent")
 )
 
 //the Engine Cloud Torrent engine, backed by anacrolix/torrent
 type Engine struct {
-	sync.RWMutex // race condition on ts,client
-	taskMutex    sync.Mutex
-	cld          Server
-	cacheDir     string
-	trashDir     string
-	client       *torrent.Client
-	closeSync    chan struct{}
-	config       Config
-	ts           map[string]*Torrent
-	TsChanged    chan struct{}
-	Trackers     []string
-	waitList     *syncList
+	sync.RWMutex  // race condition on ts,client
+	taskMutex     sync.Mutex
+	cld           Server
+	cacheDir      string
+	trashDir      string
+	client        *torrent.Client
+	closeSync     chan struct{}
+	config        Config
+	ts            map[string]*Torrent
+	TsChanged     chan struct{}
+	Trackers      []string
+	trackerStat   TrackerStat
+	waitList      *syncList
+	jobs          *jobRunner
+	rulesMu       sync.RWMutex // hooks, notifyRoutes and quietHours
+	hooks         []*hookRule
+	doneCmdRoutes doneCmdRouter
+	notifyRoutes  []*notifyRoute
+	adds          addStates
+	schedules     scheduleState
+	renames       renameState
+	dataStorage   *remapStorage
+	quietHours    *quietHours
+	digest        quietDigest
+	plugins       *plugin.Manager
+	useMmap       bool
+	owner         *fileOwner
+	restart       restartState
+	dedupe        dedupeState
+	failed        failedList
+	maintenance   maintenanceState
+	watchdog      watchdogState
+	volume        volumeState
+	scrub         scrubState
+	autoTune      autoTuneState
+	qos           qosState
+	groups        groupState
+	quotas        quotaState
+	removed       removedHistory
+	rss           rssHistory
+	rates         rateState
+	feed          eventFeed
+	guard         guardState
+	shares        shareState
+	publish       publishState
+	previews      previewState
+	background    backgroundState
+	dns           dnsState
+	speedTest     speedTestState
+	transferQuota transferQuotaState
+	policies      policyState
 	//file watcher
 	watcher *fsnotify.Watcher
 }
 
 func New(s Server) *Engine {
-	return &Engine{
+	e := &Engine{
 		ts:        make(map[string]*Torrent),
 		cld:       s,
 		waitList:  NewSyncList(),
 		TsChanged: make(chan struct{}, 1),
 	}
+	e.jobs = newJobRunner(e)
+	go e.scheduleRoutine()
+	go e.watchdogRoutine()
+	go e.scrubRoutine()
+	go e.autoTuneRoutine()
+	go e.qosRoutine()
+	go e.mqttRoutine()
+	go e.quotaRoutine()
+	go e.backgroundRoutine()
+	e.AddBackgroundTask("volume", func() time.Duration { return volumeTick }, e.volumeTask)
+	e.AddBackgroundTask("trackers", e.trackerRefreshInterval, e.trackerRefreshTask)
+	e.AddBackgroundTask("watchdir", func() time.Duration { return 0 }, e.watchRescanTask)
+	e.AddBackgroundTask("imap", e.imapInterval, e.imapTask)
+	e.AddBackgroundTask("remoteimports", e.remoteImportInterval, e.remoteImportTask)
+	e.AddBackgroundTask("metrics", e.metricsInterval, e.metricsTask)
+	e.AddBackgroundTask("transferquota", e.transferQuotaInterval, e.transferQuotaTask)
+	e.AddBackgroundTask("policies", e.policiesInterval, e.policiesTask)
+	return e
 }
 
 func (e *Engine) Config() Config {
+	e.RLock()
+	defer e.RUnlock()
 	return e.config
 }
 
 func (e *Engine) SetConfig(c *Config) {
+	// parsed out of the lock, the readers of the config never wait on them
+	hooks, err := ParseHooks(c.Hooks)
+	if err != nil {
+		log.Println("[SetConfig] hooks unchanged,", err)
+	}
+	if routes, err := ParseDoneCmdRoutes(c.DoneCmdRoutes); err == nil {
+		e.doneCmdRoutes.set(routes)
+	} else {
+		log.Println("[SetConfig] donecmd routes unchanged,", err)
+	}
+	notifyRoutes, notifyErr := ParseNotifications(c.Notifications)
+	if notifyErr != nil {
+		log.Println("[SetConfig] notifications unchanged,", notifyErr)
+	}
+	quiet, quietErr := c.quietHours()
+	if quietErr != nil {
+		log.Println("[SetConfig] quiet hours unchanged,", quietErr)
+	}
+	if r, err := NewResolver(c.DNSResolver); err == nil {
+		e.setResolver(r)
+	} else {
+		log.Println("[SetConfig] DNS resolver unchanged,", err)
+	}
+	e.setFileGuard(c)
+
+	e.Lock()
+	defer e.Unlock()
+	e.rulesMu.Lock()
+	defer e.rulesMu.Unlock()
+	if err == nil {
+		e.hooks = hooks
+	}
+	if notifyErr == nil {
+		e.notifyRoutes = notifyRoutes
+	}
+	if quietErr == nil {
+		e.quietHours = quiet
+	}
 	e.config = *c
 }
 
+// hookRules is the rules of the Hooks, read out of the engine lock
+func (e *Engine) hookRules() []*hookRule {
+	e.rulesMu.RLock()
+	defer e.rulesMu.RUnlock()
+	return e.hooks
+}
+
 func (e *Engine) Configure(c *Config) error {
 	//recieve config
 	if c.IncomingPort <= 0 {
@@ -78,6 +188,73 @@ func (e *Engine) Configure(c *Config) error {
 	if c.TrackerList == "" {
 		c.TrackerList = "remote:" + defaultTrackerListURL
 	}
+	hooks, err := ParseHooks(c.Hooks)
+	if err != nil {
+		return err
+	}
+	doneCmdRoutes, err := ParseDoneCmdRoutes(c.DoneCmdRoutes)
+	if err != nil {
+		return err
+	}
+	notifyRoutes, err := ParseNotifications(c.Notifications)
+	if err != nil {
+		return err
+	}
+	quiet, err := c.quietHours()
+	if err != nil {
+		return err
+	}
+	owner, err := newFileOwner(c)
+	if err != nil {
+		return err
+	}
+	guard, err := parseFileGuard(c)
+	if err != nil {
+		return err
+	}
+	resolver, err := NewResolver(c.DNSResolver)
+	if err != nil {
+		return err
+	}
+	if err := checkIPPreference(c.PeerIPPreference); err != nil {
+		return err
+	}
+	// failing once the old client is closed would leave none
+	if err := applyAdvanced(torrent.NewDefaultClientConfig(), c.Advanced); err != nil {
+		return err
+	}
+	if c.ProxyURL != "" {
+		if _, err := c.resolvedProxyURL(); err != nil {
+			return err
+		}
+	}
+	ipv4, ipv6 := true, !c.DisableIPv6
+	if ipv6 {
+		ipv4, ipv6 = peerFamilies(c.PeerIPPreference)
+		if !ipv4 || !ipv6 {
+			log.Printf("[Configure] peers over IPv4: %v, IPv6: %v, by the PeerIPPreference %q", ipv4, ipv6, c.PeerIPPreference)
+		}
+	}
+
+	// the old client is closed out of the lock, the engine keeps answering
+	// meanwhile
+	e.Lock()
+	old := e.client
+	if old != nil {
+		close(e.closeSync)
+		e.client = nil
+		e.ts = make(map[string]*Torrent)
+	}
+	e.Unlock()
+	if old != nil {
+		// stop all current torrents
+		for _, t := range old.Torrents() {
+			t.Drop()
+		}
+		old.Close()
+		log.Println("Configure: old client closed")
+		time.Sleep(3 * time.Second)
+	}
 
 	e.Lock()
 	defer e.Unlock()
@@ -86,12 +263,14 @@ func (e *Engine) Configure(c *Config) error {
 	tc.DisableUTP = c.DisableUTP
 	tc.ListenPort = c.IncomingPort
 	tc.DataDir = c.DownloadDirectory
+	e.owner = owner
 
+	e.useMmap = false
 	if !(e.cld.GetBoolAttribute("DisableMmap")) {
 		// enable MMap on 64bit machines
 		if strconv.IntSize == 64 {
 			log.Println("[Configure] 64bit arch detected, using MMap for storage")
-			tc.DefaultStorage = storage.NewMMap(tc.DataDir)
+			e.useMmap = true
 		}
 	} else {
 		log.Println("[Configure] mmap disabled")
@@ -103,33 +282,40 @@ func (e *Engine) Configure(c *Config) error {
 	tc.Debug = c.EngineDebug
 	tc.NoUpload = !c.EnableUpload
 	tc.Seed = c.EnableSeeding
-	tc.UploadRateLimiter = c.UploadLimiter()
-	tc.DownloadRateLimiter = c.DownloadLimiter()
+	tc.UploadRateLimiter, tc.DownloadRateLimiter = e.rateLimiters(c)
 	tc.HeaderObfuscationPolicy = torrent.HeaderObfuscationPolicy{
 		Preferred:        c.ObfsPreferred,
 		RequirePreferred: c.ObfsRequirePreferred,
 	}
 	tc.DisableTrackers = c.DisableTrackers
-	tc.DisableIPv6 = c.DisableIPv6
+	tc.DisableIPv6 = !ipv6
+	tc.DisableIPv4 = !ipv4
+	tc.DisableWebseeds = c.DisableWebseeds
+	tc.LookupTrackerIp = e.lookupTrackerIP
+	if err := applyAdvanced(tc, c.Advanced); err != nil {
+		return err
+	}
 	if c.ProxyURL != "" {
+		proxyURL, err := c.resolvedProxyURL()
+		if err != nil {
+			return err
+		}
 		tc.HTTPProxy = func(*http.Request) (*url.URL, error) {
-			return url.Parse(c.ProxyURL)
+			return url.Parse(proxyURL)
 		}
 	}
 
 	{
-		if e.client != nil {
-			// stop all current torrents
-			for _, t := range e.client.Torrents() {
-				t.Drop()
+		if e.dataStorage != nil {
+			if err := e.dataStorage.Close(); err != nil {
+				log.Println("[Configure] close storage", err)
 			}
-			e.client.Close()
-			close(e.closeSync)
-			log.Println("Configure: old client closed")
-			e.client = nil
-			e.ts = make(map[string]*Torrent)
-			time.Sleep(3 * time.Second)
 		}
+		e.resetRenames()
+		e.resetShares()
+		e.resetPublished()
+		e.dataStorage = e.newDataStorage(tc.DataDir)
+		tc.DefaultStorage = wrapStorage(e.dataStorage, tc.DataDir, owner)
 
 		// runtime reconfigure need to retry while creating client,
 		// wait max for 3 * 10 seconds
@@ -150,11 +336,25 @@ func (e *Engine) Configure(c *Config) error {
 	}
 
 	e.closeSync = make(chan struct{})
-	e.cacheDir = path.Join(c.DownloadDirectory, CachedTorrentDir)
-	e.trashDir = path.Join(c.DownloadDirectory, TrashTorrentDir)
+	instance := e.cld.GetStrAttribute("Instance")
+	e.cacheDir = path.Join(c.DownloadDirectory, namespaced(CachedTorrentDir, instance))
+	e.trashDir = path.Join(c.DownloadDirectory, namespaced(TrashTorrentDir, instance))
 	mkdir(e.cacheDir)
 	mkdir(e.trashDir)
 	e.config = *c
+	e.doneCmdRoutes.set(doneCmdRoutes)
+	e.rulesMu.Lock()
+	e.hooks = hooks
+	e.notifyRoutes = notifyRoutes
+	e.quietHours = quiet
+	e.rulesMu.Unlock()
+	e.guard.Lock()
+	e.guard.guard = guard
+	e.guard.Unlock()
+	e.setResolver(resolver)
+	if e.plugins == nil {
+		e.plugins = plugin.Load(c.Plugins)
+	}
 	return nil
 }
 
@@ -165,50 +365,91 @@ func (e *Engine) IsConfigred() bool {
 }
 
 // NewMagnet -> newTorrentBySpec
-func (e *Engine) NewMagnet(magnetURI string) error {
+func (e *Engine) NewMagnet(magnetURI string, opts ...AddOptions) (err error) {
 	log.Println("[NewMagnet] called:", magnetURI)
+	defer func(input string) {
+		e.RecordFailedAdd(FailedMagnet, input, nil, err)
+	}(magnetURI)
+	magnetURI, err = normalizeMagnet(magnetURI)
+	if err != nil {
+		return err
+	}
 	spec, err := torrent.TorrentSpecFromMagnetUri(magnetURI)
 	if err != nil {
 		return err
 	}
+	if err := e.checkDuplicate(spec, addOptions(opts)); err != nil {
+		return err
+	}
 	e.newMagnetCacheFile(magnetURI, spec.InfoHash.HexString())
-	return e.newTorrentBySpec(spec, taskMagnet)
+	return e.newTorrentBySpec(spec, taskMagnet, addOptions(opts))
 }
 
 // NewTorrentByReader -> newTorrentBySpec
-func (e *Engine) NewTorrentByReader(r io.Reader) error {
-	info, err := metainfo.Load(r)
+func (e *Engine) NewTorrentByReader(r io.Reader, opts ...AddOptions) (err error) {
+	data, err := ioutil.ReadAll(r)
 	if err != nil {
 		return err
 	}
+	name := "torrent file"
+	defer func() {
+		e.RecordFailedAdd(FailedTorrent, name, data, err)
+	}()
+	info, err := metainfo.Load(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if err := checkV2Only(info.InfoBytes); err != nil {
+		return err
+	}
 	spec := torrent.TorrentSpecFromMetaInfo(info)
+	name = spec.DisplayName
+	if err := e.checkDuplicate(spec, addOptions(opts)); err != nil {
+		return err
+	}
 	e.newTorrentCacheFile(info)
-	return e.newTorrentBySpec(spec, taskTorrent)
+	return e.newTorrentBySpec(spec, taskTorrent, addOptions(opts))
 }
 
 // NewTorrentByFilePath -> newTorrentBySpec
-func (e *Engine) NewTorrentByFilePath(path string) error {
+func (e *Engine) NewTorrentByFilePath(path string, opts ...AddOptions) (err error) {
+	defer func() {
+		if err != nil {
+			e.RecordFailedAdd(FailedFile, path, readTorrentFile(path), err)
+		}
+	}()
 	// torrent.TorrentSpecFromMetaInfo may panic if the info is malformed
-	defer func() error {
+	defer func() {
 		if r := recover(); r != nil {
-			err := fmt.Errorf("Error loading new torrent from file %s: %+v", path, r)
+			err = fmt.Errorf("Error loading new torrent from file %s: %+v", path, r)
 			log.Println(err)
-			return err
 		}
-		return nil
-	}() // nolint: errcheck
+	}()
 
 	info, err := metainfo.LoadFromFile(path)
 	if err != nil {
 		return err
 	}
-	e.newTorrentCacheFile(info)
+	if err := checkV2Only(info.InfoBytes); err != nil {
+		return err
+	}
 	spec := torrent.TorrentSpecFromMetaInfo(info)
-	return e.newTorrentBySpec(spec, taskTorrent)
+	if err := e.checkDuplicate(spec, addOptions(opts)); err != nil {
+		return err
+	}
+	e.newTorrentCacheFile(info)
+	return e.newTorrentBySpec(spec, taskTorrent, addOptions(opts))
 }
 
 func (e *Engine) isReadyAddTask() bool {
-	nowTorrentsLen := len(e.client.Torrents())
+	e.RLock()
+	client := e.client
+	e.RUnlock()
+	if client == nil {
+		// reconfiguring, queued till the new client
+		return false
+	}
+	nowTorrentsLen := len(client.Torrents())
 	if e.config.MaxConcurrentTask > 0 && nowTorrentsLen >= e.config.MaxConcurrentTask {
 		return false
 	}
@@ -216,64 +457,190 @@ func (e *Engine) isReadyAddTask() bool {
 }
 
 // NewTorrentBySpec -> *Torrent -> addTorrentTask
-func (e *Engine) newTorrentBySpec(spec *torrent.TorrentSpec, taskT taskType) error {
+func (e *Engine) newTorrentBySpec(spec *torrent.TorrentSpec, taskT taskType, opt AddOptions) error {
 	ih := spec.InfoHash.HexString()
 	log.Println("[newTorrentBySpec] called", ih)
 
+	hres := runHooks(e.hookRules(), hookOnAdd, hookTargetFromSpec(spec))
+	if hres.reject {
+		log.Println("[newTorrentBySpec] rejected by hook", ih)
+		e.removeMagnetCache(ih)
+		e.removeTorrentCache(ih, false)
+		return ErrRejectedByHook
+	}
+
+	if err := checkSchedule(opt.StartAt); err != nil {
+		return err
+	}
+	if err := checkGroupName(opt.Group); err != nil {
+		return err
+	}
+	if err := e.checkDependency(ih, opt.After); err != nil {
+		return err
+	}
+	if err := e.checkQuota(spec, hres.labels, opt); err != nil {
+		return err
+	}
+	if err := e.checkBlocked(spec); err != nil {
+		return err
+	}
+
 	e.taskMutex.Lock()
 	defer e.taskMutex.Unlock()
+	e.scheduleAdded(ih, opt)
+	e.groupAdded(ih, opt)
+	e.dependencyAdded(ih, opt)
+	e.userAdded(ih, opt)
+	e.excludeAdded(ih, opt)
+	// queued until the task it waits for completes
+	if e.waitingDependency(ih) {
+		if !e.isTaskInList(ih) {
+			log.Printf("[newTorrentBySpec] waiting for %s to complete, add as pretask: %s %v", e.dependency(ih), ih, taskT)
+			e.pushWaitTask(ih, taskT)
+			e.queueAddState(ih, opt)
+			e.queueResume(ih)
+		}
+		t, err := e.upsertTorrent(ih, spec.DisplayName, true) // show queueing task
+		common.FancyHandleError(err)
+		t.Labels = hres.labels
+		return nil
+	}
 	// whether add as pretasks
 	if !e.isReadyAddTask() {
 		if !e.isTaskInList(ih) {
 			log.Printf("[newTorrentBySpec] reached max task %d, add as pretask: %s %v", e.config.MaxConcurrentTask, ih, taskT)
 			e.pushWaitTask(ih, taskT)
+			e.queueAddState(ih, opt)
+			e.queueResume(ih)
 		} else {
 			log.Printf("[newTorrentBySpec] reached max task %d, task already in queue: %s %v", e.config.MaxConcurrentTask, ih, taskT)
 		}
-		_, err := e.upsertTorrent(ih, spec.DisplayName, true) // show queueing task
+		t, err := e.upsertTorrent(ih, spec.DisplayName, true) // show queueing task
 		common.FancyHandleError(err)
+		t.Labels = hres.labels
 		return ErrMaxConnTasks
 	}
 
 	t, _ := e.upsertTorrent(ih, spec.DisplayName, false)
-	tt, _, err := e.client.AddTorrentSpec(spec)
+	t.Labels = hres.labels
+	spec.Webseeds = append(spec.Webseeds, e.loadWebSeeds(ih)...)
+	t.addWebSeeds(spec.Webseeds)
+	t.specFlags = torrent.TorrentSpec{
+		DisallowDataUpload:       spec.DisallowDataUpload,
+		DisableInitialPieceCheck: spec.DisableInitialPieceCheck,
+	}
+	t.noAutoStart = hres.stop
+	switch e.addState(ih, opt) {
+	case AddPaused:
+		t.noAutoStart = true
+	case AddStarted:
+		t.forceStart = !hres.stop
+	}
+	if rt, ok := e.resumeState(ih); ok {
+		// restored by an engine restart or backup, keeps the previous state
+		t.noAutoStart = t.noAutoStart || !rt.Started
+		t.forceStart = rt.Started
+		for _, l := range rt.Labels {
+			if !hasLabel(t.Labels, l) {
+				t.Labels = append(t.Labels, l)
+			}
+		}
+	}
+	if t.ScheduledStart != nil {
+		// started by the scheduler
+		t.noAutoStart = true
+	}
+	if dir := hres.dir; dir != "" || t.selectDir != "" {
+		// the dir chosen by the preview over the hook's
+		if t.selectDir != "" {
+			dir = t.selectDir
+		}
+		if st, dir, err := e.newStorage(dir); err == nil {
+			spec.Storage = st
+			t.Directory = dir
+		} else {
+			log.Println("[newTorrentBySpec] dir ignored", err)
+		}
+	}
+	// a preview of the same torrent would hold it in the client
+	e.previews.stop(ih)
+	e.RLock()
+	client := e.client
+	e.RUnlock()
+	if client == nil {
+		return errors.New("engine not configured")
+	}
+	tt, _, err := client.AddTorrentSpec(spec)
 	if err != nil {
 		return err
 	}
+	tt.SetOnWriteChunkError(func(err error) {
+		tt.DisallowDataDownload()
+		t.setError(err)
+	})
+	if tt.Info() == nil {
+		t.MetaStage = MetaStagePeers
+		t.ownTrackers = len(tt.Metainfo().AnnounceList) > 0
+	}
 
-	meta := tt.Metainfo()
-	if len(e.Trackers) > 0 && (e.config.AlwaysAddTrackers || len(meta.AnnounceList) == 0) {
-		log.Printf("[newTorrent] added %d public trackers\n", len(e.Trackers))
-		tt.AddTrackers([][]string{e.Trackers})
+	// magnets with trackers of their own wait for the info to tell whether they are private
+	if tt.Info() == nil && len(tt.Metainfo().AnnounceList) > 0 && e.config.SkipPrivateTorrents {
+		t.waitTrackers = true
+	} else {
+		e.addPublicTrackers(tt)
 	}
 
 	go e.torrentEventProcessor(tt, t, ih)
+	e.notify(EventAdd, t, "")
 	return nil
 }
 
 func (e *Engine) torrentEventProcessor(tt *torrent.Torrent, t *Torrent, ih string) {
 
-	select {
-	case <-e.closeSync:
-		log.Println("Engine shutdown while waiting Info", ih)
-		tt.Drop()
-		return
-	case <-t.dropWait:
-		tt.Drop()
-		log.Println("Task Dropped while waiting Info", ih)
-		go e.NextWaitTask() // nolint: errcheck
-		return
-	case <-tt.GotInfo():
-		// Already got full torrent info
-		// If the origin is from a magnet link, remove it, cache the torrent data
-		e.removeMagnetCache(ih)
-		m := tt.Metainfo()
-		e.newTorrentCacheFile(&m)
-		t.updateOnGotInfo(tt)
-		e.TsChanged <- struct{}{}
+	metaTimeout := e.metadataTimer(tt)
+	retryTk := time.NewTicker(3 * time.Second)
+	defer retryTk.Stop()
+waitInfo:
+	for {
+		select {
+		case <-retryTk.C:
+			if metaTimeout == nil && e.retryMetadata(t) {
+				metaTimeout = e.metadataTimer(tt)
+			}
+		case <-e.closeSync:
+			log.Println("Engine shutdown while waiting Info", ih)
+			tt.Drop()
+			return
+		case <-t.dropWait:
+			tt.Drop()
+			log.Println("Task Dropped while waiting Info", ih)
+			go e.NextWaitTask() // nolint: errcheck
+			return
+		case <-metaTimeout:
+			metaTimeout = nil
+			log.Println("Metadata timeout", ih)
+			go e.fetchMetadata(tt, t)
+		case <-tt.GotInfo():
+			// Already got full torrent info
+			// If the origin is from a magnet link, remove it, cache the torrent data
+			e.removeMagnetCache(ih)
+			m := tt.Metainfo()
+			e.newTorrentCacheFile(&m)
+			e.applyExclude(t, tt)
+			e.applyGuard(t, tt)
+			t.updateOnGotInfo(tt)
+			t.Lock()
+			t.MetaStage = ""
+			t.Unlock()
+			if t.waitTrackers {
+				e.addPublicTrackers(tt)
+			}
+			e.TsChanged <- struct{}{}
+			break waitInfo
+		}
 	}
 
-	if e.config.AutoStart {
+	if (e.config.AutoStart || t.forceStart) && !t.noAutoStart {
 		go e.StartTorrent(ih) // nolint: errcheck
 	}
 
@@ -289,11 +656,17 @@ func (e *Engine) torrentEventProcessor(tt *torrent.Torrent, t *Torrent, ih strin
 			}
 			if !t.Done {
 				t.updateTorrentStatus()
+				t.updateAvailability()
 			}
 			if t.Started {
 				e.taskRoutine(t)
 			}
+			e.retryRoutine(t)
+			e.stalledRoutine(t)
+			e.swarmRoutine(t)
 			t.updateConnStat()
+			t.updateETA()
+			t.refreshStatus()
 		case <-t.dropWait:
 			tt.Drop()
 			log.Println("Task Droped, exit loop:", ih)
@@ -307,6 +680,34 @@ func (e *Engine) torrentEventProcessor(tt *torrent.Torrent, t *Torrent, ih strin
 	}
 }
 
+// newStorage creates the storage of a task saving to a directory other than
+// DownloadDirectory, relative paths are under the DownloadDirectory
+func (e *Engine) newStorage(dir string) (storage.ClientImpl, string, error) {
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(e.config.DownloadDirectory, dir)
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, "", err
+	}
+	return wrapStorage(e.newDataStorage(dir), dir, e.owner), dir, nil
+}
+
+// addPublicTrackers injects the merged tracker list to the task,
+// private torrents are skipped if SkipPrivateTorrents is set
+func (e *Engine) addPublicTrackers(tt *torrent.Torrent) {
+	trackers := e.GetTrackers()
+	meta := tt.Metainfo()
+	if len(trackers) == 0 || !(e.config.AlwaysAddTrackers || len(meta.AnnounceList) == 0) {
+		return
+	}
+	if e.config.SkipPrivateTorrents && isPrivate(tt.Info()) {
+		log.Println("[newTorrent] private torrent, skip adding public trackers", tt.InfoHash().HexString())
+		return
+	}
+	log.Printf("[newTorrent] added %d public trackers\n", len(trackers))
+	tt.AddTrackers([][]string{trackers})
+}
+
 //GetTorrents just get the local infohash->Torrent map
 func (e *Engine) GetTorrents() *map[string]*Torrent {
 	return &e.ts
@@ -319,7 +720,7 @@ func (e *Engine) taskRoutine(t *Torrent) {
 	if e.config.SeedRatio > 0 && t.SeedRatio > e.config.SeedRatio &&
 		t.Started && !t.ManualStarted && t.Done {
 		log.Printf("[TaskRoutine]%s Stopped and Drop due to reaching SeedRatio %f", t.InfoHash, t.SeedRatio)
-		go e.stopRemoveTask(t.InfoHash)
+		go e.stopRemoveTask(t.InfoHash, RemovedSeedRatio)
 	}
 
 	// stops task when there're tasks waiting after `SeedTime`
@@ -328,14 +729,13 @@ func (e *Engine) taskRoutine(t *Torrent) {
 		!t.FinishedAt.IsZero() &&
 		time.Since(t.FinishedAt) > e.config.SeedTime {
 		log.Printf("[TaskRoutine]%s Stopped and Drop due to timed up for SeedTime %s", t.InfoHash, e.config.SeedTime)
-		go e.stopRemoveTask(t.InfoHash)
+		go e.stopRemoveTask(t.InfoHash, RemovedSeedTime)
 	}
 }
 
-func (e *Engine) stopRemoveTask(ih string) {
+func (e *Engine) stopRemoveTask(ih, reason string) {
 	common.FancyHandleError(e.StopTorrent(ih))
-	e.RemoveCache(ih)
-	common.FancyHandleError(e.DeleteTorrent(ih))
+	common.FancyHandleError(e.RemoveTorrent(ih, reason))
 }
 
 func (e *Engine) ManualStartTorrent(infohash string) error {
@@ -352,13 +752,22 @@ func (e *Engine) ManualStartTorrent(infohash string) error {
 
 func (e *Engine) StartTorrent(infohash string) error {
 	log.Println("StartTorrent", infohash)
-	e.Lock()
-	defer e.Unlock()
-
+	e.RLock()
 	t, err := e.getTorrent(infohash)
+	e.RUnlock()
 	if err != nil {
 		return err
 	}
+	defer t.refreshStatus()
+	if e.inMaintenance() {
+		return ErrMaintenance
+	}
+	if e.volumeUnavailable() {
+		return ErrVolumeUnavailable
+	}
+	if e.transferQuotaExceeded() {
+		return ErrTransferQuotaExceeded
+	}
 	t.Lock()
 	defer t.Unlock()
 
@@ -367,25 +776,28 @@ func (e *Engine) StartTorrent(infohash string) error {
 	}
 	t.Started = true
 	t.StartedAt = time.Now()
+	t.RetryCount = 0
+	t.clearError()
 	for _, f := range t.Files {
 		if f != nil {
-			f.Started = true
+			f.Started = f.f == nil || t.isSelected(f.f.Path())
 		}
 	}
 	if t.t.Info() != nil {
-		t.t.DownloadAll()
+		t.downloadSelected()
 	}
 	return nil
 }
 
 func (e *Engine) StopTorrent(infohash string) error {
 	log.Println("StopTorrent", infohash)
-	e.Lock()
-	defer e.Unlock()
+	e.RLock()
 	t, err := e.getTorrent(infohash)
+	e.RUnlock()
 	if err != nil {
 		return err
 	}
+	defer t.refreshStatus()
 	t.Lock()
 	defer t.Unlock()
 
@@ -491,4 +903,14 @@ func (e *Engine) StopFile(infohash, filepath string) error {
 func (e *Engine) RemoveCache(infohash string) {
 	e.removeMagnetCache(infohash)
 	e.removeTorrentCache(infohash, true)
+	e.removeTaskMeta(infohash)
+	e.removeRenames(infohash)
+	e.removeSchedule(infohash)
+	e.removeGroup(infohash)
+	e.removeDependency(infohash)
+	go e.releaseDependents(infohash)
+	e.removeWebSeeds(infohash)
+	e.removeSwarmHistory(infohash)
+	e.removeSelection(infohash)
+	e.removePublished(infohash)
 }
@@ -87,6 +87,16 @@ func (e *Engine) removeTorrentCache(infohash string, toTrash bool) {
 	}
 }
 
+// isTaskSidecar tells if fn is a file saved along with a cached task
+func isTaskSidecar(fn string) bool {
+	for _, ext := range []string{taskMetaExt, taskRenamesExt, taskScheduleExt, taskGroupExt, taskAfterExt, taskWebSeedsExt, taskSwarmExt, taskSelectionExt} {
+		if strings.HasSuffix(fn, ext) {
+			return true
+		}
+	}
+	return false
+}
+
 func (e *Engine) TorrentCacheFileName(infohash string) string {
 	cacheFilePath := filepath.Join(e.cacheDir,
 		fmt.Sprintf("%s%s.torrent", cacheSavedPrefix, infohash))
@@ -128,6 +138,11 @@ func (e *Engine) RestoreTask(fn string) error {
 			return err
 		}
 		log.Printf("[RestoreMagnet] Restored: %s \n", fn)
+	} else if isTaskSidecar(fn) && isCachedFile {
+		// loaded along with the task
+		return nil
+	} else if filepath.Base(fn) == removedHistoryFile {
+		return nil
 	} else {
 		log.Println("Cache file doesn't match", fn)
 	}
@@ -136,6 +151,12 @@ func (e *Engine) RestoreTask(fn string) error {
 }
 
 func (e *Engine) RestoreCacheDir() {
+	e.restoreCacheDir(nil)
+}
+
+// restoreCacheDir restores the cached tasks, reporting the progress if
+// progress is not nil
+func (e *Engine) restoreCacheDir(progress func(done, total int)) {
 
 	files, err := ioutil.ReadDir(e.cacheDir)
 	if err != nil {
@@ -148,7 +169,10 @@ func (e *Engine) RestoreCacheDir() {
 		return files[i].ModTime().Before(files[j].ModTime())
 	})
 
-	for _, i := range files {
+	for n, i := range files {
+		if progress != nil {
+			progress(n, len(files))
+		}
 		if i.IsDir() {
 			continue
 		}
@@ -163,9 +187,8 @@ func (e *Engine) NextWaitTask() error {
 	}
 
 	for {
-		if elm := e.waitList.Pop(); elm != nil {
+		if te, ok := e.popWaitTask(); ok {
 			var res string
-			te := elm.(taskElem)
 			switch te.tp {
 			case taskTorrent:
 				res = fmt.Sprintf("%s%s.torrent", cacheSavedPrefix, te.ih)
@@ -179,6 +202,9 @@ func (e *Engine) NextWaitTask() error {
 				continue
 			}
 			return e.RestoreTask(fn)
+		} else if e.waitList.Len() > 0 {
+			log.Println("NextWaitTask: tasks waiting for others to complete")
+			return ErrWaitListEmpty
 		} else {
 			log.Println("NextWaitTask: wait list empty")
 			return ErrWaitListEmpty
@@ -1,9 +1,12 @@
 package engine
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -36,12 +39,21 @@ func (e *Engine) upsertTorrent(ih, name string, isQueueing bool) (*Torrent, erro
 			e:          e,
 			dropWait:   make(chan struct{}),
 		}
+		e.loadTaskMeta(torrent)
+		e.loadSchedule(torrent)
+		e.loadGroup(torrent)
+		e.loadDependency(torrent)
+		e.loadSwarmHistory(torrent)
+		e.loadSelection(torrent)
+		torrent.Name = e.displayName(ih, name)
+		torrent.updateStatus()
 		e.Lock()
 		e.ts[ih] = torrent
 		e.Unlock()
 		return torrent, nil
 	}
 	torrent.IsQueueing = isQueueing
+	torrent.refreshStatus()
 	return torrent, ErrTaskExists
 }
 
@@ -49,7 +61,7 @@ func (e *Engine) getTorrent(infohash string) (*Torrent, error) {
 	if t, ok := e.ts[infohash]; ok {
 		return t, nil
 	}
-	return nil, fmt.Errorf("Missing torrent %x", infohash)
+	return nil, fmt.Errorf("%w %x", ErrMissingTorrent, infohash)
 }
 
 func (e *Engine) deleteTorrent(infohash string) {
@@ -57,41 +69,137 @@ func (e *Engine) deleteTorrent(infohash string) {
 	e.TsChanged <- struct{}{}
 }
 
+// TrackerStat is the result of the last tracker list refresh
+type TrackerStat struct {
+	LastRefresh time.Time
+	Count       int
+	Sources     int
+	Errors      []string
+}
+
+// trackerRefreshInterval is the TrackerRefreshInterval, 0 disables
+func (e *Engine) trackerRefreshInterval() time.Duration {
+	e.RLock()
+	defer e.RUnlock()
+	return e.config.TrackerRefreshInterval
+}
+
+// trackerRefreshTask parses the TrackerList again, unless refreshed lately
+// at the start or by a config change
+func (e *Engine) trackerRefreshTask() error {
+	itv := e.trackerRefreshInterval()
+	if itv <= 0 || time.Since(e.TrackerStat().LastRefresh) < itv/2 {
+		return nil
+	}
+	return e.ParseTrackerList()
+}
+
+// ParseTrackerList merges the trackers from all the sources configured in
+// TrackerList. Each line is either a tracker url, "remote:<http url>" of a
+// remote list or "file:<path>" of a local list, lines start with "#" are ignored.
 func (e *Engine) ParseTrackerList() error {
 	conf := e.config.TrackerList
 
-	trackers := []string{}
+	var trackers, errs []string
+	var sources int
 
 	for _, l := range strings.Split(conf, "\n") {
 		line := strings.TrimSpace(l)
-		if line == "" {
+		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
 
-		if strings.HasPrefix(line, "remote:") {
-			if lst, err := fetchTxtList(line[7:]); err == nil {
-				trackers = append(trackers, lst...)
-			} else {
-				log.Println("[ParseTrackerList] ignored", err, line)
-			}
-		} else {
-			trackers = append(trackers, line)
+		var lst []string
+		var err error
+		switch {
+		case strings.HasPrefix(line, "remote:"):
+			lst, err = fetchTxtList(line[7:], e.Resolver())
+		case strings.HasPrefix(line, "file:"):
+			lst, err = readTxtList(line[5:])
+		default:
+			lst = []string{line}
+		}
+		if err != nil {
+			log.Println("[ParseTrackerList] ignored", err, line)
+			errs = append(errs, fmt.Sprintf("%s: %s", line, err))
+			continue
 		}
+		sources++
+		trackers = append(trackers, lst...)
 	}
 
-	// remove duplicated entries
-	dupMap := make(map[string]struct{})
-	for _, t := range trackers {
-		dupMap[t] = struct{}{}
-	}
-	for t := range dupMap {
-		e.Trackers = append(e.Trackers, t)
+	trackers = dedupTrackers(trackers)
+
+	e.Lock()
+	e.Trackers = trackers
+	e.trackerStat = TrackerStat{
+		LastRefresh: time.Now(),
+		Count:       len(trackers),
+		Sources:     sources,
+		Errors:      errs,
 	}
+	e.Unlock()
 
-	log.Printf("[ParseTrackerList] got %d trackers", len(e.Trackers))
+	log.Printf("[ParseTrackerList] got %d trackers from %d sources", len(trackers), sources)
+	if len(errs) > 0 {
+		return fmt.Errorf("%d tracker sources failed", len(errs))
+	}
 	return nil
 }
 
+// TrackerStat returns the result of the last tracker list refresh
+func (e *Engine) TrackerStat() TrackerStat {
+	e.RLock()
+	defer e.RUnlock()
+	return e.trackerStat
+}
+
+// GetTrackers returns the current merged tracker list
+func (e *Engine) GetTrackers() []string {
+	e.RLock()
+	defer e.RUnlock()
+	return e.Trackers
+}
+
+// MarshalTorrents encodes the tasks matched, all if match is nil, each
+// under its own lock, the engine locked only to list them
+func (e *Engine) MarshalTorrents(match func(t *Torrent) bool) ([]byte, error) {
+	e.RLock()
+	ts := make(map[string]*Torrent, len(e.ts))
+	for ih, t := range e.ts {
+		ts[ih] = t
+	}
+	e.RUnlock()
+	res := make(map[string]json.RawMessage, len(ts))
+	for ih, t := range ts {
+		t.Lock()
+		if match != nil && !match(t) {
+			t.Unlock()
+			continue
+		}
+		data, err := json.Marshal(t)
+		t.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		res[ih] = data
+	}
+	return json.Marshal(res)
+}
+
+// MarshalTorrent encodes a task under its lock
+func (e *Engine) MarshalTorrent(infohash string) ([]byte, error) {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	t.Lock()
+	defer t.Unlock()
+	return json.Marshal(t)
+}
+
 func (e *Engine) WriteStauts(_w io.Writer) {
 	e.RLock()
 	defer e.RUnlock()
@@ -109,13 +217,70 @@ func (e *Engine) ConnStat() torrent.ConnStats {
 	return torrent.ConnStats{}
 }
 
-func (e *Engine) StartTorrentWatcher() error {
-
+func (e *Engine) stopTorrentWatcher() {
 	if e.watcher != nil {
 		log.Println("Torrent Watcher: close")
 		e.watcher.Close()
 		e.watcher = nil
 	}
+}
+
+// addWatched adds a .torrent file of the WatchDirectory, removed once added
+func (e *Engine) addWatched(fn string) error {
+	if !strings.HasSuffix(fn, ".torrent") {
+		return nil
+	}
+	if st, err := os.Stat(fn); err != nil {
+		log.Println(err)
+		return err
+	} else if st.IsDir() {
+		return nil
+	}
+
+	if err := e.NewTorrentByFilePath(fn, AddOptions{Source: AddSourceWatch}); err != nil {
+		log.Printf("Torrent Watcher: fail to add %s, ERR:%#v\n", fn, err)
+		return err
+	}
+	log.Printf("Torrent Watcher: added %s, file removed\n", fn)
+	os.Remove(fn)
+	return nil
+}
+
+// watchRescanTask adds the .torrent files the watcher missed, dropped in
+// while stopped or moved in. Run on demand only, the failed ones would be
+// tried again and again.
+func (e *Engine) watchRescanTask() error {
+	if e.inMaintenance() {
+		return ErrMaintenance
+	}
+	e.RLock()
+	dir := e.config.WatchDirectory
+	e.RUnlock()
+	if dir == "" {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.torrent"))
+	if err != nil {
+		return err
+	}
+	var failed int
+	for _, fn := range files {
+		if err := e.addWatched(fn); err != nil && !errors.Is(err, ErrMaxConnTasks) {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d torrent files failed", failed, len(files))
+	}
+	return nil
+}
+
+func (e *Engine) StartTorrentWatcher() error {
+
+	e.stopTorrentWatcher()
+	if e.inMaintenance() {
+		return ErrMaintenance
+	}
 
 	if w, err := os.Stat(e.config.WatchDirectory); os.IsNotExist(err) || (err == nil && !w.IsDir()) {
 		return fmt.Errorf("[Watcher] WatchDirectory [%s] is not a dir, will not watch", e.config.WatchDirectory)
@@ -137,22 +302,7 @@ func (e *Engine) StartTorrentWatcher() error {
 				}
 				// log.Println("event:", event)
 				if event.Op&fsnotify.Write == fsnotify.Write {
-					if !strings.HasSuffix(event.Name, ".torrent") {
-						continue
-					}
-					if st, err := os.Stat(event.Name); err != nil {
-						log.Println(err)
-						continue
-					} else if st.IsDir() {
-						continue
-					}
-
-					if err := e.NewTorrentByFilePath(event.Name); err == nil {
-						log.Printf("Torrent Watcher: added %s, file removed\n", event.Name)
-						os.Remove(event.Name)
-					} else {
-						log.Printf("Torrent Watcher: fail to add %s, ERR:%#v\n", event.Name, err)
-					}
+					e.addWatched(event.Name) // nolint: errcheck
 				}
 			case err, ok := <-watcher.Errors:
 				if !ok {
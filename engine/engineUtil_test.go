@@ -0,0 +1,33 @@
+package engine
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEngine_MarshalTorrents(t *testing.T) {
+	e := &Engine{ts: map[string]*Torrent{
+		"aa": {InfoHash: "aa", Name: "seeding", Status: StatusSeeding},
+		"bb": {InfoHash: "bb", Name: "paused", Status: StatusPaused},
+	}}
+	data, err := e.MarshalTorrents(func(t *Torrent) bool { return t.Status == StatusSeeding })
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]struct{ Name string }
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got["aa"].Name != "seeding" {
+		t.Errorf("MarshalTorrents(seeding) = %s", data)
+	}
+
+	data, err = e.MarshalTorrent("bb")
+	var task struct{ Name, Status string }
+	if err != nil || json.Unmarshal(data, &task) != nil || task.Status != "paused" {
+		t.Errorf("MarshalTorrent(bb) = %s, %v", data, err)
+	}
+	if _, err := e.MarshalTorrent("cc"); err == nil {
+		t.Error("MarshalTorrent(cc) of a missing task")
+	}
+}
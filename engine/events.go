@@ -0,0 +1,52 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/boypt/simple-torrent/plugin"
+)
+
+const (
+	EventAdd      = "add"
+	EventComplete = "complete"
+	EventStalled  = "stalled"
+	EventError    = "error"
+)
+
+// notify sends the task event to the notify plugins and the Notifications
+// channels
+func (e *Engine) notify(evType string, t *Torrent, msg string) {
+	e.sendNotifications(evType, t, msg)
+	e.publishEvent(plugin.Event{
+		Type:     evType,
+		InfoHash: t.InfoHash,
+		Name:     t.Name,
+		Size:     t.Size,
+		Labels:   t.Labels,
+		Message:  msg,
+		Time:     time.Now(),
+	})
+}
+
+// publishEvent sends the event to the notify plugins and the event feed
+func (e *Engine) publishEvent(ev plugin.Event) {
+	e.plugins.Notify(ev)
+	e.feed.publish(ev)
+}
+
+// postProcess calls the postprocess plugins on a finished task
+func (e *Engine) postProcess(t *Torrent) {
+	e.plugins.PostProcess(plugin.Task{
+		InfoHash:  t.InfoHash,
+		Name:      t.Name,
+		Directory: t.dataDir(),
+		Size:      t.Size,
+		Labels:    t.Labels,
+		Verified:  t.verifyStatus(),
+	})
+}
+
+// Plugins returns the loaded plugins
+func (e *Engine) Plugins() *plugin.Manager {
+	return e.plugins
+}
@@ -0,0 +1,163 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+	"github.com/c2h5oh/datasize"
+)
+
+// excludeRules are the ExcludeFiles, the files not downloaded by the new
+// tasks
+type excludeRules struct {
+	globs []string
+	// the files smaller are excluded
+	minSize int64
+}
+
+// parseExcludeFiles parses the ExcludeFiles, comma separated globs of the
+// file names, case insensitive, or <size for the files smaller than size,
+// eg. "*.lnk, *.exe, sample.*, *.nfo, <5KB"
+func parseExcludeFiles(s string) (excludeRules, error) {
+	var rules excludeRules
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if strings.HasPrefix(p, "<") {
+			var v datasize.ByteSize
+			if err := v.UnmarshalText([]byte(strings.TrimSpace(p[1:]))); err != nil || v == 0 {
+				return rules, fmt.Errorf("invalid size of the exclusion %q", p)
+			}
+			rules.minSize = int64(v)
+			continue
+		}
+		p = strings.ToLower(p)
+		if strings.Contains(p, "/") {
+			return rules, fmt.Errorf("invalid exclusion %q, the globs match the file names only", p)
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return rules, fmt.Errorf("invalid exclusion %q: %w", p, err)
+		}
+		rules.globs = append(rules.globs, p)
+	}
+	return rules, nil
+}
+
+func (r excludeRules) empty() bool {
+	return len(r.globs) == 0 && r.minSize == 0
+}
+
+// excluded tells if the file of the path in the torrent is skipped
+func (r excludeRules) excluded(p string, size int64) bool {
+	if size < r.minSize {
+		return true
+	}
+	name := strings.ToLower(path.Base(p))
+	for _, g := range r.globs {
+		if ok, _ := path.Match(g, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// selectFiles are the paths of the files not excluded, nil if none is or
+// if all are
+func (r excludeRules) selectFiles(paths []string, sizes []int64) (selected []string) {
+	for i, p := range paths {
+		if !r.excluded(p, sizes[i]) {
+			selected = append(selected, p)
+		}
+	}
+	if len(selected) == len(paths) || len(selected) == 0 {
+		return nil
+	}
+	return selected
+}
+
+func (e *Engine) excludeRules() excludeRules {
+	e.RLock()
+	defer e.RUnlock()
+	rules, _ := parseExcludeFiles(e.config.ExcludeFiles)
+	return rules
+}
+
+// excludeAdded marks the new task for the ExcludeFiles to apply once its
+// files are known, unless the add keeps them all or selects its own
+func (e *Engine) excludeAdded(infohash string, opt AddOptions) {
+	// the cache restores have no source
+	if opt.Source == "" || opt.KeepAllFiles || e.excludeRules().empty() {
+		return
+	}
+	if _, err := os.Stat(e.selectionFileName(infohash)); err == nil {
+		return
+	}
+	if err := e.saveSelection(infohash, taskSelection{Exclude: true}); err != nil {
+		log.Println("[Exclude]", infohash, err)
+	}
+}
+
+// applyExclude selects the files of the task not excluded by the
+// ExcludeFiles, once its info is known
+func (e *Engine) applyExclude(t *Torrent, tt *torrent.Torrent) {
+	t.Lock()
+	defer t.Unlock()
+	if !t.applyExclude {
+		return
+	}
+	t.applyExclude = false
+
+	var paths []string
+	var sizes []int64
+	for _, f := range tt.Files() {
+		paths = append(paths, f.Path())
+		sizes = append(sizes, f.Length())
+	}
+	selected := e.excludeRules().selectFiles(paths, sizes)
+	if selected == nil {
+		if t.selectDir == "" {
+			e.removeSelection(t.InfoHash)
+		} else if err := e.saveSelection(t.InfoHash, taskSelection{Dir: t.selectDir}); err != nil {
+			log.Println("[Exclude]", t.InfoHash, err)
+		}
+		return
+	}
+	t.selected = make(map[string]bool, len(selected))
+	for _, p := range selected {
+		t.selected[p] = true
+	}
+	if err := e.saveSelection(t.InfoHash, taskSelection{Dir: t.selectDir, Files: selected}); err != nil {
+		log.Println("[Exclude]", t.InfoHash, err)
+	}
+	log.Printf("[Exclude] %s: %d of %d files excluded", t.InfoHash, len(paths)-len(selected), len(paths))
+}
+
+// markExcluded flags the files of the inspection the ExcludeFiles skip
+func (e *Engine) markExcluded(ins *Inspection) {
+	rules := e.excludeRules()
+	if rules.empty() {
+		return
+	}
+	var paths []string
+	var sizes []int64
+	for _, f := range ins.Files {
+		paths = append(paths, f.Path)
+		sizes = append(sizes, f.Size)
+	}
+	selected := rules.selectFiles(paths, sizes)
+	if selected == nil {
+		return
+	}
+	keep := make(map[string]bool, len(selected))
+	for _, p := range selected {
+		keep[p] = true
+	}
+	for i := range ins.Files {
+		ins.Files[i].Excluded = !keep[ins.Files[i].Path]
+	}
+}
@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseExcludeFiles(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    excludeRules
+		wantErr bool
+	}{
+		{"", excludeRules{}, false},
+		{"*.LNK, sample.*, <5KB", excludeRules{globs: []string{"*.lnk", "sample.*"}, minSize: 5 << 10}, false},
+		{"<nothing", excludeRules{}, true},
+		{"dir/*.exe", excludeRules{}, true},
+		{"[a-", excludeRules{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseExcludeFiles(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseExcludeFiles(%q) err = %v, wantErr %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseExcludeFiles(%q) = %+v, want %+v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func Test_excludeRules_selectFiles(t *testing.T) {
+	rules, err := parseExcludeFiles("*.lnk, sample.*, <5KB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		paths []string
+		sizes []int64
+		want  []string
+	}{
+		{
+			[]string{"show/e01.mkv", "show/Sample.mkv", "show/open.lnk", "show/e01.srt"},
+			[]int64{1 << 30, 10 << 20, 1 << 20, 1 << 10},
+			[]string{"show/e01.mkv"},
+		},
+		// nothing excluded
+		{[]string{"show/e01.mkv"}, []int64{1 << 30}, nil},
+		// all excluded, kept
+		{[]string{"sample.mkv"}, []int64{1 << 30}, nil},
+	}
+	for _, tt := range tests {
+		if got := rules.selectFiles(tt.paths, tt.sizes); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("selectFiles(%v) = %v, want %v", tt.paths, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+const maxFailedAdds = 100
+
+// the kinds of FailedAdd
+const (
+	FailedMagnet  = "magnet"
+	FailedTorrent = "torrent"
+	FailedFile    = "file"
+	FailedURL     = "url"
+)
+
+var ErrFailedAddNotFound = errors.New("Failed add not found")
+
+// FailedAdd is an add which failed, kept in the failed list until retried
+// or dismissed
+type FailedAdd struct {
+	ID    string
+	Kind  string
+	Input string
+	Error string
+	At    time.Time
+
+	// the content of the torrent file
+	data []byte
+}
+
+type failedList struct {
+	sync.Mutex
+	list []*FailedAdd
+}
+
+// RecordFailedAdd puts an add failed by err in the failed list, the queued,
+// rejected and duplicate adds aren't failures
+func (e *Engine) RecordFailedAdd(kind, input string, data []byte, err error) {
+	if err == nil || errors.Is(err, ErrMaxConnTasks) || errors.Is(err, ErrRejectedByHook) ||
+		errors.Is(err, ErrDuplicate) || errors.Is(err, ErrTaskExists) {
+		return
+	}
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	fa := &FailedAdd{
+		ID:    fmt.Sprintf("%x", id),
+		Kind:  kind,
+		Input: input,
+		Error: err.Error(),
+		At:    time.Now(),
+		data:  data,
+	}
+	log.Printf("[FailedAdd] %s %s: %s", kind, input, err)
+
+	e.failed.Lock()
+	for i, f := range e.failed.list {
+		// the same input fails again
+		if f.Kind == kind && f.Input == input && bytes.Equal(f.data, data) {
+			e.failed.list = append(e.failed.list[:i], e.failed.list[i+1:]...)
+			break
+		}
+	}
+	e.failed.list = append(e.failed.list, fa)
+	if len(e.failed.list) > maxFailedAdds {
+		e.failed.list = e.failed.list[len(e.failed.list)-maxFailedAdds:]
+	}
+	e.failed.Unlock()
+
+	select {
+	case e.TsChanged <- struct{}{}:
+	default:
+	}
+}
+
+// FailedAdds lists the failed adds, the latest last
+func (e *Engine) FailedAdds() []FailedAdd {
+	e.failed.Lock()
+	defer e.failed.Unlock()
+	res := make([]FailedAdd, 0, len(e.failed.list))
+	for _, f := range e.failed.list {
+		res = append(res, *f)
+	}
+	return res
+}
+
+// TakeFailedAdd removes a failed add from the list, returning it with the
+// torrent content, for a retry
+func (e *Engine) TakeFailedAdd(id string) (*FailedAdd, []byte, error) {
+	e.failed.Lock()
+	defer e.failed.Unlock()
+	for i, f := range e.failed.list {
+		if f.ID == id {
+			e.failed.list = append(e.failed.list[:i], e.failed.list[i+1:]...)
+			return f, f.data, nil
+		}
+	}
+	return nil, nil, ErrFailedAddNotFound
+}
+
+// RetryFailedAdd adds the input of a failed add again, it goes back to the
+// list if failing again. The url adds are retried by the caller, which
+// downloads the torrent.
+func (e *Engine) RetryFailedAdd(id string) error {
+	f, data, err := e.TakeFailedAdd(id)
+	if err != nil {
+		return err
+	}
+	switch f.Kind {
+	case FailedMagnet:
+		err = e.NewMagnet(f.Input)
+	case FailedTorrent:
+		err = e.NewTorrentByReader(bytes.NewReader(data))
+	case FailedFile:
+		if data == nil {
+			err = e.NewTorrentByFilePath(f.Input)
+		} else {
+			err = e.NewTorrentByReader(bytes.NewReader(data))
+		}
+	default:
+		return fmt.Errorf("Can't retry a %s add", f.Kind)
+	}
+	if errors.Is(err, ErrMaxConnTasks) {
+		return nil
+	}
+	return err
+}
+
+// readTorrentFile keeps the content of a torrent file for the failed list,
+// the watched files may be gone when retried
+func readTorrentFile(path string) []byte {
+	data, err := ioutil.ReadFile(path)
+	if err != nil || len(data) > 512*1024 {
+		return nil
+	}
+	return data
+}
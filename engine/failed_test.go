@@ -0,0 +1,34 @@
+package engine
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewTorrentByFilePath_malformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"not bencode", "not a torrent"},
+		// TorrentSpecFromMetaInfo panics on the info not a dict
+		{"info list", "d4:infoli1eee"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Engine{ts: make(map[string]*Torrent), TsChanged: make(chan struct{}, 1)}
+			fn := filepath.Join(t.TempDir(), "bad.torrent")
+			if err := ioutil.WriteFile(fn, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := e.NewTorrentByFilePath(fn); err == nil {
+				t.Fatal("NewTorrentByFilePath() expected error")
+			}
+			fa := e.FailedAdds()
+			if len(fa) != 1 || fa[0].Kind != FailedFile || fa[0].Input != fn {
+				t.Errorf("FailedAdds() = %+v, want the file", fa)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package engine
+
+import (
+	"sync"
+
+	"github.com/boypt/simple-torrent/plugin"
+)
+
+// eventFeed fans the task events out to the subscribers, like the event
+// streams of the APIs
+type eventFeed struct {
+	sync.Mutex
+	subs map[chan plugin.Event]struct{}
+}
+
+// SubscribeEvents receives the task events until cancel is called. The
+// events are dropped for a subscriber lagging more than buf behind.
+func (e *Engine) SubscribeEvents(buf int) (events <-chan plugin.Event, cancel func()) {
+	ch := make(chan plugin.Event, buf)
+	e.feed.Lock()
+	if e.feed.subs == nil {
+		e.feed.subs = make(map[chan plugin.Event]struct{})
+	}
+	e.feed.subs[ch] = struct{}{}
+	e.feed.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			e.feed.Lock()
+			delete(e.feed.subs, ch)
+			e.feed.Unlock()
+			close(ch)
+		})
+	}
+}
+
+func (f *eventFeed) publish(ev plugin.Event) {
+	f.Lock()
+	defer f.Unlock()
+	for ch := range f.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/boypt/simple-torrent/plugin"
+)
+
+func TestSubscribeEvents(t *testing.T) {
+	e := &Engine{}
+	events, cancel := e.SubscribeEvents(1)
+	lagging, cancelLagging := e.SubscribeEvents(0)
+	defer cancelLagging()
+
+	e.feed.publish(plugin.Event{Type: EventAdd, InfoHash: "ih"})
+	// dropped, the buffer is full
+	e.feed.publish(plugin.Event{Type: EventComplete, InfoHash: "ih"})
+	if ev := <-events; ev.Type != EventAdd {
+		t.Errorf("got %s, want %s", ev.Type, EventAdd)
+	}
+	select {
+	case ev := <-lagging:
+		t.Errorf("lagging subscriber got %v", ev)
+	default:
+	}
+
+	cancel()
+	cancel()
+	if _, ok := <-events; ok {
+		t.Error("events not closed by cancel")
+	}
+	e.feed.publish(plugin.Event{Type: EventError})
+	if len(e.feed.subs) != 1 {
+		t.Errorf("%d subscribers, want 1", len(e.feed.subs))
+	}
+}
@@ -0,0 +1,150 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FetchDirect in the proxy option of an override bypasses the SearchUseProxy
+const FetchDirect = "direct"
+
+// FetchOverride is the http setting of the search provider (or RSS host)
+// fetches, see SearchOverrides
+type FetchOverride struct {
+	Target  string
+	Proxy   string
+	Headers map[string]string
+	Timeout time.Duration
+	// Cloudflare fetches through the FlareSolverr
+	Cloudflare bool
+}
+
+// the options run until the next one, so the values may contain spaces
+var fetchOptRegexp = regexp.MustCompile(`\s(proxy|cookie|header|timeout|cloudflare)=`)
+
+// splitFetchOverride splits a line into the target and the option pairs
+func splitFetchOverride(line string) (string, [][2]string) {
+	line = " " + line
+	locs := fetchOptRegexp.FindAllStringSubmatchIndex(line, -1)
+	if len(locs) == 0 {
+		return strings.TrimSpace(line), nil
+	}
+	target := strings.TrimSpace(line[:locs[0][0]])
+	var opts [][2]string
+	for i, loc := range locs {
+		end := len(line)
+		if i+1 < len(locs) {
+			end = locs[i+1][0]
+		}
+		opts = append(opts, [2]string{line[loc[2]:loc[3]], strings.TrimSpace(line[loc[1]:end])})
+	}
+	return target, opts
+}
+
+// ParseFetchOverrides parses the SearchOverrides, one each line:
+// `<provider id|host> [proxy=<url>|direct] [cookie=<cookies>] [header=<Name>: <value>] [timeout=<duration>] [cloudflare=true]`
+// Secret references in the values are expanded.
+func ParseFetchOverrides(conf string) ([]*FetchOverride, error) {
+	var ovs []*FetchOverride
+	for n, l := range strings.Split(conf, "\n") {
+		line := strings.TrimSpace(l)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		o, err := parseFetchOverride(line)
+		if err != nil {
+			return nil, fmt.Errorf("overrides line %d: %w", n+1, err)
+		}
+		ovs = append(ovs, o)
+	}
+	return ovs, nil
+}
+
+func parseFetchOverride(line string) (*FetchOverride, error) {
+	target, opts := splitFetchOverride(line)
+	if target == "" || strings.ContainsAny(target, " \t") {
+		return nil, errors.New("missing provider")
+	}
+	o := &FetchOverride{Target: target, Headers: make(map[string]string)}
+	for _, opt := range opts {
+		val, err := ResolveSecrets(opt[1])
+		if err != nil {
+			return nil, err
+		}
+		switch opt[0] {
+		case "proxy":
+			if val != FetchDirect {
+				if u, err := url.Parse(val); err != nil || u.Host == "" {
+					return nil, fmt.Errorf("Invalid proxy url %s", maskURL(val))
+				}
+			}
+			o.Proxy = val
+		case "cookie":
+			o.Headers["Cookie"] = val
+		case "header":
+			kv := strings.SplitN(val, ":", 2)
+			if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+				return nil, fmt.Errorf("invalid header %q", opt[1])
+			}
+			o.Headers[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		case "timeout":
+			if o.Timeout, err = time.ParseDuration(val); err != nil {
+				return nil, err
+			}
+		case "cloudflare":
+			if o.Cloudflare, err = strconv.ParseBool(val); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return o, nil
+}
+
+// maskFetchOverrides hides the cookies, the header values and the proxy
+// passwords of the SearchOverrides
+func maskFetchOverrides(conf string) string {
+	lines := strings.Split(conf, "\n")
+	for i, l := range lines {
+		line := strings.TrimSpace(l)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		target, opts := splitFetchOverride(line)
+		parts := []string{target}
+		for _, opt := range opts {
+			val := opt[1]
+			switch opt[0] {
+			case "proxy":
+				val = maskURL(val)
+			case "cookie":
+				val = maskValue(val)
+			case "header":
+				if kv := strings.SplitN(val, ":", 2); len(kv) == 2 {
+					val = kv[0] + ": " + maskValue(strings.TrimSpace(kv[1]))
+				}
+			}
+			parts = append(parts, opt[0]+"="+val)
+		}
+		lines[i] = strings.Join(parts, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// URLHost is the host of a (templated) url, eg. of the search providers
+func URLHost(u string) string {
+	if i := strings.Index(u, "://"); i >= 0 {
+		u = u[i+3:]
+	}
+	if i := strings.IndexAny(u, "/?#{"); i >= 0 {
+		u = u[:i]
+	}
+	if pu, err := url.Parse("http://" + u); err == nil {
+		return pu.Hostname()
+	}
+	return u
+}
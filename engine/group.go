@@ -0,0 +1,251 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	taskGroupExt    = ".group"
+	maxGroupNameLen = 64
+)
+
+var (
+	ErrInvalidGroup = errors.New("Invalid group name")
+	ErrMissingGroup = errors.New("Missing group")
+)
+
+// groupState keeps the group DoneCmd from being called twice, by tasks
+// finishing together
+type groupState struct {
+	sync.Mutex
+	called map[string]bool
+}
+
+// reset lets the DoneCmd of the group be called again, its tasks changed
+func (gs *groupState) reset(group string) {
+	gs.Lock()
+	delete(gs.called, group)
+	gs.Unlock()
+}
+
+// GroupStatus aggregates the tasks of a group, like the parts of a
+// multi-part release
+type GroupStatus struct {
+	Name       string
+	Tasks      []string
+	Size       int64
+	Downloaded int64
+	Percent    float32
+	// bytes per second
+	DownloadRate float32
+	UploadRate   float32
+	Started      int
+	Done         bool
+}
+
+// checkGroupName tells if the name is fine for a group, empty for none
+func checkGroupName(name string) error {
+	if len(name) > maxGroupNameLen || strings.TrimSpace(name) != name ||
+		strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return fmt.Errorf("%w: %q", ErrInvalidGroup, name)
+	}
+	return nil
+}
+
+// groupFileName is saved in the cache dir next to the task's torrent file
+func (e *Engine) groupFileName(infohash string) string {
+	return filepath.Join(e.cacheDir, fmt.Sprintf("%s%s%s", cacheSavedPrefix, infohash, taskGroupExt))
+}
+
+// loadGroup restores the group of a new task
+func (e *Engine) loadGroup(t *Torrent) {
+	data, err := ioutil.ReadFile(e.groupFileName(t.InfoHash))
+	if err != nil {
+		return
+	}
+	if group := string(data); checkGroupName(group) == nil {
+		t.Group = group
+	}
+}
+
+func (e *Engine) saveGroup(infohash, group string) error {
+	if group == "" {
+		e.removeGroup(infohash)
+		return nil
+	}
+	return ioutil.WriteFile(e.groupFileName(infohash), []byte(group), 0644)
+}
+
+func (e *Engine) removeGroup(infohash string) {
+	if err := os.Remove(e.groupFileName(infohash)); err != nil && !os.IsNotExist(err) {
+		log.Println("[Group]", infohash, err)
+	}
+}
+
+// groupAdded saves the group asked by the add, before the task is upserted
+// which loads it
+func (e *Engine) groupAdded(infohash string, opt AddOptions) {
+	if opt.Group == "" {
+		return
+	}
+	if err := e.saveGroup(infohash, opt.Group); err != nil {
+		log.Println("[Group]", infohash, err)
+	}
+	e.groups.reset(opt.Group)
+}
+
+// SetGroup moves the task to the group, empty to take it out of any
+func (e *Engine) SetGroup(infohash, group string) error {
+	if err := checkGroupName(group); err != nil {
+		return err
+	}
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+	if err := e.saveGroup(infohash, group); err != nil {
+		return err
+	}
+	t.Lock()
+	old := t.Group
+	t.Group = group
+	t.Unlock()
+	e.groups.reset(old)
+	e.groups.reset(group)
+	log.Printf("[Group]%s set to %q", infohash, group)
+	return nil
+}
+
+// Groups lists the groups with their aggregate progress, by name
+func (e *Engine) Groups() []GroupStatus {
+	groups := make(map[string]*GroupStatus)
+	e.RLock()
+	for ih, t := range e.ts {
+		t.Lock()
+		if t.Group != "" {
+			g, ok := groups[t.Group]
+			if !ok {
+				g = &GroupStatus{Name: t.Group, Done: true}
+				groups[t.Group] = g
+			}
+			g.Tasks = append(g.Tasks, ih)
+			g.Size += t.Size
+			g.Downloaded += t.Downloaded
+			g.DownloadRate += t.DownloadRate
+			g.UploadRate += t.UploadRate
+			if t.Started {
+				g.Started++
+			}
+			// the size is unknown until the info is loaded
+			g.Done = g.Done && t.Done && t.Loaded
+		}
+		t.Unlock()
+	}
+	e.RUnlock()
+
+	res := make([]GroupStatus, 0, len(groups))
+	for _, g := range groups {
+		sort.Strings(g.Tasks)
+		g.Percent = percent(g.Downloaded, g.Size)
+		res = append(res, *g)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+func (e *Engine) groupTasks(group string) ([]string, error) {
+	for _, g := range e.Groups() {
+		if g.Name == group {
+			return g.Tasks, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrMissingGroup, group)
+}
+
+// forGroup does the action on each task of the group, the errors are
+// logged and the last one returned
+func (e *Engine) forGroup(group, action string, do func(ih string) error) error {
+	tasks, err := e.groupTasks(group)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, ih := range tasks {
+		if err := do(ih); err != nil {
+			log.Printf("[Group]%s %s %s: %s", group, action, ih, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// StartGroup starts the tasks of the group
+func (e *Engine) StartGroup(group string) error {
+	return e.forGroup(group, "start", e.ManualStartTorrent)
+}
+
+// StopGroup stops the tasks of the group
+func (e *Engine) StopGroup(group string) error {
+	return e.forGroup(group, "stop", e.StopTorrent)
+}
+
+// DeleteGroup removes the tasks of the group, keeping the data like the
+// removal of a task
+func (e *Engine) DeleteGroup(group string) error {
+	return e.forGroup(group, "delete", func(ih string) error {
+		return e.RemoveTorrent(ih, RemovedByUser)
+	})
+}
+
+// groupTaskDone calls the DoneCmd of the group when its last task finished,
+// with CLD_TYPE=group, CLD_PATH the group name and CLD_HASH the infohashes
+// comma separated
+func (e *Engine) groupTaskDone(group string) {
+	if group == "" {
+		return
+	}
+	var g *GroupStatus
+	for _, gs := range e.Groups() {
+		if gs.Name == group {
+			g = &gs
+			break
+		}
+	}
+	if g == nil || !g.Done {
+		return
+	}
+	e.groups.Lock()
+	called := e.groups.called[group]
+	if e.groups.called == nil {
+		e.groups.called = make(map[string]bool)
+	}
+	e.groups.called[group] = true
+	e.groups.Unlock()
+	if called {
+		return
+	}
+	cmdPath, env, err := e.config.GetCmdConfig()
+	if err != nil || cmdPath == "" {
+		return
+	}
+	log.Printf("[Group]%s finished, %d tasks", group, len(g.Tasks))
+	env = append(env,
+		fmt.Sprintf("CLD_RESTAPI=%s", e.cld.GetStrAttribute("RestAPI")),
+		fmt.Sprintf("CLD_PATH=%s", group),
+		fmt.Sprintf("CLD_HASH=%s", strings.Join(g.Tasks, ",")),
+		"CLD_TYPE=group",
+		fmt.Sprintf("CLD_SIZE=%d", g.Size),
+		fmt.Sprintf("CLD_FILENUM=%d", len(g.Tasks)),
+	)
+	e.jobs.submit(g.Tasks[0], group, "group", cmdPath, env)
+}
@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_checkGroupName(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"", false},
+		{"Season 1", false},
+		{" padded", true},
+		{"tab\tname", true},
+		{strings.Repeat("x", maxGroupNameLen+1), true},
+	}
+	for _, tt := range tests {
+		if err := checkGroupName(tt.name); (err != nil) != tt.wantErr {
+			t.Errorf("checkGroupName(%q) = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestEngine_Groups(t *testing.T) {
+	e := &Engine{ts: map[string]*Torrent{
+		"a": {InfoHash: "a", Group: "release", Loaded: true, Done: true, Size: 100, Downloaded: 100},
+		"b": {InfoHash: "b", Group: "release", Loaded: true, Started: true, Size: 100, Downloaded: 50, DownloadRate: 10},
+		"c": {InfoHash: "c"},
+	}}
+	groups := e.Groups()
+	if len(groups) != 1 {
+		t.Fatalf("Groups() = %v, want the release group only", groups)
+	}
+	g := groups[0]
+	if g.Name != "release" || len(g.Tasks) != 2 || g.Tasks[0] != "a" {
+		t.Errorf("Groups() = %+v", g)
+	}
+	if g.Size != 200 || g.Downloaded != 150 || g.Percent != 75 || g.Started != 1 || g.Done {
+		t.Errorf("Groups() aggregate = %+v", g)
+	}
+
+	if _, err := e.groupTasks("missing"); !errors.Is(err, ErrMissingGroup) {
+		t.Errorf("groupTasks() err = %v, want ErrMissingGroup", err)
+	}
+}
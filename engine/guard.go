@@ -0,0 +1,207 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+const (
+	EventBlocked = "blocked"
+
+	GuardBlock      = "block"
+	GuardQuarantine = "quarantine"
+)
+
+var ErrBlockedFiles = errors.New("All the files are of blocked types")
+
+// fileGuard is the BlockedFileTypes and what's done with them
+type fileGuard struct {
+	// the extensions, lower cased with the dot
+	exts map[string]bool
+	// the dir the blocked files are stored in, relative to the task dir,
+	// empty to not download them
+	quarantine string
+}
+
+// guardState keeps the fileGuard of the config, read by the storage which
+// can't take the engine lock
+type guardState struct {
+	sync.Mutex
+	guard fileGuard
+}
+
+// parseFileGuard parses the BlockedFileTypes, comma separated extensions eg.
+// ".exe, .scr", the BlockedFileAction and the QuarantineDirectory
+func parseFileGuard(c *Config) (fileGuard, error) {
+	g := fileGuard{exts: make(map[string]bool)}
+	for _, ext := range strings.Split(c.BlockedFileTypes, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if len(ext) == 1 || strings.ContainsAny(ext[1:], `./\*?`) {
+			return g, fmt.Errorf("invalid file type %q, expecting an extension like .exe", ext)
+		}
+		g.exts[ext] = true
+	}
+	switch c.BlockedFileAction {
+	case "", GuardBlock:
+	case GuardQuarantine:
+		dir := filepath.ToSlash(filepath.Clean(c.QuarantineDirectory))
+		if !localRenamePath(dir) {
+			return g, fmt.Errorf("the QuarantineDirectory must be relative to the download directory (%s)", c.QuarantineDirectory)
+		}
+		g.quarantine = dir
+	default:
+		return g, fmt.Errorf("unknown action %q, expecting %s or %s", c.BlockedFileAction, GuardBlock, GuardQuarantine)
+	}
+	return g, nil
+}
+
+// blocked tells if the file of the path is of a blocked type
+func (g fileGuard) blocked(p string) bool {
+	return g.exts[strings.ToLower(path.Ext(p))]
+}
+
+// quarantinePath is where the blocked file of the path in the torrent is
+// stored, relative to the task dir
+func (g fileGuard) quarantinePath(infohash, p string) string {
+	return path.Join(g.quarantine, infohash, p)
+}
+
+func (e *Engine) setFileGuard(c *Config) {
+	g, err := parseFileGuard(c)
+	if err != nil {
+		log.Println("[Guard] blocked file types unchanged,", err)
+		return
+	}
+	e.guard.Lock()
+	e.guard.guard = g
+	e.guard.Unlock()
+}
+
+func (e *Engine) fileGuard() fileGuard {
+	e.guard.Lock()
+	defer e.guard.Unlock()
+	return e.guard.guard
+}
+
+// checkBlocked refuses the torrent of which all the files are blocked,
+// magnets are checked once their info is known
+func (e *Engine) checkBlocked(spec *torrent.TorrentSpec) error {
+	g := e.fileGuard()
+	if len(g.exts) == 0 || g.quarantine != "" || spec.InfoBytes == nil {
+		return nil
+	}
+	var info metainfo.Info
+	if err := bencode.Unmarshal(spec.InfoBytes, &info); err != nil {
+		return nil
+	}
+	for _, p := range infoFilePaths(&info) {
+		if !g.blocked(p) {
+			return nil
+		}
+	}
+	return ErrBlockedFiles
+}
+
+// infoFilePaths are the paths of the files in the torrent, as File.Path
+func infoFilePaths(info *metainfo.Info) (paths []string) {
+	for _, fi := range info.UpvertedFiles() {
+		paths = append(paths, strings.Join(append([]string{info.Name}, fi.Path...), "/"))
+	}
+	return
+}
+
+// quarantineRenames are the renames of the task with its blocked files
+// remapped to the quarantine, the files already downloaded moved there
+func (e *Engine) quarantineRenames(infohash string, info *metainfo.Info, dir string) *TaskRenames {
+	rn := e.taskRenames(infohash)
+	g := e.fileGuard()
+	if g.quarantine == "" || len(g.exts) == 0 {
+		return rn
+	}
+	var nrn *TaskRenames
+	for _, p := range infoFilePaths(info) {
+		if !g.blocked(p) {
+			continue
+		}
+		cur, qp := rn.path(p), g.quarantinePath(infohash, p)
+		if cur == qp {
+			continue
+		}
+		if nrn == nil {
+			nrn = rn.clone()
+		}
+		nrn.Files[p] = qp
+		src, dst := filepath.Join(dir, filepath.FromSlash(cur)), filepath.Join(dir, filepath.FromSlash(qp))
+		if _, err := os.Stat(src); err == nil {
+			if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err == nil {
+				err = os.Rename(src, dst)
+			}
+			if err != nil {
+				log.Printf("[Guard]%s quarantine %s: %s", infohash, cur, err)
+			}
+		}
+		log.Printf("[Guard]%s %s quarantined in %s", infohash, p, qp)
+	}
+	if nrn == nil {
+		return rn
+	}
+	if err := e.saveRenames(infohash, nrn); err != nil {
+		log.Println("[Guard]", infohash, err)
+	}
+	return nrn
+}
+
+// applyGuard reports the blocked files of the task once its info is known,
+// and keeps them from being downloaded unless quarantined
+func (e *Engine) applyGuard(t *Torrent, tt *torrent.Torrent) {
+	g := e.fileGuard()
+	if len(g.exts) == 0 {
+		return
+	}
+	var blocked []string
+	files := tt.Files()
+	for _, f := range files {
+		if g.blocked(f.Path()) {
+			blocked = append(blocked, f.Path())
+		}
+	}
+	if len(blocked) == 0 {
+		return
+	}
+
+	done := "quarantined"
+	t.Lock()
+	t.Blocked = blocked
+	if g.quarantine == "" {
+		done = "blocked"
+		t.blocked = make(map[string]bool, len(blocked))
+		for _, p := range blocked {
+			t.blocked[p] = true
+		}
+		if len(blocked) == len(files) {
+			// nothing left, refused like the torrents added with the info
+			t.Error = ErrBlockedFiles.Error()
+			t.noAutoStart = true
+			tt.DisallowDataDownload()
+		}
+	}
+	t.Unlock()
+	msg := fmt.Sprintf("%d files %s: %s", len(blocked), done, strings.Join(blocked, ", "))
+	log.Printf("[Guard]%s %s", t.InfoHash, msg)
+	e.notify(EventBlocked, t, msg)
+}
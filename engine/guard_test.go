@@ -0,0 +1,69 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func Test_parseFileGuard(t *testing.T) {
+	tests := []struct {
+		c       Config
+		wantErr bool
+	}{
+		{Config{}, false},
+		{Config{BlockedFileTypes: ".EXE, scr"}, false},
+		{Config{BlockedFileTypes: "*.exe"}, true},
+		{Config{BlockedFileTypes: ".exe", BlockedFileAction: "delete"}, true},
+		{Config{BlockedFileTypes: ".exe", BlockedFileAction: GuardQuarantine, QuarantineDirectory: "quarantine"}, false},
+		{Config{BlockedFileTypes: ".exe", BlockedFileAction: GuardQuarantine, QuarantineDirectory: "../quarantine"}, true},
+		{Config{BlockedFileTypes: ".exe", BlockedFileAction: GuardQuarantine, QuarantineDirectory: "/quarantine"}, true},
+	}
+	for _, tt := range tests {
+		g, err := parseFileGuard(&tt.c)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseFileGuard(%+v) err = %v, wantErr %v", tt.c, err, tt.wantErr)
+		}
+		if err == nil && tt.c.BlockedFileTypes != "" && (!g.blocked("show/setup.exe") || !g.blocked("show/SETUP.EXE") || g.blocked("show/e01.mkv")) {
+			t.Errorf("parseFileGuard(%+v) blocked = %v", tt.c, g.exts)
+		}
+	}
+}
+
+func TestEngine_quarantineRenames(t *testing.T) {
+	dir := t.TempDir()
+	e := &Engine{cacheDir: t.TempDir()}
+	g, err := parseFileGuard(&Config{BlockedFileTypes: ".exe", BlockedFileAction: GuardQuarantine, QuarantineDirectory: "quarantine"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.guard.guard = g
+
+	info := &metainfo.Info{Name: "show", Files: []metainfo.FileInfo{
+		{Path: []string{"e01.mkv"}, Length: 10},
+		{Path: []string{"setup.exe"}, Length: 1},
+	}}
+	// downloaded before the guard
+	if err := os.MkdirAll(filepath.Join(dir, "show"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "show", "setup.exe"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rn := e.quarantineRenames("ih", info, dir)
+	want := "quarantine/ih/show/setup.exe"
+	if rn.path("show/setup.exe") != want || rn.path("show/e01.mkv") != "show/e01.mkv" {
+		t.Errorf("quarantineRenames() = %v", rn.Files)
+	}
+	if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(want))); err != nil {
+		t.Errorf("quarantineRenames() didn't move the file: %s", err)
+	}
+	// kept by the renames
+	e.resetRenames()
+	if got := e.taskRenames("ih").path("show/setup.exe"); got != want {
+		t.Errorf("taskRenames() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,195 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// the smaller files aren't worth hashing for the dedupe
+const dedupeMinSize = 1 << 20
+
+var ErrDedupeRunning = errors.New("Dedupe is running")
+
+// DedupeReport is the result of the last dedupe scan
+type DedupeReport struct {
+	Running    bool
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Files      int
+	Linked     int
+	SavedBytes int64
+	Error      string
+}
+
+type dedupeState struct {
+	sync.Mutex
+	report DedupeReport
+}
+
+// linkOrCopy hardlinks src to dst, falling back to a copy across devices
+func linkOrCopy(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		return nil
+	}
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// linkFinished hardlinks the files of a finished task into the
+// FinishedDirectory, the task keeps seeding from the originals
+func (t *Torrent) linkFinished() {
+	dst := t.e.config.FinishedDirectory
+	t.Lock()
+	files := append([]*File(nil), t.Files...)
+	t.Unlock()
+	t.setMoving(true)
+	defer t.setMoving(false)
+	for _, f := range files {
+		if f == nil {
+			continue
+		}
+		rel := filepath.FromSlash(f.Path)
+		if err := linkOrCopy(filepath.Join(t.dataDir(), rel), filepath.Join(dst, rel)); err != nil {
+			log.Println("[FinishedDirectory]", t.InfoHash, err)
+			return
+		}
+	}
+	log.Println("[FinishedDirectory] linked", t.InfoHash, len(files), "files")
+}
+
+func fileSHA256(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// replaceWithLink replaces dup with a hardlink of orig, atomically
+func replaceWithLink(orig, dup string) error {
+	tmp := dup + ".dedupe"
+	if err := os.Link(orig, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dup); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// DedupeReport returns the report of the current or last dedupe scan
+func (e *Engine) DedupeReport() DedupeReport {
+	e.dedupe.Lock()
+	defer e.dedupe.Unlock()
+	return e.dedupe.report
+}
+
+// Dedupe starts a scan hardlinking the identical files of the finished
+// tasks, e.g. the cross-seeded content. The files of unfinished tasks are
+// never touched as they're still being written.
+func (e *Engine) Dedupe() error {
+	e.dedupe.Lock()
+	defer e.dedupe.Unlock()
+	if e.dedupe.report.Running {
+		return ErrDedupeRunning
+	}
+	e.dedupe.report = DedupeReport{Running: true, StartedAt: time.Now()}
+	go e.runDedupe()
+	return nil
+}
+
+func (e *Engine) runDedupe() {
+	// size -> paths
+	bySize := make(map[int64][]string)
+	e.RLock()
+	for _, t := range e.ts {
+		t.Lock()
+		if t.Done {
+			for _, f := range t.Files {
+				if f != nil && f.Size >= dedupeMinSize {
+					p := filepath.Join(t.dataDir(), filepath.FromSlash(f.Path))
+					bySize[f.Size] = append(bySize[f.Size], p)
+				}
+			}
+		}
+		t.Unlock()
+	}
+	e.RUnlock()
+
+	var rp DedupeReport
+	var lastErr error
+	for size, paths := range bySize {
+		rp.Files += len(paths)
+		if len(paths) < 2 {
+			continue
+		}
+		// hash -> the first file seen
+		byHash := make(map[string]string)
+		for _, p := range paths {
+			h, err := fileSHA256(p)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			orig, ok := byHash[h]
+			if !ok {
+				byHash[h] = p
+				continue
+			}
+			oi, err1 := os.Stat(orig)
+			pi, err2 := os.Stat(p)
+			if err1 != nil || err2 != nil || os.SameFile(oi, pi) {
+				continue
+			}
+			if err := replaceWithLink(orig, p); err != nil {
+				lastErr = err
+				log.Println("[Dedupe]", p, err)
+				continue
+			}
+			log.Println("[Dedupe] linked", p, "to", orig)
+			rp.Linked++
+			rp.SavedBytes += size
+		}
+	}
+
+	e.dedupe.Lock()
+	defer e.dedupe.Unlock()
+	rp.StartedAt = e.dedupe.report.StartedAt
+	rp.FinishedAt = time.Now()
+	if lastErr != nil {
+		rp.Error = lastErr.Error()
+	}
+	e.dedupe.report = rp
+	log.Printf("[Dedupe] done, %d files linked, %d bytes saved", rp.Linked, rp.SavedBytes)
+}
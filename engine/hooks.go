@@ -0,0 +1,293 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/c2h5oh/datasize"
+)
+
+// Hooks are small rules configured in the Hooks option, one rule each line:
+//
+//	<event>: <condition> [and <condition>...] => <action>[, <action>...]
+//
+// events:     on-add, on-complete
+// conditions: name ~ <regexp>, name !~ <regexp>, size > <size>, size < <size>,
+//             tracker ~ <regexp>, tracker == <domain>, label == <label>, label != <label>,
+//             private, public, *
+// actions:    reject, label <label>, dir <path>, stop
+//
+// eg.
+//
+//	on-add: name ~ (?i)\bsample\b => reject
+//	on-add: name ~ (?i)s\d\de\d\d => label tv, dir tv
+//	on-complete: label == tv => stop
+
+const (
+	hookOnAdd      = "on-add"
+	hookOnComplete = "on-complete"
+)
+
+var ErrRejectedByHook = errors.New("Task rejected by hook")
+
+type hookRule struct {
+	event   string
+	conds   []hookCond
+	actions []hookAction
+}
+
+type hookCond struct {
+	field string
+	op    string
+	value string
+	re    *regexp.Regexp
+	size  int64
+}
+
+type hookAction struct {
+	verb string
+	arg  string
+}
+
+// hookTarget is what the hook conditions test against
+type hookTarget struct {
+	Name     string
+	Size     int64
+	Trackers []string
+	Labels   []string
+	Private  bool
+}
+
+// hookResult is the accumulated actions of the matched rules
+type hookResult struct {
+	reject bool
+	stop   bool
+	dir    string
+	labels []string
+}
+
+// ParseHooks parses the hook rules, one each line
+func ParseHooks(conf string) ([]*hookRule, error) {
+	var rules []*hookRule
+	for n, l := range strings.Split(conf, "\n") {
+		line := strings.TrimSpace(l)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r, err := parseHookRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("hooks line %d: %w", n+1, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func parseHookRule(line string) (*hookRule, error) {
+	ev := strings.SplitN(line, ":", 2)
+	if len(ev) != 2 {
+		return nil, errors.New("missing event")
+	}
+	r := &hookRule{event: strings.TrimSpace(ev[0])}
+	if r.event != hookOnAdd && r.event != hookOnComplete {
+		return nil, fmt.Errorf("unknown event %q", r.event)
+	}
+
+	ca := strings.SplitN(ev[1], "=>", 2)
+	if len(ca) != 2 {
+		return nil, errors.New("missing =>")
+	}
+
+	for _, cs := range strings.Split(ca[0], " and ") {
+		c, err := parseHookCond(strings.TrimSpace(cs))
+		if err != nil {
+			return nil, err
+		}
+		r.conds = append(r.conds, c)
+	}
+
+	for _, as := range strings.Split(ca[1], ",") {
+		f := strings.SplitN(strings.TrimSpace(as), " ", 2)
+		a := hookAction{verb: f[0]}
+		if len(f) == 2 {
+			a.arg = unquote(f[1])
+		}
+		switch a.verb {
+		case "reject", "stop":
+		case "label", "dir":
+			if a.arg == "" {
+				return nil, fmt.Errorf("action %q needs an argument", a.verb)
+			}
+		default:
+			return nil, fmt.Errorf("unknown action %q", a.verb)
+		}
+		if a.verb == "reject" && r.event != hookOnAdd {
+			return nil, errors.New("reject is only allowed on-add")
+		}
+		if a.verb == "dir" && r.event != hookOnAdd {
+			return nil, errors.New("dir is only allowed on-add")
+		}
+		r.actions = append(r.actions, a)
+	}
+	return r, nil
+}
+
+func parseHookCond(cs string) (hookCond, error) {
+	switch cs {
+	case "*", "private", "public":
+		return hookCond{field: cs}, nil
+	}
+
+	f := strings.SplitN(cs, " ", 3)
+	if len(f) != 3 {
+		return hookCond{}, fmt.Errorf("invalid condition %q", cs)
+	}
+	c := hookCond{field: f[0], op: f[1], value: unquote(f[2])}
+	switch c.field + " " + c.op {
+	case "name ~", "name !~", "tracker ~":
+		re, err := regexp.Compile(c.value)
+		if err != nil {
+			return c, err
+		}
+		c.re = re
+	case "size >", "size <":
+		var v datasize.ByteSize
+		if err := v.UnmarshalText([]byte(strings.ToLower(c.value))); err != nil {
+			return c, fmt.Errorf("invalid size %q", c.value)
+		}
+		c.size = int64(v)
+	case "label ==", "label !=", "tracker ==":
+	default:
+		return c, fmt.Errorf("invalid condition %q", cs)
+	}
+	return c, nil
+}
+
+func unquote(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+func (c *hookCond) match(ht *hookTarget) bool {
+	switch c.field {
+	case "*":
+		return true
+	case "private":
+		return ht.Private
+	case "public":
+		return !ht.Private
+	case "name":
+		return c.re.MatchString(ht.Name) == (c.op == "~")
+	case "size":
+		if c.op == ">" {
+			return ht.Size > c.size
+		}
+		return ht.Size < c.size
+	case "tracker":
+		for _, tr := range ht.Trackers {
+			if c.re != nil && c.re.MatchString(tr) || c.re == nil && trackerInDomain(tr, c.value) {
+				return true
+			}
+		}
+		return false
+	case "label":
+		return hasLabel(ht.Labels, c.value) == (c.op == "==")
+	}
+	return false
+}
+
+func (r *hookRule) match(ht *hookTarget) bool {
+	for _, c := range r.conds {
+		if !c.match(ht) {
+			return false
+		}
+	}
+	return true
+}
+
+// runHooks applies the rules of the event to the target in order
+func runHooks(rules []*hookRule, event string, ht hookTarget) hookResult {
+	var res hookResult
+	for _, r := range rules {
+		if r.event != event || !r.match(&ht) {
+			continue
+		}
+		for _, a := range r.actions {
+			switch a.verb {
+			case "reject":
+				res.reject = true
+			case "stop":
+				res.stop = true
+			case "dir":
+				res.dir = a.arg
+			case "label":
+				if !hasLabel(ht.Labels, a.arg) {
+					ht.Labels = append(ht.Labels, a.arg)
+				}
+			}
+		}
+	}
+	res.labels = ht.Labels
+	return res
+}
+
+// trackerInDomain tells if the host of the tracker url is the domain or a
+// subdomain of it
+func trackerInDomain(tracker, domain string) bool {
+	u, err := url.Parse(tracker)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+func hasLabel(labels []string, l string) bool {
+	for _, v := range labels {
+		if v == l {
+			return true
+		}
+	}
+	return false
+}
+
+func hookTargetFromSpec(spec *torrent.TorrentSpec) hookTarget {
+	ht := hookTarget{Name: spec.DisplayName}
+	for _, tier := range spec.Trackers {
+		ht.Trackers = append(ht.Trackers, tier...)
+	}
+	if len(spec.InfoBytes) > 0 {
+		var info metainfo.Info
+		if err := bencode.Unmarshal(spec.InfoBytes, &info); err == nil {
+			ht.Name = info.Name
+			ht.Size = info.TotalLength()
+			ht.Private = isPrivate(&info)
+		}
+	}
+	return ht
+}
+
+func (t *Torrent) hookTarget() hookTarget {
+	ht := hookTarget{
+		Name:    t.Name,
+		Size:    t.Size,
+		Labels:  append([]string(nil), t.Labels...),
+		Private: t.IsPrivate,
+	}
+	if t.t != nil {
+		for _, tier := range t.t.Metainfo().AnnounceList {
+			ht.Trackers = append(ht.Trackers, tier...)
+		}
+	}
+	return ht
+}
@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_runHooks(t *testing.T) {
+	conf := `
+# comment
+on-add: name ~ (?i)\bsample\b => reject
+on-add: name ~ (?i)s\d\de\d\d and size < 10GB => label tv, dir tv
+on-add: private => label private
+on-complete: label == tv => stop
+on-complete: tracker == linuxtracker.org => label iso
+`
+	rules, err := ParseHooks(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		event string
+		ht    hookTarget
+		want  hookResult
+	}{
+		{"reject", hookOnAdd, hookTarget{Name: "Movie.Sample.mkv"}, hookResult{reject: true}},
+		{"tv", hookOnAdd, hookTarget{Name: "Show.S01E02.mkv", Size: 1 << 30}, hookResult{dir: "tv", labels: []string{"tv"}}},
+		{"tv too large", hookOnAdd, hookTarget{Name: "Show.S01E02.mkv", Size: 20 << 30}, hookResult{}},
+		{"private", hookOnAdd, hookTarget{Name: "x", Private: true}, hookResult{labels: []string{"private"}}},
+		{"complete", hookOnComplete, hookTarget{Labels: []string{"tv"}}, hookResult{stop: true, labels: []string{"tv"}}},
+		{"tracker domain", hookOnComplete, hookTarget{Trackers: []string{"udp://tracker.linuxtracker.org:2710/announce"}}, hookResult{labels: []string{"iso"}}},
+		{"tracker other", hookOnComplete, hookTarget{Trackers: []string{"udp://notlinuxtracker.org:2710/announce"}}, hookResult{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runHooks(rules, tt.event, tt.ht); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("runHooks() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseHooks_invalid(t *testing.T) {
+	for _, conf := range []string{
+		"on-add name ~ x => reject",
+		"on-remove: * => reject",
+		"on-add: * reject",
+		"on-add: size > lots => reject",
+		"on-add: * => explode",
+		"on-complete: * => reject",
+		"on-add: name ~ ( => reject",
+	} {
+		if _, err := ParseHooks(conf); err == nil {
+			t.Errorf("ParseHooks(%q) expected error", conf)
+		}
+	}
+}
+
+func TestDoneCmdRoutes(t *testing.T) {
+	routes, err := ParseDoneCmdRoutes(`
+# comment
+label == tv => /opt/scripts/sonarr-notify.sh
+tracker == linuxtracker.org and size > 1GB => "/opt/scripts/rsync-iso.sh"
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r doneCmdRouter
+	r.set(routes)
+	iso := "http://linuxtracker.org:2710/announce"
+	tests := []struct {
+		name string
+		ht   hookTarget
+		want string
+	}{
+		{"label", hookTarget{Labels: []string{"tv"}}, "/opt/scripts/sonarr-notify.sh"},
+		{"tracker", hookTarget{Size: 2 << 30, Trackers: []string{iso}}, "/opt/scripts/rsync-iso.sh"},
+		{"tracker small", hookTarget{Size: 1 << 20, Trackers: []string{iso}}, ""},
+		{"none", hookTarget{Name: "x"}, ""},
+	}
+	for _, tt := range tests {
+		if got := r.route(tt.ht); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"label == tv", "label == tv =>", "foo => /bin/true"} {
+		if _, err := ParseDoneCmdRoutes(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
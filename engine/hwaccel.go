@@ -0,0 +1,62 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var errHWAccel = errors.New("expecting -hwaccel <name> [-hwaccel_device <device>] [-hwaccel_output_format <format>]")
+
+var (
+	hwAccels = map[string]bool{
+		"auto": true, "cuda": true, "vaapi": true, "qsv": true, "videotoolbox": true,
+		"dxva2": true, "d3d11va": true, "vdpau": true, "drm": true, "opencl": true, "vulkan": true,
+	}
+	hwOutputFormats = map[string]bool{
+		"cuda": true, "vaapi": true, "qsv": true, "d3d11": true, "dxva2_vld": true,
+		"videotoolbox_vld": true, "drm_prime": true, "vulkan": true,
+	}
+	// a device node, or the index of the gpu
+	hwDeviceRe = regexp.MustCompile(`^(/dev/[\w/.-]+|\d+)$`)
+)
+
+// HWAccelArgs parses the TranscodeHWAccel into the ffmpeg arguments, only
+// the known accelerations and formats are accepted, so the config can't
+// pass other inputs or outputs to ffmpeg
+func HWAccelArgs(s string) ([]string, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	if len(fields)%2 != 0 {
+		return nil, errHWAccel
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < len(fields); i += 2 {
+		opt, v := fields[i], fields[i+1]
+		if seen[opt] {
+			return nil, fmt.Errorf("%w: %s repeated", errHWAccel, opt)
+		}
+		seen[opt] = true
+		var ok bool
+		switch opt {
+		case "-hwaccel":
+			ok = hwAccels[v]
+		case "-hwaccel_device":
+			ok = hwDeviceRe.MatchString(v)
+		case "-hwaccel_output_format":
+			ok = hwOutputFormats[v]
+		default:
+			return nil, fmt.Errorf("%w: unknown option %q", errHWAccel, opt)
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: invalid %s %q", errHWAccel, opt, v)
+		}
+	}
+	if !seen["-hwaccel"] {
+		return nil, errHWAccel
+	}
+	return fields, nil
+}
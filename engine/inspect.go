@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Inspection is the parsed content of a torrent file or magnet, the fields
+// a magnet doesn't carry are left empty
+type Inspection struct {
+	InfoHash     string
+	InfoHashV2   string `json:",omitempty"`
+	Name         string
+	IsMagnet     bool
+	Private      bool
+	PieceLength  int64
+	Pieces       int
+	Size         int64
+	Files        []InspectFile
+	Trackers     []string
+	WebSeeds     []string
+	CreationDate time.Time
+	CreatedBy    string
+	Comment      string
+}
+
+// InspectFile is a file listed in the torrent
+type InspectFile struct {
+	Path string
+	Size int64
+	// skipped by the ExcludeFiles unless selected
+	Excluded bool `json:",omitempty"`
+	// of the BlockedFileTypes
+	Blocked bool `json:",omitempty"`
+}
+
+// InspectMagnet parses a magnet link without adding it
+func InspectMagnet(magnetURI string) (*Inspection, error) {
+	magnetURI, err := normalizeMagnet(magnetURI)
+	if err != nil {
+		return nil, err
+	}
+	m, err := metainfo.ParseMagnetUri(magnetURI)
+	if err != nil {
+		return nil, err
+	}
+	return &Inspection{
+		InfoHash: m.InfoHash.HexString(),
+		Name:     m.DisplayName,
+		IsMagnet: true,
+		Trackers: m.Trackers,
+		WebSeeds: m.Params["ws"],
+	}, nil
+}
+
+// InspectTorrent parses a torrent file without adding it
+func InspectTorrent(data []byte) (*Inspection, error) {
+	mi, err := metainfo.Load(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return nil, err
+	}
+	ins := &Inspection{
+		InfoHash:    mi.HashInfoBytes().HexString(),
+		Name:        info.Name,
+		Private:     isPrivate(&info),
+		PieceLength: info.PieceLength,
+		Pieces:      info.NumPieces(),
+		Size:        info.TotalLength(),
+		Trackers:    mi.UpvertedAnnounceList().DistinctValues(),
+		WebSeeds:    mi.UrlList,
+		CreatedBy:   mi.CreatedBy,
+		Comment:     mi.Comment,
+	}
+	ins.InfoHashV2, _ = infoV2(mi.InfoBytes)
+	if mi.CreationDate > 0 {
+		ins.CreationDate = time.Unix(mi.CreationDate, 0)
+	}
+	for _, fi := range info.UpvertedFiles() {
+		p := info.Name
+		if len(info.Files) > 0 {
+			p = strings.Join(append([]string{info.Name}, fi.Path...), "/")
+		}
+		ins.Files = append(ins.Files, InspectFile{Path: p, Size: fi.Length})
+	}
+	return ins, nil
+}
@@ -0,0 +1,89 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	ipProbeTimeout = 3 * time.Second
+	// the head start of the preferred family, as the happy eyeballs (RFC 8305)
+	ipFallbackDelay = 300 * time.Millisecond
+)
+
+var ErrInvalidIPPreference = errors.New("Invalid peer IP preference")
+
+// the anycast DNS servers dialed to tell whether a family is routed
+var ipProbeAddrs = map[string][]string{
+	"tcp4": {"1.1.1.1:443", "8.8.8.8:443"},
+	"tcp6": {"[2606:4700:4700::1111]:443", "[2001:4860:4860::8888]:443"},
+}
+
+func checkIPPreference(pref string) error {
+	switch pref {
+	case "", "auto", "ipv4", "ipv6":
+		return nil
+	}
+	return fmt.Errorf("%w: %s, expecting auto, ipv4 or ipv6", ErrInvalidIPPreference, pref)
+}
+
+// familyReachable dials the probe addrs of the network after the delay,
+// true once one answers
+func familyReachable(ctx context.Context, network string, delay time.Duration) bool {
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		return false
+	}
+	addrs := ipProbeAddrs[network]
+	res := make(chan bool, len(addrs))
+	var d net.Dialer
+	for _, a := range addrs {
+		go func(a string) {
+			c, err := d.DialContext(ctx, network, a)
+			if err == nil {
+				c.Close()
+			}
+			res <- err == nil
+		}(a)
+	}
+	for range addrs {
+		if <-res {
+			return true
+		}
+	}
+	return false
+}
+
+// peerFamilies is the IP families the peers are connected over. The
+// preferred one only if reachable, else the other; on auto both, less
+// the one unreachable, as a broken IPv6 route of a VPS stalls the dials
+// till they time out. Both when neither answers, offline or firewalled.
+func peerFamilies(pref string) (ipv4, ipv6 bool) {
+	first, second := "tcp4", "tcp6"
+	if pref == "ipv6" {
+		first, second = second, first
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ipProbeTimeout)
+	defer cancel()
+	firstOK, secondOK := make(chan bool, 1), make(chan bool, 1)
+	go func() { firstOK <- familyReachable(ctx, first, 0) }()
+	go func() { secondOK <- familyReachable(ctx, second, ipFallbackDelay) }()
+
+	r := map[string]bool{first: <-firstOK}
+	if r[first] && pref != "" && pref != "auto" {
+		// no need to wait for the other
+		cancel()
+	}
+	r[second] = <-secondOK
+	switch {
+	case !r[first] && !r[second]:
+		return true, true
+	case r[first] && pref != "" && pref != "auto":
+		return first == "tcp4", first == "tcp6"
+	}
+	return r["tcp4"], r["tcp6"]
+}
@@ -0,0 +1,50 @@
+package engine
+
+import (
+	"net"
+	"testing"
+)
+
+func Test_peerFamilies(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	// not an IPv6 addr, the tcp6 dials fail at once
+	up, down := l.Addr().String(), "127.0.0.1:1"
+
+	saved := ipProbeAddrs
+	defer func() { ipProbeAddrs = saved }()
+	tests := []struct {
+		name     string
+		pref     string
+		ipv4Addr string
+		wantIPv4 bool
+		wantIPv6 bool
+	}{
+		{"auto, ipv6 broken", "auto", up, true, false},
+		{"ipv4", "ipv4", up, true, false},
+		{"ipv6 falling back", "ipv6", up, true, false},
+		{"offline", "auto", down, true, true},
+		{"ipv4 offline", "ipv4", down, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ipProbeAddrs = map[string][]string{"tcp4": {tt.ipv4Addr}, "tcp6": {up}}
+			ipv4, ipv6 := peerFamilies(tt.pref)
+			if ipv4 != tt.wantIPv4 || ipv6 != tt.wantIPv6 {
+				t.Errorf("peerFamilies() = %v, %v, want %v, %v", ipv4, ipv6, tt.wantIPv4, tt.wantIPv6)
+			}
+		})
+	}
+}
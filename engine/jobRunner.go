@@ -0,0 +1,221 @@
+package engine
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxJobHistory   = 100
+	maxJobOutput    = 64 * 1024
+	jobRetryBackoff = 30 * time.Second
+)
+
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobSuccess = "success"
+	JobFailed  = "failed"
+)
+
+var ErrJobNotFound = errors.New("Job not found")
+
+// Job is a single run of DoneCmd, or the command routed by DoneCmdRoutes
+type Job struct {
+	ID         int64
+	InfoHash   string
+	Path       string
+	Type       string
+	Cmd        string
+	Status     string
+	Attempt    int
+	ExitCode   int
+	Error      string
+	Output     string
+	QueuedAt   time.Time
+	StartedAt  time.Time
+	FinishedAt time.Time
+	env        []string
+}
+
+// jobRunner queues up the DoneCmd calls, runs them with limited concurrency
+// and keeps a history of the recent runs
+type jobRunner struct {
+	sync.Mutex
+	cond    *sync.Cond
+	e       *Engine
+	nextID  int64
+	running int
+	history []*Job
+}
+
+func newJobRunner(e *Engine) *jobRunner {
+	r := &jobRunner{e: e}
+	r.cond = sync.NewCond(&r.Mutex)
+	return r
+}
+
+func (r *jobRunner) submit(ih, path, tasktype, cmd string, env []string) *Job {
+	r.Lock()
+	defer r.Unlock()
+	r.nextID++
+	j := &Job{
+		ID:       r.nextID,
+		InfoHash: ih,
+		Path:     path,
+		Type:     tasktype,
+		Cmd:      cmd,
+		Status:   JobQueued,
+		QueuedAt: time.Now(),
+		env:      env,
+	}
+	r.history = append(r.history, j)
+	if len(r.history) > maxJobHistory {
+		r.history = r.history[len(r.history)-maxJobHistory:]
+	}
+	go r.run(j)
+	return j
+}
+
+func (r *jobRunner) acquire() {
+	r.Lock()
+	defer r.Unlock()
+	for {
+		max := r.e.config.DoneCmdConcurrency
+		if max <= 0 || r.running < max {
+			break
+		}
+		r.cond.Wait()
+	}
+	r.running++
+}
+
+func (r *jobRunner) release() {
+	r.Lock()
+	r.running--
+	r.Unlock()
+	r.cond.Broadcast()
+}
+
+func (r *jobRunner) run(j *Job) {
+	r.acquire()
+	defer r.release()
+
+	cmdPath := j.Cmd
+	if cmdPath == "" {
+		r.finish(j, -1, errors.New("unconfigred Donecmd"))
+		return
+	}
+
+	r.Lock()
+	j.Status = JobRunning
+	j.Attempt++
+	j.StartedAt = time.Now()
+	j.Output = ""
+	r.Unlock()
+
+	cmd := exec.Command(cmdPath)
+	cmd.Env = j.env
+	sout, _ := cmd.StdoutPipe()
+	serr, _ := cmd.StderrPipe()
+	log.Printf("[DoneCmd:%s]%sCMD:`%s' ENV:%s", j.Type, j.InfoHash, cmd.String(), cmd.Env)
+	if err := cmd.Start(); err != nil {
+		r.finish(j, -1, err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go r.scanOutput(j, sout, &wg, fmt.Sprintf("[DoneCmd:%s]%sO:", log.filteredArg(j.Type, j.InfoHash)...))
+	go r.scanOutput(j, serr, &wg, fmt.Sprintf("[DoneCmd:%s]%sE:", log.filteredArg(j.Type, j.InfoHash)...))
+	wg.Wait()
+
+	// call Wait will close pipes above
+	err := cmd.Wait()
+	r.finish(j, cmd.ProcessState.ExitCode(), err)
+}
+
+// scanOutput logs the command output and captures it into the job
+func (r *jobRunner) scanOutput(j *Job, p io.ReadCloser, wg *sync.WaitGroup, logprefix string) {
+	defer wg.Done()
+	sc := bufio.NewScanner(p)
+	for sc.Scan() {
+		oline := strings.TrimSpace(sc.Text())
+		if len(oline) == 0 {
+			continue
+		}
+		log.Println(logprefix, oline)
+		r.Lock()
+		if len(j.Output) < maxJobOutput {
+			j.Output += oline + "\n"
+		}
+		r.Unlock()
+	}
+}
+
+func (r *jobRunner) finish(j *Job, code int, err error) {
+	r.Lock()
+	defer r.Unlock()
+	j.ExitCode = code
+	j.FinishedAt = time.Now()
+	if err == nil {
+		j.Status = JobSuccess
+		j.Error = ""
+		log.Printf("[DoneCmd:%s]%sExit code: %d", j.Type, j.InfoHash, code)
+		return
+	}
+
+	j.Status = JobFailed
+	j.Error = err.Error()
+	log.Printf("[DoneCmd:%s]%sERR: %v", j.Type, j.InfoHash, err)
+	if j.Attempt > 0 && j.Attempt <= r.e.config.DoneCmdRetry {
+		j.Status = JobQueued
+		delay := jobRetryBackoff * time.Duration(j.Attempt)
+		log.Printf("[DoneCmd:%s]%sretry in %s", j.Type, j.InfoHash, delay)
+		time.AfterFunc(delay, func() { r.run(j) })
+	}
+}
+
+// retry runs a finished job again
+func (r *jobRunner) retry(id int64) error {
+	r.Lock()
+	defer r.Unlock()
+	for _, j := range r.history {
+		if j.ID == id {
+			if j.Status == JobQueued || j.Status == JobRunning {
+				return fmt.Errorf("job %d is %s", id, j.Status)
+			}
+			j.Status = JobQueued
+			go r.run(j)
+			return nil
+		}
+	}
+	return ErrJobNotFound
+}
+
+// list returns copies of the recent jobs, the latest first
+func (r *jobRunner) list() []Job {
+	r.Lock()
+	defer r.Unlock()
+	jobs := make([]Job, 0, len(r.history))
+	for i := len(r.history) - 1; i >= 0; i-- {
+		jobs = append(jobs, *r.history[i])
+	}
+	return jobs
+}
+
+// DoneCmdJobs lists the recent DoneCmd runs
+func (e *Engine) DoneCmdJobs() []Job {
+	return e.jobs.list()
+}
+
+// RetryDoneCmdJob runs a finished DoneCmd job again
+func (e *Engine) RetryDoneCmdJob(id int64) error {
+	return e.jobs.retry(id)
+}
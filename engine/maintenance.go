@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrMaintenance = errors.New("In maintenance mode")
+
+// MaintenanceStatus tells whether the engine is in maintenance mode, where
+// all tasks are paused and nothing new is started
+type MaintenanceStatus struct {
+	Enabled bool
+	Reason  string
+	Since   time.Time
+}
+
+type maintenanceState struct {
+	sync.Mutex
+	status MaintenanceStatus
+	// the tasks started before the maintenance
+	resume []string
+}
+
+// Maintenance reports the maintenance mode
+func (e *Engine) Maintenance() MaintenanceStatus {
+	e.maintenance.Lock()
+	defer e.maintenance.Unlock()
+	return e.maintenance.status
+}
+
+func (e *Engine) inMaintenance() bool {
+	return e.Maintenance().Enabled
+}
+
+// SetMaintenance enters the maintenance mode, stopping the started tasks
+// and the watcher, or leaves it, starting them again
+func (e *Engine) SetMaintenance(on bool, reason string) error {
+	e.maintenance.Lock()
+	if e.maintenance.status.Enabled == on {
+		e.maintenance.Unlock()
+		return nil
+	}
+
+	if !on {
+		resume := e.maintenance.resume
+		e.maintenance.status = MaintenanceStatus{}
+		e.maintenance.resume = nil
+		e.maintenance.Unlock()
+		log.Println("[Maintenance] off, resuming", len(resume), "tasks")
+		if e.config.WatchDirectory != "" {
+			if err := e.StartTorrentWatcher(); err != nil {
+				log.Println("[Maintenance]", err)
+			}
+		}
+		for _, ih := range resume {
+			if err := e.StartTorrent(ih); err != nil {
+				log.Println("[Maintenance]", ih, err)
+			}
+		}
+		e.TsChanged <- struct{}{}
+		return nil
+	}
+
+	e.maintenance.status = MaintenanceStatus{Enabled: true, Reason: reason, Since: time.Now()}
+	e.maintenance.Unlock()
+	log.Println("[Maintenance] on,", reason)
+
+	e.stopTorrentWatcher()
+	var resume []string
+	for ih, rt := range e.resumeTasks() {
+		if !rt.Started {
+			continue
+		}
+		if err := e.StopTorrent(ih); err != nil {
+			log.Println("[Maintenance]", ih, err)
+			continue
+		}
+		resume = append(resume, ih)
+	}
+	e.maintenance.Lock()
+	e.maintenance.resume = resume
+	e.maintenance.Unlock()
+	e.TsChanged <- struct{}{}
+	return nil
+}
@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// the manifest is saved as <file or top dir of the task>.sha256
+const manifestExt = ".sha256"
+
+var ErrNoManifest = errors.New("No checksum manifest of the task")
+
+// manifestPath is the manifest of the task, beside its data
+func (t *Torrent) manifestPath() string {
+	t.Lock()
+	defer t.Unlock()
+	return filepath.Join(t.dataDir(), t.diskName()+manifestExt)
+}
+
+// writeManifest hashes the files of the task downloaded into a SHA-256
+// manifest, in the sha256sum format with the paths relative to the data
+// dir, so `sha256sum -c` checks a copy without the torrent
+func (t *Torrent) writeManifest() error {
+	t.Lock()
+	files := append([]*File(nil), t.Files...)
+	dir := t.dataDir()
+	t.Unlock()
+	if len(files) == 0 {
+		return ErrNotLoaded
+	}
+
+	start := time.Now()
+	var b bytes.Buffer
+	var n int
+	for i, f := range files {
+		if f == nil || !f.Done || isPaddingFile(f.Path) || (f.f != nil && !t.isSelected(f.f.Path())) {
+			continue
+		}
+		sum, err := fileSHA256(filepath.Join(dir, filepath.FromSlash(f.Path)))
+		if err != nil {
+			return fmt.Errorf("file %d: %w", i, err)
+		}
+		fmt.Fprintf(&b, "%s  %s\n", sum, f.Path)
+		n++
+	}
+	fn := t.manifestPath()
+	tmp := fn + ".tmp"
+	if err := ioutil.WriteFile(tmp, b.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, fn); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if o := t.e.owner; o != nil {
+		o.apply(fn, false)
+	}
+	log.Printf("[Manifest]%s %d files hashed in %s", t.InfoHash, n, time.Since(start))
+	return nil
+}
+
+func isFile(fn string) bool {
+	st, err := os.Stat(fn)
+	return err == nil && st.Mode().IsRegular()
+}
+
+// Manifest is the checksum manifest of the task, and its path
+func (e *Engine) Manifest(infohash string) (string, []byte, error) {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return "", nil, err
+	}
+	fn := t.manifestPath()
+	data, err := ioutil.ReadFile(fn)
+	if os.IsNotExist(err) {
+		return "", nil, ErrNoManifest
+	}
+	return fn, data, err
+}
+
+// GenerateManifest hashes the files of the completed task into its
+// manifest, in the background
+func (e *Engine) GenerateManifest(infohash string) error {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+	t.Lock()
+	done := t.Done
+	t.Unlock()
+	if !done {
+		return errors.New("Task not completed")
+	}
+	go func() {
+		if err := t.writeManifest(); err != nil {
+			log.Println("[Manifest]", infohash, err)
+		}
+	}()
+	return nil
+}
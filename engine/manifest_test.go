@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTorrent_writeManifest(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "show"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range []string{"e01.mkv", "e02.mkv"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "show", fn), []byte("abc"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	task := &Torrent{InfoHash: "ih", Directory: dir, e: &Engine{}, Files: []*File{
+		{Path: "show/e01.mkv", Done: true},
+		{Path: "show/.pad/3", Done: true},
+		// not downloaded
+		{Path: "show/e02.mkv"},
+	}}
+	if err := task.writeManifest(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "show"+manifestExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad  show/e01.mkv\n"
+	if string(data) != want {
+		t.Errorf("writeManifest() = %q, want %q", data, want)
+	}
+}
@@ -0,0 +1,152 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	taskMetaExt     = ".meta"
+	maxMetaKeys     = 64
+	maxMetaValueLen = 4096
+)
+
+var ErrInvalidMeta = errors.New("Invalid task metadata")
+
+// TaskMeta is the free-form notes and key/value metadata attached to a task
+// by the user or the automations, e.g. the ids of external services
+type TaskMeta struct {
+	Notes *string           `json:",omitempty"`
+	Meta  map[string]string `json:",omitempty"`
+}
+
+// metaFileName is saved in the cache dir next to the task's torrent file,
+// so it goes along with the backups
+func (e *Engine) metaFileName(infohash string) string {
+	return filepath.Join(e.cacheDir, fmt.Sprintf("%s%s%s", cacheSavedPrefix, infohash, taskMetaExt))
+}
+
+// loadTaskMeta restores the saved notes and metadata of a new task
+func (e *Engine) loadTaskMeta(t *Torrent) {
+	data, err := ioutil.ReadFile(e.metaFileName(t.InfoHash))
+	if err != nil {
+		return
+	}
+	var m TaskMeta
+	if err := json.Unmarshal(data, &m); err != nil {
+		log.Println("[TaskMeta]", t.InfoHash, err)
+		return
+	}
+	if m.Notes != nil {
+		t.Notes = *m.Notes
+	}
+	t.Meta = m.Meta
+}
+
+// SetTaskMeta updates the notes if not nil, and merges the metadata, an
+// empty value deletes the key
+func (e *Engine) SetTaskMeta(infohash string, m TaskMeta) error {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	t.Lock()
+	meta := make(map[string]string)
+	for k, v := range t.Meta {
+		meta[k] = v
+	}
+	for k, v := range m.Meta {
+		k = strings.TrimSpace(k)
+		if k == "" || len(v) > maxMetaValueLen {
+			t.Unlock()
+			return ErrInvalidMeta
+		}
+		if v == "" {
+			delete(meta, k)
+		} else {
+			meta[k] = v
+		}
+	}
+	if len(meta) > maxMetaKeys {
+		t.Unlock()
+		return fmt.Errorf("%w: more than %d keys", ErrInvalidMeta, maxMetaKeys)
+	}
+	if len(meta) == 0 {
+		meta = nil
+	}
+	notes := t.Notes
+	if m.Notes != nil {
+		notes = *m.Notes
+	}
+	data, err := json.Marshal(TaskMeta{Notes: &notes, Meta: meta})
+	if err == nil {
+		err = ioutil.WriteFile(e.metaFileName(infohash), data, 0644)
+	}
+	if err == nil {
+		t.Notes = notes
+		t.Meta = meta
+	}
+	t.Unlock()
+	if err != nil {
+		return err
+	}
+	e.TsChanged <- struct{}{}
+	return nil
+}
+
+func (e *Engine) removeTaskMeta(infohash string) {
+	if err := os.Remove(e.metaFileName(infohash)); err != nil && !os.IsNotExist(err) {
+		log.Println("[TaskMeta]", infohash, err)
+	}
+}
+
+// matchTask tells whether the task's name, labels, notes or metadata
+// contain the query, case insensitive. A query of key=value matches the
+// metadata exactly.
+func (t *Torrent) matchTask(query string) bool {
+	t.Lock()
+	defer t.Unlock()
+	if kv := strings.SplitN(query, "=", 2); len(kv) == 2 {
+		v, ok := t.Meta[kv[0]]
+		return ok && v == kv[1]
+	}
+	q := strings.ToLower(query)
+	contains := func(s string) bool {
+		return strings.Contains(strings.ToLower(s), q)
+	}
+	if contains(t.Name) || contains(t.Notes) {
+		return true
+	}
+	for _, l := range t.Labels {
+		if contains(l) {
+			return true
+		}
+	}
+	for k, v := range t.Meta {
+		if contains(k) || contains(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// SearchTasks returns the tasks matching the query, see matchTask
+func (e *Engine) SearchTasks(query string) map[string]*Torrent {
+	e.RLock()
+	defer e.RUnlock()
+	res := make(map[string]*Torrent)
+	for ih, t := range e.ts {
+		if t.matchTask(query) {
+			res[ih] = t
+		}
+	}
+	return res
+}
@@ -0,0 +1,119 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// the stages of resolving the info of a magnet task
+const (
+	MetaStagePeers   = "peers"
+	MetaStageSources = "sources"
+)
+
+const (
+	metadataFetchWait = 30 * time.Second
+	maxMetadataLen    = 10 << 20
+)
+
+var ErrMetadataNotFound = errors.New("Metadata not found from the peers or sources")
+
+// metadataTimer fires when the task has waited MetadataTimeout for the info
+// from the peers, nil if it's not waiting
+func (e *Engine) metadataTimer(tt *torrent.Torrent) <-chan time.Time {
+	if e.config.MetadataTimeout <= 0 || tt.Info() != nil {
+		return nil
+	}
+	return time.After(e.config.MetadataTimeout)
+}
+
+// metadataURLs expands the MetadataSources of an infohash, one each line,
+// with {infohash} or {INFOHASH} replaced
+func (c *Config) metadataURLs(ih string) []string {
+	var urls []string
+	for _, l := range strings.Split(c.MetadataSources, "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		l = strings.ReplaceAll(l, "{infohash}", strings.ToLower(ih))
+		l = strings.ReplaceAll(l, "{INFOHASH}", strings.ToUpper(ih))
+		urls = append(urls, l)
+	}
+	return urls
+}
+
+// fetchInfoBytes downloads a torrent file and returns its info if it's of ih
+func fetchInfoBytes(u string, ih metainfo.Hash) ([]byte, error) {
+	client := http.Client{Timeout: metadataFetchWait}
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxMetadataLen))
+	if err != nil {
+		return nil, err
+	}
+	mi, err := metainfo.Load(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", u, err)
+	}
+	if mi.HashInfoBytes() != ih {
+		return nil, fmt.Errorf("%s: infohash mismatch", u)
+	}
+	return mi.InfoBytes, nil
+}
+
+// fetchMetadata tries the MetadataSources for the info of a magnet task
+// which timed out on the peers, unless the magnet has trackers of its own,
+// which may be private. The task is errored if still without the info,
+// while it keeps waiting the peers.
+func (e *Engine) fetchMetadata(tt *torrent.Torrent, t *Torrent) {
+	ih := tt.InfoHash()
+	var urls []string
+	t.Lock()
+	if !t.ownTrackers {
+		urls = e.config.metadataURLs(ih.HexString())
+	}
+	if len(urls) > 0 {
+		t.MetaStage = MetaStageSources
+	}
+	t.Unlock()
+	for _, u := range urls {
+		if tt.Info() != nil {
+			return
+		}
+		info, err := fetchInfoBytes(u, ih)
+		if err != nil {
+			log.Println("[fetchMetadata]", err)
+			continue
+		}
+		if err := tt.SetInfoBytes(info); err != nil {
+			log.Println("[fetchMetadata]", u, err)
+			continue
+		}
+		log.Println("[fetchMetadata] got info from", u)
+		return
+	}
+	t.Lock()
+	if tt.Info() != nil {
+		t.Unlock()
+		return
+	}
+	t.MetaStage = MetaStagePeers
+	t.Unlock()
+	t.setError(fmt.Errorf("%w in %s", ErrMetadataNotFound, e.config.MetadataTimeout))
+}
@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestConfig_metadataURLs(t *testing.T) {
+	c := &Config{MetadataSources: "https://a.example/{infohash}.torrent\n\n# disabled\n  https://b.example/{INFOHASH}  \n"}
+	got := c.metadataURLs("AbCd")
+	want := []string{"https://a.example/abcd.torrent", "https://b.example/ABCD"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("metadataURLs() = %v, want %v", got, want)
+	}
+}
+
+func Test_fetchInfoBytes(t *testing.T) {
+	infoBytes, err := bencode.Marshal(metainfo.Info{Name: "a", PieceLength: 16 << 10, Length: 1, Pieces: make([]byte, 20)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mi := metainfo.MetaInfo{InfoBytes: infoBytes}
+	ih := mi.HashInfoBytes()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok.torrent":
+			mi.Write(w) // nolint: errcheck
+		case "/junk.torrent":
+			w.Write([]byte("<html>")) // nolint: errcheck
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		path    string
+		ih      metainfo.Hash
+		wantErr string
+	}{
+		{"found", "/ok.torrent", ih, ""},
+		{"other torrent", "/ok.torrent", metainfo.Hash{1}, "infohash mismatch"},
+		{"not a torrent", "/junk.torrent", ih, "junk.torrent"},
+		{"missing", "/none.torrent", ih, "404"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fetchInfoBytes(srv.URL+tt.path, tt.ih)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("fetchInfoBytes() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, infoBytes) {
+				t.Errorf("fetchInfoBytes() = %v, %v, want the info", got, err)
+			}
+		})
+	}
+}
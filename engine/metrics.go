@@ -0,0 +1,217 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	metricsTimeout     = 10 * time.Second
+	minMetricsInterval = 10 * time.Second
+)
+
+var ErrInvalidMetricsPush = errors.New("Invalid metrics push endpoint")
+
+// metricSample is the stats of the engine, or of a task if infohash is set
+type metricSample struct {
+	infohash, name string
+	fields         map[string]float64
+}
+
+// parseMetricsPush checks the MetricsPush url: the http(s) write endpoint
+// of InfluxDB taking the line protocol, eg. http://host:8086/write?db=<db>,
+// or graphite://host[:2003] for the plaintext protocol of Graphite
+func parseMetricsPush(push string) (*url.URL, error) {
+	if push == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(push)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMetricsPush, err)
+	}
+	switch {
+	case u.Hostname() == "":
+	case u.Scheme == "http" || u.Scheme == "https":
+		return u, nil
+	case u.Scheme == "graphite":
+		if u.Port() == "" {
+			u.Host = net.JoinHostPort(u.Hostname(), "2003")
+		}
+		return u, nil
+	}
+	return nil, fmt.Errorf("%w: %s, expecting http(s):// or graphite://", ErrInvalidMetricsPush, maskURL(push))
+}
+
+// metricSamples are the stats of the engine, then of each task
+func (e *Engine) metricSamples() []metricSample {
+	st := e.mqttStatus()
+	cs := e.ConnStat()
+	samples := []metricSample{{fields: map[string]float64{
+		"torrents":      float64(st.Torrents),
+		"downloading":   float64(st.Downloading),
+		"seeding":       float64(st.Seeding),
+		"download_rate": float64(st.DownloadRate),
+		"upload_rate":   float64(st.UploadRate),
+		"bytes_read":    float64(cs.BytesReadData.Int64()),
+		"bytes_written": float64(cs.BytesWrittenData.Int64()),
+	}}}
+	for _, s := range TaskStatuses {
+		samples[0].fields["status_"+string(s)] = float64(st.Statuses[s])
+	}
+
+	e.RLock()
+	for ih, t := range e.ts {
+		t.Lock()
+		samples = append(samples, metricSample{infohash: ih, name: t.Name, fields: map[string]float64{
+			"size":          float64(t.Size),
+			"downloaded":    float64(t.Downloaded),
+			"uploaded":      float64(t.Uploaded),
+			"percent":       float64(t.Percent),
+			"download_rate": float64(t.DownloadRate),
+			"upload_rate":   float64(t.UploadRate),
+			"ratio":         float64(t.SeedRatio),
+			"seeders":       float64(t.Swarm.Seeders),
+			"leechers":      float64(t.Swarm.Leechers),
+		}})
+		t.Unlock()
+	}
+	e.RUnlock()
+	sort.Slice(samples[1:], func(i, j int) bool { return samples[i+1].infohash < samples[j+1].infohash })
+	return samples
+}
+
+func sortedKeys(fields map[string]float64) []string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var influxTagEscape = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", "")
+
+// formatInflux writes the samples in the line protocol, the measurement of
+// the engine is prefix, the one of the tasks prefix_torrent tagged by the
+// infohash and the name
+func formatInflux(samples []metricSample, prefix string, at time.Time) []byte {
+	var b bytes.Buffer
+	for _, s := range samples {
+		b.WriteString(influxTagEscape.Replace(prefix))
+		if s.infohash != "" {
+			b.WriteString("_torrent,infohash=" + s.infohash)
+			if name := influxTagEscape.Replace(s.name); name != "" {
+				b.WriteString(",name=" + name)
+			}
+		}
+		for i, k := range sortedKeys(s.fields) {
+			sep := ","
+			if i == 0 {
+				sep = " "
+			}
+			b.WriteString(sep + k + "=" + strconv.FormatFloat(s.fields[k], 'f', -1, 64))
+		}
+		fmt.Fprintf(&b, " %d\n", at.UnixNano())
+	}
+	return b.Bytes()
+}
+
+// formatGraphite writes the samples in the plaintext protocol, at
+// <prefix>.<field> for the engine, <prefix>.torrent.<infohash>.<field> for
+// the tasks
+func formatGraphite(samples []metricSample, prefix string, at time.Time) []byte {
+	var b bytes.Buffer
+	for _, s := range samples {
+		path := prefix
+		if s.infohash != "" {
+			path += ".torrent." + s.infohash
+		}
+		for _, k := range sortedKeys(s.fields) {
+			fmt.Fprintf(&b, "%s.%s %s %d\n", path, k, strconv.FormatFloat(s.fields[k], 'f', -1, 64), at.Unix())
+		}
+	}
+	return b.Bytes()
+}
+
+// metricsInterval is the MetricsPushInterval, 0 without a MetricsPush
+func (e *Engine) metricsInterval() time.Duration {
+	e.RLock()
+	defer e.RUnlock()
+	if e.config.MetricsPush == "" {
+		return 0
+	}
+	if e.config.MetricsPushInterval < minMetricsInterval {
+		return minMetricsInterval
+	}
+	return e.config.MetricsPushInterval
+}
+
+// metricsTask pushes the stats of the engine and the tasks to the
+// MetricsPush
+func (e *Engine) metricsTask() error {
+	e.RLock()
+	push, prefix := e.config.MetricsPush, e.config.MetricsPrefix
+	e.RUnlock()
+	if push == "" {
+		return nil
+	}
+	return e.pushMetrics(push, prefix)
+}
+
+func (e *Engine) pushMetrics(push, prefix string) error {
+	resolved, err := ResolveSecrets(push)
+	if err != nil {
+		return err
+	}
+	u, err := parseMetricsPush(resolved)
+	if err != nil {
+		return err
+	}
+	samples, now := e.metricSamples(), time.Now()
+
+	if u.Scheme == "graphite" {
+		conn, err := net.DialTimeout("tcp", u.Host, metricsTimeout)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(metricsTimeout))
+		_, err = conn.Write(formatGraphite(samples, prefix, now))
+		return err
+	}
+
+	// the credentials go as the basic auth, like the InfluxDB 1.x and the
+	// compatible /write of the 2.x (with the token as the password) take
+	endpoint := *u
+	endpoint.User = nil
+	req, err := http.NewRequest("POST", endpoint.String(), bytes.NewReader(formatInflux(samples, prefix, now)))
+	if err != nil {
+		return err
+	}
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		req.SetBasicAuth(u.User.Username(), pass)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	client := &http.Client{Timeout: metricsTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
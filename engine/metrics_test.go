@@ -0,0 +1,49 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseMetricsPush(t *testing.T) {
+	tests := []struct {
+		push string
+		host string
+		err  bool
+	}{
+		{"", "", false},
+		{"http://influx:8086/write?db=torrents", "influx:8086", false},
+		{"graphite://carbon", "carbon:2003", false},
+		{"graphite://carbon:2004", "carbon:2004", false},
+		{"udp://carbon", "", true},
+		{"http:///write", "", true},
+	}
+	for _, tt := range tests {
+		u, err := parseMetricsPush(tt.push)
+		if (err != nil) != tt.err || (err != nil && !errors.Is(err, ErrInvalidMetricsPush)) {
+			t.Errorf("parseMetricsPush(%q) error = %v", tt.push, err)
+			continue
+		}
+		if u != nil && u.Host != tt.host {
+			t.Errorf("parseMetricsPush(%q) host = %s, want %s", tt.push, u.Host, tt.host)
+		}
+	}
+}
+
+func TestFormatMetrics(t *testing.T) {
+	at := time.Unix(1600000000, 0)
+	samples := []metricSample{
+		{fields: map[string]float64{"torrents": 2, "download_rate": 1.5}},
+		{infohash: "abcd", name: "A name, v=2", fields: map[string]float64{"size": 100}},
+	}
+	wantInflux := "st download_rate=1.5,torrents=2 1600000000000000000\n" +
+		`st_torrent,infohash=abcd,name=A\ name\,\ v\=2 size=100 1600000000000000000` + "\n"
+	if got := string(formatInflux(samples, "st", at)); got != wantInflux {
+		t.Errorf("formatInflux() = %q, want %q", got, wantInflux)
+	}
+	wantGraphite := "st.download_rate 1.5 1600000000\nst.torrents 2 1600000000\nst.torrent.abcd.size 100 1600000000\n"
+	if got := string(formatGraphite(samples, "st", at)); got != wantGraphite {
+		t.Errorf("formatGraphite() = %q, want %q", got, wantGraphite)
+	}
+}
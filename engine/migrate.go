@@ -0,0 +1,197 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/spf13/viper"
+)
+
+const (
+	legacyConfigFile = "cloud-torrent.json"
+	// written next to the legacy config, so it's migrated only once
+	migratedMark = ".cloud-torrent-migrated"
+)
+
+// legacyConfigKeys are the keys of the old cloud-torrent configs which
+// changed, the others are imported by name if still exist
+var legacyConfigKeys = map[string]func(v interface{}) (string, interface{}){
+	"disableencryption": func(v interface{}) (string, interface{}) {
+		b, _ := v.(bool)
+		return "ObfsPreferred", !b
+	},
+	"trackerlisturl": func(v interface{}) (string, interface{}) {
+		return "TrackerList", fmt.Sprintf("remote:%v", v)
+	},
+}
+
+// MigrateReport is what's imported from an old cloud-torrent installation
+type MigrateReport struct {
+	From     string
+	Config   []string
+	Torrents int
+	Magnets  int
+}
+
+// FindLegacyInstall looks for the config of an old cloud-torrent in the
+// dirs, which isn't migrated yet
+func FindLegacyInstall(dirs ...string) string {
+	for _, d := range dirs {
+		cf := filepath.Join(d, legacyConfigFile)
+		if _, err := os.Stat(cf); err != nil {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(d, migratedMark)); err == nil {
+			continue
+		}
+		if abs, err := filepath.Abs(cf); err == nil {
+			return abs
+		}
+	}
+	return ""
+}
+
+// MigrateLegacy imports an old cloud-torrent installation: the settings of
+// its config into c (and the config file), then the torrents and magnets
+// cached in its download directory into the cache dir of c, restored as
+// tasks when the engine starts.
+func MigrateLegacy(legacyConf string, c *Config, instance string) (*MigrateReport, error) {
+	data, err := ioutil.ReadFile(legacyConf)
+	if err != nil {
+		return nil, err
+	}
+	var old map[string]interface{}
+	if err := json.Unmarshal(data, &old); err != nil {
+		return nil, fmt.Errorf("%s: %w", legacyConf, err)
+	}
+
+	rp := &MigrateReport{From: legacyConf}
+	names := configFieldNames()
+	for k, v := range old {
+		var name string
+		if conv, ok := legacyConfigKeys[strings.ToLower(k)]; ok {
+			name, v = conv(v)
+		} else if name, ok = names[strings.ToLower(k)]; !ok {
+			log.Println("[Migrate] ignored", k)
+			continue
+		}
+		if err := decodeConfigField(c, name, v); err != nil {
+			log.Println("[Migrate] ignored", name, err)
+			continue
+		}
+		viper.Set(name, v)
+		rp.Config = append(rp.Config, name)
+	}
+
+	legacyDir := filepath.Dir(legacyConf)
+	if !filepath.IsAbs(c.DownloadDirectory) {
+		// relative to the old installation
+		c.DownloadDirectory = filepath.Join(legacyDir, c.DownloadDirectory)
+	}
+	if dirChanged, err := c.NormlizeConfigDir(); err != nil {
+		return nil, err
+	} else if dirChanged {
+		viper.Set("DownloadDirectory", c.DownloadDirectory)
+		viper.Set("WatchDirectory", c.WatchDirectory)
+		viper.Set("FinishedDirectory", c.FinishedDirectory)
+	}
+	if err := c.WriteDefault(); err != nil {
+		return nil, err
+	}
+
+	cacheDir := filepath.Join(c.DownloadDirectory, namespaced(CachedTorrentDir, instance))
+	mkdir(cacheDir)
+	for _, dir := range []string{
+		filepath.Join(c.DownloadDirectory, CachedTorrentDir),
+		filepath.Join(legacyDir, "downloads", CachedTorrentDir),
+	} {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, fi := range files {
+			if fi.IsDir() {
+				continue
+			}
+			fn := filepath.Join(dir, fi.Name())
+			switch filepath.Ext(fn) {
+			case ".torrent":
+				if importLegacyTorrent(fn, cacheDir) {
+					rp.Torrents++
+				}
+			case ".info", ".magnet":
+				if importLegacyMagnet(fn, cacheDir) {
+					rp.Magnets++
+				}
+			}
+		}
+	}
+
+	mark := fmt.Sprintf("migrated at %s: %d config keys, %d torrents, %d magnets\n",
+		time.Now().Format(time.RFC3339), len(rp.Config), rp.Torrents, rp.Magnets)
+	if err := ioutil.WriteFile(filepath.Join(legacyDir, migratedMark), []byte(mark), 0644); err != nil {
+		log.Println("[Migrate] can't mark as migrated", err)
+	}
+	return rp, nil
+}
+
+// importLegacyTorrent copies a cached torrent file as the cache file of the
+// task, if not already there
+func importLegacyTorrent(fn, cacheDir string) bool {
+	info, err := metainfo.LoadFromFile(fn)
+	if err != nil {
+		log.Println("[Migrate]", fn, err)
+		return false
+	}
+	dst := filepath.Join(cacheDir, fmt.Sprintf("%s%s.torrent", cacheSavedPrefix, info.HashInfoBytes().HexString()))
+	if _, err := os.Stat(dst); err == nil {
+		return false
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		log.Println("[Migrate]", err)
+		return false
+	}
+	defer f.Close()
+	if err := info.Write(f); err != nil {
+		log.Println("[Migrate]", err)
+		return false
+	}
+	return true
+}
+
+// importLegacyMagnet saves a cached magnet as the magnet cache file of the
+// task, if not already there
+func importLegacyMagnet(fn, cacheDir string) bool {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		log.Println("[Migrate]", fn, err)
+		return false
+	}
+	magnet := strings.TrimSpace(string(data))
+	spec, err := torrent.TorrentSpecFromMagnetUri(magnet)
+	if err != nil {
+		log.Println("[Migrate]", fn, err)
+		return false
+	}
+	ih := spec.InfoHash.HexString()
+	dst := filepath.Join(cacheDir, fmt.Sprintf("%s%s.info", cacheSavedPrefix, ih))
+	torrentDst := filepath.Join(cacheDir, fmt.Sprintf("%s%s.torrent", cacheSavedPrefix, ih))
+	for _, f := range []string{dst, torrentDst} {
+		if _, err := os.Stat(f); err == nil {
+			return false
+		}
+	}
+	if err := ioutil.WriteFile(dst, []byte(magnet), 0644); err != nil {
+		log.Println("[Migrate]", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,259 @@
+package engine
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	mqttStatusTick  = time.Minute
+	mqttKeepAlive   = 2 * mqttStatusTick
+	mqttDialTimeout = 10 * time.Second
+)
+
+var ErrInvalidMQTTBroker = errors.New("Invalid MQTT broker")
+
+// MQTTStatus is published retained to <MQTTTopicPrefix>/status
+type MQTTStatus struct {
+	Torrents     int
+	Downloading  int
+	Seeding      int
+	DownloadRate float32
+	UploadRate   float32
+	Statuses     map[TaskStatus]int
+}
+
+// parseMQTTBroker checks the MQTTBroker url:
+// mqtt://[user:password@]host[:1883][?client_id=<id>], or mqtts:// for TLS
+// (port 8883) with ?insecure=1 to skip the certificate verification
+func parseMQTTBroker(broker string) (*url.URL, error) {
+	if broker == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(broker)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMQTTBroker, err)
+	}
+	if (u.Scheme != "mqtt" && u.Scheme != "mqtts") || u.Hostname() == "" {
+		return nil, fmt.Errorf("%w: %s, expecting mqtt:// or mqtts://", ErrInvalidMQTTBroker, maskURL(broker))
+	}
+	if u.Port() == "" {
+		port := "1883"
+		if u.Scheme == "mqtts" {
+			port = "8883"
+		}
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+	return u, nil
+}
+
+// mqttClient is a publisher of MQTT 3.1.1 with QoS 0
+type mqttClient struct {
+	broker string
+	conn   net.Conn
+	w      *bufio.Writer
+}
+
+// mqttRoutine publishes the task events to <MQTTTopicPrefix>/event/<type>,
+// and the status every minute, to the MQTTBroker
+func (e *Engine) mqttRoutine() {
+	events, cancel := e.SubscribeEvents(64)
+	defer cancel()
+	tk := time.NewTicker(mqttStatusTick)
+	defer tk.Stop()
+	mc := &mqttClient{}
+	defer mc.close()
+	for {
+		var topic string
+		var payload interface{}
+		retain := false
+		select {
+		case ev := <-events:
+			topic, payload = "event/"+ev.Type, ev
+		case <-tk.C:
+			topic, payload, retain = "status", e.mqttStatus(), true
+		}
+		e.RLock()
+		broker, prefix := e.config.MQTTBroker, e.config.MQTTTopicPrefix
+		e.RUnlock()
+		if broker == "" {
+			mc.close()
+			continue
+		}
+		b, err := json.Marshal(payload)
+		if err != nil {
+			log.Println("[MQTT]", err)
+			continue
+		}
+		if err := mc.publish(broker, prefix+"/"+topic, b, retain); err != nil {
+			log.Printf("[MQTT] publish %s: %s", topic, err)
+			mc.close()
+		}
+	}
+}
+
+func (e *Engine) mqttStatus() MQTTStatus {
+	var st MQTTStatus
+	e.RLock()
+	defer e.RUnlock()
+	st.Statuses = make(map[TaskStatus]int)
+	for _, t := range e.ts {
+		t.Lock()
+		st.Torrents++
+		st.Statuses[t.Status]++
+		if t.Started {
+			if t.Done {
+				st.Seeding++
+			} else {
+				st.Downloading++
+			}
+		}
+		st.DownloadRate += t.DownloadRate
+		st.UploadRate += t.UploadRate
+		t.Unlock()
+	}
+	return st
+}
+
+// publish connects to the broker first, or again when it's changed
+func (mc *mqttClient) publish(broker, topic string, payload []byte, retain bool) error {
+	if mc.conn != nil && mc.broker != broker {
+		mc.close()
+	}
+	if mc.conn == nil {
+		if err := mc.connect(broker); err != nil {
+			return err
+		}
+	}
+	header := byte(0x30)
+	if retain {
+		header |= 0x01
+	}
+	var body []byte
+	body = appendMQTTString(body, topic)
+	body = append(body, payload...)
+	mc.conn.SetWriteDeadline(time.Now().Add(mqttDialTimeout))
+	if err := writeMQTTPacket(mc.w, header, body); err != nil {
+		return err
+	}
+	return mc.w.Flush()
+}
+
+func (mc *mqttClient) connect(broker string) error {
+	resolved, err := ResolveSecrets(broker)
+	if err != nil {
+		return err
+	}
+	u, err := parseMQTTBroker(resolved)
+	if err != nil {
+		return err
+	}
+	d := &net.Dialer{Timeout: mqttDialTimeout}
+	var conn net.Conn
+	if u.Scheme == "mqtts" {
+		conn, err = tls.DialWithDialer(d, "tcp", u.Host, &tls.Config{
+			ServerName:         u.Hostname(),
+			InsecureSkipVerify: u.Query().Get("insecure") != "",
+		})
+	} else {
+		conn, err = d.Dial("tcp", u.Host)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(mqttDialTimeout))
+	if err := mqttHandshake(conn, u); err != nil {
+		conn.Close()
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+	mc.broker, mc.conn, mc.w = broker, conn, bufio.NewWriter(conn)
+	log.Println("[MQTT] connected to", u.Host)
+	return nil
+}
+
+// mqttHandshake sends the CONNECT of a clean session and reads the CONNACK
+func mqttHandshake(rw io.ReadWriter, u *url.URL) error {
+	clientID := u.Query().Get("client_id")
+	if clientID == "" {
+		host, _ := os.Hostname()
+		clientID = "simple-torrent-" + host
+	}
+	flags := byte(0x02)
+	var body []byte
+	body = appendMQTTString(body, "MQTT")
+	body = append(body, 4, 0, 0, 0)
+	binary.BigEndian.PutUint16(body[len(body)-2:], uint16(mqttKeepAlive/time.Second))
+	body = appendMQTTString(body, clientID)
+	if u.User != nil {
+		flags |= 0x80
+		body = appendMQTTString(body, u.User.Username())
+		if p, ok := u.User.Password(); ok {
+			flags |= 0x40
+			body = appendMQTTString(body, p)
+		}
+	}
+	body[7] = flags
+	w := bufio.NewWriter(rw)
+	if err := writeMQTTPacket(w, 0x10, body); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	ack := make([]byte, 4)
+	if _, err := io.ReadFull(rw, ack); err != nil {
+		return err
+	}
+	if ack[0] != 0x20 || ack[1] != 2 {
+		return errors.New("unexpected CONNACK")
+	}
+	if ack[3] != 0 {
+		return fmt.Errorf("connection refused, code %d", ack[3])
+	}
+	return nil
+}
+
+func (mc *mqttClient) close() {
+	if mc.conn == nil {
+		return
+	}
+	if writeMQTTPacket(mc.w, 0xe0, nil) == nil {
+		mc.w.Flush()
+	}
+	mc.conn.Close()
+	mc.conn, mc.w = nil, nil
+}
+
+func appendMQTTString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
+
+func writeMQTTPacket(w *bufio.Writer, header byte, body []byte) error {
+	w.WriteByte(header)
+	// the remaining length, 7 bits a byte
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		w.WriteByte(b)
+		if n == 0 {
+			break
+		}
+	}
+	_, err := w.Write(body)
+	return err
+}
@@ -0,0 +1,392 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Notifications are the built-in notifiers configured in the Notifications
+// option, one channel each line:
+//
+//	<event>[,<event>...] => <channel url>
+//
+// events:   add, complete, stalled, error, watchdog, or * for all
+// channels: smtp://[user:password@]host[:587]/?from=<addr>&to=<addr>[,<addr>...]
+//           (smtps:// for the implicit TLS on 465)
+//           pushover://<app token>@<user key>
+//           gotify://host[:port][/path]/<app token>
+//           ntfy://[user:password@]host/<topic>
+// gotify and ntfy are requested over https, unless with ?scheme=http.
+//
+// eg.
+//
+//	error, stalled => smtp://me:${env:SMTP_PASS}@smtp.example.com/?from=ct@example.com&to=me@example.com
+//	complete => ntfy://ntfy.sh/my-downloads
+
+const notifyTimeout = 30 * time.Second
+
+var errNotifyEvents = errors.New("missing events")
+
+// notification is what's sent to a channel
+type notification struct {
+	Event    string
+	Title    string
+	Message  string
+	Critical bool
+}
+
+type notifyRoute struct {
+	// nil for all events
+	events map[string]bool
+	target *url.URL
+	send   func(u *url.URL, n *notification) error
+}
+
+var notifiers = map[string]struct {
+	check func(u *url.URL) error
+	send  func(u *url.URL, n *notification) error
+}{
+	"smtp":     {checkSMTP, sendSMTP},
+	"smtps":    {checkSMTP, sendSMTP},
+	"pushover": {checkPushover, sendPushover},
+	"gotify":   {checkGotify, sendGotify},
+	"ntfy":     {checkNtfy, sendNtfy},
+}
+
+var notifyClient = &http.Client{Timeout: notifyTimeout}
+
+// ParseNotifications parses the notification channels, one each line, with
+// the secret references expanded
+func ParseNotifications(conf string) ([]*notifyRoute, error) {
+	var routes []*notifyRoute
+	for n, l := range strings.Split(conf, "\n") {
+		line := strings.TrimSpace(l)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r, err := parseNotifyRoute(line)
+		if err != nil {
+			return nil, fmt.Errorf("notifications line %d: %w", n+1, err)
+		}
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+func parseNotifyRoute(line string) (*notifyRoute, error) {
+	er := strings.SplitN(line, "=>", 2)
+	if len(er) != 2 {
+		return nil, errors.New("missing =>")
+	}
+	r := &notifyRoute{events: make(map[string]bool)}
+	all := false
+	for _, ev := range strings.Split(er[0], ",") {
+		switch ev = strings.TrimSpace(ev); ev {
+		case "*":
+			all = true
+		case EventAdd, EventComplete, EventStalled, EventError, EventWatchdog, EventBlocked, EventQuota:
+			r.events[ev] = true
+		case "":
+			return nil, errNotifyEvents
+		default:
+			return nil, fmt.Errorf("unknown event %q", ev)
+		}
+	}
+	if all {
+		r.events = nil
+	}
+
+	target, err := ResolveSecrets(strings.TrimSpace(er[1]))
+	if err != nil {
+		return nil, err
+	}
+	if r.target, err = url.Parse(target); err != nil {
+		return nil, err
+	}
+	nt, ok := notifiers[r.target.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unknown channel %q", r.target.Scheme)
+	}
+	if err := nt.check(r.target); err != nil {
+		return nil, fmt.Errorf("%s: %w", r.target.Scheme, err)
+	}
+	r.send = nt.send
+	return r, nil
+}
+
+// maskNotifications hides the passwords and tokens of the channels
+func maskNotifications(conf string) string {
+	lines := strings.Split(conf, "\n")
+	for i, l := range lines {
+		er := strings.SplitN(l, "=>", 2)
+		if len(er) != 2 {
+			continue
+		}
+		u, err := url.Parse(strings.TrimSpace(er[1]))
+		if err != nil {
+			// with secret references
+			continue
+		}
+		masked := true
+		switch {
+		case u.Scheme == "pushover" && u.User != nil:
+			u.User = url.User(secretMask)
+		case u.Scheme == "gotify":
+			path, token := gotifyToken(u)
+			u.Path = path + "/" + secretMask
+			masked = token != ""
+		case u.User != nil:
+			_, masked = u.User.Password()
+			u.User = url.UserPassword(u.User.Username(), secretMask)
+		default:
+			masked = false
+		}
+		if masked {
+			// the url escapes the *
+			lines[i] = er[0] + "=> " + strings.ReplaceAll(u.String(), strings.Repeat("%2A", len(secretMask)), secretMask)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sendNotifications sends the event to the channels routed, in background
+func (e *Engine) sendNotifications(evType string, t *Torrent, msg string) {
+	e.rulesMu.RLock()
+	routes := e.notifyRoutes
+	e.rulesMu.RUnlock()
+	var n *notification
+	for _, r := range routes {
+		if r.events != nil && !r.events[evType] {
+			continue
+		}
+		if n == nil {
+			n = newNotification(e.cld.GetStrAttribute("Title"), evType, t.Name, msg)
+		}
+		if e.holdNotification(r, n) {
+			continue
+		}
+		go func(r *notifyRoute) {
+			if err := r.send(r.target, n); err != nil {
+				log.Printf("[Notify] %s %s: %s", r.target.Scheme, evType, err)
+			}
+		}(r)
+	}
+}
+
+func newNotification(title, evType, name, msg string) *notification {
+	if title == "" {
+		title = "SimpleTorrent"
+	}
+	n := &notification{
+		Event:    evType,
+		Title:    fmt.Sprintf("%s: %s", title, evType),
+		Message:  name,
+		Critical: evType == EventError || evType == EventWatchdog,
+	}
+	switch evType {
+	case EventAdd:
+		n.Title = fmt.Sprintf("%s: added", title)
+	case EventComplete:
+		n.Title = fmt.Sprintf("%s: completed", title)
+	}
+	if msg != "" {
+		n.Message += "\n" + msg
+	}
+	return n
+}
+
+// notifyHTTPBase is the https (or ?scheme=http) base url of a channel
+func notifyHTTPBase(u *url.URL) string {
+	scheme := u.Query().Get("scheme")
+	if scheme == "" {
+		scheme = "https"
+	}
+	return scheme + "://" + u.Host
+}
+
+func notifyPost(req *http.Request) error {
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+func checkSMTP(u *url.URL) error {
+	q := u.Query()
+	if u.Hostname() == "" {
+		return errors.New("missing host")
+	}
+	if q.Get("from") == "" || q.Get("to") == "" {
+		return errors.New("missing from or to")
+	}
+	return nil
+}
+
+func sendSMTP(u *url.URL, n *notification) error {
+	q := u.Query()
+	from, to := q.Get("from"), strings.Split(q.Get("to"), ",")
+	for i := range to {
+		to[i] = strings.TrimSpace(to[i])
+	}
+	host, port := u.Hostname(), u.Port()
+	if port == "" {
+		port = "587"
+		if u.Scheme == "smtps" {
+			port = "465"
+		}
+	}
+	addr := net.JoinHostPort(host, port)
+	var auth smtp.Auth
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		auth = smtp.PlainAuth("", u.User.Username(), pass, host)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n"+
+		"Content-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		from, strings.Join(to, ", "), mime.QEncoding.Encode("utf-8", n.Title),
+		time.Now().Format(time.RFC1123Z), strings.ReplaceAll(n.Message, "\n", "\r\n"))
+
+	if u.Scheme == "smtp" {
+		// with STARTTLS if the server supports
+		return smtp.SendMail(addr, auth, from, to, []byte(msg))
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: notifyTimeout}, "tcp", addr, &tls.Config{ServerName: host})
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func checkPushover(u *url.URL) error {
+	if u.User == nil || u.User.Username() == "" || u.Host == "" {
+		return errors.New("expecting pushover://<app token>@<user key>")
+	}
+	return nil
+}
+
+func sendPushover(u *url.URL, n *notification) error {
+	form := url.Values{
+		"token":   {u.User.Username()},
+		"user":    {u.Host},
+		"title":   {n.Title},
+		"message": {n.Message},
+	}
+	if n.Critical {
+		form.Set("priority", "1")
+	}
+	req, err := http.NewRequest(http.MethodPost, "https://api.pushover.net/1/messages.json",
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return notifyPost(req)
+}
+
+// gotifyToken splits the app token off the path
+func gotifyToken(u *url.URL) (path, token string) {
+	p := strings.TrimRight(u.Path, "/")
+	i := strings.LastIndex(p, "/")
+	if i < 0 {
+		return "", ""
+	}
+	return p[:i], p[i+1:]
+}
+
+func checkGotify(u *url.URL) error {
+	if _, token := gotifyToken(u); u.Host == "" || token == "" {
+		return errors.New("expecting gotify://host/<app token>")
+	}
+	return nil
+}
+
+func sendGotify(u *url.URL, n *notification) error {
+	path, token := gotifyToken(u)
+	priority := 5
+	if n.Critical {
+		priority = 8
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"title":    n.Title,
+		"message":  n.Message,
+		"priority": priority,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost,
+		notifyHTTPBase(u)+path+"/message?token="+url.QueryEscape(token), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return notifyPost(req)
+}
+
+func checkNtfy(u *url.URL) error {
+	if u.Host == "" || strings.Trim(u.Path, "/") == "" {
+		return errors.New("expecting ntfy://host/<topic>")
+	}
+	return nil
+}
+
+func sendNtfy(u *url.URL, n *notification) error {
+	req, err := http.NewRequest(http.MethodPost, notifyHTTPBase(u)+u.Path, strings.NewReader(n.Message))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", mime.QEncoding.Encode("utf-8", n.Title))
+	req.Header.Set("Tags", n.Event)
+	if n.Critical {
+		req.Header.Set("Priority", "high")
+	}
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		req.SetBasicAuth(u.User.Username(), pass)
+	}
+	return notifyPost(req)
+}
@@ -0,0 +1,424 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c2h5oh/datasize"
+	"gopkg.in/yaml.v2"
+)
+
+// Policies are the rules of the PoliciesFile, a YAML file apart from the
+// config, read again each PoliciesInterval so an edit applies without a
+// restart:
+//
+//	dryRun: false
+//	policies:
+//	  - name: tv seeded
+//	    when: label=tv AND ratio>2
+//	    action: stop
+//	  - name: old tracker
+//	    when: tracker=tracker.example.com AND seedtime>30d
+//	    action: remove-data
+//
+// conditions: label=, label!=, tracker= (the domain or a subdomain),
+//             tracker~ <regexp>, name~, name!~, status=, status!=,
+//             ratio, seedtime, age (since added) and size with >, <, >=, <=,
+//             private, public, and the Release of the name: title~, title=,
+//             group=, group!=, quality, season and episode with =, != and
+//             the comparisons, eg. quality>=1080p
+// actions:    stop, start, remove (the data kept), remove-data
+//
+// The first policy matching a task acts on it, the dry run only reports.
+
+const (
+	PolicyStop       = "stop"
+	PolicyStart      = "start"
+	PolicyRemove     = "remove"
+	PolicyRemoveData = "remove-data"
+
+	RemovedByPolicy = "policy"
+)
+
+var (
+	ErrInvalidPolicy = errors.New("Invalid policy")
+	ErrNoPolicies    = errors.New("No PoliciesFile configured")
+)
+
+var (
+	policyAndRe  = regexp.MustCompile(`(?i)\s+and\s+`)
+	policyCondRe = regexp.MustCompile(`^([a-z]+)\s*(!=|>=|<=|!~|=|~|>|<)\s*(.+)$`)
+)
+
+// PolicyAction is a task matched by a policy
+type PolicyAction struct {
+	Policy   string
+	InfoHash string
+	Name     string
+	Action   string
+	// reported only, by the dryRun of the file or ?dryrun=1
+	DryRun bool
+	Error  string `json:",omitempty"`
+}
+
+// PolicyReport is an evaluation of the policies, reported by GET
+// /api/policies
+type PolicyReport struct {
+	At      time.Time
+	DryRun  bool
+	Actions []PolicyAction
+	Error   string `json:",omitempty"`
+}
+
+type policyFile struct {
+	DryRun   bool         `yaml:"dryRun"`
+	Policies []policySpec `yaml:"policies"`
+}
+
+type policySpec struct {
+	Name   string `yaml:"name"`
+	When   string `yaml:"when"`
+	Action string `yaml:"action"`
+}
+
+type policy struct {
+	name   string
+	conds  []policyCond
+	action string
+}
+
+type policyCond struct {
+	field string
+	op    string
+	value string
+	re    *regexp.Regexp
+	// ratio, bytes, seconds, lines or number
+	num float64
+}
+
+// policyTarget is what the policy conditions test against
+type policyTarget struct {
+	hookTarget
+	Release  Release
+	Status   TaskStatus
+	Ratio    float64
+	SeedTime time.Duration
+	Age      time.Duration
+}
+
+type policyState struct {
+	sync.Mutex
+	last PolicyReport
+}
+
+// loadPolicies reads the policies file
+func loadPolicies(fn string) ([]*policy, bool, error) {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, false, err
+	}
+	var pf policyFile
+	if err := yaml.UnmarshalStrict(data, &pf); err != nil {
+		return nil, false, fmt.Errorf("%w: %s", ErrInvalidPolicy, err)
+	}
+	policies := make([]*policy, 0, len(pf.Policies))
+	for n, ps := range pf.Policies {
+		p, err := parsePolicy(ps)
+		if err != nil {
+			return nil, false, fmt.Errorf("%w #%d %s", err, n+1, ps.Name)
+		}
+		policies = append(policies, p)
+	}
+	return policies, pf.DryRun, nil
+}
+
+func parsePolicy(ps policySpec) (*policy, error) {
+	p := &policy{name: ps.Name, action: strings.TrimSpace(ps.Action)}
+	if p.name == "" {
+		p.name = ps.When
+	}
+	switch p.action {
+	case PolicyStop, PolicyStart, PolicyRemove, PolicyRemoveData:
+	default:
+		return nil, fmt.Errorf("%w: unknown action %q", ErrInvalidPolicy, ps.Action)
+	}
+	for _, cs := range policyAndRe.Split(strings.TrimSpace(ps.When), -1) {
+		c, err := parsePolicyCond(cs)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidPolicy, err)
+		}
+		p.conds = append(p.conds, c)
+	}
+	return p, nil
+}
+
+func parsePolicyCond(cs string) (policyCond, error) {
+	switch cs {
+	case "private", "public":
+		return policyCond{field: cs}, nil
+	}
+	m := policyCondRe.FindStringSubmatch(cs)
+	if m == nil {
+		return policyCond{}, fmt.Errorf("condition %q", cs)
+	}
+	c := policyCond{field: m[1], op: m[2], value: unquote(m[3])}
+	var err error
+	switch c.field + " " + c.op {
+	case "name ~", "name !~", "tracker ~", "title ~", "title !~":
+		c.re, err = regexp.Compile(c.value)
+	case "label =", "label !=", "tracker =", "title =", "group =", "group !=":
+	case "status =", "status !=":
+		if !knownStatus(TaskStatus(c.value)) {
+			err = ErrInvalidStatus
+		}
+	case "ratio >", "ratio <", "ratio >=", "ratio <=":
+		c.num, err = strconv.ParseFloat(c.value, 64)
+	case "size >", "size <", "size >=", "size <=":
+		var v datasize.ByteSize
+		err = v.UnmarshalText([]byte(strings.ToLower(c.value)))
+		c.num = float64(v)
+	case "quality =", "quality !=", "quality >", "quality <", "quality >=", "quality <=":
+		if c.num = qualityRank(c.value); c.num == 0 {
+			err = errors.New("unknown quality")
+		}
+	case "season =", "season !=", "season >", "season <", "season >=", "season <=",
+		"episode =", "episode !=", "episode >", "episode <", "episode >=", "episode <=":
+		c.num, err = strconv.ParseFloat(c.value, 64)
+	case "seedtime >", "seedtime <", "seedtime >=", "seedtime <=", "age >", "age <", "age >=", "age <=":
+		var d time.Duration
+		d, err = parsePolicyDuration(c.value)
+		c.num = d.Seconds()
+	default:
+		return c, fmt.Errorf("condition %q", cs)
+	}
+	if err != nil {
+		return c, fmt.Errorf("condition %q: %s", cs, err)
+	}
+	return c, nil
+}
+
+// parsePolicyDuration is a time.Duration, or a number of days like 30d
+func parsePolicyDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(s, "d"), 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+func comparePolicy(v float64, op string, ref float64) bool {
+	switch op {
+	case "=":
+		return v == ref
+	case "!=":
+		return v != ref
+	case ">":
+		return v > ref
+	case "<":
+		return v < ref
+	case ">=":
+		return v >= ref
+	case "<=":
+		return v <= ref
+	}
+	return false
+}
+
+func (c *policyCond) match(pt *policyTarget) bool {
+	switch c.field {
+	case "private":
+		return pt.Private
+	case "public":
+		return !pt.Private
+	case "name":
+		return c.re.MatchString(pt.Name) == (c.op == "~")
+	case "label":
+		return hasLabel(pt.Labels, c.value) == (c.op == "=")
+	case "tracker":
+		for _, tr := range pt.Trackers {
+			if c.re != nil && c.re.MatchString(tr) || c.re == nil && trackerInDomain(tr, c.value) {
+				return true
+			}
+		}
+		return false
+	case "title":
+		if c.re != nil {
+			return c.re.MatchString(pt.Release.Title) == (c.op == "~")
+		}
+		return NormalizeReleaseTitle(pt.Release.Title) == NormalizeReleaseTitle(c.value)
+	case "group":
+		return strings.EqualFold(pt.Release.Group, c.value) == (c.op == "=")
+	case "quality":
+		q := qualityRank(pt.Release.Quality)
+		return q != 0 && comparePolicy(q, c.op, c.num)
+	case "season":
+		return comparePolicy(float64(pt.Release.Season), c.op, c.num)
+	case "episode":
+		return comparePolicy(float64(pt.Release.Episode), c.op, c.num)
+	case "status":
+		return (pt.Status == TaskStatus(c.value)) == (c.op == "=")
+	case "ratio":
+		return comparePolicy(pt.Ratio, c.op, c.num)
+	case "size":
+		return comparePolicy(float64(pt.Size), c.op, c.num)
+	case "seedtime":
+		return comparePolicy(pt.SeedTime.Seconds(), c.op, c.num)
+	case "age":
+		return comparePolicy(pt.Age.Seconds(), c.op, c.num)
+	}
+	return false
+}
+
+func (p *policy) match(pt *policyTarget) bool {
+	for _, c := range p.conds {
+		if !c.match(pt) {
+			return false
+		}
+	}
+	return true
+}
+
+// policyTarget is the task as the policies see it, with t locked
+func (t *Torrent) policyTarget(now time.Time) policyTarget {
+	pt := policyTarget{
+		hookTarget: t.hookTarget(),
+		Release:    ParseRelease(t.Name),
+		Status:     t.Status,
+		Ratio:      float64(t.SeedRatio),
+		Age:        now.Sub(t.AddedAt),
+	}
+	if t.Done && !t.FinishedAt.IsZero() {
+		pt.SeedTime = now.Sub(t.FinishedAt)
+	}
+	return pt
+}
+
+// Policies is the last evaluation of the policies
+func (e *Engine) Policies() PolicyReport {
+	e.policies.Lock()
+	defer e.policies.Unlock()
+	return e.policies.last
+}
+
+func (e *Engine) policiesInterval() time.Duration {
+	e.RLock()
+	defer e.RUnlock()
+	if e.config.PoliciesFile == "" {
+		return 0
+	}
+	return e.config.PoliciesInterval
+}
+
+func (e *Engine) policiesTask() error {
+	_, err := e.EvaluatePolicies(false)
+	return err
+}
+
+// EvaluatePolicies runs the policies of the PoliciesFile on the tasks,
+// acting on them unless dryRun or the dryRun of the file
+func (e *Engine) EvaluatePolicies(dryRun bool) (PolicyReport, error) {
+	e.RLock()
+	fn := e.config.PoliciesFile
+	ts := make([]*Torrent, 0, len(e.ts))
+	for _, t := range e.ts {
+		ts = append(ts, t)
+	}
+	e.RUnlock()
+	report := PolicyReport{At: time.Now(), DryRun: dryRun}
+	if fn == "" {
+		return report, ErrNoPolicies
+	}
+	policies, fileDryRun, err := loadPolicies(fn)
+	if err != nil {
+		report.Error = err.Error()
+		if !dryRun {
+			e.setPolicyReport(report)
+		}
+		return report, err
+	}
+	report.DryRun = dryRun || fileDryRun
+
+	for _, t := range ts {
+		t.Lock()
+		pt := t.policyTarget(report.At)
+		ih, name, started := t.InfoHash, t.Name, t.Started
+		t.Unlock()
+		for _, p := range policies {
+			if !p.match(&pt) {
+				continue
+			}
+			// already so, not reported each round
+			if p.action == PolicyStop && !started || p.action == PolicyStart && started {
+				break
+			}
+			pa := PolicyAction{Policy: p.name, InfoHash: ih, Name: name, Action: p.action, DryRun: report.DryRun}
+			if !report.DryRun {
+				if err := e.applyPolicy(t, p); err != nil {
+					pa.Error = err.Error()
+				}
+				log.Printf("[Policies] %s: %s %s %s", p.name, p.action, ih, name)
+			}
+			report.Actions = append(report.Actions, pa)
+			break
+		}
+	}
+	// a dry run asked doesn't hide what the scheduled ones did
+	if !dryRun {
+		e.setPolicyReport(report)
+	}
+	if !report.DryRun && len(report.Actions) > 0 {
+		e.TsChanged <- struct{}{}
+	}
+	return report, nil
+}
+
+func (e *Engine) setPolicyReport(r PolicyReport) {
+	e.policies.Lock()
+	e.policies.last = r
+	e.policies.Unlock()
+}
+
+func (e *Engine) applyPolicy(t *Torrent, p *policy) error {
+	switch p.action {
+	case PolicyStop:
+		return e.StopTorrent(t.InfoHash)
+	case PolicyStart:
+		return e.StartTorrent(t.InfoHash)
+	case PolicyRemove:
+		return e.RemoveTorrent(t.InfoHash, RemovedByPolicy+" "+p.name)
+	case PolicyRemoveData:
+		t.Lock()
+		dir, name, started := t.dataDir(), t.diskName(), t.Started
+		t.Unlock()
+		if started {
+			if err := e.StopTorrent(t.InfoHash); err != nil {
+				return err
+			}
+		}
+		if err := e.RemoveTorrent(t.InfoHash, RemovedByPolicy+" "+p.name); err != nil {
+			return err
+		}
+		return removeTaskData(dir, name)
+	}
+	return nil
+}
+
+// removeTaskData deletes the file or the top dir of a task in its data dir
+func removeTaskData(dir, name string) error {
+	p := filepath.Join(dir, name)
+	if rel, err := filepath.Rel(dir, p); err != nil || name == "" || rel == "." || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("refused to delete %q of %s", name, dir)
+	}
+	return os.RemoveAll(p)
+}
@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPolicyMatch(t *testing.T) {
+	pt := &policyTarget{
+		hookTarget: hookTarget{
+			Name:     "Show.S01E01.1080p",
+			Labels:   []string{"tv"},
+			Trackers: []string{"udp://tracker.example.com:1337/announce"},
+			Size:     2 << 30,
+		},
+		Release:  ParseRelease("Show.S01E01.1080p"),
+		Status:   StatusSeeding,
+		Ratio:    2.5,
+		SeedTime: 40 * 24 * time.Hour,
+		Age:      41 * 24 * time.Hour,
+	}
+	tests := []struct {
+		when string
+		want bool
+	}{
+		{"label=tv AND ratio>2", true},
+		{"label=tv and ratio>3", false},
+		{"label!=movies", true},
+		{"tracker=example.com AND seedtime>30d", true},
+		{"tracker~ other\\.org", false},
+		{"name~ (?i)s01e\\d+", true},
+		{"name!~ 1080p", false},
+		{"status=seeding AND public", true},
+		{"private", false},
+		{"size>=2GB AND size<3GB", true},
+		{"age<24h", false},
+		{"quality>=1080p AND season=1 AND episode=1", true},
+		{"quality>1080p", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.when, func(t *testing.T) {
+			p, err := parsePolicy(policySpec{When: tt.when, Action: PolicyStop})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := p.match(pt); got != tt.want {
+				t.Errorf("match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePolicyInvalid(t *testing.T) {
+	for _, ps := range []policySpec{
+		{When: "ratio>2", Action: "delete"},
+		{When: "ratio>two", Action: PolicyStop},
+		{When: "status=done", Action: PolicyStop},
+		{When: "seeds>2", Action: PolicyStop},
+		{When: "name~ (", Action: PolicyStop},
+	} {
+		if _, err := parsePolicy(ps); !errors.Is(err, ErrInvalidPolicy) {
+			t.Errorf("parsePolicy(%q %q) err = %v", ps.When, ps.Action, err)
+		}
+	}
+}
+
+func TestLoadPolicies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "policies.yaml")
+	data := "dryRun: true\npolicies:\n  - name: tv seeded\n    when: label=tv AND ratio>2\n    action: stop\n"
+	if err := ioutil.WriteFile(fn, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	policies, dryRun, err := loadPolicies(fn)
+	if err != nil || !dryRun || len(policies) != 1 || policies[0].name != "tv seeded" || len(policies[0].conds) != 2 {
+		t.Errorf("loadPolicies() = %v, %v, %v", policies, dryRun, err)
+	}
+
+	if err := ioutil.WriteFile(fn, []byte("policies:\n  - when: ratio>2\n    act: stop\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadPolicies(fn); !errors.Is(err, ErrInvalidPolicy) {
+		t.Errorf("unknown key err = %v", err)
+	}
+}
+
+func TestRemoveTaskData(t *testing.T) {
+	for _, name := range []string{"", ".", "../x", "a/../../x"} {
+		if err := removeTaskData("/tmp/downloads", name); err == nil {
+			t.Errorf("removeTaskData(%q) not refused", name)
+		}
+	}
+}
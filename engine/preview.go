@@ -0,0 +1,397 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+const (
+	taskSelectionExt = ".selection"
+	// how long a resolved preview waits for the confirmation
+	previewTTL = 15 * time.Minute
+)
+
+var (
+	ErrMissingPreview   = errors.New("Missing preview, resolve it again")
+	ErrPreviewCanceled  = errors.New("Preview canceled")
+	ErrInvalidSelection = errors.New("Invalid file selection")
+)
+
+// preview is a magnet resolving its info, or a torrent waiting for the
+// confirmation of its add
+type preview struct {
+	meta    *metainfo.MetaInfo
+	expires time.Time
+	// closed to stop the resolving
+	cancel chan struct{}
+	// closed once the resolving is over
+	done chan struct{}
+}
+
+type previewState struct {
+	sync.Mutex
+	items map[string]*preview
+}
+
+// taskSelection is the files to download of a task, and its directory,
+// chosen by the add of a preview
+type taskSelection struct {
+	Dir   string   `json:",omitempty"`
+	Files []string `json:",omitempty"`
+	// the ExcludeFiles to apply once the files are known
+	Exclude bool `json:",omitempty"`
+}
+
+// put registers the preview of the infohash, expiring the old ones
+func (ps *previewState) put(ih string, p *preview) {
+	ps.Lock()
+	defer ps.Unlock()
+	if ps.items == nil {
+		ps.items = make(map[string]*preview)
+	}
+	now := time.Now()
+	for k, old := range ps.items {
+		if old.meta != nil && now.After(old.expires) {
+			delete(ps.items, k)
+		}
+	}
+	ps.items[ih] = p
+}
+
+func (ps *previewState) get(ih string) *preview {
+	ps.Lock()
+	defer ps.Unlock()
+	p := ps.items[ih]
+	if p != nil && p.meta != nil && time.Now().After(p.expires) {
+		delete(ps.items, ih)
+		return nil
+	}
+	return p
+}
+
+func (ps *previewState) remove(ih string, p *preview) {
+	ps.Lock()
+	if ps.items[ih] == p {
+		delete(ps.items, ih)
+	}
+	ps.Unlock()
+}
+
+// stop cancels the resolving of the infohash, if any, and waits for it to
+// be dropped from the client
+func (ps *previewState) stop(ih string) {
+	p := ps.get(ih)
+	if p == nil || p.meta != nil {
+		return
+	}
+	ps.Lock()
+	select {
+	case <-p.cancel:
+	default:
+		close(p.cancel)
+	}
+	ps.Unlock()
+	<-p.done
+}
+
+// PreviewMagnet resolves the info of the magnet without adding it, for its
+// add to be confirmed with AddPreview. The resolving ends with the ctx or
+// by CancelPreview.
+func (e *Engine) PreviewMagnet(ctx context.Context, magnetURI string) (*Inspection, error) {
+	magnetURI, err := normalizeMagnet(magnetURI)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := torrent.TorrentSpecFromMagnetUri(magnetURI)
+	if err != nil {
+		return nil, err
+	}
+	ih := spec.InfoHash.HexString()
+	if p := e.previews.get(ih); p != nil && p.meta != nil {
+		return e.inspectPreview(p.meta)
+	}
+
+	e.RLock()
+	client := e.client
+	_, exists := e.ts[ih]
+	alwaysTrackers := e.config.AlwaysAddTrackers
+	e.RUnlock()
+	if exists {
+		return nil, ErrTaskExists
+	}
+	if client == nil {
+		return nil, errors.New("engine not configured")
+	}
+	if len(spec.Trackers) == 0 || alwaysTrackers {
+		if trackers := e.GetTrackers(); len(trackers) > 0 {
+			spec.Trackers = append(spec.Trackers, trackers)
+		}
+	}
+	// nothing is written while previewing
+	spec.Storage = noDataStorage{}
+	spec.DisallowDataDownload = true
+	spec.DisallowDataUpload = true
+
+	e.previews.stop(ih)
+	p := &preview{cancel: make(chan struct{}), done: make(chan struct{})}
+	e.previews.put(ih, p)
+	tt, isNew, err := client.AddTorrentSpec(spec)
+	if err != nil || !isNew {
+		close(p.done)
+		e.previews.remove(ih, p)
+		if err == nil {
+			err = ErrTaskExists
+		}
+		return nil, err
+	}
+	log.Println("[Preview] resolving", ih)
+
+	select {
+	case <-tt.GotInfo():
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-p.cancel:
+		err = ErrPreviewCanceled
+	case <-e.closeSync:
+		err = ErrPreviewCanceled
+	}
+	mi := tt.Metainfo()
+	tt.Drop()
+	close(p.done)
+	if err != nil {
+		e.previews.remove(ih, p)
+		return nil, err
+	}
+
+	e.previews.put(ih, &preview{meta: &mi, expires: time.Now().Add(previewTTL)})
+	return e.inspectPreview(&mi)
+}
+
+// PreviewTorrent keeps the torrent for its add to be confirmed with
+// AddPreview
+func (e *Engine) PreviewTorrent(data []byte) (*Inspection, error) {
+	mi, err := metainfo.Load(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkV2Only(mi.InfoBytes); err != nil {
+		return nil, err
+	}
+	ins, err := e.inspectPreview(mi)
+	if err != nil {
+		return nil, err
+	}
+	e.previews.put(ins.InfoHash, &preview{meta: mi, expires: time.Now().Add(previewTTL)})
+	return ins, nil
+}
+
+func inspectMeta(mi *metainfo.MetaInfo) (*Inspection, error) {
+	data, err := bencode.Marshal(mi)
+	if err != nil {
+		return nil, err
+	}
+	return InspectTorrent(data)
+}
+
+// inspectPreview is the inspection of the preview, the files the
+// ExcludeFiles skip flagged to be unselected
+func (e *Engine) inspectPreview(mi *metainfo.MetaInfo) (*Inspection, error) {
+	ins, err := inspectMeta(mi)
+	if err != nil {
+		return nil, err
+	}
+	e.markExcluded(ins)
+	g := e.fileGuard()
+	for i := range ins.Files {
+		ins.Files[i].Blocked = g.blocked(ins.Files[i].Path)
+	}
+	return ins, nil
+}
+
+// CancelPreview stops the resolving of the preview, or forgets the one
+// resolved
+func (e *Engine) CancelPreview(infohash string) error {
+	p := e.previews.get(infohash)
+	if p == nil {
+		return ErrMissingPreview
+	}
+	if p.meta != nil {
+		e.previews.remove(infohash, p)
+		return nil
+	}
+	e.previews.stop(infohash)
+	return nil
+}
+
+// AddPreview adds the task previewed, downloading only the files, all if
+// empty, to the dir under the DownloadDirectory if not empty
+func (e *Engine) AddPreview(infohash string, files []string, dir string, opt AddOptions) error {
+	p := e.previews.get(infohash)
+	if p == nil || p.meta == nil {
+		return ErrMissingPreview
+	}
+	if dir != "" {
+		dir = filepath.Clean(dir)
+		if filepath.IsAbs(dir) || dir == ".." || strings.HasPrefix(dir, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("%w: the directory must be under the DownloadDirectory (%s)", ErrInvalidSelection, dir)
+		}
+	}
+	if len(files) > 0 {
+		ins, err := inspectMeta(p.meta)
+		if err != nil {
+			return err
+		}
+		listed := make(map[string]bool, len(ins.Files))
+		for _, f := range ins.Files {
+			listed[f.Path] = true
+		}
+		for _, f := range files {
+			if !listed[f] {
+				return fmt.Errorf("%w: %s not in the torrent", ErrInvalidSelection, f)
+			}
+		}
+		if len(files) == len(ins.Files) {
+			// all of them
+			files = nil
+		}
+	}
+
+	data, err := bencode.Marshal(p.meta)
+	if err != nil {
+		return err
+	}
+	sel := taskSelection{Dir: dir, Files: files}
+	if sel.Dir != "" || len(sel.Files) > 0 {
+		if err := e.saveSelection(infohash, sel); err != nil {
+			return err
+		}
+	}
+	// the files are as selected
+	opt.KeepAllFiles = true
+	err = e.NewTorrentByReader(bytes.NewReader(data), opt)
+	if err != nil && !errors.Is(err, ErrMaxConnTasks) {
+		if !errors.Is(err, ErrTaskExists) {
+			e.removeSelection(infohash)
+		}
+		return err
+	}
+	e.previews.remove(infohash, p)
+	return err
+}
+
+// selectionFileName is saved in the cache dir next to the task's torrent file
+func (e *Engine) selectionFileName(infohash string) string {
+	return filepath.Join(e.cacheDir, fmt.Sprintf("%s%s%s", cacheSavedPrefix, infohash, taskSelectionExt))
+}
+
+func (e *Engine) saveSelection(infohash string, sel taskSelection) error {
+	data, err := json.Marshal(sel)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(e.selectionFileName(infohash), data, 0644)
+}
+
+// loadSelection restores the files to download and the directory of a new
+// task
+func (e *Engine) loadSelection(t *Torrent) {
+	data, err := ioutil.ReadFile(e.selectionFileName(t.InfoHash))
+	if err != nil {
+		return
+	}
+	var sel taskSelection
+	if err := json.Unmarshal(data, &sel); err != nil {
+		log.Println("[Preview]", t.InfoHash, err)
+		return
+	}
+	t.selectDir = sel.Dir
+	t.applyExclude = sel.Exclude
+	if len(sel.Files) > 0 {
+		t.selected = make(map[string]bool, len(sel.Files))
+		for _, f := range sel.Files {
+			t.selected[f] = true
+		}
+	}
+}
+
+func (e *Engine) removeSelection(infohash string) {
+	if err := os.Remove(e.selectionFileName(infohash)); err != nil && !os.IsNotExist(err) {
+		log.Println("[Preview]", infohash, err)
+	}
+}
+
+// isSelected tells if the file of the path in the torrent is to download
+func (t *Torrent) isSelected(path string) bool {
+	return !t.blocked[path] && (t.selected == nil || t.selected[path])
+}
+
+// downloadSelected downloads the files selected, all if no selection
+func (t *Torrent) downloadSelected() {
+	if t.selected == nil && t.blocked == nil {
+		t.t.DownloadAll()
+		return
+	}
+	for _, f := range t.t.Files() {
+		if t.isSelected(f.Path()) {
+			f.SetPriority(torrent.PiecePriorityNormal)
+		} else {
+			f.SetPriority(torrent.PiecePriorityNone)
+		}
+	}
+}
+
+// bytesMissing is what's left to download of the files selected, all of
+// them if none is
+func (t *Torrent) bytesMissing() int64 {
+	if t.selected == nil && t.blocked == nil {
+		return t.t.BytesMissing()
+	}
+	var missing int64
+	selected := false
+	for _, f := range t.t.Files() {
+		if t.isSelected(f.Path()) {
+			selected = true
+			missing += f.Length() - f.BytesCompleted()
+		}
+	}
+	if !selected {
+		return t.t.BytesMissing()
+	}
+	return missing
+}
+
+// noDataStorage is the storage of the previews, which get the info only
+type noDataStorage struct{}
+
+func (noDataStorage) OpenTorrent(*metainfo.Info, metainfo.Hash) (storage.TorrentImpl, error) {
+	return storage.TorrentImpl{
+		Piece: func(metainfo.Piece) storage.PieceImpl { return noDataPiece{} },
+		Close: func() error { return nil },
+	}, nil
+}
+
+type noDataPiece struct{}
+
+var errNoData = errors.New("no data while previewing")
+
+func (noDataPiece) ReadAt([]byte, int64) (int, error)  { return 0, errNoData }
+func (noDataPiece) WriteAt([]byte, int64) (int, error) { return 0, errNoData }
+func (noDataPiece) MarkComplete() error                { return nil }
+func (noDataPiece) MarkNotComplete() error             { return nil }
+func (noDataPiece) Completion() storage.Completion {
+	return storage.Completion{Complete: false, Ok: true}
+}
@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestEngine_AddPreview(t *testing.T) {
+	infoBytes, err := bencode.Marshal(metainfo.Info{
+		Name:        "show",
+		PieceLength: 16 << 10,
+		Pieces:      make([]byte, 20),
+		Files: []metainfo.FileInfo{
+			{Path: []string{"e01.mkv"}, Length: 10},
+			{Path: []string{"e01.nfo"}, Length: 1},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := bencode.Marshal(metainfo.MetaInfo{InfoBytes: infoBytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &Engine{cacheDir: t.TempDir(), ts: map[string]*Torrent{}}
+	ins, err := e.PreviewTorrent(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ins.Files) != 2 || ins.Files[0].Path != "show/e01.mkv" {
+		t.Fatalf("PreviewTorrent() files = %+v", ins.Files)
+	}
+
+	tests := []struct {
+		files []string
+		dir   string
+		want  error
+	}{
+		{[]string{"show/e02.mkv"}, "", ErrInvalidSelection},
+		{[]string{"show/e01.mkv"}, "../out", ErrInvalidSelection},
+		{[]string{"show/e01.mkv"}, "/out", ErrInvalidSelection},
+	}
+	for _, tt := range tests {
+		if err := e.AddPreview(ins.InfoHash, tt.files, tt.dir, AddOptions{}); !errors.Is(err, tt.want) {
+			t.Errorf("AddPreview(%v, %q) = %v, want %v", tt.files, tt.dir, err, tt.want)
+		}
+	}
+	if err := e.AddPreview("missing", nil, "", AddOptions{}); !errors.Is(err, ErrMissingPreview) {
+		t.Errorf("AddPreview() of a missing preview = %v", err)
+	}
+	if err := e.CancelPreview(ins.InfoHash); err != nil {
+		t.Errorf("CancelPreview() = %v", err)
+	}
+	if err := e.CancelPreview(ins.InfoHash); !errors.Is(err, ErrMissingPreview) {
+		t.Errorf("CancelPreview() twice = %v", err)
+	}
+}
+
+func TestEngine_loadSelection(t *testing.T) {
+	e := &Engine{cacheDir: t.TempDir()}
+	if err := e.saveSelection("ih", taskSelection{Dir: "shows", Files: []string{"show/e01.mkv"}}); err != nil {
+		t.Fatal(err)
+	}
+	task := &Torrent{InfoHash: "ih"}
+	e.loadSelection(task)
+	if task.selectDir != "shows" || !task.isSelected("show/e01.mkv") || task.isSelected("show/e01.nfo") {
+		t.Errorf("loadSelection() = %q %v", task.selectDir, task.selected)
+	}
+
+	e.removeSelection("ih")
+	task = &Torrent{InfoHash: "ih"}
+	e.loadSelection(task)
+	if task.selected != nil || !task.isSelected("show/e01.nfo") {
+		t.Errorf("loadSelection() after remove = %v", task.selected)
+	}
+}
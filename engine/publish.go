@@ -0,0 +1,240 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+const (
+	// in the cache dir
+	publishedFile = "published.json"
+	// the pieces of a published torrent, within the sizes
+	publishPieces       = 2000
+	minPublishPieceSize = 256 << 10
+	maxPublishPieceSize = 16 << 20
+)
+
+var (
+	ErrNotPublished     = errors.New("Torrent not published")
+	ErrAlreadyPublished = errors.New("The path is already a task")
+	ErrInvalidPublish   = errors.New("Invalid path to publish")
+)
+
+// Published is a torrent created from a local file or dir, seeded and
+// announced by the feed of the published torrents
+type Published struct {
+	InfoHash string
+	Name     string
+	// relative to the DownloadDirectory
+	Path        string
+	Size        int64
+	Magnet      string
+	PublishedAt time.Time
+}
+
+type publishState struct {
+	sync.Mutex
+	// loaded from the cache dir on first use
+	items  []Published
+	loaded bool
+}
+
+func (e *Engine) publishedFileName() string {
+	return filepath.Join(e.cacheDir, publishedFile)
+}
+
+// loadPublished reads the published torrents once, to be called with the
+// publish locked
+func (e *Engine) loadPublished() {
+	if e.publish.loaded {
+		return
+	}
+	e.publish.loaded = true
+	e.publish.items = nil
+	data, err := ioutil.ReadFile(e.publishedFileName())
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(data, &e.publish.items); err != nil {
+		log.Println("[Publish]", err)
+	}
+}
+
+// resetPublished forgets the torrents loaded, as the cache dir may change
+// by a reconfigure
+func (e *Engine) resetPublished() {
+	e.publish.Lock()
+	e.publish.loaded = false
+	e.publish.Unlock()
+}
+
+// savePublished writes the torrents, to be called with the publish locked
+func (e *Engine) savePublished() error {
+	data, err := json.Marshal(e.publish.items)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(e.publishedFileName(), data, 0644)
+}
+
+// publishPieceLength is the power of 2 making about publishPieces pieces
+func publishPieceLength(size int64) int64 {
+	l := int64(minPublishPieceSize)
+	for l < maxPublishPieceSize && size/l > publishPieces {
+		l <<= 1
+	}
+	return l
+}
+
+func pathSize(root string) (size int64, files int, err error) {
+	err = filepath.Walk(root, func(_ string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			size += fi.Size()
+			files++
+		}
+		return nil
+	})
+	return
+}
+
+// Publish creates a torrent of the file or dir of the path relative to the
+// DownloadDirectory, seeds it where it is, and adds it to the published
+// torrents. The files are hashed before returning.
+func (e *Engine) Publish(p string) (*Published, error) {
+	p = filepath.ToSlash(filepath.Clean(strings.TrimPrefix(p, "/")))
+	if !localRenamePath(p) {
+		return nil, fmt.Errorf("%w: the path must be under the download directory (%s)", ErrInvalidPublish, p)
+	}
+	e.RLock()
+	root := filepath.Join(e.config.DownloadDirectory, filepath.FromSlash(p))
+	e.RUnlock()
+
+	size, files, err := pathSize(root)
+	if err != nil {
+		return nil, err
+	}
+	if files == 0 {
+		return nil, fmt.Errorf("%w: no file in %s", ErrInvalidPublish, p)
+	}
+	start := time.Now()
+	info := metainfo.Info{PieceLength: publishPieceLength(size)}
+	if err := info.BuildFromFilePath(root); err != nil {
+		return nil, err
+	}
+	mi := &metainfo.MetaInfo{
+		CreatedBy:    "simple-torrent",
+		CreationDate: time.Now().Unix(),
+	}
+	if trackers := e.GetTrackers(); len(trackers) > 0 {
+		mi.AnnounceList = [][]string{trackers}
+	}
+	if mi.InfoBytes, err = bencode.Marshal(info); err != nil {
+		return nil, err
+	}
+	ih := mi.HashInfoBytes()
+	log.Printf("[Publish] %s hashed as %s in %s", p, ih.HexString(), time.Since(start))
+
+	e.RLock()
+	_, exists := e.ts[ih.HexString()]
+	e.RUnlock()
+	if exists {
+		return nil, ErrAlreadyPublished
+	}
+	// seeded from where the data is
+	if dir := filepath.Dir(filepath.FromSlash(p)); dir != "." {
+		if err := e.saveSelection(ih.HexString(), taskSelection{Dir: dir}); err != nil {
+			return nil, err
+		}
+	}
+	e.newTorrentCacheFile(mi)
+	opt := AddOptions{State: AddStarted, Source: AddSourceWeb, Force: true, KeepAllFiles: true}
+	if err := e.newTorrentBySpec(torrent.TorrentSpecFromMetaInfo(mi), taskTorrent, opt); err != nil && !errors.Is(err, ErrMaxConnTasks) {
+		e.removeTorrentCache(ih.HexString(), false)
+		e.removeSelection(ih.HexString())
+		return nil, err
+	}
+
+	pub := Published{
+		InfoHash:    ih.HexString(),
+		Name:        info.Name,
+		Path:        p,
+		Size:        size,
+		Magnet:      mi.Magnet(&ih, &info).String(),
+		PublishedAt: time.Now(),
+	}
+	e.publish.Lock()
+	defer e.publish.Unlock()
+	e.loadPublished()
+	e.publish.items = append(e.publish.items, pub)
+	if err := e.savePublished(); err != nil {
+		return nil, err
+	}
+	return &pub, nil
+}
+
+// PublishedTorrents lists the published torrents, the latest first
+func (e *Engine) PublishedTorrents() []Published {
+	e.publish.Lock()
+	defer e.publish.Unlock()
+	e.loadPublished()
+	items := append([]Published{}, e.publish.items...)
+	sort.Slice(items, func(i, j int) bool { return items[i].PublishedAt.After(items[j].PublishedAt) })
+	return items
+}
+
+// PublishedTorrent is the .torrent file of a published torrent
+func (e *Engine) PublishedTorrent(infohash string) ([]byte, error) {
+	if !e.isPublished(infohash) {
+		return nil, ErrNotPublished
+	}
+	return ioutil.ReadFile(filepath.Join(e.cacheDir, cacheSavedPrefix+infohash+".torrent"))
+}
+
+func (e *Engine) isPublished(infohash string) bool {
+	e.publish.Lock()
+	defer e.publish.Unlock()
+	e.loadPublished()
+	for _, p := range e.publish.items {
+		if p.InfoHash == infohash {
+			return true
+		}
+	}
+	return false
+}
+
+// Unpublish removes the torrent from the feed, the task seeding it is kept
+func (e *Engine) Unpublish(infohash string) error {
+	e.publish.Lock()
+	defer e.publish.Unlock()
+	e.loadPublished()
+	for i, p := range e.publish.items {
+		if p.InfoHash == infohash {
+			e.publish.items = append(e.publish.items[:i], e.publish.items[i+1:]...)
+			log.Println("[Publish] unpublished", infohash)
+			return e.savePublished()
+		}
+	}
+	return ErrNotPublished
+}
+
+// removePublished drops the torrent of a task removed from the feed
+func (e *Engine) removePublished(infohash string) {
+	if err := e.Unpublish(infohash); err != nil && !errors.Is(err, ErrNotPublished) {
+		log.Println("[Publish]", err)
+	}
+}
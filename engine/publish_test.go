@@ -0,0 +1,47 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPublishPieceLength(t *testing.T) {
+	tests := []struct {
+		size int64
+		want int64
+	}{
+		{0, 256 << 10},
+		{100 << 20, 256 << 10},
+		{1 << 30, 1 << 20},
+		{1 << 40, 16 << 20},
+	}
+	for _, tt := range tests {
+		if got := publishPieceLength(tt.size); got != tt.want {
+			t.Errorf("publishPieceLength(%d) = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestEngine_published(t *testing.T) {
+	e := &Engine{cacheDir: t.TempDir(), config: Config{DownloadDirectory: t.TempDir()}}
+	for _, p := range []string{"../x", "", "empty"} {
+		if _, err := e.Publish(p); err == nil {
+			t.Errorf("Publish(%q) no error", p)
+		}
+	}
+
+	e.publish.items = []Published{{InfoHash: "a", PublishedAt: time.Unix(1, 0)}, {InfoHash: "b", PublishedAt: time.Unix(2, 0)}}
+	e.publish.loaded = true
+	if err := e.Unpublish("a"); err != nil {
+		t.Fatal(err)
+	}
+	// reloaded from the cache dir
+	e.resetPublished()
+	if ps := e.PublishedTorrents(); len(ps) != 1 || ps[0].InfoHash != "b" {
+		t.Errorf("PublishedTorrents() = %+v", ps)
+	}
+	if _, err := e.PublishedTorrent("a"); !errors.Is(err, ErrNotPublished) {
+		t.Errorf("PublishedTorrent() err = %v", err)
+	}
+}
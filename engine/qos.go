@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const qosTick = 15 * time.Second
+
+// QoSStatus is the share of the upload taken by the seeding tasks, while
+// the downloading ones compete for the UploadRate
+type QoSStatus struct {
+	Active    bool
+	SeedShare float64
+	// the connections a seeding task is capped to, 0 uncapped
+	Slots int
+}
+
+type qosState struct {
+	sync.Mutex
+	status QoSStatus
+}
+
+// QoS reports the upload QoS
+func (e *Engine) QoS() QoSStatus {
+	e.qos.Lock()
+	defer e.qos.Unlock()
+	return e.qos.status
+}
+
+// qosSlots caps the seeding tasks' connections while their share of the
+// saturated upload is above the share allowed, and relaxes the cap when
+// it's under or the limiter has room again
+func qosSlots(slots int, seedShare, allowed float64, saturated bool) int {
+	switch {
+	case saturated && seedShare > allowed:
+		if slots == 0 {
+			slots = defaultUploadSlots
+		}
+		slots /= 2
+		if slots < minUploadSlots {
+			slots = minUploadSlots
+		}
+	case slots > 0 && (!saturated || seedShare < allowed/2):
+		slots *= 2
+		if slots >= defaultUploadSlots {
+			// uncapped
+			slots = 0
+		}
+	}
+	return slots
+}
+
+// qosRoutine gives the downloading tasks the upload priority over the
+// seeding ones with SeedingUploadShare, the upload of the downloading
+// tasks is what their peers reciprocate
+func (e *Engine) qosRoutine() {
+	tk := time.NewTicker(qosTick)
+	defer tk.Stop()
+	for range tk.C {
+		e.RLock()
+		share := float64(e.config.SeedingUploadShare)
+		limit := e.uploadLimit()
+		var seedRate, downRate float64
+		downloading := false
+		for _, t := range e.ts {
+			t.Lock()
+			if t.Started && t.t != nil {
+				if t.Done {
+					seedRate += float64(t.UploadRate)
+				} else {
+					downloading = true
+					downRate += float64(t.UploadRate)
+				}
+			}
+			t.Unlock()
+		}
+		e.RUnlock()
+
+		e.qos.Lock()
+		prev := e.qos.status.Slots
+		if share <= 0 || limit == rate.Inf {
+			e.qos.status = QoSStatus{}
+		} else {
+			total := seedRate + downRate
+			s := QoSStatus{Active: downloading}
+			if total > 0 {
+				s.SeedShare = seedRate / total
+			}
+			saturated := downloading && total >= 0.9*float64(limit)
+			s.Slots = qosSlots(prev, s.SeedShare, share, saturated)
+			e.qos.status = s
+		}
+		// the tasks finished since are capped too
+		apply := e.qos.status.Slots != prev || prev > 0
+		e.qos.Unlock()
+		if apply {
+			e.applySeedingSlots()
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package engine
+
+import "testing"
+
+func Test_qosSlots(t *testing.T) {
+	tests := []struct {
+		name      string
+		slots     int
+		seedShare float64
+		saturated bool
+		want      int
+	}{
+		{"uncapped", 0, 0.2, true, 0},
+		{"cap", 0, 0.8, true, defaultUploadSlots / 2},
+		{"cap more", 10, 0.8, true, 5},
+		{"min", minUploadSlots, 0.8, true, minUploadSlots},
+		{"within share", 10, 0.2, true, 10},
+		{"relax under half", 10, 0.1, true, 20},
+		{"relax unsaturated", 10, 0.8, false, 20},
+		{"uncap", 40, 0.8, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := qosSlots(tt.slots, tt.seedShare, 0.3, tt.saturated); got != tt.want {
+				t.Errorf("qosSlots() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxDigestLines = 50
+
+var errQuietHours = errors.New("expecting <hh:mm>-<hh:mm>, eg. 23:00-07:00")
+
+// quietHours is a daily period of the local time, in minutes of the day,
+// which may wrap over midnight
+type quietHours struct {
+	start, end int
+	// batched in a digest, or else dropped
+	digest bool
+}
+
+// quietDigest keeps the notifications held in the quiet hours, by channel
+type quietDigest struct {
+	sync.Mutex
+	pending map[*notifyRoute][]*notification
+	timer   *time.Timer
+}
+
+// parseQuietHours parses the NotifyQuietHours, nil if not set
+func parseQuietHours(s string) (*quietHours, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	se := strings.SplitN(s, "-", 2)
+	if len(se) != 2 {
+		return nil, errQuietHours
+	}
+	var q quietHours
+	for i, p := range []*int{&q.start, &q.end} {
+		t, err := time.Parse("15:04", strings.TrimSpace(se[i]))
+		if err != nil {
+			return nil, errQuietHours
+		}
+		*p = t.Hour()*60 + t.Minute()
+	}
+	if q.start == q.end {
+		return nil, fmt.Errorf("%w: empty period", errQuietHours)
+	}
+	return &q, nil
+}
+
+// quietHours is the NotifyQuietHours with NotifyQuietDigest
+func (c *Config) quietHours() (*quietHours, error) {
+	q, err := parseQuietHours(c.NotifyQuietHours)
+	if q != nil {
+		q.digest = c.NotifyQuietDigest
+	}
+	return q, err
+}
+
+func minuteOfDay(t time.Time) int {
+	return t.Hour()*60 + t.Minute()
+}
+
+// contains tells if t is in the quiet hours
+func (q *quietHours) contains(t time.Time) bool {
+	m := minuteOfDay(t)
+	if q.start < q.end {
+		return m >= q.start && m < q.end
+	}
+	return m >= q.start || m < q.end
+}
+
+// endAfter is when the quiet hours containing t end
+func (q *quietHours) endAfter(t time.Time) time.Time {
+	end := time.Date(t.Year(), t.Month(), t.Day(), q.end/60, q.end%60, 0, 0, t.Location())
+	if !end.After(t) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return end
+}
+
+// holdNotification tells if n is held by the quiet hours, queued for the
+// digest of r if NotifyQuietDigest, or else dropped. Errors are never held.
+func (e *Engine) holdNotification(r *notifyRoute, n *notification) bool {
+	e.rulesMu.RLock()
+	q := e.quietHours
+	e.rulesMu.RUnlock()
+	now := time.Now()
+	if q == nil || n.Critical || !q.contains(now) {
+		return false
+	}
+	if !q.digest {
+		return true
+	}
+
+	e.digest.Lock()
+	defer e.digest.Unlock()
+	if e.digest.pending == nil {
+		e.digest.pending = make(map[*notifyRoute][]*notification)
+	}
+	e.digest.pending[r] = append(e.digest.pending[r], n)
+	if e.digest.timer == nil {
+		e.digest.timer = time.AfterFunc(q.endAfter(now).Sub(now), e.sendDigests)
+	}
+	return true
+}
+
+// sendDigests sends the notifications held in the quiet hours, one message
+// each channel
+func (e *Engine) sendDigests() {
+	e.digest.Lock()
+	pending := e.digest.pending
+	e.digest.pending = nil
+	e.digest.timer = nil
+	e.digest.Unlock()
+
+	for r, ns := range pending {
+		go func(r *notifyRoute, n *notification) {
+			if err := r.send(r.target, n); err != nil {
+				log.Printf("[Notify] %s digest: %s", r.target.Scheme, err)
+			}
+		}(r, newDigest(e.cld.GetStrAttribute("Title"), ns))
+	}
+}
+
+func newDigest(title string, ns []*notification) *notification {
+	if title == "" {
+		title = "SimpleTorrent"
+	}
+	var lines []string
+	for i, n := range ns {
+		if i == maxDigestLines {
+			lines = append(lines, fmt.Sprintf("... and %d more", len(ns)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", n.Event, strings.SplitN(n.Message, "\n", 2)[0]))
+	}
+	return &notification{
+		Event:   "digest",
+		Title:   fmt.Sprintf("%s: %d notifications in the quiet hours", title, len(ns)),
+		Message: strings.Join(lines, "\n"),
+	}
+}
@@ -0,0 +1,229 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/c2h5oh/datasize"
+)
+
+const (
+	quotaTick = 30 * time.Second
+	// the task metadata key of the user a task belongs to
+	userMetaKey = "user"
+)
+
+var ErrQuotaExceeded = errors.New("Disk quota exceeded")
+
+// Quota is the usage of a quota of the DiskQuotas
+type Quota struct {
+	// label:<label> or user:<user>
+	Key   string
+	Limit int64
+	// the bytes downloaded by the tasks
+	Used int64
+	// the sizes of the tasks, when downloaded
+	Committed int64
+	Tasks     int
+	Exceeded  bool
+}
+
+// quotaState keeps the tasks stopped by the quotas, started again once the
+// usage is back under the limits
+type quotaState struct {
+	sync.Mutex
+	stopped map[string]bool
+}
+
+// parseQuotas parses the DiskQuotas, comma separated label:<label>=<size>
+// or user:<user>=<size>, eg. "label:movies=500GB, user:alice=200GB"
+func parseQuotas(s string) (map[string]int64, error) {
+	quotas := make(map[string]int64)
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		kv := strings.SplitN(p, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || (!strings.HasPrefix(key, "label:") && !strings.HasPrefix(key, "user:")) ||
+			strings.TrimSpace(key[strings.Index(key, ":")+1:]) == "" {
+			return nil, fmt.Errorf("invalid quota %q, expecting label:<label>=<size> or user:<user>=<size>", p)
+		}
+		var v datasize.ByteSize
+		if err := v.UnmarshalText([]byte(strings.TrimSpace(kv[1]))); err != nil || v == 0 {
+			return nil, fmt.Errorf("invalid size of the quota %q", p)
+		}
+		quotas[key] = int64(v)
+	}
+	return quotas, nil
+}
+
+// quotaKeys are the quotas a task counts in
+func quotaKeys(labels []string, user string) []string {
+	keys := make([]string, 0, len(labels)+1)
+	for _, l := range labels {
+		keys = append(keys, "label:"+l)
+	}
+	if user != "" {
+		keys = append(keys, "user:"+user)
+	}
+	return keys
+}
+
+// Quotas is the usage of the DiskQuotas, by key
+func (e *Engine) Quotas() []Quota {
+	e.RLock()
+	limits, _ := parseQuotas(e.config.DiskQuotas)
+	usage := make(map[string]*Quota, len(limits))
+	for k, limit := range limits {
+		usage[k] = &Quota{Key: k, Limit: limit}
+	}
+	for _, t := range e.ts {
+		t.Lock()
+		for _, k := range quotaKeys(t.Labels, t.Meta[userMetaKey]) {
+			if q, ok := usage[k]; ok {
+				q.Used += t.Downloaded
+				q.Committed += t.Size
+				q.Tasks++
+			}
+		}
+		t.Unlock()
+	}
+	e.RUnlock()
+
+	res := make([]Quota, 0, len(usage))
+	for _, q := range usage {
+		q.Exceeded = q.Used >= q.Limit
+		res = append(res, *q)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Key < res[j].Key })
+	return res
+}
+
+// checkQuota refuses an add making a quota of the task's labels or user
+// overcommitted, the size of a magnet is unknown until its info is loaded
+func (e *Engine) checkQuota(spec *torrent.TorrentSpec, labels []string, opt AddOptions) error {
+	if opt.Source == "" {
+		// restored
+		return nil
+	}
+	var size int64
+	if len(spec.InfoBytes) > 0 {
+		var info metainfo.Info
+		if err := bencode.Unmarshal(spec.InfoBytes, &info); err == nil {
+			size = info.TotalLength()
+		}
+	}
+	keys := quotaKeys(labels, opt.User)
+	for _, q := range e.Quotas() {
+		for _, k := range keys {
+			if q.Key == k && (q.Used >= q.Limit || q.Committed+size > q.Limit) {
+				return fmt.Errorf("%w: %s", ErrQuotaExceeded, k)
+			}
+		}
+	}
+	return nil
+}
+
+// userAdded saves the user asked by the add in the task metadata, before
+// the task is upserted which loads it
+func (e *Engine) userAdded(infohash string, opt AddOptions) {
+	if opt.User == "" {
+		return
+	}
+	var m TaskMeta
+	if data, err := ioutil.ReadFile(e.metaFileName(infohash)); err == nil {
+		json.Unmarshal(data, &m)
+	}
+	if m.Meta == nil {
+		m.Meta = make(map[string]string)
+	}
+	m.Meta[userMetaKey] = opt.User
+	data, err := json.Marshal(m)
+	if err == nil {
+		err = ioutil.WriteFile(e.metaFileName(infohash), data, 0644)
+	}
+	if err != nil {
+		log.Println("[Quota]", infohash, err)
+	}
+}
+
+// quotaRoutine stops the downloads of the quotas exceeded, and starts them
+// again once back under the limits
+func (e *Engine) quotaRoutine() {
+	tk := time.NewTicker(quotaTick)
+	defer tk.Stop()
+	for range tk.C {
+		if e.enforceQuotas() {
+			e.TsChanged <- struct{}{}
+		}
+	}
+}
+
+func (e *Engine) enforceQuotas() (changed bool) {
+	exceeded := make(map[string]bool)
+	for _, q := range e.Quotas() {
+		exceeded[q.Key] = q.Exceeded
+	}
+
+	var stop, start []*Torrent
+	e.quotas.Lock()
+	e.RLock()
+	for ih, t := range e.ts {
+		t.Lock()
+		over := ""
+		for _, k := range quotaKeys(t.Labels, t.Meta[userMetaKey]) {
+			if exceeded[k] {
+				over = k
+				break
+			}
+		}
+		switch {
+		case over != "" && t.Started && !t.Done:
+			if e.quotas.stopped == nil {
+				e.quotas.stopped = make(map[string]bool)
+			}
+			e.quotas.stopped[ih] = true
+			stop = append(stop, t)
+			log.Printf("[Quota]%s stopped, %s exceeded", ih, over)
+		case over == "" && e.quotas.stopped[ih]:
+			delete(e.quotas.stopped, ih)
+			if !t.Started {
+				start = append(start, t)
+			}
+		}
+		t.Unlock()
+	}
+	for ih := range e.quotas.stopped {
+		if _, ok := e.ts[ih]; !ok {
+			delete(e.quotas.stopped, ih)
+		}
+	}
+	e.RUnlock()
+	e.quotas.Unlock()
+
+	for _, t := range stop {
+		if err := e.StopTorrent(t.InfoHash); err != nil {
+			log.Println("[Quota]", t.InfoHash, err)
+		}
+		e.notify(EventError, t, ErrQuotaExceeded.Error())
+	}
+	for _, t := range start {
+		if err := e.StartTorrent(t.InfoHash); err != nil {
+			log.Println("[Quota]", t.InfoHash, err)
+		} else {
+			log.Println("[Quota]", t.InfoHash, "started again, under the quota")
+		}
+	}
+	return len(stop) > 0 || len(start) > 0
+}
@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+)
+
+func TestParseQuotas(t *testing.T) {
+	tests := []struct {
+		in   string
+		want map[string]int64
+		err  bool
+	}{
+		{"", map[string]int64{}, false},
+		{"label:movies=1KB, user:alice=2MB", map[string]int64{"label:movies": 1 << 10, "user:alice": 2 << 20}, false},
+		{"movies=1KB", nil, true},
+		{"label:=1KB", nil, true},
+		{"user:alice=lots", nil, true},
+		{"user:alice=0", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseQuotas(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("parseQuotas(%q) error = %v", tt.in, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("parseQuotas(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+		for k, v := range tt.want {
+			if got[k] != v {
+				t.Errorf("parseQuotas(%q)[%s] = %d, want %d", tt.in, k, got[k], v)
+			}
+		}
+	}
+}
+
+func TestEngine_Quotas(t *testing.T) {
+	e := &Engine{
+		config: Config{DiskQuotas: "label:movies=100B, user:alice=1KB"},
+		ts: map[string]*Torrent{
+			"a": {Labels: []string{"movies"}, Size: 80, Downloaded: 80},
+			"b": {Labels: []string{"movies"}, Meta: map[string]string{userMetaKey: "alice"}, Size: 40, Downloaded: 30},
+			"c": {Labels: []string{"music"}, Size: 500, Downloaded: 500},
+		},
+	}
+	qs := e.Quotas()
+	if len(qs) != 2 {
+		t.Fatalf("Quotas() = %+v", qs)
+	}
+	if q := qs[0]; q.Key != "label:movies" || q.Used != 110 || q.Committed != 120 || q.Tasks != 2 || !q.Exceeded {
+		t.Errorf("Quotas() movies = %+v", q)
+	}
+	if q := qs[1]; q.Key != "user:alice" || q.Used != 30 || q.Tasks != 1 || q.Exceeded {
+		t.Errorf("Quotas() alice = %+v", q)
+	}
+
+	spec := &torrent.TorrentSpec{}
+	if err := e.checkQuota(spec, []string{"movies"}, AddOptions{Source: AddSourceWeb}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("checkQuota() movies = %v, want ErrQuotaExceeded", err)
+	}
+	if err := e.checkQuota(spec, nil, AddOptions{Source: AddSourceWeb, User: "alice"}); err != nil {
+		t.Errorf("checkQuota() alice = %v", err)
+	}
+	if err := e.checkQuota(spec, []string{"movies"}, AddOptions{}); err != nil {
+		t.Errorf("checkQuota() restored = %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const maxRateOverrideTTL = 7 * 24 * time.Hour
+
+var ErrInvalidRateOverride = errors.New("Invalid rate limit override")
+
+// RateOverride is a temporary UploadRate and DownloadRate, reverted to the
+// config at Until, never saved to the config file
+type RateOverride struct {
+	Active bool
+	// the rates, in the format of the config, empty keeps the one of the config
+	Upload   string `json:",omitempty"`
+	Download string `json:",omitempty"`
+	Until    time.Time
+}
+
+// rateState keeps the limiters of the client, adjusted in place by the
+// overrides
+type rateState struct {
+	sync.Mutex
+	up, down *rate.Limiter
+	override RateOverride
+	timer    *time.Timer
+	// the cap of both directions by the TransferQuota, over the config and
+	// the override
+	throttle string
+}
+
+// rateLimiters are the limiters of the client configured with c, the
+// override still active applied
+func (e *Engine) rateLimiters(c *Config) (up, down *rate.Limiter) {
+	up, down = c.UploadLimiter(), c.DownloadLimiter()
+	e.rates.Lock()
+	defer e.rates.Unlock()
+	e.rates.up, e.rates.down = up, down
+	if o := e.rates.override; o.Active {
+		setRate(up, o.Upload)
+		setRate(down, o.Download)
+	}
+	capRate(up, e.rates.throttle)
+	capRate(down, e.rates.throttle)
+	return
+}
+
+// setRate sets the limit of l to rstr, if not empty
+func setRate(l *rate.Limiter, rstr string) {
+	if l == nil || rstr == "" {
+		return
+	}
+	if nl, err := rateLimiter(rstr); err == nil {
+		l.SetLimit(nl.Limit())
+		l.SetBurst(nl.Burst())
+	}
+}
+
+// capRate lowers the limit of l to rstr, if not empty and lower
+func capRate(l *rate.Limiter, rstr string) {
+	if l == nil || rstr == "" {
+		return
+	}
+	if nl, err := rateLimiter(rstr); err == nil && nl.Limit() < l.Limit() {
+		l.SetLimit(nl.Limit())
+		l.SetBurst(nl.Burst())
+	}
+}
+
+// setRateThrottle caps the rates to r, empty lifts the cap
+func (e *Engine) setRateThrottle(r string) {
+	e.RLock()
+	up, down := e.config.UploadRate, e.config.DownloadRate
+	e.RUnlock()
+
+	e.rates.Lock()
+	defer e.rates.Unlock()
+	e.rates.throttle = r
+	o := e.rates.override
+	if !o.Active {
+		o = RateOverride{}
+	}
+	setRate(e.rates.up, overrideOr(o.Upload, up))
+	setRate(e.rates.down, overrideOr(o.Download, down))
+	capRate(e.rates.up, r)
+	capRate(e.rates.down, r)
+}
+
+// RateOverrideStatus reports the temporary rate limits
+func (e *Engine) RateOverrideStatus() RateOverride {
+	e.rates.Lock()
+	defer e.rates.Unlock()
+	return e.rates.override
+}
+
+// SetRateOverride limits the upload and the download to up and down, as
+// UploadRate and DownloadRate take them, for ttl then reverts to the config
+func (e *Engine) SetRateOverride(up, down string, ttl time.Duration) error {
+	if up == "" && down == "" {
+		return fmt.Errorf("%w: no rate", ErrInvalidRateOverride)
+	}
+	if ttl <= 0 || ttl > maxRateOverrideTTL {
+		return fmt.Errorf("%w: the duration must be within %s", ErrInvalidRateOverride, maxRateOverrideTTL)
+	}
+	for _, r := range []string{up, down} {
+		if _, err := rateLimiter(r); err != nil {
+			return fmt.Errorf("%w: %s %s", ErrInvalidRateOverride, r, err)
+		}
+	}
+
+	e.RLock()
+	cup, cdown := e.config.UploadRate, e.config.DownloadRate
+	e.RUnlock()
+
+	e.rates.Lock()
+	defer e.rates.Unlock()
+	if e.rates.timer != nil {
+		e.rates.timer.Stop()
+	}
+	o := RateOverride{Active: true, Upload: up, Download: down, Until: time.Now().Add(ttl)}
+	e.rates.override = o
+	e.rates.timer = time.AfterFunc(ttl, func() { e.expireRateOverride(o.Until) })
+	// the direction not overridden is back to the config, from a previous override
+	setRate(e.rates.up, overrideOr(up, cup))
+	setRate(e.rates.down, overrideOr(down, cdown))
+	capRate(e.rates.up, e.rates.throttle)
+	capRate(e.rates.down, e.rates.throttle)
+	log.Printf("[RateLimit] override up %q down %q until %s", up, down, o.Until.Format(time.RFC3339))
+	return nil
+}
+
+// ClearRateOverride reverts the rates to the config
+func (e *Engine) ClearRateOverride() {
+	e.RLock()
+	up, down := e.config.UploadRate, e.config.DownloadRate
+	e.RUnlock()
+
+	e.rates.Lock()
+	defer e.rates.Unlock()
+	if !e.rates.override.Active {
+		return
+	}
+	if e.rates.timer != nil {
+		e.rates.timer.Stop()
+		e.rates.timer = nil
+	}
+	e.rates.override = RateOverride{}
+	setRate(e.rates.up, overrideOr("", up))
+	setRate(e.rates.down, overrideOr("", down))
+	capRate(e.rates.up, e.rates.throttle)
+	capRate(e.rates.down, e.rates.throttle)
+	log.Println("[RateLimit] override cleared, back to the config")
+}
+
+// expireRateOverride clears the override ending at until, not a later one
+// set since
+func (e *Engine) expireRateOverride(until time.Time) {
+	e.rates.Lock()
+	current := e.rates.override.Active && e.rates.override.Until.Equal(until)
+	e.rates.Unlock()
+	if current {
+		e.ClearRateOverride()
+	}
+}
+
+// overrideOr is the rate of the override if set, else the one of the
+// config, unlimited if empty
+func overrideOr(override, config string) string {
+	switch {
+	case override != "":
+		return override
+	case config != "":
+		return config
+	}
+	return "unlimited"
+}
+
+// uploadLimit is the limit of the upload in effect, the override or the
+// UploadRate, to be called with the engine locked
+func (e *Engine) uploadLimit() rate.Limit {
+	e.rates.Lock()
+	o, throttle := e.rates.override, e.rates.throttle
+	e.rates.Unlock()
+	if !o.Active {
+		o.Upload = ""
+	}
+	return cappedLimit(overrideOr(o.Upload, e.config.UploadRate), throttle)
+}
+
+// downloadLimit is the limit of the download in effect, to be called with
+// the engine locked
+func (e *Engine) downloadLimit() rate.Limit {
+	e.rates.Lock()
+	o, throttle := e.rates.override, e.rates.throttle
+	e.rates.Unlock()
+	if !o.Active {
+		o.Download = ""
+	}
+	return cappedLimit(overrideOr(o.Download, e.config.DownloadRate), throttle)
+}
+
+// cappedLimit is the limit of the rate, lowered to the throttle if set
+func cappedLimit(r, throttle string) rate.Limit {
+	l := rate.Inf
+	if rl, err := rateLimiter(r); err == nil {
+		l = rl.Limit()
+	}
+	if tl, err := rateLimiter(throttle); throttle != "" && err == nil && tl.Limit() < l {
+		l = tl.Limit()
+	}
+	return l
+}
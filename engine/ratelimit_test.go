@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestRateOverride(t *testing.T) {
+	e := &Engine{config: Config{UploadRate: "high"}}
+	up, down := e.rateLimiters(&e.config)
+
+	if err := e.SetRateOverride("", "", time.Hour); err == nil {
+		t.Error("SetRateOverride() without rates, want error")
+	}
+	if err := e.SetRateOverride("1MB", "", 0); err == nil {
+		t.Error("SetRateOverride() without ttl, want error")
+	}
+	if err := e.SetRateOverride("1MB", "low", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if up.Limit() != 1<<20 || down.Limit() != 50000 || e.uploadLimit() != 1<<20 {
+		t.Errorf("override limits = %v %v, want 1MB low", up.Limit(), down.Limit())
+	}
+
+	// a new override keeps the config for the direction not given
+	if err := e.SetRateOverride("", "medium", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if up.Limit() != 1500000 || down.Limit() != 500000 {
+		t.Errorf("override limits = %v %v, want high medium", up.Limit(), down.Limit())
+	}
+
+	// a reconfigured client keeps the override
+	up, down = e.rateLimiters(&e.config)
+	if down.Limit() != 500000 {
+		t.Errorf("reconfigured download limit = %v, want medium", down.Limit())
+	}
+
+	e.ClearRateOverride()
+	if up.Limit() != 1500000 || down.Limit() != rate.Inf || e.RateOverrideStatus().Active {
+		t.Errorf("cleared limits = %v %v, want high unlimited", up.Limit(), down.Limit())
+	}
+
+	if err := e.SetRateOverride("low", "", 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if up.Limit() != 1500000 || e.RateOverrideStatus().Active {
+		t.Errorf("expired upload limit = %v, want high", up.Limit())
+	}
+}
+
+func TestRateThrottle(t *testing.T) {
+	e := &Engine{config: Config{UploadRate: "high", DownloadRate: "low"}}
+	up, down := e.rateLimiters(&e.config)
+
+	e.setRateThrottle("medium")
+	// the download limit lower than the throttle is kept
+	if up.Limit() != 500000 || down.Limit() != 50000 || e.uploadLimit() != 500000 {
+		t.Errorf("throttled limits = %v %v, want medium low", up.Limit(), down.Limit())
+	}
+	// an override is capped too
+	if err := e.SetRateOverride("10MB", "10MB", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if up.Limit() != 500000 || down.Limit() != 500000 || e.downloadLimit() != 500000 {
+		t.Errorf("throttled override limits = %v %v, want medium", up.Limit(), down.Limit())
+	}
+	e.setRateThrottle("")
+	if up.Limit() != 10<<20 || down.Limit() != 10<<20 {
+		t.Errorf("unthrottled limits = %v %v, want the override", up.Limit(), down.Limit())
+	}
+	e.ClearRateOverride()
+}
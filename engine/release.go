@@ -0,0 +1,154 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Release is what a release name tells: Show.Name.S01E02.1080p.WEB-DL-GROUP
+// or [Group] Show Name - 02 (1080p), the season and episode 0 if none
+type Release struct {
+	Title   string `json:"title,omitempty"`
+	Season  int    `json:"season,omitempty"`
+	Episode int    `json:"episode,omitempty"`
+	Quality string `json:"quality,omitempty"`
+	Group   string `json:"group,omitempty"`
+}
+
+var ErrInvalidFilter = errors.New("Invalid release filter")
+
+var (
+	releaseEpisodeRes = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)\bS(\d{1,2})[ ._-]?E(\d{1,3})`),
+		regexp.MustCompile(`(?i)\b(\d{1,2})x(\d{2,3})\b`),
+	}
+	releaseSeasonRe    = regexp.MustCompile(`(?i)\b(?:S|Season[ ._-]?)(\d{1,2})\b`)
+	releaseAnimeEpRe   = regexp.MustCompile(`\s-\s(\d{1,4})(?:v\d)?\b`)
+	releaseQualityRe   = regexp.MustCompile(`(?i)\b(?:(2160|1080|720|576|480)[pi]|4k|uhd)\b`)
+	releaseYearRe      = regexp.MustCompile(`\b(?:19|20)\d\d\b`)
+	releaseHeadGroupRe = regexp.MustCompile(`^\s*\[([^\]]+)\]\s*`)
+	releaseTailGroupRe = regexp.MustCompile(`-([A-Za-z0-9]+)(?:\[[^\]]*\])?$`)
+	releaseExtRe       = regexp.MustCompile(`(?i)\.(?:mkv|mp4|avi|ts|torrent)$`)
+	releaseTitleTrim   = " -_.([{"
+)
+
+// ParseRelease reads the title, season, episode, quality and group of a
+// release name, the unknown ones left empty
+func ParseRelease(name string) Release {
+	var r Release
+	// the underscores as spaces, they are word characters to \b
+	rest := strings.ReplaceAll(releaseExtRe.ReplaceAllString(strings.TrimSpace(name), ""), "_", " ")
+	if m := releaseHeadGroupRe.FindStringSubmatch(rest); m != nil {
+		r.Group = m[1]
+		rest = rest[len(m[0]):]
+	} else if m := releaseTailGroupRe.FindStringSubmatch(rest); m != nil {
+		// the source of WEB-DL isn't a group
+		switch strings.ToLower(m[1]) {
+		case "dl", "rip":
+		default:
+			r.Group = m[1]
+		}
+	}
+
+	// the title ends at the first of the episode, the quality or the year
+	end := len(rest)
+	cut := func(loc []int) {
+		if loc != nil && loc[0] > 0 && loc[0] < end {
+			end = loc[0]
+		}
+	}
+	found := false
+	for _, re := range releaseEpisodeRes {
+		if m := re.FindStringSubmatchIndex(rest); m != nil {
+			r.Season, _ = strconv.Atoi(rest[m[2]:m[3]])
+			r.Episode, _ = strconv.Atoi(rest[m[4]:m[5]])
+			cut(m)
+			found = true
+			break
+		}
+	}
+	if !found {
+		if m := releaseSeasonRe.FindStringSubmatchIndex(rest); m != nil {
+			r.Season, _ = strconv.Atoi(rest[m[2]:m[3]])
+			cut(m)
+		} else if m := releaseAnimeEpRe.FindStringSubmatchIndex(rest); m != nil {
+			r.Episode, _ = strconv.Atoi(rest[m[2]:m[3]])
+			cut(m)
+		}
+	}
+	if m := releaseQualityRe.FindStringSubmatchIndex(rest); m != nil {
+		r.Quality = normalizeQuality(rest[m[0]:m[1]])
+		cut(m)
+	}
+	cut(releaseYearRe.FindStringIndex(rest))
+
+	title := strings.ReplaceAll(rest[:end], ".", " ")
+	r.Title = strings.Join(strings.Fields(strings.Trim(title, releaseTitleTrim)), " ")
+	return r
+}
+
+// normalizeQuality is the quality as <lines>p, 4k as 2160p
+func normalizeQuality(q string) string {
+	q = strings.ToLower(q)
+	switch q {
+	case "4k", "uhd":
+		return "2160p"
+	}
+	return strings.TrimRight(q, "pi") + "p"
+}
+
+// qualityRank is the lines of a quality to compare them, 0 if unknown
+func qualityRank(q string) float64 {
+	if q == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(normalizeQuality(q), "p"))
+	if err != nil {
+		return 0
+	}
+	return float64(n)
+}
+
+// ReleaseFilter is the conditions of the policies on the release names,
+// matching the RSS items and the search results, eg.
+//
+//	quality>=1080p AND season=2 AND group!=YIFY
+//
+// fields: name, title, season, episode, quality, group
+type ReleaseFilter []policyCond
+
+// ParseReleaseFilter reads the conditions joined by AND, nil for none
+func ParseReleaseFilter(s string) (ReleaseFilter, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	var f ReleaseFilter
+	for _, cs := range policyAndRe.Split(s, -1) {
+		c, err := parsePolicyCond(cs)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidFilter, err)
+		}
+		switch c.field {
+		case "name", "title", "season", "episode", "quality", "group":
+		default:
+			return nil, fmt.Errorf("%w: %q isn't a release field", ErrInvalidFilter, c.field)
+		}
+		f = append(f, c)
+	}
+	return f, nil
+}
+
+// Match tells whether the release of the name matches all the conditions
+func (f ReleaseFilter) Match(name string) bool {
+	pt := &policyTarget{hookTarget: hookTarget{Name: name}, Release: ParseRelease(name)}
+	for i := range f {
+		if !f[i].match(pt) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseRelease(t *testing.T) {
+	tests := []struct {
+		name string
+		want Release
+	}{
+		{"Show.Name.S01E02.1080p.WEB-DL.x264-NTb", Release{"Show Name", 1, 2, "1080p", "NTb"}},
+		{"Show Name s2e10 720p HDTV", Release{"Show Name", 2, 10, "720p", ""}},
+		{"Show_Name_3x07_HDTV-LOL", Release{"Show Name", 3, 7, "", "LOL"}},
+		{"Show.Name.S03.2160p.BluRay-GRP", Release{"Show Name", 3, 0, "2160p", "GRP"}},
+		{"[SubsPlease] Show Name - 02 (1080p) [ABCD1234].mkv", Release{"Show Name", 0, 2, "1080p", "SubsPlease"}},
+		{"Movie.Title.2019.4K.WEB-DL", Release{"Movie Title", 0, 0, "2160p", ""}},
+		{"Some Album", Release{"Some Album", 0, 0, "", ""}},
+		{"", Release{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseRelease(tt.name); got != tt.want {
+				t.Errorf("ParseRelease() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReleaseFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		name   string
+		want   bool
+	}{
+		{"", "anything", true},
+		{"quality>=1080p", "Show.S01E02.1080p.WEB-GRP", true},
+		{"quality>=1080p", "Show.S01E02.720p.WEB-GRP", false},
+		{"quality>=1080p", "Show.S01E02.WEB-GRP", false},
+		{"quality=4k", "Movie.2019.2160p.BluRay", true},
+		{"season=1 AND episode>1", "Show.S01E02.720p", true},
+		{"season=1 AND episode>2", "Show.S01E02.720p", false},
+		{"group=grp AND title~ ^Show$", "Show.S01E02.720p-GRP", true},
+		{"group!=GRP", "Show.S01E02.720p-GRP", false},
+		{"title=show name", "Show.Name.S01E02", true},
+		{"name~ (?i)web", "Show.S01E02.WEB", true},
+	}
+	for _, tt := range tests {
+		f, err := ParseReleaseFilter(tt.filter)
+		if err != nil {
+			t.Fatalf("ParseReleaseFilter(%q) err = %v", tt.filter, err)
+		}
+		if got := f.Match(tt.name); got != tt.want {
+			t.Errorf("%q.Match(%q) = %v, want %v", tt.filter, tt.name, got, tt.want)
+		}
+	}
+
+	for _, s := range []string{"quality>=high", "ratio>2", "season=one", "quality"} {
+		if _, err := ParseReleaseFilter(s); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("ParseReleaseFilter(%q) err = %v", s, err)
+		}
+	}
+}
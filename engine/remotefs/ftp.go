@@ -0,0 +1,241 @@
+package remotefs
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ftpConn is an FTP client of the servers listing by MLSD, in the passive
+// mode
+type ftpConn struct {
+	raw  net.Conn
+	text *textproto.Conn
+	host string
+	// for the data connections of ftps
+	tls *tls.Config
+}
+
+func dialFTP(u *url.URL) (*ftpConn, error) {
+	raw, err := net.DialTimeout("tcp", u.Host, Timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &ftpConn{raw: raw, text: textproto.NewConn(raw), host: u.Hostname()}
+	raw.SetDeadline(time.Now().Add(Timeout))
+	if _, _, err := c.text.ReadResponse(220); err != nil {
+		raw.Close()
+		return nil, err
+	}
+	if err := c.login(u); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *ftpConn) login(u *url.URL) error {
+	if u.Scheme == "ftps" {
+		if _, err := c.cmd(234, "AUTH TLS"); err != nil {
+			return err
+		}
+		c.tls = &tls.Config{
+			ServerName:         u.Hostname(),
+			InsecureSkipVerify: u.Query().Get("insecure") != "",
+			// the data connections resume the session of the control one
+			ClientSessionCache: tls.NewLRUClientSessionCache(4),
+		}
+		tc := tls.Client(c.raw, c.tls)
+		if err := tc.Handshake(); err != nil {
+			return err
+		}
+		c.raw = tc
+		c.text = textproto.NewConn(tc)
+		if _, err := c.cmd(200, "PBSZ 0"); err != nil {
+			return err
+		}
+		if _, err := c.cmd(200, "PROT P"); err != nil {
+			return err
+		}
+	}
+	code, err := c.cmd(0, "USER %s", u.User.Username())
+	if err != nil {
+		return err
+	}
+	if code == 331 {
+		pass, _ := u.User.Password()
+		if _, err := c.cmd(230, "PASS %s", pass); err != nil {
+			return err
+		}
+	} else if code != 230 {
+		return fmt.Errorf("USER: unexpected reply %d", code)
+	}
+	_, err = c.cmd(200, "TYPE I")
+	return err
+}
+
+// cmd sends the command and reads the reply of the expected code, any code
+// if 0
+func (c *ftpConn) cmd(expect int, format string, args ...interface{}) (int, error) {
+	c.raw.SetDeadline(time.Now().Add(Timeout))
+	if err := c.text.PrintfLine(format, args...); err != nil {
+		return 0, err
+	}
+	code, _, err := c.text.ReadResponse(expect)
+	if err != nil {
+		return code, fmt.Errorf("%s: %w", strings.SplitN(format, " ", 2)[0], err)
+	}
+	return code, nil
+}
+
+// dataConn opens a passive data connection, to the host of the control one
+// whatever the server tells
+func (c *ftpConn) dataConn() (net.Conn, error) {
+	c.raw.SetDeadline(time.Now().Add(Timeout))
+	if err := c.text.PrintfLine("EPSV"); err != nil {
+		return nil, err
+	}
+	var port int
+	code, msg, err := c.text.ReadResponse(229)
+	if err == nil {
+		port, err = parseEPSV(msg)
+	} else if code >= 500 {
+		if err := c.text.PrintfLine("PASV"); err != nil {
+			return nil, err
+		}
+		if _, msg, err = c.text.ReadResponse(227); err == nil {
+			port, err = parsePASV(msg)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	dc, err := net.DialTimeout("tcp", net.JoinHostPort(c.host, strconv.Itoa(port)), Timeout)
+	if err != nil {
+		return nil, err
+	}
+	if c.tls != nil {
+		return tls.Client(dc, c.tls), nil
+	}
+	return dc, nil
+}
+
+// parseEPSV is the port of `Entering Extended Passive Mode (|||port|)`
+func parseEPSV(msg string) (int, error) {
+	start, end := strings.Index(msg, "(|||"), strings.LastIndex(msg, "|)")
+	if start < 0 || end < start+4 {
+		return 0, fmt.Errorf("EPSV: unexpected reply %q", msg)
+	}
+	return strconv.Atoi(msg[start+4 : end])
+}
+
+// parsePASV is the port of `Entering Passive Mode (h1,h2,h3,h4,p1,p2)`
+func parsePASV(msg string) (int, error) {
+	start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+	if start < 0 || end < start {
+		return 0, fmt.Errorf("PASV: unexpected reply %q", msg)
+	}
+	parts := strings.Split(msg[start+1:end], ",")
+	if len(parts) != 6 {
+		return 0, fmt.Errorf("PASV: unexpected reply %q", msg)
+	}
+	p1, err1 := strconv.Atoi(strings.TrimSpace(parts[4]))
+	p2, err2 := strconv.Atoi(strings.TrimSpace(parts[5]))
+	if err1 != nil || err2 != nil {
+		return 0, fmt.Errorf("PASV: unexpected reply %q", msg)
+	}
+	return p1<<8 | p2, nil
+}
+
+// transfer runs the command reading its data into w
+func (c *ftpConn) transfer(w io.Writer, format string, args ...interface{}) error {
+	dc, err := c.dataConn()
+	if err != nil {
+		return err
+	}
+	defer dc.Close()
+	if _, err := c.cmd(1, format, args...); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, idleConn{dc})
+	dc.Close()
+	if err != nil {
+		return err
+	}
+	// the transfer may take long, the reply comes after
+	c.raw.SetDeadline(time.Now().Add(Timeout))
+	if _, _, err := c.text.ReadResponse(2); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *ftpConn) List(dir string) ([]Entry, error) {
+	var buf bytes.Buffer
+	if err := c.transfer(&buf, "MLSD %s", dir); err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if ent, ok := parseMLSD(strings.TrimRight(line, "\r")); ok {
+			entries = append(entries, ent)
+		}
+	}
+	return entries, nil
+}
+
+// parseMLSD reads a line `type=file;size=1;modify=20060102150405; name`,
+// the current and parent dirs skipped
+func parseMLSD(line string) (Entry, bool) {
+	i := strings.Index(line, " ")
+	if i < 0 {
+		return Entry{}, false
+	}
+	ent := Entry{Name: line[i+1:]}
+	var typ string
+	for _, fact := range strings.Split(line[:i], ";") {
+		kv := strings.SplitN(fact, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch strings.ToLower(kv[0]) {
+		case "type":
+			typ = strings.ToLower(kv[1])
+		case "size":
+			ent.Size, _ = strconv.ParseInt(kv[1], 10, 64)
+		case "modify":
+			if len(kv[1]) >= 14 {
+				ent.ModTime, _ = time.Parse("20060102150405", kv[1][:14])
+			}
+		}
+	}
+	switch typ {
+	case "file":
+	case "dir":
+		ent.IsDir = true
+	default:
+		return Entry{}, false
+	}
+	return ent, true
+}
+
+func (c *ftpConn) Retrieve(p string, w io.Writer) error {
+	return c.transfer(w, "RETR %s", p)
+}
+
+func (c *ftpConn) Remove(p string) error {
+	_, err := c.cmd(250, "DELE %s", p)
+	return err
+}
+
+func (c *ftpConn) Close() {
+	c.text.PrintfLine("QUIT")
+	c.raw.Close()
+}
@@ -0,0 +1,27 @@
+package remotefs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseMLSD(t *testing.T) {
+	ent, ok := parseMLSD("type=file;size=42;modify=20210102030405.123;UNIX.mode=0644; a b.mkv")
+	want := Entry{Name: "a b.mkv", Size: 42, ModTime: time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)}
+	if !ok || ent != want {
+		t.Errorf("parseMLSD() = %+v, %v", ent, ok)
+	}
+	if ent, ok := parseMLSD("Type=dir;modify=20210102030405; season 1"); !ok || !ent.IsDir {
+		t.Errorf("parseMLSD() dir = %+v, %v", ent, ok)
+	}
+	if _, ok := parseMLSD("type=cdir; ."); ok {
+		t.Error("parseMLSD() current dir listed")
+	}
+
+	if port, err := parseEPSV("Entering Extended Passive Mode (|||50123|)"); err != nil || port != 50123 {
+		t.Errorf("parseEPSV() = %d, %v", port, err)
+	}
+	if port, err := parsePASV("Entering Passive Mode (10,0,0,1,195,80)."); err != nil || port != 50000 {
+		t.Errorf("parsePASV() = %d, %v", port, err)
+	}
+}
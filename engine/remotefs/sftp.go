@@ -0,0 +1,441 @@
+package remotefs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// the SFTP v3 packets used
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpRead     = 5
+	sftpOpendir  = 11
+	sftpReaddir  = 12
+	sftpRemove   = 13
+	sftpStatus   = 101
+	sftpHandle   = 102
+	sftpData     = 103
+	sftpName     = 104
+	sftpStatusOK = 0
+	sftpEOF      = 1
+
+	sftpAttrSize        = 0x1
+	sftpAttrUIDGID      = 0x2
+	sftpAttrPermissions = 0x4
+	sftpAttrACModTime   = 0x8
+	sftpAttrExtended    = 0x80000000
+
+	sftpMaxPacket = 256 << 10
+	sftpReadSize  = 32 << 10
+	// the reads of a file in flight
+	sftpReadAhead = 16
+)
+
+var errSFTPPacket = errors.New("malformed SFTP packet")
+
+// sftpConn is an SFTP v3 client over the ssh subsystem
+type sftpConn struct {
+	client  *ssh.Client
+	session *ssh.Session
+	w       io.WriteCloser
+	r       *bufio.Reader
+	id      uint32
+}
+
+func sftpHostKeyCallback(u *url.URL) (ssh.HostKeyCallback, error) {
+	q := u.Query()
+	if fp := q.Get("hostkey"); fp != "" {
+		return func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			if got := ssh.FingerprintSHA256(key); got != fp {
+				return fmt.Errorf("host key mismatch, got %s", got)
+			}
+			return nil
+		}, nil
+	}
+	if q.Get("insecure") != "" {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	return nil, errors.New("no ?hostkey= to verify the server")
+}
+
+func dialSFTP(u *url.URL) (*sftpConn, error) {
+	hostKey, err := sftpHostKeyCallback(u)
+	if err != nil {
+		return nil, err
+	}
+	var auths []ssh.AuthMethod
+	if fn := u.Query().Get("key"); fn != "" {
+		pem, err := ioutil.ReadFile(fn)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", fn, err)
+		}
+		auths = append(auths, ssh.PublicKeys(signer))
+	}
+	if pass, ok := u.User.Password(); ok {
+		auths = append(auths, ssh.Password(pass))
+	}
+	conn, err := net.DialTimeout("tcp", u.Host, Timeout)
+	if err != nil {
+		return nil, err
+	}
+	sc, chans, reqs, err := ssh.NewClientConn(idleConn{conn}, u.Host, &ssh.ClientConfig{
+		User:            u.User.Username(),
+		Auth:            auths,
+		HostKeyCallback: hostKey,
+		Timeout:         Timeout,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c := &sftpConn{client: ssh.NewClient(sc, chans, reqs)}
+	if err := c.start(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *sftpConn) start() (err error) {
+	if c.session, err = c.client.NewSession(); err != nil {
+		return err
+	}
+	if c.w, err = c.session.StdinPipe(); err != nil {
+		return err
+	}
+	out, err := c.session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	c.r = bufio.NewReader(out)
+	if err := c.session.RequestSubsystem("sftp"); err != nil {
+		return err
+	}
+	// INIT carries the version where the id goes
+	if err := c.send(sftpInit, 3, nil); err != nil {
+		return err
+	}
+	typ, _, err := c.recv()
+	if err == nil && typ != sftpVersion {
+		err = fmt.Errorf("unexpected SFTP packet %d", typ)
+	}
+	return err
+}
+
+func sftpU32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func sftpU64(b []byte, v uint64) []byte {
+	return sftpU32(sftpU32(b, uint32(v>>32)), uint32(v))
+}
+
+func sftpString(b []byte, s string) []byte {
+	return append(sftpU32(b, uint32(len(s))), s...)
+}
+
+func (c *sftpConn) send(typ byte, id uint32, payload []byte) error {
+	b := make([]byte, 0, 9+len(payload))
+	b = sftpU32(b, uint32(5+len(payload)))
+	b = append(b, typ)
+	b = sftpU32(b, id)
+	b = append(b, payload...)
+	_, err := c.w.Write(b)
+	return err
+}
+
+// recv reads a packet, the data following the type
+func (c *sftpConn) recv() (byte, []byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n == 0 || n > sftpMaxPacket {
+		return 0, nil, errSFTPPacket
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return 0, nil, err
+	}
+	return data[0], data[1:], nil
+}
+
+// request sends a packet of the next id and reads its reply, the data
+// following the id
+func (c *sftpConn) request(typ byte, payload []byte) (byte, []byte, error) {
+	c.id++
+	id := c.id
+	if err := c.send(typ, id, payload); err != nil {
+		return 0, nil, err
+	}
+	rtyp, data, err := c.recv()
+	if err != nil {
+		return 0, nil, err
+	}
+	rd := sftpReader{b: data}
+	if rd.u32() != id || rd.err != nil {
+		return 0, nil, errSFTPPacket
+	}
+	return rtyp, rd.b, nil
+}
+
+// sftpReader decodes the fields of a packet, the error kept
+type sftpReader struct {
+	b   []byte
+	err error
+}
+
+func (r *sftpReader) u32() uint32 {
+	if len(r.b) < 4 {
+		r.err = errSFTPPacket
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+func (r *sftpReader) u64() uint64 {
+	return uint64(r.u32())<<32 | uint64(r.u32())
+}
+
+func (r *sftpReader) str() string {
+	n := r.u32()
+	if uint32(len(r.b)) < n {
+		r.err = errSFTPPacket
+		return ""
+	}
+	s := string(r.b[:n])
+	r.b = r.b[n:]
+	return s
+}
+
+// attrs reads the ATTRS into the entry
+func (r *sftpReader) attrs(ent *Entry) {
+	flags := r.u32()
+	if flags&sftpAttrSize != 0 {
+		ent.Size = int64(r.u64())
+	}
+	if flags&sftpAttrUIDGID != 0 {
+		r.u32()
+		r.u32()
+	}
+	if flags&sftpAttrPermissions != 0 {
+		ent.IsDir = r.u32()&0170000 == 0040000
+	}
+	if flags&sftpAttrACModTime != 0 {
+		r.u32()
+		ent.ModTime = time.Unix(int64(r.u32()), 0)
+	}
+	if flags&sftpAttrExtended != 0 {
+		for n := r.u32(); n > 0 && r.err == nil; n-- {
+			r.str()
+			r.str()
+		}
+	}
+}
+
+// statusErr is the error of a STATUS reply, nil if OK
+func statusErr(data []byte) error {
+	r := sftpReader{b: data}
+	code := r.u32()
+	msg := r.str()
+	switch {
+	case r.err != nil:
+		return r.err
+	case code == sftpStatusOK:
+		return nil
+	case code == sftpEOF:
+		return io.EOF
+	}
+	return fmt.Errorf("SFTP status %d: %s", code, msg)
+}
+
+// handle opens a file or dir by the packet
+func (c *sftpConn) handle(typ byte, payload []byte) (string, error) {
+	rtyp, data, err := c.request(typ, payload)
+	if err != nil {
+		return "", err
+	}
+	switch rtyp {
+	case sftpHandle:
+		r := sftpReader{b: data}
+		h := r.str()
+		return h, r.err
+	case sftpStatus:
+		if err := statusErr(data); err != nil {
+			return "", err
+		}
+	}
+	return "", errSFTPPacket
+}
+
+func (c *sftpConn) closeHandle(h string) {
+	c.request(sftpClose, sftpString(nil, h))
+}
+
+func (c *sftpConn) List(dir string) ([]Entry, error) {
+	h, err := c.handle(sftpOpendir, sftpString(nil, dir))
+	if err != nil {
+		return nil, err
+	}
+	defer c.closeHandle(h)
+	var entries []Entry
+	for {
+		typ, data, err := c.request(sftpReaddir, sftpString(nil, h))
+		if err != nil {
+			return nil, err
+		}
+		if typ == sftpStatus {
+			if err := statusErr(data); err != io.EOF {
+				return nil, err
+			}
+			return entries, nil
+		}
+		if typ != sftpName {
+			return nil, errSFTPPacket
+		}
+		r := sftpReader{b: data}
+		for n := r.u32(); n > 0 && r.err == nil; n-- {
+			ent := Entry{Name: r.str()}
+			// the longname
+			r.str()
+			r.attrs(&ent)
+			if ent.Name != "." && ent.Name != ".." {
+				entries = append(entries, ent)
+			}
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+}
+
+// retrieve reads the file with the reads pipelined, written in order
+func (c *sftpConn) Retrieve(p string, w io.Writer) error {
+	payload := sftpString(nil, p)
+	payload = sftpU32(payload, 1) // READ
+	payload = sftpU32(payload, 0) // no attrs
+	h, err := c.handle(sftpOpen, payload)
+	if err != nil {
+		return err
+	}
+
+	type chunk struct {
+		off uint64
+		n   uint32
+	}
+	inflight := make(map[uint32]chunk)
+	defer func() {
+		// the replies in flight are read before the next request
+		for len(inflight) > 0 {
+			_, data, err := c.recv()
+			r := sftpReader{b: data}
+			if err != nil {
+				break
+			}
+			delete(inflight, r.u32())
+		}
+		c.closeHandle(h)
+	}()
+	pending := make(map[uint64][]byte)
+	var next, written uint64
+	var eof bool
+	read := func(off uint64, n uint32) error {
+		c.id++
+		b := sftpString(nil, h)
+		b = sftpU64(b, off)
+		b = sftpU32(b, n)
+		inflight[c.id] = chunk{off, n}
+		return c.send(sftpRead, c.id, b)
+	}
+	for {
+		for !eof && len(inflight) < sftpReadAhead {
+			if err := read(next, sftpReadSize); err != nil {
+				return err
+			}
+			next += sftpReadSize
+		}
+		if len(inflight) == 0 {
+			return nil
+		}
+		typ, data, err := c.recv()
+		if err != nil {
+			return err
+		}
+		r := sftpReader{b: data}
+		id := r.u32()
+		ck, ok := inflight[id]
+		if !ok || r.err != nil {
+			return errSFTPPacket
+		}
+		delete(inflight, id)
+		switch typ {
+		case sftpData:
+			b := []byte(r.str())
+			if r.err != nil {
+				return r.err
+			}
+			if len(b) == 0 {
+				eof = true
+				continue
+			}
+			pending[ck.off] = b
+			// a short read, the rest asked again
+			if uint32(len(b)) < ck.n {
+				if err := read(ck.off+uint64(len(b)), ck.n-uint32(len(b))); err != nil {
+					return err
+				}
+			}
+		case sftpStatus:
+			if err := statusErr(r.b); err != io.EOF {
+				return err
+			}
+			eof = true
+		default:
+			return errSFTPPacket
+		}
+		for b, ok := pending[written]; ok; b, ok = pending[written] {
+			if _, err := w.Write(b); err != nil {
+				return err
+			}
+			delete(pending, written)
+			written += uint64(len(b))
+		}
+	}
+}
+
+func (c *sftpConn) Remove(p string) error {
+	typ, data, err := c.request(sftpRemove, sftpString(nil, p))
+	if err != nil {
+		return err
+	}
+	if typ != sftpStatus {
+		return errSFTPPacket
+	}
+	return statusErr(data)
+}
+
+func (c *sftpConn) Close() {
+	if c.session != nil {
+		c.session.Close()
+	}
+	c.client.Close()
+}
@@ -0,0 +1,175 @@
+package remotefs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+)
+
+// fakeSFTP serves a dir of files over the pipes, the reads of the files
+// shortened to test the retries
+type fakeSFTP struct {
+	r *bufio.Reader
+	// the replies queued like a socket buffers them, the client sends its
+	// reads ahead before reading
+	replies chan []byte
+	files   map[string][]byte
+}
+
+func (s *fakeSFTP) reply(typ byte, id uint32, payload []byte) {
+	b := sftpU32(nil, uint32(5+len(payload)))
+	b = append(b, typ)
+	b = sftpU32(b, id)
+	s.replies <- append(b, payload...)
+}
+
+func (s *fakeSFTP) status(id, code uint32, msg string) {
+	s.reply(sftpStatus, id, sftpString(sftpU32(nil, code), msg))
+}
+
+func (s *fakeSFTP) serve() {
+	listed := false
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(s.r, hdr[:]); err != nil {
+			return
+		}
+		data := make([]byte, binary.BigEndian.Uint32(hdr[:]))
+		if _, err := io.ReadFull(s.r, data); err != nil {
+			return
+		}
+		typ := data[0]
+		p := sftpReader{b: data[1:]}
+		id := p.u32()
+		switch typ {
+		case sftpOpendir, sftpOpen:
+			s.reply(sftpHandle, id, sftpString(nil, p.str()))
+		case sftpReaddir:
+			if listed {
+				s.status(id, sftpEOF, "")
+				continue
+			}
+			listed = true
+			b := sftpU32(nil, 3)
+			b = sftpString(sftpString(b, "."), "")
+			b = sftpU32(b, 0)
+			b = sftpString(sftpString(b, "a.mkv"), "-rw-r--r-- a.mkv")
+			b = sftpU32(b, sftpAttrSize|sftpAttrPermissions|sftpAttrACModTime)
+			b = sftpU32(sftpU32(sftpU32(sftpU64(b, 42), 0100644), 1), 1600000000)
+			b = sftpString(sftpString(b, "season 1"), "")
+			b = sftpU32(b, sftpAttrPermissions|sftpAttrExtended)
+			b = sftpU32(sftpU32(b, 040755), 1)
+			b = sftpString(sftpString(b, "k"), "v")
+			s.reply(sftpName, id, b)
+		case sftpRead:
+			content := s.files[p.str()]
+			off, n := p.u64(), p.u32()
+			if off >= uint64(len(content)) {
+				s.status(id, sftpEOF, "")
+				continue
+			}
+			// at most 1000 bytes a read
+			if n > 1000 {
+				n = 1000
+			}
+			end := off + uint64(n)
+			if end > uint64(len(content)) {
+				end = uint64(len(content))
+			}
+			s.reply(sftpData, id, sftpString(nil, string(content[off:end])))
+		case sftpClose:
+			s.status(id, sftpStatusOK, "")
+		case sftpRemove:
+			s.status(id, 2, "No such file")
+		}
+	}
+}
+
+func newFakeSFTP(files map[string][]byte) *sftpConn {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	s := &fakeSFTP{r: bufio.NewReader(sr), replies: make(chan []byte, 1024), files: files}
+	go s.serve()
+	go func() {
+		for b := range s.replies {
+			sw.Write(b)
+		}
+	}()
+	return &sftpConn{w: cw, r: bufio.NewReader(cr)}
+}
+
+func TestSFTPList(t *testing.T) {
+	c := newFakeSFTP(nil)
+	entries, err := c.List("/done")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{
+		{Name: "a.mkv", Size: 42, ModTime: time.Unix(1600000000, 0)},
+		{Name: "season 1", IsDir: true},
+	}
+	if len(entries) != len(want) || entries[0] != want[0] || entries[1] != want[1] {
+		t.Errorf("List() = %+v, want %+v", entries, want)
+	}
+}
+
+func TestSFTPRetrieve(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100000)
+	c := newFakeSFTP(map[string][]byte{"/a.mkv": content})
+	var buf bytes.Buffer
+	if err := c.Retrieve("/a.mkv", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), content) {
+		t.Errorf("Retrieve() = %d bytes, want %d in order", buf.Len(), len(content))
+	}
+
+	// the connection still usable after the reads in flight
+	if err := c.Remove("/a.mkv"); err == nil || err.Error() != "SFTP status 2: No such file" {
+		t.Errorf("Remove() err = %v", err)
+	}
+}
+
+func TestSFTPPackets(t *testing.T) {
+	var r sftpReader
+	r.b = sftpString(sftpU64(sftpU32(nil, 7), 1<<40), "name")
+	if v, big, s := r.u32(), r.u64(), r.str(); v != 7 || big != 1<<40 || s != "name" || r.err != nil || len(r.b) != 0 {
+		t.Errorf("decoded %d %d %q %v", v, big, s, r.err)
+	}
+	r = sftpReader{b: sftpU32(nil, 10)}
+	if r.str(); r.err != errSFTPPacket {
+		t.Errorf("short string err = %v", r.err)
+	}
+
+	if err := statusErr(sftpString(sftpU32(nil, sftpStatusOK), "")); err != nil {
+		t.Errorf("statusErr(OK) = %v", err)
+	}
+	if err := statusErr(sftpString(sftpU32(nil, sftpEOF), "")); err != io.EOF {
+		t.Errorf("statusErr(EOF) = %v", err)
+	}
+	if err := statusErr([]byte{0}); err != errSFTPPacket {
+		t.Errorf("statusErr(short) = %v", err)
+	}
+
+	c := &sftpConn{r: bufio.NewReader(bytes.NewReader(sftpU32(nil, sftpMaxPacket+1)))}
+	if _, _, err := c.recv(); err != errSFTPPacket {
+		t.Errorf("recv() of an oversized packet err = %v", err)
+	}
+	var buf bytes.Buffer
+	c = &sftpConn{w: nopCloser{&buf}}
+	if err := c.send(sftpOpendir, 9, sftpString(nil, "/")); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0, 0, 0, 10, sftpOpendir, 0, 0, 0, 9, 0, 0, 0, 1, '/'}; !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("send() = %v, want %v", buf.Bytes(), want)
+	}
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRemoteImports(t *testing.T) {
+	tests := []struct {
+		in      string
+		wantErr bool
+		want    []string
+	}{
+		{in: "", want: nil},
+		{in: "# sftp://u:p@h/\n\n", want: nil},
+		{in: "sftp://u:p@h/done?insecure=1 to=watch delete\nftps://u:p@h:2121", want: []string{"sftp://u:p@h:22/done?insecure=1", "ftps://u:p@h:2121/"}},
+		{in: "sftp://u:p@h/done", wantErr: true},
+		{in: "sftp://u@h/?hostkey=SHA256:x", wantErr: true},
+		{in: "http://u:p@h/", wantErr: true},
+		{in: "ftp://h/", wantErr: true},
+		{in: "ftp://u:p@h/ to=elsewhere", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseRemoteImports(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseRemoteImports(%q) err = %v", tt.in, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("parseRemoteImports(%q) = %d imports, want %d", tt.in, len(got), len(tt.want))
+			continue
+		}
+		for i, ri := range got {
+			if ri.url.String() != tt.want[i] {
+				t.Errorf("parseRemoteImports(%q)[%d] = %s, want %s", tt.in, i, ri.url, tt.want[i])
+			}
+		}
+	}
+	ris, _ := parseRemoteImports("sftp://u:p@h/done?insecure=1 to=watch delete")
+	if !ris[0].toWatch || !ris[0].delete {
+		t.Errorf("parseRemoteImports() options = %+v", ris[0])
+	}
+
+	if got, want := maskRemoteImports("# x\nftp://u:secret@h/  delete"), "# x\n"+maskURL("ftp://u:secret@h/")+" delete"; got != want {
+		t.Errorf("maskRemoteImports() = %q, want %q", got, want)
+	}
+}
+
+func TestRemotePartial(t *testing.T) {
+	for name, want := range map[string]bool{
+		"a.mkv":          false,
+		".hidden":        true,
+		"a.mkv.part":     true,
+		"a.mkv.!qB":      true,
+		"b.torrent":      false,
+		"c.zip.filepart": true,
+	} {
+		if got := remotePartial(name); got != want {
+			t.Errorf("remotePartial(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestRemotePullPaths(t *testing.T) {
+	owned := map[string]bool{filepath.Join("/dl", "Show.S01"): true, filepath.Join("/dl", "movie.mkv"): true}
+	for p, want := range map[string]bool{
+		"/dl/Show.S01/e01.mkv":   true,
+		"/dl/movie.mkv":          true,
+		"/dl/movie.mkv.nfo":      false,
+		"/dl/Show.S01.extra/a":   false,
+		"/dl/other/Show.S01/e01": false,
+	} {
+		if _, got := ownedPath(owned, filepath.FromSlash(p)); got != want {
+			t.Errorf("ownedPath(%q) = %v, want %v", p, got, want)
+		}
+	}
+
+	dir := t.TempDir()
+	p := filepath.Join(dir, "a.mkv")
+	if got := freePath(p); got != p {
+		t.Errorf("freePath() = %s of a free path", got)
+	}
+	for _, fn := range []string{"a.mkv", "a (1).mkv"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, fn), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := freePath(p), filepath.Join(dir, "a (2).mkv"); got != want {
+		t.Errorf("freePath() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,166 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// in the cache dir, a JSON line a task
+	removedHistoryFile = "removed-history.jsonl"
+	maxRemovedHistory  = 5000
+)
+
+// the reasons of the removals
+const (
+	RemovedByUser    = "user"
+	RemovedSeedRatio = "seed ratio"
+	RemovedSeedTime  = "seed time"
+)
+
+// RemovedTask is a task in the history of the removed ones
+type RemovedTask struct {
+	InfoHash   string
+	Name       string
+	Size       int64
+	Downloaded int64
+	Uploaded   int64
+	Ratio      float32
+	Labels     []string `json:",omitempty"`
+	Done       bool
+	AddedAt    time.Time
+	RemovedAt  time.Time
+	Reason     string
+}
+
+// removedHistory serializes the writes of the history file
+type removedHistory struct {
+	sync.Mutex
+}
+
+func (e *Engine) removedHistoryFileName() string {
+	return filepath.Join(e.cacheDir, removedHistoryFile)
+}
+
+// RemoveTorrent removes the task and its cache, keeping the data, and
+// records it in the history of the removed tasks
+func (e *Engine) RemoveTorrent(infohash, reason string) error {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+	t.Lock()
+	rt := RemovedTask{
+		InfoHash:   t.InfoHash,
+		Name:       t.Name,
+		Size:       t.Size,
+		Downloaded: t.Downloaded,
+		Uploaded:   t.Uploaded,
+		Ratio:      t.SeedRatio,
+		Labels:     append([]string(nil), t.Labels...),
+		Done:       t.Done,
+		AddedAt:    t.AddedAt,
+		Reason:     reason,
+	}
+	t.Unlock()
+
+	if err := e.DeleteTorrent(infohash); err != nil {
+		return err
+	}
+	e.RemoveCache(infohash)
+	rt.RemovedAt = time.Now()
+	if err := e.recordRemoved(rt); err != nil {
+		log.Println("[Removed]", infohash, err)
+	}
+	return nil
+}
+
+// recordRemoved appends the task to the history, the oldest ones beyond
+// maxRemovedHistory are dropped
+func (e *Engine) recordRemoved(rt RemovedTask) error {
+	line, err := json.Marshal(rt)
+	if err != nil {
+		return err
+	}
+	e.removed.Lock()
+	defer e.removed.Unlock()
+	fn := e.removedHistoryFileName()
+	data, err := ioutil.ReadFile(fn)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	lines := append(bytes.Split(bytes.TrimSpace(data), []byte("\n")), line)
+	if len(lines[0]) == 0 {
+		lines = lines[1:]
+	}
+	if n := len(lines) - maxRemovedHistory; n > 0 {
+		lines = lines[n:]
+	}
+	tmp := fn + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(bytes.Join(lines, []byte("\n")), '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fn)
+}
+
+// RemovedHistory lists the removed tasks, the latest first, the ones with
+// the query in the name, infohash or labels if not empty, at most limit if
+// positive
+func (e *Engine) RemovedHistory(query string, limit int) ([]RemovedTask, error) {
+	e.removed.Lock()
+	f, err := os.Open(e.removedHistoryFileName())
+	if os.IsNotExist(err) {
+		e.removed.Unlock()
+		return []RemovedTask{}, nil
+	}
+	if err != nil {
+		e.removed.Unlock()
+		return nil, err
+	}
+	var all []RemovedTask
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		var rt RemovedTask
+		if err := json.Unmarshal(sc.Bytes(), &rt); err == nil {
+			all = append(all, rt)
+		}
+	}
+	err = sc.Err()
+	f.Close()
+	e.removed.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	q := strings.ToLower(query)
+	res := []RemovedTask{}
+	for i := len(all) - 1; i >= 0 && (limit <= 0 || len(res) < limit); i-- {
+		if rt := all[i]; q == "" || rt.matches(q) {
+			res = append(res, rt)
+		}
+	}
+	return res, nil
+}
+
+// matches tells if the name, infohash or a label contains q, lower cased
+func (rt *RemovedTask) matches(q string) bool {
+	if strings.Contains(strings.ToLower(rt.Name), q) || strings.HasPrefix(rt.InfoHash, q) {
+		return true
+	}
+	for _, l := range rt.Labels {
+		if strings.Contains(strings.ToLower(l), q) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package engine
+
+import (
+	"testing"
+)
+
+func TestEngine_RemovedHistory(t *testing.T) {
+	e := &Engine{cacheDir: t.TempDir()}
+	if got, err := e.RemovedHistory("", 0); err != nil || len(got) != 0 {
+		t.Fatalf("RemovedHistory() empty = %v, %v", got, err)
+	}
+	for _, rt := range []RemovedTask{
+		{InfoHash: "aaaa", Name: "Old.Movie", Reason: RemovedByUser},
+		{InfoHash: "bbbb", Name: "Some.Album", Labels: []string{"music"}, Reason: RemovedSeedRatio},
+		{InfoHash: "cccc", Name: "New.Movie", Reason: RemovedSeedTime},
+	} {
+		if err := e.recordRemoved(rt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		query string
+		limit int
+		want  []string
+	}{
+		{"", 0, []string{"cccc", "bbbb", "aaaa"}},
+		{"", 2, []string{"cccc", "bbbb"}},
+		{"movie", 0, []string{"cccc", "aaaa"}},
+		{"MUSIC", 0, []string{"bbbb"}},
+		{"aa", 0, []string{"aaaa"}},
+		{"nothing", 0, nil},
+	}
+	for _, tt := range tests {
+		got, err := e.RemovedHistory(tt.query, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ihs []string
+		for _, rt := range got {
+			ihs = append(ihs, rt.InfoHash)
+		}
+		if len(ihs) != len(tt.want) {
+			t.Errorf("RemovedHistory(%q, %d) = %v, want %v", tt.query, tt.limit, ihs, tt.want)
+			continue
+		}
+		for i := range ihs {
+			if ihs[i] != tt.want[i] {
+				t.Errorf("RemovedHistory(%q, %d) = %v, want %v", tt.query, tt.limit, ihs, tt.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,438 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+const taskRenamesExt = ".renames"
+
+var (
+	ErrInvalidName       = errors.New("Invalid name")
+	ErrRenameUnsupported = errors.New("Task storage doesn't support renaming")
+)
+
+// TaskRenames are the display name and the file paths a task is renamed to,
+// the paths are relative to the task dir, as File.Path
+type TaskRenames struct {
+	Name string `json:",omitempty"`
+	// path in the torrent -> path on disk
+	Files map[string]string `json:",omitempty"`
+}
+
+type renameState struct {
+	sync.Mutex
+	// infohash -> renames, loaded from the cache dir on first use
+	tasks map[string]*TaskRenames
+	// infohash -> storage of the task opened
+	open map[string]*remapTorrent
+}
+
+func (rn *TaskRenames) path(p string) string {
+	if np, ok := rn.Files[p]; ok {
+		return np
+	}
+	return p
+}
+
+func (rn *TaskRenames) clone() *TaskRenames {
+	c := &TaskRenames{Name: rn.Name, Files: make(map[string]string)}
+	for k, v := range rn.Files {
+		c.Files[k] = v
+	}
+	return c
+}
+
+// renamesFileName is saved in the cache dir next to the task's torrent file
+func (e *Engine) renamesFileName(infohash string) string {
+	return filepath.Join(e.cacheDir, fmt.Sprintf("%s%s%s", cacheSavedPrefix, infohash, taskRenamesExt))
+}
+
+// taskRenames is the renames of the task, never nil. It doesn't take the
+// engine lock, as it's called by the storage under the client lock.
+func (e *Engine) taskRenames(infohash string) *TaskRenames {
+	e.renames.Lock()
+	defer e.renames.Unlock()
+	if rn, ok := e.renames.tasks[infohash]; ok {
+		return rn
+	}
+	rn := &TaskRenames{}
+	if data, err := ioutil.ReadFile(e.renamesFileName(infohash)); err == nil {
+		if err := json.Unmarshal(data, rn); err != nil {
+			log.Println("[Rename]", infohash, err)
+		}
+	}
+	for p, np := range rn.Files {
+		// the file is edited by hand, or of an other install
+		if !localRenamePath(np) {
+			log.Printf("[Rename]%s ignored %s -> %s outside the task dir", infohash, p, np)
+			delete(rn.Files, p)
+		}
+	}
+	if e.renames.tasks == nil {
+		e.renames.tasks = make(map[string]*TaskRenames)
+	}
+	e.renames.tasks[infohash] = rn
+	return rn
+}
+
+func (e *Engine) saveRenames(infohash string, rn *TaskRenames) error {
+	fn := e.renamesFileName(infohash)
+	if rn.Name == "" && len(rn.Files) == 0 {
+		if err := os.Remove(fn); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		data, err := json.Marshal(rn)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(fn, data, 0644); err != nil {
+			return err
+		}
+	}
+	e.renames.Lock()
+	defer e.renames.Unlock()
+	if e.renames.tasks == nil {
+		e.renames.tasks = make(map[string]*TaskRenames)
+	}
+	e.renames.tasks[infohash] = rn
+	return nil
+}
+
+func (e *Engine) removeRenames(infohash string) {
+	e.renames.Lock()
+	delete(e.renames.tasks, infohash)
+	e.renames.Unlock()
+	if err := os.Remove(e.renamesFileName(infohash)); err != nil && !os.IsNotExist(err) {
+		log.Println("[Rename]", infohash, err)
+	}
+}
+
+// resetRenames forgets the renames loaded and the storages opened, as the
+// cache dir may change by a reconfigure
+func (e *Engine) resetRenames() {
+	e.renames.Lock()
+	defer e.renames.Unlock()
+	e.renames.tasks = nil
+	e.renames.open = nil
+}
+
+// renamedPath is the path on disk of a file of the task, p is its path in
+// the torrent
+func (e *Engine) renamedPath(infohash, p string) string {
+	return e.taskRenames(infohash).path(p)
+}
+
+// displayName is the name the task is renamed to, or else name
+func (e *Engine) displayName(infohash, name string) string {
+	if rn := e.taskRenames(infohash); rn.Name != "" {
+		return rn.Name
+	}
+	return name
+}
+
+// localRenamePath tells if p is a relative slash path staying in the dir
+func localRenamePath(p string) bool {
+	if p == "" || strings.HasPrefix(p, "/") || strings.Contains(p, `\`) || filepath.IsAbs(p) {
+		return false
+	}
+	for _, seg := range strings.Split(p, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// checkRenameName checks the new name of a file or dir, which is renamed in
+// place, so can't be a path
+func checkRenameName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	return nil
+}
+
+// RenameTorrent changes the name the task is displayed as, the files on
+// disk are kept. An empty name resets to the torrent's name.
+func (e *Engine) RenameTorrent(infohash, name string) error {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+	name = strings.TrimSpace(name)
+	rn := e.taskRenames(infohash).clone()
+	rn.Name = name
+	if err := e.saveRenames(infohash, rn); err != nil {
+		return err
+	}
+
+	t.Lock()
+	if name != "" {
+		t.Name = name
+	} else if t.t != nil && t.t.Info() != nil {
+		t.Name = t.t.Name()
+	}
+	t.Unlock()
+	log.Printf("[Rename]%s renamed as %q", infohash, name)
+	e.TsChanged <- struct{}{}
+	return nil
+}
+
+// RenameFile renames a file or a dir of the task in place, path is its
+// current path relative to the task dir. The data on disk is moved and the
+// storage remapped, so the task keeps seeding.
+func (e *Engine) RenameFile(infohash, path, name string) error {
+	if err := checkRenameName(name); err != nil {
+		return err
+	}
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+	t.Lock()
+	tt := t.t
+	dir := t.dataDir()
+	t.Unlock()
+	if tt == nil || tt.Info() == nil {
+		return ErrNotLoaded
+	}
+	e.renames.Lock()
+	rt := e.renames.open[infohash]
+	e.renames.Unlock()
+	if rt == nil {
+		return ErrRenameUnsupported
+	}
+
+	path = strings.Trim(path, "/")
+	newPath := name
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		newPath = path[:i+1] + name
+	}
+	if newPath == path {
+		return nil
+	}
+
+	old := e.taskRenames(infohash)
+	rn := old.clone()
+	var matched bool
+	for _, f := range tt.Files() {
+		cur := old.path(f.Path())
+		var np string
+		switch {
+		case cur == path:
+			np = newPath
+		case strings.HasPrefix(cur, path+"/"):
+			np = newPath + cur[len(path):]
+		case cur == newPath || strings.HasPrefix(cur, newPath+"/"):
+			return fmt.Errorf("%s already exists", newPath)
+		default:
+			continue
+		}
+		matched = true
+		if np == f.Path() {
+			delete(rn.Files, f.Path())
+		} else {
+			rn.Files[f.Path()] = np
+		}
+	}
+	if !matched {
+		return fmt.Errorf("Missing file %s", path)
+	}
+	dst := filepath.Join(dir, filepath.FromSlash(newPath))
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("%s already exists", newPath)
+	}
+
+	if err := e.saveRenames(infohash, rn); err != nil {
+		return err
+	}
+	if err := rt.rename(rn, filepath.Join(dir, filepath.FromSlash(path)), dst); err != nil {
+		if err := e.saveRenames(infohash, old); err != nil {
+			log.Println("[Rename]", infohash, err)
+		}
+		return err
+	}
+
+	t.Lock()
+	for i, f := range tt.Files() {
+		if i < len(t.Files) && t.Files[i] != nil {
+			t.Files[i].Path = rn.path(f.Path())
+		}
+	}
+	t.Unlock()
+	log.Printf("[Rename]%s %s -> %s", infohash, path, newPath)
+	e.TsChanged <- struct{}{}
+	return nil
+}
+
+// remapStorage stores the data in a dir as the storage wrapped (the mmap or
+// file storage), except the tasks with renamed files, which are stored by
+// the file storage with the paths remapped
+type remapStorage struct {
+	storage.ClientImpl
+	e   *Engine
+	dir string
+	pc  storage.PieceCompletion
+}
+
+// newDataStorage is the storage of the tasks saving to dir, sharing one
+// piece completion db between the mmap and file storages
+func (e *Engine) newDataStorage(dir string) *remapStorage {
+	pc, err := storage.NewDefaultPieceCompletionForDir(dir)
+	if err != nil {
+		log.Printf("[Storage] piece completion of %s kept in memory: %s", dir, err)
+		pc = storage.NewMapPieceCompletion()
+	}
+	s := &remapStorage{e: e, dir: dir, pc: pc}
+	if e.useMmap {
+		s.ClientImpl = storage.NewMMapWithCompletion(dir, pc)
+	} else {
+		s.ClientImpl = s.fileStorage(&TaskRenames{})
+	}
+	return s
+}
+
+func (s *remapStorage) Close() error {
+	return s.pc.Close()
+}
+
+func (s *remapStorage) fileStorage(rn *TaskRenames) storage.ClientImpl {
+	return storage.NewFileOpts(storage.NewFileClientOpts{
+		ClientBaseDir: s.dir,
+		FilePathMaker: func(opts storage.FilePathMakerOpts) string {
+			p := strings.Join(append([]string{opts.Info.Name}, opts.File.Path...), "/")
+			return filepath.FromSlash(rn.path(p))
+		},
+		PieceCompletion: s.pc,
+	})
+}
+
+func (s *remapStorage) OpenTorrent(info *metainfo.Info, ih metainfo.Hash) (storage.TorrentImpl, error) {
+	infohash := ih.HexString()
+	st := s.ClientImpl
+	if rn := s.e.quarantineRenames(infohash, info, s.dir); len(rn.Files) > 0 {
+		st = s.fileStorage(rn)
+	}
+	ti, err := st.OpenTorrent(info, ih)
+	if err != nil {
+		return ti, err
+	}
+
+	rt := &remapTorrent{s: s, info: info, ih: ih, ti: ti}
+	s.e.renames.Lock()
+	if s.e.renames.open == nil {
+		s.e.renames.open = make(map[string]*remapTorrent)
+	}
+	s.e.renames.open[infohash] = rt
+	s.e.renames.Unlock()
+	return storage.TorrentImpl{
+		Piece: func(p metainfo.Piece) storage.PieceImpl {
+			return &remapPiece{t: rt, p: p}
+		},
+		Close: func() error {
+			s.e.renames.Lock()
+			if s.e.renames.open[infohash] == rt {
+				delete(s.e.renames.open, infohash)
+			}
+			s.e.renames.Unlock()
+			return rt.close()
+		},
+		Capacity: ti.Capacity,
+	}, nil
+}
+
+// remapTorrent is the storage of a task, swapped by a rename while the
+// piece IO waits
+type remapTorrent struct {
+	sync.RWMutex
+	s    *remapStorage
+	info *metainfo.Info
+	ih   metainfo.Hash
+	ti   storage.TorrentImpl
+}
+
+// rename moves src to dst, then reopens the storage with the paths of rn
+func (rt *remapTorrent) rename(rn *TaskRenames, src, dst string) error {
+	rt.Lock()
+	defer rt.Unlock()
+	moved := false
+	if _, err := os.Stat(src); err == nil {
+		if err := os.Rename(src, dst); err != nil {
+			return err
+		}
+		moved = true
+	}
+	ti, err := rt.s.fileStorage(rn).OpenTorrent(rt.info, rt.ih)
+	if err != nil {
+		if moved {
+			os.Rename(dst, src) // nolint: errcheck
+		}
+		return err
+	}
+	if rt.ti.Close != nil {
+		if err := rt.ti.Close(); err != nil {
+			log.Println("[Rename] close storage", err)
+		}
+	}
+	rt.ti = ti
+	return nil
+}
+
+func (rt *remapTorrent) close() error {
+	rt.Lock()
+	defer rt.Unlock()
+	if rt.ti.Close == nil {
+		return nil
+	}
+	return rt.ti.Close()
+}
+
+type remapPiece struct {
+	t *remapTorrent
+	p metainfo.Piece
+}
+
+func (p *remapPiece) ReadAt(b []byte, off int64) (int, error) {
+	p.t.RLock()
+	defer p.t.RUnlock()
+	return p.t.ti.Piece(p.p).ReadAt(b, off)
+}
+
+func (p *remapPiece) WriteAt(b []byte, off int64) (int, error) {
+	p.t.RLock()
+	defer p.t.RUnlock()
+	return p.t.ti.Piece(p.p).WriteAt(b, off)
+}
+
+func (p *remapPiece) MarkComplete() error {
+	p.t.RLock()
+	defer p.t.RUnlock()
+	return p.t.ti.Piece(p.p).MarkComplete()
+}
+
+func (p *remapPiece) MarkNotComplete() error {
+	p.t.RLock()
+	defer p.t.RUnlock()
+	return p.t.ti.Piece(p.p).MarkNotComplete()
+}
+
+func (p *remapPiece) Completion() storage.Completion {
+	p.t.RLock()
+	defer p.t.RUnlock()
+	return p.t.ti.Piece(p.p).Completion()
+}
@@ -0,0 +1,65 @@
+package engine
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func Test_checkRenameName(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"movie.mkv", false},
+		{"..movie", false},
+		{"", true},
+		{".", true},
+		{"..", true},
+		{"a/b", true},
+		{`..\b`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkRenameName(tt.name)
+			if (err != nil) != tt.wantErr || err != nil && !errors.Is(err, ErrInvalidName) {
+				t.Errorf("checkRenameName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEngine_renamedPath(t *testing.T) {
+	const ih = "0123456789abcdef0123456789abcdef01234567"
+	e := &Engine{cacheDir: t.TempDir()}
+	renames := `{"Files":{
+		"t/a.mkv": "t/b.mkv",
+		"t/up": "t/../../up",
+		"t/abs": "/etc/passwd",
+		"t/win": "t\\..\\..\\win",
+		"t/dot": "t/./dot",
+		"t/empty": ""
+	}}`
+	if err := ioutil.WriteFile(e.renamesFileName(ih), []byte(renames), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		p    string
+		want string
+	}{
+		{"t/a.mkv", "t/b.mkv"},
+		{"t/c.mkv", "t/c.mkv"},
+		{"t/up", "t/up"},
+		{"t/abs", "t/abs"},
+		{"t/win", "t/win"},
+		{"t/dot", "t/dot"},
+		{"t/empty", "t/empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.p, func(t *testing.T) {
+			if got := e.renamedPath(ih, tt.p); got != tt.want {
+				t.Errorf("renamedPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
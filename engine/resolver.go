@@ -0,0 +1,181 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dnsTimeout = 10 * time.Second
+	// the largest DNS message
+	maxDNSMessage = 64 << 10
+)
+
+var ErrInvalidResolver = errors.New("Invalid DNS resolver")
+
+type dnsState struct {
+	sync.RWMutex
+	resolver *net.Resolver
+}
+
+// NewResolver is the DNSResolver: empty for the system one, a DNS server
+// `1.1.1.1`, `udp://1.1.1.1:53` or `tcp://9.9.9.9`, or the url of a
+// DNS-over-HTTPS service `https://cloudflare-dns.com/dns-query`. The
+// host of a DoH url is looked up by the system resolver, an IP avoids it.
+func NewResolver(s string) (*net.Resolver, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(s, "https://") {
+		if _, err := url.Parse(s); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidResolver, err)
+		}
+		client := &http.Client{Timeout: dnsTimeout}
+		return &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return &dohConn{ctx: ctx, url: s, client: client}, nil
+			},
+		}, nil
+	}
+
+	network, addr := "udp", s
+	if i := strings.Index(s, "://"); i > 0 {
+		network, addr = s[:i], s[i+3:]
+	}
+	if network != "udp" && network != "tcp" {
+		return nil, fmt.Errorf("%w: %s, expecting udp://, tcp:// or https://", ErrInvalidResolver, s)
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "53")
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	if net.ParseIP(host) == nil {
+		return nil, fmt.Errorf("%w: %s, expecting the IP of a DNS server", ErrInvalidResolver, s)
+	}
+	var d net.Dialer
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return d.DialContext(ctx, network, addr)
+		},
+	}, nil
+}
+
+// DialContext dials by the resolver, the system one if nil
+func DialContext(r *net.Resolver) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Resolver: r}
+	return d.DialContext
+}
+
+// Resolver is the DNSResolver, nil for the system one
+func (e *Engine) Resolver() *net.Resolver {
+	e.dns.RLock()
+	defer e.dns.RUnlock()
+	return e.dns.resolver
+}
+
+func (e *Engine) setResolver(r *net.Resolver) {
+	e.dns.Lock()
+	e.dns.resolver = r
+	e.dns.Unlock()
+}
+
+// lookupTrackerIP looks up the trackers announced to by the DNSResolver
+func (e *Engine) lookupTrackerIP(u *url.URL) ([]net.IP, error) {
+	r := e.Resolver()
+	if r == nil {
+		return net.LookupIP(u.Hostname())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+	defer cancel()
+	addrs, err := r.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, a := range addrs {
+		ips[i] = a.IP
+	}
+	return ips, nil
+}
+
+// dohConn carries the DNS queries of the Go resolver to a DNS-over-HTTPS
+// service (RFC 8484). Not being a PacketConn, the messages are framed like
+// over TCP: each Write is a query prefixed by its length, answered by the
+// Reads the same way.
+type dohConn struct {
+	ctx      context.Context
+	url      string
+	client   *http.Client
+	deadline time.Time
+	resp     bytes.Buffer
+}
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	if len(b) < 2 || int(b[0])<<8|int(b[1]) != len(b)-2 {
+		return 0, errors.New("DoH: unexpected DNS message framing")
+	}
+	ctx := c.ctx
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(b[2:]))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("DoH: %s", resp.Status)
+	}
+	msg, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxDNSMessage))
+	if err != nil {
+		return 0, err
+	}
+	if len(msg) >= maxDNSMessage {
+		return 0, errors.New("DoH: response too large")
+	}
+	c.resp.Reset()
+	c.resp.Write([]byte{byte(len(msg) >> 8), byte(len(msg))})
+	c.resp.Write(msg)
+	return len(b), nil
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	return c.resp.Read(b)
+}
+
+func (c *dohConn) Close() error                       { return nil }
+func (c *dohConn) LocalAddr() net.Addr                { return dohAddr(c.url) }
+func (c *dohConn) RemoteAddr() net.Addr               { return dohAddr(c.url) }
+func (c *dohConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { return c.SetDeadline(t) }
+
+func (c *dohConn) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+type dohAddr string
+
+func (a dohAddr) Network() string { return "https" }
+func (a dohAddr) String() string  { return string(a) }
@@ -0,0 +1,69 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewResolver(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		wantNil bool
+		wantErr bool
+	}{
+		{"system", "", true, false},
+		{"ip", "1.1.1.1", false, false},
+		{"udp with port", "udp://1.1.1.1:5353", false, false},
+		{"tcp", "tcp://9.9.9.9", false, false},
+		{"ipv6", "[2606:4700:4700::1111]", false, false},
+		{"doh", "https://cloudflare-dns.com/dns-query", false, false},
+		{"hostname", "dns.google", false, true},
+		{"unknown scheme", "tls://1.1.1.1", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewResolver(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewResolver() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidResolver) {
+				t.Errorf("NewResolver() error = %v", err)
+			}
+			if !tt.wantErr && (r == nil) != tt.wantNil {
+				t.Errorf("NewResolver() = %v, wantNil %v", r, tt.wantNil)
+			}
+		})
+	}
+}
+
+func Test_dohConn(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		// echoes the query as the answer
+		b, _ := ioutil.ReadAll(r.Body)
+		w.Write(b)
+	}))
+	defer ts.Close()
+
+	c := &dohConn{ctx: context.Background(), url: ts.URL, client: ts.Client()}
+	query := []byte{0, 3, 'a', 'b', 'c'}
+	if n, err := c.Write(query); err != nil || n != len(query) {
+		t.Fatalf("Write() = %d, %v", n, err)
+	}
+	got, err := ioutil.ReadAll(io.LimitReader(c, 16))
+	if err != nil || string(got) != string(query) {
+		t.Errorf("Read() = %q, %v", got, err)
+	}
+	if _, err := c.Write([]byte{0, 9, 'a'}); err == nil {
+		t.Error("Write() misframed accepted")
+	}
+}
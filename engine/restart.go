@@ -0,0 +1,172 @@
+package engine
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrRestarting = errors.New("Engine is restarting")
+
+// RestartStatus is the progress of an engine restart
+type RestartStatus struct {
+	Restarting bool
+	Stage      string
+	Done       int
+	Total      int
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Error      string
+}
+
+// resumeTask is the state of a task kept across a restart or a backup
+type resumeTask struct {
+	InfoHash string
+	Started  bool
+	Labels   []string `json:",omitempty"`
+}
+
+type restartState struct {
+	sync.Mutex
+	status RestartStatus
+	// infohash -> state before the restart
+	resume map[string]resumeTask
+	// the states of the tasks restored into the queue of the
+	// MaxConcurrentTask, kept until they are added
+	queued map[string]resumeTask
+}
+
+func (e *Engine) setRestartStage(stage string, done, total int) {
+	e.restart.Lock()
+	defer e.restart.Unlock()
+	e.restart.status.Stage = stage
+	e.restart.status.Done = done
+	e.restart.status.Total = total
+}
+
+// RestartStatus reports the progress of the current (or last) restart
+func (e *Engine) RestartStatus() RestartStatus {
+	e.restart.Lock()
+	defer e.restart.Unlock()
+	return e.restart.status
+}
+
+func (e *Engine) resumeState(ih string) (rt resumeTask, ok bool) {
+	e.restart.Lock()
+	defer e.restart.Unlock()
+	if rt, ok = e.restart.resume[ih]; ok {
+		return
+	}
+	if rt, ok = e.restart.queued[ih]; ok {
+		delete(e.restart.queued, ih)
+	}
+	return
+}
+
+// queueResume keeps the state of the task ih queued by the
+// MaxConcurrentTask, as the restore is over before it's added
+func (e *Engine) queueResume(ih string) {
+	e.restart.Lock()
+	defer e.restart.Unlock()
+	rt, ok := e.restart.resume[ih]
+	if !ok {
+		return
+	}
+	if e.restart.queued == nil {
+		e.restart.queued = make(map[string]resumeTask)
+	}
+	e.restart.queued[ih] = rt
+}
+
+// queuedResume is the state kept for the queued task ih
+func (e *Engine) queuedResume(ih string) (rt resumeTask, ok bool) {
+	e.restart.Lock()
+	defer e.restart.Unlock()
+	rt, ok = e.restart.queued[ih]
+	return
+}
+
+func (e *Engine) setResume(resume map[string]resumeTask) {
+	e.restart.Lock()
+	defer e.restart.Unlock()
+	e.restart.resume = resume
+}
+
+// resumeTasks snapshots the state of current tasks. The queued tasks keep
+// the state they are restored with, or else go as added when loaded.
+func (e *Engine) resumeTasks() map[string]resumeTask {
+	e.RLock()
+	defer e.RUnlock()
+	resume := make(map[string]resumeTask)
+	for ih, t := range e.ts {
+		if t.IsQueueing {
+			if rt, ok := e.queuedResume(ih); ok {
+				resume[ih] = rt
+			}
+			continue
+		}
+		resume[ih] = resumeTask{InfoHash: ih, Started: t.Started, Labels: t.Labels}
+	}
+	return resume
+}
+
+// Restart re-creates the torrent client with the config, the tasks are
+// restored with their started/stopped state kept. The engine lock is only
+// held while the client is swapped, so the web UI stays responsive.
+func (e *Engine) Restart(c *Config) error {
+	e.restart.Lock()
+	if e.restart.status.Restarting {
+		e.restart.Unlock()
+		return ErrRestarting
+	}
+	e.restart.status = RestartStatus{
+		Restarting: true,
+		Stage:      "snapshot",
+		StartedAt:  time.Now(),
+	}
+	e.restart.Unlock()
+	log.Println("[Restart] engine restarting")
+
+	resume := e.resumeTasks()
+	e.setResume(resume)
+
+	e.setRestartStage("configure", 0, 0)
+	err := e.Configure(c)
+	if err == nil {
+		e.restoreCacheDir(func(done, total int) {
+			e.setRestartStage("restore", done, total)
+		})
+	}
+
+	e.restart.Lock()
+	defer e.restart.Unlock()
+	e.restart.resume = nil
+	e.restart.status.Restarting = false
+	e.restart.status.FinishedAt = time.Now()
+	if err != nil {
+		e.restart.status.Stage = "failed"
+		e.restart.status.Error = err.Error()
+		log.Println("[Restart] failed", err)
+		return err
+	}
+	e.restart.status.Stage = "done"
+	e.restart.status.Done = e.restart.status.Total
+	log.Println("[Restart] engine restarted, restored", len(resume), "tasks")
+	return nil
+}
+
+// Close drops the tasks and closes the torrent client, before the process
+// is replaced by a self-update
+func (e *Engine) Close() {
+	e.Lock()
+	defer e.Unlock()
+	if e.client == nil {
+		return
+	}
+	for _, t := range e.client.Torrents() {
+		t.Drop()
+	}
+	e.client.Close()
+	e.client = nil
+	log.Println("[Close] torrent client closed")
+}
@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"time"
+)
+
+const maxRetryBackoff = time.Hour
+
+// setError marks the task as errored and schedules the next retry
+// with exponential backoff, until RetryMaxAttempts is reached
+func (t *Torrent) setError(err error) {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.Error != "" {
+		// already errored, retry is scheduled
+		return
+	}
+	t.Error = err.Error()
+	log.Printf("[TaskError]%s %s", t.InfoHash, t.Error)
+	t.e.notify(EventError, t, t.Error)
+
+	if max := t.e.config.RetryMaxAttempts; max <= 0 || t.RetryCount >= max {
+		log.Printf("[TaskError]%s no more retry, attempted %d", t.InfoHash, t.RetryCount)
+		return
+	}
+	t.NextRetryAt = time.Now().Add(retryBackoff(t.e.config.RetryBackoff, t.RetryCount))
+}
+
+// clearError resets the error state, called with torrent lock held
+func (t *Torrent) clearError() {
+	t.Error = ""
+	t.NextRetryAt = time.Time{}
+	if t.t != nil {
+		t.t.AllowDataDownload()
+	}
+}
+
+// retryDue tells whether the errored task is due to retry, and resets the
+// error for the attempt, called with torrent lock held
+func (t *Torrent) retryDue(now time.Time) bool {
+	if t.Error == "" || t.NextRetryAt.IsZero() || now.Before(t.NextRetryAt) {
+		return false
+	}
+
+	t.RetryCount++
+	log.Printf("[Retry]%s attempt %d, last error: %s", t.InfoHash, t.RetryCount, t.Error)
+	t.clearError()
+	return true
+}
+
+// retryRoutine retries the errored task when the backoff is due
+func (e *Engine) retryRoutine(t *Torrent) {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.retryDue(time.Now()) && t.Started && t.t.Info() != nil {
+		t.downloadSelected()
+	}
+}
+
+// retryMetadata tells whether the magnet task errored without the info is
+// due to wait the MetadataTimeout again, and try the sources after
+func (e *Engine) retryMetadata(t *Torrent) bool {
+	t.Lock()
+	defer t.Unlock()
+	return t.retryDue(time.Now())
+}
+
+func retryBackoff(base time.Duration, n int) time.Duration {
+	if base <= 0 {
+		base = time.Minute
+	}
+	d := base
+	for i := 0; i < n && d < maxRetryBackoff; i++ {
+		d *= 2
+	}
+	if d > maxRetryBackoff {
+		d = maxRetryBackoff
+	}
+	return d
+}
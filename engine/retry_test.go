@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_retryBackoff(t *testing.T) {
+	type args struct {
+		base time.Duration
+		n    int
+	}
+	tests := []struct {
+		name string
+		args args
+		want time.Duration
+	}{
+		{"first", args{time.Minute, 0}, time.Minute},
+		{"double", args{time.Minute, 2}, 4 * time.Minute},
+		{"default", args{0, 1}, 2 * time.Minute},
+		{"cap", args{time.Minute, 10}, maxRetryBackoff},
+		{"overflow", args{time.Minute, 100}, maxRetryBackoff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryBackoff(tt.args.base, tt.args.n); got != tt.want {
+				t.Errorf("retryBackoff() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTorrent_retryDue(t *testing.T) {
+	now := time.Now()
+	metadataErr := ErrMetadataNotFound.Error()
+	tests := []struct {
+		name      string
+		err       string
+		next      time.Time
+		want      bool
+		wantCount int
+	}{
+		{"no error", "", now.Add(-time.Second), false, 0},
+		{"no more retry", metadataErr, time.Time{}, false, 0},
+		{"backoff", metadataErr, now.Add(time.Second), false, 0},
+		{"metadata due", metadataErr, now.Add(-time.Second), true, 1},
+		{"write due", "disk full", now, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := &Torrent{Error: tt.err, NextRetryAt: tt.next}
+			if got := task.retryDue(now); got != tt.want {
+				t.Errorf("retryDue() = %v, want %v", got, tt.want)
+			}
+			if task.RetryCount != tt.wantCount {
+				t.Errorf("RetryCount = %d, want %d", task.RetryCount, tt.wantCount)
+			}
+			if tt.want && (task.Error != "" || !task.NextRetryAt.IsZero()) {
+				t.Errorf("error not reset: %q %v", task.Error, task.NextRetryAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// in the cache dir, a JSON line an RSS item downloaded
+	rssHistoryFile = "rss-history.jsonl"
+	maxRSSHistory  = 5000
+)
+
+var releaseTitleSepRe = regexp.MustCompile(`[^\pL\pN]+`)
+
+// RSSItem is an item of the RSS feeds added as a task, kept across the
+// restarts so the feeds listing it again show it downloaded
+type RSSItem struct {
+	InfoHash string `json:",omitempty"`
+	Title    string
+	GUID     string `json:",omitempty"`
+	Feed     string `json:",omitempty"`
+	AddedAt  time.Time
+}
+
+// rssHistory is the history file, its infohashes and normalized titles
+// loaded at the first use
+type rssHistory struct {
+	sync.Mutex
+	fn         string
+	infohashes map[string]bool
+	titles     map[string]bool
+}
+
+// NormalizeReleaseTitle is the title of a release as compared across the
+// feeds: lower case, the dots, dashes, brackets and spaces as one space
+func NormalizeReleaseTitle(title string) string {
+	return strings.TrimSpace(releaseTitleSepRe.ReplaceAllString(strings.ToLower(title), " "))
+}
+
+func (e *Engine) rssHistoryFileName() string {
+	return filepath.Join(e.cacheDir, rssHistoryFile)
+}
+
+// load reads the history file, again if the cache dir changed, with the
+// lock held
+func (h *rssHistory) load(fn string) error {
+	if h.fn == fn {
+		return nil
+	}
+	h.infohashes, h.titles = make(map[string]bool), make(map[string]bool)
+	data, err := ioutil.ReadFile(fn)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var it RSSItem
+		if json.Unmarshal(line, &it) == nil {
+			h.add(it)
+		}
+	}
+	h.fn = fn
+	return nil
+}
+
+func (h *rssHistory) add(it RSSItem) {
+	if it.InfoHash != "" {
+		h.infohashes[strings.ToLower(it.InfoHash)] = true
+	}
+	if title := NormalizeReleaseTitle(it.Title); title != "" {
+		h.titles[title] = true
+	}
+}
+
+// RecordRSSItem appends the item to the history of the downloaded RSS
+// items, the oldest ones beyond maxRSSHistory are dropped
+func (e *Engine) RecordRSSItem(it RSSItem) error {
+	if it.AddedAt.IsZero() {
+		it.AddedAt = time.Now()
+	}
+	line, err := json.Marshal(it)
+	if err != nil {
+		return err
+	}
+	e.rss.Lock()
+	defer e.rss.Unlock()
+	fn := e.rssHistoryFileName()
+	if err := e.rss.load(fn); err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(fn)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	lines := append(bytes.Split(bytes.TrimSpace(data), []byte("\n")), line)
+	if len(lines[0]) == 0 {
+		lines = lines[1:]
+	}
+	if n := len(lines) - maxRSSHistory; n > 0 {
+		lines = lines[n:]
+		// the dropped ones forgotten at the next load
+		e.rss.fn = ""
+	}
+	tmp := fn + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(bytes.Join(lines, []byte("\n")), '\n'), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, fn); err != nil {
+		return err
+	}
+	e.rss.add(it)
+	return nil
+}
+
+// RSSDownloaded tells whether an RSS item of the infohash or the title was
+// downloaded, recorded in the history or a current task
+func (e *Engine) RSSDownloaded(infohash, title string) bool {
+	infohash = strings.ToLower(infohash)
+	if infohash != "" {
+		e.RLock()
+		_, ok := e.ts[infohash]
+		e.RUnlock()
+		if ok {
+			return true
+		}
+	}
+	e.rss.Lock()
+	defer e.rss.Unlock()
+	if err := e.rss.load(e.rssHistoryFileName()); err != nil {
+		log.Println("[RSS]", err)
+		return false
+	}
+	return infohash != "" && e.rss.infohashes[infohash] || e.rss.titles[NormalizeReleaseTitle(title)]
+}
@@ -0,0 +1,57 @@
+package engine
+
+import "testing"
+
+func TestNormalizeReleaseTitle(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Show.Name.S01E02.1080p.WEB-DL", "show name s01e02 1080p web dl"},
+		{"  [Group] Show Name - 02 (1080p) ", "group show name 02 1080p"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := NormalizeReleaseTitle(tt.title); got != tt.want {
+			t.Errorf("NormalizeReleaseTitle(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestEngine_RSSDownloaded(t *testing.T) {
+	dir := t.TempDir()
+	e := &Engine{cacheDir: dir, ts: map[string]*Torrent{"cccc": {InfoHash: "cccc"}}}
+	if e.RSSDownloaded("aaaa", "Show.S01E01") {
+		t.Fatal("RSSDownloaded() of an empty history")
+	}
+	for _, it := range []RSSItem{
+		{InfoHash: "AAAA", Title: "Show.S01E01.1080p"},
+		{Title: "Other Show - S02E03 [720p]"},
+	} {
+		if err := e.RecordRSSItem(it); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// as read again after a restart
+	restarted := &Engine{cacheDir: dir}
+	tests := []struct {
+		e        *Engine
+		infohash string
+		title    string
+		want     bool
+	}{
+		{e, "aaaa", "", true},
+		{restarted, "aaaa", "", true},
+		{restarted, "", "show s01e01 1080p", true},
+		{restarted, "bbbb", "other.show.s02e03.720p", true},
+		{restarted, "bbbb", "Other Show S02E04", false},
+		{e, "CCCC", "", true},
+		{restarted, "cccc", "", false},
+	}
+	for _, tt := range tests {
+		if got := tt.e.RSSDownloaded(tt.infohash, tt.title); got != tt.want {
+			t.Errorf("RSSDownloaded(%q, %q) = %v, want %v", tt.infohash, tt.title, got, tt.want)
+		}
+	}
+}
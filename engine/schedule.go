@@ -0,0 +1,218 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	taskScheduleExt = ".schedule"
+	scheduleTick    = 30 * time.Second
+)
+
+var ErrInvalidSchedule = errors.New("Invalid scheduled start time")
+
+// scheduleState keeps the scheduled starts apart from the tasks, so the
+// scheduler never takes the engine lock unless a start is due
+type scheduleState struct {
+	sync.Mutex
+	// infohash -> start time
+	at map[string]time.Time
+}
+
+func (s *scheduleState) set(ih string, at time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	if s.at == nil {
+		s.at = make(map[string]time.Time)
+	}
+	s.at[ih] = at
+}
+
+func (s *scheduleState) forget(ih string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.at, ih)
+}
+
+// due lists the tasks scheduled to start by now
+func (s *scheduleState) due(now time.Time) []string {
+	s.Lock()
+	defer s.Unlock()
+	var due []string
+	for ih, at := range s.at {
+		if !now.Before(at) {
+			due = append(due, ih)
+		}
+	}
+	return due
+}
+
+// scheduleFileName is saved in the cache dir next to the task's torrent
+// file, the start time in RFC 3339
+func (e *Engine) scheduleFileName(infohash string) string {
+	return filepath.Join(e.cacheDir, fmt.Sprintf("%s%s%s", cacheSavedPrefix, infohash, taskScheduleExt))
+}
+
+// loadSchedule restores the scheduled start of a new task
+func (e *Engine) loadSchedule(t *Torrent) {
+	data, err := ioutil.ReadFile(e.scheduleFileName(t.InfoHash))
+	if err != nil {
+		return
+	}
+	at, err := time.Parse(time.RFC3339, string(data))
+	if err != nil {
+		log.Println("[Schedule]", t.InfoHash, err)
+		return
+	}
+	t.ScheduledStart = &at
+	e.schedules.set(t.InfoHash, at)
+}
+
+func (e *Engine) saveSchedule(infohash string, at time.Time) error {
+	if err := ioutil.WriteFile(e.scheduleFileName(infohash), []byte(at.Format(time.RFC3339)), 0644); err != nil {
+		return err
+	}
+	e.schedules.set(infohash, at)
+	return nil
+}
+
+func (e *Engine) removeSchedule(infohash string) {
+	e.schedules.forget(infohash)
+	if err := os.Remove(e.scheduleFileName(infohash)); err != nil && !os.IsNotExist(err) {
+		log.Println("[Schedule]", infohash, err)
+	}
+}
+
+// clearSchedule removes the schedule of the task, after started
+func (e *Engine) clearSchedule(infohash string) {
+	e.removeSchedule(infohash)
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return
+	}
+	t.Lock()
+	t.ScheduledStart = nil
+	t.Unlock()
+}
+
+// scheduleAdded saves the schedule asked by the add, before the task is
+// upserted which loads it
+func (e *Engine) scheduleAdded(infohash string, opt AddOptions) {
+	if opt.StartAt.IsZero() {
+		return
+	}
+	if err := e.saveSchedule(infohash, opt.StartAt); err != nil {
+		log.Println("[Schedule]", infohash, err)
+	}
+}
+
+// checkSchedule tells if at is fine to start a task at, zero for none
+func checkSchedule(at time.Time) error {
+	if !at.IsZero() && at.Before(time.Now()) {
+		return fmt.Errorf("%w: %s is in the past", ErrInvalidSchedule, at.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// ScheduleStart keeps the task stopped until the time at, when it's started
+// by the scheduler. A zero time cancels the schedule.
+func (e *Engine) ScheduleStart(infohash string, at time.Time) error {
+	if err := checkSchedule(at); err != nil {
+		return err
+	}
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	if at.IsZero() {
+		e.clearSchedule(infohash)
+		log.Println("[Schedule]", infohash, "canceled")
+		e.TsChanged <- struct{}{}
+		return nil
+	}
+	if err := e.saveSchedule(infohash, at); err != nil {
+		return err
+	}
+	t.Lock()
+	t.ScheduledStart = &at
+	t.noAutoStart = true
+	started := t.Started
+	t.Unlock()
+	if started {
+		// not before the time scheduled
+		if err := e.StopTorrent(infohash); err != nil {
+			log.Println("[Schedule]", infohash, err)
+		}
+	}
+	log.Println("[Schedule]", infohash, "to start at", at.Format(time.RFC3339))
+	e.TsChanged <- struct{}{}
+	return nil
+}
+
+// scheduleRoutine starts the tasks due
+func (e *Engine) scheduleRoutine() {
+	tk := time.NewTicker(scheduleTick)
+	defer tk.Stop()
+	for now := range tk.C {
+		started := false
+		for _, ih := range e.schedules.due(now) {
+			e.RLock()
+			_, err := e.getTorrent(ih)
+			e.RUnlock()
+			if err != nil {
+				// gone, or being restored by a restart which loads it again
+				e.schedules.forget(ih)
+				continue
+			}
+			started = e.startScheduled(ih, e.startLoaded) || started
+		}
+		if started {
+			e.TsChanged <- struct{}{}
+		}
+	}
+}
+
+// startScheduled starts the task due, the schedule is kept for the next tick
+// if the task can't be started yet: in the maintenance, the download volume
+// unavailable, queued by the MaxConcurrentTask, or a magnet waiting for its info
+func (e *Engine) startScheduled(ih string, start func(string) error) bool {
+	err := start(ih)
+	if errors.Is(err, ErrMaintenance) || errors.Is(err, ErrVolumeUnavailable) || errors.Is(err, ErrNotLoaded) {
+		return false
+	}
+	if err != nil {
+		log.Println("[Schedule]", ih, err)
+	} else {
+		log.Println("[Schedule]", ih, "started as scheduled")
+	}
+	e.clearSchedule(ih)
+	return true
+}
+
+// startLoaded starts the task if it's in the torrent client with the info
+func (e *Engine) startLoaded(ih string) error {
+	e.RLock()
+	t, err := e.getTorrent(ih)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+	t.Lock()
+	loaded := t.t != nil
+	t.Unlock()
+	if !loaded {
+		return ErrNotLoaded
+	}
+	return e.StartTorrent(ih)
+}
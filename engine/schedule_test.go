@@ -0,0 +1,66 @@
+package engine
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_startScheduled(t *testing.T) {
+	const ih = "0123456789abcdef0123456789abcdef01234567"
+	tests := []struct {
+		name     string
+		startErr error
+		want     bool
+		kept     bool
+	}{
+		{"started", nil, true, false},
+		{"maintenance", ErrMaintenance, false, true},
+		{"volume unavailable", ErrVolumeUnavailable, false, true},
+		{"not loaded", ErrNotLoaded, false, true},
+		{"already started", errors.New("already started"), true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Engine{ts: make(map[string]*Torrent), cacheDir: t.TempDir()}
+			at := time.Now().Add(-time.Minute)
+			task := &Torrent{InfoHash: ih, ScheduledStart: &at}
+			e.ts[ih] = task
+			if err := e.saveSchedule(ih, at); err != nil {
+				t.Fatal(err)
+			}
+
+			var called string
+			got := e.startScheduled(ih, func(s string) error {
+				called = s
+				return tt.startErr
+			})
+			if got != tt.want || called != ih {
+				t.Errorf("startScheduled() = %v, called %q, want %v", got, called, tt.want)
+			}
+			due := e.schedules.due(time.Now())
+			_, err := os.Stat(e.scheduleFileName(ih))
+			if kept := len(due) == 1 && err == nil && task.ScheduledStart != nil; kept != tt.kept {
+				t.Errorf("schedule kept = %v, want %v", kept, tt.kept)
+			}
+		})
+	}
+}
+
+func Test_scheduleState_due(t *testing.T) {
+	now := time.Now()
+	var s scheduleState
+	s.set("past", now.Add(-time.Second))
+	s.set("now", now)
+	s.set("future", now.Add(time.Second))
+	due := s.due(now)
+	if len(due) != 2 {
+		t.Fatalf("due() = %v, want past and now", due)
+	}
+	for _, ih := range due {
+		if ih == "future" {
+			t.Errorf("due() = %v, future is not due", due)
+		}
+	}
+}
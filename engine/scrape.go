@@ -0,0 +1,191 @@
+package engine
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/tracker/udp"
+)
+
+// the number of trackers asked on a scrape
+const maxScrapeTrackers = 20
+
+// SwarmStat is the swarm size reported by the trackers scrape
+type SwarmStat struct {
+	Seeders   int
+	Leechers  int
+	Completed int
+	// the number of trackers answered
+	Trackers int
+}
+
+// merge takes the largest numbers, the trackers see overlapping swarms
+func (s *SwarmStat) merge(o SwarmStat) {
+	if o.Seeders > s.Seeders {
+		s.Seeders = o.Seeders
+	}
+	if o.Leechers > s.Leechers {
+		s.Leechers = o.Leechers
+	}
+	if o.Completed > s.Completed {
+		s.Completed = o.Completed
+	}
+	s.Trackers += o.Trackers
+}
+
+// ScrapeTrackers asks the trackers for the swarm sizes of the infohashes,
+// the hashes no tracker knows are absent from the result
+func ScrapeTrackers(ctx context.Context, trackers []string, infohashes []string) map[string]SwarmStat {
+	var ihs [][20]byte
+	for _, h := range infohashes {
+		var ih [20]byte
+		if b, err := hex.DecodeString(h); err == nil && len(b) == 20 {
+			copy(ih[:], b)
+			ihs = append(ihs, ih)
+		}
+	}
+	res := make(map[string]SwarmStat)
+	if len(ihs) == 0 {
+		return res
+	}
+	if len(trackers) > maxScrapeTrackers {
+		trackers = trackers[:maxScrapeTrackers]
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, tr := range trackers {
+		wg.Add(1)
+		go func(tr string) {
+			defer wg.Done()
+			// the dead trackers are common, not worth a log
+			stats, err := scrapeTracker(ctx, tr, ihs)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for i, st := range stats {
+				if st.Trackers == 0 {
+					continue
+				}
+				h := hex.EncodeToString(ihs[i][:])
+				cur := res[h]
+				cur.merge(st)
+				res[h] = cur
+			}
+		}(tr)
+	}
+	wg.Wait()
+	return res
+}
+
+// scrapeTracker returns the stats in the order of ihs
+func scrapeTracker(ctx context.Context, tracker string, ihs [][20]byte) ([]SwarmStat, error) {
+	u, err := url.Parse(tracker)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "udp", "udp4", "udp6":
+		return scrapeUDP(ctx, u, ihs)
+	case "http", "https":
+		return scrapeHTTP(ctx, u, ihs)
+	}
+	return nil, fmt.Errorf("unsupported tracker %s", u.Scheme)
+}
+
+func scrapeUDP(ctx context.Context, u *url.URL, ihs [][20]byte) ([]SwarmStat, error) {
+	network := u.Scheme
+	if network == "udp" {
+		network = "udp4"
+	}
+	cc, err := udp.NewConnClient(udp.NewConnClientOpts{Network: network, Host: u.Host})
+	if err != nil {
+		return nil, err
+	}
+	defer cc.Close()
+
+	req := make([]udp.InfoHash, len(ihs))
+	for i, ih := range ihs {
+		req[i] = ih
+	}
+	resp, err := cc.Client.Scrape(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	stats := make([]SwarmStat, len(ihs))
+	for i, r := range resp {
+		stats[i] = SwarmStat{
+			Seeders:   int(r.Seeders),
+			Leechers:  int(r.Leechers),
+			Completed: int(r.Completed),
+			Trackers:  1,
+		}
+	}
+	return stats, nil
+}
+
+// scrapeURL is the scrape convention url of an announce url
+func scrapeURL(u *url.URL) (*url.URL, error) {
+	i := strings.LastIndex(u.Path, "/")
+	if i < 0 || !strings.HasPrefix(u.Path[i+1:], "announce") {
+		return nil, fmt.Errorf("tracker doesn't support scrape")
+	}
+	su := *u
+	su.Path = u.Path[:i+1] + "scrape" + strings.TrimPrefix(u.Path[i+1:], "announce")
+	return &su, nil
+}
+
+func scrapeHTTP(ctx context.Context, u *url.URL, ihs [][20]byte) ([]SwarmStat, error) {
+	su, err := scrapeURL(u)
+	if err != nil {
+		return nil, err
+	}
+	q := su.Query()
+	for _, ih := range ihs {
+		q.Add("info_hash", string(ih[:]))
+	}
+	su.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", su.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("scrape %s", resp.Status)
+	}
+	var body struct {
+		Files map[string]struct {
+			Complete   int `bencode:"complete"`
+			Incomplete int `bencode:"incomplete"`
+			Downloaded int `bencode:"downloaded"`
+		} `bencode:"files"`
+	}
+	if err := bencode.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	stats := make([]SwarmStat, len(ihs))
+	for i, ih := range ihs {
+		if f, ok := body.Files[string(ih[:])]; ok {
+			stats[i] = SwarmStat{
+				Seeders:   f.Complete,
+				Leechers:  f.Incomplete,
+				Completed: f.Downloaded,
+				Trackers:  1,
+			}
+		}
+	}
+	return stats, nil
+}
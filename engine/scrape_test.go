@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/anacrolix/torrent/bencode"
+)
+
+func Test_scrapeURL(t *testing.T) {
+	tests := []struct {
+		announce string
+		want     string
+		wantErr  bool
+	}{
+		{"http://t.example/announce", "http://t.example/scrape", false},
+		{"http://t.example/x/announce.php", "http://t.example/x/scrape.php", false},
+		{"http://t.example/announce?passkey=k", "http://t.example/scrape?passkey=k", false},
+		{"http://t.example/a", "", true},
+		{"http://t.example/announce/x", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.announce, func(t *testing.T) {
+			u, _ := url.Parse(tt.announce)
+			got, err := scrapeURL(u)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("scrapeURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("scrapeURL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScrapeTrackers(t *testing.T) {
+	const known, unknown = "0123456789abcdef0123456789abcdef01234567", "76543210fedcba9876543210fedcba9876543210"
+	ih, _ := hex.DecodeString(known)
+	tracker := func(complete, incomplete int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/scrape" {
+				http.NotFound(w, r)
+				return
+			}
+			files := map[string]interface{}{
+				string(ih): map[string]int{"complete": complete, "incomplete": incomplete, "downloaded": 7},
+			}
+			w.Write(bencode.MustMarshal(map[string]interface{}{"files": files})) // nolint: errcheck
+		}))
+	}
+	a, b := tracker(5, 1), tracker(2, 3)
+	defer a.Close()
+	defer b.Close()
+
+	trackers := []string{a.URL + "/announce", b.URL + "/announce", "wss://t.example/announce"}
+	got := ScrapeTrackers(context.Background(), trackers, []string{known, unknown, "bad"})
+	if _, ok := got[unknown]; ok || len(got) != 1 {
+		t.Fatalf("ScrapeTrackers() = %v, want only the known hash", got)
+	}
+	if want := (SwarmStat{Seeders: 5, Leechers: 3, Completed: 7, Trackers: 2}); got[known] != want {
+		t.Errorf("ScrapeTrackers() = %+v, want %+v", got[known], want)
+	}
+}
@@ -0,0 +1,163 @@
+package engine
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"golang.org/x/time/rate"
+)
+
+// scrubState keeps a scrub round from overlapping the next
+type scrubState struct {
+	sync.Mutex
+	running bool
+	lastRun time.Time
+}
+
+// scrubRoutine rechecks a ScrubFraction of the seeded tasks every
+// ScrubInterval, the least recently scrubbed first
+func (e *Engine) scrubRoutine() {
+	tk := time.NewTicker(time.Minute)
+	defer tk.Stop()
+	for now := range tk.C {
+		e.RLock()
+		interval := e.config.ScrubInterval
+		configured := e.client != nil
+		e.RUnlock()
+		if interval <= 0 || !configured || e.inMaintenance() || e.volumeUnavailable() {
+			continue
+		}
+		e.scrub.Lock()
+		due := !e.scrub.running && now.Sub(e.scrub.lastRun) >= interval
+		if due {
+			e.scrub.running = true
+			e.scrub.lastRun = now
+		}
+		e.scrub.Unlock()
+		if due {
+			e.scrubRound()
+			e.scrub.Lock()
+			e.scrub.running = false
+			e.scrub.Unlock()
+		}
+	}
+}
+
+// scrubPick picks the fraction of the seeded tasks, at least one, the
+// least recently scrubbed first
+func scrubPick(seeded []*Torrent, fraction float32) []*Torrent {
+	if len(seeded) == 0 || fraction <= 0 {
+		return nil
+	}
+	sort.SliceStable(seeded, func(i, j int) bool {
+		return seeded[i].ScrubbedAt.Before(seeded[j].ScrubbedAt)
+	})
+	n := int(math.Ceil(float64(fraction) * float64(len(seeded))))
+	if n > len(seeded) {
+		n = len(seeded)
+	}
+	return seeded[:n]
+}
+
+func (e *Engine) scrubRound() {
+	e.RLock()
+	c := e.config
+	var seeded []*Torrent
+	for _, t := range e.ts {
+		t.Lock()
+		if t.Done && t.t != nil {
+			seeded = append(seeded, t)
+		}
+		t.Unlock()
+	}
+	e.RUnlock()
+
+	l, err := rateLimiter(c.ScrubRate)
+	if err != nil {
+		log.Println("[Scrub] ScrubRate", err)
+		return
+	}
+	for _, t := range scrubPick(seeded, c.ScrubFraction) {
+		if e.inMaintenance() || e.volumeUnavailable() {
+			return
+		}
+		t.scrubData(l, c.ScrubRepair)
+	}
+	e.TsChanged <- struct{}{}
+}
+
+// scrubData rehashes the complete pieces of the task one by one, read at
+// the rate of l. The corrupt pieces are downloaded again if repair, or
+// else kept unwanted and flagged.
+func (t *Torrent) scrubData(l *rate.Limiter, repair bool) {
+	t.Lock()
+	tt := t.t
+	t.Unlock()
+	if tt == nil || tt.Info() == nil {
+		return
+	}
+	log.Println("[Scrub] started", t.InfoHash)
+	start := time.Now()
+	var corrupt int
+	for i := 0; i < tt.NumPieces(); i++ {
+		if !tt.PieceState(i).Complete {
+			continue
+		}
+		p := tt.Piece(i)
+		if err := waitBytes(l, p.Info().Length()); err != nil {
+			log.Println("[Scrub]", t.InfoHash, err)
+			return
+		}
+		select {
+		case <-tt.Closed():
+			return
+		default:
+		}
+		p.VerifyData()
+		if tt.PieceState(i).Complete {
+			continue
+		}
+		corrupt++
+		log.Printf("[Scrub]%s piece %d corrupt", t.InfoHash, i)
+		if repair {
+			tt.DownloadPieces(i, i+1)
+		} else {
+			p.SetPriority(torrent.PiecePriorityNone)
+		}
+	}
+
+	t.Lock()
+	t.ScrubbedAt = time.Now()
+	t.CorruptPieces = corrupt
+	t.Unlock()
+	log.Printf("[Scrub]%s finished in %s, corrupt pieces: %d", t.InfoHash, time.Since(start), corrupt)
+	if corrupt > 0 {
+		msg := "corrupt pieces found by the scrub"
+		if repair {
+			msg += ", downloading them again"
+		}
+		t.e.notify(EventError, t, msg)
+	}
+}
+
+// waitBytes waits l for n bytes, by the bursts of l
+func waitBytes(l *rate.Limiter, n int64) error {
+	if l.Limit() == rate.Inf {
+		return nil
+	}
+	for n > 0 {
+		b := int64(l.Burst())
+		if b > n {
+			b = n
+		}
+		if err := l.WaitN(context.Background(), int(b)); err != nil {
+			return err
+		}
+		n -= b
+	}
+	return nil
+}
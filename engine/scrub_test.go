@@ -0,0 +1,40 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_scrubPick(t *testing.T) {
+	now := time.Now()
+	seeded := func() []*Torrent {
+		return []*Torrent{
+			{InfoHash: "a", ScrubbedAt: now},
+			{InfoHash: "b"},
+			{InfoHash: "c", ScrubbedAt: now.Add(-time.Hour)},
+			{InfoHash: "d", ScrubbedAt: now.Add(-time.Minute)},
+		}
+	}
+	tests := []struct {
+		name     string
+		fraction float32
+		want     string
+	}{
+		{"disabled", 0, ""},
+		{"at least one", 0.1, "b"},
+		{"half", 0.5, "bc"},
+		{"rounded up", 0.6, "bcd"},
+		{"all", 1, "bcda"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			for _, p := range scrubPick(seeded(), tt.fraction) {
+				got += p.InfoHash
+			}
+			if got != tt.want {
+				t.Errorf("scrubPick() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
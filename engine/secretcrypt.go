@@ -0,0 +1,139 @@
+package engine
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	// the key encrypting the config secrets, or the file holding it
+	secretKeyEnv     = configEnvPrefix + "_SECRET_KEY"
+	secretKeyFileEnv = configEnvPrefix + "_SECRET_KEYFILE"
+)
+
+var (
+	ErrNoSecretKey      = errors.New("No secret key, set " + secretKeyEnv + " or " + secretKeyFileEnv)
+	ErrInvalidEncSecret = errors.New("Invalid encrypted secret")
+)
+
+// secretKey is the AES-256 key of the encrypted secrets, the SHA-256 of the
+// key from the env or the key file
+func secretKey() ([]byte, error) {
+	material, ok := os.LookupEnv(secretKeyEnv)
+	if !ok {
+		fn, ok := os.LookupEnv(secretKeyFileEnv)
+		if !ok {
+			return nil, ErrNoSecretKey
+		}
+		b, err := os.ReadFile(fn)
+		if err != nil {
+			return nil, err
+		}
+		material = string(b)
+	}
+	material = strings.TrimSpace(material)
+	if material == "" {
+		return nil, ErrNoSecretKey
+	}
+	sum := sha256.Sum256([]byte(material))
+	return sum[:], nil
+}
+
+func secretGCM() (cipher.AEAD, error) {
+	key, err := secretKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptSecret encrypts the value with AES-GCM into a ${enc:...} secret
+// reference, decrypted when the config value is used
+func EncryptSecret(plain string) (string, error) {
+	gcm, err := secretGCM()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	return "${enc:" + base64.RawURLEncoding.EncodeToString(sealed) + "}", nil
+}
+
+// decryptSecret decrypts the payload of a ${enc:...} reference
+func decryptSecret(payload string) (string, error) {
+	gcm, err := secretGCM()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return "", ErrInvalidEncSecret
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("%w: wrong key or corrupted", ErrInvalidEncSecret)
+	}
+	return string(plain), nil
+}
+
+// checkEncryptedSecrets tells if the ${enc:...} references of the value
+// can be decrypted, the file and env ones are left to the use
+func checkEncryptedSecrets(val string) error {
+	for _, m := range secretRefRegexp.FindAllStringSubmatch(val, -1) {
+		if m[1] != "enc" {
+			continue
+		}
+		if _, err := decryptSecret(m[2]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSecrets tells the first secret field with an encrypted reference
+// which can't be decrypted
+func (c *Config) checkSecrets() error {
+	for _, f := range secretFields {
+		if err := checkEncryptedSecrets(*f.get(c)); err != nil {
+			return &ConfigError{Field: f.name, Err: err}
+		}
+	}
+	return nil
+}
+
+// EncryptCommand is the `encrypt` subcommand, printing the secret reference
+// of the value read from the stdin, kept out of the shell history
+type EncryptCommand struct{}
+
+func (c *EncryptCommand) Run() error {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	val := strings.TrimRight(line, "\r\n")
+	if val == "" {
+		return errors.New("nothing to encrypt")
+	}
+	enc, err := EncryptSecret(val)
+	if err != nil {
+		return err
+	}
+	fmt.Println(enc)
+	return nil
+}
@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const secretMask = "******"
+
+// secret references in config values: ${file:/run/secrets/proxy},
+// ${env:PROXY} or ${enc:...} encrypted by EncryptSecret
+var secretRefRegexp = regexp.MustCompile(`\$\{(file|env|enc):([^}]+)\}`)
+
+// ResolveSecrets expands the secret references in a config value
+func ResolveSecrets(val string) (string, error) {
+	var rerr error
+	res := secretRefRegexp.ReplaceAllStringFunc(val, func(ref string) string {
+		m := secretRefRegexp.FindStringSubmatch(ref)
+		switch m[1] {
+		case "file":
+			b, err := os.ReadFile(m[2])
+			if err != nil {
+				rerr = fmt.Errorf("secret %s: %w", ref, err)
+				return ""
+			}
+			return strings.TrimRight(string(b), "\r\n")
+		case "enc":
+			v, err := decryptSecret(m[2])
+			if err != nil {
+				rerr = fmt.Errorf("secret ${enc:...}: %w", err)
+			}
+			return v
+		default:
+			v, ok := os.LookupEnv(m[2])
+			if !ok {
+				rerr = fmt.Errorf("secret %s: env not set", ref)
+			}
+			return v
+		}
+	})
+	return res, rerr
+}
+
+// resolvedProxyURL is the ProxyURL with secrets expanded
+func (c *Config) resolvedProxyURL() (string, error) {
+	return ResolveSecrets(c.ProxyURL)
+}
+
+// maskURL hides the password in an url
+func maskURL(u string) string {
+	pu, err := url.Parse(u)
+	if err != nil || pu.User == nil {
+		return u
+	}
+	if _, ok := pu.User.Password(); !ok {
+		return u
+	}
+	pu.User = url.UserPassword(pu.User.Username(), secretMask)
+	return pu.String()
+}
+
+// maskValue hides a plain secret value, the references are kept
+func maskValue(v string) string {
+	if v == "" || secretRefRegexp.ReplaceAllString(v, "") == "" {
+		return v
+	}
+	return secretMask
+}
+
+// maskClusterNodes hides the credentials of the `<name> <url> [user:password]` lines
+func maskClusterNodes(nodes string) string {
+	var sb strings.Builder
+	sc := bufio.NewScanner(strings.NewReader(nodes))
+	for sc.Scan() {
+		if sb.Len() > 0 {
+			sb.WriteByte('\n')
+		}
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 3 {
+			if up := strings.SplitN(fields[2], ":", 2); len(up) == 2 {
+				fields[2] = up[0] + ":" + secretMask
+			}
+			sb.WriteString(strings.Join(fields, " "))
+			continue
+		}
+		sb.WriteString(sc.Text())
+	}
+	return sb.String()
+}
+
+var secretFields = []struct {
+	name string
+	mask func(string) string
+	get  func(c *Config) *string
+}{
+	{"ProxyURL", maskURL, func(c *Config) *string { return &c.ProxyURL }},
+	{"ClusterNodes", maskClusterNodes, func(c *Config) *string { return &c.ClusterNodes }},
+	{"BackupLocation", maskURL, func(c *Config) *string { return &c.BackupLocation }},
+	{"SubtitlesAPIKey", maskValue, func(c *Config) *string { return &c.SubtitlesAPIKey }},
+	{"SearchOverrides", maskFetchOverrides, func(c *Config) *string { return &c.SearchOverrides }},
+	{"Notifications", maskNotifications, func(c *Config) *string { return &c.Notifications }},
+	{"MQTTBroker", maskURL, func(c *Config) *string { return &c.MQTTBroker }},
+	{"IMAPMailbox", maskURL, func(c *Config) *string { return &c.IMAPMailbox }},
+	{"RemoteImports", maskRemoteImports, func(c *Config) *string { return &c.RemoteImports }},
+	{"MetricsPush", maskURL, func(c *Config) *string { return &c.MetricsPush }},
+}
+
+// Masked returns a copy of the config with the secret values hidden,
+// for the web UI and logs. Secret references are kept as is.
+func (c Config) Masked() Config {
+	for _, f := range secretFields {
+		v := f.get(&c)
+		*v = f.mask(*v)
+	}
+	return c
+}
+
+// Unmask restores the secret values which come back masked from the web UI
+func (c *Config) Unmask(old *Config) {
+	for _, f := range secretFields {
+		v, ov := f.get(c), f.get(old)
+		if *v != *ov && *v == f.mask(*ov) {
+			*v = *ov
+		}
+	}
+}
+
+// isSecretField tells if the values of the field should not be logged
+func isSecretField(name string) bool {
+	for _, f := range secretFields {
+		if f.name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,258 @@
+package engine
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// in the cache dir
+	sharesFile      = "shares.json"
+	defaultShareTTL = 24 * time.Hour
+	maxShareTTL     = 30 * 24 * time.Hour
+)
+
+var (
+	ErrShareNotFound   = errors.New("Sharing link not found or expired")
+	ErrSharePassword   = errors.New("Wrong password of the sharing link")
+	ErrShareIncomplete = errors.New("The task of the file isn't completed")
+	ErrInvalidShare    = errors.New("Invalid sharing link")
+)
+
+// ShareLink is a public link to a file or a dir under the download
+// directory, expiring
+type ShareLink struct {
+	Token string
+	// relative to the DownloadDirectory
+	Path      string
+	IsDir     bool
+	Protected bool
+	// the download rate limit, in the format of the UploadRate, empty for none
+	Rate      string `json:",omitempty"`
+	Downloads int
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// shareRecord is a ShareLink as saved
+type shareRecord struct {
+	ShareLink
+	Salt     string `json:",omitempty"`
+	PassHash string `json:",omitempty"`
+}
+
+type shareState struct {
+	sync.Mutex
+	// token -> link, loaded from the cache dir on first use
+	links map[string]*shareRecord
+}
+
+func (e *Engine) sharesFileName() string {
+	return filepath.Join(e.cacheDir, sharesFile)
+}
+
+// loadShares reads the links once, the expired ones dropped, to be called
+// with the shares locked
+func (e *Engine) loadShares() {
+	if e.shares.links != nil {
+		return
+	}
+	e.shares.links = make(map[string]*shareRecord)
+	data, err := ioutil.ReadFile(e.sharesFileName())
+	if err != nil {
+		return
+	}
+	var recs []*shareRecord
+	if err := json.Unmarshal(data, &recs); err != nil {
+		log.Println("[Share]", err)
+		return
+	}
+	now := time.Now()
+	for _, r := range recs {
+		if now.Before(r.ExpiresAt) {
+			e.shares.links[r.Token] = r
+		}
+	}
+}
+
+// resetShares forgets the links loaded, as the cache dir may change by a
+// reconfigure
+func (e *Engine) resetShares() {
+	e.shares.Lock()
+	e.shares.links = nil
+	e.shares.Unlock()
+}
+
+// saveShares writes the links, to be called with the shares locked
+func (e *Engine) saveShares() error {
+	recs := make([]*shareRecord, 0, len(e.shares.links))
+	for _, r := range e.shares.links {
+		recs = append(recs, r)
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].CreatedAt.Before(recs[j].CreatedAt) })
+	data, err := json.Marshal(recs)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(e.sharesFileName(), data, 0600)
+}
+
+func sharePassHash(salt, password string) string {
+	sum := sha256.Sum256([]byte(salt + password))
+	return base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+func randomToken(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// CreateShare makes a link to the file or dir of the path relative to the
+// DownloadDirectory, expiring after ttl (defaults to a day), protected by
+// the password and limited to the rate if not empty
+func (e *Engine) CreateShare(p string, ttl time.Duration, password, rateStr string) (*ShareLink, error) {
+	p = filepath.ToSlash(filepath.Clean(strings.TrimPrefix(p, "/")))
+	if !localRenamePath(p) {
+		return nil, fmt.Errorf("%w: the path must be under the download directory (%s)", ErrInvalidShare, p)
+	}
+	if ttl == 0 {
+		ttl = defaultShareTTL
+	}
+	if ttl < 0 || ttl > maxShareTTL {
+		return nil, fmt.Errorf("%w: the expiry must be within %s", ErrInvalidShare, maxShareTTL)
+	}
+	if rateStr != "" {
+		if _, err := rateLimiter(rateStr); err != nil {
+			return nil, fmt.Errorf("%w: rate %s", ErrInvalidShare, err)
+		}
+	}
+
+	e.RLock()
+	st, err := os.Stat(filepath.Join(e.config.DownloadDirectory, filepath.FromSlash(p)))
+	if err == nil {
+		err = e.checkShareComplete(p)
+	}
+	e.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	r := &shareRecord{ShareLink: ShareLink{
+		Token:     randomToken(18),
+		Path:      p,
+		IsDir:     st.IsDir(),
+		Protected: password != "",
+		Rate:      rateStr,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}}
+	if password != "" {
+		r.Salt = randomToken(12)
+		r.PassHash = sharePassHash(r.Salt, password)
+	}
+
+	e.shares.Lock()
+	defer e.shares.Unlock()
+	e.loadShares()
+	e.shares.links[r.Token] = r
+	if err := e.saveShares(); err != nil {
+		delete(e.shares.links, r.Token)
+		return nil, err
+	}
+	log.Printf("[Share] %s shared until %s", p, r.ExpiresAt.Format(time.RFC3339))
+	link := r.ShareLink
+	return &link, nil
+}
+
+// checkShareComplete refuses the path of a task not completed, to be called
+// with the engine locked
+func (e *Engine) checkShareComplete(p string) error {
+	for _, t := range e.ts {
+		t.Lock()
+		loaded, done, dir, name := t.Loaded, t.Done, t.dataDir(), t.diskName()
+		t.Unlock()
+		if !loaded || done || filepath.Clean(dir) != filepath.Clean(e.config.DownloadDirectory) {
+			continue
+		}
+		if p == name || strings.HasPrefix(p, name+"/") || strings.HasPrefix(name, p+"/") {
+			return ErrShareIncomplete
+		}
+	}
+	return nil
+}
+
+// Shares lists the links not expired, the latest first
+func (e *Engine) Shares() []ShareLink {
+	e.shares.Lock()
+	defer e.shares.Unlock()
+	e.loadShares()
+	now := time.Now()
+	links := []ShareLink{}
+	for tok, r := range e.shares.links {
+		if now.After(r.ExpiresAt) {
+			delete(e.shares.links, tok)
+			continue
+		}
+		links = append(links, r.ShareLink)
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].CreatedAt.After(links[j].CreatedAt) })
+	return links
+}
+
+// RevokeShare deletes the link
+func (e *Engine) RevokeShare(token string) error {
+	e.shares.Lock()
+	defer e.shares.Unlock()
+	e.loadShares()
+	if _, ok := e.shares.links[token]; !ok {
+		return ErrShareNotFound
+	}
+	delete(e.shares.links, token)
+	log.Println("[Share] revoked", token)
+	return e.saveShares()
+}
+
+// OpenShare checks the password of the link, and counts the download. It
+// returns the file or dir shared, and the limiter of its rate if any.
+func (e *Engine) OpenShare(token, password string) (ShareLink, string, *rate.Limiter, error) {
+	// the engine is locked before the shares by a reconfigure
+	e.RLock()
+	dldir := e.config.DownloadDirectory
+	e.RUnlock()
+
+	e.shares.Lock()
+	defer e.shares.Unlock()
+	e.loadShares()
+	r, ok := e.shares.links[token]
+	if !ok || time.Now().After(r.ExpiresAt) {
+		return ShareLink{}, "", nil, ErrShareNotFound
+	}
+	if r.PassHash != "" && subtle.ConstantTimeCompare([]byte(sharePassHash(r.Salt, password)), []byte(r.PassHash)) != 1 {
+		return r.ShareLink, "", nil, ErrSharePassword
+	}
+	var lim *rate.Limiter
+	if r.Rate != "" {
+		lim, _ = rateLimiter(r.Rate)
+	}
+	r.Downloads++
+	if err := e.saveShares(); err != nil {
+		log.Println("[Share]", err)
+	}
+	return r.ShareLink, filepath.Join(dldir, filepath.FromSlash(r.Path)), lim, nil
+}
@@ -0,0 +1,53 @@
+package engine
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEngine_share(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.mkv"), []byte("abc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e := &Engine{cacheDir: t.TempDir(), ts: map[string]*Torrent{}, config: Config{DownloadDirectory: dir}}
+
+	for _, p := range []string{"../a.mkv", "", "missing.mkv"} {
+		if _, err := e.CreateShare(p, 0, "", ""); err == nil {
+			t.Errorf("CreateShare(%q) no error", p)
+		}
+	}
+	if _, err := e.CreateShare("a.mkv", 31*24*time.Hour, "", ""); !errors.Is(err, ErrInvalidShare) {
+		t.Errorf("CreateShare() ttl err = %v", err)
+	}
+
+	link, err := e.CreateShare("/a.mkv", 0, "pw", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !link.Protected || link.Path != "a.mkv" {
+		t.Errorf("CreateShare() = %+v", link)
+	}
+	if _, _, _, err := e.OpenShare(link.Token, "wrong"); !errors.Is(err, ErrSharePassword) {
+		t.Errorf("OpenShare() wrong password err = %v", err)
+	}
+	_, fn, _, err := e.OpenShare(link.Token, "pw")
+	if err != nil || fn != filepath.Join(dir, "a.mkv") {
+		t.Errorf("OpenShare() = %q, %v", fn, err)
+	}
+
+	// reloaded from the cache dir
+	e.resetShares()
+	if ls := e.Shares(); len(ls) != 1 || ls[0].Downloads != 1 {
+		t.Errorf("Shares() = %+v", ls)
+	}
+	if err := e.RevokeShare(link.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := e.OpenShare(link.Token, "pw"); !errors.Is(err, ErrShareNotFound) {
+		t.Errorf("OpenShare() revoked err = %v", err)
+	}
+}
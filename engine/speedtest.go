@@ -0,0 +1,292 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/storage"
+	"github.com/dustin/go-humanize"
+	"golang.org/x/time/rate"
+)
+
+const (
+	speedTestTick        = time.Second
+	minSpeedTestDuration = 10 * time.Second
+	maxSpeedTestDuration = 10 * time.Minute
+	// written to the DownloadDirectory to measure the disk
+	speedTestDiskBytes = 64 << 20
+	// fewer peers than this is a poorly seeded swarm, or an unreachable port
+	speedTestFewPeers = 5
+)
+
+var (
+	ErrNoSpeedTest      = errors.New("No SpeedTestMagnet configured")
+	ErrSpeedTestRunning = errors.New("Speed test already running")
+)
+
+// SpeedTestResult is the last speed test, the configured torrent downloaded
+// to a temp dir for the SpeedTestDuration, reported by GET /api/speedtest
+type SpeedTestResult struct {
+	Running bool
+	// metadata, download or disk while running
+	Stage    string `json:",omitempty"`
+	Started  time.Time
+	Finished time.Time
+	Name     string
+	// bytes, and bytes per second
+	Downloaded int64
+	Rate       float64
+	PeakRate   float64
+	DiskRate   float64
+	// the download limit in effect, 0 for unlimited
+	Limit float64
+	Peers int
+	Seeds int
+	// the tasks downloading alongside, sharing the link and the limit
+	OtherDownloads int
+	// limiter, disk, swarm or network, the first of the hints
+	Bottleneck string   `json:",omitempty"`
+	Hints      []string `json:",omitempty"`
+	Error      string   `json:",omitempty"`
+}
+
+type speedTestState struct {
+	sync.Mutex
+	result SpeedTestResult
+	// closed to end the test early
+	cancel chan struct{}
+}
+
+// SpeedTest reports the speed test running or the last one
+func (e *Engine) SpeedTest() SpeedTestResult {
+	e.speedTest.Lock()
+	defer e.speedTest.Unlock()
+	return e.speedTest.result
+}
+
+// StartSpeedTest downloads the SpeedTestMagnet to a temp dir, the data
+// discarded once the test is over
+func (e *Engine) StartSpeedTest() error {
+	e.RLock()
+	client := e.client
+	c := e.config
+	closing := e.closeSync
+	e.RUnlock()
+	if c.SpeedTestMagnet == "" {
+		return ErrNoSpeedTest
+	}
+	if client == nil {
+		return errors.New("engine not configured")
+	}
+	magnetURI, err := normalizeMagnet(c.SpeedTestMagnet)
+	if err != nil {
+		return err
+	}
+	spec, err := torrent.TorrentSpecFromMagnetUri(magnetURI)
+	if err != nil {
+		return err
+	}
+	if e.isTaskInList(spec.InfoHash.HexString()) {
+		return ErrTaskExists
+	}
+	if len(spec.Trackers) == 0 || c.AlwaysAddTrackers {
+		if trackers := e.GetTrackers(); len(trackers) > 0 {
+			spec.Trackers = append(spec.Trackers, trackers)
+		}
+	}
+
+	e.speedTest.Lock()
+	defer e.speedTest.Unlock()
+	if e.speedTest.result.Running {
+		return ErrSpeedTestRunning
+	}
+	cancel := make(chan struct{})
+	e.speedTest.cancel = cancel
+	e.speedTest.result = SpeedTestResult{Running: true, Stage: "metadata", Started: time.Now(), Name: spec.DisplayName}
+	log.Println("[SpeedTest] started", spec.InfoHash.HexString())
+	go func() {
+		res := e.runSpeedTest(client, spec, c, cancel, closing)
+		res.Finished = time.Now()
+		log.Printf("[SpeedTest] %s/s average, %s/s peak, %s", humanize.Bytes(uint64(res.Rate)), humanize.Bytes(uint64(res.PeakRate)), res.Bottleneck)
+		e.speedTest.Lock()
+		e.speedTest.result = res
+		e.speedTest.Unlock()
+	}()
+	return nil
+}
+
+// CancelSpeedTest ends the speed test running, reporting what it measured
+func (e *Engine) CancelSpeedTest() {
+	e.speedTest.Lock()
+	defer e.speedTest.Unlock()
+	if !e.speedTest.result.Running {
+		return
+	}
+	select {
+	case <-e.speedTest.cancel:
+	default:
+		close(e.speedTest.cancel)
+	}
+}
+
+func (e *Engine) speedTestStage(stage string) {
+	e.speedTest.Lock()
+	e.speedTest.result.Stage = stage
+	e.speedTest.Unlock()
+}
+
+func (e *Engine) runSpeedTest(client *torrent.Client, spec *torrent.TorrentSpec, c Config, cancel, closing <-chan struct{}) SpeedTestResult {
+	e.speedTest.Lock()
+	res := e.speedTest.result
+	e.speedTest.Unlock()
+	res.Running, res.Stage = false, ""
+
+	dir, err := ioutil.TempDir("", "simple-torrent-speedtest")
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	defer os.RemoveAll(dir)
+	st := storage.NewFileWithCompletion(dir, storage.NewMapPieceCompletion())
+	defer st.Close()
+	spec.Storage = st
+
+	e.previews.stop(spec.InfoHash.HexString())
+	tt, isNew, err := client.AddTorrentSpec(spec)
+	if err == nil && !isNew {
+		err = ErrTaskExists
+	}
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	defer tt.Drop()
+
+	deadline := time.After(c.SpeedTestDuration)
+	select {
+	case <-tt.GotInfo():
+	case <-deadline:
+		res.Error = "metadata not resolved in time, the torrent is not seeded"
+	case <-cancel:
+		res.Error = "canceled"
+	case <-closing:
+		res.Error = "engine reconfigured"
+	}
+	if res.Error != "" {
+		return res
+	}
+	res.Name = tt.Name()
+	e.speedTestStage("download")
+	tt.DownloadAll()
+
+	tk := time.NewTicker(speedTestTick)
+	defer tk.Stop()
+	start, last, lastAt := time.Now(), int64(0), time.Now()
+sampling:
+	for tt.BytesMissing() > 0 {
+		select {
+		case now := <-tk.C:
+			ts := tt.Stats()
+			n := ts.BytesReadUsefulData.Int64()
+			if r := float64(n-last) / now.Sub(lastAt).Seconds(); r > res.PeakRate {
+				res.PeakRate = r
+			}
+			last, lastAt = n, now
+			if ts.ActivePeers > res.Peers {
+				res.Peers = ts.ActivePeers
+			}
+			if ts.ConnectedSeeders > res.Seeds {
+				res.Seeds = ts.ConnectedSeeders
+			}
+			e.speedTest.Lock()
+			e.speedTest.result.Downloaded, e.speedTest.result.PeakRate = n, res.PeakRate
+			e.speedTest.Unlock()
+		case <-deadline:
+			break sampling
+		case <-cancel:
+			break sampling
+		case <-closing:
+			res.Error = "engine reconfigured"
+			return res
+		}
+	}
+	ts := tt.Stats()
+	res.Downloaded = ts.BytesReadUsefulData.Int64()
+	res.Rate = float64(res.Downloaded) / time.Since(start).Seconds()
+	for _, t := range client.Torrents() {
+		if t != tt && t.Info() != nil && t.BytesMissing() > 0 {
+			res.OtherDownloads++
+		}
+	}
+	tt.Drop()
+
+	e.speedTestStage("disk")
+	e.RLock()
+	if l := e.downloadLimit(); l != rate.Inf {
+		res.Limit = float64(l)
+	}
+	e.RUnlock()
+	diskRate, err := diskWriteRate(c.DownloadDirectory, speedTestDiskBytes)
+	if err != nil {
+		res.Hints = append(res.Hints, fmt.Sprintf("disk not measured: %s", err))
+	}
+	res.DiskRate = diskRate
+	res.Bottleneck, res.Hints = speedTestHints(res, res.Hints)
+	return res
+}
+
+// diskWriteRate writes size bytes to a temp file of the dir, synced, and
+// is the bytes written per second
+func diskWriteRate(dir string, size int) (float64, error) {
+	f, err := ioutil.TempFile(dir, ".speedtest")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	buf := make([]byte, 1<<20)
+	start := time.Now()
+	for n := 0; n < size; n += len(buf) {
+		if _, err := f.Write(buf); err != nil {
+			return 0, err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return 0, err
+	}
+	return float64(size) / time.Since(start).Seconds(), nil
+}
+
+// speedTestHints tells what held the download back, the bottleneck first
+func speedTestHints(r SpeedTestResult, hints []string) (string, []string) {
+	bottleneck := ""
+	hint := func(b, format string, args ...interface{}) {
+		if bottleneck == "" {
+			bottleneck = b
+		}
+		hints = append(hints, fmt.Sprintf(format, args...))
+	}
+	perSec := func(f float64) string { return humanize.Bytes(uint64(f)) + "/s" }
+
+	if r.Limit > 0 && r.PeakRate >= 0.9*r.Limit {
+		hint("limiter", "the download is capped at %s by the DownloadRate or its override", perSec(r.Limit))
+	}
+	if r.DiskRate > 0 && r.DiskRate < 1.25*r.PeakRate {
+		hint("disk", "the disk of the DownloadDirectory writes %s, close to the %s peak download", perSec(r.DiskRate), perSec(r.PeakRate))
+	}
+	if r.Peers < speedTestFewPeers {
+		hint("swarm", "only %d peers connected, the incoming port may be unreachable or the torrent poorly seeded", r.Peers)
+	}
+	if r.OtherDownloads > 0 {
+		hint("network", "%d tasks downloading alongside shared the link", r.OtherDownloads)
+	}
+	if bottleneck == "" {
+		hint("network", "the download is bound by the link or the peers: %s average, %s peak", perSec(r.Rate), perSec(r.PeakRate))
+	}
+	return bottleneck, hints
+}
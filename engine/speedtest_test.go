@@ -0,0 +1,47 @@
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test_speedTestHints(t *testing.T) {
+	const mb = 1 << 20
+	tests := []struct {
+		name  string
+		r     SpeedTestResult
+		want  string
+		hints int
+	}{
+		{"limited", SpeedTestResult{Rate: 0.9 * mb, PeakRate: mb, Limit: mb, DiskRate: 100 * mb, Peers: 20}, "limiter", 1},
+		{"slow disk", SpeedTestResult{Rate: 8 * mb, PeakRate: 10 * mb, DiskRate: 11 * mb, Peers: 20}, "disk", 1},
+		{"few peers", SpeedTestResult{Rate: mb, PeakRate: mb, DiskRate: 100 * mb, Peers: 2}, "swarm", 1},
+		{"limited and few peers", SpeedTestResult{PeakRate: mb, Limit: mb, Peers: 1}, "limiter", 2},
+		{"network", SpeedTestResult{Rate: 5 * mb, PeakRate: 6 * mb, DiskRate: 100 * mb, Peers: 30}, "network", 1},
+		{"sharing", SpeedTestResult{Rate: 5 * mb, PeakRate: 6 * mb, Peers: 30, OtherDownloads: 2}, "network", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, hints := speedTestHints(tt.r, nil)
+			if got != tt.want || len(hints) != tt.hints {
+				t.Errorf("speedTestHints() = %s, %q, want %s with %d hints", got, hints, tt.want, tt.hints)
+			}
+		})
+	}
+}
+
+func Test_diskWriteRate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "speedtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r, err := diskWriteRate(dir, 4<<20)
+	if err != nil || r <= 0 {
+		t.Fatalf("diskWriteRate() = %v, %v", r, err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("diskWriteRate() left %d files", len(files))
+	}
+}
@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+// stalledRoutine marks a downloading task as stalled when there's no
+// progress within StalledTimeout
+func (e *Engine) stalledRoutine(t *Torrent) {
+	timeout := e.config.StalledTimeout
+
+	t.Lock()
+	defer t.Unlock()
+
+	if timeout <= 0 || !t.Started || t.Done {
+		t.IsStalled = false
+		t.lastProgressAt = time.Time{}
+		return
+	}
+
+	if t.lastProgressAt.IsZero() || t.Downloaded != t.lastDownloaded {
+		if t.IsStalled {
+			log.Println("[Stalled] task recovered", t.InfoHash)
+		}
+		t.IsStalled = false
+		t.lastDownloaded = t.Downloaded
+		t.lastProgressAt = time.Now()
+		return
+	}
+
+	if t.IsStalled || time.Since(t.lastProgressAt) < timeout {
+		return
+	}
+
+	t.IsStalled = true
+	log.Printf("[Stalled]%s no progress for %s", t.InfoHash, timeout)
+	t.e.notify(EventStalled, t, "")
+	if e.config.StalledReannounce {
+		go e.reannounce(t.t)
+	}
+	if e.config.StalledCallCmd {
+		go t.callDoneCmd(t.diskName(), "stalled", t.Size, t.doneCmdTask())
+	}
+}
+
+// reannounce announces the task to DHT again, looking for more peers
+func (e *Engine) reannounce(tt *torrent.Torrent) {
+	e.RLock()
+	client := e.client
+	e.RUnlock()
+	if client == nil {
+		return
+	}
+
+	for _, s := range client.DhtServers() {
+		done, stop, err := tt.AnnounceToDht(s)
+		if err != nil {
+			log.Println("[Reannounce]", tt.InfoHash().HexString(), err)
+			continue
+		}
+		select {
+		case <-done:
+		case <-tt.Closed():
+		case <-time.After(5 * time.Minute):
+		}
+		stop()
+	}
+}
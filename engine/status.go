@@ -0,0 +1,151 @@
+package engine
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/boypt/simple-torrent/plugin"
+)
+
+// TaskStatus is the state of a task, derived in one place from its flags
+// by updateStatus
+type TaskStatus string
+
+const (
+	// waiting for a slot of the MaxConcurrentTask, or the task it's after
+	StatusQueued TaskStatus = "queued"
+	// its pieces hashed, by a verify or when added
+	StatusChecking    TaskStatus = "checking"
+	StatusDownloading TaskStatus = "downloading"
+	StatusSeeding     TaskStatus = "seeding"
+	StatusPaused      TaskStatus = "paused"
+	StatusStalled     TaskStatus = "stalled"
+	StatusError       TaskStatus = "error"
+	// its files going to the FinishedDirectory
+	StatusMoving TaskStatus = "moving"
+
+	// the transitions of the statuses, to the plugins, the event feed and
+	// MQTT but not the Notifications, the status is the message
+	EventStatus = "status"
+)
+
+var ErrInvalidStatus = errors.New("Invalid task status")
+
+var TaskStatuses = []TaskStatus{StatusQueued, StatusChecking, StatusDownloading, StatusSeeding,
+	StatusPaused, StatusStalled, StatusError, StatusMoving}
+
+// ParseTaskStatuses reads the comma separated statuses, nil for all
+func ParseTaskStatuses(s string) (map[TaskStatus]bool, error) {
+	if s == "" {
+		return nil, nil
+	}
+	res := make(map[TaskStatus]bool)
+	for _, st := range strings.Split(s, ",") {
+		st = strings.TrimSpace(st)
+		if !knownStatus(TaskStatus(st)) {
+			return nil, ErrInvalidStatus
+		}
+		res[TaskStatus(st)] = true
+	}
+	return res, nil
+}
+
+func knownStatus(s TaskStatus) bool {
+	for _, k := range TaskStatuses {
+		if k == s {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusCounts is the count of the tasks by status
+func (e *Engine) StatusCounts() map[TaskStatus]int {
+	return e.mqttStatus().Statuses
+}
+
+// deriveStatus is the status by the flags, the first matching of moving,
+// checking, error, queued, paused, seeding, stalled and downloading
+func (t *Torrent) deriveStatus() TaskStatus {
+	switch {
+	case t.moving:
+		return StatusMoving
+	case t.checking || (t.t != nil && t.Started && piecesChecking(t.t)):
+		return StatusChecking
+	case t.Error != "":
+		return StatusError
+	case t.IsQueueing:
+		return StatusQueued
+	case !t.Started:
+		return StatusPaused
+	case t.Done:
+		return StatusSeeding
+	case t.IsStalled:
+		return StatusStalled
+	}
+	return StatusDownloading
+}
+
+// piecesChecking tells whether the client hashes some pieces of the task
+func piecesChecking(tt *torrent.Torrent) bool {
+	if tt.Info() == nil {
+		return false
+	}
+	for _, r := range tt.PieceStateRuns() {
+		if r.Checking {
+			return true
+		}
+	}
+	return false
+}
+
+// updateStatus moves the task to the status of its flags, with t locked,
+// returning the previous one if changed
+func (t *Torrent) updateStatus() (TaskStatus, bool) {
+	st := t.deriveStatus()
+	if st == t.Status {
+		return "", false
+	}
+	from := t.Status
+	t.Status, t.StatusSince = st, time.Now()
+	return from, true
+}
+
+// refreshStatus updates the status, publishing the transition
+func (t *Torrent) refreshStatus() {
+	t.Lock()
+	from, changed := t.updateStatus()
+	to := t.Status
+	t.Unlock()
+	if !changed || from == "" || t.e == nil {
+		return
+	}
+	log.Printf("[Status]%s %s -> %s", t.InfoHash, from, to)
+	t.e.publishEvent(plugin.Event{
+		Type:     EventStatus,
+		InfoHash: t.InfoHash,
+		Name:     t.Name,
+		Size:     t.Size,
+		Labels:   t.Labels,
+		Message:  string(to),
+		Time:     time.Now(),
+	})
+}
+
+// setChecking marks the task hashing its pieces, or done with it
+func (t *Torrent) setChecking(on bool) {
+	t.Lock()
+	t.checking = on
+	t.Unlock()
+	t.refreshStatus()
+}
+
+// setMoving marks the task moving its files, or done with it
+func (t *Torrent) setMoving(on bool) {
+	t.Lock()
+	t.moving = on
+	t.Unlock()
+	t.refreshStatus()
+}
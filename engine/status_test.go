@@ -0,0 +1,56 @@
+package engine
+
+import "testing"
+
+func TestDeriveStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		t    *Torrent
+		want TaskStatus
+	}{
+		{"added", &Torrent{}, StatusPaused},
+		{"queued", &Torrent{IsQueueing: true}, StatusQueued},
+		{"downloading", &Torrent{Started: true}, StatusDownloading},
+		{"stalled", &Torrent{Started: true, IsStalled: true}, StatusStalled},
+		{"seeding", &Torrent{Started: true, Done: true}, StatusSeeding},
+		{"stopped done", &Torrent{Done: true}, StatusPaused},
+		{"error", &Torrent{Started: true, Error: "tracker down"}, StatusError},
+		{"checking", &Torrent{Started: true, Error: "x", checking: true}, StatusChecking},
+		{"moving", &Torrent{Done: true, checking: true, moving: true}, StatusMoving},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.t.deriveStatus(); got != tt.want {
+				t.Errorf("deriveStatus() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateStatus(t *testing.T) {
+	tr := &Torrent{}
+	if from, changed := tr.updateStatus(); !changed || from != "" || tr.Status != StatusPaused {
+		t.Errorf("first update = %q %v %s", from, changed, tr.Status)
+	}
+	since := tr.StatusSince
+	if _, changed := tr.updateStatus(); changed || !tr.StatusSince.Equal(since) {
+		t.Error("unchanged status updated")
+	}
+	tr.Started = true
+	if from, changed := tr.updateStatus(); !changed || from != StatusPaused || tr.Status != StatusDownloading {
+		t.Errorf("start = %q %v %s", from, changed, tr.Status)
+	}
+}
+
+func TestParseTaskStatuses(t *testing.T) {
+	if got, err := ParseTaskStatuses(""); got != nil || err != nil {
+		t.Errorf("empty = %v, %v", got, err)
+	}
+	got, err := ParseTaskStatuses("seeding, paused")
+	if err != nil || len(got) != 2 || !got[StatusSeeding] || !got[StatusPaused] {
+		t.Errorf("seeding,paused = %v, %v", got, err)
+	}
+	if _, err := ParseTaskStatuses("seeding,done"); err != ErrInvalidStatus {
+		t.Errorf("done err = %v", err)
+	}
+}
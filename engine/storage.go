@@ -0,0 +1,167 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+// fileOwner is the ownership and permission applied to the downloaded files,
+// so that files created in containers are not root-owned on bind mounts
+type fileOwner struct {
+	uid, gid int
+	umask    os.FileMode
+	hasMask  bool
+}
+
+func newFileOwner(c *Config) (*fileOwner, error) {
+	o := &fileOwner{uid: c.FileUID, gid: c.FileGID}
+	if c.Umask != "" {
+		m, err := strconv.ParseUint(c.Umask, 8, 32)
+		if err != nil || m > 0777 {
+			return nil, fmt.Errorf("Invalid umask (%s)", c.Umask)
+		}
+		o.umask = os.FileMode(m)
+		o.hasMask = true
+	}
+	if o.uid < 0 && o.gid < 0 && !o.hasMask {
+		return nil, nil
+	}
+	return o, nil
+}
+
+func (o *fileOwner) apply(path string, isDir bool) {
+	if o.uid >= 0 || o.gid >= 0 {
+		if err := os.Lchown(path, o.uid, o.gid); err != nil {
+			log.Println("[storage] chown", err)
+		}
+	}
+	if o.hasMask {
+		mode := os.FileMode(0666)
+		if isDir {
+			mode = os.ModePerm
+		}
+		if err := os.Chmod(path, mode&^o.umask); err != nil {
+			log.Println("[storage] chmod", err)
+		}
+	}
+}
+
+// ownedStorage wraps a storage, fixing up the owner and mode of the files
+// when they are created
+type ownedStorage struct {
+	storage.ClientImpl
+	owner   *fileOwner
+	baseDir string
+}
+
+func wrapStorage(st storage.ClientImpl, baseDir string, o *fileOwner) storage.ClientImpl {
+	if o == nil {
+		return st
+	}
+	return &ownedStorage{ClientImpl: st, owner: o, baseDir: baseDir}
+}
+
+func (s *ownedStorage) OpenTorrent(info *metainfo.Info, ih metainfo.Hash) (storage.TorrentImpl, error) {
+	ti, err := s.ClientImpl.OpenTorrent(info, ih)
+	if err != nil {
+		return ti, err
+	}
+
+	ot := &ownedTorrent{
+		owner:   s.owner,
+		baseDir: s.baseDir,
+		fixed:   make(map[string]bool),
+	}
+	var off int64
+	for _, fi := range info.UpvertedFiles() {
+		p := strings.Join(append([]string{info.Name}, fi.Path...), "/")
+		if rs, ok := s.ClientImpl.(*remapStorage); ok {
+			p = rs.e.renamedPath(ih.HexString(), p)
+		}
+		ot.files = append(ot.files, ownedFile{
+			path:   filepath.Join(s.baseDir, filepath.FromSlash(p)),
+			offset: off,
+			length: fi.Length,
+		})
+		off += fi.Length
+	}
+	// storages like mmap create all the files on open
+	for i := range ot.files {
+		ot.fix(i, true)
+	}
+
+	piece := ti.Piece
+	ti.Piece = func(p metainfo.Piece) storage.PieceImpl {
+		return &ownedPiece{PieceImpl: piece(p), t: ot, p: p}
+	}
+	return ti, nil
+}
+
+type ownedFile struct {
+	path   string
+	offset int64
+	length int64
+}
+
+type ownedTorrent struct {
+	sync.Mutex
+	owner   *fileOwner
+	baseDir string
+	files   []ownedFile
+	fixed   map[string]bool
+}
+
+// fix applies the owner to the file and its parent dirs under the base dir, once
+func (t *ownedTorrent) fix(i int, onlyExisting bool) {
+	t.Lock()
+	defer t.Unlock()
+	f := t.files[i]
+	if t.fixed[f.path] {
+		return
+	}
+	if _, err := os.Lstat(f.path); err != nil {
+		if !onlyExisting {
+			log.Println("[storage]", err)
+		}
+		return
+	}
+	t.fixed[f.path] = true
+	t.owner.apply(f.path, false)
+	for dir := filepath.Dir(f.path); dir != t.baseDir && len(dir) > len(t.baseDir); dir = filepath.Dir(dir) {
+		if t.fixed[dir] {
+			break
+		}
+		t.fixed[dir] = true
+		t.owner.apply(dir, true)
+	}
+}
+
+type ownedPiece struct {
+	storage.PieceImpl
+	t *ownedTorrent
+	p metainfo.Piece
+}
+
+func (p *ownedPiece) WriteAt(b []byte, off int64) (int, error) {
+	n, err := p.PieceImpl.WriteAt(b, off)
+	if n > 0 {
+		start := p.p.Offset() + off
+		end := start + int64(n)
+		files := p.t.files
+		i := sort.Search(len(files), func(i int) bool {
+			return files[i].offset+files[i].length > start
+		})
+		for ; i < len(files) && files[i].offset < end; i++ {
+			p.t.fix(i, false)
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/anacrolix/torrent"
+)
+
+var ErrNotLoaded = errors.New("Task info not loaded yet")
+
+// StreamReader reads a file of a task while it's downloading, the reads
+// block until the data arrives
+type StreamReader struct {
+	torrent.Reader
+	Name   string
+	Length int64
+
+	f      *torrent.File
+	radius int
+}
+
+// Seek raises the priority of the pieces around the new position, so the
+// playback resumes quickly after a seek
+func (sr *StreamReader) Seek(off int64, whence int) (int64, error) {
+	pos, err := sr.Reader.Seek(off, whence)
+	if err != nil || sr.radius <= 0 {
+		return pos, err
+	}
+	t := sr.f.Torrent()
+	pieceLen := t.Info().PieceLength
+	first := int(sr.f.Offset() / pieceLen)
+	last := int((sr.f.Offset() + sr.f.Length() - 1) / pieceLen)
+	cur := int((sr.f.Offset() + pos) / pieceLen)
+	begin, end := cur-sr.radius, cur+sr.radius
+	if begin < first {
+		begin = first
+	}
+	if end > last {
+		end = last
+	}
+	for i := begin; i <= end; i++ {
+		t.Piece(i).SetPriority(torrent.PiecePriorityHigh)
+	}
+	return pos, nil
+}
+
+var _ io.ReadSeekCloser = (*StreamReader)(nil)
+
+// StreamFile opens a file of a task for streaming. The readahead window
+// starts at StreamReadahead MB and grows with the contiguous reading up to
+// 4 times of it, for high bitrate content on slow swarms.
+func (e *Engine) StreamFile(infohash, path string) (*StreamReader, error) {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	t.Lock()
+	tt := t.t
+	t.Unlock()
+	if tt == nil || tt.Info() == nil {
+		return nil, ErrNotLoaded
+	}
+
+	for _, f := range tt.Files() {
+		if e.renamedPath(infohash, f.Path()) != path {
+			continue
+		}
+		base := int64(e.config.StreamReadahead) << 20
+		r := f.NewReader()
+		r.SetResponsive()
+		if base > 0 {
+			r.SetReadaheadFunc(func(rc torrent.ReadaheadContext) int64 {
+				ra := base + (rc.CurrentPos-rc.ContiguousReadStartPos)/4
+				if ra > 4*base {
+					ra = 4 * base
+				}
+				return ra
+			})
+		}
+		return &StreamReader{
+			Reader: r,
+			Name:   f.DisplayPath(),
+			Length: f.Length(),
+			f:      f,
+			radius: e.config.StreamPriorityRadius,
+		}, nil
+	}
+	return nil, fmt.Errorf("Missing file %s", path)
+}
@@ -0,0 +1,207 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	openSubtitlesAPI = "https://api.opensubtitles.com/api/v1"
+	openSubtitlesUA  = "SimpleTorrent v1.0"
+	// smaller videos are samples or extras
+	subtitlesMinSize = 50 << 20
+)
+
+var subtitlesClient = &http.Client{Timeout: time.Minute}
+
+var videoExts = map[string]bool{
+	".mkv": true, ".mp4": true, ".avi": true, ".m4v": true,
+	".mov": true, ".wmv": true, ".ts": true, ".webm": true,
+}
+
+// movieHash is the OpenSubtitles hash: the size plus the 64bit little-endian
+// sums of the first and the last 64KB of the file
+func movieHash(r io.ReaderAt, size int64) (string, error) {
+	const chunk = 64 << 10
+	if size < chunk {
+		return "", fmt.Errorf("file too small")
+	}
+	hash := uint64(size)
+	buf := make([]byte, chunk)
+	for _, off := range []int64{0, size - chunk} {
+		if _, err := r.ReadAt(buf, off); err != nil {
+			return "", err
+		}
+		for i := 0; i < chunk; i += 8 {
+			hash += binary.LittleEndian.Uint64(buf[i:])
+		}
+	}
+	return fmt.Sprintf("%016x", hash), nil
+}
+
+type subtitleResult struct {
+	Attributes struct {
+		Language       string `json:"language"`
+		MovieHashMatch bool   `json:"moviehash_match"`
+		DownloadCount  int    `json:"download_count"`
+		Files          []struct {
+			FileID int `json:"file_id"`
+		} `json:"files"`
+	} `json:"attributes"`
+}
+
+func openSubtitlesRequest(apiKey, method, path string, body interface{}, out interface{}) error {
+	var rd io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rd = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, openSubtitlesAPI+path, rd)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Api-Key", apiKey)
+	req.Header.Set("User-Agent", openSubtitlesUA)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := subtitlesClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("opensubtitles %s: %s %s", path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// bestSubtitles picks a subtitle file id per language, the hash matched
+// ones first, then the most downloaded
+func bestSubtitles(results []subtitleResult) map[string]int {
+	best := map[string]subtitleResult{}
+	for _, r := range results {
+		a := r.Attributes
+		if len(a.Files) == 0 {
+			continue
+		}
+		cur, ok := best[a.Language]
+		if !ok || (a.MovieHashMatch && !cur.Attributes.MovieHashMatch) ||
+			(a.MovieHashMatch == cur.Attributes.MovieHashMatch && a.DownloadCount > cur.Attributes.DownloadCount) {
+			best[a.Language] = r
+		}
+	}
+	ids := map[string]int{}
+	for lang, r := range best {
+		ids[lang] = r.Attributes.Files[0].FileID
+	}
+	return ids
+}
+
+// fetchSubtitles saves the subtitles of a video file as <name>.<lang>.srt,
+// returns the languages found
+func fetchSubtitles(apiKey, file string, langs []string) ([]string, error) {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	q := url.Values{}
+	q.Set("languages", strings.Join(langs, ","))
+	q.Set("query", strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)))
+	if f, err := os.Open(file); err == nil {
+		if h, err := movieHash(f, fi.Size()); err == nil {
+			q.Set("moviehash", h)
+		}
+		f.Close()
+	}
+
+	var search struct {
+		Data []subtitleResult `json:"data"`
+	}
+	if err := openSubtitlesRequest(apiKey, "GET", "/subtitles?"+q.Encode(), nil, &search); err != nil {
+		return nil, err
+	}
+
+	base := strings.TrimSuffix(file, filepath.Ext(file))
+	var got []string
+	for lang, id := range bestSubtitles(search.Data) {
+		dst := fmt.Sprintf("%s.%s.srt", base, lang)
+		if _, err := os.Stat(dst); err == nil {
+			got = append(got, lang)
+			continue
+		}
+		var dl struct {
+			Link string `json:"link"`
+		}
+		if err := openSubtitlesRequest(apiKey, "POST", "/download", map[string]int{"file_id": id}, &dl); err != nil {
+			return got, err
+		}
+		resp, err := subtitlesClient.Get(dl.Link)
+		if err != nil {
+			return got, err
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 8<<20))
+		resp.Body.Close()
+		if err != nil {
+			return got, err
+		}
+		if err := ioutil.WriteFile(dst, data, 0644); err != nil {
+			return got, err
+		}
+		got = append(got, lang)
+	}
+	return got, nil
+}
+
+// fetchSubtitles downloads the subtitles of the video files in a completed task
+func (t *Torrent) fetchSubtitles() {
+	c := t.e.config
+	apiKey, err := ResolveSecrets(c.SubtitlesAPIKey)
+	if err != nil || apiKey == "" {
+		log.Println("[Subtitles]", t.InfoHash, err)
+		return
+	}
+	var langs []string
+	for _, l := range strings.Split(c.SubtitlesLanguages, ",") {
+		if l = strings.TrimSpace(l); l != "" {
+			langs = append(langs, l)
+		}
+	}
+	if len(langs) == 0 {
+		langs = []string{"en"}
+	}
+
+	t.Lock()
+	files := append([]*File(nil), t.Files...)
+	t.Unlock()
+	for _, f := range files {
+		if f == nil || f.Size < subtitlesMinSize || !videoExts[strings.ToLower(filepath.Ext(f.Path))] {
+			continue
+		}
+		got, err := fetchSubtitles(apiKey, filepath.Join(t.dataDir(), filepath.FromSlash(f.Path)), langs)
+		if err != nil {
+			log.Println("[Subtitles]", f.Path, err)
+		}
+		if len(got) > 0 {
+			log.Println("[Subtitles]", f.Path, got)
+			t.Lock()
+			f.Subtitles = got
+			t.Unlock()
+		}
+	}
+}
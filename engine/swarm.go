@@ -0,0 +1,124 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	taskSwarmExt = ".swarm"
+	// 2 days of the default 30m interval
+	maxSwarmHistory = 96
+	swarmScrapeWait = 30 * time.Second
+)
+
+// SwarmSample is a tracker scrape of the task at a time
+type SwarmSample struct {
+	At       time.Time
+	Seeders  int
+	Leechers int
+}
+
+// swarmRoutine scrapes the trackers of a started task every ScrapeInterval,
+// the swarm size is independent of the peers connected
+func (e *Engine) swarmRoutine(t *Torrent) {
+	itv := e.config.ScrapeInterval
+	t.Lock()
+	defer t.Unlock()
+	if itv <= 0 || !t.Started || t.t == nil || t.scraping || time.Since(t.scrapedAt) < itv {
+		return
+	}
+	t.scraping = true
+	go t.scrapeSwarm()
+}
+
+func (t *Torrent) scrapeSwarm() {
+	mi := t.t.Metainfo()
+	trackers := mi.UpvertedAnnounceList().DistinctValues()
+
+	ctx, cancel := context.WithTimeout(context.Background(), swarmScrapeWait)
+	defer cancel()
+	st, ok := ScrapeTrackers(ctx, trackers, []string{t.InfoHash})[t.InfoHash]
+
+	t.Lock()
+	defer t.Unlock()
+	t.scraping = false
+	t.scrapedAt = time.Now()
+	if !ok {
+		return
+	}
+	t.Swarm = st
+	t.SwarmHistory = appendSwarmSample(t.SwarmHistory, SwarmSample{
+		At:       t.scrapedAt,
+		Seeders:  st.Seeders,
+		Leechers: st.Leechers,
+	})
+	if err := t.e.saveSwarmHistory(t.InfoHash, t.SwarmHistory); err != nil {
+		log.Println("[Swarm]", t.InfoHash, err)
+	}
+}
+
+// appendSwarmSample keeps the last maxSwarmHistory samples
+func appendSwarmSample(history []SwarmSample, s SwarmSample) []SwarmSample {
+	history = append(history, s)
+	if len(history) > maxSwarmHistory {
+		history = append([]SwarmSample(nil), history[len(history)-maxSwarmHistory:]...)
+	}
+	return history
+}
+
+// swarmFileName is saved in the cache dir next to the task's torrent file,
+// the scrape history as json
+func (e *Engine) swarmFileName(infohash string) string {
+	return filepath.Join(e.cacheDir, fmt.Sprintf("%s%s%s", cacheSavedPrefix, infohash, taskSwarmExt))
+}
+
+// loadSwarmHistory restores the scrape history of a new task
+func (e *Engine) loadSwarmHistory(t *Torrent) {
+	data, err := ioutil.ReadFile(e.swarmFileName(t.InfoHash))
+	if err != nil {
+		return
+	}
+	var history []SwarmSample
+	if err := json.Unmarshal(data, &history); err != nil {
+		log.Println("[Swarm]", t.InfoHash, err)
+		return
+	}
+	if len(history) > maxSwarmHistory {
+		history = history[len(history)-maxSwarmHistory:]
+	}
+	t.SwarmHistory = history
+}
+
+func (e *Engine) saveSwarmHistory(infohash string, history []SwarmSample) error {
+	data, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(e.swarmFileName(infohash), data, 0644)
+}
+
+func (e *Engine) removeSwarmHistory(infohash string) {
+	if err := os.Remove(e.swarmFileName(infohash)); err != nil && !os.IsNotExist(err) {
+		log.Println("[Swarm]", infohash, err)
+	}
+}
+
+// SwarmHistory returns the scrape history of the task, oldest first. It's
+// kept out of the task state pushed to the web UI.
+func (e *Engine) SwarmHistory(infohash string) ([]SwarmSample, error) {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	t.Lock()
+	defer t.Unlock()
+	return append([]SwarmSample{}, t.SwarmHistory...), nil
+}
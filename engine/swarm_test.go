@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_appendSwarmSample(t *testing.T) {
+	var history []SwarmSample
+	for i := 0; i < maxSwarmHistory+2; i++ {
+		history = appendSwarmSample(history, SwarmSample{Seeders: i})
+	}
+	if len(history) != maxSwarmHistory || history[0].Seeders != 2 || history[len(history)-1].Seeders != maxSwarmHistory+1 {
+		t.Errorf("appendSwarmSample() kept %d samples, %d to %d", len(history), history[0].Seeders, history[len(history)-1].Seeders)
+	}
+}
+
+func TestEngine_swarmHistory(t *testing.T) {
+	const ih = "0123456789abcdef0123456789abcdef01234567"
+	e := &Engine{cacheDir: t.TempDir(), ts: make(map[string]*Torrent)}
+	at := time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	want := []SwarmSample{{At: at, Seeders: 10, Leechers: 3}, {At: at.Add(time.Hour), Seeders: 12, Leechers: 1}}
+	if err := e.saveSwarmHistory(ih, want); err != nil {
+		t.Fatal(err)
+	}
+
+	task := &Torrent{InfoHash: ih}
+	e.loadSwarmHistory(task)
+	e.ts[ih] = task
+	got, err := e.SwarmHistory(ih)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SwarmHistory() = %v, want %v", got, want)
+	}
+
+	e.removeSwarmHistory(ih)
+	task = &Torrent{InfoHash: ih}
+	e.loadSwarmHistory(task)
+	if task.SwarmHistory != nil {
+		t.Errorf("loaded %v after removed", task.SwarmHistory)
+	}
+}
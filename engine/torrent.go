@@ -2,11 +2,12 @@ package engine
 
 import (
 	"fmt"
-	"os/exec"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
 )
 
 type Torrent struct {
@@ -14,6 +15,8 @@ type Torrent struct {
 
 	//anacrolix/torrent
 	InfoHash   string
+	InfoHashV2 string
+	Hybrid     bool
 	Name       string
 	Magnet     string
 	Loaded     bool
@@ -21,6 +24,14 @@ type Torrent struct {
 	Uploaded   int64
 	Size       int64
 	Files      []*File
+	Labels     []string
+	Group      string `json:",omitempty"`
+	After      string `json:",omitempty"`
+	WebSeeds   []string
+	Directory  string
+	Notes      string
+	Meta       map[string]string
+	MetaStage  string
 
 	//cloud torrent
 	Stats          *torrent.TorrentStats
@@ -31,21 +42,55 @@ type Torrent struct {
 	IsSeeding      bool
 	ManualStarted  bool
 	IsAllFilesDone bool
+	IsPrivate      bool
+	IsStalled      bool
+	Status         TaskStatus
+	StatusSince    time.Time
+	Error          string
+	RetryCount     int
+	CorruptPieces  int
 	Percent        float32
 	DownloadRate   float32
 	UploadRate     float32
 	SeedRatio      float32
+	Availability   float32
+	ETA            int64
+	Swarm          SwarmStat
+	SwarmHistory   []SwarmSample `json:"-"`
 	AddedAt        time.Time
 	StartedAt      time.Time
 	FinishedAt     time.Time
 	StoppedAt      time.Time
+	NextRetryAt    time.Time
+	ScrubbedAt     time.Time
+	ScheduledStart *time.Time `json:",omitempty"`
+	Blocked        []string   `json:",omitempty"` // the files of the BlockedFileTypes
 	updatedAt      time.Time
+	lastProgressAt time.Time
+	lastDownloaded int64
+	smoothRate     float32
+	verifyReport   *VerifyReport
+	noAutoStart    bool
+	forceStart     bool
+	scraping       bool
+	checking       bool
+	moving         bool
+	scrapedAt      time.Time
+	waitTrackers   bool
+	ownTrackers    bool                // never looked up in the MetadataSources
+	specFlags      torrent.TorrentSpec // the flags added with, kept by MergeSpec
+	selected       map[string]bool     // the files to download, all if nil
+	selectDir      string              // the dir chosen by the preview
+	applyExclude   bool                // the ExcludeFiles apply once the info is known
+	blocked        map[string]bool     // the files never downloaded
 	t              *torrent.Torrent
 	e              *Engine
 	dropWait       chan struct{}
 	cld            Server
 }
 
+const etaSmoothFactor = 0.3
+
 type File struct {
 	//anacrolix/torrent
 	Path          string
@@ -54,9 +99,10 @@ type File struct {
 	Done          bool
 	DoneCmdCalled bool
 	//cloud torrent
-	Started bool
-	Percent float32
-	f       *torrent.File
+	Started   bool
+	Percent   float32
+	Subtitles []string
+	f         *torrent.File
 }
 
 // Update retrive info from torrent.Torrent
@@ -64,8 +110,11 @@ func (torrent *Torrent) updateOnGotInfo(t *torrent.Torrent) {
 
 	if t.Info() != nil && !torrent.Loaded {
 		torrent.t = t
-		torrent.Name = t.Name()
+		torrent.Name = torrent.e.displayName(torrent.InfoHash, t.Name())
 		torrent.Loaded = true
+		torrent.IsPrivate = isPrivate(t.Info())
+		torrent.InfoHashV2, _ = infoV2(t.Metainfo().InfoBytes)
+		torrent.Hybrid = torrent.InfoHashV2 != ""
 		torrent.updateFileStatus()
 		torrent.updateTorrentStatus()
 		torrent.updateConnStat()
@@ -78,7 +127,7 @@ func (torrent *Torrent) updateOnGotInfo(t *torrent.Torrent) {
 			} else {
 				torrent.Magnet = "ERROR{}"
 			}
-			torrent.Name = t.Name()
+			torrent.Name = torrent.e.displayName(torrent.InfoHash, t.Name())
 		}
 	}
 }
@@ -128,6 +177,36 @@ func (torrent *Torrent) updateConnStat() {
 	}
 }
 
+// updateETA estimates the remaining seconds by the smoothed download rate,
+// -1 if unknown
+func (torrent *Torrent) updateETA() {
+	if torrent.smoothRate == 0 {
+		torrent.smoothRate = torrent.DownloadRate
+	} else {
+		torrent.smoothRate = etaSmoothFactor*torrent.DownloadRate + (1-etaSmoothFactor)*torrent.smoothRate
+	}
+	torrent.ETA = eta(torrent.Size-torrent.Downloaded, torrent.smoothRate)
+}
+
+// updateAvailability counts the distributed copies among the connected peers
+func (torrent *Torrent) updateAvailability() {
+	n := torrent.t.NumPieces()
+	if n == 0 {
+		return
+	}
+	counts := make([]int, n)
+	for _, pc := range torrent.t.PeerConns() {
+		pc.PeerPieces().Iterate(func(x uint32) bool {
+			if int(x) >= n {
+				return false
+			}
+			counts[x]++
+			return true
+		})
+	}
+	torrent.Availability = distributedCopies(counts)
+}
+
 func (torrent *Torrent) updateFileStatus() {
 	if torrent.IsAllFilesDone {
 		return
@@ -141,10 +220,10 @@ func (torrent *Torrent) updateFileStatus() {
 	//merge in files
 	doneFlag := true
 	for i, f := range tfiles {
-		path := f.Path()
+		path := torrent.e.renamedPath(torrent.InfoHash, f.Path())
 		file := torrent.Files[i]
 		if file == nil {
-			file = &File{Path: path, Started: torrent.Started, f: f}
+			file = &File{Path: path, Started: torrent.Started && torrent.isSelected(f.Path()), f: f}
 			torrent.Files[i] = file
 		}
 
@@ -154,9 +233,9 @@ func (torrent *Torrent) updateFileStatus() {
 		file.Done = (file.Completed == file.Size)
 		if file.Done && !file.DoneCmdCalled {
 			file.DoneCmdCalled = true
-			go torrent.callDoneCmd(file.Path, "file", file.Size)
+			go torrent.callDoneCmd(file.Path, "file", file.Size, torrent.lockedDoneCmdTask())
 		}
-		if !file.Done {
+		if !file.Done && torrent.isSelected(f.Path()) {
 			doneFlag = false
 		}
 	}
@@ -167,7 +246,7 @@ func (torrent *Torrent) updateFileStatus() {
 func (torrent *Torrent) updateTorrentStatus() {
 	torrent.Size = torrent.t.Length()
 	torrent.Percent = percent(torrent.t.BytesCompleted(), torrent.Size)
-	torrent.Done = (torrent.t.BytesMissing() == 0)
+	torrent.Done = (torrent.bytesMissing() == 0)
 	torrent.IsSeeding = torrent.t.Seeding() && torrent.Done
 
 	// this process called at least on second Update calls
@@ -175,8 +254,70 @@ func (torrent *Torrent) updateTorrentStatus() {
 		torrent.DoneCmdCalled = true
 		torrent.FinishedAt = time.Now()
 		log.Println("[TaskFinished]", torrent.InfoHash)
-		go torrent.callDoneCmd(torrent.Name, "torrent", torrent.Size)
+		hres := runHooks(torrent.e.hookRules(), hookOnComplete, torrent.hookTarget())
+		torrent.Labels = hres.labels
+		if hres.stop {
+			go torrent.e.StopTorrent(torrent.InfoHash) // nolint: errcheck
+		}
+		dt := torrent.lockedDoneCmdTask()
+		group := torrent.Group
+		go func() {
+			if torrent.e.config.VerifyOnComplete {
+				torrent.verify()
+			}
+			if torrent.e.config.ChecksumManifest {
+				if err := torrent.writeManifest(); err != nil {
+					log.Println("[Manifest]", torrent.InfoHash, err)
+				}
+			}
+			if torrent.e.config.FinishedDirectory != "" {
+				torrent.linkFinished()
+			}
+			if torrent.e.config.SubtitlesAPIKey != "" {
+				torrent.fetchSubtitles()
+			}
+			torrent.e.postProcess(torrent)
+			torrent.e.notify(EventComplete, torrent, torrent.verifyStatus())
+			torrent.callDoneCmd(torrent.diskName(), "torrent", torrent.Size, dt)
+			torrent.e.groupTaskDone(group)
+			torrent.e.releaseDependents(torrent.InfoHash)
+		}()
+	}
+}
+
+func eta(remain int64, rate float32) int64 {
+	if remain <= 0 {
+		return 0
+	}
+	if rate < 1 {
+		return -1
+	}
+	return int64(float32(remain) / rate)
+}
+
+// distributedCopies is the number of full copies in the swarm, plus the
+// fraction of the pieces that are more available than the rarest one
+func distributedCopies(counts []int) float32 {
+	if len(counts) == 0 {
+		return 0
+	}
+	min := counts[0]
+	for _, c := range counts {
+		if c < min {
+			min = c
+		}
+	}
+	var above int
+	for _, c := range counts {
+		if c > min {
+			above++
+		}
 	}
+	return float32(min) + float32(above)/float32(len(counts))
+}
+
+func isPrivate(info *metainfo.Info) bool {
+	return info != nil && info.Private != nil && *info.Private
 }
 
 func percent(n, total int64) float32 {
@@ -186,42 +327,89 @@ func percent(n, total int64) float32 {
 	return float32(int(float64(10000)*(float64(n)/float64(total)))) / 100
 }
 
-func (t *Torrent) callDoneCmd(name, tasktype string, size int64) {
-
-	if cmd, env, err := t.e.config.GetCmdConfig(); err == nil {
-		cmd := exec.Command(cmd)
-		ih := t.InfoHash
-		cmd.Env = append(env,
-			fmt.Sprintf("CLD_RESTAPI=%s", t.cld.GetStrAttribute("RestAPI")),
-			fmt.Sprintf("CLD_PATH=%s", name),
-			fmt.Sprintf("CLD_HASH=%s", ih),
-			fmt.Sprintf("CLD_TYPE=%s", tasktype),
-			fmt.Sprintf("CLD_SIZE=%d", size),
-			fmt.Sprintf("CLD_STARTTS=%d", t.StartedAt.Unix()),
-			fmt.Sprintf("CLD_FILENUM=%d", len(t.Files)),
-		)
-		sout, _ := cmd.StdoutPipe()
-		serr, _ := cmd.StderrPipe()
-		log.Printf("[DoneCmd:%s]%sCMD:`%s' ENV:%s", tasktype, ih, cmd.String(), cmd.Env)
-		if err := cmd.Start(); err != nil {
-			log.Printf("[DoneCmd:%s]%sERR: %v", tasktype, ih, err)
-			return
-		}
+// dataDir is the directory the task saves to
+func (t *Torrent) dataDir() string {
+	if t.Directory != "" {
+		return t.Directory
+	}
+	return t.e.config.DownloadDirectory
+}
 
-		var wg sync.WaitGroup
-		wg.Add(2)
-		go cmdScanLine(sout, &wg, fmt.Sprintf("[DoneCmd:%s]%sO:", log.filteredArg(tasktype, ih)...))
-		go cmdScanLine(serr, &wg, fmt.Sprintf("[DoneCmd:%s]%sE:", log.filteredArg(tasktype, ih)...))
-		wg.Wait()
+// diskName is the file or the top dir of the task in its data dir, which
+// differs from the Name if renamed
+func (t *Torrent) diskName() string {
+	if len(t.Files) == 0 || t.Files[0] == nil {
+		return t.Name
+	}
+	return strings.SplitN(t.Files[0].Path, "/", 2)[0]
+}
 
-		// call Wait will close pipes above
-		if err := cmd.Wait(); err != nil {
-			log.Printf("[DoneCmd:%s]%sERR: %v", tasktype, ih, err)
-			return
-		}
+// verifyStatus is "ok" or "failed" if the task has been verified, or empty
+func (t *Torrent) verifyStatus() string {
+	t.Lock()
+	defer t.Unlock()
+	switch {
+	case t.verifyReport == nil:
+		return ""
+	case t.verifyReport.OK:
+		return "ok"
+	default:
+		return "failed"
+	}
+}
 
-		log.Printf("[DoneCmd:%s]%sExit code: %d", tasktype, ih, cmd.ProcessState.ExitCode())
-	} else {
+// doneCmdTask is the task as the DoneCmd is called, taken before the call
+// goes to the background
+type doneCmdTask struct {
+	routed    string
+	startedAt time.Time
+	fileNum   int
+}
+
+// doneCmdTask snapshots the task for the DoneCmd, with t locked
+func (t *Torrent) doneCmdTask() doneCmdTask {
+	return doneCmdTask{
+		routed:    t.e.doneCmdRoutes.route(t.hookTarget()),
+		startedAt: t.StartedAt,
+		fileNum:   len(t.Files),
+	}
+}
+
+// lockedDoneCmdTask is doneCmdTask with t not locked
+func (t *Torrent) lockedDoneCmdTask() doneCmdTask {
+	t.Lock()
+	defer t.Unlock()
+	return t.doneCmdTask()
+}
+
+func (t *Torrent) callDoneCmd(name, tasktype string, size int64, dt doneCmdTask) {
+
+	cmdPath, env, err := t.e.config.GetCmdConfig()
+	if err != nil {
 		log.Println("[DoneCmd]", t.InfoHash, err)
+		return
+	}
+	if dt.routed != "" {
+		cmdPath = dt.routed
+	}
+	if cmdPath == "" {
+		log.Println("[DoneCmd]", t.InfoHash, "no route matched")
+		return
+	}
+	env = append(env,
+		fmt.Sprintf("CLD_RESTAPI=%s", t.cld.GetStrAttribute("RestAPI")),
+		fmt.Sprintf("CLD_PATH=%s", name),
+		fmt.Sprintf("CLD_HASH=%s", t.InfoHash),
+		fmt.Sprintf("CLD_TYPE=%s", tasktype),
+		fmt.Sprintf("CLD_SIZE=%d", size),
+		fmt.Sprintf("CLD_STARTTS=%d", dt.startedAt.Unix()),
+		fmt.Sprintf("CLD_FILENUM=%d", dt.fileNum),
+		fmt.Sprintf("CLD_VERIFY=%s", t.verifyStatus()),
+	)
+	if tasktype == "torrent" {
+		if fn := t.manifestPath(); t.e.config.ChecksumManifest && isFile(fn) {
+			env = append(env, fmt.Sprintf("CLD_MANIFEST=%s", fn))
+		}
 	}
+	t.e.jobs.submit(t.InfoHash, name, tasktype, cmdPath, env)
 }
@@ -0,0 +1,47 @@
+package engine
+
+import "testing"
+
+func Test_eta(t *testing.T) {
+	tests := []struct {
+		name   string
+		remain int64
+		rate   float32
+		want   int64
+	}{
+		{"done", 0, 100, 0},
+		{"overshot", -10, 100, 0},
+		{"stalled", 100, 0, -1},
+		{"below a byte", 100, 0.5, -1},
+		{"downloading", 1000, 100, 10},
+		{"rounded down", 1050, 100, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := eta(tt.remain, tt.rate); got != tt.want {
+				t.Errorf("eta() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_distributedCopies(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts []int
+		want   float32
+	}{
+		{"no pieces", nil, 0},
+		{"no peers", []int{0, 0, 0, 0}, 0},
+		{"partial", []int{0, 1, 1, 0}, 0.5},
+		{"full copies", []int{2, 2, 2, 2}, 2},
+		{"plus rarest", []int{1, 2, 3, 1}, 1.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := distributedCopies(tt.counts); got != tt.want {
+				t.Errorf("distributedCopies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/anacrolix/torrent/bencode"
+)
+
+// The torrent client speaks BitTorrent v1 only. The hybrid torrents carry
+// the v1 piece hashes as well, so they download by the v1 infohash; the v2
+// infohash is computed for display, and the verification checks the files
+// by their v2 merkle roots too. The v2-only torrents and the magnets with
+// only the btmh can't be added, as the client can neither fetch their info
+// nor check their pieces.
+
+var ErrV2Only = errors.New("BitTorrent v2-only torrents are not supported, use a hybrid torrent")
+
+const (
+	btihPrefix = "urn:btih:"
+	// multihash sha2-256 (0x12) of 32 bytes (0x20)
+	btmhPrefix = "urn:btmh:1220"
+	// the leaves of the v2 file merkle trees
+	merkleBlockSize = 16 << 10
+)
+
+// normalizeMagnet moves the v1 xt of a hybrid magnet to the first, as the
+// magnet parser reads only the first xt. A magnet with only the v2 btmh is
+// rejected with ErrV2Only.
+func normalizeMagnet(magnetURI string) (string, error) {
+	u, err := url.Parse(magnetURI)
+	if err != nil || u.Scheme != "magnet" {
+		return magnetURI, nil
+	}
+	q := u.Query()
+	var v1, others []string
+	hasV2 := false
+	for _, xt := range q["xt"] {
+		switch {
+		case strings.HasPrefix(xt, btihPrefix):
+			v1 = append(v1, xt)
+		case strings.HasPrefix(xt, btmhPrefix):
+			hasV2 = true
+			others = append(others, xt)
+		default:
+			others = append(others, xt)
+		}
+	}
+	if len(v1) == 0 {
+		if hasV2 {
+			return "", ErrV2Only
+		}
+		return magnetURI, nil
+	}
+	if strings.HasPrefix(q.Get("xt"), btihPrefix) {
+		return magnetURI, nil
+	}
+	q["xt"] = append(v1, others...)
+	// keep urn:btih: unescaped, same as the magnet parser writes
+	u.RawQuery = strings.ReplaceAll(q.Encode(), "urn%3Abtih%3A", btihPrefix)
+	return u.String(), nil
+}
+
+// infoV2 reads the v2 properties of the info dict: the sha256 infohash if it
+// is of metadata version 2, and whether it has the v1 file list (hybrid)
+func infoV2(infoBytes []byte) (ihv2 string, hasV1 bool) {
+	var info struct {
+		MetaVersion int   `bencode:"meta version"`
+		Length      int64 `bencode:"length"`
+		Files       []struct {
+			Length int64 `bencode:"length"`
+		} `bencode:"files"`
+		// kept raw, skipping it decodes into interface{} which rejects the
+		// "" keys of the files
+		FileTree map[string]bencode.Bytes `bencode:"file tree"`
+	}
+	if len(infoBytes) == 0 || bencode.Unmarshal(infoBytes, &info) != nil {
+		return "", true
+	}
+	hasV1 = info.Length > 0 || len(info.Files) > 0
+	if info.MetaVersion != 2 {
+		return "", hasV1
+	}
+	h := sha256.Sum256(infoBytes)
+	return hex.EncodeToString(h[:]), hasV1
+}
+
+// checkV2Only rejects the info dict without the v1 file list
+func checkV2Only(infoBytes []byte) error {
+	if ihv2, hasV1 := infoV2(infoBytes); ihv2 != "" && !hasV1 {
+		return ErrV2Only
+	}
+	return nil
+}
+
+// v2FileRoots reads the "pieces root" of the files in the v2 file tree, by
+// the file paths of the torrent client, which are prefixed with the name for
+// the multi-file torrents. The empty files have no root.
+func v2FileRoots(infoBytes []byte) map[string][]byte {
+	// the file tree is decoded level by level as raw values, the decoding
+	// into interface{} rejects the "" keys of the files
+	var info struct {
+		Name     string                   `bencode:"name"`
+		Length   int64                    `bencode:"length"`
+		FileTree map[string]bencode.Bytes `bencode:"file tree"`
+	}
+	if len(infoBytes) == 0 || bencode.Unmarshal(infoBytes, &info) != nil || len(info.FileTree) == 0 {
+		return nil
+	}
+	roots := make(map[string][]byte)
+	var walk func(dir string, tree map[string]bencode.Bytes)
+	walk = func(dir string, tree map[string]bencode.Bytes) {
+		for k, v := range tree {
+			if k == "" {
+				var f struct {
+					PiecesRoot string `bencode:"pieces root"`
+				}
+				if bencode.Unmarshal(v, &f) == nil && len(f.PiecesRoot) == sha256.Size {
+					roots[dir] = []byte(f.PiecesRoot)
+				}
+				continue
+			}
+			var sub map[string]bencode.Bytes
+			if bencode.Unmarshal(v, &sub) != nil {
+				continue
+			}
+			p := k
+			if dir != "" {
+				p = dir + "/" + k
+			}
+			walk(p, sub)
+		}
+	}
+	dir := info.Name
+	if info.Length > 0 {
+		// single file, the tree has the name only
+		dir = ""
+	}
+	walk(dir, info.FileTree)
+	return roots
+}
+
+// merkleRoot computes the BEP 52 root of the data: the sha256 tree of the
+// 16KiB blocks, with the leaves padded by zero hashes to a power of two
+func merkleRoot(r io.Reader) ([]byte, error) {
+	type node struct {
+		level int
+		hash  []byte
+	}
+	join := func(l, r []byte) []byte {
+		h := sha256.Sum256(append(append(make([]byte, 0, 2*sha256.Size), l...), r...))
+		return h[:]
+	}
+	// pad[k] is the root of a subtree of 2^k zero leaves
+	pad := [][]byte{make([]byte, sha256.Size)}
+	padAt := func(level int) []byte {
+		for len(pad) <= level {
+			last := pad[len(pad)-1]
+			pad = append(pad, join(last, last))
+		}
+		return pad[level]
+	}
+
+	var stack []node
+	leaves := 0
+	buf := make([]byte, merkleBlockSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			h := sha256.Sum256(buf[:n])
+			stack = append(stack, node{0, h[:]})
+			leaves++
+			for len(stack) > 1 && stack[len(stack)-1].level == stack[len(stack)-2].level {
+				l, r := stack[len(stack)-2], stack[len(stack)-1]
+				stack = append(stack[:len(stack)-2], node{l.level + 1, join(l.hash, r.hash)})
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if leaves == 0 {
+		return nil, nil
+	}
+
+	// fill the right edge with the zero subtrees
+	for len(stack) > 1 {
+		top := &stack[len(stack)-1]
+		if below := stack[len(stack)-2]; top.level < below.level {
+			top.hash = join(top.hash, padAt(top.level))
+			top.level++
+			continue
+		}
+		l, r := stack[len(stack)-2], stack[len(stack)-1]
+		stack = append(stack[:len(stack)-2], node{l.level + 1, join(l.hash, r.hash)})
+	}
+	root := stack[0]
+	height := 0
+	for 1<<height < leaves {
+		height++
+	}
+	for root.level < height {
+		root.hash = join(root.hash, padAt(root.level))
+		root.level++
+	}
+	return root.hash, nil
+}
+
+// merkleMatch tells if the file at fn has the v2 root
+func merkleMatch(fn string, root []byte) bool {
+	f, err := os.Open(fn)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	got, err := merkleRoot(f)
+	return err == nil && bytes.Equal(got, root)
+}
@@ -0,0 +1,122 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/anacrolix/torrent/bencode"
+)
+
+func Test_normalizeMagnet(t *testing.T) {
+	const (
+		btih = "urn:btih:c9e15763f722f23e98a29decdfae341b98d53056"
+		btmh = "urn:btmh:1220caf1e1c30e81cb361b9ee167c4aa64228a7fa4fa9f6105232b28ad099f3a302e"
+	)
+	tests := []struct {
+		name    string
+		magnet  string
+		want    string
+		wantErr error
+	}{
+		{"v1", "magnet:?xt=" + btih + "&dn=a", "magnet:?xt=" + btih + "&dn=a", nil},
+		{"hybrid v1 first", "magnet:?xt=" + btih + "&xt=" + btmh, "magnet:?xt=" + btih + "&xt=" + btmh, nil},
+		{"hybrid v2 first", "magnet:?xt=" + btmh + "&xt=" + btih, "magnet:?xt=" + btih + "&xt=urn%3Abtmh%3A" + btmh[9:], nil},
+		{"v2 only", "magnet:?xt=" + btmh, "", ErrV2Only},
+		{"not magnet", "http://example.com/a.torrent", "http://example.com/a.torrent", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeMagnet(tt.magnet)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("normalizeMagnet() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("normalizeMagnet() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_merkleRoot(t *testing.T) {
+	h := func(b ...[]byte) []byte {
+		s := sha256.Sum256(bytes.Join(b, nil))
+		return s[:]
+	}
+	zero := make([]byte, sha256.Size)
+	block := func(c byte) []byte { return bytes.Repeat([]byte{c}, merkleBlockSize) }
+	tests := []struct {
+		name string
+		data []byte
+		want []byte
+	}{
+		{"empty", nil, nil},
+		{"partial block", []byte("abc"), h([]byte("abc"))},
+		{"one block", block('a'), h(block('a'))},
+		{"two blocks", append(block('a'), 'b'), h(h(block('a')), h([]byte("b")))},
+		{"three blocks", bytes.Join([][]byte{block('a'), block('b'), []byte("c")}, nil),
+			h(h(h(block('a')), h(block('b'))), h(h([]byte("c")), zero))},
+		{"five blocks", bytes.Join([][]byte{block('a'), block('b'), block('c'), block('d'), []byte("e")}, nil),
+			h(h(h(h(block('a')), h(block('b'))), h(h(block('c')), h(block('d')))),
+				h(h(h([]byte("e")), zero), h(zero, zero)))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := merkleRoot(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("merkleRoot() = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_v2FileRoots(t *testing.T) {
+	root := func(c byte) string { return string(bytes.Repeat([]byte{c}, sha256.Size)) }
+	leaf := func(r string) map[string]interface{} {
+		return map[string]interface{}{"": map[string]interface{}{"length": 1, "pieces root": r}}
+	}
+	tests := []struct {
+		name string
+		info map[string]interface{}
+		want map[string]string
+	}{
+		{"v1", map[string]interface{}{"name": "a", "length": 1}, nil},
+		{"single file", map[string]interface{}{
+			"name": "a", "length": 1, "meta version": 2,
+			"file tree": map[string]interface{}{"a": leaf(root('a'))},
+		}, map[string]string{"a": root('a')}},
+		{"multi file", map[string]interface{}{
+			"name": "d", "meta version": 2,
+			"file tree": map[string]interface{}{
+				"a":     leaf(root('a')),
+				"sub":   map[string]interface{}{"b": leaf(root('b'))},
+				"empty": map[string]interface{}{"": map[string]interface{}{"length": 0}},
+			},
+		}, map[string]string{"d/a": root('a'), "d/sub/b": root('b')}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			infoBytes, err := bencode.Marshal(tt.info)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the "" keys of the file tree are fine to infoV2 too
+			if ihv2, _ := infoV2(infoBytes); (ihv2 != "") != (tt.want != nil) {
+				t.Errorf("infoV2() = %q, want v2 %v", ihv2, tt.want != nil)
+			}
+			got := v2FileRoots(infoBytes)
+			if len(got) != len(tt.want) {
+				t.Fatalf("v2FileRoots() = %q, want %q", got, tt.want)
+			}
+			for p, r := range tt.want {
+				if string(got[p]) != r {
+					t.Errorf("v2FileRoots()[%q] = %x, want %x", p, got[p], r)
+				}
+			}
+		})
+	}
+}
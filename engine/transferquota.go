@@ -0,0 +1,311 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/c2h5oh/datasize"
+	"github.com/dustin/go-humanize"
+)
+
+const (
+	transferQuotaTick = time.Minute
+	transferQuotaFile = "transfer-quota.json"
+	// the share of the quota throttled and warned at
+	transferQuotaWarn = 0.9
+
+	EventQuota = "quota"
+)
+
+var ErrTransferQuotaExceeded = errors.New("Monthly transfer quota exceeded")
+
+// TransferQuota is the traffic of the current period of the TransferQuota,
+// reported by GET /api/transferquota
+type TransferQuota struct {
+	Enabled bool
+	Limit   int64
+	// the bytes counted by the TransferQuotaCount
+	Used        int64
+	Upload      int64
+	Download    int64
+	PeriodStart time.Time
+	PeriodEnd   time.Time
+	// slowed to the TransferQuotaThrottle, past 90% of the quota
+	Throttled bool
+	// the tasks paused till the next period
+	Exceeded bool
+}
+
+// transferUsage is the traffic of the period, kept in the cache dir across
+// the restarts
+type transferUsage struct {
+	PeriodStart time.Time
+	Upload      int64
+	Download    int64
+	// notified already
+	Warned   bool
+	Exceeded bool
+	// the tasks paused, started again with the next period
+	Paused []string `json:",omitempty"`
+}
+
+type transferQuotaState struct {
+	sync.Mutex
+	usage  transferUsage
+	loaded bool
+	// the counters of the client at the last tick, a new client starts at 0
+	client              *torrent.Client
+	lastRead, lastWrite int64
+	throttled           bool
+}
+
+// parseTransferQuota is the TransferQuota in bytes, 0 if not set
+func parseTransferQuota(s string) (int64, error) {
+	if s == "" || s == "0" {
+		return 0, nil
+	}
+	var v datasize.ByteSize
+	if err := v.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(v), nil
+}
+
+// transferPeriod is the month of the quota the time is in, starting on the
+// reset day
+func transferPeriod(now time.Time, resetDay int) (start, end time.Time) {
+	start = time.Date(now.Year(), now.Month(), resetDay, 0, 0, 0, 0, now.Location())
+	if now.Before(start) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start, start.AddDate(0, 1, 0)
+}
+
+// transferUsed is the traffic counted by the TransferQuotaCount
+func transferUsed(u transferUsage, count string) int64 {
+	switch count {
+	case "upload":
+		return u.Upload
+	case "download":
+		return u.Download
+	}
+	return u.Upload + u.Download
+}
+
+// TransferQuotaStatus reports the traffic of the period
+func (e *Engine) TransferQuotaStatus() TransferQuota {
+	e.RLock()
+	c := e.config
+	e.RUnlock()
+	limit, _ := parseTransferQuota(c.TransferQuota)
+	start, end := transferPeriod(time.Now(), c.TransferQuotaResetDay)
+	e.transferQuota.Lock()
+	defer e.transferQuota.Unlock()
+	e.loadTransferUsage()
+	u := e.transferQuota.usage
+	if !u.PeriodStart.Equal(start) {
+		u = transferUsage{}
+	}
+	return TransferQuota{
+		Enabled:     limit > 0,
+		Limit:       limit,
+		Used:        transferUsed(u, c.TransferQuotaCount),
+		Upload:      u.Upload,
+		Download:    u.Download,
+		PeriodStart: start,
+		PeriodEnd:   end,
+		Throttled:   e.transferQuota.throttled,
+		Exceeded:    u.Exceeded,
+	}
+}
+
+// transferQuotaExceeded refuses the starts, from the restore at the
+// start up too
+func (e *Engine) transferQuotaExceeded() bool {
+	e.transferQuota.Lock()
+	defer e.transferQuota.Unlock()
+	e.loadTransferUsage()
+	return e.transferQuota.usage.Exceeded
+}
+
+// loadTransferUsage reads the usage kept once, the lock held
+func (e *Engine) loadTransferUsage() {
+	qs := &e.transferQuota
+	if qs.loaded {
+		return
+	}
+	qs.loaded = true
+	data, err := ioutil.ReadFile(e.transferQuotaFileName())
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(data, &qs.usage); err != nil {
+		log.Println("[TransferQuota]", err)
+	}
+}
+
+func (e *Engine) transferQuotaFileName() string {
+	return filepath.Join(e.cacheDir, transferQuotaFile)
+}
+
+func (e *Engine) transferQuotaInterval() time.Duration {
+	e.RLock()
+	defer e.RUnlock()
+	if limit, _ := parseTransferQuota(e.config.TransferQuota); limit > 0 {
+		return transferQuotaTick
+	}
+	// lifting the throttle and the pause of a quota removed
+	e.transferQuota.Lock()
+	defer e.transferQuota.Unlock()
+	if e.transferQuota.throttled || e.transferQuota.usage.Exceeded {
+		return transferQuotaTick
+	}
+	return 0
+}
+
+// transferQuotaTask counts the traffic of the client into the period,
+// throttling then pausing the tasks as the quota is approached
+func (e *Engine) transferQuotaTask() error {
+	e.RLock()
+	c := e.config
+	client := e.client
+	e.RUnlock()
+	if client == nil {
+		return nil
+	}
+	limit, err := parseTransferQuota(c.TransferQuota)
+	if err != nil {
+		return err
+	}
+	start, _ := transferPeriod(time.Now(), c.TransferQuotaResetDay)
+	cs := client.ConnStats()
+	read, write := cs.BytesRead.Int64(), cs.BytesWritten.Int64()
+
+	qs := &e.transferQuota
+	qs.Lock()
+	e.loadTransferUsage()
+	if qs.client != client {
+		qs.client, qs.lastRead, qs.lastWrite = client, 0, 0
+	}
+	if !qs.usage.PeriodStart.Equal(start) {
+		if !qs.usage.PeriodStart.IsZero() {
+			log.Printf("[TransferQuota] new period from %s, %s used in the last", start.Format("2006-01-02"),
+				humanize.Bytes(uint64(transferUsed(qs.usage, c.TransferQuotaCount))))
+		}
+		qs.usage = transferUsage{PeriodStart: start, Exceeded: qs.usage.Exceeded, Paused: qs.usage.Paused}
+	}
+	qs.usage.Download += read - qs.lastRead
+	qs.usage.Upload += write - qs.lastWrite
+	qs.lastRead, qs.lastWrite = read, write
+
+	used := transferUsed(qs.usage, c.TransferQuotaCount)
+	warn := limit > 0 && float64(used) >= transferQuotaWarn*float64(limit)
+	exceed := limit > 0 && used >= limit
+	notifyWarn := warn && !qs.usage.Warned
+	notifyExceed := exceed && !qs.usage.Exceeded
+	// back under by a new period or a raised quota
+	resume := !exceed && qs.usage.Exceeded
+	qs.usage.Warned, qs.usage.Exceeded = warn, exceed
+	throttle := warn && c.TransferQuotaThrottle != ""
+	rethrottle := throttle != qs.throttled
+	qs.throttled = throttle
+	qs.Unlock()
+
+	if rethrottle {
+		if throttle {
+			e.setRateThrottle(c.TransferQuotaThrottle)
+			log.Println("[TransferQuota] throttled to", c.TransferQuotaThrottle)
+		} else {
+			e.setRateThrottle("")
+			log.Println("[TransferQuota] throttle lifted")
+		}
+	}
+	if resume {
+		e.transferQuotaResume()
+	}
+	msg := fmt.Sprintf("%s of %s used, till %s", humanize.Bytes(uint64(used)), humanize.Bytes(uint64(limit)),
+		start.AddDate(0, 1, 0).Format("2006-01-02"))
+	switch {
+	case exceed:
+		// the tasks started since by hand too
+		e.transferQuotaPause(msg, notifyExceed)
+	case notifyWarn:
+		if throttle {
+			msg += ", throttled to " + c.TransferQuotaThrottle
+		}
+		e.notify(EventQuota, &Torrent{Name: "Monthly transfer quota almost reached"}, msg)
+	}
+	qs.Lock()
+	usage := qs.usage
+	qs.Unlock()
+	return e.saveTransferUsage(usage)
+}
+
+func (e *Engine) saveTransferUsage(u transferUsage) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	fn := e.transferQuotaFileName()
+	tmp := fn + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fn)
+}
+
+// transferQuotaPause stops the started tasks, seeding ones included
+func (e *Engine) transferQuotaPause(msg string, notify bool) {
+	var paused []string
+	for ih, rt := range e.resumeTasks() {
+		if !rt.Started {
+			continue
+		}
+		if err := e.StopTorrent(ih); err != nil {
+			log.Println("[TransferQuota]", ih, err)
+			continue
+		}
+		e.RLock()
+		t, terr := e.getTorrent(ih)
+		e.RUnlock()
+		if terr == nil {
+			t.Lock()
+			// not retried, resumed with the next period
+			t.Error = ErrTransferQuotaExceeded.Error()
+			t.NextRetryAt = time.Time{}
+			t.Unlock()
+		}
+		paused = append(paused, ih)
+	}
+	if len(paused) > 0 {
+		log.Printf("[TransferQuota] exceeded, %s, paused %d tasks", msg, len(paused))
+		e.transferQuota.Lock()
+		e.transferQuota.usage.Paused = append(e.transferQuota.usage.Paused, paused...)
+		e.transferQuota.Unlock()
+		e.TsChanged <- struct{}{}
+	}
+	if notify {
+		e.notify(EventQuota, &Torrent{Name: ErrTransferQuotaExceeded.Error()}, msg)
+	}
+}
+
+func (e *Engine) transferQuotaResume() {
+	e.transferQuota.Lock()
+	paused := e.transferQuota.usage.Paused
+	e.transferQuota.usage.Paused = nil
+	e.transferQuota.Unlock()
+	log.Println("[TransferQuota] back under the quota, resuming", len(paused), "tasks")
+	for _, ih := range paused {
+		if err := e.StartTorrent(ih); err != nil {
+			log.Println("[TransferQuota]", ih, err)
+		}
+	}
+	e.TsChanged <- struct{}{}
+}
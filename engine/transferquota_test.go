@@ -0,0 +1,50 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_transferPeriod(t *testing.T) {
+	day := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 0, 0, 0, 0, time.Local) }
+	tests := []struct {
+		name      string
+		now       time.Time
+		resetDay  int
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{"first", day(2026, 10, 16).Add(5 * time.Hour), 1, day(2026, 10, 1), day(2026, 11, 1)},
+		{"before the reset day", day(2026, 10, 10), 15, day(2026, 9, 15), day(2026, 10, 15)},
+		{"on the reset day", day(2026, 10, 15), 15, day(2026, 10, 15), day(2026, 11, 15)},
+		{"across the year", day(2027, 1, 3), 20, day(2026, 12, 20), day(2027, 1, 20)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := transferPeriod(tt.now, tt.resetDay)
+			if !start.Equal(tt.wantStart) || !end.Equal(tt.wantEnd) {
+				t.Errorf("transferPeriod() = %v, %v, want %v, %v", start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func Test_parseTransferQuota(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{"2TB", 2 << 40, false},
+		{"500GB", 500 << 30, false},
+		{"lots", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTransferQuota(tt.s)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseTransferQuota(%q) = %d, %v", tt.s, got, err)
+		}
+	}
+}
@@ -3,11 +3,12 @@ package engine
 import (
 	"bufio"
 	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/boypt/simple-torrent/common"
@@ -47,18 +48,6 @@ func rateLimiter(rstr string) (*rate.Limiter, error) {
 	return rate.NewLimiter(rate.Limit(rateSize), rateSize*3), nil
 }
 
-func cmdScanLine(p io.ReadCloser, wg *sync.WaitGroup, logprefix string) {
-	sc := bufio.NewScanner(p)
-	for sc.Scan() {
-		oline := strings.TrimSpace(sc.Text())
-		if len(oline) > 0 {
-			log.Println(logprefix, oline)
-		}
-	}
-
-	wg.Done()
-}
-
 func mkdir(dirpath string) {
 	if st, err := os.Stat(dirpath); errors.Is(err, os.ErrNotExist) {
 		common.HandleError(os.MkdirAll(dirpath, os.ModePerm))
@@ -67,12 +56,13 @@ func mkdir(dirpath string) {
 	}
 }
 
-func fetchTxtList(url string) ([]string, error) {
-	var txtlines []string
-
+func fetchTxtList(url string, r *net.Resolver) ([]string, error) {
 	log.Println("fetchTxtList: fetching", url)
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = DialContext(r)
 	client := http.Client{
-		Timeout: 10 * time.Second,
+		Transport: tr,
+		Timeout:   10 * time.Second,
 	}
 
 	resp, err := client.Get(url)
@@ -80,17 +70,77 @@ func fetchTxtList(url string) ([]string, error) {
 		return nil, err
 	}
 	defer resp.Body.Close()
-	scanner := bufio.NewScanner(resp.Body)
-	scanner.Split(bufio.ScanLines)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetchTxtList: %s", resp.Status)
+	}
+	txtlines := scanTxtLines(resp.Body)
+	log.Println("fetchTxtList: got lines", len(txtlines))
+	return txtlines, nil
+}
 
+func readTxtList(fpath string) ([]string, error) {
+	f, err := os.Open(strings.TrimSpace(fpath))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	txtlines := scanTxtLines(f)
+	log.Println("readTxtList: got lines", len(txtlines), fpath)
+	return txtlines, nil
+}
+
+// scanTxtLines reads non-empty lines, skipping "#" comments
+func scanTxtLines(r io.Reader) []string {
+	var txtlines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Split(bufio.ScanLines)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
+		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
 		txtlines = append(txtlines, line)
 	}
+	return txtlines
+}
 
-	log.Println("fetchTxtList: got lines", len(txtlines))
-	return txtlines, nil
+// dedupTrackers removes duplicated entries while keeping the original order
+func dedupTrackers(trackers []string) []string {
+	dupMap := make(map[string]struct{})
+	res := make([]string, 0, len(trackers))
+	for _, t := range trackers {
+		if _, ok := dupMap[t]; ok {
+			continue
+		}
+		dupMap[t] = struct{}{}
+		res = append(res, t)
+	}
+	return res
+}
+
+// namespaced appends the instance name to a state file or directory name
+func namespaced(name, instance string) string {
+	if instance == "" {
+		return name
+	}
+	return name + "-" + instance
+}
+
+// freePort finds a port free on both tcp and udp, as both are used by the torrent client
+func freePort() (int, error) {
+	for i := 0; i < 10; i++ {
+		l, err := net.Listen("tcp", ":0")
+		if err != nil {
+			return 0, err
+		}
+		port := l.Addr().(*net.TCPAddr).Port
+		u, err := net.ListenPacket("udp", fmt.Sprintf(":%d", port))
+		l.Close()
+		if err != nil {
+			continue
+		}
+		u.Close()
+		return port, nil
+	}
+	return 0, errors.New("No free port found")
 }
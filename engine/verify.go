@@ -0,0 +1,136 @@
+package engine
+
+import (
+	"errors"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var ErrNotVerified = errors.New("Task not verified yet")
+
+// VerifyReport is the result of rehashing the data of a task
+type VerifyReport struct {
+	InfoHash   string
+	OK         bool
+	Pieces     int
+	BadPieces  int
+	Files      []*FileReport
+	VerifiedAt time.Time
+	Duration   time.Duration
+}
+
+// FileReport is the verification result of a single file
+type FileReport struct {
+	Path      string
+	Size      int64
+	Pieces    int
+	BadPieces int
+	OK        bool
+	// the file starts at a piece boundary
+	Aligned bool
+	// BEP47 / BitComet style padding files
+	IsPadding bool
+	// the data on disk matches the v2 merkle root, the hybrid torrents only
+	MerkleOK *bool `json:",omitempty"`
+}
+
+// verify rehashes all the pieces of the task and records the report
+func (t *Torrent) verify() *VerifyReport {
+	tt := t.t
+	if tt == nil || tt.Info() == nil {
+		return nil
+	}
+
+	log.Println("[Verify] started", t.InfoHash)
+	start := time.Now()
+	t.setChecking(true)
+	tt.VerifyData()
+	t.setChecking(false)
+
+	pieceLen := tt.Info().PieceLength
+	rp := &VerifyReport{
+		InfoHash: t.InfoHash,
+		Pieces:   tt.NumPieces(),
+	}
+	for i := 0; i < rp.Pieces; i++ {
+		if st := tt.PieceState(i); !st.Complete {
+			rp.BadPieces++
+		}
+	}
+	var roots map[string][]byte
+	if t.InfoHashV2 != "" {
+		roots = v2FileRoots(tt.Metainfo().InfoBytes)
+	}
+	for _, f := range tt.Files() {
+		fr := &FileReport{
+			Path:      t.e.renamedPath(t.InfoHash, f.Path()),
+			Size:      f.Length(),
+			Aligned:   pieceLen > 0 && f.Offset()%pieceLen == 0,
+			IsPadding: isPaddingFile(f.Path()),
+		}
+		for _, ps := range f.State() {
+			fr.Pieces++
+			if !ps.Complete {
+				fr.BadPieces++
+			}
+		}
+		// the incomplete files differ anyway
+		if root, ok := roots[f.Path()]; ok && fr.BadPieces == 0 {
+			match := merkleMatch(filepath.Join(t.dataDir(), filepath.FromSlash(fr.Path)), root)
+			fr.MerkleOK = &match
+		}
+		fr.OK = fr.BadPieces == 0 && (fr.MerkleOK == nil || *fr.MerkleOK)
+		rp.Files = append(rp.Files, fr)
+	}
+	rp.OK = rp.BadPieces == 0
+	for _, fr := range rp.Files {
+		rp.OK = rp.OK && fr.OK
+	}
+	rp.VerifiedAt = time.Now()
+	rp.Duration = rp.VerifiedAt.Sub(start)
+
+	t.Lock()
+	t.verifyReport = rp
+	t.Unlock()
+	log.Printf("[Verify]%s finished in %s, ok: %v, bad pieces: %d/%d", t.InfoHash, rp.Duration, rp.OK, rp.BadPieces, rp.Pieces)
+	return rp
+}
+
+// VerifyTorrent starts rehashing the task data in the background
+func (e *Engine) VerifyTorrent(infohash string) error {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+	if !t.Loaded {
+		return errors.New("Task info not loaded")
+	}
+	go t.verify()
+	return nil
+}
+
+// GetVerifyReport returns the last verification report of the task
+func (e *Engine) GetVerifyReport(infohash string) (*VerifyReport, error) {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	t.Lock()
+	defer t.Unlock()
+	if t.verifyReport == nil {
+		return nil, ErrNotVerified
+	}
+	return t.verifyReport, nil
+}
+
+func isPaddingFile(p string) bool {
+	return strings.HasPrefix(p, ".pad/") ||
+		strings.Contains(p, "/.pad/") ||
+		strings.HasPrefix(path.Base(p), "_____padding_file_")
+}
@@ -0,0 +1,126 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const volumeTick = 30 * time.Second
+
+var ErrVolumeUnavailable = errors.New("Download volume unavailable")
+
+// VolumeStatus tells whether the DownloadDirectory can be written, the
+// started tasks are paused while it can't (read-only or unmounted)
+type VolumeStatus struct {
+	Unavailable bool
+	Error       string
+	Since       time.Time
+}
+
+type volumeState struct {
+	sync.Mutex
+	status VolumeStatus
+	// the tasks paused, started again when the volume is back
+	paused []string
+}
+
+// Volume reports the state of the download volume
+func (e *Engine) Volume() VolumeStatus {
+	e.volume.Lock()
+	defer e.volume.Unlock()
+	return e.volume.status
+}
+
+func (e *Engine) volumeUnavailable() bool {
+	return e.Volume().Unavailable
+}
+
+// checkVolume tells why the download dir can't be written. The cache dir
+// inside is gone if the volume is unmounted, leaving the mount point.
+func checkVolume(dir, cacheDir string) error {
+	if st, err := os.Stat(cacheDir); err != nil || !st.IsDir() {
+		return fmt.Errorf("%w: %s is missing, not mounted?", ErrVolumeUnavailable, cacheDir)
+	}
+	f, err := os.CreateTemp(dir, ".writetest")
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrVolumeUnavailable, err)
+	}
+	f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		return fmt.Errorf("%w: %s", ErrVolumeUnavailable, err)
+	}
+	return nil
+}
+
+// volumeTask pauses the tasks when the download volume goes away, and
+// resumes them when it's back, every volumeTick
+func (e *Engine) volumeTask() error {
+	e.RLock()
+	dir, cacheDir := e.config.DownloadDirectory, e.cacheDir
+	configured := e.client != nil
+	e.RUnlock()
+	if !configured {
+		return nil
+	}
+	err := checkVolume(dir, cacheDir)
+	switch {
+	case err != nil && !e.volumeUnavailable():
+		e.volumeLost(err)
+	case err == nil && e.volumeUnavailable():
+		e.volumeBack()
+	}
+	return nil
+}
+
+func (e *Engine) volumeLost(err error) {
+	e.volume.Lock()
+	e.volume.status = VolumeStatus{Unavailable: true, Error: err.Error(), Since: time.Now()}
+	e.volume.Unlock()
+	log.Printf("[Volume] %s, pausing the tasks", err)
+
+	var paused []string
+	for ih, rt := range e.resumeTasks() {
+		if !rt.Started {
+			continue
+		}
+		if err := e.StopTorrent(ih); err != nil {
+			log.Println("[Volume]", ih, err)
+			continue
+		}
+		e.RLock()
+		t, terr := e.getTorrent(ih)
+		e.RUnlock()
+		if terr == nil {
+			t.Lock()
+			// not retried, resumed with the volume
+			t.Error = err.Error()
+			t.NextRetryAt = time.Time{}
+			t.Unlock()
+		}
+		paused = append(paused, ih)
+	}
+	e.volume.Lock()
+	e.volume.paused = paused
+	e.volume.Unlock()
+	e.notify(EventError, &Torrent{Name: e.config.DownloadDirectory}, err.Error())
+	e.TsChanged <- struct{}{}
+}
+
+func (e *Engine) volumeBack() {
+	e.volume.Lock()
+	paused := e.volume.paused
+	e.volume.status = VolumeStatus{}
+	e.volume.paused = nil
+	e.volume.Unlock()
+	log.Println("[Volume] download volume is back, resuming", len(paused), "tasks")
+
+	for _, ih := range paused {
+		if err := e.StartTorrent(ih); err != nil {
+			log.Println("[Volume]", ih, err)
+		}
+	}
+	e.TsChanged <- struct{}{}
+}
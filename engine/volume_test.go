@@ -0,0 +1,33 @@
+package engine
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_checkVolume(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(dir, cache string) error
+		wantErr bool
+	}{
+		{"mounted", func(dir, cache string) error { return os.Mkdir(cache, 0755) }, false},
+		{"unmounted", func(dir, cache string) error { return nil }, true},
+		{"cache is a file", func(dir, cache string) error { return os.WriteFile(cache, nil, 0644) }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cache := filepath.Join(dir, CachedTorrentDir)
+			if err := tt.setup(dir, cache); err != nil {
+				t.Fatal(err)
+			}
+			err := checkVolume(dir, cache)
+			if (err != nil) != tt.wantErr || err != nil && !errors.Is(err, ErrVolumeUnavailable) {
+				t.Errorf("checkVolume() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
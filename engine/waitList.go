@@ -32,7 +32,8 @@ func (l *syncList) Pop() interface{} {
 	return nil
 }
 
-func (l *syncList) Remove(ih string) {
+// Remove tells if ih was in the list
+func (l *syncList) Remove(ih string) bool {
 	l.Lock()
 	defer l.Unlock()
 
@@ -40,9 +41,21 @@ func (l *syncList) Remove(ih string) {
 		if elm, ok := temp.Value.(taskElem); ok && elm.ih == ih {
 			l.lst.Remove(temp)
 			log.Println("syncList removed ih", ih)
-			break
+			return true
 		}
 	}
+	return false
+}
+
+// Values lists the elements in order
+func (l *syncList) Values() []interface{} {
+	l.Lock()
+	defer l.Unlock()
+	vs := make([]interface{}, 0, l.lst.Len())
+	for temp := l.lst.Front(); temp != nil; temp = temp.Next() {
+		vs = append(vs, temp.Value)
+	}
+	return vs
 }
 
 func (l *syncList) Len() int {
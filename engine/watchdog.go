@@ -0,0 +1,167 @@
+package engine
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	EventWatchdog = "watchdog"
+
+	watchdogTick = time.Minute
+	// the client lock is taken by every peer message, held longer than
+	// this it's deadlocked
+	watchdogProbeWait    = 30 * time.Second
+	maxWatchdogIncidents = 20
+)
+
+// WatchdogIncident is a wedged torrent client detected by the watchdog
+type WatchdogIncident struct {
+	At        time.Time
+	Reason    string
+	Restarted bool
+	Error     string `json:",omitempty"`
+}
+
+type watchdogState struct {
+	sync.Mutex
+	lastBytes      int64
+	lastProgressAt time.Time
+	incidents      []WatchdogIncident
+}
+
+// watchdogSample is what the watchdog sees of the client on a tick
+type watchdogSample struct {
+	// the client lock didn't answer the probe
+	locked bool
+	// the useful bytes read and written by the client
+	bytes int64
+	// started tasks downloading, and the peers connected to them
+	downloading int
+	peers       int
+}
+
+// check tells why the client looks wedged, empty if it's fine. It's
+// wedged if the client lock is stuck, or the downloading tasks have had
+// no peer and no progress for the timeout.
+func (w *watchdogState) check(s watchdogSample, now time.Time, timeout time.Duration) string {
+	w.Lock()
+	defer w.Unlock()
+	if s.locked {
+		return fmt.Sprintf("torrent client not responding for %s", watchdogProbeWait)
+	}
+	if w.lastProgressAt.IsZero() || s.bytes != w.lastBytes || s.downloading == 0 || s.peers > 0 {
+		w.lastBytes = s.bytes
+		w.lastProgressAt = now
+		return ""
+	}
+	if now.Sub(w.lastProgressAt) < timeout {
+		return ""
+	}
+	// once for each period
+	w.lastProgressAt = now
+	return fmt.Sprintf("%d tasks without any peer or progress for %s", s.downloading, timeout)
+}
+
+func (w *watchdogState) record(in WatchdogIncident) {
+	w.Lock()
+	defer w.Unlock()
+	w.incidents = append(w.incidents, in)
+	if len(w.incidents) > maxWatchdogIncidents {
+		w.incidents = w.incidents[len(w.incidents)-maxWatchdogIncidents:]
+	}
+}
+
+// reset restarts the progress timer, after the client is replaced
+func (w *watchdogState) reset() {
+	w.Lock()
+	defer w.Unlock()
+	w.lastProgressAt = time.Time{}
+}
+
+// WatchdogIncidents lists the recent incidents, the oldest first
+func (e *Engine) WatchdogIncidents() []WatchdogIncident {
+	e.watchdog.Lock()
+	defer e.watchdog.Unlock()
+	return append([]WatchdogIncident(nil), e.watchdog.incidents...)
+}
+
+// watchdogRoutine looks for a torrent client stopped making progress, and
+// restarts the engine if WatchdogRestart
+func (e *Engine) watchdogRoutine() {
+	tk := time.NewTicker(watchdogTick)
+	defer tk.Stop()
+	for now := range tk.C {
+		e.RLock()
+		timeout := e.config.WatchdogTimeout
+		configured := e.client != nil
+		e.RUnlock()
+		if timeout <= 0 || !configured || e.inMaintenance() || e.RestartStatus().Restarting {
+			e.watchdog.reset()
+			continue
+		}
+		if reason := e.watchdog.check(e.watchdogSample(), now, timeout); reason != "" {
+			e.watchdogIncident(reason)
+		}
+	}
+}
+
+func (e *Engine) watchdogSample() watchdogSample {
+	var s watchdogSample
+	e.RLock()
+	client := e.client
+	for _, t := range e.ts {
+		t.Lock()
+		if t.Started && !t.Done && t.Error == "" {
+			s.downloading++
+			if t.Stats != nil {
+				s.peers += t.Stats.ActivePeers
+			}
+		}
+		t.Unlock()
+	}
+	e.RUnlock()
+	if client == nil {
+		return s
+	}
+
+	probe := make(chan int64, 1)
+	go func() {
+		st := client.ConnStats()
+		probe <- st.BytesReadUsefulData.Int64() + st.BytesWrittenData.Int64()
+	}()
+	select {
+	case s.bytes = <-probe:
+	case <-time.After(watchdogProbeWait):
+		s.locked = true
+	}
+	return s
+}
+
+func (e *Engine) watchdogIncident(reason string) {
+	log.Println("[Watchdog]", reason)
+	in := WatchdogIncident{At: time.Now(), Reason: reason}
+	e.RLock()
+	restart := e.config.WatchdogRestart
+	c := e.config
+	e.RUnlock()
+	if restart {
+		log.Println("[Watchdog] restarting the engine")
+		if err := e.Restart(&c); err != nil {
+			in.Error = err.Error()
+		} else {
+			in.Restarted = true
+		}
+		e.watchdog.reset()
+	}
+	e.watchdog.record(in)
+
+	msg := reason
+	if in.Restarted {
+		msg += ", engine restarted"
+	} else if in.Error != "" {
+		msg += ", restart failed: " + in.Error
+	}
+	e.notify(EventWatchdog, &Torrent{Name: "Torrent client"}, msg)
+}
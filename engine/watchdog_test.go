@@ -0,0 +1,43 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_watchdogState_check(t *testing.T) {
+	const timeout = 10 * time.Minute
+	start := time.Now()
+	tests := []struct {
+		name   string
+		sample watchdogSample
+		after  time.Duration
+		wedged bool
+	}{
+		{"locked", watchdogSample{locked: true}, time.Minute, true},
+		{"no progress", watchdogSample{bytes: 10, downloading: 2}, timeout, true},
+		{"within timeout", watchdogSample{bytes: 10, downloading: 2}, timeout - time.Second, false},
+		{"progress", watchdogSample{bytes: 20, downloading: 2}, timeout, false},
+		{"peers connected", watchdogSample{bytes: 10, downloading: 2, peers: 1}, timeout, false},
+		{"nothing downloading", watchdogSample{bytes: 10}, timeout, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var w watchdogState
+			w.check(watchdogSample{bytes: 10, downloading: 2}, start, timeout)
+			if got := w.check(tt.sample, start.Add(tt.after), timeout); (got != "") != tt.wedged {
+				t.Errorf("check() = %q, wedged %v", got, tt.wedged)
+			}
+		})
+	}
+}
+
+func Test_watchdogState_record(t *testing.T) {
+	var w watchdogState
+	for i := 0; i < maxWatchdogIncidents+5; i++ {
+		w.record(WatchdogIncident{Reason: string(rune('a' + i))})
+	}
+	if len(w.incidents) != maxWatchdogIncidents || w.incidents[0].Reason != "f" {
+		t.Errorf("record() kept %d, first %q", len(w.incidents), w.incidents[0].Reason)
+	}
+}
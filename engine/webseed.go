@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Web seeds are the http mirrors of the task data, the url-list of BEP 19,
+// from the torrent, the ws= params of the magnet, or added by AddWebSeed.
+// The httpseeds of BEP 17 are not supported by the torrent client.
+
+const taskWebSeedsExt = ".webseeds"
+
+var ErrInvalidWebSeed = errors.New("Invalid web seed url")
+
+// checkWebSeed tells if u is an http(s) url, a dir url (ending with /) is
+// the parent of the task dir for the multi-file torrents
+func checkWebSeed(u string) error {
+	pu, err := url.Parse(u)
+	if err != nil || (pu.Scheme != "http" && pu.Scheme != "https") || pu.Host == "" {
+		return fmt.Errorf("%w: %q", ErrInvalidWebSeed, u)
+	}
+	return nil
+}
+
+// addWebSeeds records the web seeds not known yet, with t locked
+func (t *Torrent) addWebSeeds(urls []string) {
+next:
+	for _, u := range urls {
+		for _, ws := range t.WebSeeds {
+			if ws == u {
+				continue next
+			}
+		}
+		t.WebSeeds = append(t.WebSeeds, u)
+	}
+}
+
+// webSeedsFileName is saved in the cache dir next to the task's torrent
+// file, the web seeds added by AddWebSeed one per line. The cached torrent
+// file is kept as added.
+func (e *Engine) webSeedsFileName(infohash string) string {
+	return filepath.Join(e.cacheDir, fmt.Sprintf("%s%s%s", cacheSavedPrefix, infohash, taskWebSeedsExt))
+}
+
+// loadWebSeeds reads the web seeds added to the task
+func (e *Engine) loadWebSeeds(infohash string) []string {
+	data, err := ioutil.ReadFile(e.webSeedsFileName(infohash))
+	if err != nil {
+		return nil
+	}
+	var urls []string
+	for _, u := range strings.Split(string(data), "\n") {
+		if u = strings.TrimSpace(u); u != "" && checkWebSeed(u) == nil {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+func (e *Engine) saveWebSeed(infohash, u string) error {
+	for _, ws := range e.loadWebSeeds(infohash) {
+		if ws == u {
+			return nil
+		}
+	}
+	f, err := os.OpenFile(e.webSeedsFileName(infohash), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(u + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (e *Engine) removeWebSeeds(infohash string) {
+	if err := os.Remove(e.webSeedsFileName(infohash)); err != nil && !os.IsNotExist(err) {
+		log.Println("[WebSeed]", infohash, err)
+	}
+}
+
+// AddWebSeed adds an http mirror to the task, saved along with the task so
+// it's kept over restarts, and used once a queued task is loaded
+func (e *Engine) AddWebSeed(infohash, u string) error {
+	u = strings.TrimSpace(u)
+	if err := checkWebSeed(u); err != nil {
+		return err
+	}
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	if err != nil {
+		e.RUnlock()
+		return err
+	}
+	tt, loaded := e.client.Torrent(metainfo.NewHashFromHex(infohash))
+	e.RUnlock()
+	if err := e.saveWebSeed(infohash, u); err != nil {
+		return err
+	}
+
+	if loaded {
+		// the merge sets the flags of the spec again, kept as the task has
+		// them: the data download is disallowed by the write errors
+		t.Lock()
+		spec := t.specFlags
+		spec.DisallowDataDownload = t.Error != ""
+		t.Unlock()
+		spec.Webseeds = []string{u}
+		if err := tt.MergeSpec(&spec); err != nil {
+			return err
+		}
+	}
+	if e.config.DisableWebseeds {
+		log.Println("[WebSeed]", infohash, "added but the web seeds are disabled")
+	}
+
+	t.Lock()
+	t.addWebSeeds([]string{u})
+	t.Unlock()
+	log.Println("[WebSeed]", infohash, "added", u)
+	e.TsChanged <- struct{}{}
+	return nil
+}
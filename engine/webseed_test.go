@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func Test_checkWebSeed(t *testing.T) {
+	tests := []struct {
+		u       string
+		wantErr bool
+	}{
+		{"http://example.com/data/", false},
+		{"https://example.com/a.iso", false},
+		{"ftp://example.com/a.iso", true},
+		{"http:///a.iso", true},
+		{"example.com/a.iso", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.u, func(t *testing.T) {
+			err := checkWebSeed(tt.u)
+			if (err != nil) != tt.wantErr || err != nil && !errors.Is(err, ErrInvalidWebSeed) {
+				t.Errorf("checkWebSeed() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEngine_saveWebSeed(t *testing.T) {
+	const ih = "0123456789abcdef0123456789abcdef01234567"
+	e := &Engine{cacheDir: t.TempDir()}
+	if got := e.loadWebSeeds(ih); got != nil {
+		t.Fatalf("loadWebSeeds() = %v, want none", got)
+	}
+	for _, u := range []string{"http://a/", "http://b/", "http://a/"} {
+		if err := e.saveWebSeed(ih, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := e.loadWebSeeds(ih), []string{"http://a/", "http://b/"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("loadWebSeeds() = %v, want %v", got, want)
+	}
+	e.removeWebSeeds(ih)
+	if got := e.loadWebSeeds(ih); got != nil {
+		t.Errorf("loadWebSeeds() after remove = %v, want none", got)
+	}
+}
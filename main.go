@@ -9,7 +9,10 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/boypt/simple-torrent/engine"
 	"github.com/boypt/simple-torrent/server"
+	"github.com/boypt/simple-torrent/service"
+	"github.com/boypt/simple-torrent/update"
 	"github.com/jpillora/opts"
 )
 
@@ -27,7 +30,16 @@ func main() {
 	o.Repo("https://github.com/boypt/simple-torrent")
 	o.PkgRepo()
 	o.SetLineWidth(96)
-	o.Parse()
+	o.AddCommand(opts.New(&service.Command{}).Name("service").
+		Summary("install|uninstall|start|stop the system service, flags before the subcommand are kept for the service"))
+	o.AddCommand(opts.New(update.NewCommand(VERSION)).Name("update").
+		Summary("install the latest release binary over this one, verified by its checksum"))
+	o.AddCommand(opts.New(&engine.EncryptCommand{}).Name("encrypt").
+		Summary("encrypt the secret read from the stdin with the key of CLOUD_TORRENT_SECRET_KEY(FILE), for the config"))
+	if po := o.Parse(); po.IsRunnable() {
+		po.RunFatal()
+		return
+	}
 
 	t := &server.TPLInfo{
 		Title:   s.Title,
@@ -41,7 +53,7 @@ func main() {
 	}
 
 	log.Print(t.GetInfo())
-	if err := s.Run(t); err != nil {
+	if err := service.Run(func() error { return s.Run(t) }); err != nil {
 		if errors.Is(err, server.ErrDiskSpace) {
 			log.Println(err)
 			os.Exit(42)
@@ -0,0 +1,205 @@
+package plugin
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const callTimeout = 60 * time.Second
+
+var (
+	ErrTimeout     = errors.New("plugin call timeout")
+	ErrNoSuchCap   = errors.New("no such plugin provider")
+	errInfoTimeout = errors.New("plugin didn't response Info")
+)
+
+// Client is a running plugin process
+type Client struct {
+	Path string
+	Info Info
+	cmd  *exec.Cmd
+	rpc  *rpc.Client
+}
+
+// Start runs the plugin program and asks for its Info
+func Start(path string) (*Client, error) {
+	cmd := exec.Command(path)
+	cmd.Env = append(os.Environ(), "CLD_PLUGIN=1")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		Path: path,
+		cmd:  cmd,
+		rpc:  jsonrpc.NewClient(stdio{stdout, stdin}),
+	}
+	go func() {
+		sc := bufio.NewScanner(stderr)
+		for sc.Scan() {
+			log.Printf("[%s] %s", path, sc.Text())
+		}
+	}()
+
+	if err := c.call("Info", Empty{}, &c.Info); err != nil {
+		c.Close()
+		if errors.Is(err, ErrTimeout) {
+			err = errInfoTimeout
+		}
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if c.Info.Name == "" {
+		c.Info.Name = path
+	}
+	return c, nil
+}
+
+func (c *Client) call(method string, args, reply interface{}) error {
+	call := c.rpc.Go("Plugin."+method, args, reply, nil)
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-time.After(callTimeout):
+		return ErrTimeout
+	}
+}
+
+// Has tells whether the plugin declared the capability
+func (c *Client) Has(capability string) bool {
+	for _, v := range c.Info.Capabilities {
+		if v == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Close stops the plugin process
+func (c *Client) Close() error {
+	c.rpc.Close()
+	if c.cmd.Process != nil {
+		c.cmd.Process.Kill() // nolint: errcheck
+	}
+	return c.cmd.Wait()
+}
+
+// Manager holds the loaded plugins, all methods are safe on a nil Manager
+type Manager struct {
+	sync.RWMutex
+	clients []*Client
+}
+
+// Load starts the plugins in the newline separated list of paths,
+// plugins failed to start are logged and skipped
+func Load(paths string) *Manager {
+	m := &Manager{}
+	for _, l := range strings.Split(paths, "\n") {
+		p := strings.TrimSpace(l)
+		if p == "" || strings.HasPrefix(p, "#") {
+			continue
+		}
+		c, err := Start(p)
+		if err != nil {
+			log.Println("failed to load", err)
+			continue
+		}
+		log.Printf("loaded %s %v", c.Info.Name, c.Info.Capabilities)
+		m.clients = append(m.clients, c)
+	}
+	return m
+}
+
+func (m *Manager) with(capability string) []*Client {
+	if m == nil {
+		return nil
+	}
+	m.RLock()
+	defer m.RUnlock()
+	var res []*Client
+	for _, c := range m.clients {
+		if c.Has(capability) {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// Notify sends the event to the notify plugins in the background
+func (m *Manager) Notify(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	for _, c := range m.with(CapNotify) {
+		go func(c *Client) {
+			if err := c.call("Notify", ev, &Empty{}); err != nil {
+				log.Printf("[%s] Notify: %s", c.Info.Name, err)
+			}
+		}(c)
+	}
+}
+
+// PostProcess calls the postprocess plugins one by one
+func (m *Manager) PostProcess(task Task) {
+	for _, c := range m.with(CapPostProcess) {
+		var r PostResult
+		if err := c.call("PostProcess", task, &r); err != nil {
+			log.Printf("[%s] PostProcess %s: %s", c.Info.Name, task.InfoHash, err)
+			continue
+		}
+		log.Printf("[%s] PostProcess %s: %s", c.Info.Name, task.InfoHash, r.Message)
+	}
+}
+
+// SearchProviders lists the names of the search plugins
+func (m *Manager) SearchProviders() []string {
+	var names []string
+	for _, c := range m.with(CapSearch) {
+		names = append(names, c.Info.Name)
+	}
+	return names
+}
+
+// Search queries the search plugin by name
+func (m *Manager) Search(name string, q SearchQuery) ([]SearchResult, error) {
+	for _, c := range m.with(CapSearch) {
+		if c.Info.Name == name {
+			var r []SearchResult
+			err := c.call("Search", q, &r)
+			return r, err
+		}
+	}
+	return nil, ErrNoSuchCap
+}
+
+// Close stops all the plugins
+func (m *Manager) Close() {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	for _, c := range m.clients {
+		c.Close() // nolint: errcheck
+	}
+	m.clients = nil
+}
@@ -0,0 +1,134 @@
+// Package plugin runs external programs as plugins of simple-torrent.
+//
+// A plugin is an executable speaking JSON-RPC over its stdin/stdout, serving
+// the methods of the "Plugin" service: Info, Notify, PostProcess and Search.
+// Plugins written in Go only need to implement the Plugin interface and call
+// Serve in main. Anything written to stderr goes to the simple-torrent log.
+package plugin
+
+import (
+	"io"
+	stdlog "log"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"time"
+)
+
+// Capabilities a plugin declares in Info
+const (
+	CapNotify      = "notify"
+	CapPostProcess = "postprocess"
+	CapSearch      = "search"
+)
+
+var log *stdlog.Logger
+
+// Info describes a plugin
+type Info struct {
+	Name         string
+	Capabilities []string
+}
+
+// Event is sent to the notify plugins on task events (add, complete, stalled, error),
+// and the watchdog incidents of the torrent client
+type Event struct {
+	Type     string
+	InfoHash string
+	Name     string
+	Size     int64
+	Labels   []string
+	Message  string
+	Time     time.Time
+}
+
+// Task is sent to the postprocess plugins when a task finished
+type Task struct {
+	InfoHash  string
+	Name      string
+	Directory string
+	Size      int64
+	Labels    []string
+	Verified  string
+}
+
+// PostResult is the result of a post processing
+type PostResult struct {
+	Message string
+}
+
+// SearchQuery is sent to the search plugins
+type SearchQuery struct {
+	Query string
+	Page  int
+}
+
+// SearchResult has the same fields as the scraper results:
+// name, magnet, infohash, torrent, size, seeds, peers, url
+type SearchResult map[string]string
+
+// Empty is the placeholder args/reply
+type Empty struct{}
+
+// Plugin is implemented by the plugin programs, see Serve
+type Plugin interface {
+	Info() Info
+	Notify(ev Event) error
+	PostProcess(task Task) (PostResult, error)
+	Search(q SearchQuery) ([]SearchResult, error)
+}
+
+// service adapts a Plugin to net/rpc
+type service struct {
+	p Plugin
+}
+
+func (s *service) Info(_ Empty, reply *Info) error {
+	*reply = s.p.Info()
+	return nil
+}
+
+func (s *service) Notify(ev Event, _ *Empty) error {
+	return s.p.Notify(ev)
+}
+
+func (s *service) PostProcess(task Task, reply *PostResult) error {
+	r, err := s.p.PostProcess(task)
+	*reply = r
+	return err
+}
+
+func (s *service) Search(q SearchQuery, reply *[]SearchResult) error {
+	r, err := s.p.Search(q)
+	*reply = r
+	return err
+}
+
+// Serve serves the plugin on stdin/stdout, blocks until stdin is closed
+func Serve(p Plugin) error {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("Plugin", &service{p}); err != nil {
+		return err
+	}
+	srv.ServeCodec(jsonrpc.NewServerCodec(stdio{os.Stdin, os.Stdout}))
+	return nil
+}
+
+// stdio joins the pipes of a process into a connection
+type stdio struct {
+	io.ReadCloser
+	io.WriteCloser
+}
+
+func (s stdio) Close() error {
+	s.WriteCloser.Close()
+	return s.ReadCloser.Close()
+}
+
+func init() {
+	log = stdlog.New(os.Stdout, "[plugin]", stdlog.LstdFlags|stdlog.Lmsgprefix)
+}
+
+func SetLoggerFlag(flag int) {
+	log.SetFlags(flag)
+}
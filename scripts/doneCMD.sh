@@ -8,6 +8,7 @@
 # - ${CLD_RESTAPI}
 # - ${CLD_SIZE}
 # - ${CLD_STARTTS}
+# - ${CLD_VERIFY}
 LOCALPATH="${CLD_DIR}/${CLD_PATH}"
 NOWTS=$(date +%s)
 
@@ -70,6 +70,7 @@ if [[ -z $ARCH ]]; then
 fi
 
 pushd $__dir/..
+./scripts/precompress.sh
 BINFILE=${BINPREFIX}${BIN}_${OS}_${ARCH}${SUFFIX}${OSSUFFIX}
 CGO_ENABLED=$CGO GOARCH=$ARCH GOOS=$OS \
 	go build -o ${BINFILE} \
@@ -81,6 +82,14 @@ if [[ ! -f ${BINFILE} ]]; then
   exit 1
 fi
 
+PKGFILE=${BINFILE}
 if [[ ! -z $PKGCMD ]]; then
   ${PKGCMD} -v -9 ${BINFILE}
+  case $PKGCMD in
+    gzip) PKGFILE=${BINFILE}.gz ;;
+    xz) PKGFILE=${BINFILE}.xz ;;
+  esac
 fi
+
+# checked by the self-update (cloud-torrent update)
+(cd $(dirname ${PKGFILE}) && sha256sum $(basename ${PKGFILE}) > $(basename ${PKGFILE}).sha256)
@@ -0,0 +1,14 @@
+#!/bin/bash
+# Writes the .gz and .br (if the brotli command is found) variants of the
+# text assets in static/files, embedded and served to the browsers
+# accepting them. Run before the build, the variants are not committed.
+__dir="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
+cd "$__dir/../static/files" || exit 1
+
+command -v brotli >/dev/null || echo "brotli not found, writing the .gz only"
+find . -type f \( -name '*.js' -o -name '*.css' -o -name '*.html' -o -name '*.svg' \) | while read -r f; do
+	gzip -k -f -9 "$f"
+	if command -v brotli >/dev/null; then
+		brotli -k -f -q 11 "$f"
+	fi
+done
@@ -0,0 +1,248 @@
+// Package apiv1 is the versioned REST API under /api/v1/. Its request and
+// response types are a stable contract for the third-party tools, kept apart
+// from the engine and velox state structs which change with the internals.
+//
+//	GET    /api/v1/torrents              list the tasks
+//	GET    /api/v1/torrents/{ih}         a task
+//	POST   /api/v1/torrents              add a task by an AddRequest
+//	POST   /api/v1/torrents/{ih}/start   start a task
+//	POST   /api/v1/torrents/{ih}/stop    stop a task
+//	POST   /api/v1/torrents/{ih}/verify  recheck the data of a task
+//	DELETE /api/v1/torrents/{ih}         remove a task
+//	GET    /api/v1/stats                 the transfer stats
+//
+// The errors are an Error body with the status code.
+package apiv1
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const Prefix = "/api/v1/"
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("conflict")
+	ErrInvalid  = errors.New("invalid request")
+)
+
+// Torrent is a task, its Status one of queued, checking, downloading, seeding,
+// paused, stalled, error or moving
+type Torrent struct {
+	InfoHash   string  `json:"infohash"`
+	Name       string  `json:"name"`
+	Size       int64   `json:"size"`
+	Downloaded int64   `json:"downloaded"`
+	Uploaded   int64   `json:"uploaded"`
+	Percent    float32 `json:"percent"`
+	// bytes per second
+	DownloadRate float32   `json:"download_rate"`
+	UploadRate   float32   `json:"upload_rate"`
+	Started      bool      `json:"started"`
+	Done         bool      `json:"done"`
+	Status       string    `json:"status"`
+	Error        string    `json:"error"`
+	Labels       []string  `json:"labels"`
+	AddedAt      time.Time `json:"added_at"`
+	Files        []File    `json:"files"`
+}
+
+// File is a file of a task, the Path is relative to the task
+type File struct {
+	Path      string  `json:"path"`
+	Size      int64   `json:"size"`
+	Completed int64   `json:"completed"`
+	Percent   float32 `json:"percent"`
+	Done      bool    `json:"done"`
+}
+
+// AddRequest adds a task by one of Magnet, URL (of a .torrent) or Torrent
+// (the .torrent bytes, base64 in the JSON)
+type AddRequest struct {
+	Magnet  string     `json:"magnet,omitempty"`
+	URL     string     `json:"url,omitempty"`
+	Torrent []byte     `json:"torrent,omitempty"`
+	Paused  *bool      `json:"paused,omitempty"`
+	StartAt *time.Time `json:"start_at,omitempty"`
+	// add even if the task looks like a duplicate
+	Force bool `json:"force,omitempty"`
+}
+
+// Stats are the transfer stats of all the tasks
+type Stats struct {
+	Torrents    int `json:"torrents"`
+	Downloading int `json:"downloading"`
+	Seeding     int `json:"seeding"`
+	// bytes per second
+	DownloadRate float32 `json:"download_rate"`
+	UploadRate   float32 `json:"upload_rate"`
+	// bytes of the data since the client started
+	BytesRead    int64 `json:"bytes_read"`
+	BytesWritten int64 `json:"bytes_written"`
+	// the count of the tasks by status
+	Statuses map[string]int `json:"statuses,omitempty"`
+}
+
+// Error is the body of the failed requests
+type Error struct {
+	Error string `json:"error"`
+}
+
+// Backend does the requests, its errors wrap ErrNotFound, ErrConflict or
+// ErrInvalid for the status codes other than 500
+type Backend interface {
+	Torrents() []Torrent
+	Torrent(infohash string) (Torrent, error)
+	Add(req AddRequest) error
+	Start(infohash string) error
+	Stop(infohash string) error
+	Verify(infohash string) error
+	Delete(infohash string) error
+	Stats() Stats
+}
+
+type handler struct {
+	b Backend
+}
+
+// Handler serves the paths under Prefix by b
+func Handler(b Backend) http.Handler {
+	return &handler{b: b}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, Prefix), "/"), "/")
+	switch {
+	case route[0] == "stats" && len(route) == 1:
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w)
+			return
+		}
+		writeJSON(w, http.StatusOK, h.b.Stats())
+	case route[0] == "torrents" && len(route) == 1:
+		h.torrents(w, r)
+	case route[0] == "torrents" && len(route) == 2:
+		h.torrent(w, r, route[1])
+	case route[0] == "torrents" && len(route) == 3:
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w)
+			return
+		}
+		var err error
+		switch route[2] {
+		case "start":
+			err = h.b.Start(route[1])
+		case "stop":
+			err = h.b.Stop(route[1])
+		case "verify":
+			err = h.b.Verify(route[1])
+		default:
+			writeError(w, ErrNotFound)
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, ErrNotFound)
+	}
+}
+
+func (h *handler) torrents(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		ts := filterStatus(h.b.Torrents(), r.URL.Query().Get("status"))
+		writeJSON(w, http.StatusOK, ts)
+	case http.MethodPost:
+		var req AddRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, ErrInvalid)
+			return
+		}
+		n := 0
+		for _, set := range []bool{req.Magnet != "", req.URL != "", len(req.Torrent) > 0} {
+			if set {
+				n++
+			}
+		}
+		if n != 1 {
+			writeJSON(w, http.StatusBadRequest, Error{"one of magnet, url or torrent is required"})
+			return
+		}
+		if err := h.b.Add(req); err != nil {
+			writeError(w, err)
+			return
+		}
+		// the task may be queued, or still looking for its metadata
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		methodNotAllowed(w)
+	}
+}
+
+// filterStatus keeps the tasks of the comma separated statuses, all if empty
+func filterStatus(ts []Torrent, statuses string) []Torrent {
+	res := []Torrent{}
+	if statuses == "" {
+		return append(res, ts...)
+	}
+	want := make(map[string]bool)
+	for _, st := range strings.Split(statuses, ",") {
+		want[strings.TrimSpace(st)] = true
+	}
+	for _, t := range ts {
+		if want[t.Status] {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+func (h *handler) torrent(w http.ResponseWriter, r *http.Request, infohash string) {
+	switch r.Method {
+	case http.MethodGet:
+		t, err := h.b.Torrent(infohash)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, t)
+	case http.MethodDelete:
+		if err := h.b.Delete(infohash); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, ErrConflict):
+		code = http.StatusConflict
+	case errors.Is(err, ErrInvalid):
+		code = http.StatusBadRequest
+	}
+	writeJSON(w, code, Error{err.Error()})
+}
+
+func methodNotAllowed(w http.ResponseWriter) {
+	writeJSON(w, http.StatusMethodNotAllowed, Error{"method not allowed"})
+}
@@ -0,0 +1,145 @@
+package apiv1
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testHash = "0123456789abcdef0123456789abcdef01234567"
+
+type fakeBackend struct {
+	added []AddRequest
+	calls []string
+}
+
+func (f *fakeBackend) Torrents() []Torrent {
+	t, _ := f.Torrent(testHash)
+	return []Torrent{t}
+}
+
+func (f *fakeBackend) Torrent(ih string) (Torrent, error) {
+	if ih != testHash {
+		return Torrent{}, fmt.Errorf("%w: %s", ErrNotFound, ih)
+	}
+	return Torrent{
+		InfoHash:     testHash,
+		Name:         "ubuntu.iso",
+		Size:         100,
+		Downloaded:   50,
+		Uploaded:     10,
+		Percent:      50,
+		DownloadRate: 1024,
+		Started:      true,
+		Status:       "downloading",
+		Labels:       []string{"linux"},
+		AddedAt:      time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC),
+		Files:        []File{{Path: "ubuntu.iso", Size: 100, Completed: 50, Percent: 50}},
+	}, nil
+}
+
+func (f *fakeBackend) Add(req AddRequest) error {
+	if req.Magnet == "magnet:?xt=urn:btih:"+testHash {
+		return fmt.Errorf("%w: Task already exists", ErrConflict)
+	}
+	f.added = append(f.added, req)
+	return nil
+}
+
+func (f *fakeBackend) call(name, ih string) error {
+	if ih != testHash {
+		return fmt.Errorf("%w: %s", ErrNotFound, ih)
+	}
+	f.calls = append(f.calls, name)
+	return nil
+}
+
+func (f *fakeBackend) Start(ih string) error  { return f.call("start", ih) }
+func (f *fakeBackend) Stop(ih string) error   { return f.call("stop", ih) }
+func (f *fakeBackend) Verify(ih string) error { return f.call("verify", ih) }
+func (f *fakeBackend) Delete(ih string) error { return f.call("delete", ih) }
+
+func (f *fakeBackend) Stats() Stats {
+	return Stats{Torrents: 1, Downloading: 1, DownloadRate: 1024, BytesRead: 50}
+}
+
+// TestContract locks the paths, status codes and the JSON bodies, a change
+// here breaks the clients of the v1
+func TestContract(t *testing.T) {
+	tests := []struct {
+		method, path, body string
+		code               int
+		want               string
+	}{
+		{"GET", "/api/v1/torrents", "", http.StatusOK,
+			`[{"infohash":"` + testHash + `","name":"ubuntu.iso","size":100,"downloaded":50,"uploaded":10,"percent":50,"download_rate":1024,"upload_rate":0,"started":true,"done":false,"status":"downloading","error":"","labels":["linux"],"added_at":"2021-12-01T00:00:00Z","files":[{"path":"ubuntu.iso","size":100,"completed":50,"percent":50,"done":false}]}]`},
+		{"GET", "/api/v1/torrents/" + testHash, "", http.StatusOK,
+			`{"infohash":"` + testHash + `","name":"ubuntu.iso","size":100,"downloaded":50,"uploaded":10,"percent":50,"download_rate":1024,"upload_rate":0,"started":true,"done":false,"status":"downloading","error":"","labels":["linux"],"added_at":"2021-12-01T00:00:00Z","files":[{"path":"ubuntu.iso","size":100,"completed":50,"percent":50,"done":false}]}`},
+		{"GET", "/api/v1/torrents?status=seeding,paused", "", http.StatusOK, `[]`},
+		{"GET", "/api/v1/torrents/missing", "", http.StatusNotFound, `{"error":"not found: missing"}`},
+		{"GET", "/api/v1/stats", "", http.StatusOK,
+			`{"torrents":1,"downloading":1,"seeding":0,"download_rate":1024,"upload_rate":0,"bytes_read":50,"bytes_written":0}`},
+		{"POST", "/api/v1/torrents", `{"magnet":"magnet:?xt=urn:btih:abc","paused":true}`, http.StatusAccepted, ``},
+		{"POST", "/api/v1/torrents", `{"magnet":"magnet:?xt=urn:btih:` + testHash + `"}`, http.StatusConflict,
+			`{"error":"conflict: Task already exists"}`},
+		{"POST", "/api/v1/torrents", `{}`, http.StatusBadRequest, `{"error":"one of magnet, url or torrent is required"}`},
+		{"POST", "/api/v1/torrents", `{"magnet":"m","url":"u"}`, http.StatusBadRequest, `{"error":"one of magnet, url or torrent is required"}`},
+		{"POST", "/api/v1/torrents", `not json`, http.StatusBadRequest, `{"error":"invalid request"}`},
+		{"POST", "/api/v1/torrents/" + testHash + "/start", "", http.StatusNoContent, ``},
+		{"POST", "/api/v1/torrents/" + testHash + "/stop", "", http.StatusNoContent, ``},
+		{"POST", "/api/v1/torrents/" + testHash + "/verify", "", http.StatusNoContent, ``},
+		{"POST", "/api/v1/torrents/missing/start", "", http.StatusNotFound, `{"error":"not found: missing"}`},
+		{"POST", "/api/v1/torrents/" + testHash + "/unknown", "", http.StatusNotFound, `{"error":"not found"}`},
+		{"DELETE", "/api/v1/torrents/" + testHash, "", http.StatusNoContent, ``},
+		{"PUT", "/api/v1/torrents", "", http.StatusMethodNotAllowed, `{"error":"method not allowed"}`},
+		{"GET", "/api/v1/unknown", "", http.StatusNotFound, `{"error":"not found"}`},
+	}
+	b := &fakeBackend{}
+	srv := httptest.NewServer(Handler(b))
+	defer srv.Close()
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, srv.URL+tt.path, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			if resp.StatusCode != tt.code {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.code)
+			}
+			if got := strings.TrimSpace(string(body)); got != tt.want {
+				t.Errorf("body = %s, want %s", got, tt.want)
+			}
+			if tt.want != "" && resp.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("Content-Type = %q", resp.Header.Get("Content-Type"))
+			}
+		})
+	}
+	if len(b.added) != 1 || b.added[0].Paused == nil || !*b.added[0].Paused {
+		t.Errorf("added = %+v", b.added)
+	}
+	if got := strings.Join(b.calls, ","); got != "start,stop,verify,delete" {
+		t.Errorf("calls = %s", got)
+	}
+}
+
+func TestAddRequestTorrent(t *testing.T) {
+	var req AddRequest
+	if err := json.Unmarshal([]byte(`{"torrent":"ZDhlOmFubm91bmNl","start_at":"2021-12-24T20:00:00Z","force":true}`), &req); err != nil {
+		t.Fatal(err)
+	}
+	if string(req.Torrent) != "d8e:announce" || !req.Force || req.StartAt == nil ||
+		!req.StartAt.Equal(time.Date(2021, 12, 24, 20, 0, 0, 0, time.UTC)) {
+		t.Errorf("req = %+v", req)
+	}
+}
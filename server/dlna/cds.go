@@ -0,0 +1,229 @@
+package dlna
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type soapEnvelope struct {
+	Body struct {
+		Action struct {
+			XMLName        xml.Name
+			ObjectID       string
+			BrowseFlag     string
+			StartingIndex  int
+			RequestedCount int
+		} `xml:",any"`
+	}
+}
+
+func soapAction(r *http.Request) string {
+	// SOAPACTION: "urn:schemas-upnp-org:service:ContentDirectory:1#Browse"
+	sa := strings.Trim(r.Header.Get("SOAPACTION"), `"`)
+	if i := strings.LastIndex(sa, "#"); i >= 0 {
+		return sa[i+1:]
+	}
+	return sa
+}
+
+func writeSOAP(w http.ResponseWriter, service, action string, args [][2]string) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Header().Set("EXT", "")
+	var sb strings.Builder
+	for _, a := range args {
+		fmt.Fprintf(&sb, "<%s>%s</%s>", a[0], xmlEscape(a[1]), a[0])
+	}
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>`+
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">`+
+		`<s:Body><u:%sResponse xmlns:u="%s">%s</u:%sResponse></s:Body></s:Envelope>`,
+		action, service, sb.String(), action)
+}
+
+func soapError(w http.ResponseWriter, code int, desc string) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>`+
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">`+
+		`<s:Body><s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail>`+
+		`<UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>%s</errorDescription></UPnPError>`+
+		`</detail></s:Fault></s:Body></s:Envelope>`, code, xmlEscape(desc))
+}
+
+func (s *Server) serveConnectionManager(w http.ResponseWriter, r *http.Request) {
+	switch soapAction(r) {
+	case "GetProtocolInfo":
+		writeSOAP(w, cmsType, "GetProtocolInfo", [][2]string{
+			{"Source", "http-get:*:video/*:*,http-get:*:audio/*:*,http-get:*:image/*:*"},
+			{"Sink", ""},
+		})
+	case "GetCurrentConnectionIDs":
+		writeSOAP(w, cmsType, "GetCurrentConnectionIDs", [][2]string{{"ConnectionIDs", "0"}})
+	default:
+		soapError(w, 401, "Invalid Action")
+	}
+}
+
+func (s *Server) serveContentDirectory(w http.ResponseWriter, r *http.Request) {
+	switch soapAction(r) {
+	case "GetSystemUpdateID":
+		writeSOAP(w, cdsType, "GetSystemUpdateID", [][2]string{{"Id", "1"}})
+	case "GetSortCapabilities":
+		writeSOAP(w, cdsType, "GetSortCapabilities", [][2]string{{"SortCaps", ""}})
+	case "GetSearchCapabilities":
+		writeSOAP(w, cdsType, "GetSearchCapabilities", [][2]string{{"SearchCaps", ""}})
+	case "Browse":
+		s.browse(w, r)
+	default:
+		soapError(w, 401, "Invalid Action")
+	}
+}
+
+func (s *Server) browse(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 64<<10))
+	if err != nil {
+		soapError(w, 402, "Invalid Args")
+		return
+	}
+	var env soapEnvelope
+	if err := xml.Unmarshal(body, &env); err != nil {
+		soapError(w, 402, "Invalid Args")
+		return
+	}
+	args := env.Body.Action
+	p, ok := s.localPath(args.ObjectID)
+	if !ok {
+		soapError(w, 701, "No such object")
+		return
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		soapError(w, 701, "No such object")
+		return
+	}
+
+	host := r.Host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, strconv.Itoa(s.port))
+	}
+
+	var objs []string
+	total := 1
+	if args.BrowseFlag == "BrowseMetadata" {
+		objs = append(objs, s.didlObject(host, args.ObjectID, fi))
+	} else {
+		files, err := ioutil.ReadDir(p)
+		if err != nil {
+			soapError(w, 701, "No such object")
+			return
+		}
+		var children []os.FileInfo
+		for _, f := range files {
+			if isHidden(f.Name()) || (!f.IsDir() && mediaClass(f.Name()) == "") {
+				continue
+			}
+			children = append(children, f)
+		}
+		sort.Slice(children, func(i, j int) bool {
+			if children[i].IsDir() != children[j].IsDir() {
+				return children[i].IsDir()
+			}
+			return children[i].Name() < children[j].Name()
+		})
+		total = len(children)
+		start := args.StartingIndex
+		if start > total {
+			start = total
+		}
+		end := total
+		if args.RequestedCount > 0 && start+args.RequestedCount < end {
+			end = start + args.RequestedCount
+		}
+		for _, f := range children[start:end] {
+			objs = append(objs, s.didlObject(host, childID(args.ObjectID, f.Name()), f))
+		}
+	}
+
+	didl := `<DIDL-Lite xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/" ` +
+		`xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/">` + strings.Join(objs, "") + `</DIDL-Lite>`
+	writeSOAP(w, cdsType, "Browse", [][2]string{
+		{"Result", didl},
+		{"NumberReturned", strconv.Itoa(len(objs))},
+		{"TotalMatches", strconv.Itoa(total)},
+		{"UpdateID", "1"},
+	})
+}
+
+func childID(parent, name string) string {
+	if parent == "0" || parent == "" {
+		return name
+	}
+	return parent + "/" + name
+}
+
+func parentID(id string) string {
+	if id == "0" {
+		return "-1"
+	}
+	if i := strings.LastIndex(id, "/"); i >= 0 {
+		return id[:i]
+	}
+	return "0"
+}
+
+func (s *Server) didlObject(host, id string, fi os.FileInfo) string {
+	title := xmlEscape(fi.Name())
+	if id == "0" {
+		title = xmlEscape(s.FriendlyName)
+	}
+	if fi.IsDir() {
+		return fmt.Sprintf(`<container id="%s" parentID="%s" restricted="1"><dc:title>%s</dc:title>`+
+			`<upnp:class>object.container.storageFolder</upnp:class></container>`,
+			xmlEscape(id), xmlEscape(parentID(id)), title)
+	}
+	mimeType := mime.TypeByExtension(filepath.Ext(fi.Name()))
+	if i := strings.Index(mimeType, ";"); i >= 0 {
+		mimeType = mimeType[:i]
+	}
+	res := (&url.URL{Scheme: "http", Host: host, Path: path.Join("/res", id)}).String()
+	return fmt.Sprintf(`<item id="%s" parentID="%s" restricted="1"><dc:title>%s</dc:title>`+
+		`<upnp:class>%s</upnp:class><res size="%d" protocolInfo="http-get:*:%s:*">%s</res></item>`,
+		xmlEscape(id), xmlEscape(parentID(id)), title, mediaClass(fi.Name()),
+		fi.Size(), mimeType, xmlEscape(res))
+}
+
+func mediaClass(name string) string {
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".mkv", ".ts", ".m2ts":
+		return "object.item.videoItem"
+	case ".flac", ".ape":
+		return "object.item.audioItem.musicTrack"
+	default:
+		m := mime.TypeByExtension(ext)
+		switch {
+		case strings.HasPrefix(m, "video/"):
+			return "object.item.videoItem"
+		case strings.HasPrefix(m, "audio/"):
+			return "object.item.audioItem.musicTrack"
+		case strings.HasPrefix(m, "image/"):
+			return "object.item.imageItem.photo"
+		}
+	}
+	return ""
+}
+
+func xmlEscape(s string) string {
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(s)) // nolint: errcheck
+	return sb.String()
+}
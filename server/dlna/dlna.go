@@ -0,0 +1,120 @@
+// Package dlna is a minimal DLNA/UPnP media server, exposing a directory
+// to smart TVs and consoles on the LAN: SSDP discovery, the device
+// description and the ContentDirectory Browse action.
+package dlna
+
+import (
+	"crypto/md5"
+	"fmt"
+	stdlog "log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	deviceType  = "urn:schemas-upnp-org:device:MediaServer:1"
+	cdsType     = "urn:schemas-upnp-org:service:ContentDirectory:1"
+	cmsType     = "urn:schemas-upnp-org:service:ConnectionManager:1"
+	serverHdr   = "Linux/3.x UPnP/1.0 SimpleTorrent/1.0"
+	notifyEvery = 15 * time.Minute
+	maxAge      = 1800
+)
+
+var log = stdlog.New(os.Stdout, "[dlna]", stdlog.LstdFlags|stdlog.Lmsgprefix)
+
+// SetLoggerFlag set flags of the package logger
+func SetLoggerFlag(flag int) {
+	log.SetFlags(flag)
+}
+
+// Server serves RootDir to the DLNA clients
+type Server struct {
+	FriendlyName string
+	RootDir      string
+	Port         int
+	uuid         string
+	port         int
+}
+
+// uuid is stable for the same host and name, so the clients remember the server
+func stableUUID(name string) string {
+	host, _ := os.Hostname()
+	h := md5.Sum([]byte(host + "|" + name))
+	return fmt.Sprintf("%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
+
+// Run serves the http and ssdp, blocks until an error occurs
+func (s *Server) Run() error {
+	s.uuid = stableUUID(s.FriendlyName)
+	l, err := net.Listen("tcp4", fmt.Sprintf(":%d", s.Port))
+	if err != nil {
+		return err
+	}
+	s.port = l.Addr().(*net.TCPAddr).Port
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rootDesc.xml", s.serveRootDesc)
+	mux.HandleFunc("/ContentDirectory.xml", serveXML(cdsSCPD))
+	mux.HandleFunc("/ConnectionManager.xml", serveXML(cmsSCPD))
+	mux.HandleFunc("/ctl/ContentDirectory", s.serveContentDirectory)
+	mux.HandleFunc("/ctl/ConnectionManager", s.serveConnectionManager)
+	mux.Handle("/res/", http.StripPrefix("/res/", http.HandlerFunc(s.serveResource)))
+
+	errc := make(chan error, 2)
+	go func() {
+		errc <- http.Serve(l, mux)
+	}()
+	go func() {
+		errc <- s.ssdp()
+	}()
+	log.Printf("serving %s as %q on port %d", s.RootDir, s.FriendlyName, s.port)
+	return <-errc
+}
+
+// localPath maps an object id to the file path, "0" is the root
+func (s *Server) localPath(id string) (string, bool) {
+	if id == "0" || id == "" {
+		return s.RootDir, true
+	}
+	p := filepath.Join(s.RootDir, filepath.FromSlash(path.Clean("/"+id)))
+	if !strings.HasPrefix(p, s.RootDir) {
+		return "", false
+	}
+	return p, true
+}
+
+func (s *Server) serveResource(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.localPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if fi, err := os.Stat(p); err != nil || fi.IsDir() || isHidden(fi.Name()) {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("transferMode.dlna.org", "Streaming")
+	w.Header().Set("contentFeatures.dlna.org", "DLNA.ORG_OP=01;DLNA.ORG_CI=0;DLNA.ORG_FLAGS=01700000000000000000000000000000")
+	http.ServeFile(w, r, p)
+}
+
+func (s *Server) serveRootDesc(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	fmt.Fprintf(w, rootDescTPL, xmlEscape(s.FriendlyName), s.uuid)
+}
+
+func serveXML(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		fmt.Fprint(w, body)
+	}
+}
+
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
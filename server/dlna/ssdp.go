@@ -0,0 +1,246 @@
+package dlna
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const ssdpAddr = "239.255.255.250:1900"
+
+// the notification types advertised, besides the uuid itself
+var ssdpTargets = []string{"upnp:rootdevice", deviceType, cdsType, cmsType}
+
+func (s *Server) usn(nt string) string {
+	if nt == "uuid:"+s.uuid {
+		return nt
+	}
+	return "uuid:" + s.uuid + "::" + nt
+}
+
+func (s *Server) location(ip net.IP) string {
+	return fmt.Sprintf("http://%s/rootDesc.xml", net.JoinHostPort(ip.String(), fmt.Sprint(s.port)))
+}
+
+// ssdp answers the M-SEARCH requests and sends the alive notifications
+func (s *Server) ssdp() error {
+	gaddr, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, gaddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	go s.notifyRoutine(gaddr)
+
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+		if err != nil || req.Method != "M-SEARCH" || req.Header.Get("MAN") != `"ssdp:discover"` {
+			continue
+		}
+		go s.answerSearch(req.Header.Get("ST"), from)
+	}
+}
+
+func (s *Server) answerSearch(st string, to *net.UDPAddr) {
+	var sts []string
+	switch st {
+	case "ssdp:all":
+		sts = append([]string{"uuid:" + s.uuid}, ssdpTargets...)
+	case "uuid:" + s.uuid:
+		sts = []string{st}
+	default:
+		for _, t := range ssdpTargets {
+			if t == st {
+				sts = []string{st}
+			}
+		}
+	}
+	if len(sts) == 0 {
+		return
+	}
+
+	// the local address on the route to the client
+	c, err := net.DialUDP("udp4", nil, to)
+	if err != nil {
+		log.Println("ssdp:", err)
+		return
+	}
+	defer c.Close()
+	ip := c.LocalAddr().(*net.UDPAddr).IP
+	for _, t := range sts {
+		msg := strings.Join([]string{
+			"HTTP/1.1 200 OK",
+			fmt.Sprintf("CACHE-CONTROL: max-age=%d", maxAge),
+			"DATE: " + time.Now().UTC().Format(http.TimeFormat),
+			"EXT:",
+			"LOCATION: " + s.location(ip),
+			"SERVER: " + serverHdr,
+			"ST: " + t,
+			"USN: " + s.usn(t),
+			"", "",
+		}, "\r\n")
+		if _, err := c.Write([]byte(msg)); err != nil {
+			log.Println("ssdp:", err)
+			return
+		}
+	}
+}
+
+func (s *Server) notifyRoutine(gaddr *net.UDPAddr) {
+	for {
+		s.notifyAlive(gaddr)
+		time.Sleep(notifyEvery)
+	}
+}
+
+// notifyAlive multicasts ssdp:alive from every up ipv4 interface
+func (s *Server) notifyAlive(gaddr *net.UDPAddr) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		log.Println("ssdp:", err)
+		return
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			ipn, ok := a.(*net.IPNet)
+			if !ok || ipn.IP.To4() == nil || ipn.IP.IsLoopback() {
+				continue
+			}
+			s.notifyFrom(ipn.IP.To4(), gaddr)
+		}
+	}
+}
+
+func (s *Server) notifyFrom(ip net.IP, gaddr *net.UDPAddr) {
+	c, err := net.DialUDP("udp4", &net.UDPAddr{IP: ip}, gaddr)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	for _, nt := range append([]string{"uuid:" + s.uuid}, ssdpTargets...) {
+		msg := strings.Join([]string{
+			"NOTIFY * HTTP/1.1",
+			"HOST: " + ssdpAddr,
+			fmt.Sprintf("CACHE-CONTROL: max-age=%d", maxAge),
+			"LOCATION: " + s.location(ip),
+			"NT: " + nt,
+			"NTS: ssdp:alive",
+			"SERVER: " + serverHdr,
+			"USN: " + s.usn(nt),
+			"", "",
+		}, "\r\n")
+		c.Write([]byte(msg)) // nolint: errcheck
+	}
+}
+
+const rootDescTPL = `<?xml version="1.0" encoding="utf-8"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0" xmlns:dlna="urn:schemas-dlna-org:device-1-0">
+<specVersion><major>1</major><minor>0</minor></specVersion>
+<device>
+<deviceType>urn:schemas-upnp-org:device:MediaServer:1</deviceType>
+<friendlyName>%s</friendlyName>
+<manufacturer>SimpleTorrent</manufacturer>
+<manufacturerURL>https://github.com/boypt/simple-torrent</manufacturerURL>
+<modelName>SimpleTorrent</modelName>
+<modelNumber>1</modelNumber>
+<dlna:X_DLNADOC>DMS-1.50</dlna:X_DLNADOC>
+<UDN>uuid:%s</UDN>
+<serviceList>
+<service>
+<serviceType>urn:schemas-upnp-org:service:ContentDirectory:1</serviceType>
+<serviceId>urn:upnp-org:serviceId:ContentDirectory</serviceId>
+<SCPDURL>/ContentDirectory.xml</SCPDURL>
+<controlURL>/ctl/ContentDirectory</controlURL>
+<eventSubURL></eventSubURL>
+</service>
+<service>
+<serviceType>urn:schemas-upnp-org:service:ConnectionManager:1</serviceType>
+<serviceId>urn:upnp-org:serviceId:ConnectionManager</serviceId>
+<SCPDURL>/ConnectionManager.xml</SCPDURL>
+<controlURL>/ctl/ConnectionManager</controlURL>
+<eventSubURL></eventSubURL>
+</service>
+</serviceList>
+</device>
+</root>`
+
+const cdsSCPD = `<?xml version="1.0" encoding="utf-8"?>
+<scpd xmlns="urn:schemas-upnp-org:service-1-0">
+<specVersion><major>1</major><minor>0</minor></specVersion>
+<actionList>
+<action><name>Browse</name><argumentList>
+<argument><name>ObjectID</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_ObjectID</relatedStateVariable></argument>
+<argument><name>BrowseFlag</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_BrowseFlag</relatedStateVariable></argument>
+<argument><name>Filter</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Filter</relatedStateVariable></argument>
+<argument><name>StartingIndex</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Index</relatedStateVariable></argument>
+<argument><name>RequestedCount</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+<argument><name>SortCriteria</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_SortCriteria</relatedStateVariable></argument>
+<argument><name>Result</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Result</relatedStateVariable></argument>
+<argument><name>NumberReturned</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+<argument><name>TotalMatches</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+<argument><name>UpdateID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_UpdateID</relatedStateVariable></argument>
+</argumentList></action>
+<action><name>GetSystemUpdateID</name><argumentList>
+<argument><name>Id</name><direction>out</direction><relatedStateVariable>SystemUpdateID</relatedStateVariable></argument>
+</argumentList></action>
+<action><name>GetSearchCapabilities</name><argumentList>
+<argument><name>SearchCaps</name><direction>out</direction><relatedStateVariable>SearchCapabilities</relatedStateVariable></argument>
+</argumentList></action>
+<action><name>GetSortCapabilities</name><argumentList>
+<argument><name>SortCaps</name><direction>out</direction><relatedStateVariable>SortCapabilities</relatedStateVariable></argument>
+</argumentList></action>
+</actionList>
+<serviceStateTable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_ObjectID</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_BrowseFlag</name><dataType>string</dataType>
+<allowedValueList><allowedValue>BrowseMetadata</allowedValue><allowedValue>BrowseDirectChildren</allowedValue></allowedValueList></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_Filter</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_Index</name><dataType>ui4</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_Count</name><dataType>ui4</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_SortCriteria</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_Result</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>A_ARG_TYPE_UpdateID</name><dataType>ui4</dataType></stateVariable>
+<stateVariable sendEvents="yes"><name>SystemUpdateID</name><dataType>ui4</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>SearchCapabilities</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="no"><name>SortCapabilities</name><dataType>string</dataType></stateVariable>
+</serviceStateTable>
+</scpd>`
+
+const cmsSCPD = `<?xml version="1.0" encoding="utf-8"?>
+<scpd xmlns="urn:schemas-upnp-org:service-1-0">
+<specVersion><major>1</major><minor>0</minor></specVersion>
+<actionList>
+<action><name>GetProtocolInfo</name><argumentList>
+<argument><name>Source</name><direction>out</direction><relatedStateVariable>SourceProtocolInfo</relatedStateVariable></argument>
+<argument><name>Sink</name><direction>out</direction><relatedStateVariable>SinkProtocolInfo</relatedStateVariable></argument>
+</argumentList></action>
+<action><name>GetCurrentConnectionIDs</name><argumentList>
+<argument><name>ConnectionIDs</name><direction>out</direction><relatedStateVariable>CurrentConnectionIDs</relatedStateVariable></argument>
+</argumentList></action>
+</actionList>
+<serviceStateTable>
+<stateVariable sendEvents="yes"><name>SourceProtocolInfo</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="yes"><name>SinkProtocolInfo</name><dataType>string</dataType></stateVariable>
+<stateVariable sendEvents="yes"><name>CurrentConnectionIDs</name><dataType>string</dataType></stateVariable>
+</serviceStateTable>
+</scpd>`
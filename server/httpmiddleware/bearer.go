@@ -0,0 +1,43 @@
+package httpmiddleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// BearerToken serves the /api/ requests carrying the `Authorization: Bearer
+// <token>` with next, skipping the auth of the fallback, used by the
+// cluster frontends. Other requests go to the fallback.
+func BearerToken(token string, next, fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			auth := r.Header.Get("Authorization")
+			if strings.HasPrefix(auth, "Bearer ") &&
+				subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		fallback.ServeHTTP(w, r)
+	})
+}
+
+// URLToken serves the requests of path carrying the token in the `token`
+// query parameter with next, skipping the auth of the fallback, for the
+// feed readers which can't log in. Other requests go to the fallback.
+func URLToken(path, token string, next, fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == path && ValidToken(r.URL.Query().Get("token"), token) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		fallback.ServeHTTP(w, r)
+	})
+}
+
+// ValidToken compares the tokens in constant time, an empty token is never
+// valid
+func ValidToken(got, token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
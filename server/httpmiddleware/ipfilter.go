@@ -0,0 +1,78 @@
+package httpmiddleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseCIDRs parses a comma separated list of CIDRs, or single IPs
+func ParseCIDRs(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %q", s)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func allowed(allow, deny []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		// not an IP peer (unix socket), only passes a deny list
+		return len(allow) == 0
+	}
+	if contains(deny, ip) {
+		return false
+	}
+	return len(allow) == 0 || contains(allow, ip)
+}
+
+// IPFilter rejects the requests from the deny list, and those not from the
+// allow list if it's not empty, with 403. It checks the peer address of the
+// connection, so it must wrap RealIP and not the other way around: the
+// forwarded headers can be forged by anyone reaching the port.
+func IPFilter(allow, deny []*net.IPNet, h http.Handler) http.Handler {
+	if len(allow) == 0 && len(deny) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if !allowed(allow, deny, net.ParseIP(host)) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,60 @@
+package httpmiddleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPFilter(t *testing.T) {
+	allow, err := ParseCIDRs("192.168.1.0/24, 10.0.0.1, ::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deny, err := ParseCIDRs("192.168.1.13")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		remote      string
+		allow, deny bool
+		want        int
+	}{
+		{"192.168.1.5:40000", true, true, http.StatusOK},
+		{"192.168.1.13:40000", true, true, http.StatusForbidden},
+		{"10.0.0.1:40000", true, true, http.StatusOK},
+		{"10.0.0.2:40000", true, true, http.StatusForbidden},
+		{"[::1]:40000", true, true, http.StatusOK},
+		{"@", true, false, http.StatusForbidden},
+		{"@", false, true, http.StatusOK},
+		{"10.0.0.2:40000", false, true, http.StatusOK},
+		{"192.168.1.13:40000", false, true, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		var a, d = allow, deny
+		if !tt.allow {
+			a = nil
+		}
+		if !tt.deny {
+			d = nil
+		}
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+		// the forwarded headers are not trusted
+		r.Header.Set("X-Real-IP", "192.168.1.5")
+		w := httptest.NewRecorder()
+		IPFilter(a, d, RealIP(ok)).ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s (allow %v, deny %v) = %d, want %d", tt.remote, tt.allow, tt.deny, w.Code, tt.want)
+		}
+	}
+}
+
+func TestParseCIDRs_invalid(t *testing.T) {
+	for _, s := range []string{"10.0.0", "10.0.0.0/33", "host.lan"} {
+		if _, err := ParseCIDRs(s); err == nil {
+			t.Errorf("ParseCIDRs(%q) = nil error", s)
+		}
+	}
+}
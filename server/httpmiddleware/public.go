@@ -0,0 +1,19 @@
+package httpmiddleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Public serves the requests under the path prefix with next, skipping the
+// auth of the fallback, for the pages checking their own tokens like the
+// sharing links. Other requests go to the fallback.
+func Public(prefix string, next, fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		fallback.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,209 @@
+// Package s3 is a minimal S3 client (put/list/delete objects) signing the
+// requests with AWS Signature V4, enough for uploading backups to AWS S3 or
+// compatible services like MinIO.
+package s3
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Client is bound to a bucket, requests are made path-style:
+// <Endpoint>/<Bucket>/<key>
+type Client struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	HTTP      *http.Client
+}
+
+// ParseURL creates a client from s3://[key:secret@]bucket/prefix?region=&endpoint=,
+// the credentials default to the env AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+func ParseURL(s3url string) (*Client, string, error) {
+	u, err := url.Parse(s3url)
+	if err != nil {
+		return nil, "", err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return nil, "", fmt.Errorf("invalid s3 url, expecting s3://bucket/prefix")
+	}
+	c := &Client{
+		Bucket:    u.Host,
+		Region:    u.Query().Get("region"),
+		Endpoint:  strings.TrimSuffix(u.Query().Get("endpoint"), "/"),
+		AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		HTTP:      &http.Client{Timeout: 10 * time.Minute},
+	}
+	if u.User != nil {
+		c.AccessKey = u.User.Username()
+		c.SecretKey, _ = u.User.Password()
+	}
+	if c.Region == "" {
+		c.Region = "us-east-1"
+	}
+	if c.Endpoint == "" {
+		c.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", c.Region)
+	}
+	if c.AccessKey == "" || c.SecretKey == "" {
+		return nil, "", fmt.Errorf("s3 credentials not set")
+	}
+	return c, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+// Put uploads an object
+func (c *Client) Put(key string, data []byte) error {
+	_, err := c.do("PUT", key, nil, data)
+	return err
+}
+
+// Delete removes an object
+func (c *Client) Delete(key string) error {
+	_, err := c.do("DELETE", key, nil, nil)
+	return err
+}
+
+// List returns the keys with the prefix, sorted
+func (c *Client) List(prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		data, err := c.do("GET", "", q, nil)
+		if err != nil {
+			return nil, err
+		}
+		var res struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := xml.Unmarshal(data, &res); err != nil {
+			return nil, err
+		}
+		for _, o := range res.Contents {
+			keys = append(keys, o.Key)
+		}
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			break
+		}
+		token = res.NextContinuationToken
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (c *Client) do(method, key string, query url.Values, body []byte) ([]byte, error) {
+	path := "/" + c.Bucket
+	if key != "" {
+		path += "/" + key
+	}
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path
+	u.RawQuery = encodeQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	c.sign(req, body, time.Now().UTC())
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("s3 %s %s: %s %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// sign adds the AWS Signature V4 headers
+func (c *Client) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := hexSHA256(body)
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		escapePath(req.URL.Path),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + c.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretKey), date)
+	key = hmacSHA256(key, c.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.AccessKey, scope, signedHeaders, signature))
+}
+
+// encodeQuery sorts and escapes the query as required by the signing
+func encodeQuery(q url.Values) string {
+	return strings.ReplaceAll(q.Encode(), "+", "%20")
+}
+
+func escapePath(p string) string {
+	segs := strings.Split(p, "/")
+	for i, s := range segs {
+		segs[i] = strings.ReplaceAll(url.PathEscape(s), "+", "%2B")
+	}
+	return strings.Join(segs, "/")
+}
+
+func hexSHA256(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
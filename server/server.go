@@ -8,34 +8,37 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/boypt/simple-torrent/common"
+	"github.com/boypt/simple-torrent/server/apiv1"
 	"github.com/boypt/simple-torrent/server/httpmiddleware"
+	"github.com/boypt/simple-torrent/server/systemd"
 
 	"errors"
 
 	"github.com/NYTimes/gziphandler"
 	"github.com/anacrolix/torrent"
-	"github.com/boypt/scraper"
 	"github.com/boypt/simple-torrent/engine"
+	"github.com/boypt/simple-torrent/plugin"
+	"github.com/boypt/simple-torrent/server/dlna"
 	ctstatic "github.com/boypt/simple-torrent/static"
 	"github.com/jpillora/cookieauth"
 	"github.com/jpillora/requestlog"
 	"github.com/jpillora/velox"
-	"github.com/mmcdole/gofeed"
 	"github.com/skratchdot/open-golang/open"
 	"github.com/spf13/viper"
-)
-
-const (
-	scraperUA = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_12_4) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/57.0.2987.133 Safari/537.36"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 var (
+	instanceRegexp = regexp.MustCompile(`^[\w-]+$`)
 	isListenOnUnix bool
 	log            *stdlog.Logger
 	//ErrDiskSpace raised if disk space not enough
@@ -51,11 +54,18 @@ type Server struct {
 	Listen         string `opts:"help=Listening Address:Port or unix socket (default all),env=LISTEN"`
 	UnixPerm       string `opts:"help=DomainSocket file permission (default 0666),env=UNIXPERM"`
 	Auth           string `opts:"help=Optional basic auth in form 'user:password',env=AUTH"`
+	APIToken       string `opts:"help=Optional bearer token accepted by the /api/ requests besides the basic auth (eg. from a cluster frontend),env=APITOKEN"`
+	FeedToken      string `opts:"help=Token enabling the feeds of the tasks: the RSS of the finished ones at /feed.xml?token=<token> (add &errors=1 for the errors), the iCalendar of the scheduled starts and completions at /calendar.ics?token=<token> and the RSS of the published torrents at /published/feed.xml?token=<token>,env=FEEDTOKEN"`
 	ProxyURL       string `opts:"help=Proxy url,env=PROXY_URL"`
 	ConfigPath     string `opts:"help=Configuration file path (default ./cloud-torrent.yaml),short=c,env=CONFIGPATH"`
+	Instance       string `opts:"help=Instance name namespacing the config/state files (default config ./cloud-torrent-<name>.yaml),env=INSTANCE"`
 	KeyPath        string `opts:"help=TLS Key file path"`
 	CertPath       string `opts:"help=TLS Certicate file path,short=r"`
 	RestAPI        string `opts:"help=Listen on a trusted port accepts /api/ requests (eg. localhost:3001),env=RESTAPI"`
+	AdminListen    string `opts:"help=Listen Address:Port serving the web UI with the config endpoints (configure/restart/update/backup/restore) which the other listeners refuse when set (eg. localhost:3002),env=ADMINLISTEN"`
+	AllowIPs       string `opts:"help=Comma separated IPs or CIDRs allowed to connect to the web and RestAPI listeners (default all),env=ALLOWIPS"`
+	DenyIPs        string `opts:"help=Comma separated IPs or CIDRs refused by the web and RestAPI listeners,env=DENYIPS"`
+	H2C            bool   `opts:"help=Serve HTTP/2 without TLS (h2c) on the web listener (eg. for a reverse proxy or CDN talking h2c to the backend),env=H2C"`
 	ReqLog         bool   `opts:"help=Enable request logging,env=REQLOG"`
 	Open           bool   `opts:"help=Open now with your default browser"`
 	DisableLogTime bool   `opts:"help=Don't print timestamp in log,env=DISABLELOGTIME"`
@@ -63,11 +73,13 @@ type Server struct {
 	Debug          bool   `opts:"help=Debug app,env=DEBUG"`
 	DebugTorrent   bool   `opts:"help=Debug torrent engine,env=DEBUGTORRENT"`
 	ConvYAML       bool   `opts:"help=Convert old json config to yaml format."`
+	Migrate        bool   `opts:"help=Import the config and tasks of an old cloud-torrent installation (cloud-torrent.json in the config dir or /etc/) once"`
 	IntevalSec     int    `opts:"help=Inteval seconds to push data to clients (default 3),env=INTEVALSEC"`
 
 	//http handlers
-	scraperh, dlfilesh, statich, verStatich, rssh http.Handler
-	scraper                                       *scraper.Handler
+	dlfilesh, statich, verStatich, rssh, apiv1h http.Handler
+	fetcher                             *fetchTransport
+	scraperState
 
 	//torrent engine
 	engine *engine.Engine
@@ -77,23 +89,41 @@ type Server struct {
 	syncWg        sync.WaitGroup
 	syncSemphor   int32
 
+	// serializes the config updates
+	configMu sync.Mutex
+
+	// locked by velox while encoded, the tasks by their own locks
 	state struct {
+		sync.Mutex
 		velox.State
-		UseQueue      bool
-		LatestRSSGuid string
-		Torrents      *map[string]*engine.Torrent
-		Users         map[string]struct{}
-		Stats         struct {
+		UseQueue       bool
+		LatestRSSGuid  string
+		Torrents       liveTorrents
+		FailedAdds     []engine.FailedAdd
+		Maintenance    engine.MaintenanceStatus
+		Volume         engine.VolumeStatus
+		Quotas         []engine.Quota
+		TransferQuota  engine.TransferQuota
+		ConfigUnsaved  []string
+		SearchDisabled bool
+		Users          map[string]struct{}
+		Stats          struct {
 			System   osStats
 			ConnStat torrent.ConnStats
+			Trackers engine.TrackerStat
+			Backup   backupStat
 		}
 	}
 
-	rssMark         map[string]string
-	rssCache        []*gofeed.Item
-	searchProviders *scraper.Config
-	engineConfig    *engine.Config
-	tpl             *TPLInfo
+	cluster      cluster
+	activity     activityLog
+	uploads      uploadState
+	files        filesState
+	idempotency  idempotencyState
+	searchHealth searchHealthState
+	rss          rssState
+	engineConfig *engine.Config
+	tpl          *TPLInfo
 }
 
 // Run the server
@@ -107,6 +137,8 @@ func (s *Server) Run(tpl *TPLInfo) error {
 
 	if s.DisableLogTime {
 		engine.SetLoggerFlag(stdlog.Lmsgprefix)
+		plugin.SetLoggerFlag(stdlog.Lmsgprefix)
+		dlna.SetLoggerFlag(stdlog.Lmsgprefix)
 		log.SetFlags(stdlog.Lmsgprefix)
 	}
 
@@ -119,6 +151,19 @@ func (s *Server) Run(tpl *TPLInfo) error {
 	}
 	isListenOnUnix = strings.HasPrefix(s.Listen, "unix:")
 
+	if s.Instance != "" && !instanceRegexp.MatchString(s.Instance) {
+		return fmt.Errorf("ERROR: Invalid instance name %q, use letters, digits, '-' and '_'", s.Instance)
+	}
+
+	// the credentials can be secret references, eg. ${enc:...}
+	for _, v := range []*string{&s.Auth, &s.APIToken, &s.FeedToken} {
+		resolved, err := engine.ResolveSecrets(*v)
+		if err != nil {
+			return err
+		}
+		*v = resolved
+	}
+
 	isTLS := s.CertPath != "" || s.KeyPath != "" //poor man's XOR
 	if isTLS && (s.CertPath == "" || s.KeyPath == "") {
 		return fmt.Errorf("ERROR: You must provide both key and cert paths")
@@ -127,39 +172,44 @@ func (s *Server) Run(tpl *TPLInfo) error {
 	s.syncConnected = make(chan struct{})
 	//init maps
 	s.state.Users = make(map[string]struct{})
-	s.rssMark = make(map[string]string)
 
 	//will use a the local embed/ dir if it exists, otherwise will use the hardcoded embedded binaries
 	s.statich = ctstatic.FileSystemHandler()
 	s.verStatich = http.StripPrefix("/"+s.tpl.Version, s.statich)
 	s.dlfilesh = http.StripPrefix("/download/", http.HandlerFunc(s.serveDownloadFiles))
 	s.rssh = http.HandlerFunc(s.serveRSS)
+	s.apiv1h = apiv1.Handler(apiV1{s})
 
 	//scraper
-	s.scraper = &scraper.Handler{
-		Log: s.Debug, Debug: s.Debug,
-		Headers: map[string]string{
-			//we're a trusty browser :)
-			"User-Agent": scraperUA,
-		},
-	}
-	if err := s.scraper.LoadConfig(defaultSearchConfig); err != nil {
-		log.Fatal(err)
-	}
-	s.searchProviders = &s.scraper.Config //share scraper config with web frontend
-	s.scraperh = http.StripPrefix("/search", s.scraper)
+	s.initScraper()
+	s.fetcher = &fetchTransport{}
+	http.DefaultClient.Transport = s.fetcher
 
 	// sync config from cmd arg to viper
 	viper.SetDefault("ProxyURL", s.ProxyURL)
 
 	//torrent engine
 	s.engine = engine.New(s)
-	c, err := engine.InitConf(&s.ConfigPath)
+	s.state.Torrents = liveTorrents{s.engine}
+	c, err := engine.InitConf(&s.ConfigPath, s.Instance)
 	if err != nil {
 		return err
 	}
 	c.EngineDebug = s.DebugTorrent
 
+	legacyDirs := []string{filepath.Dir(s.ConfigPath), "/etc/"}
+	if lc := engine.FindLegacyInstall(legacyDirs...); lc != "" {
+		if !s.Migrate {
+			log.Println("[Migrate] found an old cloud-torrent installation", lc, ", start with --migrate to import it")
+		} else {
+			rp, err := engine.MigrateLegacy(lc, c, s.Instance)
+			if err != nil {
+				return fmt.Errorf("migrate %s: %w", lc, err)
+			}
+			log.Printf("[Migrate] imported from %s: config %v, %d torrents, %d magnets", rp.From, rp.Config, rp.Torrents, rp.Magnets)
+		}
+	}
+
 	// write cloud-torrent.yaml at the same dir with -c conf and exit
 	if s.ConvYAML {
 		cf := viper.ConfigFileUsed()
@@ -181,18 +231,29 @@ func (s *Server) Run(tpl *TPLInfo) error {
 	}
 
 	// engine configure
+	s.state.Lock()
 	s.state.Stats.System.diskDirPath = c.DownloadDirectory
 	s.state.UseQueue = (c.MaxConcurrentTask > 0)
+	s.state.Unlock()
 	s.engineConfig = c
+	s.updateFetcher()
+	if c.UITheme != "" {
+		if err := s.applyTheme(c.UITheme); err != nil {
+			log.Println("[Theme] using the built-in UI,", err)
+		}
+	}
 	s.tpl.AllowRuntimeConfigure = c.AllowRuntimeConfigure
 	if err := s.engine.Configure(c); err != nil {
 		return err
 	}
-	s.state.Torrents = s.engine.GetTorrents()
+	searchDisabled := s.searchDisabled()
+	s.state.Lock()
+	s.state.SearchDisabled = searchDisabled
+	s.state.Unlock()
 
 	if s.Debug {
 		viper.Debug()
-		log.Printf("Effective Config: %#v", *c)
+		log.Printf("Effective Config: %#v", c.Masked())
 	}
 
 	if err := s.engine.ParseTrackerList(); err != nil {
@@ -200,6 +261,17 @@ func (s *Server) Run(tpl *TPLInfo) error {
 	}
 	s.backgroundRoutines()
 
+	if c.DLNAEnable {
+		go func() {
+			d := &dlna.Server{
+				FriendlyName: c.DLNAFriendlyName,
+				RootDir:      c.DownloadDirectory,
+				Port:         c.DLNAPort,
+			}
+			log.Println("[dlna] err", d.Run())
+		}()
+	}
+
 	if s.Open && !isListenOnUnix {
 		go func() {
 			proto := "http"
@@ -211,19 +283,45 @@ func (s *Server) Run(tpl *TPLInfo) error {
 		}()
 	}
 
+	// checked before the auth, by the peer address of the connections
+	allowIPs, err := httpmiddleware.ParseCIDRs(s.AllowIPs)
+	if err != nil {
+		return fmt.Errorf("AllowIPs: %w", err)
+	}
+	denyIPs, err := httpmiddleware.ParseCIDRs(s.DenyIPs)
+	if err != nil {
+		return fmt.Errorf("DenyIPs: %w", err)
+	}
+
+	// systemd socket activation, the first socket is for the web server,
+	// the second one (if any) for the restful API server
+	sdListeners, err := systemd.Listeners()
+	if err != nil {
+		return err
+	}
+
 	// restful API server
-	if s.RestAPI != "" {
+	if s.RestAPI != "" || len(sdListeners) > 1 {
 		go func() {
 			restServer := http.Server{
 				Addr: s.RestAPI,
 				Handler: requestlog.Wrap(
-					httpmiddleware.RealIP(
-						http.Handler(http.HandlerFunc(s.restAPIhandle)),
+					httpmiddleware.IPFilter(allowIPs, denyIPs,
+						httpmiddleware.RealIP(
+							http.Handler(http.HandlerFunc(s.restAPIhandle)),
+						),
 					),
 				),
 			}
-			log.Println("[RestAPI] listening at ", s.RestAPI)
-			if err := restServer.ListenAndServe(); err != nil {
+			var err error
+			if len(sdListeners) > 1 {
+				log.Println("[RestAPI] listening at systemd socket", sdListeners[1].Addr())
+				err = restServer.Serve(sdListeners[1])
+			} else {
+				log.Println("[RestAPI] listening at ", s.RestAPI)
+				err = restServer.ListenAndServe()
+			}
+			if err != nil {
 				log.Println("[RestAPI] err ", err)
 			}
 		}()
@@ -249,12 +347,33 @@ func (s *Server) Run(tpl *TPLInfo) error {
 			user = s[0]
 			pass = s[1]
 		}
-		h = cookieauth.New().SetUserPass(user, pass).Wrap(h)
+		authed := cookieauth.New().SetUserPass(user, pass).Wrap(h)
+		if s.APIToken != "" {
+			authed = httpmiddleware.BearerToken(s.APIToken, h, authed)
+			log.Printf("Enabled API bearer token")
+		}
+		if s.FeedToken != "" {
+			authed = httpmiddleware.URLToken(feedPath, s.FeedToken, h, authed)
+			authed = httpmiddleware.URLToken(calendarPath, s.FeedToken, h, authed)
+			authed = httpmiddleware.Public(publishPath, h, authed)
+		}
+		authed = httpmiddleware.Public(sharePath, h, authed)
+		h = authed
 		log.Printf("Enabled HTTP authentication")
 	}
+	h = httpmiddleware.IPFilter(allowIPs, denyIPs, h)
 	if s.ReqLog {
 		h = requestlog.Wrap(h)
 	}
+	if s.AdminListen != "" {
+		go s.serveAdmin(h, isTLS)
+	}
+
+	// HTTP/2 is negotiated by TLS, or else with the h2c
+	if s.H2C && !isTLS {
+		h = h2c.NewHandler(h, &http2.Server{})
+		log.Println("Enabled h2c")
+	}
 
 	server := http.Server{
 		//handler stack
@@ -263,7 +382,10 @@ func (s *Server) Run(tpl *TPLInfo) error {
 
 	//serve!
 	var listener net.Listener
-	if isListenOnUnix {
+	if len(sdListeners) > 0 {
+		listener = sdListeners[0]
+		log.Println("Listening at systemd socket", listener.Addr())
+	} else if isListenOnUnix {
 		sockPath := s.Listen[5:]
 		if _, err := os.Stat(sockPath); !errors.Is(err, os.ErrNotExist) {
 			log.Println("Listening sock exists, removing", sockPath)
@@ -285,9 +407,18 @@ func (s *Server) Run(tpl *TPLInfo) error {
 		if err != nil {
 			log.Fatalln("Failed listening", err)
 		}
-		if isTLS {
-			return server.ServeTLS(listener, s.CertPath, s.KeyPath)
-		}
+	}
+
+	if ok, err := systemd.Notify("READY=1"); ok {
+		log.Println("[systemd] notified ready")
+		go systemd.Watchdog(s.engine.IsConfigred)
+	} else if err != nil {
+		log.Println("[systemd] notify failed", err)
+	}
+
+	// no TLS on the unix domain socket
+	if isTLS && (len(sdListeners) > 0 || !isListenOnUnix) {
+		return server.ServeTLS(listener, s.CertPath, s.KeyPath)
 	}
 	return server.Serve(listener)
 }
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+type adminCtxKey struct{}
+
+// adminAPI tells if the request changes the config or the installation,
+// served at the AdminListen only if it's set
+func adminAPI(r *http.Request) bool {
+	action := strings.TrimPrefix(r.URL.Path, "/api/")
+	switch r.Method {
+	case "POST", "PATCH":
+		switch action {
+		case "configure", "restart", "update", "restore":
+			return true
+		}
+	case "GET":
+		// has the secrets of the config
+		return action == "backup"
+	}
+	return false
+}
+
+// adminDenied refuses the admin requests not coming from the AdminListen
+func (s *Server) adminDenied(w http.ResponseWriter, r *http.Request) bool {
+	if s.AdminListen == "" || !adminAPI(r) || r.Context().Value(adminCtxKey{}) != nil {
+		return false
+	}
+	http.Error(w, "Forbidden: served at the admin listener only", http.StatusForbidden)
+	return true
+}
+
+// adminHandler marks the requests of the AdminListen
+func adminHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminCtxKey{}, true)))
+	})
+}
+
+func (s *Server) serveAdmin(h http.Handler, isTLS bool) {
+	adminServer := http.Server{
+		Addr:    s.AdminListen,
+		Handler: adminHandler(h),
+	}
+	log.Println("[Admin] listening at", s.AdminListen)
+	var err error
+	if isTLS {
+		err = adminServer.ListenAndServeTLS(s.CertPath, s.KeyPath)
+	} else {
+		err = adminServer.ListenAndServe()
+	}
+	log.Println("[Admin] err", err)
+}
@@ -3,17 +3,24 @@ package server
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/boypt/simple-torrent/common"
 	"github.com/boypt/simple-torrent/engine"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
 )
 
 var (
@@ -41,7 +48,11 @@ func (s *Server) apiGET(w http.ResponseWriter, r *http.Request) error {
 		}{}
 
 		m := r.URL.Query().Get("m")
-		if err := s.engine.NewMagnet(m); err != nil {
+		opt, err := addOptions(r)
+		if err == nil {
+			err = s.engine.NewMagnet(m, opt)
+		}
+		if err != nil {
 			if !errors.Is(err, engine.ErrMaxConnTasks) {
 				tdata.HasError = true
 				tdata.Error = err.Error()
@@ -49,11 +60,37 @@ func (s *Server) apiGET(w http.ResponseWriter, r *http.Request) error {
 		}
 		tdata.Magnet = m
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		common.HandleError(htmlTPL["magadded.html"].Execute(w, tdata))
+		common.HandleError(htmlTemplate("magadded.html").Execute(w, tdata))
 	case "configure":
-		common.HandleError(json.NewEncoder(w).Encode(*(s.engineConfig)))
+		common.HandleError(json.NewEncoder(w).Encode(s.engineConfig.Masked()))
 	case "torrents":
-		common.HandleError(json.NewEncoder(w).Encode(s.engine.GetTorrents()))
+		// searches the name, labels, notes and metadata: ?q=sonarr_id=123
+		if q := r.URL.Query().Get("q"); q != "" {
+			common.HandleError(json.NewEncoder(w).Encode(s.engine.SearchTasks(q)))
+			return nil
+		}
+		// ?status=seeding,paused
+		statuses, err := engine.ParseTaskStatuses(r.URL.Query().Get("status"))
+		if err != nil {
+			return err
+		}
+		var match func(t *engine.Torrent) bool
+		switch r.URL.Query().Get("filter") {
+		case "":
+			if statuses != nil {
+				match = func(t *engine.Torrent) bool { return statuses[t.Status] }
+			}
+		case "stalled":
+			match = func(t *engine.Torrent) bool { return t.IsStalled }
+		default:
+			return errUnknowAct
+		}
+		data, err := s.engine.MarshalTorrents(match)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
 	case "files":
 		common.HandleError(json.NewEncoder(w).Encode(s.listFiles()))
 	case "torrent":
@@ -64,18 +101,147 @@ func (s *Server) apiGET(w http.ResponseWriter, r *http.Request) error {
 		if len(hash) != 40 {
 			return errUnknowPath
 		}
-		m := s.engine.GetTorrents()
-		if t, ok := (*m)[hash]; ok {
-			common.HandleError(json.NewEncoder(w).Encode(t))
-		} else {
+		data, err := s.engine.MarshalTorrent(hash)
+		if err != nil {
 			return errUnknowPath
 		}
+		_, err = w.Write(data)
+		return err
+	case "verify":
+		if len(routeDirs) != 2 {
+			return errUnknowAct
+		}
+		rp, err := s.engine.GetVerifyReport(routeDirs[1])
+		if err != nil {
+			return err
+		}
+		common.HandleError(json.NewEncoder(w).Encode(rp))
+	case "manifest":
+		if len(routeDirs) != 2 {
+			return errUnknowAct
+		}
+		fn, data, err := s.engine.Manifest(routeDirs[1])
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", filepath.Base(fn)))
+		_, err = w.Write(data)
+		return err
+	case "swarm":
+		if len(routeDirs) != 2 {
+			return errUnknowAct
+		}
+		history, err := s.engine.SwarmHistory(routeDirs[1])
+		if err != nil {
+			return err
+		}
+		common.HandleError(json.NewEncoder(w).Encode(history))
+	case "jobs":
+		common.HandleError(json.NewEncoder(w).Encode(s.engine.DoneCmdJobs()))
+	case "tasks":
+		// the background tasks, not the torrents
+		common.HandleError(json.NewEncoder(w).Encode(s.engine.BackgroundTasks()))
+	case "shares":
+		common.HandleError(json.NewEncoder(w).Encode(s.engine.Shares()))
+	case "uploads":
+		common.HandleError(json.NewEncoder(w).Encode(s.listUploads()))
+	case "published":
+		common.HandleError(json.NewEncoder(w).Encode(s.engine.PublishedTorrents()))
+	case "groups":
+		common.HandleError(json.NewEncoder(w).Encode(s.engine.Groups()))
+	case "removed":
+		// the history of the removed tasks: /api/removed?q=<query>&limit=<n>
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		removed, err := s.engine.RemovedHistory(r.URL.Query().Get("q"), limit)
+		if err != nil {
+			return err
+		}
+		common.HandleError(json.NewEncoder(w).Encode(removed))
+	case "state":
+		return s.apiState(w, r)
 	case "stat":
-		s.state.Stats.System.loadStats()
-		s.state.Stats.ConnStat = s.engine.ConnStat()
-		common.HandleError(json.NewEncoder(w).Encode(s.state.Stats))
+		s.refreshStats()
+		s.state.Lock()
+		data, err := json.Marshal(s.state.Stats)
+		s.state.Unlock()
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	case "restart":
+		common.HandleError(json.NewEncoder(w).Encode(s.engine.RestartStatus()))
+	case "dedupe":
+		common.HandleError(json.NewEncoder(w).Encode(s.engine.DedupeReport()))
+	case "maintenance":
+		common.HandleError(json.NewEncoder(w).Encode(s.engine.Maintenance()))
+	case "autotune":
+		common.HandleError(json.NewEncoder(w).Encode(s.engine.AutoTune()))
+	case "speedtest":
+		common.HandleError(json.NewEncoder(w).Encode(s.engine.SpeedTest()))
+	case "transferquota":
+		common.HandleError(json.NewEncoder(w).Encode(s.engine.TransferQuotaStatus()))
+	case "policies":
+		// the last run of the policies, /api/policies?dryrun=1 evaluates them now without acting
+		if r.URL.Query().Get("dryrun") != "1" {
+			common.HandleError(json.NewEncoder(w).Encode(s.engine.Policies()))
+			break
+		}
+		report, err := s.engine.EvaluatePolicies(true)
+		if err != nil {
+			return err
+		}
+		common.HandleError(json.NewEncoder(w).Encode(report))
+	case "qos":
+		common.HandleError(json.NewEncoder(w).Encode(s.engine.QoS()))
+	case "ratelimit":
+		common.HandleError(json.NewEncoder(w).Encode(s.engine.RateOverrideStatus()))
+	case "watchdog":
+		common.HandleError(json.NewEncoder(w).Encode(s.engine.WatchdogIncidents()))
+	case "update":
+		rel, err := s.checkUpdate()
+		if err != nil {
+			return err
+		}
+		common.HandleError(json.NewEncoder(w).Encode(rel))
+	case "backup":
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition",
+			fmt.Sprintf(`attachment; filename="cloud-torrent-backup-%s.tar.gz"`, time.Now().Format("20060102")))
+		return s.engine.WriteBackup(w, viper.ConfigFileUsed())
+	case "cluster":
+		common.HandleError(json.NewEncoder(w).Encode(s.clusterNodes()))
 	case "searchproviders":
-		common.HandleError(json.NewEncoder(w).Encode(s.searchProviders))
+		common.HandleError(json.NewEncoder(w).Encode(s.searchProviderList()))
+	case "searchhealth":
+		common.HandleError(json.NewEncoder(w).Encode(s.searchHealthList()))
+	case "resolve":
+		if s.engineConfig.DisableSearch {
+			return errSearchDisabled
+		}
+		res, err := s.apiResolve(r)
+		if err != nil {
+			return err
+		}
+		common.HandleError(json.NewEncoder(w).Encode(res))
+	case "searchtest":
+		if s.engineConfig.DisableSearch {
+			return errSearchDisabled
+		}
+		res, err := s.apiSearchTest(r)
+		if err != nil {
+			return err
+		}
+		common.HandleError(json.NewEncoder(w).Encode(res))
+	case "health":
+		h, err := s.apiHealth(r)
+		if err != nil {
+			return err
+		}
+		common.HandleError(json.NewEncoder(w).Encode(h))
+	case "debug":
+		return s.apiDebug(w, r)
 	case "enginedebug":
 		w.Header().Set("Content-Type", "application/json")
 		var buf bytes.Buffer
@@ -83,7 +249,7 @@ func (s *Server) apiGET(w http.ResponseWriter, r *http.Request) error {
 		common.HandleError(json.NewEncoder(w).Encode(struct {
 			EngineStatus string
 			Trackers     []string
-		}{buf.String(), s.engine.Trackers}))
+		}{buf.String(), s.engine.GetTrackers()}))
 	default:
 		return errUnknowAct
 	}
@@ -104,28 +270,122 @@ func (s *Server) apiPOST(r *http.Request) error {
 		return fmt.Errorf("ERROR: Failed to download request body: %w", err)
 	}
 
-	//convert url into torrent bytes
-	if action == "url" {
-		url := string(data)
-		remote, err := http.Get(url)
-		if err != nil {
-			return fmt.Errorf("ERROR: Invalid remote torrent URL: %s %w", url, err)
+	// notes and metadata of a task: /api/meta/<infohash>
+	if strings.HasPrefix(action, "meta/") {
+		var m engine.TaskMeta
+		if err := json.Unmarshal(data, &m); err != nil {
+			return errInvalidReq
+		}
+		defer s.state.Push()
+		return s.engine.SetTaskMeta(strings.TrimPrefix(action, "meta/"), m)
+	}
+
+	// the background tasks listed at GET /api/tasks: /api/tasks/<name>/run,
+	// /api/tasks/<name>/pause or /api/tasks/<name>/resume
+	if strings.HasPrefix(action, "tasks/") {
+		parts := strings.Split(strings.TrimPrefix(action, "tasks/"), "/")
+		if len(parts) != 2 {
+			return errUnknowAct
 		}
-		defer remote.Body.Close()
-		if remote.ContentLength > 512*1024 {
-			//enforce max body size (512k)
-			return fmt.Errorf("ERROR: Remote torrent too large")
+		switch parts[1] {
+		case "run":
+			return s.engine.TriggerBackgroundTask(parts[0])
+		case "pause":
+			return s.engine.PauseBackgroundTask(parts[0], true)
+		case "resume":
+			return s.engine.PauseBackgroundTask(parts[0], false)
 		}
-		data, err = ioutil.ReadAll(remote.Body)
-		if err != nil {
-			return fmt.Errorf("ERROR: Failed to download remote torrent: %w", err)
+		return errUnknowAct
+	}
+
+	// renames a task, or a file or dir of it by the Path: /api/rename/<infohash>
+	if strings.HasPrefix(action, "rename/") {
+		var rn struct {
+			Path string
+			Name string
+		}
+		if err := json.Unmarshal(data, &rn); err != nil {
+			return errInvalidReq
+		}
+		defer s.state.Push()
+		infohash := strings.TrimPrefix(action, "rename/")
+		if rn.Path == "" {
+			return s.engine.RenameTorrent(infohash, rn.Name)
+		}
+		return s.engine.RenameFile(infohash, rn.Path, rn.Name)
+	}
+
+	// adds an http mirror to a task: /api/webseed/<infohash>, the url as the body
+	if strings.HasPrefix(action, "webseed/") {
+		defer s.state.Push()
+		return s.engine.AddWebSeed(strings.TrimPrefix(action, "webseed/"), string(data))
+	}
+
+	// schedules a task to start: /api/schedule/<infohash>, the RFC 3339 time
+	// as the body, empty to cancel
+	if strings.HasPrefix(action, "schedule/") {
+		var at time.Time
+		if v := strings.TrimSpace(string(data)); v != "" {
+			if at, err = time.Parse(time.RFC3339, v); err != nil {
+				return fmt.Errorf("%w: %s", engine.ErrInvalidSchedule, err)
+			}
+		}
+		defer s.state.Push()
+		return s.engine.ScheduleStart(strings.TrimPrefix(action, "schedule/"), at)
+	}
+
+	// the actions on a group of tasks: /api/group/<name>, start, stop or
+	// delete as the body
+	if strings.HasPrefix(action, "group/") {
+		name := strings.TrimPrefix(action, "group/")
+		defer s.state.Push()
+		switch string(data) {
+		case "start":
+			return s.engine.StartGroup(name)
+		case "stop":
+			return s.engine.StopGroup(name)
+		case "delete":
+			return s.engine.DeleteGroup(name)
+		}
+		return errInvalidReq
+	}
+
+	// moves a task to a group: /api/taskgroup/<infohash>, the group name as
+	// the body, empty to take it out
+	if strings.HasPrefix(action, "taskgroup/") {
+		defer s.state.Push()
+		return s.engine.SetGroup(strings.TrimPrefix(action, "taskgroup/"), strings.TrimSpace(string(data)))
+	}
+
+	// the failed adds: /api/failed/retry/<id> or /api/failed/dismiss/<id>
+	if strings.HasPrefix(action, "failed/") {
+		defer s.state.Push()
+		return s.apiFailedAdd(strings.TrimPrefix(action, "failed/"))
+	}
+
+	// dispatch adds to a cluster node: /api/cluster/magnet?node=name
+	if strings.HasPrefix(action, "cluster/") {
+		return s.clusterDispatch(strings.TrimPrefix(action, "cluster/"), r.URL.Query().Get("node"), data)
+	}
+
+	//convert url into torrent bytes
+	if action == "url" {
+		u := string(data)
+		if data, err = fetchTorrentURL(u); err != nil {
+			s.engine.RecordFailedAdd(engine.FailedURL, u, nil, err)
+			return err
 		}
 		action = "torrentfile"
 	}
 
+	opt, err := addOptions(r)
+	if err != nil {
+		return err
+	}
+
 	//convert torrent bytes into magnet
 	if action == "torrentfile" {
-		if err := s.engine.NewTorrentByReader(bytes.NewBuffer(data)); err != nil {
+		if err := s.engine.NewTorrentByReader(bytes.NewBuffer(data), opt); err != nil {
 			if !errors.Is(err, engine.ErrMaxConnTasks) {
 				return err
 			}
@@ -139,9 +399,13 @@ func (s *Server) apiPOST(r *http.Request) error {
 	//interface with engine
 	switch action {
 	case "configure":
-		return s.apiConfigure(data)
+		// applied at runtime, written to the config file with ?save=1 or
+		// by config/save
+		return s.apiConfigure(data, r.URL.Query().Get("save") == "1")
+	case "config/save":
+		return s.saveConfig()
 	case "magnet":
-		if err := s.engine.NewMagnet(string(data)); err != nil {
+		if err := s.engine.NewMagnet(string(data), opt); err != nil {
 			if errors.Is(err, engine.ErrMaxConnTasks) {
 				return nil
 			}
@@ -164,10 +428,17 @@ func (s *Server) apiPOST(r *http.Request) error {
 				return err
 			}
 		case "delete":
-			if err := s.engine.DeleteTorrent(infohash); err != nil {
+			if err := s.engine.RemoveTorrent(infohash, engine.RemovedByUser); err != nil {
+				return err
+			}
+		case "verify":
+			if err := s.engine.VerifyTorrent(infohash); err != nil {
+				return err
+			}
+		case "manifest":
+			if err := s.engine.GenerateManifest(infohash); err != nil {
 				return err
 			}
-			s.engine.RemoveCache(infohash)
 		case "move2wait":
 			if err := s.engine.DeleteTorrent(infohash); err != nil {
 				return err
@@ -178,6 +449,83 @@ func (s *Server) apiPOST(r *http.Request) error {
 		default:
 			return fmt.Errorf("ERROR: Invalid state: %s", state)
 		}
+	case "dedupe":
+		// hardlinks the identical files of the finished tasks, report at GET /api/dedupe
+		return s.engine.Dedupe()
+	case "maintenance":
+		// on[:reason] pauses all the tasks, the watcher and RSS, off resumes them
+		cmd := strings.SplitN(string(data), ":", 2)
+		reason := ""
+		if len(cmd) == 2 {
+			reason = cmd[1]
+		}
+		switch cmd[0] {
+		case "on":
+			return s.engine.SetMaintenance(true, reason)
+		case "off":
+			return s.engine.SetMaintenance(false, "")
+		}
+		return errInvalidReq
+	case "speedtest":
+		// start downloads the SpeedTestMagnet for a while, cancel ends it,
+		// the result at GET /api/speedtest
+		switch string(data) {
+		case "start":
+			return s.engine.StartSpeedTest()
+		case "cancel":
+			s.engine.CancelSpeedTest()
+			return nil
+		}
+		return errInvalidReq
+	case "ratelimit":
+		// temporary rates not saved to the config, {"Upload":"1MB","TTL":"2h"},
+		// the TTL empty or 0 reverts to the config
+		return s.apiRateLimit(data)
+	case "update":
+		// installs the latest release and restarts with it, check at GET /api/update
+		return s.selfUpdate()
+	case "restart":
+		// restarts the engine in background, progress at GET /api/restart
+		if s.engine.RestartStatus().Restarting {
+			return engine.ErrRestarting
+		}
+		go func() {
+			if err := s.engine.Restart(s.engineConfig); err != nil {
+				s.checkRestartFatal()
+			}
+			s.state.Push()
+		}()
+	case "restore":
+		// restores a backup from GET /api/backup, with ?config=1 the config is restored too
+		var applyConfig func([]byte) error
+		if r.URL.Query().Get("config") == "1" {
+			applyConfig = func(data []byte) error {
+				c := engine.Config{}
+				if err := yaml.Unmarshal(data, &c); err != nil {
+					return err
+				}
+				jc, err := json.Marshal(c)
+				if err != nil {
+					return err
+				}
+				return s.apiConfigure(jc, true)
+			}
+		}
+		if _, err := s.engine.RestoreBackup(bytes.NewReader(data), applyConfig); err != nil {
+			return err
+		}
+	case "job":
+		cmd := strings.SplitN(string(data), ":", 2)
+		if len(cmd) != 2 || cmd[0] != "retry" {
+			return errInvalidReq
+		}
+		id, err := strconv.ParseInt(cmd[1], 10, 64)
+		if err != nil {
+			return errInvalidReq
+		}
+		if err := s.engine.RetryDoneCmdJob(id); err != nil {
+			return err
+		}
 	case "file":
 		cmd := strings.SplitN(string(data), ":", 3)
 		if len(cmd) != 3 {
@@ -204,19 +552,102 @@ func (s *Server) apiPOST(r *http.Request) error {
 	return nil
 }
 
-func (s *Server) apiConfigure(data []byte) error {
+// apiRateLimit overrides the UploadRate and DownloadRate for the TTL
+func (s *Server) apiRateLimit(data []byte) error {
+	var o struct {
+		Upload   string
+		Download string
+		TTL      string
+	}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &o); err != nil {
+			return errInvalidReq
+		}
+	}
+	if o.TTL == "" || o.TTL == "0" {
+		s.engine.ClearRateOverride()
+		return nil
+	}
+	ttl, err := time.ParseDuration(o.TTL)
+	if err != nil {
+		return fmt.Errorf("%w: %s", engine.ErrInvalidRateOverride, err)
+	}
+	return s.engine.SetRateOverride(o.Upload, o.Download, ttl)
+}
+
+func (s *Server) apiConfigure(data []byte, save bool) error {
 
 	if !s.engineConfig.AllowRuntimeConfigure {
 		return errors.New("AllowRuntimeConfigure is set to false")
 	}
 
 	c := engine.Config{}
-	if err := json.Unmarshal(data, &c); err != nil {
+	if err := engine.DecodeConfigJSON(data, &c); err != nil {
 		return err
 	}
+	c.Unmask(s.engineConfig)
 	if _, err := c.NormlizeConfigDir(); err != nil {
 		return err
 	}
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	return s.applyConfig(c, save)
+}
+
+// config is a copy of the current config for the background routines, as
+// the config is replaced by the configure
+func (s *Server) config() engine.Config {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	return *s.engineConfig
+}
+
+// saveConfig writes the config changed at runtime to the config file
+func (s *Server) saveConfig() error {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	return s.writeConfig()
+}
+
+// writeConfig writes the config file, the caller holds the configMu
+func (s *Server) writeConfig() error {
+	if err := s.engineConfig.WriteDefault(); err != nil {
+		return err
+	}
+	s.state.Lock()
+	s.state.ConfigUnsaved = nil
+	s.state.Unlock()
+	log.Printf("[api] config saved")
+	return nil
+}
+
+// markUnsaved adds the fields to the ones changed since the config file
+// was written
+func (s *Server) markUnsaved(fields []string) {
+	s.state.Lock()
+	defer s.state.Unlock()
+	for _, f := range fields {
+		found := false
+		for _, u := range s.state.ConfigUnsaved {
+			if u == f {
+				found = true
+				break
+			}
+		}
+		if !found {
+			s.state.ConfigUnsaved = append(s.state.ConfigUnsaved, f)
+		}
+	}
+	sort.Strings(s.state.ConfigUnsaved)
+}
+
+// applyConfig replaces the config by c, reconfiguring what the changes
+// require, and writes it to the config file if save, the caller holds the
+// configMu
+func (s *Server) applyConfig(c engine.Config, save bool) error {
+	if _, err := engine.ParseHooks(c.Hooks); err != nil {
+		return err
+	}
 
 	if !reflect.DeepEqual(s.engineConfig, c) {
 		status := s.engineConfig.Validate(&c)
@@ -230,27 +661,30 @@ func (s *Server) apiConfigure(data []byte) error {
 			log.Printf("[api] file watcher restartd")
 		}
 
-		// now it's safe to save the configure
+		if c.UITheme != s.engineConfig.UITheme {
+			if err := s.applyTheme(c.UITheme); err != nil {
+				return err
+			}
+		}
+
+		// now it's safe to apply the configure, kept in memory until saved
+		s.markUnsaved(s.engineConfig.ChangedFields(&c))
 		s.engineConfig.SyncViper(c)
 		s.engineConfig = &c
-		if err := s.engineConfig.WriteDefault(); err != nil {
-			return err
+		searchDisabled := s.searchDisabled()
+		s.state.Lock()
+		s.state.SearchDisabled = searchDisabled
+		s.state.Unlock()
+		if !save {
+			log.Printf("[api] config applied, not saved to the file")
 		}
-		log.Printf("[api] config saved")
 
 		// finally to reconfigure the engine
 		if status&engine.NeedEngineReConfig > 0 {
-			if err := s.engine.Configure(s.engineConfig); err != nil {
-				if !s.engine.IsConfigred() {
-					go func() {
-						log.Println("[apiConfigure] serious error occured while reconfigured, will exit in 10s")
-						time.Sleep(time.Second * 10)
-						log.Fatalln(err)
-					}()
-				}
+			if err := s.engine.Restart(s.engineConfig); err != nil {
+				s.checkRestartFatal()
 				return err
 			}
-			s.engine.RestoreCacheDir()
 			log.Printf("[api] torrent engine reconfigred")
 		} else {
 			s.engine.SetConfig(s.engineConfig)
@@ -259,13 +693,16 @@ func (s *Server) apiConfigure(data []byte) error {
 		if status&engine.NeedUpdateTracker > 0 {
 			go s.engine.ParseTrackerList() // nolint: errcheck
 		}
+		s.updateFetcher()
 		if status&engine.NeedUpdateRSS > 0 {
-			go s.updateRSS()
+			s.engine.TriggerBackgroundTask("rss") // nolint: errcheck
 		}
 		s.state.Push()
 
 		// do after config synced
+		s.state.Lock()
 		s.state.UseQueue = (s.engineConfig.MaxConcurrentTask > 0)
+		s.state.Unlock()
 		if status&engine.NeedLoadWaitList > 0 {
 			go func() {
 				for {
@@ -281,11 +718,289 @@ func (s *Server) apiConfigure(data []byte) error {
 		log.Printf("[api] configure unchanged")
 	}
 
+	s.state.Lock()
+	unsaved := len(s.state.ConfigUnsaved)
+	s.state.Unlock()
+	if save && unsaved > 0 {
+		if err := s.writeConfig(); err != nil {
+			return err
+		}
+	}
+
 	// update search config anyway
 	go s.fetchSearchConfig(s.engineConfig.ScraperURL) // nolint: errcheck
 	return nil
 }
 
+// apiFailedAdd retries or dismisses a failed add
+func (s *Server) apiFailedAdd(cmd string) error {
+	c := strings.SplitN(cmd, "/", 2)
+	if len(c) != 2 {
+		return errInvalidReq
+	}
+	switch c[0] {
+	case "dismiss":
+		_, _, err := s.engine.TakeFailedAdd(c[1])
+		return err
+	case "retry":
+		for _, f := range s.engine.FailedAdds() {
+			if f.ID != c[1] || f.Kind != engine.FailedURL {
+				continue
+			}
+			// the engine doesn't download
+			if _, _, err := s.engine.TakeFailedAdd(f.ID); err != nil {
+				return err
+			}
+			data, err := fetchTorrentURL(f.Input)
+			if err == nil {
+				err = s.engine.NewTorrentByReader(bytes.NewReader(data))
+			} else {
+				s.engine.RecordFailedAdd(engine.FailedURL, f.Input, nil, err)
+			}
+			if errors.Is(err, engine.ErrMaxConnTasks) {
+				return nil
+			}
+			return err
+		}
+		return s.engine.RetryFailedAdd(c[1])
+	}
+	return errInvalidReq
+}
+
+// the max size of a torrent file fetched or uploaded
+const maxTorrentFileSize = 512 << 10
+
+// fetchTorrentURL downloads a remote torrent file
+func fetchTorrentURL(url string) ([]byte, error) {
+	remote, err := http.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: Invalid remote torrent URL: %s %w", url, err)
+	}
+	defer remote.Body.Close()
+	if remote.ContentLength > maxTorrentFileSize {
+		//enforce max body size (512k)
+		return nil, fmt.Errorf("ERROR: Remote torrent too large")
+	}
+	data, err := ioutil.ReadAll(remote.Body)
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: Failed to download remote torrent: %w", err)
+	}
+	return data, nil
+}
+
+// apiInspect parses a magnet, a torrent url or torrent file content
+// without adding it: POST /api/inspect
+func (s *Server) apiInspect(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, 4<<20))
+	if err != nil {
+		return err
+	}
+	var ins *engine.Inspection
+	switch text := strings.TrimSpace(string(data)); {
+	case strings.HasPrefix(text, "magnet:"):
+		ins, err = engine.InspectMagnet(text)
+	case strings.HasPrefix(text, "http://"), strings.HasPrefix(text, "https://"):
+		if data, err = fetchTorrentURL(text); err == nil {
+			ins, err = engine.InspectTorrent(data)
+		}
+	default:
+		ins, err = engine.InspectTorrent(data)
+	}
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(ins)
+}
+
+// previewRequest confirms the add of a preview, Files empty for all
+type previewRequest struct {
+	Files []string
+	Dir   string
+}
+
+// apiPreview serves /api/preview, resolving the posted magnet, url or
+// torrent into its file list without adding it, within ?timeout=<duration>.
+// /api/preview/<infohash>/add adds it with the posted previewRequest and the
+// add options, /api/preview/<infohash>/cancel drops it.
+func (s *Server) apiPreview(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	if rest := strings.TrimPrefix(r.URL.Path, "/api/preview/"); rest != r.URL.Path {
+		parts := strings.SplitN(rest, "/", 2)
+		if len(parts) != 2 {
+			return errUnknowAct
+		}
+		ih := strings.ToLower(parts[0])
+		switch parts[1] {
+		case "add":
+			var req previewRequest
+			if err := json.NewDecoder(io.LimitReader(r.Body, 4<<20)).Decode(&req); err != nil && err != io.EOF {
+				return err
+			}
+			opt, err := addOptions(r)
+			if err != nil {
+				return err
+			}
+			if err := s.engine.AddPreview(ih, req.Files, req.Dir, opt); err != nil && !errors.Is(err, engine.ErrMaxConnTasks) {
+				return err
+			}
+		case "cancel":
+			if err := s.engine.CancelPreview(ih); err != nil {
+				return err
+			}
+		default:
+			return errUnknowAct
+		}
+		_, err := w.Write([]byte("OK"))
+		return err
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, 4<<20))
+	if err != nil {
+		return err
+	}
+	timeout := 60 * time.Second
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		if timeout, err = time.ParseDuration(v); err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid timeout %q", v)
+		}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	var ins *engine.Inspection
+	switch text := strings.TrimSpace(string(data)); {
+	case strings.HasPrefix(text, "magnet:"):
+		ins, err = s.engine.PreviewMagnet(ctx, text)
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("no metadata within %s", timeout)
+		}
+	case strings.HasPrefix(text, "http://"), strings.HasPrefix(text, "https://"):
+		if data, err = fetchTorrentURL(text); err == nil {
+			ins, err = s.engine.PreviewTorrent(data)
+		}
+	default:
+		ins, err = s.engine.PreviewTorrent(data)
+	}
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(ins)
+}
+
+// apiConfigValidate is the dry-run of apiConfigure, responding what's wrong
+// with the posted config and what applying it requires
+func (s *Server) apiConfigValidate(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	c := engine.Config{}
+	if err := engine.DecodeConfigJSON(data, &c); err != nil {
+		var cerrs engine.ConfigErrors
+		if !errors.As(err, &cerrs) {
+			return err
+		}
+		// report as a failed check
+		w.Header().Set("Content-Type", "application/json")
+		check := engine.ConfigCheck{}
+		for _, ce := range cerrs {
+			check.Errors = append(check.Errors, engine.FieldError{Field: ce.Field, Error: ce.Error()})
+		}
+		return json.NewEncoder(w).Encode(check)
+	}
+	c.Unmask(s.engineConfig)
+	if _, err := c.NormlizeConfigDir(); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(s.engineConfig.Check(&c))
+}
+
+// configPatchResult is the response of PATCH /api/configure
+type configPatchResult struct {
+	Changed []string
+	Effects []string
+}
+
+// apiConfigPatch serves PATCH /api/configure, changing only the posted
+// fields of the config. The checks of the posted fields failing respond
+// the ConfigCheck with 400.
+func (s *Server) apiConfigPatch(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	if !s.engineConfig.AllowRuntimeConfigure {
+		return errors.New("AllowRuntimeConfigure is set to false")
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil || len(fields) == 0 {
+		return errInvalidReq
+	}
+
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	check := engine.ConfigCheck{}
+	c := *s.engineConfig
+	if err := engine.DecodeConfigJSON(data, &c); err != nil {
+		var cerrs engine.ConfigErrors
+		if !errors.As(err, &cerrs) {
+			return err
+		}
+		for _, ce := range cerrs {
+			check.Errors = append(check.Errors, engine.FieldError{Field: ce.Field, Error: ce.Error()})
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return json.NewEncoder(w).Encode(check)
+	}
+	c.Unmask(s.engineConfig)
+	if _, err := c.NormlizeConfigDir(); err != nil {
+		return err
+	}
+
+	// only the posted fields are checked, the others are as before
+	full := s.engineConfig.Check(&c)
+	for _, fe := range full.Errors {
+		for name := range fields {
+			if strings.EqualFold(name, fe.Field) {
+				check.Errors = append(check.Errors, fe)
+				break
+			}
+		}
+	}
+	if len(check.Errors) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return json.NewEncoder(w).Encode(check)
+	}
+
+	res := configPatchResult{
+		Changed: s.engineConfig.ChangedFields(&c),
+		Effects: full.Effects,
+	}
+	if err := s.applyConfig(c, r.URL.Query().Get("save") == "1"); err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(res)
+}
+
+// checkRestartFatal exits if the engine is left unconfigured by a failed restart
+func (s *Server) checkRestartFatal() {
+	if !s.engine.IsConfigred() {
+		go func() {
+			log.Println("[apiConfigure] serious error occured while reconfigured, will exit in 10s")
+			time.Sleep(time.Second * 10)
+			log.Fatalln(s.engine.RestartStatus().Error)
+		}()
+	}
+}
+
 func (s *Server) GetStrAttribute(name string) string {
 	cval := reflect.Indirect(reflect.ValueOf(s)).FieldByName(name)
 	return cval.String()
@@ -295,3 +1010,39 @@ func (s *Server) GetBoolAttribute(name string) bool {
 	cval := reflect.Indirect(reflect.ValueOf(s)).FieldByName(name)
 	return cval.Bool()
 }
+
+// addOptions are the options of an add from the web: ?paused=1 adds the task
+// stopped, ?paused=0 started, or else by the DefaultAddState. ?force=1 adds
+// even if the task looks like a duplicate.
+func addOptions(r *http.Request) (engine.AddOptions, error) {
+	opt := engine.AddOptions{
+		Source: engine.AddSourceWeb,
+		Force:  r.URL.Query().Get("force") != "",
+	}
+	switch r.URL.Query().Get("paused") {
+	case "1", "true":
+		opt.State = engine.AddPaused
+	case "0", "false":
+		opt.State = engine.AddStarted
+	}
+	// ?start_at=<RFC 3339 time> schedules the start
+	if at := r.URL.Query().Get("start_at"); at != "" {
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			return opt, fmt.Errorf("%w: %s", engine.ErrInvalidSchedule, err)
+		}
+		opt.StartAt = t
+	}
+	// ?group=<name> puts the task in the group
+	opt.Group = strings.TrimSpace(r.URL.Query().Get("group"))
+	// ?user=<name> for the DiskQuotas
+	opt.User = strings.TrimSpace(r.URL.Query().Get("user"))
+	// ?exclude=0 keeps the files the ExcludeFiles skip
+	switch r.URL.Query().Get("exclude") {
+	case "0", "false":
+		opt.KeepAllFiles = true
+	}
+	// ?after=<infohash> queues the task until that one completes
+	opt.After = strings.ToLower(strings.TrimSpace(r.URL.Query().Get("after")))
+	return opt, nil
+}
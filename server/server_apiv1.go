@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/boypt/simple-torrent/engine"
+	"github.com/boypt/simple-torrent/server/apiv1"
+)
+
+// apiV1 is the apiv1.Backend of the server's engine
+type apiV1 struct {
+	s *Server
+}
+
+func v1Torrent(t *engine.Torrent) apiv1.Torrent {
+	t.Lock()
+	defer t.Unlock()
+	vt := apiv1.Torrent{
+		InfoHash:     t.InfoHash,
+		Name:         t.Name,
+		Size:         t.Size,
+		Downloaded:   t.Downloaded,
+		Uploaded:     t.Uploaded,
+		Percent:      t.Percent,
+		DownloadRate: t.DownloadRate,
+		UploadRate:   t.UploadRate,
+		Started:      t.Started,
+		Done:         t.Done,
+		Status:       string(t.Status),
+		Error:        t.Error,
+		Labels:       append([]string{}, t.Labels...),
+		AddedAt:      t.AddedAt,
+		Files:        make([]apiv1.File, 0, len(t.Files)),
+	}
+	for _, f := range t.Files {
+		vt.Files = append(vt.Files, apiv1.File{
+			Path:      f.Path,
+			Size:      f.Size,
+			Completed: f.Completed,
+			Percent:   f.Percent,
+			Done:      f.Done,
+		})
+	}
+	return vt
+}
+
+// v1Error wraps the engine errors for the status codes
+func v1Error(err error) error {
+	var dup *engine.Duplicate
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, engine.ErrMissingTorrent):
+		return fmt.Errorf("%w: %s", apiv1.ErrNotFound, err)
+	case errors.Is(err, engine.ErrTaskExists), errors.As(err, &dup):
+		return fmt.Errorf("%w: %s", apiv1.ErrConflict, err)
+	case errors.Is(err, engine.ErrInvalidSchedule), errors.Is(err, engine.ErrInvalidGroup):
+		return fmt.Errorf("%w: %s", apiv1.ErrInvalid, err)
+	}
+	return err
+}
+
+func (a apiV1) Torrents() []apiv1.Torrent {
+	e := a.s.engine
+	e.RLock()
+	defer e.RUnlock()
+	ts := make([]apiv1.Torrent, 0, len(*e.GetTorrents()))
+	for _, t := range *e.GetTorrents() {
+		ts = append(ts, v1Torrent(t))
+	}
+	sort.Slice(ts, func(i, j int) bool { return ts[i].AddedAt.Before(ts[j].AddedAt) })
+	return ts
+}
+
+func (a apiV1) Torrent(infohash string) (apiv1.Torrent, error) {
+	e := a.s.engine
+	e.RLock()
+	defer e.RUnlock()
+	t, ok := (*e.GetTorrents())[infohash]
+	if !ok {
+		return apiv1.Torrent{}, fmt.Errorf("%w: %s", apiv1.ErrNotFound, infohash)
+	}
+	return v1Torrent(t), nil
+}
+
+func (a apiV1) Add(req apiv1.AddRequest) error {
+	opt := engine.AddOptions{Source: engine.AddSourceWeb, Force: req.Force}
+	if req.Paused != nil {
+		opt.State = engine.AddStarted
+		if *req.Paused {
+			opt.State = engine.AddPaused
+		}
+	}
+	if req.StartAt != nil {
+		opt.StartAt = *req.StartAt
+	}
+
+	var err error
+	switch {
+	case req.Magnet != "":
+		err = a.s.engine.NewMagnet(req.Magnet, opt)
+	case req.URL != "":
+		var data []byte
+		if data, err = fetchTorrentURL(req.URL); err != nil {
+			a.s.engine.RecordFailedAdd(engine.FailedURL, req.URL, nil, err)
+			return fmt.Errorf("%w: %s", apiv1.ErrInvalid, err)
+		}
+		err = a.s.engine.NewTorrentByReader(bytes.NewReader(data), opt)
+	default:
+		err = a.s.engine.NewTorrentByReader(bytes.NewReader(req.Torrent), opt)
+	}
+	// queued
+	if errors.Is(err, engine.ErrMaxConnTasks) {
+		err = nil
+	}
+	a.s.state.Push()
+	return v1Error(err)
+}
+
+func (a apiV1) Start(infohash string) error {
+	defer a.s.state.Push()
+	return v1Error(a.s.engine.ManualStartTorrent(infohash))
+}
+
+func (a apiV1) Stop(infohash string) error {
+	defer a.s.state.Push()
+	return v1Error(a.s.engine.StopTorrent(infohash))
+}
+
+func (a apiV1) Verify(infohash string) error {
+	defer a.s.state.Push()
+	return v1Error(a.s.engine.VerifyTorrent(infohash))
+}
+
+func (a apiV1) Delete(infohash string) error {
+	defer a.s.state.Push()
+	return v1Error(a.s.engine.RemoveTorrent(infohash, engine.RemovedByUser))
+}
+
+func (a apiV1) Stats() apiv1.Stats {
+	var st apiv1.Stats
+	e := a.s.engine
+	e.RLock()
+	for _, t := range *e.GetTorrents() {
+		t.Lock()
+		st.Torrents++
+		if t.Started {
+			if t.Done {
+				st.Seeding++
+			} else {
+				st.Downloading++
+			}
+		}
+		st.DownloadRate += t.DownloadRate
+		st.UploadRate += t.UploadRate
+		t.Unlock()
+	}
+	e.RUnlock()
+	cs := e.ConnStat()
+	st.BytesRead = cs.BytesReadData.Int64()
+	st.BytesWritten = cs.BytesWrittenData.Int64()
+	st.Statuses = make(map[string]int)
+	for s, n := range e.StatusCounts() {
+		st.Statuses[string(s)] = n
+	}
+	return st
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boypt/simple-torrent/engine"
+	"github.com/boypt/simple-torrent/server/s3"
+	"github.com/spf13/viper"
+)
+
+const backupFilePrefix = "cloud-torrent-backup-"
+
+// backupStat is the status of the scheduled backups, shown in Stats
+type backupStat struct {
+	LastAt    time.Time
+	LastFile  string
+	LastSize  int
+	LastError string
+}
+
+// backupTarget is where the scheduled backups saved to
+type backupTarget interface {
+	put(name string, data []byte) error
+	list() ([]string, error)
+	remove(name string) error
+}
+
+type localBackup struct {
+	dir string
+}
+
+func (l localBackup) put(name string, data []byte) error {
+	if err := os.MkdirAll(l.dir, 0755); err != nil {
+		return err
+	}
+	tmp := filepath.Join(l.dir, "."+name)
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(l.dir, name))
+}
+
+func (l localBackup) list() ([]string, error) {
+	files, err := ioutil.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range files {
+		if !f.IsDir() && strings.HasPrefix(f.Name(), backupFilePrefix) {
+			names = append(names, f.Name())
+		}
+	}
+	return names, nil
+}
+
+func (l localBackup) remove(name string) error {
+	return os.Remove(filepath.Join(l.dir, name))
+}
+
+type s3Backup struct {
+	client *s3.Client
+	prefix string
+}
+
+func (b s3Backup) key(name string) string {
+	if b.prefix == "" {
+		return name
+	}
+	return strings.TrimSuffix(b.prefix, "/") + "/" + name
+}
+
+func (b s3Backup) put(name string, data []byte) error {
+	return b.client.Put(b.key(name), data)
+}
+
+func (b s3Backup) list() ([]string, error) {
+	keys, err := b.client.List(b.key(backupFilePrefix))
+	if err != nil {
+		return nil, err
+	}
+	for i, k := range keys {
+		keys[i] = k[strings.LastIndex(k, "/")+1:]
+	}
+	return keys, nil
+}
+
+func (b s3Backup) remove(name string) error {
+	return b.client.Delete(b.key(name))
+}
+
+func newBackupTarget(location string) (backupTarget, error) {
+	location, err := engine.ResolveSecrets(location)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(location, "s3://") {
+		c, prefix, err := s3.ParseURL(location)
+		if err != nil {
+			return nil, err
+		}
+		return s3Backup{c, prefix}, nil
+	}
+	return localBackup{location}, nil
+}
+
+// scheduledBackup writes a backup to BackupLocation and removes the ones
+// beyond BackupRetention
+func (s *Server) scheduledBackup() error {
+	c := s.config()
+	target, err := newBackupTarget(c.BackupLocation)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := s.engine.WriteBackup(&buf, viper.ConfigFileUsed()); err != nil {
+		return err
+	}
+	// the timestamp sorts the names by time
+	name := fmt.Sprintf("%s%s.tar.gz", backupFilePrefix, time.Now().Format("20060102-150405"))
+	if err := target.put(name, buf.Bytes()); err != nil {
+		return err
+	}
+	s.state.Lock()
+	s.state.Stats.Backup.LastFile = name
+	s.state.Stats.Backup.LastSize = buf.Len()
+	s.state.Unlock()
+	log.Println("[backup] saved", name, buf.Len())
+
+	if c.BackupRetention <= 0 {
+		return nil
+	}
+	names, err := target.list()
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for len(names) > c.BackupRetention {
+		if err := target.remove(names[0]); err != nil {
+			return err
+		}
+		log.Println("[backup] removed", names[0])
+		names = names[1:]
+	}
+	return nil
+}
+
+func (s *Server) backupRoutine() {
+	tk := time.NewTicker(time.Minute)
+	defer tk.Stop()
+	for range tk.C {
+		c := s.config()
+		itv := c.BackupInterval
+		s.state.Lock()
+		lastAt := s.state.Stats.Backup.LastAt
+		s.state.Unlock()
+		if itv <= 0 || c.BackupLocation == "" || time.Since(lastAt) < itv {
+			continue
+		}
+		s.state.Lock()
+		s.state.Stats.Backup.LastAt = time.Now()
+		s.state.Stats.Backup.LastError = ""
+		s.state.Unlock()
+		if err := s.scheduledBackup(); err != nil {
+			s.state.Lock()
+			s.state.Stats.Backup.LastError = err.Error()
+			s.state.Unlock()
+			log.Println("[backup]", err)
+		}
+	}
+}
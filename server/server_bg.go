@@ -1,17 +1,31 @@
 package server
 
 import (
-	"strings"
 	"sync/atomic"
 	"time"
+
+	"github.com/boypt/simple-torrent/engine"
 )
 
+// liveTorrents encodes the tasks of the engine when the state is pushed,
+// each task locked on its own
+type liveTorrents struct {
+	e *engine.Engine
+}
+
+func (l liveTorrents) MarshalJSON() ([]byte, error) {
+	if l.e == nil {
+		return []byte("{}"), nil
+	}
+	return l.e.MarshalTorrents(nil)
+}
+
 func (s *Server) backgroundRoutines() {
 
 	go s.fetchSearchConfig(s.engineConfig.ScraperURL) // nolint: errcheck
 
 	// initial state
-	s.state.Stats.System.loadStats()
+	s.refreshStats()
 	//collecting sys stats
 	go func() {
 		for {
@@ -21,27 +35,36 @@ func (s *Server) backgroundRoutines() {
 					go s.tickerRoutine()
 				}
 			case <-s.engine.TsChanged: // task added/deleted
-				s.engine.RLock()
+				failed, maintenance := s.engine.FailedAdds(), s.engine.Maintenance()
+				volume, quotas := s.engine.Volume(), s.engine.Quotas()
+				transferQuota := s.engine.TransferQuotaStatus()
+				s.state.Lock()
+				s.state.FailedAdds, s.state.Maintenance = failed, maintenance
+				s.state.Volume, s.state.Quotas = volume, quotas
+				s.state.TransferQuota = transferQuota
+				s.state.Unlock()
 				s.state.Push()
-				s.engine.RUnlock()
 			}
 		}
 	}()
 
-	// rss updater
-	go func() {
-		// skip if not configured
-		if strings.TrimSpace(s.engineConfig.RssURL) == "" {
-			return
-		}
+	// the activity of the /feed.xml
+	if s.FeedToken != "" {
+		go s.recordActivity()
+	}
 
-		s.updateRSS()
-		tk := time.NewTicker(30 * time.Minute)
-		defer tk.Stop()
-		for range tk.C {
-			s.updateRSS()
-		}
-	}()
+	// the history of the RSS items downloaded
+	go s.recordRSSHistory()
+
+	// rss updater, the tracker list refresher is the engine's
+	s.engine.AddBackgroundTask("rss", s.rssInterval, s.updateRSS)
+	s.engine.TriggerBackgroundTask("rss") // nolint: errcheck
+
+	// cluster nodes poller
+	go s.clusterRoutine()
+
+	// scheduled backups
+	go s.backupRoutine()
 
 	go s.engine.RestoreCacheDir()
 	if err := s.engine.StartTorrentWatcher(); err != nil {
@@ -67,14 +90,24 @@ func (s *Server) tickerRoutine() {
 	for {
 		select {
 		case <-tk.C:
-			s.state.Stats.System.loadStats()
-			s.state.Stats.ConnStat = s.engine.ConnStat()
-			s.engine.RLock()
+			s.refreshStats()
 			s.state.Push()
-			s.engine.RUnlock()
 		case <-done:
 			log.Println("[tickerRoutine] sync exit")
 			return
 		}
 	}
 }
+
+// refreshStats samples the system and the engine out of the state lock, a
+// slow disk stat doesn't hold the pushes
+func (s *Server) refreshStats() {
+	s.state.Lock()
+	sys := s.state.Stats.System
+	s.state.Unlock()
+	sys.loadStats()
+	connStat, trackers := s.engine.ConnStat(), s.engine.TrackerStat()
+	s.state.Lock()
+	s.state.Stats.System, s.state.Stats.ConnStat, s.state.Stats.Trackers = sys, connStat, trackers
+	s.state.Unlock()
+}
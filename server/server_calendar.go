@@ -0,0 +1,90 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/boypt/simple-torrent/engine"
+	"github.com/boypt/simple-torrent/server/httpmiddleware"
+)
+
+const (
+	calendarPath = "/calendar.ics"
+	icsTime      = "20060102T150405Z"
+)
+
+// icsEscape escapes a TEXT value of iCalendar
+var icsEscape = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`, "\r", "")
+
+// writeICSLine writes a content line, folded at 75 octets as RFC 5545 asks
+func writeICSLine(b *bytes.Buffer, line string) {
+	// the continuation lines start with a space
+	for max := 75; len(line) > max; max = 74 {
+		n := max
+		// not in the middle of an utf-8 sequence
+		for n > 0 && line[n]&0xC0 == 0x80 {
+			n--
+		}
+		b.WriteString(line[:n] + "\r\n ")
+		line = line[n:]
+	}
+	b.WriteString(line + "\r\n")
+}
+
+func writeICSEvent(b *bytes.Buffer, uid, summary, desc string, at, stamp time.Time) {
+	writeICSLine(b, "BEGIN:VEVENT")
+	writeICSLine(b, "UID:"+uid+"@simple-torrent")
+	writeICSLine(b, "DTSTAMP:"+stamp.UTC().Format(icsTime))
+	writeICSLine(b, "DTSTART:"+at.UTC().Format(icsTime))
+	writeICSLine(b, "SUMMARY:"+icsEscape.Replace(summary))
+	if desc != "" {
+		writeICSLine(b, "DESCRIPTION:"+icsEscape.Replace(desc))
+	}
+	writeICSLine(b, "END:VEVENT")
+}
+
+// serveCalendar serves the iCalendar of the scheduled starts and the
+// completions at /calendar.ics?token=, with the token of the feed
+func (s *Server) serveCalendar(w http.ResponseWriter, r *http.Request) {
+	if s.FeedToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !httpmiddleware.ValidToken(r.URL.Query().Get("token"), s.FeedToken) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	now := time.Now()
+	var b bytes.Buffer
+	writeICSLine(&b, "BEGIN:VCALENDAR")
+	writeICSLine(&b, "VERSION:2.0")
+	writeICSLine(&b, "PRODID:-//simple-torrent//"+s.tpl.Version+"//EN")
+	writeICSLine(&b, "X-WR-CALNAME:"+icsEscape.Replace(s.Title))
+
+	s.engine.RLock()
+	for ih, t := range *s.engine.GetTorrents() {
+		t.Lock()
+		if t.ScheduledStart != nil {
+			writeICSEvent(&b, ih+"-start", "Start: "+t.Name,
+				fmt.Sprintf("Scheduled start of %s", ih), *t.ScheduledStart, now)
+		}
+		t.Unlock()
+	}
+	s.engine.RUnlock()
+
+	for _, ev := range s.activity.list(false) {
+		if ev.Type != engine.EventComplete {
+			continue
+		}
+		writeICSEvent(&b, fmt.Sprintf("%s-complete-%d", ev.InfoHash, ev.Time.Unix()),
+			"Completed: "+ev.Name, ev.Message, ev.Time, ev.Time)
+	}
+	writeICSLine(&b, "END:VCALENDAR")
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Write(b.Bytes())
+}
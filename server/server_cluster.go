@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boypt/simple-torrent/engine"
+)
+
+const clusterPollInterval = 10 * time.Second
+
+var (
+	errNoClusterNode = errors.New("No cluster node available")
+	clusterClient    = &http.Client{Timeout: 15 * time.Second}
+)
+
+// clusterTorrent is the subset of a remote task used by the frontend
+type clusterTorrent struct {
+	InfoHash     string
+	Name         string
+	Loaded       bool
+	Started      bool
+	Done         bool
+	Percent      float32
+	Size         int64
+	DownloadRate float32
+	UploadRate   float32
+}
+
+// clusterNode is a remote simple-torrent instance controlled by this one
+type clusterNode struct {
+	Name     string
+	URL      string
+	Online   bool
+	Error    string
+	LastSeen time.Time
+	Active   int
+	Torrents map[string]*clusterTorrent
+	auth     string
+}
+
+type cluster struct {
+	sync.RWMutex
+	conf  string
+	nodes []*clusterNode
+}
+
+// parseClusterNodes parses lines of `<name> <url> [user:password|bearer:token]`,
+// the credentials can be secret references like ${file:/run/secrets/nas}
+func parseClusterNodes(conf string) ([]*clusterNode, error) {
+	conf, err := engine.ResolveSecrets(conf)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []*clusterNode
+	sc := bufio.NewScanner(strings.NewReader(conf))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 || !strings.HasPrefix(fields[1], "http") {
+			return nil, fmt.Errorf("Invalid cluster node: %s", fields[0])
+		}
+		n := &clusterNode{
+			Name: fields[0],
+			URL:  strings.TrimSuffix(fields[1], "/"),
+		}
+		if len(fields) == 3 {
+			n.auth = fields[2]
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, sc.Err()
+}
+
+func (n *clusterNode) request(method, api string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, n.URL+"/api/"+api, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if up := strings.SplitN(n.auth, ":", 2); len(up) == 2 {
+		if up[0] == "bearer" {
+			// the node's --api-token
+			req.Header.Set("Authorization", "Bearer "+up[1])
+		} else {
+			req.SetBasicAuth(up[0], up[1])
+		}
+	}
+	resp, err := clusterClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+func (n *clusterNode) poll() {
+	data, err := n.request("GET", "torrents", nil)
+	ts := make(map[string]*clusterTorrent)
+	if err == nil {
+		err = json.Unmarshal(data, &ts)
+	}
+	if err != nil {
+		n.Online = false
+		n.Error = err.Error()
+		return
+	}
+	n.Online = true
+	n.Error = ""
+	n.LastSeen = time.Now()
+	n.Torrents = ts
+	n.Active = 0
+	for _, t := range ts {
+		if t.Started && !t.Done {
+			n.Active++
+		}
+	}
+}
+
+// clusterRoutine polls the state of the remote nodes, the node list is
+// re-read from the config on every round
+func (s *Server) clusterRoutine() {
+	s.pollCluster()
+	tk := time.NewTicker(clusterPollInterval)
+	defer tk.Stop()
+	for range tk.C {
+		s.pollCluster()
+	}
+}
+
+func (s *Server) pollCluster() {
+	conf := s.config().ClusterNodes
+	if conf != s.cluster.conf {
+		nodes, err := parseClusterNodes(conf)
+		if err != nil {
+			log.Println("[cluster]", err)
+		}
+		s.cluster.Lock()
+		s.cluster.conf = conf
+		s.cluster.nodes = nodes
+		s.cluster.Unlock()
+	}
+
+	nodes := s.clusterNodes()
+	if len(nodes) == 0 {
+		return
+	}
+
+	// poll on copies, the readers keep the previous round
+	var wg sync.WaitGroup
+	polled := make([]*clusterNode, len(nodes))
+	for i, n := range nodes {
+		wg.Add(1)
+		go func(i int, n clusterNode) {
+			defer wg.Done()
+			n.poll()
+			polled[i] = &n
+		}(i, *n)
+	}
+	wg.Wait()
+
+	s.cluster.Lock()
+	if s.cluster.conf == conf {
+		s.cluster.nodes = polled
+	}
+	s.cluster.Unlock()
+}
+
+func (s *Server) clusterNodes() []*clusterNode {
+	s.cluster.RLock()
+	defer s.cluster.RUnlock()
+	return s.cluster.nodes
+}
+
+// pickClusterNode returns the named node, or the online one with least
+// active tasks if name is empty
+func (s *Server) pickClusterNode(name string) (*clusterNode, error) {
+	var picked *clusterNode
+	for _, n := range s.clusterNodes() {
+		if name != "" {
+			if n.Name == name {
+				return n, nil
+			}
+			continue
+		}
+		if n.Online && (picked == nil || n.Active < picked.Active) {
+			picked = n
+		}
+	}
+	if picked == nil {
+		return nil, errNoClusterNode
+	}
+	return picked, nil
+}
+
+// clusterDispatch forwards an add request (magnet/url/torrentfile) to a node
+func (s *Server) clusterDispatch(action, name string, data []byte) error {
+	switch action {
+	case "magnet", "url", "torrentfile":
+	default:
+		return errUnknowAct
+	}
+	n, err := s.pickClusterNode(name)
+	if err != nil {
+		return err
+	}
+	log.Printf("[cluster] dispatching %s to node %s", action, n.Name)
+	if _, err := n.request("POST", action, data); err != nil {
+		return fmt.Errorf("ERROR: node %s: %w", n.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/boypt/simple-torrent/common"
+)
+
+var errPprofDisabled = errors.New("Profiling is disabled, set EnablePprof to enable")
+
+// debugSummary is the runtime state of the process, for diagnosing the
+// memory growth without the profiler
+type debugSummary struct {
+	Uptime        string
+	Tasks         int
+	Goroutines    int
+	HeapAlloc     uint64
+	HeapInuse     uint64
+	HeapIdle      uint64
+	HeapReleased  uint64
+	HeapObjects   uint64
+	StackInuse    uint64
+	Sys           uint64
+	NumGC         uint32
+	LastGC        time.Time
+	LastGCPause   time.Duration
+	GCPauseTotal  time.Duration
+	GCCPUFraction float64
+	NextGC        uint64
+}
+
+// apiDebug serves GET /api/debug/summary, and the pprof handlers at
+// /api/debug/pprof/ if EnablePprof
+func (s *Server) apiDebug(w http.ResponseWriter, r *http.Request) error {
+	name := strings.TrimPrefix(r.URL.Path, "/api/debug/")
+	if name == "summary" {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		s.engine.RLock()
+		tasks := len(*s.engine.GetTorrents())
+		s.engine.RUnlock()
+		sum := debugSummary{
+			Uptime:        time.Since(time.Unix(s.tpl.Uptime, 0)).Round(time.Second).String(),
+			Tasks:         tasks,
+			Goroutines:    runtime.NumGoroutine(),
+			HeapAlloc:     ms.HeapAlloc,
+			HeapInuse:     ms.HeapInuse,
+			HeapIdle:      ms.HeapIdle,
+			HeapReleased:  ms.HeapReleased,
+			HeapObjects:   ms.HeapObjects,
+			StackInuse:    ms.StackInuse,
+			Sys:           ms.Sys,
+			NumGC:         ms.NumGC,
+			GCPauseTotal:  time.Duration(ms.PauseTotalNs),
+			GCCPUFraction: ms.GCCPUFraction,
+			NextGC:        ms.NextGC,
+		}
+		if ms.NumGC > 0 {
+			sum.LastGC = time.Unix(0, int64(ms.LastGC))
+			sum.LastGCPause = time.Duration(ms.PauseNs[(ms.NumGC+255)%256])
+		}
+		common.HandleError(json.NewEncoder(w).Encode(sum))
+		return nil
+	}
+
+	if name != "pprof" && !strings.HasPrefix(name, "pprof/") {
+		return errUnknowPath
+	}
+	if !s.engineConfig.EnablePprof {
+		return errPprofDisabled
+	}
+	// the pprof handlers expect /debug/pprof/
+	r.URL.Path = strings.TrimPrefix(r.URL.Path, "/api")
+	switch strings.TrimPrefix(name, "pprof/") {
+	case "cmdline":
+		pprof.Cmdline(w, r)
+	case "profile":
+		pprof.Profile(w, r)
+	case "symbol":
+		pprof.Symbol(w, r)
+	case "trace":
+		pprof.Trace(w, r)
+	default:
+		if name == "pprof" {
+			r.URL.Path += "/"
+		}
+		pprof.Index(w, r)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/boypt/simple-torrent/engine"
+	"github.com/boypt/simple-torrent/plugin"
+	"github.com/boypt/simple-torrent/server/httpmiddleware"
+	"github.com/dustin/go-humanize"
+)
+
+const (
+	feedPath = "/feed.xml"
+	// the events kept for the feed
+	feedItems = 100
+)
+
+// activityLog keeps the last completions and errors since the start, for
+// the feed and the calendar
+type activityLog struct {
+	sync.Mutex
+	events []plugin.Event
+}
+
+func (a *activityLog) add(ev plugin.Event) {
+	a.Lock()
+	defer a.Unlock()
+	a.events = append(a.events, ev)
+	if n := len(a.events) - feedItems; n > 0 {
+		a.events = append([]plugin.Event(nil), a.events[n:]...)
+	}
+}
+
+// list is the events newest first
+func (a *activityLog) list(withErrors bool) []plugin.Event {
+	a.Lock()
+	defer a.Unlock()
+	res := make([]plugin.Event, 0, len(a.events))
+	for i := len(a.events) - 1; i >= 0; i-- {
+		if ev := a.events[i]; ev.Type == engine.EventComplete || withErrors {
+			res = append(res, ev)
+		}
+	}
+	return res
+}
+
+// recordActivity fills the activity log of the feed from the task events
+func (s *Server) recordActivity() {
+	events, _ := s.engine.SubscribeEvents(16)
+	for ev := range events {
+		switch ev.Type {
+		case engine.EventComplete, engine.EventError:
+			s.activity.add(ev)
+		}
+	}
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link,omitempty"`
+	Description string        `xml:"description"`
+	GUID        rssGUID       `xml:"guid"`
+	PubDate     string        `xml:"pubDate"`
+	Categories  []string      `xml:"category"`
+	Enclosure   *rssEnclosure `xml:"enclosure,omitempty"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// serveFeed serves the RSS feed of the completed tasks at /feed.xml?token=,
+// with the errors too by &errors=1
+func (s *Server) serveFeed(w http.ResponseWriter, r *http.Request) {
+	if s.FeedToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !httpmiddleware.ValidToken(r.URL.Query().Get("token"), s.FeedToken) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       s.Title,
+			Link:        fmt.Sprintf("%s://%s/", scheme, r.Host),
+			Description: "The tasks finished by " + s.Title,
+		},
+	}
+	for _, ev := range s.activity.list(r.URL.Query().Get("errors") != "") {
+		item := rssItem{
+			Title:       ev.Name,
+			Description: fmt.Sprintf("Completed, %s", humanize.Bytes(uint64(ev.Size))),
+			GUID:        rssGUID{Value: fmt.Sprintf("%s-%s-%d", ev.InfoHash, ev.Type, ev.Time.Unix())},
+			PubDate:     ev.Time.Format(time.RFC1123Z),
+			Categories:  ev.Labels,
+		}
+		if ev.Type == engine.EventError {
+			item.Title = "Error: " + ev.Name
+			item.Description = ev.Message
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		log.Println("[Feed]", err)
+	}
+}
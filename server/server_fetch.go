@@ -0,0 +1,199 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boypt/simple-torrent/engine"
+)
+
+// fetchRule is how the requests to a host of the search providers or RSS
+// feeds are made
+type fetchRule struct {
+	proxy      string
+	headers    map[string]string
+	timeout    time.Duration
+	cloudflare bool
+}
+
+// fetchTransport routes the search and RSS requests by host with the proxy,
+// headers and timeout of their rules. The scraper only uses the
+// http.DefaultClient, so it's installed as its transport, the requests to
+// the other hosts pass through untouched.
+type fetchTransport struct {
+	sync.RWMutex
+	rules        map[string]*fetchRule
+	transports   map[string]*http.Transport
+	flareSolverr string
+}
+
+var _ http.RoundTripper = (*fetchTransport)(nil)
+
+// cancelBody releases the timeout context of a response on close
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+func (ft *fetchTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ft.RLock()
+	rule := ft.rules[req.URL.Hostname()]
+	direct := ft.transports[""]
+	tr := direct
+	if rule != nil {
+		tr = ft.transports[rule.proxy]
+	}
+	var solver string
+	if rule != nil && rule.cloudflare {
+		solver = ft.flareSolverr
+	}
+	ft.RUnlock()
+	if rule == nil || tr == nil {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+
+	ctx, cancel := req.Context(), context.CancelFunc(func() {})
+	if rule.timeout > 0 {
+		ctx, cancel = context.WithTimeout(req.Context(), rule.timeout)
+	}
+	req = req.Clone(ctx)
+	for k, v := range rule.headers {
+		req.Header.Set(k, v)
+	}
+	var resp *http.Response
+	var err error
+	if solver != "" {
+		resp, err = flareSolve(solver, req, rule, direct)
+	} else {
+		resp, err = tr.RoundTrip(req)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{resp.Body, cancel}
+	return resp, nil
+}
+
+// update rebuilds the rules from the config, for the hosts of the search
+// providers and RSS feeds, then applies the SearchOverrides
+func (ft *fetchTransport) update(c *engine.Config, providers map[string]string) error {
+	ovs, err := engine.ParseFetchOverrides(c.SearchOverrides)
+	if err != nil {
+		return err
+	}
+	def := fetchRule{timeout: c.SearchTimeout}
+	if c.SearchUseProxy && c.ProxyURL != "" {
+		if def.proxy, err = engine.ResolveSecrets(c.ProxyURL); err != nil {
+			return err
+		}
+	}
+
+	rules := make(map[string]*fetchRule)
+	ruleOf := func(host string) *fetchRule {
+		r, ok := rules[host]
+		if !ok {
+			r = &fetchRule{proxy: def.proxy, timeout: def.timeout}
+			rules[host] = r
+		}
+		return r
+	}
+	for _, u := range providers {
+		ruleOf(engine.URLHost(u))
+	}
+	for _, u := range strings.Split(c.RssURL, "\n") {
+		if u = strings.TrimSpace(u); strings.HasPrefix(u, "http") {
+			ruleOf(engine.URLHost(u))
+		}
+	}
+	for _, o := range ovs {
+		var hosts []string
+		for id, u := range providers {
+			if id == o.Target || strings.HasPrefix(id, o.Target+"/") {
+				hosts = append(hosts, engine.URLHost(u))
+			}
+		}
+		if len(hosts) == 0 {
+			hosts = []string{o.Target}
+		}
+		for _, h := range hosts {
+			r := ruleOf(h)
+			switch o.Proxy {
+			case "":
+			case engine.FetchDirect:
+				r.proxy = ""
+			default:
+				r.proxy = o.Proxy
+			}
+			if o.Timeout > 0 {
+				r.timeout = o.Timeout
+			}
+			if o.Cloudflare {
+				r.cloudflare = true
+			}
+			if len(o.Headers) > 0 && r.headers == nil {
+				r.headers = make(map[string]string)
+			}
+			for k, v := range o.Headers {
+				r.headers[k] = v
+			}
+		}
+	}
+
+	resolver, err := engine.NewResolver(c.DNSResolver)
+	if err != nil {
+		return err
+	}
+	direct := http.DefaultTransport.(*http.Transport).Clone()
+	direct.DialContext = engine.DialContext(resolver)
+	transports := map[string]*http.Transport{"": direct}
+	for _, r := range rules {
+		if _, ok := transports[r.proxy]; ok {
+			continue
+		}
+		pu, err := url.Parse(r.proxy)
+		if err != nil {
+			return err
+		}
+		tr := direct.Clone()
+		tr.Proxy = http.ProxyURL(pu)
+		transports[r.proxy] = tr
+	}
+
+	ft.Lock()
+	old := ft.transports
+	ft.rules, ft.transports = rules, transports
+	ft.flareSolverr = c.FlareSolverrURL
+	ft.Unlock()
+	for _, tr := range old {
+		tr.CloseIdleConnections()
+	}
+	return nil
+}
+
+// updateFetcher applies the current config and search providers to the
+// fetch rules
+func (s *Server) updateFetcher() {
+	if err := s.fetcher.update(s.engineConfig, s.scraperProviders()); err != nil {
+		log.Println("[SearchOverrides]", err)
+	}
+}
+
+// searchTestResult is the reachability of a search provider
+type searchTestResult struct {
+	Provider string
+	URL      string
+	Status   int
+	Latency  int64
+	Error    string
+}
@@ -8,13 +8,18 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/boypt/simple-torrent/common"
 	"github.com/jpillora/archive"
 )
 
-const fileNumberLimit = 65535
+const (
+	fileNumberLimit = 65535
+	// the walk of the downloads shared by the requests meanwhile
+	filesCacheAge = 3 * time.Second
+)
 
 type fsNode struct {
 	Name     string
@@ -23,7 +28,42 @@ type fsNode struct {
 	Children []*fsNode
 }
 
+// filesState is the last walk of the DownloadDirectory, locked on its own
+// and never during a walk, the tree not changed once walked
+type filesState struct {
+	sync.Mutex
+	root *fsNode
+	at   time.Time
+	// closed when the walk running is done
+	walking chan struct{}
+}
+
+func (f *filesState) invalidate() {
+	f.Lock()
+	f.root = nil
+	f.Unlock()
+}
+
+// listFiles is the tree of the downloads, one walk at a time for the
+// requests arriving meanwhile
 func (s *Server) listFiles() *fsNode {
+	fs := &s.files
+	fs.Lock()
+	if fs.root != nil && time.Since(fs.at) < filesCacheAge {
+		defer fs.Unlock()
+		return fs.root
+	}
+	if walking := fs.walking; walking != nil {
+		fs.Unlock()
+		<-walking
+		fs.Lock()
+		defer fs.Unlock()
+		return fs.root
+	}
+	done := make(chan struct{})
+	fs.walking = done
+	fs.Unlock()
+
 	rootDir := s.engineConfig.DownloadDirectory
 	root := &fsNode{}
 	if info, err := os.Stat(rootDir); err == nil {
@@ -31,6 +71,11 @@ func (s *Server) listFiles() *fsNode {
 			log.Printf("File listing failed: %s", err)
 		}
 	}
+
+	fs.Lock()
+	fs.root, fs.at, fs.walking = root, time.Now(), nil
+	fs.Unlock()
+	close(done)
 	return root
 }
 
@@ -69,11 +114,31 @@ func (s *Server) serveDownloadFiles(w http.ResponseWriter, r *http.Request) {
 		if err := os.RemoveAll(file); err != nil {
 			http.Error(w, "Delete failed: "+err.Error(), http.StatusInternalServerError)
 		}
+		s.files.invalidate()
 	default:
 		http.Error(w, "Not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
+// serveStream plays a file of a task while it's downloading:
+// /stream/<infohash>/<file path>, the reads wait for the pieces
+func (s *Server) serveStream(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(r.URL.Path, "/", 2)
+	if len(parts) != 2 {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	sr, err := s.engine.StreamFile(parts[0], parts[1])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer sr.Close()
+	// avoid gzip buffering
+	w.Header().Set("Content-Encoding", "identity")
+	http.ServeContent(w, r, sr.Name, time.Time{}, sr)
+}
+
 //custom directory walk
 
 func list(path string, info os.FileInfo, node *fsNode, n *uint) error {
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// the FlareSolverr's own default
+const flareSolverrTimeout = 60 * time.Second
+
+type flareSolverrCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type flareSolverrRequest struct {
+	Cmd        string               `json:"cmd"`
+	URL        string               `json:"url"`
+	PostData   string               `json:"postData,omitempty"`
+	MaxTimeout int64                `json:"maxTimeout"`
+	Cookies    []flareSolverrCookie `json:"cookies,omitempty"`
+	Proxy      *struct {
+		URL string `json:"url"`
+	} `json:"proxy,omitempty"`
+}
+
+type flareSolverrResponse struct {
+	Status   string `json:"status"`
+	Message  string `json:"message"`
+	Solution struct {
+		URL      string            `json:"url"`
+		Status   int               `json:"status"`
+		Headers  map[string]string `json:"headers"`
+		Response string            `json:"response"`
+	} `json:"solution"`
+}
+
+// flareSolve makes the request by the FlareSolverr, which passes the
+// cloudflare challenge with a real browser, and responds the page as if
+// fetched directly
+func flareSolve(solver string, req *http.Request, rule *fetchRule, tr http.RoundTripper) (*http.Response, error) {
+	fr := flareSolverrRequest{
+		Cmd:        "request.get",
+		URL:        req.URL.String(),
+		MaxTimeout: flareSolverrTimeout.Milliseconds(),
+	}
+	if rule.timeout > 0 {
+		fr.MaxTimeout = rule.timeout.Milliseconds()
+	}
+	if req.Method == http.MethodPost {
+		fr.Cmd = "request.post"
+		if req.Body != nil {
+			body, err := ioutil.ReadAll(req.Body)
+			req.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			fr.PostData = string(body)
+		}
+	}
+	for _, c := range req.Cookies() {
+		fr.Cookies = append(fr.Cookies, flareSolverrCookie{c.Name, c.Value})
+	}
+	if rule.proxy != "" {
+		fr.Proxy = &struct {
+			URL string `json:"url"`
+		}{rule.proxy}
+	}
+
+	data, err := json.Marshal(fr)
+	if err != nil {
+		return nil, err
+	}
+	freq, err := http.NewRequestWithContext(req.Context(), http.MethodPost,
+		strings.TrimRight(solver, "/")+"/v1", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	freq.Header.Set("Content-Type", "application/json")
+	resp, err := tr.RoundTrip(freq)
+	if err != nil {
+		return nil, fmt.Errorf("FlareSolverr: %w", err)
+	}
+	defer resp.Body.Close()
+	var fres flareSolverrResponse
+	if err := json.NewDecoder(resp.Body).Decode(&fres); err != nil {
+		return nil, fmt.Errorf("FlareSolverr: %s %w", resp.Status, err)
+	}
+	if fres.Status != "ok" {
+		return nil, fmt.Errorf("FlareSolverr: %s", fres.Message)
+	}
+
+	sol := fres.Solution
+	header := make(http.Header)
+	for k, v := range sol.Headers {
+		header.Set(k, v)
+	}
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "text/html; charset=utf-8")
+	}
+	// the body is decoded already
+	header.Del("Content-Encoding")
+	header.Del("Content-Length")
+	if sol.Status == 0 {
+		sol.Status = http.StatusOK
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", sol.Status, http.StatusText(sol.Status)),
+		StatusCode:    sol.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(strings.NewReader(sol.Response)),
+		ContentLength: int64(len(sol.Response)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boypt/simple-torrent/engine"
+)
+
+const (
+	healthCacheTTL   = 30 * time.Minute
+	healthScrapeWait = 10 * time.Second
+	maxHealthHashes  = 50
+)
+
+// swarmHealth is the scraped swarm of a search result with a 0-100 score
+type swarmHealth struct {
+	engine.SwarmStat
+	Score     int
+	CheckedAt time.Time
+}
+
+// healthCache keeps the scrapes to avoid hammering the trackers
+var healthCache = struct {
+	sync.Mutex
+	m map[string]swarmHealth
+}{m: make(map[string]swarmHealth)}
+
+// healthScore grows with the log of the seeders up to 80, the rest is the
+// seeders share of the swarm. A torrent without seeders scores 0.
+func healthScore(seeders, leechers int) int {
+	if seeders <= 0 {
+		return 0
+	}
+	score := math.Min(80, 30*math.Log10(float64(seeders)+1))
+	score += 20 * float64(seeders) / float64(seeders+leechers)
+	return int(math.Round(score))
+}
+
+// swarmHealthOf scrapes the infohashes not in the cache, with the trackers
+// given by the search results plus the configured ones
+func (s *Server) swarmHealthOf(infohashes, trackers []string) map[string]swarmHealth {
+	res := make(map[string]swarmHealth)
+	var missing []string
+
+	healthCache.Lock()
+	for _, ih := range infohashes {
+		ih = strings.ToLower(ih)
+		if h, ok := healthCache.m[ih]; ok && time.Since(h.CheckedAt) < healthCacheTTL {
+			res[ih] = h
+		} else if len(ih) == 40 {
+			missing = append(missing, ih)
+		}
+	}
+	healthCache.Unlock()
+	if len(missing) == 0 {
+		return res
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), healthScrapeWait)
+	defer cancel()
+	stats := engine.ScrapeTrackers(ctx, append(trackers, s.engine.GetTrackers()...), missing)
+
+	now := time.Now()
+	healthCache.Lock()
+	defer healthCache.Unlock()
+	for ih, h := range healthCache.m {
+		if now.Sub(h.CheckedAt) > healthCacheTTL {
+			delete(healthCache.m, ih)
+		}
+	}
+	for _, ih := range missing {
+		st := stats[ih]
+		h := swarmHealth{
+			SwarmStat: st,
+			Score:     healthScore(st.Seeders, st.Leechers),
+			CheckedAt: now,
+		}
+		healthCache.m[ih] = h
+		res[ih] = h
+	}
+	return res
+}
+
+// apiHealth serves GET /api/health?ih=<hash>&ih=<hash>&tr=<tracker url>
+func (s *Server) apiHealth(r *http.Request) (map[string]swarmHealth, error) {
+	q := r.URL.Query()
+	ihs := q["ih"]
+	if len(ihs) == 0 || len(ihs) > maxHealthHashes {
+		return nil, errInvalidReq
+	}
+	return s.swarmHealthOf(ihs, q["tr"]), nil
+}
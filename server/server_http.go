@@ -1,19 +1,27 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/boypt/simple-torrent/common"
+	"github.com/boypt/simple-torrent/engine"
+	"github.com/boypt/simple-torrent/server/apiv1"
 	ctstatic "github.com/boypt/simple-torrent/static"
 	"github.com/jpillora/velox"
 )
 
 var (
-	htmlTPL map[string]*template.Template
+	// the server templates, replaced with the theme
+	htmlTPLMu sync.RWMutex
+	htmlTPL   map[string]*template.Template
 )
 
 func (s *Server) webHandle(w http.ResponseWriter, r *http.Request) {
@@ -21,30 +29,65 @@ func (s *Server) webHandle(w http.ResponseWriter, r *http.Request) {
 	switch r.URL.Path {
 
 	case "/", "index.html":
-		common.HandleError(htmlTPL["index.html"].Execute(w, s.tpl))
+		tpl := *s.tpl
+		tpl.Assets = ctstatic.Fingerprint()
+		if s.AdminListen != "" && r.Context().Value(adminCtxKey{}) == nil {
+			// the config is edited at the admin listener
+			tpl.AllowRuntimeConfigure = false
+		}
+		common.HandleError(htmlTemplate("index.html").Execute(w, tpl))
 		return
 	case "/rss":
 		s.rssh.ServeHTTP(w, r)
 		return
+	case feedPath:
+		s.serveFeed(w, r)
+		return
+	case calendarPath:
+		s.serveCalendar(w, r)
+		return
 	case "/sync":
 		//handle realtime client connections,
 		if r.Header.Get("Accept") == "text/event-stream" {
 			// avoid gzip buffer
 			w.Header().Set("Content-Encoding", "identity")
 		}
-		conn, err := velox.Sync(&s.state, w, r)
+		// a client may subscribe to some sections of the state, pushed
+		// every interval
+		client, err := s.newSyncClient(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var gostruct interface{} = &s.state
+		if client != nil {
+			gostruct = client
+		}
+		// the late writes of velox are dropped, for HTTP/2
+		sw := newSyncWriter(w)
+		defer sw.finish()
+		conn, err := velox.Sync(gostruct, sw, r)
 		if err != nil {
 			log.Printf("sync failed: %s", err)
 			return
 		}
+		if client != nil {
+			done := make(chan struct{})
+			defer close(done)
+			go client.pushRoutine(done)
+		}
 		ukey := conn.ID() + "|" + r.RemoteAddr
+		s.state.Lock()
 		s.state.Users[ukey] = struct{}{}
+		s.state.Unlock()
 		s.syncConnected <- struct{}{}
 		s.syncWg.Add(1)
 		defer s.syncWg.Done()
 		s.state.Push()
 		conn.Wait()
+		s.state.Lock()
 		delete(s.state.Users, ukey)
+		s.state.Unlock()
 		return
 	case "/js/velox.js":
 		velox.JS.ServeHTTP(w, r)
@@ -54,7 +97,15 @@ func (s *Server) webHandle(w http.ResponseWriter, r *http.Request) {
 	pathDir := strings.SplitN(r.URL.Path[1:], "/", 2)
 	switch pathDir[0] {
 	case "search":
-		s.scraperh.ServeHTTP(w, r)
+		if s.engineConfig.DisableSearch {
+			http.Error(w, errSearchDisabled.Error(), http.StatusNotFound)
+			return
+		}
+		if len(pathDir) == 2 && s.isPluginSearch(pathDir[1]) {
+			s.servePluginSearch(w, r, pathDir[1])
+			return
+		}
+		s.serveSearch(w, r)
 	case "api":
 		origin := r.Header.Get("Origin")
 		if origin == "" {
@@ -65,6 +116,19 @@ func (s *Server) webHandle(w http.ResponseWriter, r *http.Request) {
 		s.restAPIhandle(w, r)
 	case "download":
 		s.dlfilesh.ServeHTTP(w, r)
+	case "share":
+		s.serveShare(w, r)
+	case "published":
+		s.servePublished(w, r)
+	case "stream":
+		http.StripPrefix("/stream/", http.HandlerFunc(s.serveStream)).ServeHTTP(w, r)
+	case "transcode":
+		http.StripPrefix("/transcode/", http.HandlerFunc(s.serveTranscode)).ServeHTTP(w, r)
+	case ctstatic.Fingerprint():
+		// the path changes with the content
+		w.Header().Set("Expires", time.Now().UTC().AddDate(1, 0, 0).Format(http.TimeFormat))
+		w.Header().Set("Cache-Control", "max-age=31536000, public, immutable")
+		http.StripPrefix("/"+pathDir[0], s.statich).ServeHTTP(w, r)
 	case s.tpl.Version:
 		w.Header().Set("Expires", time.Now().UTC().AddDate(0, 6, 0).Format(http.TimeFormat))
 		w.Header().Set("Cache-Control", "max-age:290304000, public")
@@ -80,15 +144,91 @@ func (s *Server) webHandle(w http.ResponseWriter, r *http.Request) {
 
 // restAPIhandle is used both by main webserver and restapi server
 func (s *Server) restAPIhandle(w http.ResponseWriter, r *http.Request) {
+	if s.adminDenied(w, r) {
+		return
+	}
+	// the adds retried by the automations
+	if key := idempotencyKey(r); key != "" {
+		s.idempotent(w, r, key, s.serveAPI)
+		return
+	}
+	s.serveAPI(w, r)
+}
+
+func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, apiv1.Prefix) {
+		s.apiv1h.ServeHTTP(w, r)
+		return
+	}
+	// the unversioned paths having a successor in the /api/v1/
+	switch strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/"), "/", 2)[0] {
+	case "torrents", "torrent", "magnet", "url", "torrentfile", "stat":
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", `<`+apiv1.Prefix+`>; rel="successor-version"`)
+	}
 	switch r.Method {
 	case "POST":
+		if r.URL.Path == "/api/config/validate" {
+			if err := s.apiConfigValidate(w, r); err != nil {
+				http.Error(w, fmt.Sprintf("%s:%s:%v", r.Method, r.URL, err.Error()), http.StatusBadRequest)
+			}
+			return
+		}
+		if r.URL.Path == "/api/preview" || strings.HasPrefix(r.URL.Path, "/api/preview/") {
+			if err := s.apiPreview(w, r); err != nil {
+				http.Error(w, fmt.Sprintf("%s:%s:%v", r.Method, r.URL, err.Error()), http.StatusBadRequest)
+			}
+			return
+		}
+		if r.URL.Path == "/api/share" || strings.HasPrefix(r.URL.Path, "/api/share/") {
+			if err := s.apiShare(w, r); err != nil {
+				http.Error(w, fmt.Sprintf("%s:%s:%v", r.Method, r.URL, err.Error()), http.StatusBadRequest)
+			}
+			return
+		}
+		if r.URL.Path == "/api/upload" || strings.HasPrefix(r.URL.Path, "/api/upload/") {
+			if err := s.apiUpload(w, r); err != nil {
+				http.Error(w, fmt.Sprintf("%s:%s:%v", r.Method, r.URL, err.Error()), http.StatusBadRequest)
+			}
+			return
+		}
+		if r.URL.Path == "/api/publish" || strings.HasPrefix(r.URL.Path, "/api/publish/") {
+			if err := s.apiPublish(w, r); err != nil {
+				http.Error(w, fmt.Sprintf("%s:%s:%v", r.Method, r.URL, err.Error()), http.StatusBadRequest)
+			}
+			return
+		}
+		if r.URL.Path == "/api/inspect" {
+			if err := s.apiInspect(w, r); err != nil {
+				http.Error(w, fmt.Sprintf("%s:%s:%v", r.Method, r.URL, err.Error()), http.StatusBadRequest)
+			}
+			return
+		}
 		if err := s.apiPOST(r); err != nil {
+			var dup *engine.Duplicate
+			if errors.As(err, &dup) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusConflict)
+				common.HandleError(json.NewEncoder(w).Encode(map[string]interface{}{
+					"error":     dup.Error(),
+					"duplicate": dup,
+				}))
+				return
+			}
 			http.Error(w, fmt.Sprintf("%s:%s:%v", r.Method, r.URL, err.Error()), http.StatusBadRequest)
 			return
 		}
 		w.WriteHeader(http.StatusOK)
 		_, err := w.Write([]byte("OK"))
 		common.HandleError(err)
+	case "PATCH":
+		if r.URL.Path != "/api/configure" {
+			http.Error(w, fmt.Sprintf("%s:%s:Method Not Allowed", r.Method, r.URL), http.StatusBadRequest)
+			return
+		}
+		if err := s.apiConfigPatch(w, r); err != nil {
+			http.Error(w, fmt.Sprintf("%s:%s:%v", r.Method, r.URL, err.Error()), http.StatusBadRequest)
+		}
 	case "GET":
 		if err := s.apiGET(w, r); err != nil {
 			http.Error(w, fmt.Sprintf("%s:%s:%v", r.Method, r.URL, err.Error()), http.StatusBadRequest)
@@ -100,9 +240,11 @@ func (s *Server) restAPIhandle(w http.ResponseWriter, r *http.Request) {
 }
 
 type TPLInfo struct {
-	Uptime                int64
-	Title                 string
-	Version               string
+	Uptime  int64
+	Title   string
+	Version string
+	// the fingerprint of the assets, their path prefix
+	Assets                string
 	Runtime               string
 	AllowRuntimeConfigure bool
 }
@@ -125,15 +267,35 @@ ___] | |  | |    |___ |___     |  |__| |  \ |  \ |___ | \|  |
 `, t.Title, t.Version, t.Runtime)
 }
 
-func init() {
-	htmlTPL = make(map[string]*template.Template)
+func htmlTemplate(name string) *template.Template {
+	htmlTPLMu.RLock()
+	defer htmlTPLMu.RUnlock()
+	return htmlTPL[name]
+}
+
+// parseTemplates parses the server templates of the resources
+func parseTemplates(fsys fs.FS) (map[string]*template.Template, error) {
+	tpls := make(map[string]*template.Template)
 	for _, fsn := range []string{"index.html", "magadded.html"} {
 
-		c, err := ctstatic.ReadAll(fsn)
+		c, err := ctstatic.ReadFrom(fsys, fsn)
 		if err != nil {
-			log.Fatalln(err)
+			return nil, err
 		}
 
-		htmlTPL[fsn] = template.Must(template.New(fsn).Delims("[[", "]]").Parse(string(c)))
+		t, err := template.New(fsn).Delims("[[", "]]").Parse(string(c))
+		if err != nil {
+			return nil, err
+		}
+		tpls[fsn] = t
+	}
+	return tpls, nil
+}
+
+func init() {
+	tpls, err := parseTemplates(ctstatic.Builtin())
+	if err != nil {
+		log.Fatalln(err)
 	}
+	htmlTPL = tpls
 }
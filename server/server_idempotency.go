@@ -0,0 +1,176 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boypt/simple-torrent/server/apiv1"
+)
+
+// the adds retried with the same Idempotency-Key header get the response of
+// the first, kept for a day
+const (
+	idempotencyHeader    = "Idempotency-Key"
+	idempotencyTTL       = 24 * time.Hour
+	maxIdempotencyKeys   = 4096
+	maxIdempotencyKeyLen = 255
+)
+
+var (
+	errIdempotencyKey      = errors.New("Invalid Idempotency-Key")
+	errIdempotencyMismatch = errors.New("Idempotency-Key reused with a different request")
+)
+
+// idempotentResponse is the response of the first request of a key
+type idempotentResponse struct {
+	// the url and the body of the request
+	fingerprint [sha256.Size]byte
+	at          time.Time
+	// closed once the response is recorded, or the request failed
+	done     chan struct{}
+	recorded bool
+	code     int
+	header   http.Header
+	body     []byte
+}
+
+type idempotencyState struct {
+	sync.Mutex
+	keys map[string]*idempotentResponse
+}
+
+// idempotencyKey is the key of an add, empty for the other requests
+func idempotencyKey(r *http.Request) string {
+	if r.Method != http.MethodPost {
+		return ""
+	}
+	switch r.URL.Path {
+	case "/api/magnet", "/api/url", "/api/torrentfile", apiv1.Prefix + "torrents":
+		return r.Header.Get(idempotencyHeader)
+	}
+	return ""
+}
+
+// idempotencyUser is who sent the request, the keys of the users apart:
+// the basic auth user, or the bearer token, and the user of the DiskQuotas
+func idempotencyUser(r *http.Request) string {
+	user, _, ok := r.BasicAuth()
+	if !ok && strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		user = "bearer"
+	}
+	return user + "/" + strings.TrimSpace(r.URL.Query().Get("user"))
+}
+
+// expire drops the keys past the TTL, the oldest ones over the limit, the
+// lock held
+func (st *idempotencyState) expire(now time.Time) {
+	var oldest string
+	for k, res := range st.keys {
+		if now.Sub(res.at) > idempotencyTTL {
+			delete(st.keys, k)
+			continue
+		}
+		if oldest == "" || res.at.Before(st.keys[oldest].at) {
+			oldest = k
+		}
+	}
+	if len(st.keys) >= maxIdempotencyKeys {
+		delete(st.keys, oldest)
+	}
+}
+
+// idempotent serves the request by h once for the key, a retry gets the
+// recorded response, waiting for it if the first is still running. The
+// failed requests are forgotten, retried for real.
+func (s *Server) idempotent(w http.ResponseWriter, r *http.Request, key string, h http.HandlerFunc) {
+	if len(key) > maxIdempotencyKeyLen {
+		http.Error(w, errIdempotencyKey.Error(), http.StatusBadRequest)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	fingerprint := sha256.Sum256(append([]byte(r.URL.RequestURI()+"\n"), body...))
+	key = idempotencyUser(r) + "\n" + key
+
+	st := &s.idempotency
+	for {
+		st.Lock()
+		res, ok := st.keys[key]
+		if !ok {
+			break
+		}
+		st.Unlock()
+		if res.fingerprint != fingerprint {
+			http.Error(w, errIdempotencyMismatch.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		<-res.done
+		if res.recorded {
+			res.replay(w)
+			return
+		}
+		// the first failed, forgotten
+	}
+	now := time.Now()
+	st.expire(now)
+	if st.keys == nil {
+		st.keys = make(map[string]*idempotentResponse)
+	}
+	res := &idempotentResponse{fingerprint: fingerprint, at: now, done: make(chan struct{})}
+	st.keys[key] = res
+	st.Unlock()
+
+	rec := &recordingWriter{ResponseWriter: w, code: http.StatusOK}
+	// the retries released even if h panics, the key forgotten
+	completed := false
+	defer func() {
+		st.Lock()
+		if completed && rec.code < 300 {
+			res.recorded = true
+			res.code, res.header, res.body = rec.code, w.Header().Clone(), rec.body.Bytes()
+		} else {
+			delete(st.keys, key)
+		}
+		st.Unlock()
+		close(res.done)
+	}()
+	h(rec, r)
+	completed = true
+}
+
+func (res *idempotentResponse) replay(w http.ResponseWriter) {
+	for k, v := range res.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(res.code)
+	_, _ = w.Write(res.body)
+}
+
+// recordingWriter keeps a copy of the response written
+type recordingWriter struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(code int) {
+	rw.code = code
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
@@ -0,0 +1,38 @@
+//go:build noscraper
+// +build noscraper
+
+package server
+
+import (
+	"net/http"
+)
+
+// the scraper of the search providers, left out by the noscraper build tag
+const scraperBuilt = false
+
+type scraperState struct{}
+
+func (s *Server) initScraper() {}
+
+func (s *Server) fetchSearchConfig(confurl string) error {
+	return nil
+}
+
+func (s *Server) scraperProviders() map[string]string {
+	return nil
+}
+
+func (s *Server) addScraperProviders(providers map[string]interface{}) {}
+
+// serveSearch only has the plugin search in a noscraper build
+func (s *Server) serveSearch(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, errSearchDisabled.Error(), http.StatusNotFound)
+}
+
+func (s *Server) apiResolve(r *http.Request) (interface{}, error) {
+	return nil, errSearchDisabled
+}
+
+func (s *Server) apiSearchTest(r *http.Request) (*searchTestResult, error) {
+	return nil, errSearchDisabled
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/boypt/simple-torrent/engine"
+	"github.com/boypt/simple-torrent/server/httpmiddleware"
+	"github.com/dustin/go-humanize"
+)
+
+const (
+	// the published torrents are served at /published/feed.xml and
+	// /published/<infohash>.torrent with the FeedToken, for other instances
+	// to subscribe
+	publishPath     = "/published/"
+	publishFeedPath = publishPath + "feed.xml"
+)
+
+// publishRequest publishes a file or dir at POST /api/publish
+type publishRequest struct {
+	// relative to the download directory
+	Path string
+}
+
+// apiPublish serves POST /api/publish creating and seeding the torrent, and
+// /api/publish/<infohash>/remove taking it off the feed
+func (s *Server) apiPublish(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	if rest := strings.TrimPrefix(r.URL.Path, "/api/publish/"); rest != r.URL.Path {
+		parts := strings.SplitN(rest, "/", 2)
+		if len(parts) != 2 || parts[1] != "remove" {
+			return errUnknowAct
+		}
+		if err := s.engine.Unpublish(parts[0]); err != nil {
+			return err
+		}
+		_, err := w.Write([]byte("OK"))
+		return err
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		return err
+	}
+	var req publishRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return errInvalidReq
+	}
+	pub, err := s.engine.Publish(req.Path)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(pub)
+}
+
+// servePublished serves the RSS feed of the published torrents and their
+// .torrent files, with the FeedToken
+func (s *Server) servePublished(w http.ResponseWriter, r *http.Request) {
+	if s.FeedToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+	token := r.URL.Query().Get("token")
+	if !httpmiddleware.ValidToken(token, s.FeedToken) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if r.URL.Path != publishFeedPath {
+		ih := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, publishPath), ".torrent")
+		data, err := s.engine.PublishedTorrent(ih)
+		switch {
+		case errors.Is(err, engine.ErrNotPublished):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-bittorrent")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", ih+".torrent"))
+		w.Write(data)
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	base := fmt.Sprintf("%s://%s", scheme, r.Host)
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       s.Title + " published",
+			Link:        base + "/",
+			Description: "The torrents published by " + s.Title,
+		},
+	}
+	for _, p := range s.engine.PublishedTorrents() {
+		link := fmt.Sprintf("%s%s%s.torrent?token=%s", base, publishPath, p.InfoHash, url.QueryEscape(token))
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       p.Name,
+			Link:        link,
+			Description: fmt.Sprintf("%s, %s", humanize.Bytes(uint64(p.Size)), p.Magnet),
+			GUID:        rssGUID{Value: p.InfoHash},
+			PubDate:     p.PublishedAt.Format(time.RFC1123Z),
+			Enclosure:   &rssEnclosure{URL: link, Length: p.Size, Type: "application/x-bittorrent"},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		log.Println("[Publish]", err)
+	}
+}
@@ -0,0 +1,243 @@
+//go:build !noscraper
+// +build !noscraper
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boypt/scraper"
+	"github.com/boypt/simple-torrent/common"
+	"github.com/boypt/simple-torrent/engine"
+)
+
+const (
+	resolveCacheTTL  = 6 * time.Hour
+	maxResolveCache  = 1000
+	maxDetailPageLen = 2 << 20
+)
+
+var (
+	errNotResolved = errors.New("No magnet or torrent link found")
+	pageMagnetExp  = regexp.MustCompile(`magnet:\?[^"'<>\s]+`)
+	pageTorrentExp = regexp.MustCompile(`href\s*=\s*["']([^"']+\.torrent(?:\?[^"']*)?)["']`)
+	infohashExp    = regexp.MustCompile(`^([0-9a-fA-F]{40}|[2-7A-Za-z]{32})$`)
+)
+
+// resolvedItem is the directly addable link of a search result
+type resolvedItem struct {
+	Magnet  string `json:"magnet,omitempty"`
+	Torrent string `json:"torrent,omitempty"`
+}
+
+type resolvedEntry struct {
+	resolvedItem
+	at time.Time
+}
+
+// resolveCache keeps the resolved detail pages, they rarely change
+var resolveCache = struct {
+	sync.Mutex
+	m map[string]resolvedEntry
+}{m: make(map[string]resolvedEntry)}
+
+// providerOrigin is the scheme://host of a provider's url
+func providerOrigin(e *scraper.Endpoint) string {
+	u, err := url.Parse(e.URL[:strings.Index(e.URL+"{", "{")])
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// absURL resolves a link of the page base
+func absURL(base, link string) string {
+	b, err := url.Parse(base)
+	if err != nil {
+		return link
+	}
+	l, err := b.Parse(link)
+	if err != nil {
+		return link
+	}
+	return l.String()
+}
+
+// itemMagnet is the magnet of an infohash result, with the comma separated
+// trackers if any
+func itemMagnet(ih, name, trackers string) string {
+	m := "magnet:?xt=urn:btih:" + ih
+	if name != "" {
+		m += "&dn=" + url.QueryEscape(name)
+	}
+	for _, tr := range strings.Split(trackers, ",") {
+		if tr = strings.TrimSpace(tr); strings.HasPrefix(tr, "http") || strings.HasPrefix(tr, "udp") {
+			m += "&tr=" + url.QueryEscape(tr)
+		}
+	}
+	return m
+}
+
+// normalizeResult trims the fields, makes the torrent link absolute and
+// turns a valid infohash into a magnet
+func normalizeResult(r scraper.Result, origin string) {
+	for k, v := range r {
+		r[k] = strings.TrimSpace(v)
+	}
+	if t := r["torrent"]; t != "" && origin != "" {
+		r["torrent"] = absURL(origin, t)
+	}
+	if ih := r["infohash"]; ih != "" {
+		if !infohashExp.MatchString(ih) {
+			delete(r, "infohash")
+		} else if r["magnet"] == "" {
+			r["magnet"] = itemMagnet(ih, r["name"], r["tracker"])
+		}
+	}
+}
+
+// serveSearch serves /search/<provider> with the results normalized, the
+// other scraper paths go to the scraper as is
+func (s *Server) serveSearch(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/search/")
+	e := s.scraper.Endpoint(id)
+	if e == nil || e.List == "" || strings.Contains(id, "/") {
+		s.scraperh.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	filter, err := engine.ParseReleaseFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		common.HandleError(json.NewEncoder(w).Encode(map[string]string{"error": err.Error()}))
+		return
+	}
+	params := make(map[string]string)
+	for k, v := range r.URL.Query() {
+		if k != "filter" {
+			params[k] = v[0]
+		}
+	}
+	var res []scraper.Result
+	err = s.timedSearch(id, func() (err error) {
+		res, err = e.Execute(params)
+		return err
+	})
+	if err != nil {
+		w.WriteHeader(searchErrorStatus(err))
+		common.HandleError(json.NewEncoder(w).Encode(map[string]string{"error": err.Error()}))
+		return
+	}
+	origin := providerOrigin(e)
+	matched := make([]scraper.Result, 0, len(res))
+	for _, rs := range res {
+		normalizeResult(rs, origin)
+		if releaseResult(rs, filter) {
+			matched = append(matched, rs)
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	common.HandleError(enc.Encode(matched))
+}
+
+// resolveItem finds the magnet or torrent link of a result by its detail
+// page path, with the provider's /item endpoint if configured, or by
+// looking for the links in the page
+func (s *Server) resolveItem(provider, path, name string) (*resolvedItem, error) {
+	key := provider + "|" + path
+	resolveCache.Lock()
+	if ent, ok := resolveCache.m[key]; ok && time.Since(ent.at) < resolveCacheTTL {
+		resolveCache.Unlock()
+		return &ent.resolvedItem, nil
+	}
+	resolveCache.Unlock()
+
+	e := s.scraper.Endpoint(provider)
+	if e == nil {
+		return nil, fmt.Errorf("Unknown search provider %s", provider)
+	}
+	origin := providerOrigin(e)
+
+	var item resolvedItem
+	if ie := s.scraper.Endpoint(provider + "/item"); ie != nil {
+		res, err := ie.Execute(map[string]string{"item": path})
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range res {
+			normalizeResult(r, origin)
+			if r["magnet"] != "" || r["torrent"] != "" {
+				item = resolvedItem{Magnet: r["magnet"], Torrent: r["torrent"]}
+				if item.Magnet != "" && name != "" && !strings.Contains(item.Magnet, "dn=") {
+					item.Magnet += "&dn=" + url.QueryEscape(name)
+				}
+				break
+			}
+		}
+	} else {
+		page := absURL(origin+"/", path)
+		var err error
+		if item, err = scanDetailPage(page); err != nil {
+			return nil, err
+		}
+	}
+	if item.Magnet == "" && item.Torrent == "" {
+		return nil, errNotResolved
+	}
+
+	resolveCache.Lock()
+	defer resolveCache.Unlock()
+	now := time.Now()
+	for k, ent := range resolveCache.m {
+		if now.Sub(ent.at) > resolveCacheTTL || len(resolveCache.m) >= maxResolveCache {
+			delete(resolveCache.m, k)
+		}
+	}
+	resolveCache.m[key] = resolvedEntry{item, now}
+	return &item, nil
+}
+
+// scanDetailPage looks for the first magnet, or else the torrent link of a page
+func scanDetailPage(page string) (resolvedItem, error) {
+	var item resolvedItem
+	resp, err := http.Get(page)
+	if err != nil {
+		return item, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return item, fmt.Errorf("%s: %s", page, resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxDetailPageLen))
+	if err != nil {
+		return item, err
+	}
+	if m := pageMagnetExp.Find(body); m != nil {
+		item.Magnet = html.UnescapeString(string(m))
+	} else if m := pageTorrentExp.FindSubmatch(body); m != nil {
+		item.Torrent = absURL(page, html.UnescapeString(string(m[1])))
+	}
+	return item, nil
+}
+
+// apiResolve serves GET /api/resolve?provider=<id>&path=<item path>&name=<name>
+func (s *Server) apiResolve(r *http.Request) (*resolvedItem, error) {
+	q := r.URL.Query()
+	provider, path := q.Get("provider"), q.Get("path")
+	if provider == "" || path == "" {
+		return nil, errInvalidReq
+	}
+	return s.resolveItem(provider, path, q.Get("name"))
+}
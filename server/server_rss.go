@@ -2,18 +2,28 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/anacrolix/torrent/metainfo"
 	"github.com/boypt/simple-torrent/common"
+	"github.com/boypt/simple-torrent/engine"
+	"github.com/boypt/simple-torrent/plugin"
 	"github.com/dustin/go-humanize"
 	"github.com/mmcdole/gofeed"
 )
 
+const (
+	rssPollInterval = 30 * time.Minute
+	maxRSSItems     = 200
+)
+
 var (
 	magnetExp   = regexp.MustCompile(`magnet:[^< ]+`)
 	hashinfoExp = regexp.MustCompile(`[0-9a-zA-Z]{40}`)
@@ -21,14 +31,30 @@ var (
 )
 
 type rssJSONItem struct {
-	Name            string `json:"name"`
-	Magnet          string `json:"magnet"`
-	InfoHash        string `json:"infohash"`
-	Published       string `json:"published"`
-	URL             string `json:"url"`
-	Torrent         string `json:"torrent"`
-	Size            string `json:"size"`
+	Name      string `json:"name"`
+	Magnet    string `json:"magnet"`
+	InfoHash  string `json:"infohash"`
+	Published string `json:"published"`
+	URL       string `json:"url"`
+	Torrent   string `json:"torrent"`
+	Size      string `json:"size"`
+	// the title, season, episode, quality and group of the name
+	engine.Release
+	// the count of the feeds listing it, the item of the first one kept
+	Feeds int `json:"feeds"`
+	// added before, even by a previous run
+	Downloaded      bool `json:"downloaded"`
 	publishedParsed *time.Time
+	guid            string
+	feed            string
+	feeds           map[string]bool
+}
+
+// rssState is the items of the feeds, the same release of several feeds
+// once
+type rssState struct {
+	sync.Mutex
+	items []*rssJSONItem
 }
 
 func (ritem *rssJSONItem) findFromFeedItem(i *gofeed.Item) (found bool) {
@@ -102,80 +128,184 @@ func (r *rssJSONItem) readExtention(i *gofeed.Item, ext string) (found bool) {
 	return
 }
 
-func (s *Server) updateRSS() {
-	fp := gofeed.NewParser()
-	fp.Client = &http.Client{
-		Timeout: 60 * time.Second,
+// rssInterval is the poll interval of the RssURL, 0 if not configured
+func (s *Server) rssInterval() time.Duration {
+	if strings.TrimSpace(s.engineConfig.RssURL) == "" {
+		return 0
+	}
+	return rssPollInterval
+}
+
+// newRSSItem reads the magnet, infohash or torrent of the feed item
+func newRSSItem(feed string, i *gofeed.Item) *rssJSONItem {
+	ritem := &rssJSONItem{
+		Name:            i.Title,
+		Published:       i.Published,
+		URL:             i.Link,
+		Feeds:           1,
+		publishedParsed: i.PublishedParsed,
+		guid:            i.GUID,
+		feed:            feed,
+		feeds:           map[string]bool{feed: true},
+	}
+	ritem.findFromFeedItem(i)
+	if ritem.InfoHash == "" && ritem.Magnet != "" {
+		if m, err := metainfo.ParseMagnetUri(ritem.Magnet); err == nil {
+			ritem.InfoHash = m.InfoHash.HexString()
+		}
+	}
+	ritem.InfoHash = strings.ToLower(ritem.InfoHash)
+	ritem.Release = engine.ParseRelease(ritem.Name)
+	return ritem
+}
+
+// key is what the same release has in all the feeds: its infohash, or its
+// normalized title without one
+func (ritem *rssJSONItem) key() string {
+	if ritem.InfoHash != "" {
+		return "btih:" + ritem.InfoHash
 	}
+	return "title:" + engine.NormalizeReleaseTitle(ritem.Name)
+}
+
+// mergeRSSItems adds the new items of the feeds to the cached ones, an item
+// known by its key only counted, the latest first
+func mergeRSSItems(cached, fetched []*rssJSONItem) ([]*rssJSONItem, int) {
+	known := make(map[string]*rssJSONItem, len(cached)+len(fetched))
+	for _, ritem := range cached {
+		known[ritem.key()] = ritem
+	}
+	var added int
+	for _, ritem := range fetched {
+		k := ritem.key()
+		if prev, ok := known[k]; ok {
+			prev.feeds[ritem.feed] = true
+			prev.Feeds = len(prev.feeds)
+			continue
+		}
+		known[k] = ritem
+		cached = append(cached, ritem)
+		added++
+	}
+	sort.SliceStable(cached, func(i, j int) bool {
+		pi, pj := cached[i].publishedParsed, cached[j].publishedParsed
+		return pi != nil && (pj == nil || pi.After(*pj))
+	})
+	if len(cached) > maxRSSItems {
+		cached = cached[:maxRSSItems]
+	}
+	return cached, added
+}
+
+func (s *Server) updateRSS() error {
+	if s.engine.Maintenance().Enabled {
+		return nil
+	}
+	var failed int
+	var fetched []*rssJSONItem
+	fp := gofeed.NewParser()
+	fp.Client = &http.Client{Transport: s.fetcher}
 	for _, rss := range strings.Split(s.engineConfig.RssURL, "\n") {
+		rss = strings.TrimSpace(rss)
 		if !strings.HasPrefix(rss, "http://") && !strings.HasPrefix(rss, "https://") {
 			continue
 		}
-		rss = strings.TrimSpace(rss)
 		feed, err := fp.ParseURL(rss)
 		if err != nil {
 			log.Printf("RSS: parse feed err %s", err.Error())
+			failed++
 			continue
 		}
 
 		if s.Debug {
 			log.Printf("RSS: retrived feed %s from %s", feed.Title, rss)
 		}
-
-		if oldmark, ok := s.rssMark[rss]; ok {
-			var lastIdx int
-			for i, item := range feed.Items {
-				if item.GUID == oldmark {
-					lastIdx = i
-					break
-				}
-			}
-			if lastIdx > 0 {
-				log.Printf("RSS: feed updated with %d new items", lastIdx)
-				s.rssMark[rss] = feed.Items[0].GUID
-				s.rssCache = append(feed.Items[:lastIdx], s.rssCache...)
-			}
-		} else if len(feed.Items) > 0 {
-			if s.Debug {
-				log.Printf("RSS: retrive %d new items, first record", len(feed.Items))
-			}
-			s.rssMark[rss] = feed.Items[0].GUID
-			s.rssCache = append(feed.Items, s.rssCache...)
+		for _, i := range feed.Items {
+			fetched = append(fetched, newRSSItem(rss, i))
 		}
+	}
 
-		if len(s.rssCache) > 200 {
-			s.rssCache = s.rssCache[:200]
+	s.rss.Lock()
+	items, added := mergeRSSItems(s.rss.items, fetched)
+	s.rss.items = items
+	var latest string
+	if len(items) > 0 {
+		latest = items[0].guid
+	}
+	s.rss.Unlock()
+	if added > 0 {
+		log.Printf("RSS: feeds updated with %d new items", added)
+	}
+	if latest != "" {
+		s.state.Lock()
+		s.state.LatestRSSGuid = latest
+		s.state.Unlock()
+		s.state.Push()
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d feeds failed", failed)
+	}
+	return nil
+}
+
+// recordRSSAdd keeps in the history the RSS item added as the task, by its
+// infohash or its title
+func (s *Server) recordRSSAdd(ev plugin.Event) {
+	title := engine.NormalizeReleaseTitle(ev.Name)
+	s.rss.Lock()
+	var found *rssJSONItem
+	for _, ritem := range s.rss.items {
+		if ritem.InfoHash == ev.InfoHash || title != "" && engine.NormalizeReleaseTitle(ritem.Name) == title {
+			found = ritem
+			break
 		}
 	}
+	var it engine.RSSItem
+	if found != nil {
+		it = engine.RSSItem{InfoHash: ev.InfoHash, Title: found.Name, GUID: found.guid, Feed: found.feed, AddedAt: ev.Time}
+	}
+	s.rss.Unlock()
+	if found == nil {
+		return
+	}
+	if err := s.engine.RecordRSSItem(it); err != nil {
+		log.Println("RSS: history", err)
+	}
+}
 
-	// sort the retrived feed by Published attr
-	// make sure the first is the latest
-	sort.Slice(s.rssCache, func(i, j int) bool {
-		return s.rssCache[i].PublishedParsed.After(*(s.rssCache[j].PublishedParsed))
-	})
-	if len(s.rssCache) > 0 {
-		s.state.LatestRSSGuid = s.rssCache[0].GUID
-		s.state.Push()
+// recordRSSHistory records the RSS items added as tasks
+func (s *Server) recordRSSHistory() {
+	events, _ := s.engine.SubscribeEvents(16)
+	for ev := range events {
+		if ev.Type == engine.EventAdd {
+			s.recordRSSAdd(ev)
+		}
 	}
 }
 
+// serveRSS serves the items of the feeds, the ones of the release filter
+// only with ?filter=quality>=1080p
 func (s *Server) serveRSS(w http.ResponseWriter, r *http.Request) {
+	filter, err := engine.ParseReleaseFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	if _, ok := r.URL.Query()["update"]; ok {
-		s.updateRSS()
+		s.updateRSS() // nolint: errcheck
 	}
 
-	var results []rssJSONItem
-	for _, i := range s.rssCache {
-		ritem := rssJSONItem{
-			Name:            i.Title,
-			Published:       i.Published,
-			URL:             i.Link,
-			publishedParsed: i.PublishedParsed,
+	s.rss.Lock()
+	results := make([]rssJSONItem, 0, len(s.rss.items))
+	for _, ritem := range s.rss.items {
+		if filter.Match(ritem.Name) {
+			results = append(results, *ritem)
 		}
-
-		ritem.findFromFeedItem(i)
-		results = append(results, ritem)
+	}
+	s.rss.Unlock()
+	for i := range results {
+		results[i].Downloaded = s.engine.RSSDownloaded(results[i].InfoHash, results[i].Name)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
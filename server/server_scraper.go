@@ -0,0 +1,157 @@
+//go:build !noscraper
+// +build !noscraper
+
+package server
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/boypt/scraper"
+)
+
+// the scraper of the search providers, left out by the noscraper build tag
+const scraperBuilt = true
+
+const scraperUA = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_12_4) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/57.0.2987.133 Safari/537.36"
+
+//go:embed default-scraper-config.json
+var defaultSearchConfig []byte
+var currentConfig []byte
+
+// templates of the scraper urls, eg. {{query}} or {{page:1}}
+var urlTemplateRegexp = regexp.MustCompile(`\{\{(\w+)(?::([^}]*))?\}\}`)
+
+type scraperState struct {
+	scraperh        http.Handler
+	scraper         *scraper.Handler
+	searchProviders *scraper.Config
+}
+
+func (s *Server) initScraper() {
+	s.scraper = &scraper.Handler{
+		Log: s.Debug, Debug: s.Debug,
+		Headers: map[string]string{
+			//we're a trusty browser :)
+			"User-Agent": scraperUA,
+		},
+	}
+	if err := s.scraper.LoadConfig(defaultSearchConfig); err != nil {
+		log.Fatal(err)
+	}
+	s.searchProviders = &s.scraper.Config //share scraper config with web frontend
+	s.scraperh = http.StripPrefix("/search", s.scraper)
+}
+
+func (s *Server) fetchSearchConfig(confurl string) error {
+	if s.engineConfig.DisableSearch {
+		return nil
+	}
+	if !strings.HasPrefix(confurl, "http") {
+		log.Println("fetchSearchConfig: unconfigured, using the default conf", confurl)
+		return nil
+	}
+	log.Println("fetchSearchConfig: loading search config from", confurl)
+	resp, err := http.Get(confurl)
+	if err != nil {
+		log.Println("[fetchSearchConfig]", err)
+		return err
+	}
+	defer resp.Body.Close()
+	newConfig, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	newConfig, err = normalize(newConfig)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(currentConfig, newConfig) {
+		return nil //skip
+	}
+	if err := s.scraper.LoadConfig(newConfig); err != nil {
+		return err
+	}
+	s.searchProviders = &s.scraper.Config
+	currentConfig = newConfig
+	s.updateFetcher()
+	log.Printf("Loaded new search providers")
+	return nil
+}
+
+// scraperProviders maps the scraper providers to their urls, for the fetch
+// rules
+func (s *Server) scraperProviders() map[string]string {
+	providers := make(map[string]string)
+	if s.engineConfig.DisableSearch {
+		return providers
+	}
+	for id, e := range s.scraper.Config {
+		providers[id] = e.URL
+	}
+	return providers
+}
+
+// addScraperProviders lists the scraper providers for the web frontend
+func (s *Server) addScraperProviders(providers map[string]interface{}) {
+	for k, v := range *s.searchProviders {
+		providers[k] = v
+	}
+}
+
+// apiSearchTest serves GET /api/searchtest?provider=<id>, searching "test"
+// with the provider through its fetch rule
+func (s *Server) apiSearchTest(r *http.Request) (*searchTestResult, error) {
+	id := r.URL.Query().Get("provider")
+	e, ok := s.scraper.Config[id]
+	if !ok {
+		return nil, fmt.Errorf("Unknown search provider %s", id)
+	}
+	res := &searchTestResult{Provider: id}
+	res.URL = urlTemplateRegexp.ReplaceAllStringFunc(e.URL, func(t string) string {
+		m := urlTemplateRegexp.FindStringSubmatch(t)
+		if m[1] == "query" {
+			return "test"
+		}
+		return m[2]
+	})
+
+	req, err := http.NewRequest(http.MethodGet, res.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range e.Headers {
+		req.Header.Set(k, v)
+	}
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	res.Latency = time.Since(start).Milliseconds()
+	if err != nil {
+		res.Error = err.Error()
+		return res, nil
+	}
+	resp.Body.Close()
+	res.Status = resp.StatusCode
+	if resp.StatusCode != http.StatusOK {
+		res.Error = resp.Status
+	}
+	return res, nil
+}
+
+func normalize(input []byte) ([]byte, error) {
+	output := bytes.Buffer{}
+	if err := json.Indent(&output, input, "", "  "); err != nil {
+		return nil, err
+	}
+	return output.Bytes(), nil
+}
+
+//see github.com/jpillora/scraper for config specification
+//cloud-torrent uses "<id>-item" handlers
@@ -1,57 +1,104 @@
 package server
 
 import (
-	"bytes"
-	_ "embed"
 	"encoding/json"
-	"io/ioutil"
+	"errors"
 	"net/http"
-	"strings"
+	"strconv"
+
+	"github.com/boypt/simple-torrent/common"
+	"github.com/boypt/simple-torrent/engine"
+	"github.com/boypt/simple-torrent/plugin"
 )
 
-//go:embed default-scraper-config.json
-var defaultSearchConfig []byte
-var currentConfig []byte
+var errSearchDisabled = errors.New("Search is disabled")
 
-func (s *Server) fetchSearchConfig(confurl string) error {
-	if !strings.HasPrefix(confurl, "http") {
-		log.Println("fetchSearchConfig: unconfigured, using the default conf", confurl)
-		return nil
+// searchDisabled is true if the search is turned off by DisableSearch, or
+// a noscraper build has no search provider of the plugins
+func (s *Server) searchDisabled() bool {
+	if s.engineConfig.DisableSearch {
+		return true
 	}
-	log.Println("fetchSearchConfig: loading search config from", confurl)
-	resp, err := http.Get(confurl)
-	if err != nil {
-		log.Println("[fetchSearchConfig]", err)
-		return err
+	return !scraperBuilt && len(s.engine.Plugins().SearchProviders()) == 0
+}
+
+func (s *Server) isPluginSearch(name string) bool {
+	for _, n := range s.engine.Plugins().SearchProviders() {
+		if n == name {
+			return true
+		}
 	}
-	defer resp.Body.Close()
-	newConfig, err := ioutil.ReadAll(resp.Body)
+	return false
+}
+
+// servePluginSearch serves the search by plugin, in the same form as scraper
+func (s *Server) servePluginSearch(w http.ResponseWriter, r *http.Request, name string) {
+	w.Header().Set("Content-Type", "application/json")
+	filter, err := engine.ParseReleaseFilter(r.URL.Query().Get("filter"))
 	if err != nil {
-		return err
+		w.WriteHeader(http.StatusBadRequest)
+		common.HandleError(json.NewEncoder(w).Encode(map[string]string{"error": err.Error()}))
+		return
 	}
-	newConfig, err = normalize(newConfig)
-	if err != nil {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	var res []plugin.SearchResult
+	err = s.timedSearch(name, func() (err error) {
+		res, err = s.engine.Plugins().Search(name, plugin.SearchQuery{
+			Query: r.URL.Query().Get("query"),
+			Page:  page,
+		})
 		return err
+	})
+	if err != nil {
+		w.WriteHeader(searchErrorStatus(err))
+		common.HandleError(json.NewEncoder(w).Encode(map[string]string{"error": err.Error()}))
+		return
 	}
-	if bytes.Equal(currentConfig, newConfig) {
-		return nil //skip
+	matched := make([]plugin.SearchResult, 0, len(res))
+	for _, rs := range res {
+		if releaseResult(rs, filter) {
+			matched = append(matched, rs)
+		}
 	}
-	if err := s.scraper.LoadConfig(newConfig); err != nil {
-		return err
+	common.HandleError(json.NewEncoder(w).Encode(matched))
+}
+
+// releaseResult adds the fields of the release of the result name, the
+// ones of the provider kept, and tells whether it matches the ?filter=
+func releaseResult(r map[string]string, filter engine.ReleaseFilter) bool {
+	rel := engine.ParseRelease(r["name"])
+	for k, v := range map[string]string{
+		"title":   rel.Title,
+		"season":  strconv.Itoa(rel.Season),
+		"episode": strconv.Itoa(rel.Episode),
+		"quality": rel.Quality,
+		"group":   rel.Group,
+	} {
+		if r[k] == "" && v != "" && v != "0" {
+			r[k] = v
+		}
 	}
-	s.searchProviders = &s.scraper.Config
-	currentConfig = newConfig
-	log.Printf("Loaded new search providers")
-	return nil
+	return filter.Match(r["name"])
 }
 
-func normalize(input []byte) ([]byte, error) {
-	output := bytes.Buffer{}
-	if err := json.Indent(&output, input, "", "  "); err != nil {
-		return nil, err
+// searchErrorStatus is the status of a failed search, 503 for a provider
+// disabled
+func searchErrorStatus(err error) int {
+	if errors.Is(err, errProviderDisabled) {
+		return http.StatusServiceUnavailable
 	}
-	return output.Bytes(), nil
+	return http.StatusInternalServerError
 }
 
-//see github.com/jpillora/scraper for config specification
-//cloud-torrent uses "<id>-item" handlers
+// searchProviderList merges the scraper providers with the plugin providers
+func (s *Server) searchProviderList() map[string]interface{} {
+	providers := make(map[string]interface{})
+	if s.engineConfig.DisableSearch {
+		return providers
+	}
+	s.addScraperProviders(providers)
+	for _, n := range s.engine.Plugins().SearchProviders() {
+		providers[n] = map[string]string{"name": n, "url": ""}
+	}
+	return providers
+}
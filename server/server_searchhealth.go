@@ -0,0 +1,154 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// the last queries a provider is judged on
+	searchHealthWindow = 20
+	// the queries before a provider is judged
+	minSearchHealthQueries = 5
+	// a provider failing this share of the queries is degraded
+	searchDegradedRate = 0.5
+)
+
+var errProviderDisabled = errors.New("Search provider disabled")
+
+// providerHealth is how a search provider did on the last queries,
+// reported by GET /api/searchhealth
+type providerHealth struct {
+	Provider    string
+	Queries     int
+	Failures    int
+	SuccessRate float64
+	// the average of the queries succeeded, in ms
+	Latency     int64
+	LastError   string `json:",omitempty"`
+	LastErrorAt time.Time
+	Degraded    bool
+	// refused till DisabledUntil, by the SearchAutoDisable
+	Disabled      bool
+	DisabledUntil time.Time
+}
+
+type searchQuery struct {
+	failed  bool
+	latency time.Duration
+}
+
+// providerQueries is the record of a provider
+type providerQueries struct {
+	queries       []searchQuery
+	lastError     string
+	lastErrorAt   time.Time
+	disabledUntil time.Time
+}
+
+type searchHealthState struct {
+	sync.Mutex
+	providers map[string]*providerQueries
+}
+
+// searchAllowed refuses the query of a provider disabled, the disabled
+// ones get a fresh start once expired
+func (s *Server) searchAllowed(provider string) error {
+	h := &s.searchHealth
+	h.Lock()
+	defer h.Unlock()
+	pq := h.providers[provider]
+	switch {
+	case pq == nil || pq.disabledUntil.IsZero():
+		return nil
+	case time.Now().Before(pq.disabledUntil):
+		return fmt.Errorf("%w: %s failing, till %s", errProviderDisabled, provider, pq.disabledUntil.Format(time.RFC3339))
+	}
+	pq.disabledUntil = time.Time{}
+	pq.queries = nil
+	log.Println("[Search] re-enabled", provider)
+	return nil
+}
+
+// recordSearch keeps the result of a query, disabling the provider gone
+// degraded if the SearchAutoDisable is set
+func (s *Server) recordSearch(provider string, err error, latency time.Duration) {
+	autoDisable := s.config().SearchAutoDisable
+	h := &s.searchHealth
+	h.Lock()
+	defer h.Unlock()
+	if h.providers == nil {
+		h.providers = make(map[string]*providerQueries)
+	}
+	pq := h.providers[provider]
+	if pq == nil {
+		pq = &providerQueries{}
+		h.providers[provider] = pq
+	}
+	pq.queries = append(pq.queries, searchQuery{failed: err != nil, latency: latency})
+	if len(pq.queries) > searchHealthWindow {
+		pq.queries = pq.queries[len(pq.queries)-searchHealthWindow:]
+	}
+	if err != nil {
+		pq.lastError, pq.lastErrorAt = err.Error(), time.Now()
+	}
+	if ph := pq.health(provider); ph.Degraded && autoDisable > 0 && pq.disabledUntil.IsZero() {
+		pq.disabledUntil = time.Now().Add(autoDisable)
+		log.Printf("[Search] %s disabled for %s, %d of %d queries failed", provider, autoDisable, ph.Failures, ph.Queries)
+	}
+}
+
+// health judges the provider on its last queries
+func (pq *providerQueries) health(provider string) providerHealth {
+	ph := providerHealth{
+		Provider:      provider,
+		LastError:     pq.lastError,
+		LastErrorAt:   pq.lastErrorAt,
+		Disabled:      time.Now().Before(pq.disabledUntil),
+		DisabledUntil: pq.disabledUntil,
+	}
+	var total time.Duration
+	for _, q := range pq.queries {
+		ph.Queries++
+		if q.failed {
+			ph.Failures++
+			continue
+		}
+		total += q.latency
+	}
+	if ok := ph.Queries - ph.Failures; ok > 0 {
+		ph.Latency = (total / time.Duration(ok)).Milliseconds()
+	}
+	if ph.Queries > 0 {
+		ph.SuccessRate = float64(ph.Queries-ph.Failures) / float64(ph.Queries)
+	}
+	ph.Degraded = ph.Queries >= minSearchHealthQueries && 1-ph.SuccessRate >= searchDegradedRate
+	return ph
+}
+
+// searchHealthList is the health of the providers queried
+func (s *Server) searchHealthList() []providerHealth {
+	h := &s.searchHealth
+	h.Lock()
+	defer h.Unlock()
+	res := []providerHealth{}
+	for p, pq := range h.providers {
+		res = append(res, pq.health(p))
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Provider < res[j].Provider })
+	return res
+}
+
+// timedSearch runs the query of the provider, recording how it did
+func (s *Server) timedSearch(provider string, query func() error) error {
+	if err := s.searchAllowed(provider); err != nil {
+		return err
+	}
+	start := time.Now()
+	err := query()
+	s.recordSearch(provider, err, time.Since(start))
+	return err
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/boypt/simple-torrent/common"
+	"github.com/boypt/simple-torrent/engine"
+	"github.com/jpillora/archive"
+	"golang.org/x/time/rate"
+)
+
+// the sharing links are served without the auth at /share/<token>
+const sharePath = "/share/"
+
+// shareRequest creates a sharing link at POST /api/share
+type shareRequest struct {
+	// relative to the download directory
+	Path string
+	// the expiry as a duration eg. 72h, empty for a day
+	TTL      string
+	Password string
+	// the download rate limit eg. 1MB, empty for none
+	Rate string
+}
+
+type shareResponse struct {
+	engine.ShareLink
+	URL string
+}
+
+// apiShare serves POST /api/share creating a link, and
+// /api/share/<token>/revoke deleting it
+func (s *Server) apiShare(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	if rest := strings.TrimPrefix(r.URL.Path, "/api/share/"); rest != r.URL.Path {
+		parts := strings.SplitN(rest, "/", 2)
+		if len(parts) != 2 || parts[1] != "revoke" {
+			return errUnknowAct
+		}
+		if err := s.engine.RevokeShare(parts[0]); err != nil {
+			return err
+		}
+		_, err := w.Write([]byte("OK"))
+		return err
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		return err
+	}
+	var req shareRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return errInvalidReq
+	}
+	var ttl time.Duration
+	if req.TTL != "" {
+		if ttl, err = time.ParseDuration(req.TTL); err != nil {
+			return fmt.Errorf("%w: expiry %s", engine.ErrInvalidShare, err)
+		}
+	}
+	link, err := s.engine.CreateShare(req.Path, ttl, req.Password, req.Rate)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(shareResponse{ShareLink: *link, URL: sharePath + link.Token})
+}
+
+// serveShare serves the file of a sharing link, or the dir as a zip. The
+// password of a protected link is asked by the basic auth, any user name.
+func (s *Server) serveShare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := strings.SplitN(strings.TrimPrefix(r.URL.Path, sharePath), "/", 2)[0]
+	_, password, _ := r.BasicAuth()
+	link, fn, lim, err := s.engine.OpenShare(token, password)
+	switch {
+	case errors.Is(err, engine.ErrSharePassword):
+		w.Header().Set("WWW-Authenticate", `Basic realm="Shared file, any user name"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	log.Printf("[Share] %s downloaded by %s", link.Path, r.RemoteAddr)
+	w.Header().Set("Cache-Control", "private, no-store")
+	if lim != nil {
+		w = &throttledWriter{ResponseWriter: w, lim: lim, r: r}
+	}
+
+	name := path.Base(link.Path)
+	if link.IsDir {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".zip"))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == "HEAD" {
+			return
+		}
+		a := archive.NewZipWriter(w)
+		common.HandleError(a.AddDir(fn))
+		a.Close()
+		return
+	}
+	f, err := os.Open(fn)
+	if err != nil {
+		http.Error(w, "File is gone", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil || st.IsDir() {
+		http.Error(w, "File is gone", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeContent(w, r, name, st.ModTime(), f)
+}
+
+// throttledWriter limits the rate of the response
+type throttledWriter struct {
+	http.ResponseWriter
+	lim *rate.Limiter
+	r   *http.Request
+}
+
+func (tw *throttledWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		n := len(p)
+		if b := tw.lim.Burst(); b > 0 && n > b {
+			n = b
+		}
+		if err := tw.lim.WaitN(tw.r.Context(), n); err != nil {
+			return written, err
+		}
+		m, err := tw.ResponseWriter.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
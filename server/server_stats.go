@@ -2,22 +2,55 @@ package server
 
 import (
 	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"time"
 
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/disk"
 	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/net"
 )
 
 type osStats struct {
-	CPU             float64 `json:"cpu"`
-	DiskFree        uint64  `json:"diskFree"`
-	DiskUsedPercent float64 `json:"diskUsedPercent"`
-	MemUsedPercent  float64 `json:"memUsedPercent"`
-	GoMemory        int64   `json:"goMemory"`
-	GoRoutines      int     `json:"goRoutines"`
+	CPU             float64     `json:"cpu"`
+	DiskFree        uint64      `json:"diskFree"`
+	DiskUsedPercent float64     `json:"diskUsedPercent"`
+	DiskIO          diskIOStat  `json:"diskIO"`
+	NetIO           []netIOStat `json:"netIO"`
+	MemUsedPercent  float64     `json:"memUsedPercent"`
+	GoMemory        int64       `json:"goMemory"`
+	GoRoutines      int         `json:"goRoutines"`
 	//internal
 	diskDirPath string
+	diskDevice  *string
+	lastDisk    disk.IOCountersStat
+	lastNet     map[string]net.IOCountersStat
+	lastSample  time.Time
+}
+
+// diskIOStat is the throughput and latency of the download volume's
+// device between the samples
+type diskIOStat struct {
+	Device       string  `json:"device"`
+	ReadRate     uint64  `json:"readRate"`
+	WriteRate    uint64  `json:"writeRate"`
+	ReadLatency  float64 `json:"readLatency"`
+	WriteLatency float64 `json:"writeLatency"`
+	BusyPercent  float64 `json:"busyPercent"`
+}
+
+// netIOStat is the traffic of a network interface between the samples,
+// with its total counters
+type netIOStat struct {
+	Name      string `json:"name"`
+	RecvRate  uint64 `json:"recvRate"`
+	SentRate  uint64 `json:"sentRate"`
+	BytesRecv uint64 `json:"bytesRecv"`
+	BytesSent uint64 `json:"bytesSent"`
+	Errors    uint64 `json:"errors"`
+	Drops     uint64 `json:"drops"`
 }
 
 func (s *osStats) loadStats() {
@@ -30,6 +63,13 @@ func (s *osStats) loadStats() {
 		s.DiskUsedPercent = stat.UsedPercent
 		s.DiskFree = stat.Free
 	}
+	//disk and network throughput since the last sample
+	now := time.Now()
+	if elapsed := now.Sub(s.lastSample).Seconds(); elapsed > 0 {
+		s.loadDiskIO(elapsed)
+		s.loadNetIO(elapsed)
+	}
+	s.lastSample = now
 	//count memory usage
 	if stat, err := mem.VirtualMemory(); err == nil {
 		s.MemUsedPercent = stat.UsedPercent
@@ -42,6 +82,113 @@ func (s *osStats) loadStats() {
 	s.GoRoutines = runtime.NumGoroutine()
 }
 
+// ioRate is the per second rate of a counter, 0 on the first sample or if
+// the counter was reset
+func ioRate(cur, last uint64, elapsed float64) uint64 {
+	if last == 0 || cur < last {
+		return 0
+	}
+	return uint64(float64(cur-last) / elapsed)
+}
+
+// ioLatency is the average ms an io took
+func ioLatency(curTime, lastTime, curCount, lastCount uint64) float64 {
+	if curCount <= lastCount || curTime < lastTime {
+		return 0
+	}
+	return float64(curTime-lastTime) / float64(curCount-lastCount)
+}
+
+func (s *osStats) loadDiskIO(elapsed float64) {
+	if s.diskDevice == nil {
+		dev := diskDevice(s.diskDirPath)
+		s.diskDevice = &dev
+	}
+	if *s.diskDevice == "" {
+		return
+	}
+	counters, err := disk.IOCounters(*s.diskDevice)
+	if err != nil {
+		return
+	}
+	cur, ok := counters[*s.diskDevice]
+	if !ok {
+		return
+	}
+	last := s.lastDisk
+	s.DiskIO = diskIOStat{
+		Device:       *s.diskDevice,
+		ReadRate:     ioRate(cur.ReadBytes, last.ReadBytes, elapsed),
+		WriteRate:    ioRate(cur.WriteBytes, last.WriteBytes, elapsed),
+		ReadLatency:  ioLatency(cur.ReadTime, last.ReadTime, cur.ReadCount, last.ReadCount),
+		WriteLatency: ioLatency(cur.WriteTime, last.WriteTime, cur.WriteCount, last.WriteCount),
+	}
+	if last.IoTime > 0 && cur.IoTime >= last.IoTime {
+		// ms busy in the elapsed seconds
+		s.DiskIO.BusyPercent = float64(cur.IoTime-last.IoTime) / (elapsed * 10)
+	}
+	s.lastDisk = cur
+}
+
+func (s *osStats) loadNetIO(elapsed float64) {
+	counters, err := net.IOCounters(true)
+	if err != nil {
+		return
+	}
+	stats := make([]netIOStat, 0, len(counters))
+	lastNet := make(map[string]net.IOCountersStat, len(counters))
+	for _, cur := range counters {
+		if cur.Name == "lo" || cur.BytesRecv+cur.BytesSent == 0 {
+			continue
+		}
+		last := s.lastNet[cur.Name]
+		stats = append(stats, netIOStat{
+			Name:      cur.Name,
+			RecvRate:  ioRate(cur.BytesRecv, last.BytesRecv, elapsed),
+			SentRate:  ioRate(cur.BytesSent, last.BytesSent, elapsed),
+			BytesRecv: cur.BytesRecv,
+			BytesSent: cur.BytesSent,
+			Errors:    cur.Errin + cur.Errout,
+			Drops:     cur.Dropin + cur.Dropout,
+		})
+		lastNet[cur.Name] = cur
+	}
+	s.NetIO = stats
+	s.lastNet = lastNet
+}
+
+// diskDevice finds the device name (as in the io counters, eg. sda1) of the
+// partition mounting dir, empty if unknown
+func diskDevice(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	if d, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = d
+	}
+	parts, err := disk.Partitions(false)
+	if err != nil {
+		return ""
+	}
+	var mount disk.PartitionStat
+	for _, p := range parts {
+		if (dir == p.Mountpoint || strings.HasPrefix(dir, strings.TrimRight(p.Mountpoint, "/")+"/")) &&
+			len(p.Mountpoint) > len(mount.Mountpoint) {
+			mount = p
+		}
+	}
+	if !strings.HasPrefix(mount.Device, "/dev/") {
+		return ""
+	}
+	dev := mount.Device
+	// eg. /dev/mapper/vg-root -> /dev/dm-0
+	if d, err := filepath.EvalSymlinks(dev); err == nil {
+		dev = d
+	}
+	return filepath.Base(dev)
+}
+
 func detectDiskStat(dir string) error {
 
 	if err := os.Mkdir(dir, os.ModePerm); err != nil {
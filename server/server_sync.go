@@ -0,0 +1,166 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jpillora/velox"
+)
+
+// the intervals a sync client may wait between the pushes
+const (
+	minSyncInterval = time.Second
+	maxSyncInterval = time.Hour
+)
+
+var errSyncSubscription = errors.New("Invalid sync subscription")
+
+// syncClient is a sync connection subscribed to some sections of the state,
+// /sync?sections=Stats,Volume&interval=10s, pushed every interval instead
+// of on each change. A dashboard gets its stats without the torrents.
+type syncClient struct {
+	velox.State
+	s        *Server
+	sections map[string]bool
+	interval time.Duration
+}
+
+// stateSections is the top level fields of the state a client subscribes to
+func (s *Server) stateSections() map[string]bool {
+	res := make(map[string]bool)
+	st := reflect.TypeOf(&s.state).Elem()
+	for i := 0; i < st.NumField(); i++ {
+		if f := st.Field(i); !f.Anonymous {
+			res[f.Name] = true
+		}
+	}
+	return res
+}
+
+// parseSections reads the comma separated sections, nil for all
+func (s *Server) parseSections(sections string) (map[string]bool, error) {
+	if sections == "" {
+		return nil, nil
+	}
+	known := s.stateSections()
+	res := make(map[string]bool)
+	for _, sec := range strings.Split(sections, ",") {
+		sec = strings.TrimSpace(sec)
+		if !known[sec] {
+			return nil, fmt.Errorf("%w: unknown section %q", errSyncSubscription, sec)
+		}
+		res[sec] = true
+	}
+	return res, nil
+}
+
+// newSyncClient reads the subscription of a /sync request, nil for the
+// whole state pushed on each change
+func (s *Server) newSyncClient(q url.Values) (*syncClient, error) {
+	sections, interval := q.Get("sections"), q.Get("interval")
+	if sections == "" && interval == "" {
+		return nil, nil
+	}
+	c := &syncClient{s: s, interval: time.Duration(s.IntevalSec) * time.Second}
+	var err error
+	if c.sections, err = s.parseSections(sections); err != nil {
+		return nil, err
+	}
+	if interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errSyncSubscription, err)
+		}
+		if d < minSyncInterval || d > maxSyncInterval {
+			return nil, fmt.Errorf("%w: interval %s out of %s-%s", errSyncSubscription, d, minSyncInterval, maxSyncInterval)
+		}
+		c.interval = d
+	}
+	return c, nil
+}
+
+// MarshalJSON is the sections subscribed of the state
+func (c *syncClient) MarshalJSON() ([]byte, error) {
+	return c.s.stateJSON(c.sections)
+}
+
+// stateJSON is the sections of the state, all if nil
+func (s *Server) stateJSON(sections map[string]bool) ([]byte, error) {
+	s.state.Lock()
+	data, err := json.Marshal(&s.state)
+	s.state.Unlock()
+	if err != nil || sections == nil {
+		return data, err
+	}
+	all := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	for k := range all {
+		if !sections[k] {
+			delete(all, k)
+		}
+	}
+	return json.Marshal(all)
+}
+
+// apiState serves GET /api/state[?sections=...], the state of /sync for
+// the scripts polling now and then. The ETag saves sending it unchanged.
+func (s *Server) apiState(w http.ResponseWriter, r *http.Request) error {
+	sections, err := s.parseSections(r.URL.Query().Get("sections"))
+	if err != nil {
+		return err
+	}
+	if sections == nil || sections["Stats"] {
+		// refreshed by the ticker only while a client syncs
+		s.refreshStats()
+	}
+	data, err := s.stateJSON(sections)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(data)
+	return err
+}
+
+// etagMatch tells whether the If-None-Match lists the etag, weak or not
+func etagMatch(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// pushRoutine pushes the changes every interval till done
+func (c *syncClient) pushRoutine(done <-chan struct{}) {
+	tk := time.NewTicker(c.interval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+			c.Push()
+		case <-done:
+			return
+		}
+	}
+}
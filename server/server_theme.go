@@ -0,0 +1,158 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ctstatic "github.com/boypt/simple-torrent/static"
+	"github.com/spf13/viper"
+)
+
+const maxThemePackSize = 50 << 20
+
+// applyTheme switches the web UI to the UITheme: a directory, or the url of
+// a .zip theme pack extracted to the themes dir next to the config file. The
+// built-in UI is restored by an empty theme.
+func (s *Server) applyTheme(theme string) error {
+	fsys := ctstatic.Builtin()
+	switch {
+	case theme == "":
+	case strings.HasPrefix(theme, "http://") || strings.HasPrefix(theme, "https://"):
+		themesDir := filepath.Join(filepath.Dir(viper.ConfigFileUsed()), "themes")
+		dir, err := fetchThemePack(theme, themesDir)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ctstatic.ErrInvalidTheme, err)
+		}
+		fsys = os.DirFS(dir)
+	default:
+		fsys = os.DirFS(theme)
+	}
+
+	tpls, err := parseTemplates(fsys)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ctstatic.ErrInvalidTheme, err)
+	}
+	if err := ctstatic.Use(fsys); err != nil {
+		return err
+	}
+	htmlTPLMu.Lock()
+	htmlTPL = tpls
+	htmlTPLMu.Unlock()
+	log.Printf("[Theme] using %q, assets %s", theme, ctstatic.Fingerprint())
+	return nil
+}
+
+// fetchThemePack downloads the theme pack and extracts it to its own dir in
+// themesDir, replacing the last download of the url
+func fetchThemePack(u, themesDir string) (string, error) {
+	resp, err := http.Get(u)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxThemePackSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxThemePackSize {
+		return "", fmt.Errorf("theme pack larger than %d bytes", maxThemePackSize)
+	}
+
+	if err := os.MkdirAll(themesDir, 0755); err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempDir(themesDir, ".download")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	if err := extractZip(data, tmp); err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(u))
+	dir := filepath.Join(themesDir, hex.EncodeToString(sum[:8]))
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return "", err
+	}
+	return themeRoot(dir), nil
+}
+
+// extractZip extracts the regular files of the archive into dir, refusing
+// the paths out of it
+func extractZip(data []byte, dir string) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	// the extracted size, against the zip bombs
+	budget := int64(4 * maxThemePackSize)
+	for _, zf := range zr.File {
+		name := filepath.FromSlash(zf.Name)
+		if !fs.ValidPath(strings.TrimSuffix(zf.Name, "/")) || strings.Contains(zf.Name, `\`) {
+			return fmt.Errorf("invalid path in the archive: %s", zf.Name)
+		}
+		target := filepath.Join(dir, name)
+		if zf.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		f, err := os.Create(target)
+		if err == nil {
+			var n int64
+			n, err = io.Copy(f, io.LimitReader(rc, budget+1))
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if budget -= n; budget < 0 {
+				err = fmt.Errorf("theme pack extracts to more than %d bytes", 4*maxThemePackSize)
+			}
+		}
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// themeRoot is the single top dir of a pack without an index.html at its
+// root, as many archives are made
+func themeRoot(dir string) string {
+	if _, err := os.Stat(filepath.Join(dir, "index.html")); err == nil {
+		return dir
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 || !entries[0].IsDir() {
+		return dir
+	}
+	return filepath.Join(dir, entries[0].Name())
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/boypt/simple-torrent/engine"
+)
+
+var (
+	errTranscodeDisabled = errors.New("Transcoding is disabled, set TranscodeFFmpeg to enable")
+	errTranscodeBusy     = errors.New("Too many transcoding jobs")
+)
+
+var transcodeJobs int32
+
+// transcodeArgs builds the ffmpeg arguments streaming fragmented mp4 to stdout.
+// mode "remux" copies the video stream, only changing the container.
+func transcodeArgs(file string, hwaccel []string, vcodec, mode string, start float64) []string {
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin"}
+	if mode != "remux" {
+		args = append(args, hwaccel...)
+	}
+	if start > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(start, 'f', 3, 64))
+	}
+	args = append(args, "-i", file, "-map", "0:v:0?", "-map", "0:a:0?")
+	if mode == "remux" {
+		args = append(args, "-c:v", "copy")
+	} else {
+		args = append(args, "-c:v", vcodec)
+		if vcodec == "libx264" {
+			args = append(args, "-preset", "veryfast", "-pix_fmt", "yuv420p")
+		}
+	}
+	return append(args,
+		"-c:a", "aac", "-ac", "2",
+		"-movflags", "frag_keyframe+empty_moov+default_base_moof",
+		"-f", "mp4", "pipe:1")
+}
+
+// serveTranscode streams a video file under DownloadDirectory transcoded by
+// ffmpeg to fragmented mp4, which browsers play natively. The request is
+// /transcode/<path>?start=<seconds>&mode=remux, both parameters are optional.
+func (s *Server) serveTranscode(w http.ResponseWriter, r *http.Request) {
+	c := s.engineConfig
+	if c.TranscodeFFmpeg == "" {
+		http.Error(w, errTranscodeDisabled.Error(), http.StatusNotFound)
+		return
+	}
+
+	dldir := c.DownloadDirectory
+	file, err := filepath.Abs(filepath.Join(dldir, r.URL.Path))
+	if err != nil || !strings.HasPrefix(file, dldir) || dldir == file {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	if info, err := os.Stat(file); err != nil || info.IsDir() {
+		http.Error(w, "Not a file", http.StatusBadRequest)
+		return
+	}
+
+	if max := int32(c.TranscodeMaxJobs); max > 0 {
+		if atomic.AddInt32(&transcodeJobs, 1) > max {
+			atomic.AddInt32(&transcodeJobs, -1)
+			http.Error(w, errTranscodeBusy.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer atomic.AddInt32(&transcodeJobs, -1)
+	}
+
+	start, _ := strconv.ParseFloat(r.URL.Query().Get("start"), 64)
+	vcodec := c.TranscodeVideoCodec
+	if vcodec == "" {
+		vcodec = "libx264"
+	}
+	hwaccel, err := engine.HWAccelArgs(c.TranscodeHWAccel)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("TranscodeHWAccel: %s", err), http.StatusInternalServerError)
+		return
+	}
+	args := transcodeArgs(file, hwaccel, vcodec, r.URL.Query().Get("mode"), start)
+
+	// killed when the client goes away
+	cmd := exec.CommandContext(r.Context(), c.TranscodeFFmpeg, args...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		http.Error(w, fmt.Sprintf("ffmpeg: %s", err), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[transcode] %s start=%.1f", file, start)
+
+	// avoid gzip buffering
+	w.Header().Set("Content-Encoding", "identity")
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Cache-Control", "no-cache")
+	buf := make([]byte, 256<<10)
+	for {
+		n, rerr := stdout.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				break
+			}
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+		if rerr != nil {
+			break
+		}
+	}
+	if err := cmd.Wait(); err != nil && r.Context().Err() == nil {
+		log.Printf("[transcode] %s: %s %s", file, err, strings.TrimSpace(stderr.String()))
+	}
+}
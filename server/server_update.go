@@ -0,0 +1,81 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/boypt/simple-torrent/update"
+)
+
+// the GitHub API allows 60 requests an hour unauthenticated
+const updateCheckTTL = 10 * time.Minute
+
+var errUpdating = errors.New("Already updating")
+
+var updateState = struct {
+	sync.Mutex
+	checked  time.Time
+	release  *update.Release
+	updating bool
+}{}
+
+// checkUpdate looks up the latest release, cached a while
+func (s *Server) checkUpdate() (*update.Release, error) {
+	updateState.Lock()
+	defer updateState.Unlock()
+	if updateState.release != nil && time.Since(updateState.checked) < updateCheckTTL {
+		return updateState.release, nil
+	}
+	rel, err := update.Check(s.tpl.Version)
+	if err != nil {
+		return nil, err
+	}
+	updateState.release, updateState.checked = rel, time.Now()
+	return rel, nil
+}
+
+// selfUpdate installs the latest release over the binary, then restarts
+// the process with it in background, after the response is sent
+func (s *Server) selfUpdate() error {
+	if !s.engineConfig.AllowRuntimeConfigure {
+		return errors.New("AllowRuntimeConfigure is set to false")
+	}
+	updateState.Lock()
+	if updateState.updating {
+		updateState.Unlock()
+		return errUpdating
+	}
+	updateState.updating = true
+	updateState.release = nil
+	updateState.Unlock()
+
+	defer func() {
+		updateState.Lock()
+		updateState.updating = false
+		updateState.Unlock()
+	}()
+
+	rel, err := s.checkUpdate()
+	if err != nil {
+		return err
+	}
+	if !rel.Newer {
+		return update.ErrUpToDate
+	}
+	log.Printf("[Update] updating %s to %s with %s", rel.Current, rel.Version, rel.Asset)
+	exe, err := update.Apply(rel)
+	if err != nil {
+		log.Println("[Update] failed", err)
+		return err
+	}
+	log.Printf("[Update] %s updated to %s, restarting", exe, rel.Version)
+
+	go func() {
+		time.Sleep(time.Second)
+		if err := update.Restart(exe, s.engine.Close); err != nil {
+			log.Println("[Update]", err)
+		}
+	}()
+	return nil
+}
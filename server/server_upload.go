@@ -0,0 +1,333 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boypt/simple-torrent/engine"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+const (
+	// the uploads in progress are kept in the hidden dir of the download
+	// directory, so a completed one is moved in place
+	uploadsDir     = ".uploads"
+	maxUploadChunk = 64 << 20
+	// the uploads not resumed for this long are dropped
+	uploadExpiry = 7 * 24 * time.Hour
+)
+
+var (
+	uploadIDRegexp = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+	errUploadNotFound = errors.New("Upload not found")
+	errUploadOffset   = errors.New("Upload offset mismatch")
+	errUploadBusy     = errors.New("A chunk of the upload is in progress")
+	errUploadTarget   = errors.New("Invalid upload target")
+)
+
+// upload is a chunked upload in progress, resumed from its Offset after an
+// interruption. Created by POST /api/upload, the chunks are posted to
+// /api/upload/<id>?offset=<n> in order.
+type upload struct {
+	ID   string
+	Name string
+	// relative to the download directory, empty for the top
+	Dir  string
+	Size int64
+	// the bytes received
+	Offset int64
+	// a .torrent file added as a task when completed, instead of saved
+	AddTorrent bool
+	Updated    time.Time
+	// where the completed upload is saved
+	Path string `json:",omitempty"`
+}
+
+type uploadState struct {
+	sync.Mutex
+	// the uploads receiving a chunk
+	busy map[string]bool
+}
+
+func (s *Server) uploadsPath() string {
+	return filepath.Join(s.engineConfig.DownloadDirectory, uploadsDir)
+}
+
+func (s *Server) uploadFile(id, ext string) string {
+	return filepath.Join(s.uploadsPath(), id+ext)
+}
+
+// uploadTarget checks the dir and name of an upload, returning the path it
+// is saved to
+func (s *Server) uploadTarget(dir, name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("%w: name %q", errUploadTarget, name)
+	}
+	// without a trailing slash, the root "/" too
+	dldir := filepath.Clean(s.engineConfig.DownloadDirectory)
+	target := filepath.Join(dldir, filepath.FromSlash(dir), name)
+	if !strings.HasPrefix(target, strings.TrimSuffix(dldir, string(filepath.Separator))+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: dir %q", errUploadTarget, dir)
+	}
+	for _, seg := range strings.Split(filepath.ToSlash(dir), "/") {
+		if strings.HasPrefix(seg, ".") && seg != "." {
+			return "", fmt.Errorf("%w: dir %q", errUploadTarget, dir)
+		}
+	}
+	return target, nil
+}
+
+func (s *Server) loadUpload(id string) (*upload, error) {
+	if !uploadIDRegexp.MatchString(id) {
+		return nil, errUploadNotFound
+	}
+	data, err := ioutil.ReadFile(s.uploadFile(id, ".json"))
+	if err != nil {
+		return nil, errUploadNotFound
+	}
+	u := &upload{}
+	if err := json.Unmarshal(data, u); err != nil {
+		return nil, err
+	}
+	// what's on disk after a crash
+	if st, err := os.Stat(s.uploadFile(id, ".part")); err == nil {
+		u.Offset = st.Size()
+	}
+	return u, nil
+}
+
+func (s *Server) saveUpload(u *upload) error {
+	u.Updated = time.Now()
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.uploadFile(u.ID, ".json"), data, 0644)
+}
+
+func (s *Server) removeUpload(id string) {
+	os.Remove(s.uploadFile(id, ".part"))
+	os.Remove(s.uploadFile(id, ".json"))
+}
+
+// listUploads is the uploads to resume, the expired ones dropped
+func (s *Server) listUploads() []*upload {
+	files, _ := filepath.Glob(filepath.Join(s.uploadsPath(), "*.json"))
+	res := []*upload{}
+	for _, fn := range files {
+		id := strings.TrimSuffix(filepath.Base(fn), ".json")
+		u, err := s.loadUpload(id)
+		if err != nil {
+			continue
+		}
+		if time.Since(u.Updated) > uploadExpiry {
+			log.Println("[Upload] expired", u.Name)
+			s.removeUpload(id)
+			continue
+		}
+		res = append(res, u)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Updated.After(res[j].Updated) })
+	return res
+}
+
+// apiUpload serves POST /api/upload creating an upload of the JSON
+// {Name, Size, Dir, AddTorrent}, /api/upload/<id>?offset=<n> receiving a
+// chunk, and /api/upload/<id>/cancel
+func (s *Server) apiUpload(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	var (
+		u   *upload
+		err error
+	)
+	switch parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/upload"), "/", 3); {
+	case len(parts) == 1 && parts[0] == "":
+		u, err = s.createUpload(r)
+	case len(parts) == 2:
+		u, err = s.uploadChunk(parts[1], r)
+	case len(parts) == 3 && parts[2] == "cancel":
+		if _, err = s.loadUpload(parts[1]); err == nil {
+			s.removeUpload(parts[1])
+			_, err = w.Write([]byte("OK"))
+		}
+		return err
+	default:
+		return errUnknowAct
+	}
+	if errors.Is(err, errUploadOffset) && u != nil {
+		// tells the client where to resume
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		return json.NewEncoder(w).Encode(u)
+	}
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(u)
+}
+
+func (s *Server) createUpload(r *http.Request) (*upload, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	u := &upload{}
+	if err := json.Unmarshal(data, u); err != nil || u.Size < 0 {
+		return nil, errInvalidReq
+	}
+	if u.AddTorrent && !strings.HasSuffix(u.Name, ".torrent") {
+		return nil, fmt.Errorf("%w: not a torrent file %q", errUploadTarget, u.Name)
+	}
+	// read whole to add it
+	if u.AddTorrent && u.Size > maxTorrentFileSize {
+		return nil, fmt.Errorf("%w: torrent file too large, %d bytes", errUploadTarget, u.Size)
+	}
+	if _, err := s.uploadTarget(u.Dir, u.Name); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(s.uploadsPath(), os.ModePerm); err != nil {
+		return nil, err
+	}
+	if st, err := disk.Usage(s.uploadsPath()); err == nil && st.Free < uint64(u.Size) {
+		return nil, ErrDiskSpace
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	u.ID = hex.EncodeToString(id)
+	u.Offset = 0
+	u.Path = ""
+	if err := ioutil.WriteFile(s.uploadFile(u.ID, ".part"), nil, 0644); err != nil {
+		return nil, err
+	}
+	if err := s.saveUpload(u); err != nil {
+		s.removeUpload(u.ID)
+		return nil, err
+	}
+	log.Printf("[Upload] %s started, %d bytes", u.Name, u.Size)
+	return u, s.finishUpload(u)
+}
+
+// uploadChunk appends the body at the offset, the upload is finished with
+// the last chunk
+func (s *Server) uploadChunk(id string, r *http.Request) (*upload, error) {
+	s.uploads.Lock()
+	if s.uploads.busy == nil {
+		s.uploads.busy = make(map[string]bool)
+	}
+	if s.uploads.busy[id] {
+		s.uploads.Unlock()
+		return nil, errUploadBusy
+	}
+	s.uploads.busy[id] = true
+	s.uploads.Unlock()
+	defer func() {
+		s.uploads.Lock()
+		delete(s.uploads.busy, id)
+		s.uploads.Unlock()
+	}()
+
+	u, err := s.loadUpload(id)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil || offset != u.Offset {
+		return u, errUploadOffset
+	}
+	f, err := os.OpenFile(s.uploadFile(id, ".part"), os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// a broken chunk is cut off, the client resumes from the offset
+	_, err = f.Seek(offset, io.SeekStart)
+	var n int64
+	if err == nil {
+		n, err = io.Copy(f, io.LimitReader(r.Body, min64(maxUploadChunk, u.Size-offset)))
+	}
+	if err != nil {
+		f.Truncate(offset)
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	u.Offset += n
+	if err := s.saveUpload(u); err != nil {
+		return nil, err
+	}
+	return u, s.finishUpload(u)
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// finishUpload saves a completed upload in place, or adds it as a task
+func (s *Server) finishUpload(u *upload) error {
+	if u.Offset < u.Size {
+		return nil
+	}
+	part := s.uploadFile(u.ID, ".part")
+	if u.AddTorrent {
+		data, err := ioutil.ReadFile(part)
+		if err != nil {
+			return err
+		}
+		s.removeUpload(u.ID)
+		err = s.engine.NewTorrentByReader(bytes.NewReader(data), engine.AddOptions{Source: engine.AddSourceWeb})
+		if err != nil && !errors.Is(err, engine.ErrMaxConnTasks) {
+			return err
+		}
+		log.Println("[Upload] added", u.Name)
+		return nil
+	}
+
+	target, err := s.uploadTarget(u.Dir, u.Name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+	// never overwrites, "name (1).ext" instead
+	ext := filepath.Ext(target)
+	base := strings.TrimSuffix(target, ext)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(target); os.IsNotExist(err) {
+			break
+		}
+		target = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	if err := os.Rename(part, target); err != nil {
+		return err
+	}
+	os.Remove(s.uploadFile(u.ID, ".json"))
+	u.Path, _ = filepath.Rel(s.engineConfig.DownloadDirectory, target)
+	u.Path = filepath.ToSlash(u.Path)
+	log.Println("[Upload] saved", u.Path)
+	return nil
+}
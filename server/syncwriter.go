@@ -0,0 +1,70 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// syncWriter guards the ResponseWriter of a velox sync connection. Velox
+// keeps writing the events from its own goroutines, and may do it after the
+// handler returned: an HTTP/1 connection tolerates it, but the writer of an
+// HTTP/2 stream is gone by then. The writes after done are dropped.
+type syncWriter struct {
+	mu     sync.Mutex
+	w      http.ResponseWriter
+	header http.Header
+	done   bool
+}
+
+func newSyncWriter(w http.ResponseWriter) *syncWriter {
+	return &syncWriter{w: w, header: w.Header()}
+}
+
+func (sw *syncWriter) Header() http.Header {
+	return sw.header
+}
+
+func (sw *syncWriter) WriteHeader(code int) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if !sw.done {
+		sw.w.WriteHeader(code)
+	}
+}
+
+func (sw *syncWriter) Write(b []byte) (int, error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.done {
+		return 0, http.ErrHandlerTimeout
+	}
+	return sw.w.Write(b)
+}
+
+// Flush is needed by the event source transport
+func (sw *syncWriter) Flush() {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if f, ok := sw.w.(http.Flusher); ok && !sw.done {
+		f.Flush()
+	}
+}
+
+// Hijack is needed by the websockets transport, HTTP/1 only
+func (sw *syncWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := sw.w.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("websockets need HTTP/1.1, use the event source")
+	}
+	return h.Hijack()
+}
+
+// finish is called before the handler returns
+func (sw *syncWriter) finish() {
+	sw.mu.Lock()
+	sw.done = true
+	sw.mu.Unlock()
+}
@@ -0,0 +1,116 @@
+// Package systemd implements the systemd socket activation and sd_notify
+// protocols, without linking to libsystemd.
+package systemd
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd (SD_LISTEN_FDS_START)
+const listenFdsStart = 3
+
+// Listeners returns the sockets passed by systemd socket activation,
+// in the order of the ListenStream= lines of the .socket unit. It returns
+// nil if the process is not socket activated.
+func Listeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds == 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	// don't pass down to the child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, nfds)
+	for fd := listenFdsStart; fd < listenFdsStart+nfds; fd++ {
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i := fd - listenFdsStart; i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		// FileListener dups the fd, the original is closed right after
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// Notify sends the state (eg. "READY=1") to the service manager. It returns
+// false if the service is not started by systemd with NOTIFY_SOCKET.
+func Notify(state string) (bool, error) {
+	sock := os.Getenv("NOTIFY_SOCKET")
+	if sock == "" {
+		return false, nil
+	}
+	if sock[0] == '@' {
+		// abstract namespace socket
+		sock = "\x00" + sock[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns the WatchdogSec= of the service, or 0 if the
+// watchdog is not enabled for this process.
+func WatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+	if wpid := os.Getenv("WATCHDOG_PID"); wpid != "" {
+		pid, err := strconv.Atoi(wpid)
+		if err != nil {
+			return 0, err
+		}
+		if pid != os.Getpid() {
+			return 0, nil
+		}
+	}
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, errors.New("invalid WATCHDOG_USEC")
+	}
+	return time.Duration(n) * time.Microsecond, nil
+}
+
+// Watchdog keeps sending "WATCHDOG=1" at half of the watchdog interval
+// while alive() returns true, it returns immediately if not enabled.
+func Watchdog(alive func() bool) {
+	itv, err := WatchdogInterval()
+	if err != nil || itv == 0 {
+		return
+	}
+	tk := time.NewTicker(itv / 2)
+	defer tk.Stop()
+	for range tk.C {
+		if alive() {
+			Notify("WATCHDOG=1") // nolint: errcheck
+		}
+	}
+}
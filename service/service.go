@@ -0,0 +1,143 @@
+// Package service registers the program as a system service (Windows service
+// or macOS launchd agent) with the flags it's currently called with.
+// Linux users should use the systemd unit under scripts/.
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	Name        = "cloud-torrent"
+	DisplayName = "SimpleTorrent"
+	Description = "Self-hosted remote torrent client"
+)
+
+// Command is the `service` subcommand, flags before the subcommand are
+// recorded as the arguments of the service.
+//
+//	cloud-torrent -c /path/to/cloud-torrent.yaml service install
+type Command struct {
+	Action string `opts:"mode=arg,help=install|uninstall|start|stop"`
+}
+
+func (c *Command) Run() error {
+	switch c.Action {
+	case "install":
+		exe, err := executable()
+		if err != nil {
+			return err
+		}
+		args, conf, err := absArgs(serviceArgs(os.Args[1:]))
+		if err != nil {
+			return err
+		}
+		if err := install(exe, args, filepath.Dir(conf)); err != nil {
+			return err
+		}
+		fmt.Printf("service %s installed: %s %v\n", Name, exe, args)
+	case "uninstall":
+		if err := uninstall(); err != nil {
+			return err
+		}
+		fmt.Printf("service %s uninstalled\n", Name)
+	case "start":
+		return start()
+	case "stop":
+		return stop()
+	default:
+		return fmt.Errorf("unknown action %q, expecting install|uninstall|start|stop", c.Action)
+	}
+	return nil
+}
+
+// serviceArgs takes the arguments before the "service" subcommand
+func serviceArgs(args []string) []string {
+	for i, a := range args {
+		if a == "service" {
+			return args[:i]
+		}
+	}
+	return args
+}
+
+// pathFlags are the flags taking a file path, without the leading dashes
+var pathFlags = map[string]bool{
+	"c":           true,
+	"config-path": true,
+	"key-path":    true,
+	"r":           true,
+	"cert-path":   true,
+}
+
+// absArgs makes the paths in the args absolute, as the service doesn't start
+// in the dir it's installed from. The config file is always given, found as
+// the program does if not in the args, so the service runs in its dir on all
+// the platforms, and the relative dirs in it are the same as from the shell.
+func absArgs(args []string) (abs []string, conf string, err error) {
+	var instance string
+	for i := 0; i < len(args); i++ {
+		flag, v := args[i], ""
+		inline := false
+		if n := strings.Index(flag, "="); n > 0 && strings.HasPrefix(flag, "-") {
+			flag, v, inline = flag[:n], flag[n+1:], true
+		}
+		name := strings.TrimLeft(flag, "-")
+		if !strings.HasPrefix(flag, "-") || !pathFlags[name] && name != "instance" || !inline && i+1 == len(args) {
+			abs = append(abs, args[i])
+			continue
+		}
+		if !inline {
+			i++
+			v = args[i]
+		}
+		if name == "instance" {
+			instance = v
+		} else if v, err = filepath.Abs(v); err != nil {
+			return nil, "", err
+		}
+		if name == "c" || name == "config-path" {
+			conf = v
+		}
+		if inline {
+			abs = append(abs, flag+"="+v)
+		} else {
+			abs = append(abs, flag, v)
+		}
+	}
+	if conf == "" {
+		if conf, err = findConfig(instance); err != nil {
+			return nil, "", err
+		}
+		abs = append(abs, "--config-path", conf)
+	}
+	return abs, conf, nil
+}
+
+// findConfig finds the config file the program loads without the
+// --config-path, in the current dir by default
+func findConfig(instance string) (string, error) {
+	name := "cloud-torrent"
+	if instance != "" {
+		name += "-" + instance
+	}
+	for _, dir := range []string{".", "/etc/"} {
+		for _, ext := range []string{".yaml", ".yml", ".json", ".toml"} {
+			if _, err := os.Stat(filepath.Join(dir, name+ext)); err == nil {
+				return filepath.Abs(filepath.Join(dir, name+ext))
+			}
+		}
+	}
+	return filepath.Abs(name + ".yaml")
+}
+
+func executable() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(exe)
+}
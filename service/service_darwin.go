@@ -0,0 +1,113 @@
+//go:build darwin
+// +build darwin
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+const launchdLabel = "com.github.boypt.simple-torrent"
+
+var plistTPL = template.Must(template.New("plist").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{.Label}}</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>{{.Exe}}</string>
+		{{- range .Args}}
+		<string>{{.}}</string>
+		{{- end}}
+	</array>
+	<key>WorkingDirectory</key>
+	<string>{{.WorkDir}}</string>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>StandardOutPath</key>
+	<string>{{.Log}}</string>
+	<key>StandardErrorPath</key>
+	<string>{{.Log}}</string>
+</dict>
+</plist>
+`))
+
+func plistPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", launchdLabel+".plist"), nil
+}
+
+func install(exe string, args []string, workDir string) error {
+	p, err := plistPath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(p); err == nil {
+		return fmt.Errorf("service %s already exists: %s", Name, p)
+	}
+	home, _ := os.UserHomeDir()
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := plistTPL.Execute(f, map[string]interface{}{
+		"Label":   launchdLabel,
+		"Exe":     exe,
+		"Args":    args,
+		"WorkDir": workDir,
+		"Log":     filepath.Join(home, "Library", "Logs", Name+".log"),
+	}); err != nil {
+		return err
+	}
+	return launchctl("load", "-w", p)
+}
+
+func uninstall() error {
+	p, err := plistPath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(p); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("service %s is not installed", Name)
+	}
+	if err := launchctl("unload", "-w", p); err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
+
+func start() error {
+	return launchctl("start", launchdLabel)
+}
+
+func stop() error {
+	return launchctl("stop", launchdLabel)
+}
+
+func launchctl(args ...string) error {
+	cmd := exec.Command("launchctl", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// Run runs the program, launchd needs nothing special
+func Run(run func() error) error {
+	return run()
+}
@@ -0,0 +1,31 @@
+//go:build !windows && !darwin
+// +build !windows,!darwin
+
+package service
+
+import (
+	"errors"
+)
+
+var errUnsupported = errors.New("service subcommand is not supported on this platform, see scripts/cloud-torrent.service for systemd")
+
+func install(exe string, args []string, workDir string) error {
+	return errUnsupported
+}
+
+func uninstall() error {
+	return errUnsupported
+}
+
+func start() error {
+	return errUnsupported
+}
+
+func stop() error {
+	return errUnsupported
+}
+
+// Run runs the program
+func Run(run func() error) error {
+	return run()
+}
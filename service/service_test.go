@@ -0,0 +1,44 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_absArgs(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	abs := func(p string) string { return filepath.Join(wd, p) }
+	tests := []struct {
+		name     string
+		args     []string
+		want     []string
+		wantConf string
+	}{
+		{"config", []string{"-c", "my.yaml", "--listen", ":3000"},
+			[]string{"-c", abs("my.yaml"), "--listen", ":3000"}, abs("my.yaml")},
+		{"inline", []string{"--config-path=conf/my.yaml", "--cert-path=/etc/ssl/c.pem"},
+			[]string{"--config-path=" + abs("conf/my.yaml"), "--cert-path=/etc/ssl/c.pem"}, abs("conf/my.yaml")},
+		{"key", []string{"--key-path", "k.pem", "-c", "/srv/ct.yaml"},
+			[]string{"--key-path", abs("k.pem"), "-c", "/srv/ct.yaml"}, "/srv/ct.yaml"},
+		{"default", []string{"--listen", ":3000"},
+			[]string{"--listen", ":3000", "--config-path", abs("cloud-torrent.yaml")}, abs("cloud-torrent.yaml")},
+		{"instance", []string{"--instance", "tv"},
+			[]string{"--instance", "tv", "--config-path", abs("cloud-torrent-tv.yaml")}, abs("cloud-torrent-tv.yaml")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, conf, err := absArgs(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) || conf != tt.wantConf {
+				t.Errorf("absArgs() = %q, %q, want %q, %q", got, conf, tt.want, tt.wantConf)
+			}
+		})
+	}
+}
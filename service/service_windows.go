@@ -0,0 +1,123 @@
+//go:build windows
+// +build windows
+
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// install creates the service, started in the workDir by Run, as the
+// services can't be given one
+func install(exe string, args []string, workDir string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect() // nolint: errcheck
+
+	if s, err := m.OpenService(Name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", Name)
+	}
+	s, err := m.CreateService(Name, exe, mgr.Config{
+		DisplayName: DisplayName,
+		Description: Description,
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	return s.Close()
+}
+
+func withService(f func(s *mgr.Service) error) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect() // nolint: errcheck
+	s, err := m.OpenService(Name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %w", Name, err)
+	}
+	defer s.Close()
+	return f(s)
+}
+
+func uninstall() error {
+	return withService(func(s *mgr.Service) error {
+		return s.Delete()
+	})
+}
+
+func start() error {
+	return withService(func(s *mgr.Service) error {
+		return s.Start()
+	})
+}
+
+func stop() error {
+	return withService(func(s *mgr.Service) error {
+		_, err := s.Control(svc.Stop)
+		return err
+	})
+}
+
+// handler reports the service status to the service control manager
+type handler struct {
+	run func() error
+	err error
+}
+
+func (h *handler) Execute(_ []string, r <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	done := make(chan error, 1)
+	go func() { done <- h.run() }()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case h.err = <-done:
+			status <- svc.Status{State: svc.StopPending}
+			if h.err != nil {
+				return true, 1
+			}
+			return false, 0
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				status <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				// give the engine a moment to flush
+				time.Sleep(time.Second)
+				return false, 0
+			}
+		}
+	}
+}
+
+// Run runs the program, under the service control manager if started as a service
+func Run(run func() error) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return run()
+	}
+	// services start in the system dir, use the dir of the config file
+	// given at install instead, same as the launchd agents
+	if _, conf, err := absArgs(os.Args[1:]); err == nil {
+		os.Chdir(filepath.Dir(conf)) // nolint: errcheck
+	}
+	h := &handler{run: run}
+	if err := svc.Run(Name, h); err != nil {
+		return err
+	}
+	return h.err
+}
@@ -0,0 +1,37 @@
+/* globals app */
+
+app.controller("ClusterController", function ($scope, $rootScope, $http, $interval, api) {
+
+  $scope.$nodes = [];
+  $scope.$target = "";
+  $scope.$expanded = true;
+  $scope.section_expanded_toggle = function () {
+    $scope.$expanded = !$scope.$expanded;
+  };
+
+  // the server polls the nodes, follow its rounds quietly
+  var refresh = function () {
+    $http.get("api/cluster").then(function (xhr) {
+      $scope.$nodes = xhr.data || [];
+    });
+  };
+  refresh();
+  var timer = $interval(refresh, 10000);
+  $scope.$on("$destroy", function () {
+    $interval.cancel(timer);
+  });
+
+  $scope.dispatch = function () {
+    var input = ($scope.$input || "").trim();
+    if (!input) {
+      return;
+    }
+    var action = /^magnet:/.test(input) ? "magnet" : "url";
+    api.cluster(action, input, $scope.$target).then(function (xhr) {
+      if (xhr && xhr.status == 200) {
+        $scope.$input = "";
+        refresh();
+      }
+    });
+  };
+});
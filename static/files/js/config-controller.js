@@ -1,11 +1,12 @@
 /* globals app,window */
 
-app.controller("ConfigController", function ($scope, $rootScope, api) {
+app.controller("ConfigController", function ($scope, $rootScope, $timeout, api, apiget) {
   $rootScope.config = $scope;
   $scope.configObj = {};
   $scope.edit = false;
   $scope.configOrderdKey = [
     "AutoStart",
+    "DefaultAddState",
     "EnableSeeding",
     "EnableUpload",
     "DisableTrackers",
@@ -15,11 +16,13 @@ app.controller("ConfigController", function ($scope, $rootScope, api) {
     "DownloadRate",
     "TrackerList",
     "AlwaysAddTrackers",
+    "SkipPrivateTorrents",
     "RssURL"
   ];
 
   $scope.configAttr = {
     "AutoStart": { t: "check", desc: "Whether to start task when added." },
+    "DefaultAddState": { t: "text", desc: "The state a task is added in, \"started\" or \"paused\" overriding AutoStart, or by source eg: \"watch=paused, web=started\". Empty leaves it to AutoStart." },
     "EnableSeeding": { t: "check", desc: "Upload even after there's nothing in it for us." },
     "EnableUpload": { t: "check", desc: "Upload data we have." },
     "DisableTrackers": { t: "check", desc: "Don't announce to trackers. This only leaves DHT to discover peers." },
@@ -27,20 +30,53 @@ app.controller("ConfigController", function ($scope, $rootScope, api) {
     "SeedRatio": { t: "number", desc: "The ratio of task Upload/Download data when reached, the task will be stopped." },
     "UploadRate": { t: "text", desc: "Upload speed limiter, Low(~50k/s), Medium(~500k/s) and High(~1500k/s) is accepted , Unlimited / 0 or empty result in unlimited rate, or a customed value eg: 850k/720kb/2.85MB. " },
     "DownloadRate": { t: "text", desc: "Download speed limiter, Low(~50k/s), Medium(~500k/s) and High(~1500k/s) is accepted , Unlimited / 0 or empty result in unlimited rate, or a customed value eg: 850k/720kb/2.85MB. " },
-    "TrackerList": { t: "multiline", desc: "A list of trackers to add to torrents, prefix with \"remote:\" will be retrived with http." },
+    "TrackerList": { t: "multiline", desc: "A list of trackers to add to torrents, prefix with \"remote:\" will be retrived with http, prefix with \"file:\" will be read from local file." },
     "AlwaysAddTrackers": { t: "check", desc: "Whether add trackers even there are trackers specified in the torrent/magnet" },
+    "SkipPrivateTorrents": { t: "check", desc: "Don't add trackers to torrents flagged private" },
     "RssURL": { t: "multiline", desc: "A newline seperated list of magnet RSS feeds. (http/https)" }
   };
 
+  // the speed test polled while running
+  $scope.speedTest = null;
+  $scope.loadSpeedTest = function () {
+    apiget.speedtest().then(function (xhr) {
+      $scope.speedTest = xhr.data;
+      if ($scope.speedTest.Running && $scope.edit) {
+        $timeout($scope.loadSpeedTest, 2000);
+      }
+    });
+  };
+  $scope.runSpeedTest = function (cmd) {
+    api.speedtest(cmd).then($scope.loadSpeedTest);
+  };
+  $scope.$watch("edit", function (edit) {
+    if (edit) {
+      $scope.loadSpeedTest();
+    }
+  });
+
   $scope.toggle = function (b) {
     $scope.edit = b === undefined ? !$scope.edit : b;
   };
   $scope.submitConfig = function () {
     var data = JSON.stringify($scope.configObj);
-    api.configure(data).then(function (xhr) {
-      $rootScope.info = `${xhr.data}: Config Saved`;
-    }).finally(function () {
-      $scope.edit = false;
+    api.validate(data).then(function (xhr) {
+      var check = xhr.data;
+      if (!check.Valid) {
+        var errs = (check.Errors || []).map(function (e) {
+          return `${e.Field}: ${e.Error}`;
+        });
+        if ((check.Effects || []).indexOf("ForbidRuntimeChange") >= 0) {
+          errs.push("DoneCmd/DoneCmdRoutes/Plugins/TranscodeFFmpeg/TranscodeHWAccel can't be changed on runtime");
+        }
+        $rootScope.err = errs.join("; ");
+        return;
+      }
+      return api.configure(data).then(function (xhr) {
+        var restart = (check.Effects || []).indexOf("NeedEngineReConfig") >= 0;
+        $rootScope.info = `${xhr.data}: Config applied, not saved to the file yet` + (restart ? ", engine restarted" : "");
+        $scope.edit = false;
+      });
     });
   };
 });
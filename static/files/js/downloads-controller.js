@@ -29,7 +29,7 @@ app.controller("DownloadsController", function ($scope, $rootScope, apiget) {
   };
 });
 
-app.controller("NodeController", function ($scope, $rootScope, $http, $timeout, reqerr) {
+app.controller("NodeController", function ($scope, $rootScope, $http, $timeout, $window, reqerr, api) {
   var n = $scope.node;
   $scope.isfile = function () {
     return !n.Children;
@@ -106,6 +106,31 @@ app.controller("NodeController", function ($scope, $rootScope, $http, $timeout,
       });
   };
 
+  // a public link expiring, for someone without the UI access
+  $scope.share = function (node) {
+    var ttl = $window.prompt("Share " + node.Name + " for (eg. 24h, 168h)", "24h");
+    if (ttl === null) return;
+    var password = $window.prompt("Password of the link, empty for none", "");
+    if (password === null) return;
+    api.share({ Path: node.$path, TTL: ttl, Password: password }).then(function (xhr) {
+      if (xhr.status != 200) return;
+      var url = $window.location.origin + $window.location.pathname.replace(/\/[^\/]*$/, "") + xhr.data.URL;
+      $window.prompt("Link, expires " + new Date(xhr.data.ExpiresAt).toLocaleString(), url);
+    });
+  };
+
+  // seeded as a new torrent, listed in the feed of the published ones
+  $scope.publish = function (node) {
+    if (!$window.confirm("Create a torrent of " + node.Name + " and seed it?")) return;
+    $scope.publishing = true;
+    api.publish(node.$path).then(function (xhr) {
+      if (xhr.status != 200) return;
+      $window.prompt("Published, the magnet", xhr.data.Magnet);
+    }).finally(function () {
+      $scope.publishing = false;
+    });
+  };
+
   $scope.togglePreview = function () {
     $scope.showPreview = !$scope.showPreview;
   };
@@ -1,4 +1,4 @@
-/* globals app,window */
+/* globals app,window,moment */
 app.controller("OmniController", function (
   $scope,
   $rootScope,
@@ -100,7 +100,7 @@ app.controller("OmniController", function (
 
     if (/^https?:\/\//.test($scope.inputs.omni)) parseTorrent();
     else if (/^magnet:\?(.+)$/.test($scope.inputs.omni)) parseMagnet(RegExp.$1);
-    else if ($scope.inputs.omni) parseSearch();
+    else if ($scope.inputs.omni && !$rootScope.state.SearchDisabled) parseSearch();
     else $scope.edit = false;
   };
   $scope.parse();
@@ -163,17 +163,122 @@ app.controller("OmniController", function (
     }
   };
 
+  var addQuery = function () {
+    // added stopped, to select the files before any transfer
+    var params = [];
+    if ($scope.inputs.paused) params.push("paused=1");
+    // or stopped until the time scheduled
+    if ($scope.inputs.startAt) params.push("start_at=" + encodeURIComponent(moment($scope.inputs.startAt).format()));
+    // or queued until another task completes
+    if ($scope.inputs.after) params.push("after=" + $scope.inputs.after);
+    // the ExcludeFiles skipped
+    if ($scope.inputs.keepAll) params.push("exclude=0");
+    return params.join("&");
+  };
+
   $scope.submitTorrent = function () {
+    var query = addQuery();
     if ($scope.mode.torrent) {
-      api.url($scope.inputs.omni).then(reqinfo);
+      api.url($scope.inputs.omni, query).then(reqinfo);
     } else if ($scope.mode.magnet) {
-      api.magnet($scope.inputs.omni).then(reqinfo);
+      api.magnet($scope.inputs.omni, query).then(reqinfo);
     } else {
       window.alert("UI Bug");
     }
     $rootScope.set_torrent_expanded(true);
   };
 
+  $scope.inspect = function () {
+    $scope.inspection = null;
+    api.inspect($scope.inputs.omni).then(function (xhr) {
+      if (xhr.status == 200) $scope.inspection = xhr.data;
+    });
+  };
+
+  // the add dialog: the files listed, the metadata of magnets resolved first
+  $scope.preview = function () {
+    $scope.previewing = { resolving: true, infohash: $scope.mode.magnet ? ($scope.magnet.infohash || "").toLowerCase() : "" };
+    api.preview($scope.inputs.omni).then(function (xhr) {
+      if (xhr.status != 200) {
+        $scope.previewing = null;
+        return;
+      }
+      var ins = xhr.data;
+      $scope.previewing = {
+        ins: ins,
+        infohash: ins.InfoHash,
+        dir: "",
+        files: (ins.Files || []).map(function (f) {
+          return { Path: f.Path, Size: f.Size, Excluded: f.Excluded, selected: !f.Excluded };
+        })
+      };
+    });
+  };
+
+  $scope.previewSelectAll = function (selected) {
+    $scope.previewing.files.forEach(function (f) {
+      f.selected = selected;
+    });
+  };
+
+  $scope.previewSize = function () {
+    return $scope.previewing.files.reduce(function (sum, f) {
+      return f.selected ? sum + f.Size : sum;
+    }, 0);
+  };
+
+  $scope.previewAdd = function () {
+    var p = $scope.previewing;
+    var sel = {
+      Dir: p.dir,
+      Files: p.files.filter(function (f) {
+        return f.selected;
+      }).map(function (f) {
+        return f.Path;
+      })
+    };
+    if (p.files.length && !sel.Files.length) {
+      window.alert("No file selected");
+      return;
+    }
+    api.previewadd(p.infohash, sel, addQuery()).then(function (xhr) {
+      if (xhr.status == 200) {
+        $scope.previewing = null;
+        $rootScope.set_torrent_expanded(true);
+      }
+      reqinfo(xhr);
+    });
+  };
+
+  $scope.previewCancel = function () {
+    var p = $scope.previewing;
+    $scope.previewing = null;
+    if (p && p.infohash) api.previewcancel(p.infohash);
+  };
+
+  //how the providers did on the last queries
+  $scope.providerHealth = {};
+  var loadProviderHealth = function () {
+    search.providerHealth().then(function (xhr) {
+      var health = {};
+      (xhr.data || []).forEach(function (h) {
+        health[h.Provider] = h;
+      });
+      $scope.providerHealth = health;
+    });
+  };
+  loadProviderHealth();
+
+  $scope.testProvider = function () {
+    var id = $scope.inputs.provider;
+    $scope.providerTest = { testing: true };
+    search.test(id).then(function (xhr) {
+      $scope.providerTest = xhr.data;
+    }, function (err) {
+      $scope.providerTest = { Error: err.data || "Test failed" };
+    });
+  };
+
   $scope.submitSearch = function () {
     //lookup provider's origin
     var provider = $scope.SearchProvidersConfig[$scope.inputs.provider];
@@ -210,7 +315,33 @@ app.controller("OmniController", function (
           $scope.results.push(r);
         }
         $scope.page++;
+        checkHealth(results);
+      })
+      .finally(loadProviderHealth);
+  };
+
+  //scrape the swarms of the results having an infohash
+  var checkHealth = function (results) {
+    var byHash = {};
+    var trackers = [];
+    results.forEach(function (r) {
+      var ih = r.infohash;
+      if (!ih && r.magnet && /xt=urn:btih:([0-9a-fA-F]{40})/.test(r.magnet)) {
+        ih = RegExp.$1;
+      }
+      if (!ih || ih.length != 40) return;
+      byHash[ih.toLowerCase()] = r;
+      parseTrackers(r).forEach(function (t) {
+        if (trackers.indexOf(t.v) < 0) trackers.push(t.v);
+      });
+    });
+    var ihs = Object.keys(byHash);
+    if (ihs.length === 0) return;
+    search.health(ihs, trackers).then(function (xhr) {
+      angular.forEach(xhr.data, function (h, ih) {
+        if (byHash[ih] && h.Trackers > 0) byHash[ih].health = h;
       });
+    });
   };
 
   $scope.submitTorrentItem = function (result) {
@@ -234,21 +365,20 @@ app.controller("OmniController", function (
     //else, look it up via url path
     if (!result.path) return ($scope.omnierr = "No item URL found");
 
-    search.one($scope.inputs.provider, result.path).then(
+    search.resolve($scope.inputs.provider, result.path, result.name).then(
       function (resp) {
         var data = resp.data;
         if (!data) return ($scope.omnierr = "No response");
-        if (data.torrent) return api.url(data.torrent);
-        var magnet;
+        //kept for the next click
         if (data.magnet) {
-          magnet = data.magnet;
-        } else if (data.infohash) {
-          magnet = magnetURI(result.name, data.infohash, parseTrackers(result));
+          result.magnet = data.magnet;
+          api.magnet(data.magnet).then(reqinfo);
+        } else if (data.torrent) {
+          result.torrent = data.torrent;
+          api.url(data.torrent).then(reqinfo);
         } else {
-          $scope.omnierr = "No magnet or infohash found";
-          return;
+          $scope.omnierr = "No magnet or torrent found";
         }
-        api.magnet(magnet).then(reqinfo);;
       },
       function (err) {
         $scope.omnierr = err;
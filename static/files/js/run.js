@@ -1,7 +1,7 @@
 /* globals app,window */
 
 //RootController
-app.run(function ($rootScope, $window, $location, $log, search, api, apiget, storage, reqinfo, reqerr) {
+app.run(function ($rootScope, $window, $location, $log, search, api, apiget, storage, uploader, reqinfo, reqerr) {
   var $scope = (window.scope = $rootScope);
 
   // register as "magnet:" protocol handler
@@ -95,27 +95,41 @@ app.run(function ($rootScope, $window, $location, $log, search, api, apiget, sto
     return moment().add(etaSec, 'seconds').fromNow();
   };
 
+  // the torrent files are added as tasks, the other files are uploaded to a
+  // folder of the downloads
   $scope.uploadTorrent = function (event) {
     var fileContainer = event.dataTransfer || event.target;
     if (!fileContainer || !fileContainer.files) {
       return $rootScope.alertErr("Invalid file event");
     }
-    var filter = Array.prototype.filter;
-    var files = filter.call(fileContainer.files, function (file) {
-      return file.name.endsWith(".torrent");
-    });
+    var files = Array.prototype.slice.call(fileContainer.files);
     if (files.length === 0) {
-      return $rootScope.alertErr("No torrent files to upload");
+      return $rootScope.alertErr("No files to upload");
+    }
+    var data = files.filter(function (file) {
+      return !file.name.endsWith(".torrent");
+    });
+    var dir = "";
+    if (data.length > 0) {
+      dir = $window.prompt("Upload " + data.length + " files to the folder of the downloads (empty for the top)", "");
+      if (dir === null) data = [];
     }
     files.forEach(function (file) {
-      var reader = new FileReader();
-      reader.readAsArrayBuffer(file);
-      reader.onload = function () {
-        var data = new Uint8Array(reader.result);
-        api.torrentfile(data).then(reqinfo, reqerr);
-      };
+      if (file.name.endsWith(".torrent")) {
+        uploader.upload(file, "", true);
+      } else if (data.indexOf(file) >= 0) {
+        uploader.upload(file, dir);
+      }
+    });
+  };
+
+  $scope.uploadTo = function (event, dir) {
+    var fileContainer = event.dataTransfer || event.target;
+    Array.prototype.forEach.call(fileContainer.files || [], function (file) {
+      uploader.upload(file, dir);
     });
   };
+  $scope.cancelUpload = uploader.cancel;
 
   $scope.alertErr = function (errMsg) {
     $scope.err = errMsg;
@@ -154,6 +168,38 @@ app.run(function ($rootScope, $window, $location, $log, search, api, apiget, sto
     }
   }
 
+  $scope.setMaintenance = function (on) {
+    var reason = "";
+    if (on) {
+      reason = window.prompt("Pause all tasks for maintenance, reason (optional):", "");
+      if (reason === null) return;
+    }
+    api.maintenance((on ? "on" : "off") + (reason ? ":" + reason : "")).then(reqinfo, reqerr);
+  }
+
+  $scope.saveConfig = function () {
+    api.saveconfig().then(reqinfo, reqerr);
+  }
+
+  $scope.checkUpdate = function () {
+    apiget.update().then(function (xhr) {
+      var rel = xhr.data;
+      if (!rel.Newer) {
+        $rootScope.info = `ver ${rel.Current} is the latest`;
+        return;
+      }
+      if (!rel.Asset) {
+        $rootScope.info = `ver ${rel.Version} is available, no binary for this platform`;
+        return;
+      }
+      if (window.confirm(`Update to ${rel.Version} and restart now?\n\n${rel.Notes}`)) {
+        api.update("").then(function () {
+          $rootScope.info = `Updated to ${rel.Version}, restarting`;
+        });
+      }
+    });
+  }
+
   $scope.toggleWebsocket = function () {
     storage.veloxCON = (storage.veloxCON !== "ws") ? "ws" : "sse";
     switch (storage.veloxCON) {
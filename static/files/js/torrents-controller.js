@@ -1,6 +1,6 @@
-/* globals app */
+/* globals app,moment */
 
-app.controller("TorrentsController", function ($scope, $rootScope, api, reqinfo, reqerr) {
+app.controller("TorrentsController", function ($scope, $rootScope, $http, api, reqinfo, reqerr) {
   $rootScope.torrents = $scope;
 
   $scope.submitTorrent = function (action, t) {
@@ -15,6 +15,47 @@ app.controller("TorrentsController", function ($scope, $rootScope, api, reqinfo,
     api.file([action, t.InfoHash, f.Path].join(":")).then(reqinfo, reqerr);
   };
 
+  $scope.saveNotes = function (t, notes) {
+    api.meta(t.InfoHash, { Notes: notes }).then(function () {
+      t.$editNotes = false;
+    });
+  };
+
+  // renames the task, or a file of it in place
+  $scope.rename = function (t, f) {
+    var current = f ? f.Path.split("/").pop() : t.Name;
+    var name = window.prompt("Rename to", current);
+    if (name === null || name === current) return;
+    api.rename(t.InfoHash, { Path: f ? f.Path : "", Name: name }).then(reqinfo, reqerr);
+  };
+
+  // schedules the task to start, an empty input cancels
+  $scope.schedule = function (t) {
+    var current = t.ScheduledStart ? moment(t.ScheduledStart).format("YYYY-MM-DD HH:mm") : "";
+    var input = window.prompt("Start at (YYYY-MM-DD HH:mm), empty to cancel", current);
+    if (input === null) return;
+    var at = "";
+    if (input.trim()) {
+      var m = moment(input, "YYYY-MM-DD HH:mm", true);
+      if (!m.isValid()) {
+        window.alert("Invalid time: " + input);
+        return;
+      }
+      at = m.format();
+    }
+    api.schedule(t.InfoHash, at).then(reqinfo, reqerr);
+  };
+
+  $scope.addWebSeed = function (t) {
+    var url = window.prompt("Web seed url (http mirror of the task data)");
+    if (!url) return;
+    api.webseed(t.InfoHash, url).then(reqinfo, reqerr);
+  };
+
+  $scope.failedAdd = function (cmd, f) {
+    api.failed(cmd, f.ID).then(reqinfo);
+  };
+
   $scope.downloading = function (f) {
     return f.Completed > 0 && f.Completed < f.Size;
   };
@@ -34,6 +75,12 @@ app.controller("TorrentsController", function ($scope, $rootScope, api, reqinfo,
     } else {
       item.$showMode = showMode;
       item.$detailTitle = tagTitle;
+      if (showMode === "Peers") {
+        // the scrape history is kept out of the pushed state
+        $http.get("api/swarm/" + item.InfoHash).then(function (xhr) {
+          item.$swarmHistory = xhr.data;
+        }, reqerr);
+      }
     }
     return false;
   };
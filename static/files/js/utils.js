@@ -36,8 +36,8 @@ app.factory("reqinfo", function ($rootScope) {
 });
 
 app.factory("api", function ($rootScope, $http, reqerr) {
-  var request = function (action, data) {
-    var url = "api/" + action;
+  var request = function (action, data, query) {
+    var url = "api/" + action + (query ? "?" + query : "");
     $rootScope.apiing = true;
     $rootScope.$applyAsync();
     var req = $http.post(url, data, {
@@ -47,7 +47,14 @@ app.factory("api", function ($rootScope, $http, reqerr) {
         console.log(`API ${url}->${xhr.data}`);
         return xhr;
       })
-      .catch(reqerr)
+      .catch(function (xhr) {
+        // looks like a task already added, ask before adding anyway
+        if (xhr.status == 409 && xhr.data && xhr.data.duplicate &&
+          window.confirm(`${xhr.data.error}\nAdd anyway?`)) {
+          return $http.post(url + (query ? "&" : "?") + "force=1", data, { transformRequest: [] }).catch(reqerr);
+        }
+        return reqerr(xhr);
+      })
       .finally(function () {
         $rootScope.apiing = false;
       });
@@ -60,11 +67,50 @@ app.factory("api", function ($rootScope, $http, reqerr) {
     "url",
     "torrent",
     "file",
-    "torrentfile"
+    "torrentfile",
+    "maintenance",
+    "speedtest",
+    "update"
   ];
   actions.forEach(function (action) {
     api[action] = request.bind(null, action);
   });
+  api.validate = request.bind(null, "config/validate");
+  api.saveconfig = request.bind(null, "config/save");
+  api.inspect = request.bind(null, "inspect");
+  api.share = function (share) {
+    return request("share", JSON.stringify(share));
+  };
+  api.publish = function (path) {
+    return request("publish", JSON.stringify({ Path: path }));
+  };
+  api.preview = function (input, timeout) {
+    return request("preview", input, timeout ? "timeout=" + timeout : "");
+  };
+  api.previewadd = function (infohash, sel, query) {
+    return request("preview/" + infohash + "/add", JSON.stringify(sel), query);
+  };
+  api.previewcancel = function (infohash) {
+    return request("preview/" + infohash + "/cancel", "");
+  };
+  api.meta = function (infohash, meta) {
+    return request("meta/" + infohash, JSON.stringify(meta));
+  };
+  api.rename = function (infohash, rename) {
+    return request("rename/" + infohash, JSON.stringify(rename));
+  };
+  api.schedule = function (infohash, at) {
+    return request("schedule/" + infohash, at);
+  };
+  api.webseed = function (infohash, url) {
+    return request("webseed/" + infohash, url);
+  };
+  api.failed = function (cmd, id) {
+    return request("failed/" + cmd + "/" + id, "");
+  };
+  api.cluster = function (action, data, node) {
+    return request("cluster/" + action, data, node ? "node=" + encodeURIComponent(node) : "");
+  };
   return api;
 });
 
@@ -89,7 +135,9 @@ app.factory("apiget", function ($rootScope, $http, reqerr) {
     "configure",
     "enginedebug",
     "searchproviders",
-    "files"
+    "files",
+    "speedtest",
+    "update"
   ];
   actions.forEach(function (action) {
     api[action] = request.bind(null, action);
@@ -110,10 +158,20 @@ app.factory("search", function ($rootScope, $http, reqerr) {
         });
       return req;
     },
-    one: function (provider, path) {
-      var opts = { params: { item: path } };
+    health: function (infohashes, trackers) {
+      var opts = { params: { ih: infohashes, tr: trackers } };
+      return $http.get("api/health", opts);
+    },
+    test: function (provider) {
+      return $http.get("api/searchtest", { params: { provider: provider } });
+    },
+    providerHealth: function () {
+      return $http.get("api/searchhealth");
+    },
+    resolve: function (provider, path, name) {
+      var opts = { params: { provider: provider, path: path, name: name } };
       $rootScope.searching = true;
-      var req = $http.get("search/" + provider + "/item", opts)
+      var req = $http.get("api/resolve", opts)
         .catch(reqerr)
         .finally(function () {
           $rootScope.searching = false;
@@ -139,6 +197,80 @@ app.factory("rss", function ($rootScope, $http, reqerr) {
   };
 });
 
+// uploader sends the files by chunks, resuming an interrupted upload of the
+// same name and size from where the server has it
+app.factory("uploader", function ($rootScope, $http, $timeout, reqerr) {
+  var chunkSize = 8 << 20;
+  var retries = 5;
+  var opts = { transformRequest: [], headers: { "Content-Type": "application/octet-stream" } };
+  // the uploads shown with their progress
+  $rootScope.uploads = [];
+
+  var finish = function (up) {
+    up.done = true;
+    $timeout(function () {
+      var i = $rootScope.uploads.indexOf(up);
+      if (i >= 0) $rootScope.uploads.splice(i, 1);
+    }, 3000);
+  };
+
+  var sendChunk = function (up, tries) {
+    if (up.canceled) return;
+    var end = Math.min(up.Offset + chunkSize, up.file.size);
+    $http.post("api/upload/" + up.ID + "?offset=" + up.Offset, up.file.slice(up.Offset, end), opts)
+      .then(function (xhr) {
+        up.Offset = xhr.data.Offset;
+        if (up.Offset >= up.Size) return finish(up);
+        sendChunk(up, 0);
+      }, function (xhr) {
+        if (xhr.status == 409 && xhr.data && "Offset" in xhr.data) {
+          // resumes where the server is
+          up.Offset = xhr.data.Offset;
+          return sendChunk(up, tries);
+        }
+        if (xhr.status <= 0 && tries < retries) {
+          return $timeout(function () {
+            sendChunk(up, tries + 1);
+          }, 2000 * (tries + 1));
+        }
+        up.error = xhr.data || xhr.statusText || "Upload failed";
+        reqerr(xhr);
+      });
+  };
+
+  return {
+    upload: function (file, dir, addTorrent) {
+      var up = { Name: file.name, Size: file.size, Dir: dir || "", AddTorrent: !!addTorrent, Offset: 0, file: file };
+      $rootScope.uploads.push(up);
+      $http.get("api/uploads").then(function (xhr) {
+        var prev = (xhr.data || []).find(function (u) {
+          return u.Name == up.Name && u.Size == up.Size && u.Dir == up.Dir && u.AddTorrent == up.AddTorrent;
+        });
+        if (prev) return { data: prev };
+        var body = JSON.stringify({ Name: up.Name, Size: up.Size, Dir: up.Dir, AddTorrent: up.AddTorrent });
+        return $http.post("api/upload", body, { transformRequest: [] });
+      }).then(function (xhr) {
+        up.ID = xhr.data.ID;
+        up.Offset = xhr.data.Offset;
+        if (up.Offset >= up.Size) return finish(up);
+        sendChunk(up, 0);
+      }, function (xhr) {
+        up.error = xhr.data || "Upload failed";
+        reqerr(xhr);
+      });
+      return up;
+    },
+    cancel: function (up) {
+      up.canceled = true;
+      var i = $rootScope.uploads.indexOf(up);
+      if (i >= 0) $rootScope.uploads.splice(i, 1);
+      if (up.ID && !up.done) {
+        $http.post("api/upload/" + up.ID + "/cancel", "").catch(reqerr);
+      }
+    }
+  };
+});
+
 app.factory("storage", function () {
   return window.localStorage || {};
 });
@@ -198,6 +330,23 @@ app.filter("round", function () {
   };
 });
 
+//seconds as HH:mm:ss, the days ahead as Nd
+app.filter("duration", function () {
+  return function (secs) {
+    if (typeof secs !== "number" || secs < 0) {
+      return secs;
+    }
+    secs = Math.round(secs);
+    var pad = function (n) {
+      return n < 10 ? "0" + n : "" + n;
+    };
+    var days = Math.floor(secs / 86400),
+      hms = pad(Math.floor(secs % 86400 / 3600)) + ":" +
+        pad(Math.floor(secs % 3600 / 60)) + ":" + pad(secs % 60);
+    return days > 0 ? days + "d " + hms : hms;
+  };
+});
+
 app.filter("escape", function () {
   return window.encodeURIComponent;
 });
@@ -1,21 +1,51 @@
 package ctstatic
 
 import (
+	"crypto/sha256"
 	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"io/fs"
 	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
 	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 //go:embed files
 var staticFS embed.FS
 
-var curFS fs.FS
+var (
+	// the resources in use, replaced by a theme
+	mu     sync.RWMutex
+	curFS  fs.FS
+	baseFS fs.FS
+	// fingerprint is the hash of the resources, the path prefix of the assets
+	fingerprint string
+)
+
+// the files a theme must have, the server templates
+var themeRequired = []string{"index.html", "magadded.html"}
+
+var ErrInvalidTheme = errors.New("Invalid theme")
 
 const resourcePath = "static/files"
 
+// the precompressed variants written by scripts/precompress.sh, preferred first
+var encodings = []struct {
+	name, ext string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
 func init() {
 	if info, err := os.Stat(resourcePath); err == nil && info.IsDir() {
 		log.Printf("[static] found %s, using external resources.", resourcePath)
@@ -23,14 +53,136 @@ func init() {
 	} else {
 		curFS, _ = fs.Sub(staticFS, "files")
 	}
+	baseFS = curFS
+	fingerprint = hashFS(curFS)
+}
+
+// Use replaces the resources by an alternative UI bundle, nil restores the
+// built-in ones
+func Use(fsys fs.FS) error {
+	if fsys == nil {
+		fsys = baseFS
+	}
+	for _, name := range themeRequired {
+		if st, err := fs.Stat(fsys, name); err != nil || st.IsDir() {
+			return fmt.Errorf("%w: %s is missing", ErrInvalidTheme, name)
+		}
+	}
+	fp := hashFS(fsys)
+	mu.Lock()
+	curFS, fingerprint = fsys, fp
+	mu.Unlock()
+	return nil
+}
+
+// Builtin is the resources without a theme
+func Builtin() fs.FS {
+	return baseFS
+}
+
+func current() fs.FS {
+	mu.RLock()
+	defer mu.RUnlock()
+	return curFS
+}
+
+// hashFS hashes the names and contents of the files
+func hashFS(fsys fs.FS) string {
+	h := sha256.New()
+	fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		f, err := fsys.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		io.WriteString(h, p)
+		_, err = io.Copy(h, f)
+		return err
+	})
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// Fingerprint changes with the resources, it's the path prefix of the
+// assets cached for long
+func Fingerprint() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return fingerprint
 }
 
 func FileSystemHandler() http.Handler {
-	return http.FileServer(http.FS(curFS))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fsys := current()
+		if !servePrecompressed(fsys, w, r) {
+			http.FileServer(http.FS(fsys)).ServeHTTP(w, r)
+		}
+	})
+}
+
+// servePrecompressed serves the .br or .gz variant of the file if there's
+// one the client accepts
+func servePrecompressed(fsys fs.FS, w http.ResponseWriter, r *http.Request) bool {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" || strings.HasSuffix(r.URL.Path, "/") {
+		return false
+	}
+	for _, enc := range encodings {
+		if !acceptsEncoding(r.Header.Get("Accept-Encoding"), enc.name) {
+			continue
+		}
+		f, err := fsys.Open(name + enc.ext)
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+		st, err := f.Stat()
+		rs, ok := f.(io.ReadSeeker)
+		if err != nil || !ok || st.IsDir() {
+			continue
+		}
+		ctype := mime.TypeByExtension(path.Ext(name))
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", ctype)
+		w.Header().Set("Content-Encoding", enc.name)
+		w.Header().Add("Vary", "Accept-Encoding")
+		http.ServeContent(w, r, name, st.ModTime(), rs)
+		return true
+	}
+	return false
+}
+
+// acceptsEncoding tells if the Accept-Encoding header has the coding, not
+// refused with q=0
+func acceptsEncoding(header, coding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), coding) {
+			continue
+		}
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				q, err := strconv.ParseFloat(p[2:], 64)
+				return err == nil && q > 0
+			}
+		}
+		return true
+	}
+	return false
 }
 
 func ReadAll(name string) ([]byte, error) {
-	f, err := curFS.Open(name)
+	return ReadFrom(current(), name)
+}
+
+// ReadFrom reads a file of fsys, like a theme before it's used
+func ReadFrom(fsys fs.FS, name string) ([]byte, error) {
+	f, err := fsys.Open(name)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,83 @@
+package ctstatic
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header, coding string
+		want           bool
+	}{
+		{"gzip, deflate, br", "br", true},
+		{"gzip;q=1.0, br;q=0", "br", false},
+		{"gzip;q=0.5", "gzip", true},
+		{"GZIP", "gzip", true},
+		{"deflate", "gzip", false},
+		{"", "br", false},
+	}
+	for _, tt := range tests {
+		if got := acceptsEncoding(tt.header, tt.coding); got != tt.want {
+			t.Errorf("acceptsEncoding(%q, %s) = %v, want %v", tt.header, tt.coding, got, tt.want)
+		}
+	}
+}
+
+func TestFileSystemHandler_precompressed(t *testing.T) {
+	defer Use(nil)
+	curFS = fstest.MapFS{
+		"js/app.js":      {Data: []byte("plain")},
+		"js/app.js.gz":   {Data: []byte("gzipped")},
+		"js/app.js.br":   {Data: []byte("brotli")},
+		"css/app.css":    {Data: []byte("plain css")},
+		"css/app.css.gz": {Data: []byte("gzipped css")},
+	}
+	tests := []struct {
+		path, accept, body, encoding string
+	}{
+		{"/js/app.js", "gzip, br", "brotli", "br"},
+		{"/js/app.js", "gzip", "gzipped", "gzip"},
+		{"/js/app.js", "", "plain", ""},
+		{"/css/app.css", "br", "plain css", ""},
+		{"/css/app.css", "gzip, br", "gzipped css", "gzip"},
+	}
+	h := FileSystemHandler()
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.path, nil)
+		r.Header.Set("Accept-Encoding", tt.accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Body.String() != tt.body || w.Header().Get("Content-Encoding") != tt.encoding {
+			t.Errorf("%s (%s) = %q %q, want %q %q", tt.path, tt.accept,
+				w.Body.String(), w.Header().Get("Content-Encoding"), tt.body, tt.encoding)
+		}
+		// by the original name, not the .br or .gz
+		if ct := w.Header().Get("Content-Type"); tt.encoding != "" && (ct == "" || ct == "application/octet-stream") {
+			t.Errorf("%s Content-Type = %s", tt.path, w.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestUse(t *testing.T) {
+	defer Use(nil)
+	base := Fingerprint()
+	if err := Use(fstest.MapFS{"index.html": {Data: []byte("x")}}); !errors.Is(err, ErrInvalidTheme) {
+		t.Errorf("Use() without magadded.html = %v", err)
+	}
+	theme := fstest.MapFS{
+		"index.html":    {Data: []byte("theme")},
+		"magadded.html": {Data: []byte("added")},
+	}
+	if err := Use(theme); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ReadAll("index.html"); string(b) != "theme" || Fingerprint() == base {
+		t.Errorf("theme not used: %q %s", b, Fingerprint())
+	}
+	if err := Use(nil); err != nil || Fingerprint() != base {
+		t.Errorf("Use(nil) = %v, fingerprint %s, want %s", err, Fingerprint(), base)
+	}
+}
@@ -0,0 +1,42 @@
+package update
+
+import (
+	"fmt"
+)
+
+// Command is the `update` subcommand, installs the latest release over the
+// binary. The running instance (or service) picks it up when restarted.
+//
+//	cloud-torrent update [--check]
+type Command struct {
+	Check bool `opts:"help=Only check whether a newer release is available"`
+
+	version string
+}
+
+// NewCommand is the `update` subcommand of the program's version
+func NewCommand(version string) *Command {
+	return &Command{version: version}
+}
+
+func (c *Command) Run() error {
+	rel, err := Check(c.version)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("current %s, latest %s (%s)\n", c.version, rel.Version, rel.PublishedAt.Format("2006-01-02"))
+	if !rel.Newer {
+		fmt.Println(ErrUpToDate)
+		return nil
+	}
+	if c.Check {
+		fmt.Println("run `cloud-torrent update` to install", rel.Asset)
+		return nil
+	}
+	exe, err := Apply(rel)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s updated to %s from %s, restart the running instance to take effect\n", exe, rel.Version, rel.Asset)
+	return nil
+}
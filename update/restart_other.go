@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package update
+
+import (
+	"os"
+	"syscall"
+)
+
+// swapExecutable renames tmp over exe, the running process keeps its inode
+func swapExecutable(tmp, exe string) error {
+	return os.Rename(tmp, exe)
+}
+
+// Restart replaces the current process by exe with the same arguments and
+// environment after cleanup, the pid (and so the systemd unit) is kept.
+func Restart(exe string, cleanup func()) error {
+	cleanup()
+	return syscall.Exec(exe, os.Args, os.Environ())
+}
@@ -0,0 +1,32 @@
+//go:build windows
+// +build windows
+
+package update
+
+import (
+	"errors"
+	"os"
+)
+
+var errRestartUnsupported = errors.New("Updated, restart the program to run the new version")
+
+// swapExecutable moves the running exe aside, windows can't replace it but
+// allows the rename. The old one is removed by the next update.
+func swapExecutable(tmp, exe string) error {
+	old := exe + ".old"
+	os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, exe); err != nil {
+		_ = os.Rename(old, exe)
+		return err
+	}
+	return nil
+}
+
+// Restart isn't done in process on windows, the service manager or the
+// user starts the new exe
+func Restart(exe string, cleanup func()) error {
+	return errRestartUnsupported
+}
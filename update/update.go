@@ -0,0 +1,291 @@
+// Package update checks the latest release on GitHub and replaces the
+// running binary by it, for the bare binary installations. The release
+// binary is verified by its published sha256 checksum, and by its ed25519
+// signature if the program is built with a PublicKey.
+package update
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	releaseAPI   = "https://api.github.com/repos/boypt/simple-torrent/releases/latest"
+	binName      = "cloud-torrent"
+	maxBinaryLen = 256 << 20
+	fetchTimeout = 5 * time.Minute
+)
+
+// PublicKey is the base64 ed25519 key the release binaries are signed with,
+// set with ldflags. When set, a binary without a valid signature (the
+// <asset>.sig) is refused.
+var PublicKey = ""
+
+var (
+	ErrNoAsset     = errors.New("No release binary for this platform")
+	ErrNoChecksum  = errors.New("No checksum published for the release binary")
+	ErrChecksum    = errors.New("Checksum mismatch of the downloaded binary")
+	ErrNoSignature = errors.New("No valid signature of the release binary")
+	ErrUpToDate    = errors.New("Already the latest version")
+)
+
+// Release is the latest release, with the binary for this platform
+type Release struct {
+	Current      string
+	Version      string
+	Newer        bool
+	PublishedAt  time.Time
+	Notes        string
+	Asset        string
+	URL          string `json:"-"`
+	ChecksumURL  string `json:"-"`
+	SignatureURL string `json:"-"`
+}
+
+type githubRelease struct {
+	TagName     string    `json:"tag_name"`
+	Body        string    `json:"body"`
+	PublishedAt time.Time `json:"published_at"`
+	Assets      []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+var client = &http.Client{Timeout: fetchTimeout}
+
+// Check looks up the latest release and whether it's newer than current
+func Check(current string) (*Release, error) {
+	req, err := http.NewRequest(http.MethodGet, releaseAPI, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", releaseAPI, resp.Status)
+	}
+	var gr githubRelease
+	if err := json.NewDecoder(resp.Body).Decode(&gr); err != nil {
+		return nil, err
+	}
+
+	rel := &Release{
+		Current:     current,
+		Version:     gr.TagName,
+		Newer:       newerVersion(gr.TagName, current),
+		PublishedAt: gr.PublishedAt,
+		Notes:       gr.Body,
+	}
+	urls := make(map[string]string)
+	for _, a := range gr.Assets {
+		urls[a.Name] = a.URL
+		if rank := assetRank(a.Name); rank > assetRank(rel.Asset) {
+			rel.Asset, rel.URL = a.Name, a.URL
+		}
+	}
+	if rel.Asset == "" {
+		return rel, nil
+	}
+	rel.ChecksumURL = urls[rel.Asset+".sha256"]
+	rel.SignatureURL = urls[rel.Asset+".sig"]
+	return rel, nil
+}
+
+// assetRank is how much a release asset fits this platform, 0 if it's not
+// for this platform. The static builds run everywhere, so are preferred.
+func assetRank(name string) int {
+	if strings.HasSuffix(name, ".xz") {
+		// not supported
+		return 0
+	}
+	n := strings.TrimSuffix(name, ".gz")
+	if runtime.GOOS == "windows" {
+		n = strings.TrimSuffix(n, ".exe")
+	}
+	prefix := fmt.Sprintf("%s_%s_%s", binName, runtime.GOOS, runtime.GOARCH)
+	switch n {
+	case prefix:
+		return 1
+	case prefix + "_static":
+		return 2
+	}
+	return 0
+}
+
+// newerVersion compares the x.y.z of the tags, a build from git
+// (1.2.3-4-gabcdef) counts as its tag
+func newerVersion(latest, current string) bool {
+	l, c := parseVersion(latest), parseVersion(current)
+	for i := range l {
+		if l[i] != c[i] {
+			return l[i] > c[i]
+		}
+	}
+	return false
+}
+
+func parseVersion(v string) (n [3]int) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	for i, p := range strings.SplitN(v, ".", 3) {
+		n[i], _ = strconv.Atoi(p)
+	}
+	return
+}
+
+// Apply downloads and verifies the release binary, then swaps it with the
+// running executable, whose path is returned. The new binary takes effect
+// by a restart.
+func Apply(rel *Release) (string, error) {
+	if rel.URL == "" {
+		return "", ErrNoAsset
+	}
+	if rel.ChecksumURL == "" {
+		return "", ErrNoChecksum
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return "", err
+	}
+
+	data, err := fetch(rel.URL, maxBinaryLen)
+	if err != nil {
+		return "", err
+	}
+	sum, err := fetch(rel.ChecksumURL, 4096)
+	if err != nil {
+		return "", err
+	}
+	if err := verifyChecksum(data, sum, rel.Asset); err != nil {
+		return "", err
+	}
+	if PublicKey != "" {
+		if rel.SignatureURL == "" {
+			return "", ErrNoSignature
+		}
+		sig, err := fetch(rel.SignatureURL, 4096)
+		if err != nil {
+			return "", err
+		}
+		if err := verifySignature(data, sig); err != nil {
+			return "", err
+		}
+	}
+
+	if strings.HasSuffix(rel.Asset, ".gz") {
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return "", err
+		}
+		if data, err = ioutil.ReadAll(io.LimitReader(gr, maxBinaryLen)); err != nil {
+			return "", err
+		}
+	}
+	return exe, replaceExecutable(exe, data)
+}
+
+func fetch(u string, limit int64) ([]byte, error) {
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, limit))
+}
+
+// verifyChecksum checks data against a sha256sum output, either the bare
+// hash or the line of the asset
+func verifyChecksum(data, sums []byte, asset string) error {
+	want := ""
+	sc := bufio.NewScanner(bytes.NewReader(sums))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) == 1 || strings.TrimPrefix(fields[1], "*") == asset {
+			want = fields[0]
+			break
+		}
+	}
+	if want == "" {
+		return ErrNoChecksum
+	}
+	got := sha256.Sum256(data)
+	if !strings.EqualFold(want, hex.EncodeToString(got[:])) {
+		return ErrChecksum
+	}
+	return nil
+}
+
+func verifySignature(data, sig []byte) error {
+	key, err := base64.StdEncoding.DecodeString(PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid PublicKey: %v", err)
+	}
+	s, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(key), data, s) {
+		return ErrNoSignature
+	}
+	return nil
+}
+
+// replaceExecutable writes the new binary next to exe and renames it over
+// exe, so the swap is atomic and a failed write leaves exe untouched
+func replaceExecutable(exe string, data []byte) error {
+	fi, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+	tmp := exe + ".new"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := swapExecutable(tmp, exe); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}